@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
 	"log"
 	"net/http"
@@ -17,30 +16,46 @@ import (
 )
 
 func main() {
-	// Database path (SQLite for MVP). Uses local file aqe.sqlite.
-	dbPath := os.Getenv("AQE_DB_PATH")
+	// A postgres:// URL in AQE_DATABASE_URL selects PostgreSQL (build with
+	// -tags postgres); otherwise the SQLite file at AQE_DB_PATH is used.
+	dbPath := os.Getenv("AQE_DATABASE_URL")
+	if dbPath == "" {
+		dbPath = os.Getenv("AQE_DB_PATH")
+	}
 	if dbPath == "" {
 		dbPath = "aqe.sqlite"
 	}
 
-	log.Printf("Using database path: %s", dbPath)
-
-	db, err := sql.Open("sqlite", dbPath)
+	db, err := storage.Open(dbPath)
 	if err != nil {
-		log.Fatalf("failed to open sqlite db: %v", err)
+		log.Fatalf("failed to open db: %v", err)
 	}
 	defer db.Close()
+	if err := db.Ping(); err != nil {
+		log.Fatalf("failed to connect to db: %v", err)
+	}
+	log.Printf("Using %s database", storage.ActiveDialect().Name())
 
-	// Pragmas for better performance
-	db.Exec("PRAGMA journal_mode=WAL;")
-	db.Exec("PRAGMA synchronous=NORMAL;")
+	if storage.ActiveDialect().Name() == "sqlite" {
+		log.Printf("Using database path: %s", dbPath)
+		// Pragmas for better performance
+		db.Exec("PRAGMA journal_mode=WAL;")
+		db.Exec("PRAGMA synchronous=NORMAL;")
+	}
 
 	if err := storage.EnsureMetaTables(context.Background(), db); err != nil {
 		log.Fatalf("failed to ensure meta tables: %v", err)
 	}
 
+	// user queries run on a separate read-only handle
+	readDB, err := storage.OpenReadOnly(dbPath)
+	if err != nil {
+		log.Fatalf("failed to open read-only db: %v", err)
+	}
+	defer readDB.Close()
+
 	r := mux.NewRouter()
-	api.RegisterRoutes(r, db)
+	api.RegisterRoutes(r, db, readDB)
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -0,0 +1,9 @@
+//go:build postgres
+
+package main
+
+// PostgreSQL support needs pgx, which go.mod requires but the default
+// build leaves out:
+//
+//	go build -tags postgres ./cmd/aqe-server
+import _ "github.com/jackc/pgx/v5/stdlib"
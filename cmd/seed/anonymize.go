@@ -0,0 +1,244 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	mrand "math/rand"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/sketches"
+)
+
+// anonymizer rewrites the values of a copied table's sensitive columns.
+type anonymizer struct {
+	// hash and perturb are keyed by lower-cased "table.column" or, for
+	// every table, "column"; perturb holds each column's relative bound.
+	hash    map[string]bool
+	perturb map[string]float64
+	key     []byte
+}
+
+// newAnonymizer reads the import settings: AQE_IMPORT_HASH lists the
+// columns to replace by keyed hashes and AQE_IMPORT_PERTURB the numeric
+// columns to perturb, as column=bound, each a column or table.column.
+// AQE_IMPORT_SALT keys the hashes; without it a random key is drawn, so
+// hashes don't match across imports.
+func newAnonymizer() (*anonymizer, error) {
+	a := &anonymizer{hash: make(map[string]bool), perturb: make(map[string]float64)}
+	for _, col := range splitList(os.Getenv("AQE_IMPORT_HASH")) {
+		a.hash[strings.ToLower(col)] = true
+	}
+	for _, entry := range splitList(os.Getenv("AQE_IMPORT_PERTURB")) {
+		col, bound, ok := strings.Cut(entry, "=")
+		b, err := strconv.ParseFloat(strings.TrimSpace(bound), 64)
+		if !ok || err != nil || b <= 0 || b >= 1 {
+			return nil, fmt.Errorf("AQE_IMPORT_PERTURB: %q is not column=bound with 0<bound<1", entry)
+		}
+		a.perturb[strings.ToLower(strings.TrimSpace(col))] = b
+	}
+	if salt := os.Getenv("AQE_IMPORT_SALT"); salt != "" {
+		a.key = []byte(salt)
+	} else {
+		a.key = make([]byte, 32)
+		if _, err := rand.Read(a.key); err != nil {
+			return nil, err
+		}
+		log.Println("AQE_IMPORT_SALT not set: hashed identifiers won't match other imports")
+	}
+	return a, nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// rule returns how to rewrite table.column: hashed, or perturbed within
+// bound, or neither.
+func (a *anonymizer) rule(table, column string) (hashed bool, bound float64) {
+	qualified := strings.ToLower(table + "." + column)
+	bare := strings.ToLower(column)
+	hashed = a.hash[qualified] || a.hash[bare]
+	if b, ok := a.perturb[qualified]; ok {
+		bound = b
+	} else {
+		bound = a.perturb[bare]
+	}
+	return hashed, bound
+}
+
+// hashValue replaces an identifier by its keyed hash. Values that read as
+// the same integer (see sketches.ValueKey) hash alike, so joins on hashed
+// keys still match; integers hash to non-negative integers below 2^53 and
+// other values to hex text. NULL stays NULL.
+func (a *anonymizer) hashValue(v any) any {
+	key, ok := sketches.ValueKey(v)
+	if !ok {
+		return nil
+	}
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write(key)
+	sum := mac.Sum(nil)
+	if _, err := strconv.ParseInt(string(key), 10, 64); err == nil {
+		return int64(binary.BigEndian.Uint64(sum) >> 11)
+	}
+	return hex.EncodeToString(sum[:12])
+}
+
+// perturbValue scales a number by a random factor within 1±bound,
+// keeping integers integral; other values are copied as they are.
+func perturbValue(v any, bound float64) any {
+	factor := 1 + bound*(2*mrand.Float64()-1)
+	switch n := v.(type) {
+	case int64:
+		return int64(math.Round(float64(n) * factor))
+	case float64:
+		return n * factor
+	}
+	return v
+}
+
+// importTables copies each of tables from the SQLite database at src into
+// db, replacing a table of the same name, with the columns named by a
+// hashed or perturbed.
+func importTables(db *sql.DB, src string, tables []string, a *anonymizer) error {
+	from, err := sql.Open("sqlite", "file:"+src+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer from.Close()
+
+	// a misspelled column would be copied in the clear, so check them all
+	// before replacing any table
+	columns := make([][]string, len(tables))
+	used := make(map[string]bool)
+	for i, table := range tables {
+		if columns[i], err = sourceColumns(from, table); err != nil {
+			return fmt.Errorf("%s: %w", table, err)
+		}
+		for _, c := range columns[i] {
+			used[strings.ToLower(c)] = true
+			used[strings.ToLower(table+"."+c)] = true
+		}
+	}
+	for col := range a.hash {
+		if !used[col] {
+			return fmt.Errorf("AQE_IMPORT_HASH names %s, which no imported table has", col)
+		}
+	}
+	for col := range a.perturb {
+		if !used[col] {
+			return fmt.Errorf("AQE_IMPORT_PERTURB names %s, which no imported table has", col)
+		}
+	}
+
+	for i, table := range tables {
+		if err := importTable(db, from, table, columns[i], a); err != nil {
+			return fmt.Errorf("%s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// sourceColumns returns table's columns in src.
+func sourceColumns(src *sql.DB, table string) ([]string, error) {
+	rows, err := src.Query(fmt.Sprintf(`SELECT * FROM "%s" LIMIT 0`, table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return rows.Columns()
+}
+
+// importTable copies table, with columns cols, from src to db.
+func importTable(db, src *sql.DB, table string, cols []string, a *anonymizer) error {
+	var ddl string
+	if err := src.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&ddl); err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("no such table in the source database")
+		}
+		return err
+	}
+
+	quoted := make([]string, len(cols))
+	plain := make([]string, len(cols)) // unary + keeps dates as stored text
+	hashed := make([]bool, len(cols))
+	bounds := make([]float64, len(cols))
+	for i, c := range cols {
+		quoted[i] = `"` + strings.ReplaceAll(c, `"`, `""`) + `"`
+		plain[i] = "+" + quoted[i]
+		hashed[i], bounds[i] = a.rule(table, c)
+	}
+
+	if _, err := db.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, table)); err != nil {
+		return err
+	}
+	if _, err := db.Exec(ddl); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(fmt.Sprintf(`INSERT INTO "%s" (%s) VALUES (%s)`,
+		table, strings.Join(quoted, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", ")))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	rows, err := src.Query(fmt.Sprintf(`SELECT %s FROM "%s"`, strings.Join(plain, ", "), table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	values := make([]any, len(cols))
+	dest := make([]any, len(cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	n := 0
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		for i, v := range values {
+			switch {
+			case hashed[i]:
+				values[i] = a.hashValue(v)
+			case bounds[i] > 0:
+				values[i] = perturbValue(v, bounds[i])
+			}
+		}
+		if _, err := stmt.Exec(values...); err != nil {
+			return fmt.Errorf("insert row %d: %w", n, err)
+		}
+		n++
+		if n%10000 == 0 {
+			fmt.Printf("%s: imported %d\n", table, n)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Imported %s with %d records", table, n)
+	return nil
+}
@@ -22,6 +22,23 @@ func main() {
 	}
 	defer db.Close()
 
+	// AQE_IMPORT_FROM copies real tables, anonymized, instead of seeding
+	if src := os.Getenv("AQE_IMPORT_FROM"); src != "" {
+		tables := splitList(os.Getenv("AQE_IMPORT_TABLES"))
+		if len(tables) == 0 {
+			log.Fatalf("import: AQE_IMPORT_TABLES lists no tables")
+		}
+		a, err := newAnonymizer()
+		if err != nil {
+			log.Fatalf("import: %v", err)
+		}
+		if err := importTables(db, src, tables, a); err != nil {
+			log.Fatalf("import: %v", err)
+		}
+		fmt.Println("Import done.")
+		return
+	}
+
 	if _, err := db.Exec(`DROP TABLE IF EXISTS purchases`); err != nil {
 		log.Fatalf("drop: %v", err)
 	}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Role is what an API key may do; each role may do everything the roles
+// below it may.
+type Role int
+
+const (
+	// RoleReader runs queries, saves and runs templates and reads tables,
+	// synopses, estimates and learning state.
+	RoleReader Role = iota + 1
+	// RoleBuilder also creates, drops and restores samples and sketches,
+	// deletes templates, onboards tables and sets their column roles and
+	// accuracy policies.
+	RoleBuilder
+	// RoleAdmin also runs maintenance: synopsis maintenance, archiving,
+	// export and import, and ANALYZE.
+	RoleAdmin
+)
+
+var roleNames = map[Role]string{RoleReader: "reader", RoleBuilder: "builder", RoleAdmin: "admin"}
+
+func (r Role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("Role(%d)", int(r))
+}
+
+// APIKey is a key clients present in an Authorization: Bearer or X-API-Key
+// header, and the role it grants.
+type APIKey struct {
+	Key  string
+	Role Role
+}
+
+// parseAPIKeys parses AQE_API_KEYS: comma-separated key:role pairs, e.g.
+// "k1:reader,k2:admin". Entries with an empty key or an unknown role are
+// left out, so a mistyped entry locks its key out rather than letting it
+// in; the result is never nil, since setting the variable turns access
+// control on.
+func parseAPIKeys(v string) []APIKey {
+	keys := []APIKey{}
+	for _, entry := range strings.Split(v, ",") {
+		i := strings.LastIndex(entry, ":")
+		if i <= 0 {
+			continue
+		}
+		key, name := strings.TrimSpace(entry[:i]), strings.ToLower(strings.TrimSpace(entry[i+1:]))
+		for role, n := range roleNames {
+			if n == name && key != "" {
+				keys = append(keys, APIKey{Key: key, Role: role})
+			}
+		}
+	}
+	return keys
+}
+
+// requestKey is the API key r presents, if any.
+func requestKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); len(auth) > len("Bearer ") && strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(auth[len("Bearer "):])
+	}
+	return strings.TrimSpace(r.Header.Get("X-API-Key"))
+}
+
+// keyRole is the role key grants, or 0 for no configured key. Every key is
+// compared, in constant time, so timing does not reveal how close a guess
+// came.
+func (h *Handler) keyRole(key string) Role {
+	var role Role
+	for _, k := range h.config.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(k.Key), []byte(key)) == 1 {
+			role = k.Role
+		}
+	}
+	return role
+}
+
+// grants reports whether r's API key grants at least role, as require
+// would check it; without Config.APIKeys every request is granted every
+// role.
+func (h *Handler) grants(r *http.Request, role Role) bool {
+	return h.config.APIKeys == nil || h.keyRole(requestKey(r)) >= role
+}
+
+// require serves next only to requests whose API key grants at least
+// role. Without Config.APIKeys access control is off and every request is
+// served.
+func (h *Handler) require(role Role, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.config.APIKeys == nil {
+			next(w, r)
+			return
+		}
+		key := requestKey(r)
+		if key == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="aqe"`)
+			writeJSON(w, http.StatusUnauthorized, JSON{"error": "API key required"})
+			return
+		}
+		have := h.keyRole(key)
+		if have == 0 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="aqe", error="invalid_token"`)
+			writeJSON(w, http.StatusUnauthorized, JSON{"error": "unknown API key"})
+			return
+		}
+		if have < role {
+			writeJSON(w, http.StatusForbidden, JSON{"error": fmt.Sprintf("%s role required; this key has %s", role, have)})
+			return
+		}
+		next(w, r)
+	}
+}
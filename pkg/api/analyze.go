@@ -0,0 +1,200 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/sketches"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// histogramBuckets is how many equi-depth buckets a numeric column's
+// histogram has.
+const histogramBuckets = 10
+
+// PostAnalyzeTable collects a table's statistics in one scan: its row
+// count and, per column, a HyperLogLog distinct count, min and max, null
+// fraction and, for numeric columns, an equi-depth histogram. The planner
+// costs plans from these instead of counting at plan time.
+func (h *Handler) PostAnalyzeTable(w http.ResponseWriter, r *http.Request) {
+	if h.rejectInSafeMode(w) {
+		return
+	}
+	table := mux.Vars(r)["name"]
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Minute)
+	defer cancel()
+
+	exists, err := storage.TableExists(ctx, h.db, table)
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	if !exists {
+		writeJSON(w, http.StatusNotFound, JSON{"error": "no such table: " + table})
+		return
+	}
+	rows, columns, err := h.analyzeTable(ctx, table)
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "table": table, "rows": rows, "columns": columns})
+}
+
+// GetTableStats returns the statistics last collected for a table.
+func (h *Handler) GetTableStats(w http.ResponseWriter, r *http.Request) {
+	table := mux.Vars(r)["name"]
+	columns, err := storage.GetColumnStats(r.Context(), h.db, table)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
+		return
+	}
+	if len(columns) == 0 {
+		writeJSON(w, http.StatusNotFound, JSON{"error": table + " has not been analyzed"})
+		return
+	}
+	rows, err := storage.RecordedRowCount(r.Context(), h.db, table)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "table": table, "rows": rows, "columns": columns})
+}
+
+// analyzeTable collects and saves table's statistics.
+func (h *Handler) analyzeTable(ctx context.Context, table string) (int64, []storage.ColumnStats, error) {
+	rows, columns, err := h.collectColumnStats(ctx, table)
+	if err != nil {
+		return 0, nil, err
+	}
+	err = h.guard.Do(ctx, func(ctx context.Context) error {
+		return storage.SaveColumnStats(ctx, h.db, table, rows, columns)
+	})
+	return rows, columns, err
+}
+
+// columnCollector accumulates one column's statistics over a scan.
+type columnCollector struct {
+	hll    *sketches.HyperLogLog
+	digest *sketches.TDigest
+	nulls  int64
+	// text is set once a non-numeric value is seen; textMin and textMax
+	// cover every value as text, numMin and numMax the numbers.
+	text             bool
+	seen             bool
+	numMin, numMax   float64
+	textMin, textMax string
+}
+
+func (c *columnCollector) add(v any) {
+	var f float64
+	var s string
+	numeric := true
+	switch v := v.(type) {
+	case nil:
+		c.nulls++
+		return
+	case int64:
+		f, s = float64(v), strconv.FormatInt(v, 10)
+	case float64:
+		f, s = v, strconv.FormatFloat(v, 'g', -1, 64)
+	case []byte:
+		s, numeric = string(v), false
+	case string:
+		s, numeric = v, false
+	case time.Time:
+		s, numeric = v.UTC().Format(time.RFC3339Nano), false
+	default:
+		s, numeric = fmt.Sprint(v), false
+	}
+	c.hll.AddString(s)
+	if !c.seen {
+		c.seen = true
+		c.numMin, c.numMax, c.textMin, c.textMax = f, f, s, s
+	}
+	c.textMin, c.textMax = min(c.textMin, s), max(c.textMax, s)
+	if !numeric {
+		c.text = true
+		return
+	}
+	c.numMin, c.numMax = min(c.numMin, f), max(c.numMax, f)
+	c.digest.Add(f)
+}
+
+func (c *columnCollector) stats(column string, rows int64) storage.ColumnStats {
+	out := storage.ColumnStats{Column: column, Distinct: int64(c.hll.Count())}
+	if rows > 0 {
+		out.NullFraction = float64(c.nulls) / float64(rows)
+	}
+	if !c.seen {
+		return out
+	}
+	if c.text {
+		out.Min, out.Max = c.textMin, c.textMax
+		return out
+	}
+	out.Numeric = true
+	out.Min = strconv.FormatFloat(c.numMin, 'g', -1, 64)
+	out.Max = strconv.FormatFloat(c.numMax, 'g', -1, 64)
+	out.Histogram = make([]float64, histogramBuckets+1)
+	for i := range out.Histogram {
+		out.Histogram[i] = c.digest.Quantile(float64(i) / histogramBuckets)
+	}
+	// the digest interpolates inside its centroids; the ends are exact
+	out.Histogram[0], out.Histogram[histogramBuckets] = c.numMin, c.numMax
+	return out
+}
+
+// collectColumnStats scans table once for its row count and the
+// statistics of every column.
+func (h *Handler) collectColumnStats(ctx context.Context, table string) (int64, []storage.ColumnStats, error) {
+	names, err := storage.TableColumns(ctx, h.db, table)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(names) == 0 {
+		return 0, nil, fmt.Errorf("%s has no columns", table)
+	}
+	collectors := make([]*columnCollector, len(names))
+	for i := range collectors {
+		collectors[i] = &columnCollector{hll: sketches.NewHyperLogLog(12), digest: sketches.NewTDigest(100)}
+	}
+
+	rows, err := h.db.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s", strings.Join(names, ", "), table))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+	values := make([]any, len(names))
+	ptrs := make([]any, len(names))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	var n int64
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return 0, nil, err
+		}
+		for i, v := range values {
+			collectors[i].add(v)
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	out := make([]storage.ColumnStats, len(names))
+	for i, c := range collectors {
+		out[i] = c.stats(names[i], n)
+		out[i].AnalyzedAt = now
+	}
+	return n, out, nil
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// sampleUseInterval is how often a sample in steady use has that use
+// written to aqe_sample_usage.
+const sampleUseInterval = time.Minute
+
+// resolveSample is the planner's SampleResolver: it brings sampleTable
+// back from the archive if it was moved there, and records its use so it
+// stays out of it. Safe mode writes nothing, so archived samples stay
+// archived and the planner falls back to exact.
+func (h *Handler) resolveSample(ctx context.Context, sampleTable string) error {
+	if h.config.SafeMode {
+		return nil
+	}
+	if last, ok := h.sampleUse.Load(sampleTable); ok && time.Since(last.(time.Time)) < sampleUseInterval {
+		return nil
+	}
+	h.archiveMu.Lock()
+	defer h.archiveMu.Unlock()
+	err := h.guard.Do(ctx, func(ctx context.Context) error {
+		if err := storage.RestoreSample(ctx, h.db, sampleTable); err != nil {
+			return err
+		}
+		return storage.TouchSample(ctx, h.db, sampleTable)
+	})
+	if err != nil {
+		return err
+	}
+	h.sampleUse.Store(sampleTable, time.Now())
+	return nil
+}
+
+// SampleArchiveReport lists the samples one archive pass moved out of the
+// main database.
+type SampleArchiveReport struct {
+	Path     string   `json:"path"`
+	Archived []string `json:"archived"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// archiveSamples moves sampleTables, or when none are given every sample
+// unused for idle, to the archive file.
+func (h *Handler) archiveSamples(ctx context.Context, sampleTables []string, idle time.Duration) (*SampleArchiveReport, error) {
+	path := h.config.ArchivePath
+	if path == "" {
+		var err error
+		if path, err = storage.DefaultArchivePath(ctx, h.db); err != nil {
+			return nil, err
+		}
+		if path == "" {
+			return nil, fmt.Errorf("in-memory database: set AQE_ARCHIVE_PATH to archive samples")
+		}
+	}
+	if len(sampleTables) == 0 {
+		var err error
+		if sampleTables, err = storage.ColdSamples(ctx, h.db, idle); err != nil {
+			return nil, err
+		}
+	}
+
+	report := &SampleArchiveReport{Path: path, Archived: []string{}}
+	h.archiveMu.Lock()
+	defer h.archiveMu.Unlock()
+	for _, s := range sampleTables {
+		err := h.guard.Do(ctx, func(ctx context.Context) error {
+			return storage.ArchiveSample(ctx, h.db, s, path)
+		})
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", s, err))
+			continue
+		}
+		h.sampleUse.Delete(s)
+		report.Archived = append(report.Archived, s)
+	}
+	return report, nil
+}
+
+// PostArchiveSamples moves samples to the archive file now: the ones
+// named, or every sample unused for "idle" (default AQE_ARCHIVE_AFTER).
+// Archived samples come back when the planner next picks them.
+func (h *Handler) PostArchiveSamples(w http.ResponseWriter, r *http.Request) {
+	if h.rejectInSafeMode(w) {
+		return
+	}
+	var req struct {
+		SampleTables []string `json:"sample_tables,omitempty"`
+		Idle         string   `json:"idle,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "invalid json"})
+		return
+	}
+	idle := h.config.ArchiveAfter
+	if req.Idle != "" {
+		d, err := time.ParseDuration(req.Idle)
+		if err != nil || d < 0 {
+			writeJSON(w, http.StatusBadRequest, JSON{"error": "idle must be a non-negative duration such as 72h"})
+			return
+		}
+		idle = d
+	}
+	if len(req.SampleTables) == 0 && idle == 0 {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "sample_tables or idle required"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Minute)
+	defer cancel()
+	report, err := h.archiveSamples(ctx, req.SampleTables, idle)
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "archive": report})
+}
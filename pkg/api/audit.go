@@ -0,0 +1,155 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/ml"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// AuditGroup is a strategy's tolerance record on one table, and whether it
+// breaches often enough to alert on.
+type AuditGroup struct {
+	ml.ToleranceGroup
+	Alerting bool `json:"alerting"`
+}
+
+// GetAccuracyAudit returns the tolerance record of every strategy and
+// table over the last AuditDays days, or ?days=, and the alerts the
+// auditor has raised; ?all=true includes resolved ones.
+func (h *Handler) GetAccuracyAudit(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	days := h.config.AuditDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeJSON(w, http.StatusBadRequest, JSON{"error": "days must be a positive integer"})
+			return
+		}
+		days = n
+	}
+	all, _ := strconv.ParseBool(r.URL.Query().Get("all"))
+
+	groups, err := h.auditGroups(ctx, days)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
+		return
+	}
+	alerts, err := storage.ListAccuracyAlerts(ctx, h.db, all)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
+		return
+	}
+	if alerts == nil {
+		alerts = []storage.AccuracyAlert{}
+	}
+	writeJSON(w, http.StatusOK, JSON{
+		"status":          "ok",
+		"days":            days,
+		"min_runs":        h.config.AuditMinRuns,
+		"max_breach_rate": h.config.AuditBreachRate,
+		"groups":          groups,
+		"alerts":          alerts,
+	})
+}
+
+// auditGroups is the learner's ToleranceAudit with each group judged
+// against the configured thresholds.
+func (h *Handler) auditGroups(ctx context.Context, days int) ([]AuditGroup, error) {
+	tolerance, err := h.learner.ToleranceAudit(ctx, days)
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]AuditGroup, len(tolerance))
+	for i, g := range tolerance {
+		groups[i] = AuditGroup{
+			ToleranceGroup: g,
+			Alerting:       g.VerifiedRuns >= h.config.AuditMinRuns && g.BreachRate > h.config.AuditBreachRate,
+		}
+	}
+	return groups, nil
+}
+
+// runAuditor checks the verifier's measurements against their tolerances
+// once per interval.
+func (h *Handler) runAuditor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		if err := h.auditAccuracy(ctx); err != nil {
+			log.Printf("accuracy audit: %v", err)
+		}
+		cancel()
+	}
+}
+
+// auditAccuracy raises an alert for every strategy and table whose
+// verified answers systematically break their tolerance, refreshes the
+// ones still open and resolves those back within bounds, logging each
+// alert opened or resolved.
+func (h *Handler) auditAccuracy(ctx context.Context) error {
+	groups, err := h.auditGroups(ctx, h.config.AuditDays)
+	if err != nil {
+		return err
+	}
+	type groupKey struct{ table, strategy string }
+	alerting := make(map[groupKey]bool)
+	for _, g := range groups {
+		if !g.Alerting {
+			continue
+		}
+		alerting[groupKey{g.Table, g.Strategy}] = true
+		alert := &storage.AccuracyAlert{
+			Table:            g.Table,
+			Strategy:         g.Strategy,
+			VerifiedRuns:     g.VerifiedRuns,
+			Breaches:         g.Breaches,
+			BreachRate:       g.BreachRate,
+			MeasuredErrorP90: g.MeasuredError.P90,
+			ToleranceP50:     g.Tolerance.P50,
+		}
+		var opened bool
+		err := h.guard.Do(ctx, func(ctx context.Context) error {
+			var raiseErr error
+			opened, raiseErr = storage.RaiseAccuracyAlert(ctx, h.db, alert)
+			return raiseErr
+		})
+		if err != nil {
+			return err
+		}
+		if opened {
+			log.Printf("accuracy alert: %s answers on %s broke their tolerance in %d of %d verified runs (p90 error %.4f, median tolerance %.4f)",
+				g.Strategy, g.Table, g.Breaches, g.VerifiedRuns, g.MeasuredError.P90, g.Tolerance.P50)
+		}
+	}
+
+	open, err := storage.ListAccuracyAlerts(ctx, h.db, false)
+	if err != nil {
+		return err
+	}
+	for _, a := range open {
+		if alerting[groupKey{a.Table, a.Strategy}] {
+			continue
+		}
+		var resolved bool
+		err := h.guard.Do(ctx, func(ctx context.Context) error {
+			var resolveErr error
+			resolved, resolveErr = storage.ResolveAccuracyAlert(ctx, h.db, a.Table, a.Strategy)
+			return resolveErr
+		})
+		if err != nil {
+			return err
+		}
+		if resolved {
+			log.Printf("accuracy alert resolved: %s answers on %s are back within tolerance", a.Strategy, a.Table)
+		}
+	}
+	return nil
+}
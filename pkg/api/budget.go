@@ -0,0 +1,400 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/sampler"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// minShrinkFraction is the smallest fraction the storage budget shrinks a
+// sample to; a sample that would go below it is dropped instead.
+const minShrinkFraction = 0.001
+
+// BudgetedSynopsis is a sample or sketch in the main database as the
+// storage budget weighs it: the bytes it takes up against Hits, the
+// approximate runs of the recorded query patterns on its table it could
+// have answered (see synopsisHits).
+type BudgetedSynopsis struct {
+	Kind   string `json:"kind"` // "sample" or "sketch"
+	Table  string `json:"table"`
+	Name   string `json:"name"` // the sample table, or the sketch's type
+	Column string `json:"column,omitempty"`
+	Bytes  int64  `json:"bytes"`
+	Hits   int64  `json:"hits"`
+
+	sample *storage.SampleInfo
+}
+
+// BudgetAction is what enforcing the storage budget did, or on a dry run
+// would do, to one synopsis. A shrunk sample is redrawn at Fraction in
+// Replacement; a dry run estimates it frees half its bytes.
+type BudgetAction struct {
+	BudgetedSynopsis
+	Action      string  `json:"action"` // "shrunk" or "dropped"
+	Fraction    float64 `json:"fraction,omitempty"`
+	Replacement string  `json:"replacement,omitempty"`
+	FreedBytes  int64   `json:"freed_bytes"`
+}
+
+// BudgetReport is the storage budget in force, the synopses it covers,
+// least useful first, and what enforcing it did.
+type BudgetReport struct {
+	MaxBytes  int64  `json:"max_bytes"` // 0 is unlimited
+	Shrink    bool   `json:"shrink"`
+	Source    string `json:"source"` // "registered" or "server"
+	UsedBytes int64  `json:"used_bytes"`
+	// OverBytes is by how much UsedBytes exceeds MaxBytes.
+	OverBytes int64                  `json:"over_bytes"`
+	Budget    *storage.StorageBudget `json:"budget"`
+	Synopses  []BudgetedSynopsis     `json:"synopses"`
+	Actions   []BudgetAction         `json:"actions,omitempty"`
+	Deferred  []string               `json:"deferred,omitempty"`
+	Errors    []string               `json:"errors,omitempty"`
+}
+
+// budgetReport weighs every sample and sketch in the main database against
+// the registered budget or, without one, the server's (see
+// Config.StorageBudgetBytes), which shrinks. Archived samples don't count.
+func (h *Handler) budgetReport(ctx context.Context) (*BudgetReport, error) {
+	budget, err := storage.GetStorageBudget(ctx, h.db)
+	if err != nil {
+		return nil, err
+	}
+	report := &BudgetReport{MaxBytes: h.config.StorageBudgetBytes, Shrink: true, Source: "server", Budget: budget, Synopses: []BudgetedSynopsis{}}
+	if budget != nil {
+		report.MaxBytes, report.Shrink, report.Source = budget.MaxBytes, budget.Shrink, "registered"
+	}
+
+	sketches, err := storage.SketchSizes(ctx, h.db)
+	if err != nil {
+		return nil, err
+	}
+	tables, err := storage.SynopsisTables(ctx, h.db)
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range tables {
+		var synopses []BudgetedSynopsis
+		samples, err := storage.ListSamples(ctx, h.db, table)
+		if err != nil {
+			return nil, err
+		}
+		for i, s := range samples {
+			if s.Archived {
+				continue
+			}
+			b := BudgetedSynopsis{Kind: "sample", Table: table, Name: s.SampleTable, sample: &samples[i]}
+			for _, name := range []string{s.SampleTable, s.OutlierTable} {
+				if name == "" {
+					continue
+				}
+				n, err := storage.TableBytes(ctx, h.db, name)
+				if err != nil {
+					return nil, err
+				}
+				b.Bytes += n
+			}
+			synopses = append(synopses, b)
+		}
+		for _, sk := range sketches {
+			if sk.Table == table {
+				synopses = append(synopses, BudgetedSynopsis{Kind: "sketch", Table: table, Name: string(sk.Type), Column: sk.Column, Bytes: sk.Bytes})
+			}
+		}
+		if err := h.synopsisHits(ctx, table, synopses); err != nil {
+			return nil, err
+		}
+		report.Synopses = append(report.Synopses, synopses...)
+	}
+
+	// least used first and, among equally used ones, the largest, which
+	// frees the most
+	sort.SliceStable(report.Synopses, func(i, j int) bool {
+		a, b := report.Synopses[i], report.Synopses[j]
+		if a.Hits != b.Hits {
+			return a.Hits < b.Hits
+		}
+		return a.Bytes > b.Bytes
+	})
+	for _, s := range report.Synopses {
+		report.UsedBytes += s.Bytes
+	}
+	if report.MaxBytes > 0 && report.UsedBytes > report.MaxBytes {
+		report.OverBytes = report.UsedBytes - report.MaxBytes
+	}
+	return report, nil
+}
+
+// synopsisHits credits each of table's synopses with the approximate runs
+// of every recorded query pattern it could have answered, by the rules
+// GET /tables/{name}/coverage uses: a sketch serves the patterns needing
+// its type on its column, and a sample those it copied the columns of.
+func (h *Handler) synopsisHits(ctx context.Context, table string, synopses []BudgetedSynopsis) error {
+	if len(synopses) == 0 {
+		return nil
+	}
+	patterns, err := h.learner.TablePatterns(ctx, table)
+	if err != nil {
+		return err
+	}
+	tableCols, err := storage.TableColumns(ctx, h.db, table)
+	if err != nil {
+		return err
+	}
+	p := planner.New()
+	p.SetComplexityThreshold(h.config.ComplexityThreshold)
+	for _, u := range patterns {
+		runs := u.Runs - u.ExactRuns
+		if runs <= 0 {
+			continue
+		}
+		_, needs := p.NeededSynopses(u.Pattern)
+		for _, need := range needs {
+			if need.Kind != "sample" {
+				for i, s := range synopses {
+					if s.Kind == "sketch" && s.Name == need.Kind && s.Column == need.Column {
+						synopses[i].Hits += runs
+					}
+				}
+				continue
+			}
+			_, cols, all := p.SampleColumns(u.Pattern)
+			for i, s := range synopses {
+				if s.sample != nil && planner.SampleCovers(s.sample.Columns, cols, all, tableCols) {
+					synopses[i].Hits += runs
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// shrinkable reports whether info can be redrawn at a smaller fraction as
+// the same kind of sample: uniform, filtered, universe and block samples
+// can; stratified, outlier and live samples are only dropped.
+func shrinkable(info *storage.SampleInfo) bool {
+	return info.StrataColumn == "" && info.OutlierTable == "" && info.ReservoirSize == 0 &&
+		info.Fraction/2 >= minShrinkFraction
+}
+
+// enforceStorageBudget brings the synopses in the main database under the
+// budget, least useful first: a sample some pattern uses is redrawn at half
+// its fraction when the budget shrinks and it is shrinkable, and anything
+// else is dropped for good, so its space is freed. A background pass
+// shrinks samples only within their table's maintenance window, and skips
+// them outside it. A dry run reports what it would do.
+func (h *Handler) enforceStorageBudget(ctx context.Context, background, dryRun bool) (*BudgetReport, error) {
+	report, err := h.budgetReport(ctx)
+	if err != nil || report.OverBytes == 0 {
+		return report, err
+	}
+
+	h.archiveMu.Lock()
+	defer h.archiveMu.Unlock()
+	over := report.OverBytes
+	replaced := make(map[string]bool)
+	for _, s := range report.Synopses {
+		if over <= 0 || ctx.Err() != nil {
+			break
+		}
+		if replaced[s.Name] {
+			continue
+		}
+		a := BudgetAction{BudgetedSynopsis: s, Action: "dropped", FreedBytes: s.Bytes}
+		if s.sample != nil && report.Shrink && s.Hits > 0 && shrinkable(s.sample) {
+			a.Action, a.Fraction = "shrunk", s.sample.Fraction/2
+		}
+		if !dryRun {
+			var err error
+			if a.Action == "shrunk" {
+				err = h.shrinkSample(ctx, &a, background)
+			} else {
+				err = h.dropBudgeted(ctx, s)
+			}
+			if errors.Is(err, errOutsideWindow) {
+				report.Deferred = append(report.Deferred, s.Name)
+				continue
+			}
+			if err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("%s %s: %v", a.Action, s.Name, err))
+				continue
+			}
+			replaced[a.Replacement] = true
+		} else if a.Action == "shrunk" {
+			a.FreedBytes = s.Bytes / 2
+		}
+		over -= a.FreedBytes
+		report.Actions = append(report.Actions, a)
+	}
+	return report, nil
+}
+
+// shrinkSample redraws a's sample at a.Fraction in a new table and drops
+// the old one, recording the replacement and the bytes freed; in the
+// background it returns errOutsideWindow outside the table's window.
+func (h *Handler) shrinkSample(ctx context.Context, a *BudgetAction, background bool) error {
+	if background {
+		runCtx, done, err := h.scheduleMaintenance(ctx, a.Table)
+		if errors.Is(err, errOutsideWindow) {
+			return errOutsideWindow
+		}
+		if err != nil {
+			return err
+		}
+		defer done()
+		ctx = runCtx
+	}
+	info := a.sample
+	return h.guard.Do(ctx, func(ctx context.Context) error {
+		var err error
+		switch {
+		case info.UniverseColumn != "":
+			a.Replacement, _, err = sampler.CreateUniverseSample(ctx, h.db, info.Table, info.UniverseColumn, a.Fraction, info.Columns)
+		case info.BlockRows > 0:
+			a.Replacement, _, err = sampler.CreateBlockSample(ctx, h.db, info.Table, info.Predicate, a.Fraction, info.BlockRows, info.Columns)
+		default:
+			a.Replacement, _, err = sampler.CreateFilteredSample(ctx, h.db, info.Table, info.Predicate, a.Fraction, info.Columns)
+		}
+		if err != nil {
+			return err
+		}
+		if err := storage.DropSample(ctx, h.db, info.SampleTable); err != nil {
+			return err
+		}
+		h.sampleUse.Delete(info.SampleTable)
+		n, err := storage.TableBytes(ctx, h.db, a.Replacement)
+		if err != nil {
+			return err
+		}
+		a.FreedBytes = a.Bytes - n
+		return nil
+	})
+}
+
+// dropBudgeted drops s for good.
+func (h *Handler) dropBudgeted(ctx context.Context, s BudgetedSynopsis) error {
+	return h.guard.Do(ctx, func(ctx context.Context) error {
+		if s.Kind == "sketch" {
+			return storage.DropSketch(ctx, h.db, s.Table, s.Column, storage.SketchType(s.Name))
+		}
+		if err := storage.DropSample(ctx, h.db, s.Name); err != nil {
+			return err
+		}
+		h.sampleUse.Delete(s.Name)
+		return nil
+	})
+}
+
+// keepStorageBudget enforces the storage budget after each maintenance
+// pass.
+func (h *Handler) keepStorageBudget(timeout time.Duration) {
+	if h.config.SafeMode {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	report, err := h.enforceStorageBudget(ctx, true, false)
+	if err != nil {
+		log.Printf("storage budget: %v", err)
+		return
+	}
+	for _, a := range report.Actions {
+		log.Printf("storage budget: %s %s %s on %s (%d hits), freeing %d bytes", a.Action, a.Kind, a.Name, a.Table, a.Hits, a.FreedBytes)
+	}
+	if len(report.Deferred) > 0 || len(report.Errors) > 0 {
+		log.Printf("storage budget: %d bytes over %d, shrinking %v deferred to maintenance windows, errors %v",
+			report.OverBytes, report.MaxBytes, report.Deferred, report.Errors)
+	}
+}
+
+// StorageBudgetRequest registers the storage budget:
+//
+//	{"max_bytes": 1073741824, "shrink": true}
+//
+// shrink defaults to true.
+type StorageBudgetRequest struct {
+	MaxBytes int64 `json:"max_bytes"`
+	Shrink   *bool `json:"shrink"`
+}
+
+// PutStorageBudget registers the storage budget, replacing the server's;
+// maintenance enforces it on its next pass.
+func (h *Handler) PutStorageBudget(w http.ResponseWriter, r *http.Request) {
+	var req StorageBudgetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "invalid json"})
+		return
+	}
+	if req.MaxBytes <= 0 {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "max_bytes must be positive"})
+		return
+	}
+	budget := &storage.StorageBudget{MaxBytes: req.MaxBytes, Shrink: req.Shrink == nil || *req.Shrink}
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+	if err := storage.SaveStorageBudget(ctx, h.db, budget); err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	h.writeBudgetReport(ctx, w)
+}
+
+// GetStorageBudget reports the storage budget in force, the bytes each
+// sample and sketch takes up and its hits, least useful first.
+func (h *Handler) GetStorageBudget(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+	h.writeBudgetReport(ctx, w)
+}
+
+func (h *Handler) writeBudgetReport(ctx context.Context, w http.ResponseWriter) {
+	report, err := h.budgetReport(ctx)
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "storage_budget": report})
+}
+
+// DeleteStorageBudget removes the registered budget; the server's applies
+// again.
+func (h *Handler) DeleteStorageBudget(w http.ResponseWriter, r *http.Request) {
+	deleted, err := storage.DeleteStorageBudget(r.Context(), h.db)
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	if !deleted {
+		writeJSON(w, http.StatusNotFound, JSON{"error": "no storage budget registered"})
+		return
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok"})
+}
+
+// PostEnforceStorageBudget brings the synopses under the storage budget
+// now, regardless of maintenance windows, or with ?dry_run=true reports
+// what that would shrink and drop.
+func (h *Handler) PostEnforceStorageBudget(w http.ResponseWriter, r *http.Request) {
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	if !dryRun && h.rejectInSafeMode(w) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Minute)
+	defer cancel()
+	report, err := h.enforceStorageBudget(ctx, false, dryRun)
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "dry_run": dryRun, "storage_budget": report})
+}
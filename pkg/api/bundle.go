@@ -0,0 +1,186 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/executor"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+)
+
+// maxBundleQueries bounds the statements in one estimate bundle.
+const maxBundleQueries = 50
+
+type BundleQuery struct {
+	Name string `json:"name,omitempty"`
+	SQL  string `json:"sql"`
+}
+
+type BundleRequest struct {
+	Queries     []BundleQuery `json:"queries"`
+	MaxRelError float64       `json:"max_rel_error"`
+	PreferExact bool          `json:"prefer_exact"`
+	SafeMode    bool          `json:"safe_mode"`
+	// Priority schedules the bundle as one query; see QueryRequest.
+	Priority string `json:"priority,omitempty"`
+}
+
+type BundleResult struct {
+	Name   string              `json:"name,omitempty"`
+	Plan   *planner.Plan       `json:"plan"`
+	Result *executor.ResultSet `json:"result"`
+	Meta   map[string]any      `json:"meta,omitempty"`
+}
+
+// BundleTable records how a bundle's queries on one table were evaluated:
+// all from one shared sample, or all exactly.
+type BundleTable struct {
+	Mode        string `json:"mode"` // "shared_sample" or "exact"
+	SampleTable string `json:"sample_table,omitempty"`
+	Queries     int    `json:"queries"`
+}
+
+// PostQueryBundle evaluates related aggregates against one read snapshot.
+// Queries on the same table either all read the same sample, scaled by the
+// same fraction, or all run exact, so their results stay mutually
+// consistent (parts add up to totals, ratios are taken over one draw).
+func (h *Handler) PostQueryBundle(w http.ResponseWriter, r *http.Request) {
+	var req BundleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "invalid json"})
+		return
+	}
+	if len(req.Queries) == 0 || len(req.Queries) > maxBundleQueries {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": fmt.Sprintf("between 1 and %d queries required", maxBundleQueries)})
+		return
+	}
+	for i := range req.Queries {
+		req.Queries[i].SQL = strings.TrimSpace(req.Queries[i].SQL)
+		if req.Queries[i].SQL == "" {
+			writeJSON(w, http.StatusBadRequest, JSON{"error": fmt.Sprintf("query %d: sql required", i)})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 120*time.Second)
+	defer cancel()
+
+	tables, results, status, err := h.evaluateBundle(ctx, req)
+	if err != nil {
+		writeJSON(w, status, errorBody(err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "snapshot": true, "tables": tables, "results": results})
+}
+
+// evaluateBundle plans, aligns and executes req's queries in one snapshot.
+// On failure it also returns the HTTP status to answer with.
+func (h *Handler) evaluateBundle(ctx context.Context, req BundleRequest) (map[string]*BundleTable, []BundleResult, int, error) {
+	priority, err := parsePriority(req.Priority)
+	if err != nil {
+		return nil, nil, http.StatusBadRequest, err
+	}
+	safeMode := h.config.SafeMode || req.SafeMode
+	if safeMode {
+		req.PreferExact = true
+	}
+
+	p := planner.New()
+	p.SetComplexityThreshold(h.config.ComplexityThreshold)
+	p.SetSafeMode(safeMode)
+	p.SetSampleResolver(h.resolveSample)
+	p.SetDisabledStrategies(h.config.DisabledStrategies)
+	p.SetMaxGroups(h.config.MaxResultGroups)
+	p.SetQueryLimits(h.config.QueryLimits)
+	plans := make([]*planner.Plan, len(req.Queries))
+	for i, q := range req.Queries {
+		plan, err := p.Plan(ctx, h.readDB, q.SQL, req.MaxRelError, req.PreferExact)
+		if err != nil {
+			return nil, nil, errorStatus(err, http.StatusBadRequest), fmt.Errorf("query %d: %w", i, err)
+		}
+		plans[i] = plan
+	}
+
+	tables, err := h.alignBundlePlans(ctx, p, req, plans)
+	if err != nil {
+		return nil, nil, errorStatus(err, http.StatusBadRequest), err
+	}
+
+	// the snapshot is one transaction, so it takes one slot if any query
+	// in it is heavy
+	ctx, release, err := h.schedule(ctx, priority, slices.ContainsFunc(plans, heavyPlan))
+	if err != nil {
+		return nil, nil, errorStatus(err, http.StatusServiceUnavailable), err
+	}
+	defer release()
+
+	results := make([]BundleResult, len(plans))
+	err = h.guard.Do(ctx, func(ctx context.Context) error {
+		// one read transaction: every statement sees the same snapshot
+		tx, err := h.readDB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		ctx = executor.WithMemoryBudget(ctx, executor.NewMemoryBudget(h.config.MaxQueryMemoryBytes))
+		for i, plan := range plans {
+			rows, meta, err := executor.Execute(ctx, tx, plan)
+			if err != nil {
+				return fmt.Errorf("query %d: %w", i, err)
+			}
+			results[i] = BundleResult{Name: req.Queries[i].Name, Plan: plan, Result: rows, Meta: meta}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, errorStatus(err, http.StatusInternalServerError), err
+	}
+	return tables, results, http.StatusOK, nil
+}
+
+// alignBundlePlans makes the plans on each table agree: if every plan on a
+// table reads the same sample they keep it, otherwise all of that table's
+// queries are replanned exact.
+func (h *Handler) alignBundlePlans(ctx context.Context, p *planner.Planner, req BundleRequest, plans []*planner.Plan) (map[string]*BundleTable, error) {
+	byTable := make(map[string][]int)
+	for i, plan := range plans {
+		byTable[plan.Table] = append(byTable[plan.Table], i)
+	}
+
+	tables := make(map[string]*BundleTable, len(byTable))
+	for table, idx := range byTable {
+		shared := plans[idx[0]].SampleTable
+		for _, i := range idx {
+			if plans[i].Type != planner.PlanSample || plans[i].SampleTable != shared {
+				shared = ""
+				break
+			}
+		}
+		if shared != "" {
+			tables[table] = &BundleTable{Mode: "shared_sample", SampleTable: shared, Queries: len(idx)}
+			continue
+		}
+
+		tables[table] = &BundleTable{Mode: "exact", Queries: len(idx)}
+		for _, i := range idx {
+			if plans[i].Type == planner.PlanExact {
+				continue
+			}
+			plan, err := p.Plan(ctx, h.readDB, req.Queries[i].SQL, req.MaxRelError, true)
+			if err != nil {
+				return nil, fmt.Errorf("query %d: %w", i, err)
+			}
+			plan.Reason = "bundle: exact so results on " + table + " stay consistent"
+			plans[i] = plan
+		}
+	}
+	return tables, nil
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/estimator"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/executor"
+)
+
+// CompareRequest asks whether metric A exceeds metric B. Each SQL must
+// return one row; Column picks the value, defaulting to the first numeric one.
+type CompareRequest struct {
+	A           string  `json:"a"`
+	B           string  `json:"b"`
+	ColumnA     string  `json:"column_a,omitempty"`
+	ColumnB     string  `json:"column_b,omitempty"`
+	MaxRelError float64 `json:"max_rel_error"`
+	Confidence  float64 `json:"confidence,omitempty"` // verdict threshold, default 0.95
+	SafeMode    bool    `json:"safe_mode"`
+}
+
+// MetricEstimate is one side of a comparison.
+type MetricEstimate struct {
+	Column   string  `json:"column"`
+	Estimate float64 `json:"estimate"`
+	StdError float64 `json:"std_error"`
+	PlanType string  `json:"plan_type"`
+}
+
+// ComparisonVerdict is the probabilistic answer to "is A greater than B".
+// Verdict is "a_greater" or "b_greater" once P(A>B) clears Confidence on
+// either side, otherwise "inconclusive".
+type ComparisonVerdict struct {
+	A            MetricEstimate `json:"a"`
+	B            MetricEstimate `json:"b"`
+	ProbAGreater float64        `json:"p_a_greater"`
+	Confidence   float64        `json:"confidence"`
+	Verdict      string         `json:"verdict"`
+}
+
+// PostCompare evaluates two metrics as a consistent bundle and returns
+// P(A > B) from their estimates and standard errors. The two estimates are
+// treated as independent; when both come from one shared sample they are
+// usually positively correlated, which makes the verdict conservative.
+func (h *Handler) PostCompare(w http.ResponseWriter, r *http.Request) {
+	var req CompareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "invalid json"})
+		return
+	}
+	req.A, req.B = strings.TrimSpace(req.A), strings.TrimSpace(req.B)
+	if req.A == "" || req.B == "" {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "a and b required"})
+		return
+	}
+	if req.Confidence == 0 {
+		req.Confidence = 0.95
+	}
+	if req.Confidence <= 0.5 || req.Confidence >= 1 {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "confidence must be in (0.5, 1)"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 120*time.Second)
+	defer cancel()
+
+	_, results, status, err := h.evaluateBundle(ctx, BundleRequest{
+		Queries:     []BundleQuery{{Name: "a", SQL: req.A}, {Name: "b", SQL: req.B}},
+		MaxRelError: req.MaxRelError,
+		SafeMode:    req.SafeMode,
+	})
+	if err != nil {
+		writeJSON(w, status, errorBody(err))
+		return
+	}
+
+	a, err := metricEstimate(results[0], req.ColumnA)
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, JSON{"error": "a: " + err.Error()})
+		return
+	}
+	b, err := metricEstimate(results[1], req.ColumnB)
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, JSON{"error": "b: " + err.Error()})
+		return
+	}
+
+	v := ComparisonVerdict{A: a, B: b, Confidence: req.Confidence, Verdict: "inconclusive"}
+	v.ProbAGreater = estimator.ProbGreater(a.Estimate, a.StdError, b.Estimate, b.StdError)
+	switch {
+	case v.ProbAGreater >= req.Confidence:
+		v.Verdict = "a_greater"
+	case v.ProbAGreater <= 1-req.Confidence:
+		v.Verdict = "b_greater"
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "comparison": v, "results": results})
+}
+
+// metricEstimate reads the single-row value of column (or the first numeric
+// value column) and its standard error, recovered from the interval the
+// executor attached at the plan's confidence level; exact results have none.
+func metricEstimate(res BundleResult, column string) (MetricEstimate, error) {
+	m := MetricEstimate{PlanType: string(res.Plan.Type)}
+	if res.Result.Len() != 1 {
+		return m, fmt.Errorf("expected one row, got %d", res.Result.Len())
+	}
+	if column == "" {
+		for _, name := range res.Result.ColumnNames() {
+			if strings.HasSuffix(name, "_ci_low") || strings.HasSuffix(name, "_ci_high") || strings.HasSuffix(name, "_rel_error") {
+				continue
+			}
+			if _, ok := res.Result.Column(name).Float(0); ok {
+				column = name
+				break
+			}
+		}
+	}
+	c := res.Result.Column(column)
+	if c == nil {
+		return m, fmt.Errorf("no numeric column %q", column)
+	}
+	est, ok := c.Float(0)
+	if !ok {
+		return m, fmt.Errorf("column %q is not numeric", column)
+	}
+	m.Column, m.Estimate = column, est
+	m.StdError = intervalStdError(res.Result, column, 0, res.Plan.Level())
+	return m, nil
+}
+
+// intervalStdError recovers the standard error of column's value in row i
+// from the interval the executor attached at confidence level; it is 0
+// when there is none, as for exact results.
+func intervalStdError(rs *executor.ResultSet, column string, i int, level float64) float64 {
+	low, high := rs.Column(column+"_ci_low"), rs.Column(column+"_ci_high")
+	if low == nil || high == nil {
+		return 0
+	}
+	l, okL := low.Float(i)
+	u, okU := high.Float(i)
+	if !okL || !okU || u < l {
+		return 0
+	}
+	return (u - l) / (2 * estimator.ZScore(level))
+}
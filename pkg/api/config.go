@@ -0,0 +1,356 @@
+package api
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// Config holds server-side knobs for the API layer, read from the environment.
+type Config struct {
+	// MaxQueryMemoryBytes caps approximate per-request memory (0 = unlimited).
+	MaxQueryMemoryBytes int64
+	// ExternalChunkRows is the rowid span per chunk for exact GROUP BY
+	// queries run through executor.ExecuteExternal.
+	ExternalChunkRows int64
+	// ComplexityThreshold is the planner complexity score above which ML
+	// rewrites and sampling are skipped in favour of exact execution.
+	ComplexityThreshold float64
+	// ResultCacheEntries bounds the /query result cache keyed by response
+	// fingerprint (0 disables caching; ETags are still sent).
+	ResultCacheEntries int
+	// JoinCacheEntries bounds how many universe joins are kept staged for
+	// reuse, each for at most JoinCacheTTL, and JoinCacheMaxRows how many
+	// joined rows one may hold (0 entries or TTL disables staging; 0 rows
+	// is unlimited).
+	JoinCacheEntries int
+	JoinCacheTTL     time.Duration
+	JoinCacheMaxRows int64
+	// MaxResultGroups caps the groups a GROUP BY query is estimated to
+	// return without a LIMIT bounding them (see planner.SetMaxGroups; 0
+	// disables the cap).
+	MaxResultGroups int64
+	// QueryLimits cap the joins, table reads and parenthesis nesting of
+	// the SQL planned; over them it is rejected before it is parsed (see
+	// planner.QueryLimits; 0 disables a limit).
+	QueryLimits planner.QueryLimits
+	// PageSpoolEntries bounds how many paginated /query results are kept
+	// spooled for their later pages, each for at most PageSpoolTTL (0
+	// entries or TTL disables pagination).
+	PageSpoolEntries int
+	PageSpoolTTL     time.Duration
+	// MaxJobs bounds the async query jobs kept at once, running or with
+	// results awaiting retrieval; each finished job's result is spooled for
+	// JobResultTTL (0 for either disables async jobs).
+	MaxJobs      int
+	JobResultTTL time.Duration
+	// ShadowExactRate is the fraction of approximate ML-optimized queries
+	// that are re-run exactly in the background to measure a true baseline.
+	ShadowExactRate float64
+	// ShadowExactTimeout bounds each shadow exact run.
+	ShadowExactTimeout time.Duration
+	// VerifyRate is the fraction of recorded approximate answers the
+	// verifier re-runs exactly to learn their true error.
+	VerifyRate float64
+	// VerifyInterval is how often the verifier works through its queue (0
+	// disables verification).
+	VerifyInterval time.Duration
+	// AuditInterval is how often the auditor checks the verified errors of
+	// the last AuditDays days against their tolerances (0 disables it). A
+	// strategy's answers on a table raise an alert once at least
+	// AuditMinRuns were verified and more than AuditBreachRate of them
+	// broke their tolerance.
+	AuditInterval   time.Duration
+	AuditDays       int
+	AuditMinRuns    int
+	AuditBreachRate float64
+	// SafeMode restricts the whole server to exact, read-only behaviour: no
+	// rewrites, no sample or sketch creation, no ML recording.
+	SafeMode bool
+	// Passthrough runs SELECTs the planner cannot parse verbatim against
+	// the read-only handle, tagged as not optimized and not approximated,
+	// instead of rejecting them. Without it, only admin keys can ask for
+	// passthrough, per request.
+	Passthrough bool
+	// DisabledStrategies are planner strategies (see planner.Strategies)
+	// switched off unless a strategy flag set through /strategies turns
+	// them back on.
+	DisabledStrategies []string
+	// MaintenanceInterval is how often samples and sketches are checked for
+	// deletes on their base tables (0 disables the background check).
+	MaintenanceInterval time.Duration
+	// MaintenanceWindow is when heavy background work on tables without a
+	// window of their own may run, as "01:00-05:00" in local time (""
+	// for any time; see scheduleMaintenance).
+	MaintenanceWindow string
+	// RefreshDrift is how far, as a fraction of the rows it was built on,
+	// a table's row count may drift before maintenance redraws its samples
+	// and rebuilds its sketches, for tables without a refresh policy of
+	// their own (0 disables drift refreshes).
+	RefreshDrift float64
+	// MaxImportBytes caps the size of a synopsis export accepted by
+	// POST /synopses/import.
+	MaxImportBytes int64
+	// ArchiveAfter is how long a sample goes unused before maintenance
+	// moves it to the archive file (0 disables archiving in the background).
+	ArchiveAfter time.Duration
+	// ArchivePath is the SQLite file cold samples are moved to; empty means
+	// the database file's name with an .archive suffix.
+	ArchivePath string
+	// DropRetention is how long a dropped sample or sketch can be restored
+	// before maintenance purges it.
+	DropRetention time.Duration
+	// StorageBudgetBytes caps the bytes samples and sketches may take up
+	// in the main database when no budget is registered through the API
+	// (0 = unlimited); maintenance shrinks or drops the least used of
+	// them to fit.
+	StorageBudgetBytes int64
+	// AnalyzeInterval is how often maintenance runs a full ANALYZE; the
+	// passes in between run PRAGMA optimize (0 disables both).
+	AnalyzeInterval time.Duration
+	// MaxHeavyQueries bounds the exact and hybrid queries running at once;
+	// more wait their turn by priority (0 = unbounded).
+	MaxHeavyQueries int
+	// MaxEscalations bounds how many times a sample answer whose intervals
+	// miss the tolerance is re-run on a larger sample or exactly (0
+	// disables re-running).
+	MaxEscalations int
+	// TemplateRunHistory is how many answers of each saved template are
+	// kept for diffs (0 keeps none).
+	TemplateRunHistory int
+	// WarmupQueries is how many of the most run saved templates startup
+	// warm-up runs again to fill the result cache (0 runs none).
+	WarmupQueries int
+	// APIKeys, when set, turns on access control: every endpoint but
+	// /health needs a key granting its role (see Handler.require).
+	APIKeys []APIKey
+}
+
+func configFromEnv() Config {
+	cfg := Config{
+		MaxQueryMemoryBytes: 512 << 20,
+		ExternalChunkRows:   1_000_000,
+		ComplexityThreshold: planner.DefaultComplexityThreshold,
+		ResultCacheEntries:  128,
+		JoinCacheEntries:    16,
+		JoinCacheTTL:        10 * time.Minute,
+		JoinCacheMaxRows:    1_000_000,
+		MaxResultGroups:     100_000,
+		QueryLimits:         planner.DefaultQueryLimits,
+		PageSpoolEntries:    32,
+		PageSpoolTTL:        15 * time.Minute,
+		MaxJobs:             64,
+		JobResultTTL:        time.Hour,
+		ShadowExactRate:     0.05,
+		ShadowExactTimeout:  60 * time.Second,
+		VerifyRate:          0.05,
+		VerifyInterval:      time.Minute,
+		AuditInterval:       15 * time.Minute,
+		AuditDays:           7,
+		AuditMinRuns:        20,
+		AuditBreachRate:     0.1,
+		MaintenanceInterval: 10 * time.Minute,
+		RefreshDrift:        storage.StaleRowDrift,
+		MaxImportBytes:      1 << 30,
+		AnalyzeInterval:     24 * time.Hour,
+		DropRetention:       7 * 24 * time.Hour,
+		MaxHeavyQueries:     4,
+		MaxEscalations:      2,
+		TemplateRunHistory:  100,
+		WarmupQueries:       10,
+	}
+	if v := os.Getenv("AQE_MAX_QUERY_MEMORY_MB"); v != "" {
+		if mb, err := strconv.ParseInt(v, 10, 64); err == nil && mb >= 0 {
+			cfg.MaxQueryMemoryBytes = mb << 20
+		}
+	}
+	if v := os.Getenv("AQE_EXTERNAL_CHUNK_ROWS"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			cfg.ExternalChunkRows = n
+		}
+	}
+	if v := os.Getenv("AQE_COMPLEXITY_THRESHOLD"); v != "" {
+		if t, err := strconv.ParseFloat(v, 64); err == nil && t >= 0 {
+			cfg.ComplexityThreshold = t
+		}
+	}
+	if v := os.Getenv("AQE_RESULT_CACHE_ENTRIES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			cfg.ResultCacheEntries = n
+		}
+	}
+	if v := os.Getenv("AQE_JOIN_CACHE_ENTRIES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			cfg.JoinCacheEntries = n
+		}
+	}
+	if v := os.Getenv("AQE_JOIN_CACHE_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			cfg.JoinCacheTTL = d
+		}
+	}
+	if v := os.Getenv("AQE_JOIN_CACHE_MAX_ROWS"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+			cfg.JoinCacheMaxRows = n
+		}
+	}
+	if v := os.Getenv("AQE_MAX_RESULT_GROUPS"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+			cfg.MaxResultGroups = n
+		}
+	}
+	if v := os.Getenv("AQE_MAX_JOINS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			cfg.QueryLimits.MaxJoins = n
+		}
+	}
+	if v := os.Getenv("AQE_MAX_EXPRESSION_DEPTH"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			cfg.QueryLimits.MaxExpressionDepth = n
+		}
+	}
+	if v := os.Getenv("AQE_MAX_TABLES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			cfg.QueryLimits.MaxTables = n
+		}
+	}
+	if v := os.Getenv("AQE_PAGE_SPOOL_ENTRIES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			cfg.PageSpoolEntries = n
+		}
+	}
+	if v := os.Getenv("AQE_PAGE_SPOOL_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			cfg.PageSpoolTTL = d
+		}
+	}
+	if v := os.Getenv("AQE_MAX_JOBS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			cfg.MaxJobs = n
+		}
+	}
+	if v := os.Getenv("AQE_JOB_RESULT_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			cfg.JobResultTTL = d
+		}
+	}
+	if v := os.Getenv("AQE_SHADOW_EXACT_PERCENT"); v != "" {
+		if pct, err := strconv.ParseFloat(v, 64); err == nil && pct >= 0 && pct <= 100 {
+			cfg.ShadowExactRate = pct / 100
+		}
+	}
+	if v := os.Getenv("AQE_VERIFY_PERCENT"); v != "" {
+		if pct, err := strconv.ParseFloat(v, 64); err == nil && pct >= 0 && pct <= 100 {
+			cfg.VerifyRate = pct / 100
+		}
+	}
+	if v := os.Getenv("AQE_VERIFY_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			cfg.VerifyInterval = d
+		}
+	}
+	if v := os.Getenv("AQE_AUDIT_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			cfg.AuditInterval = d
+		}
+	}
+	if v := os.Getenv("AQE_AUDIT_DAYS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			cfg.AuditDays = n
+		}
+	}
+	if v := os.Getenv("AQE_AUDIT_MIN_RUNS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			cfg.AuditMinRuns = n
+		}
+	}
+	if v := os.Getenv("AQE_AUDIT_BREACH_PERCENT"); v != "" {
+		if pct, err := strconv.ParseFloat(v, 64); err == nil && pct >= 0 && pct <= 100 {
+			cfg.AuditBreachRate = pct / 100
+		}
+	}
+	if v := os.Getenv("AQE_SAFE_MODE"); v != "" {
+		if on, err := strconv.ParseBool(v); err == nil {
+			cfg.SafeMode = on
+		}
+	}
+	if v := os.Getenv("AQE_PASSTHROUGH"); v != "" {
+		if on, err := strconv.ParseBool(v); err == nil {
+			cfg.Passthrough = on
+		}
+	}
+	for _, name := range strings.Split(os.Getenv("AQE_DISABLED_STRATEGIES"), ",") {
+		if name = strings.TrimSpace(name); planner.KnownStrategy(name) {
+			cfg.DisabledStrategies = append(cfg.DisabledStrategies, name)
+		}
+	}
+	if v := os.Getenv("AQE_MAINTENANCE_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			cfg.MaintenanceInterval = d
+		}
+	}
+	if v := os.Getenv("AQE_MAINTENANCE_WINDOW"); v != "" {
+		if _, err := parseMaintenanceHours(v); err == nil {
+			cfg.MaintenanceWindow = v
+		}
+	}
+	if v := os.Getenv("AQE_REFRESH_DRIFT"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 {
+			cfg.RefreshDrift = f
+		}
+	}
+	if v := os.Getenv("AQE_MAX_IMPORT_MB"); v != "" {
+		if mb, err := strconv.ParseInt(v, 10, 64); err == nil && mb > 0 {
+			cfg.MaxImportBytes = mb << 20
+		}
+	}
+	if v := os.Getenv("AQE_ARCHIVE_AFTER"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			cfg.ArchiveAfter = d
+		}
+	}
+	cfg.ArchivePath = os.Getenv("AQE_ARCHIVE_PATH")
+	if v := os.Getenv("AQE_DROP_RETENTION"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			cfg.DropRetention = d
+		}
+	}
+	if v := os.Getenv("AQE_STORAGE_BUDGET_MB"); v != "" {
+		if mb, err := strconv.ParseInt(v, 10, 64); err == nil && mb >= 0 {
+			cfg.StorageBudgetBytes = mb << 20
+		}
+	}
+	if v := os.Getenv("AQE_ANALYZE_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			cfg.AnalyzeInterval = d
+		}
+	}
+	if v := os.Getenv("AQE_MAX_HEAVY_QUERIES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			cfg.MaxHeavyQueries = n
+		}
+	}
+	if v := os.Getenv("AQE_MAX_ESCALATIONS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			cfg.MaxEscalations = n
+		}
+	}
+	if v := os.Getenv("AQE_TEMPLATE_RUN_HISTORY"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			cfg.TemplateRunHistory = n
+		}
+	}
+	if v := os.Getenv("AQE_WARMUP_QUERIES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			cfg.WarmupQueries = n
+		}
+	}
+	if v, ok := os.LookupEnv("AQE_API_KEYS"); ok {
+		cfg.APIKeys = parseAPIKeys(v)
+	}
+	return cfg
+}
@@ -0,0 +1,232 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// ColumnCoverage lists the synopses built on one column, and its declared
+// role if it has one.
+type ColumnCoverage struct {
+	Name             string   `json:"name"`
+	Role             string   `json:"role,omitempty"`
+	Sketches         []string `json:"sketches,omitempty"`
+	DegradedSketches []string `json:"degraded_sketches,omitempty"`
+	StrataSamples    []string `json:"strata_samples,omitempty"`
+}
+
+// PatternCoverage is one recorded query pattern and the synopses it would
+// need but lacks. Blocked means none of its candidate synopses exist, so
+// the planner had no choice but exact.
+type PatternCoverage struct {
+	Pattern   string                 `json:"pattern"`
+	Runs      int64                  `json:"runs"`
+	ExactRuns int64                  `json:"exact_runs"`
+	Missing   []planner.SynopsisNeed `json:"missing,omitempty"`
+	Blocked   bool                   `json:"blocked"`
+}
+
+// CoverageGap is a missing synopsis and the recorded work it would have
+// accelerated. EstimatedSavedMs is exact runs × average time × (1 −
+// 1/speedup) over the blocked patterns; gaps that are alternatives for the
+// same pattern overlap, so their savings don't add up.
+type CoverageGap struct {
+	Need             planner.SynopsisNeed `json:"need"`
+	Patterns         []string             `json:"patterns"`
+	ExactRuns        int64                `json:"exact_runs"`
+	EstimatedSpeedup float64              `json:"estimated_speedup"`
+	EstimatedSavedMs float64              `json:"estimated_saved_ms"`
+}
+
+// CoverageReport is the body of GET /tables/{name}/coverage.
+type CoverageReport struct {
+	Table    string               `json:"table"`
+	RowCount int64                `json:"row_count"`
+	HasStats bool                 `json:"has_stats"`
+	Columns  []ColumnCoverage     `json:"columns"`
+	Samples  []storage.SampleInfo `json:"samples"`
+	Patterns []PatternCoverage    `json:"patterns"`
+	Gaps     []CoverageGap        `json:"gaps"`
+}
+
+func (h *Handler) GetTableCoverage(w http.ResponseWriter, r *http.Request) {
+	table := mux.Vars(r)["name"]
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	exists, err := storage.TableExists(ctx, h.db, table)
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	if !exists {
+		writeJSON(w, http.StatusNotFound, JSON{"error": "no such table: " + table})
+		return
+	}
+
+	report, err := h.tableCoverage(ctx, table)
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "coverage": report})
+}
+
+func (h *Handler) tableCoverage(ctx context.Context, table string) (*CoverageReport, error) {
+	report := &CoverageReport{Table: table, Columns: []ColumnCoverage{}, Patterns: []PatternCoverage{}, Gaps: []CoverageGap{}}
+
+	rowCount, err := storage.RecordedRowCount(ctx, h.db, table)
+	if err != nil {
+		return nil, err
+	}
+	report.HasStats = rowCount > 0
+	if !report.HasStats {
+		if rowCount, err = storage.CountRows(ctx, h.db, table); err != nil {
+			return nil, err
+		}
+	}
+	report.RowCount = rowCount
+
+	sketchList, err := storage.ListSketches(ctx, h.db, table)
+	if err != nil {
+		return nil, err
+	}
+	if report.Samples, err = storage.ListSamples(ctx, h.db, table); err != nil {
+		return nil, err
+	}
+	if report.Samples == nil {
+		report.Samples = []storage.SampleInfo{}
+	}
+	for i, s := range report.Samples {
+		if s.ReservoirSize > 0 {
+			if report.Samples[i].LagRows, err = storage.ReservoirLag(ctx, h.db, table, s.ScannedRowid); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// what the planner can use right now
+	available := make(map[planner.SynopsisNeed]bool)
+	for _, sk := range sketchList {
+		if !sk.Degraded {
+			available[planner.SynopsisNeed{Kind: string(sk.Type), Column: sk.Column}] = true
+		}
+	}
+	var uniform []storage.SampleInfo
+	for _, s := range report.Samples {
+		if s.StrataColumn == "" && s.Predicate == "" && s.OutlierTable == "" && s.UniverseColumn == "" {
+			uniform = append(uniform, s)
+		}
+	}
+
+	tableCols, err := storage.TableColumns(ctx, h.db, table)
+	if err != nil {
+		return nil, err
+	}
+	roles, err := storage.GetTableRoles(ctx, h.db, table)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range tableCols {
+		c := ColumnCoverage{Name: name, Role: roles.Role(name)}
+		for _, sk := range sketchList {
+			if sk.Column != c.Name {
+				continue
+			}
+			if sk.Degraded {
+				c.DegradedSketches = append(c.DegradedSketches, string(sk.Type))
+			} else {
+				c.Sketches = append(c.Sketches, string(sk.Type))
+			}
+		}
+		for _, s := range report.Samples {
+			if s.StrataColumn == c.Name {
+				c.StrataSamples = append(c.StrataSamples, s.SampleTable)
+			}
+		}
+		report.Columns = append(report.Columns, c)
+	}
+
+	patterns, err := h.learner.TablePatterns(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+	p := planner.New()
+	p.SetComplexityThreshold(h.config.ComplexityThreshold)
+	// a pattern can use a sample only if it copied the columns it reads
+	sampleCovers := func(pattern string) bool {
+		_, cols, all := p.SampleColumns(pattern)
+		for _, s := range uniform {
+			if planner.SampleCovers(s.Columns, cols, all, tableCols) {
+				return true
+			}
+		}
+		return false
+	}
+	gaps := make(map[planner.SynopsisNeed]*CoverageGap)
+	for _, u := range patterns {
+		pc := PatternCoverage{Pattern: u.Pattern, Runs: u.Runs, ExactRuns: u.ExactRuns}
+		_, needs := p.NeededSynopses(u.Pattern)
+		covered := false
+		for _, need := range needs {
+			if available[need] || need.Kind == "sample" && sampleCovers(u.Pattern) {
+				covered = true
+			} else {
+				pc.Missing = append(pc.Missing, need)
+			}
+		}
+		pc.Blocked = len(needs) > 0 && !covered
+		report.Patterns = append(report.Patterns, pc)
+		if !pc.Blocked || u.ExactRuns == 0 {
+			continue
+		}
+
+		for _, need := range pc.Missing {
+			if !fitsRole(need, roles) {
+				continue
+			}
+			g := gaps[need]
+			if g == nil {
+				g = &CoverageGap{Need: need, EstimatedSpeedup: p.SynopsisSpeedup(need, rowCount)}
+				gaps[need] = g
+			}
+			g.Patterns = append(g.Patterns, u.Pattern)
+			g.ExactRuns += u.ExactRuns
+			g.EstimatedSavedMs += float64(u.ExactRuns) * u.AvgExecTime * (1 - 1/g.EstimatedSpeedup)
+		}
+	}
+
+	for _, g := range gaps {
+		report.Gaps = append(report.Gaps, *g)
+	}
+	sort.Slice(report.Gaps, func(i, j int) bool {
+		return report.Gaps[i].EstimatedSavedMs > report.Gaps[j].EstimatedSavedMs
+	})
+	return report, nil
+}
+
+// fitsRole reports whether need suits its column's declared role: the
+// planner takes the first GROUP BY key of any small grouping for a heavy
+// hitter and any quantile argument for a distribution, but frequencies are
+// only worth sketching for dimensions and quantiles for measures. Columns
+// of tables without roles, and those without one, fit anything.
+func fitsRole(need planner.SynopsisNeed, roles *storage.TableRoles) bool {
+	role := roles.Role(need.Column)
+	switch {
+	case role == "":
+		return true
+	case need.Kind == "countmin":
+		return role == storage.RoleDimension
+	case need.Kind == "tdigest":
+		return role == storage.RoleMeasure
+	}
+	return true
+}
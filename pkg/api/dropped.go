@@ -0,0 +1,191 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// DroppedSynopsis is a dropped sample or sketch and when it will be
+// purged unless restored.
+type DroppedSynopsis struct {
+	storage.DroppedSynopsis
+	PurgeAfter time.Time `json:"purge_after"`
+}
+
+func (h *Handler) dropped(d storage.DroppedSynopsis) DroppedSynopsis {
+	return DroppedSynopsis{DroppedSynopsis: d, PurgeAfter: d.DroppedAt.Add(h.config.DropRetention)}
+}
+
+// DeleteSample drops a sample table. It can be restored with POST
+// /synopses/{id}/restore until DropRetention has passed. The remains of a
+// sample whose table is gone, and orphan sample tables, are dropped for
+// good.
+func (h *Handler) DeleteSample(w http.ResponseWriter, r *http.Request) {
+	if h.rejectInSafeMode(w) {
+		return
+	}
+	sampleTable := mux.Vars(r)["name"]
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+
+	// an archived sample is brought back first, so its rows are kept too
+	h.archiveMu.Lock()
+	defer h.archiveMu.Unlock()
+	var d *storage.DroppedSynopsis
+	err := h.guard.Do(ctx, func(ctx context.Context) error {
+		if err := storage.RestoreSample(ctx, h.db, sampleTable); err != nil {
+			return err
+		}
+		var dropErr error
+		d, dropErr = storage.DropSampleSoft(ctx, h.db, sampleTable)
+		return dropErr
+	})
+	if errors.Is(err, storage.ErrNoSynopsis) {
+		h.dropUnrestorableSample(ctx, w, sampleTable, err)
+		return
+	}
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	h.sampleUse.Delete(sampleTable)
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "dropped": h.dropped(*d)})
+}
+
+// dropUnrestorableSample drops what is left of a sample DeleteSample
+// couldn't set aside, notFound saying why: the records of one whose table
+// is gone, strata included, or an orphan table with no record at all (see
+// storage.FindOrphanSampleTables). Neither can be restored.
+func (h *Handler) dropUnrestorableSample(ctx context.Context, w http.ResponseWriter, sampleTable string, notFound error) {
+	var kind string
+	err := h.guard.Do(ctx, func(ctx context.Context) error {
+		info, err := storage.LookupSample(ctx, h.db, sampleTable)
+		if err != nil {
+			return err
+		}
+		if info != nil {
+			kind = "records"
+			return storage.DropSample(ctx, h.db, sampleTable)
+		}
+		dropped, err := storage.DropOrphanSampleTable(ctx, h.db, sampleTable)
+		if dropped {
+			kind = "orphan_table"
+		}
+		return err
+	})
+	switch {
+	case err != nil:
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+	case kind == "":
+		writeJSON(w, http.StatusNotFound, JSON{"error": notFound.Error()})
+	default:
+		h.sampleUse.Delete(sampleTable)
+		writeJSON(w, http.StatusOK, JSON{"status": "ok", "removed": kind, "sample_table": sampleTable})
+	}
+}
+
+// DeleteSketch drops the ?sketch_type= sketch on ?table= and ?column=. It
+// can be restored with POST /synopses/{id}/restore until DropRetention has
+// passed.
+func (h *Handler) DeleteSketch(w http.ResponseWriter, r *http.Request) {
+	if h.rejectInSafeMode(w) {
+		return
+	}
+	q := r.URL.Query()
+	table, column, sketchType := q.Get("table"), q.Get("column"), q.Get("sketch_type")
+	if table == "" || sketchType == "" {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "table and sketch_type parameters required"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+
+	var d *storage.DroppedSynopsis
+	err := h.guard.Do(ctx, func(ctx context.Context) error {
+		var dropErr error
+		d, dropErr = storage.DropSketchSoft(ctx, h.db, table, column, storage.SketchType(sketchType))
+		return dropErr
+	})
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "dropped": h.dropped(*d)})
+}
+
+// GetDroppedSynopses lists the dropped samples and sketches that can still
+// be restored.
+func (h *Handler) GetDroppedSynopses(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	list, err := storage.ListDropped(ctx, h.db)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
+		return
+	}
+	dropped := make([]DroppedSynopsis, len(list))
+	for i, d := range list {
+		dropped[i] = h.dropped(d)
+	}
+	writeJSON(w, http.StatusOK, JSON{
+		"status":    "ok",
+		"retention": h.config.DropRetention.String(),
+		"dropped":   dropped,
+	})
+}
+
+// PostRestoreSynopsis brings back the dropped sample or sketch {id}.
+func (h *Handler) PostRestoreSynopsis(w http.ResponseWriter, r *http.Request) {
+	if h.rejectInSafeMode(w) {
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "id must be an integer"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+
+	var d *storage.DroppedSynopsis
+	err = h.guard.Do(ctx, func(ctx context.Context) error {
+		var restoreErr error
+		d, restoreErr = storage.RestoreSynopsis(ctx, h.db, id)
+		return restoreErr
+	})
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "restored": d})
+}
+
+// purgeDropped removes the synopses dropped more than DropRetention ago.
+func (h *Handler) purgeDropped(timeout time.Duration) {
+	if h.config.SafeMode {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var purged []storage.DroppedSynopsis
+	err := h.guard.Do(ctx, func(ctx context.Context) error {
+		var purgeErr error
+		purged, purgeErr = storage.PurgeDropped(ctx, h.db, time.Now().Add(-h.config.DropRetention))
+		return purgeErr
+	})
+	if err != nil {
+		log.Printf("purging dropped synopses: %v", err)
+	}
+	for _, d := range purged {
+		log.Printf("purged dropped %s %s on %s", d.Kind, d.Name, d.Table)
+	}
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/executor"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+)
+
+// Escalation records a plan whose realized error missed the tolerance, so
+// the query was re-run on a larger sample or exactly.
+type Escalation struct {
+	PlanType       planner.PlanType `json:"plan_type"`
+	SampleTable    string           `json:"sample_table,omitempty"`
+	SampleFraction float64          `json:"sample_fraction,omitempty"`
+	// RealizedRelError is the largest relative error of the plan's
+	// intervals; TargetRelError, the tolerance at the planner's
+	// calibration level it had to meet.
+	RealizedRelError float64 `json:"realized_rel_error"`
+	TargetRelError   float64 `json:"target_rel_error"`
+	// Next is the plan type it escalated to; Error is set when that plan
+	// failed and this plan's answer was kept.
+	Next  planner.PlanType `json:"next"`
+	Error string           `json:"error,omitempty"`
+}
+
+// escalate re-runs a sample plan whose intervals came out wider than its
+// tolerance, up to Config.MaxEscalations times, each time on the next
+// larger sample or finally exactly, and returns the last answer. The chain
+// of missed plans goes in the answer's meta as "escalations". If a re-run
+// fails the answer before it is kept.
+func (h *Handler) escalate(ctx context.Context, p *planner.Planner, plan *planner.Plan, rows *executor.ResultSet, meta map[string]any,
+	execute func(*planner.Plan) (*executor.ResultSet, map[string]any, error)) (*planner.Plan, *executor.ResultSet, map[string]any, error) {
+	var chain []Escalation
+	for len(chain) < h.config.MaxEscalations && plan.Type == planner.PlanSample {
+		worst, bounded := maxResultRelError(rows)
+		target := planner.ToleranceAt(plan.MaxRelError, plan.Level())
+		if !bounded || worst <= target {
+			break
+		}
+		next, err := p.Escalate(ctx, h.readDB, plan)
+		if err != nil || next == nil {
+			break
+		}
+		step := Escalation{
+			PlanType:         plan.Type,
+			SampleTable:      plan.SampleTable,
+			SampleFraction:   plan.SampleFraction,
+			RealizedRelError: worst,
+			TargetRelError:   target,
+			Next:             next.Type,
+		}
+		nextRows, nextMeta, err := execute(next)
+		if err != nil {
+			step.Error = err.Error()
+			chain = append(chain, step)
+			break
+		}
+		chain = append(chain, step)
+		plan, rows, meta = next, nextRows, nextMeta
+	}
+	if len(chain) > 0 {
+		meta["escalations"] = chain
+	}
+	return plan, rows, meta, nil
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+	_ "modernc.org/sqlite"
+)
+
+// TestEscalateScalarSum runs an ungrouped SUM over a skewed column with a
+// tolerance the 1% sample is planned to meet but, by its realized
+// interval, misses: the answer must come from the 5% sample.
+func TestEscalateScalarSum(t *testing.T) {
+	t.Setenv("AQE_VERIFY_INTERVAL", "0")
+	t.Setenv("AQE_MAINTENANCE_INTERVAL", "0")
+	path := filepath.Join(t.TempDir(), "aqe.db")
+	db, err := storage.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := storage.EnsureMetaTables(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE TABLE purchases (id INTEGER PRIMARY KEY, amount REAL);
+		WITH RECURSIVE n(i) AS (SELECT 0 UNION ALL SELECT i + 1 FROM n WHERE i < 99999)
+		INSERT INTO purchases SELECT i, (i % 100) * (i % 100) * (i % 100) FROM n`); err != nil {
+		t.Fatal(err)
+	}
+	readDB, err := storage.OpenReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readDB.Close()
+	r := mux.NewRouter()
+	RegisterRoutes(r, db, readDB)
+
+	post := func(path, body string, out any) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("POST %s: %d %s", path, w.Code, w.Body)
+		}
+		if out != nil {
+			if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	post("/samples/create", `{"table": "purchases", "sample_fraction": 0.01}`, nil)
+	post("/samples/create", `{"table": "purchases", "sample_fraction": 0.05}`, nil)
+
+	var resp struct {
+		Meta struct {
+			SampleFraction float64      `json:"sample_fraction"`
+			Escalations    []Escalation `json:"escalations"`
+		} `json:"meta"`
+		Result []map[string]float64 `json:"result"`
+	}
+	post("/query", `{"sql": "SELECT SUM(amount) AS total FROM purchases", "max_rel_error": 0.035}`, &resp)
+
+	if len(resp.Meta.Escalations) != 1 {
+		t.Fatalf("escalations %+v, want one", resp.Meta.Escalations)
+	}
+	step := resp.Meta.Escalations[0]
+	if step.SampleFraction != 0.01 || step.Next != "sample" || step.RealizedRelError <= step.TargetRelError {
+		t.Errorf("escalation %+v, want the 1%% sample missing its target for another sample", step)
+	}
+	if resp.Meta.SampleFraction != 0.05 {
+		t.Errorf("answered from the %v sample, want 0.05", resp.Meta.SampleFraction)
+	}
+	if len(resp.Result) != 1 {
+		t.Fatalf("result %v, want one row", resp.Result)
+	}
+	if rel := resp.Result[0]["total_rel_error"]; rel <= 0 || rel > 0.035 {
+		t.Errorf("total_rel_error %v, want within (0, 0.035]", rel)
+	}
+}
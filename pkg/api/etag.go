@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// readsBaseTables reports whether plan reads the tables themselves, not
+// only their synopses: exact and hybrid plans, and wander joins' random
+// walks. Their answers move with every write to any table the statement
+// reads, which no synopsis version tracks, so they get no fingerprint.
+func readsBaseTables(plan *planner.Plan) bool {
+	return plan.Type == planner.PlanExact || plan.Type == planner.PlanHybrid || plan.WanderJoin != nil
+}
+
+// queryFingerprint derives a strong ETag for a /query response from the
+// chosen plan and the versions of the statistics and sample it reads. A
+// new sample or refreshed stats yields a new fingerprint, which is also
+// what invalidates the result cache. Sketch joins also fingerprint the
+// query and the sketched table's statistics, universe joins the joined
+// table's statistics and sample, and synopsis joins the join synopsis.
+// Only plans answered from synopses alone are fingerprinted (see
+// readsBaseTables).
+func (h *Handler) queryFingerprint(ctx context.Context, req QueryRequest, plan *planner.Plan) (string, error) {
+	statsVersion, sampleVersion, err := storage.TableVersions(ctx, h.readDB, plan.Table, plan.SampleTable)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.New()
+	fmt.Fprintf(sum, "%s\x00%s\x00%s\x00%g\x00%s\x00%s\x00%t",
+		plan.Type, plan.SQL, plan.SampleTable, plan.SampleFraction,
+		statsVersion, sampleVersion, req.UseMLOptimization)
+	// a tracked table's count moves with every insert and delete
+	if n, ok, err := storage.ExactRowCount(ctx, h.readDB, plan.Table); err == nil && ok {
+		fmt.Fprintf(sum, "\x00%d", n)
+	}
+	if plan.ResultLimit > 0 {
+		// the executor applies the LIMIT moved out of the SQL
+		fmt.Fprintf(sum, "\x00%d\x00%g", plan.ResultLimit, plan.RankConfidence)
+	}
+	if j := plan.SketchJoin; j != nil {
+		// the sample's SQL doesn't say how the sketched side is joined
+		sketchedVersion, _, err := storage.TableVersions(ctx, h.readDB, j.Table, "")
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(sum, "\x00%s\x00%s", plan.OriginalSQL, sketchedVersion)
+	}
+	if plan.JoinSampleTable != "" {
+		joinedVersion, joinSampleVersion, err := storage.TableVersions(ctx, h.readDB, plan.JoinTable, plan.JoinSampleTable)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(sum, "\x00%s\x00%s", joinedVersion, joinSampleVersion)
+	}
+	if plan.JoinSynopsis != "" {
+		synopsisVersion, err := storage.JoinSynopsisVersion(ctx, h.readDB, plan.JoinSynopsis)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(sum, "\x00%s", synopsisVersion)
+	}
+	return `"` + hex.EncodeToString(sum.Sum(nil)[:16]) + `"`, nil
+}
+
+// etagMatches reports whether an If-None-Match header value names etag.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// resultCache keeps recent /query responses keyed by fingerprint. It
+// holds only answers read from synopses, which change only with a new
+// plan, sample or stats version; any of those changes the key, and the old
+// entry ages out in FIFO order.
+type resultCache struct {
+	mu      sync.Mutex
+	max     int
+	entries map[string]QueryResponse
+	order   []string
+}
+
+func newResultCache(max int) *resultCache {
+	return &resultCache{max: max, entries: make(map[string]QueryResponse)}
+}
+
+func (c *resultCache) get(key string) (QueryResponse, bool) {
+	if c == nil || c.max <= 0 {
+		return QueryResponse{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	resp, ok := c.entries[key]
+	return resp, ok
+}
+
+func (c *resultCache) put(key string, resp QueryResponse) {
+	if c == nil || c.max <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok {
+		c.order = append(c.order, key)
+	}
+	c.entries[key] = resp
+	for len(c.order) > c.max {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// writeNotModified answers a conditional /query whose fingerprint matched.
+func writeNotModified(w http.ResponseWriter, etag string) {
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(http.StatusNotModified)
+}
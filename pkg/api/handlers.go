@@ -2,10 +2,14 @@ package api
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"maps"
 	"math"
+	"math/rand"
 	"net/http"
 	"strconv"
 	"strings"
@@ -20,43 +24,138 @@ import (
 )
 
 func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
-	writeJSON(w, http.StatusOK, JSON{"status": "ok"})
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "storage_breaker": h.guard.BreakerState(), "safe_mode": h.config.SafeMode})
 }
 
 func (h *Handler) ListTables(w http.ResponseWriter, r *http.Request) {
-	rows, err := h.db.Query(`SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%' ORDER BY 1`)
-	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
-		return
-	}
-	defer rows.Close()
 	var tables []string
-	for rows.Next() {
-		var name string
-		if err := rows.Scan(&name); err != nil {
-			continue
+	err := h.guard.Do(r.Context(), func(ctx context.Context) error {
+		rows, err := h.db.QueryContext(ctx, storage.ActiveDialect().ListTablesQuery())
+		if err != nil {
+			return err
 		}
-		tables = append(tables, name)
+		defer rows.Close()
+		tables = tables[:0]
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				continue
+			}
+			tables = append(tables, name)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
 	}
 	writeJSON(w, http.StatusOK, JSON{"tables": tables})
 }
 
 type QueryRequest struct {
-	SQL               string  `json:"sql"`
-	MaxRelError       float64 `json:"max_rel_error"`
-	PreferExact       bool    `json:"prefer_exact"`
+	SQL         string  `json:"sql"`
+	MaxRelError float64 `json:"max_rel_error"`
+	PreferExact bool    `json:"prefer_exact"`
+	// ConfidenceLevel is the level MaxRelError and reported intervals are
+	// at, in (0, 1); planner.DefaultConfidenceLevel when unset.
+	ConfidenceLevel float64 `json:"confidence_level,omitempty"`
+	// RankConfidence, in (0, 1), grows top-N answers over samples with the
+	// next-ranked groups until they hold the true top N with that
+	// probability (see planner.SetRankConfidence).
+	RankConfidence    float64 `json:"rank_confidence,omitempty"`
 	UseMLOptimization bool    `json:"use_ml_optimization"`
 	Explain           bool    `json:"explain"`
+	SafeMode          bool    `json:"safe_mode"`
+	// Passthrough enables Config.Passthrough for this request; only admin
+	// keys may set it.
+	Passthrough bool `json:"passthrough"`
+	// Progressive streams refinements from growing subsets of the table
+	// before the exact answer; ProgressiveFractions overrides the subsets.
+	Progressive          bool      `json:"progressive"`
+	ProgressiveFractions []float64 `json:"progressive_fractions,omitempty"`
+	// Priority is the scheduling class of the query's heavy (exact) work:
+	// interactive (default), batch or background.
+	Priority string `json:"priority,omitempty"`
+	// PageSize, when set, answers with at most that many rows and a
+	// next_page_token; the whole result is spooled for a while, and a
+	// request with only PageToken set (and optionally PageSize) reads the
+	// page it points to.
+	PageSize  int    `json:"page_size,omitempty"`
+	PageToken string `json:"page_token,omitempty"`
+
+	// pattern, when set, is the ML pattern key the query is learned under
+	// instead of its SQL (see ml.WithQueryPattern).
+	pattern string
+	// onAnswer, when set, is given each successful answer before it is
+	// written.
+	onAnswer func(*QueryResponse)
+	// warmup marks a startup warm-up run, which is answered and cached
+	// like any other but learned from and recorded like none.
+	warmup bool
+	// passthrough is Passthrough once resolvePassthrough has let it
+	// through; the planner never sees Passthrough itself.
+	passthrough bool
 }
 
 type QueryResponse struct {
 	Status            string                `json:"status"`
 	Plan              *planner.Plan         `json:"plan,omitempty"`
-	Result            []map[string]any      `json:"result,omitempty"`
+	Result            *executor.ResultSet   `json:"result,omitempty"`
 	Meta              map[string]any        `json:"meta,omitempty"`
 	Error             string                `json:"error,omitempty"`
 	MLOptimization    *ml.QueryOptimization `json:"ml_optimization,omitempty"`
 	StatisticalBounds *ml.StatisticalBounds `json:"statistical_bounds,omitempty"`
+	// NextPageToken points to the next page of a paginated result.
+	NextPageToken string `json:"next_page_token,omitempty"`
+}
+
+// prepareQuery validates req and applies the accuracy clause and hints in
+// its SQL to its fields; the planner applies them too, this keeps recording
+// and progressive stopping in step. It returns the query's priority and
+// hints.
+func prepareQuery(req *QueryRequest) (Priority, planner.Hints, error) {
+	req.SQL = strings.TrimSpace(req.SQL)
+	if req.SQL == "" {
+		return "", planner.Hints{}, errors.New("sql required")
+	}
+	priority, err := parsePriority(req.Priority)
+	if err != nil {
+		return "", planner.Hints{}, err
+	}
+	if req.ConfidenceLevel < 0 || req.ConfidenceLevel >= 1 {
+		return "", planner.Hints{}, errors.New("confidence_level must be in (0, 1)")
+	}
+	if req.RankConfidence < 0 || req.RankConfidence >= 1 {
+		return "", planner.Hints{}, errors.New("rank_confidence must be in (0, 1)")
+	}
+
+	sqlText, accuracy, err := planner.StripAccuracyClause(req.SQL)
+	if err != nil {
+		return "", planner.Hints{}, err
+	}
+	req.SQL = sqlText
+	req.MaxRelError, req.ConfidenceLevel = accuracy.Override(req.MaxRelError, req.ConfidenceLevel)
+	hints, err := planner.ParseHints(req.SQL)
+	if err != nil {
+		return "", planner.Hints{}, err
+	}
+	req.MaxRelError, req.PreferExact = hints.Override(req.MaxRelError, req.PreferExact)
+	return priority, hints, nil
+}
+
+// errPassthroughRole refuses passthrough asked for by a key without the
+// admin role.
+var errPassthroughRole = errors.New("passthrough requires the admin role")
+
+// resolvePassthrough lets req.Passthrough through to the planner when
+// admin, whether the request's key has the admin role, says it may. It
+// never turns off Config.Passthrough, which applies to every request.
+func (h *Handler) resolvePassthrough(req *QueryRequest, admin bool) error {
+	if req.Passthrough && !admin && !h.config.Passthrough {
+		return errPassthroughRole
+	}
+	req.passthrough = req.Passthrough
+	return nil
 }
 
 func (h *Handler) PostQuery(w http.ResponseWriter, r *http.Request) {
@@ -65,43 +164,76 @@ func (h *Handler) PostQuery(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, JSON{"error": "invalid json"})
 		return
 	}
-	req.SQL = strings.TrimSpace(req.SQL)
-	if req.SQL == "" {
-		writeJSON(w, http.StatusBadRequest, JSON{"error": "sql required"})
+	if err := h.resolvePassthrough(&req, h.grants(r, RoleAdmin)); err != nil {
+		writeJSON(w, errorStatus(err, http.StatusBadRequest), errorBody(err))
 		return
 	}
+	h.serveQuery(w, r, req)
+}
+
+// serveQuery plans, runs and answers req for PostQuery and saved template
+// runs.
+func (h *Handler) serveQuery(w http.ResponseWriter, r *http.Request, req QueryRequest) {
+	if req.PageSize < 0 {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "page_size must not be negative"})
+		return
+	}
+	if req.PageToken != "" {
+		h.servePage(w, r, req)
+		return
+	}
+	priority, hints, err := prepareQuery(&req)
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusBadRequest), errorBody(err))
+		return
+	}
+	h.applyTablePolicy(r.Context(), &req)
+
+	// safe mode: exact over the original SQL, nothing learned or recorded
+	safeMode := h.config.SafeMode || req.SafeMode
+	if safeMode {
+		req.PreferExact = true
+		req.UseMLOptimization = false
+	}
+	record := !safeMode && !req.warmup
 
-	ctx, cancel := context.WithTimeout(r.Context(), 120*time.Second)
+	if req.Progressive && !safeMode && !req.PreferExact && !req.Explain && hints.SampleTable == "" {
+		h.streamProgressive(w, r, req, priority)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ml.WithQueryPattern(r.Context(), req.pattern), 120*time.Second)
 	defer cancel()
 
 	var mlOptimization *ml.QueryOptimization
 	var statisticalBounds *ml.StatisticalBounds
-	var finalSQL = req.SQL
-	var learningOptimizer *ml.LearningOptimizer
 
+	// One decision pipeline: the planner enumerates candidates and, with ML
+	// enabled, the learner re-scores them before the planner picks one.
+	p := planner.New()
+	p.SetComplexityThreshold(h.config.ComplexityThreshold)
+	p.SetSafeMode(safeMode)
+	p.SetPassthrough(h.config.Passthrough || req.passthrough)
+	p.SetDisabledStrategies(h.config.DisabledStrategies)
+	p.SetSampleResolver(h.resolveSample)
+	p.SetConfidenceLevel(req.ConfidenceLevel)
+	p.SetRankConfidence(req.RankConfidence)
+	p.SetMaxGroups(h.config.MaxResultGroups)
+	p.SetQueryLimits(h.config.QueryLimits)
 	if req.UseMLOptimization && !req.PreferExact {
-		learningOptimizer = ml.NewLearningOptimizer(h.db)
-		var err error
-		mlOptimization, err = learningOptimizer.OptimizeQueryWithLearning(ctx, req.SQL, req.MaxRelError)
-		if err != nil {
-			mlOptimization = &ml.QueryOptimization{
-				Strategy:        ml.StrategyExact,
-				ModifiedSQL:     req.SQL,
-				OriginalSQL:     req.SQL,
-				Reasoning:       fmt.Sprintf("ML optimization failed: %v", err),
-				Transformations: make([]string, 0),
-			}
-		} else {
-			finalSQL = mlOptimization.ModifiedSQL
-		}
+		p.SetScorer(h.learner)
 	}
-
-	p := planner.New()
-	plan, err := p.Plan(ctx, h.db, finalSQL, req.MaxRelError, req.PreferExact)
+	plan, err := p.Plan(ctx, h.readDB, req.SQL, req.MaxRelError, req.PreferExact)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, JSON{"error": err.Error()})
+		if record {
+			h.learner.RecordFailure(err)
+		}
+		writeJSON(w, errorStatus(err, http.StatusBadRequest), errorBody(err))
 		return
 	}
+	if req.UseMLOptimization {
+		mlOptimization = h.learner.DescribePlan(plan)
+	}
 
 	if req.Explain {
 		writeJSON(w, http.StatusOK, QueryResponse{
@@ -111,14 +243,89 @@ func (h *Handler) PostQuery(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	// dashboards re-poll unchanged queries; answer those from the fingerprint.
+	// Exact, passthrough and catalog answers read tables whose writes the
+	// fingerprint can't see.
+	var etag string
+	if !plan.Passthrough && !plan.Catalog && !readsBaseTables(plan) {
+		if fp, err := h.queryFingerprint(ctx, req, plan); err == nil {
+			etag = fp
+		}
+	}
+	if etag != "" {
+		// a paginated answer's page token may have expired, so it is resent
+		if inm := r.Header.Get("If-None-Match"); inm != "" && req.PageSize == 0 && etagMatches(inm, etag) {
+			writeNotModified(w, etag)
+			return
+		}
+		if cached, ok := h.cache.get(etag); ok {
+			if req.onAnswer != nil {
+				req.onAnswer(&cached)
+			}
+			if record {
+				withStrategyChange(&cached, h.noteDecision(ctx, req.SQL, cached.Plan))
+			}
+			h.paginate(ctx, &cached, req.PageSize)
+			w.Header().Set("ETag", etag)
+			writeQueryResponse(w, http.StatusOK, cached)
+			return
+		}
+	}
 
 	executionStart := time.Now()
 
-	rows, meta, err := executor.Execute(ctx, h.db, plan)
+	execute := func(plan *planner.Plan) (rows *executor.ResultSet, meta map[string]any, err error) {
+		runCtx, release, err := h.schedule(ctx, priority, heavyPlan(plan))
+		if err != nil {
+			return nil, nil, err
+		}
+		defer release()
+		// repeated universe joins read the joined samples staged once
+		run, joinCache := h.stageJoin(runCtx, plan)
+		err = h.guard.Do(runCtx, func(ctx context.Context) error {
+			var execErr error
+			ctx = executor.WithMemoryBudget(ctx, executor.NewMemoryBudget(h.config.MaxQueryMemoryBytes))
+			if req.PreferExact && !safeMode && !run.Passthrough {
+				// forced-exact GROUP BYs on big tables spill partial aggregates to disk
+				rows, meta, execErr = executor.ExecuteExternal(ctx, h.readDB, run, h.config.ExternalChunkRows)
+			} else {
+				rows, meta, execErr = executor.Execute(ctx, h.readDB, run)
+			}
+			return execErr
+		})
+		if err == nil && joinCache != nil {
+			meta["join_cache"] = joinCache
+		}
+		return rows, meta, err
+	}
+	rows, meta, err := execute(plan)
+	if err == nil && req.MaxRelError > 0 {
+		plan, rows, meta, err = h.escalate(ctx, p, plan, rows, meta, execute)
+	}
 	executionTime := time.Since(executionStart)
+	// count what kept the query exact, once it has run
+	if record && len(plan.Unsupported) > 0 {
+		go func(constructs []string) {
+			err := h.guard.Do(context.WithoutCancel(ctx), func(ctx context.Context) error {
+				return storage.RecordUnsupportedConstructs(ctx, h.db, constructs, req.SQL)
+			})
+			if err != nil {
+				log.Printf("unsupported construct telemetry: %v", err)
+			}
+		}(plan.Unsupported)
+	}
+	if meta != nil && heavyPlan(plan) {
+		meta["priority"] = priority
+	}
+	if safeMode && meta != nil {
+		meta["safe_mode"] = true
+	}
 
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, QueryResponse{
+		if record {
+			h.learner.RecordFailure(err)
+		}
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), QueryResponse{
 			Status:         "error",
 			Error:          err.Error(),
 			Plan:           plan,
@@ -127,32 +334,27 @@ func (h *Handler) PostQuery(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.UseMLOptimization && mlOptimization != nil && mlOptimization.Strategy == ml.StrategySample {
-		scaleMLOptimizedResults(rows, mlOptimization)
-
-		errorEstimator := ml.NewErrorEstimator(0.95)
-
-		sampleSize := int64(2000)
-		populationSize := int64(200000)
-		samplingFraction := float64(sampleSize) / float64(populationSize)
-
-		aggregationCols := identifyAggregationColumns(rows)
-
-		for _, col := range aggregationCols {
-			if len(rows) > 0 {
-				if val, exists := rows[0][col]; exists {
-					if numVal, ok := convertToFloat64API(val); ok {
-						bounds := errorEstimator.EstimateErrorBounds(
-							numVal, sampleSize, populationSize, samplingFraction,
-							getAggregationType(col))
-
-						errorEstimator.ApplyStatisticalBoundsToResults(rows, bounds, []string{col})
+	var strategyChange *ml.StrategyChange
+	if record {
+		strategyChange = h.noteDecision(ctx, req.SQL, plan)
+	}
 
-						if statisticalBounds == nil {
-							statisticalBounds = bounds
-						}
-					}
-				}
+	// the executor already scaled sample results and attached bootstrap CIs;
+	// the analytical bounds are reported alongside for the first aggregate
+	if req.UseMLOptimization && plan.Type == planner.PlanSample && plan.TableRows > 0 && rows.Len() > 0 {
+		errorEstimator := ml.NewErrorEstimator(plan.Level())
+		sampleSize := int64(float64(plan.TableRows) * plan.SampleFraction)
+
+		for _, agg := range plan.Aggregates {
+			kind := boundsAggregationType(agg)
+			c := rows.Column(agg.Column)
+			if kind == "" || c == nil {
+				continue
+			}
+			if numVal, ok := c.Float(0); ok && sampleSize > 0 {
+				statisticalBounds = errorEstimator.EstimateErrorBounds(
+					numVal, sampleSize, plan.TableRows, plan.SampleFraction, kind)
+				break
 			}
 		}
 	}
@@ -161,7 +363,7 @@ func (h *Handler) PostQuery(w http.ResponseWriter, r *http.Request) {
 	// BUT skip recording if we're querying the ML learning table itself to prevent recursion
 	sqlLower := strings.ToLower(req.SQL)
 	isMLHistoryQuery := strings.Contains(sqlLower, "ml_query_performance_history")
-	if req.UseMLOptimization && mlOptimization != nil && !isMLHistoryQuery {
+	if req.UseMLOptimization && mlOptimization != nil && !isMLHistoryQuery && !plan.Passthrough && !req.warmup {
 		go func() {
 			// Add panic recovery to prevent server crashes
 			defer func() {
@@ -171,18 +373,43 @@ func (h *Handler) PostQuery(w http.ResponseWriter, r *http.Request) {
 				}
 			}()
 
-			// Use existing learning optimizer or create one if needed
-			currentLearningOptimizer := learningOptimizer
-			if currentLearningOptimizer == nil {
-				currentLearningOptimizer = ml.NewLearningOptimizer(h.db)
+			// Validate ML optimization data before recording
+			if mlOptimization.EstimatedSpeedup <= 0 {
+				mlOptimization.EstimatedSpeedup = 1.0
+			}
+			if mlOptimization.EstimatedError < 0 {
+				mlOptimization.EstimatedError = 0.0
+			}
+
+			// Baseline: the exact time itself, a fresh shadow run, the last
+			// shadow measurement for this pattern, or failing those the
+			// planner's own speedup estimate
+			baselineTime := executionTime * time.Duration(mlOptimization.EstimatedSpeedup)
+			shadow := plan.Type != planner.PlanExact && rand.Float64() < h.config.ShadowExactRate
+			var shadowElapsed time.Duration
+			if shadow {
+				elapsed, err := h.shadowExact(plan.Table, plan.OriginalSQL)
+				shadow = err == nil
+				shadowElapsed = elapsed
 			}
 
 			// Add timeout context to prevent hanging
-			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			ctx, cancel := context.WithTimeout(ml.WithQueryPattern(context.Background(), req.pattern), 10*time.Second)
 			defer cancel()
 
+			if shadow {
+				baselineTime = shadowElapsed
+				if err := h.learner.RecordBaseline(ctx, req.SQL, shadowElapsed); err != nil {
+					fmt.Printf("Error recording shadow baseline: %v\n", err)
+				}
+			} else if plan.Type == planner.PlanExact {
+				baselineTime = executionTime
+			} else if observed, ok := h.learner.ObservedBaseline(ctx, req.SQL); ok {
+				baselineTime = observed
+			}
+
 			// Extract proper features using the optimizer instance
-			features, err := currentLearningOptimizer.ExtractQueryFeatures(ctx, req.SQL, req.MaxRelError)
+			features, err := h.learner.ExtractQueryFeatures(ctx, req.SQL, req.MaxRelError)
 			if err != nil {
 				// Fallback to basic features if extraction fails
 				features = &ml.QueryFeatures{
@@ -194,23 +421,24 @@ func (h *Handler) PostQuery(w http.ResponseWriter, r *http.Request) {
 					HasGroupBy:     strings.Contains(strings.ToUpper(req.SQL), "GROUP BY"),
 				}
 			}
-			// Validate ML optimization data before recording
-			if mlOptimization.EstimatedSpeedup <= 0 {
-				mlOptimization.EstimatedSpeedup = 1.0
-			}
-			if mlOptimization.EstimatedError < 0 {
-				mlOptimization.EstimatedError = 0.0
+			// Until the verifier measures it, the error recorded is the
+			// answer's own: its widest interval, or the planner's estimate
+			actualError := 0.0
+			if plan.Type != planner.PlanExact {
+				actualError = plan.EstimatedError
+				if worst, bounded := maxResultRelError(rows); bounded {
+					actualError = worst
+				}
 			}
 
-			actualError := 0.02
-			baselineTime := executionTime * time.Duration(mlOptimization.EstimatedSpeedup)
-
 			// Add error handling for RecordQueryPerformance
-			err = currentLearningOptimizer.RecordQueryPerformance(
+			historyID, err := h.learner.RecordQueryPerformance(
 				ctx, mlOptimization, features,
 				executionTime, actualError, baselineTime)
 			if err != nil {
 				fmt.Printf("Error recording ML performance: %v\n", err)
+			} else if historyID > 0 && plan.Type != planner.PlanExact && rand.Float64() < h.config.VerifyRate {
+				h.verifier.enqueue(verification{historyID: historyID, plan: plan, result: rows})
 			}
 		}()
 	}
@@ -256,47 +484,309 @@ func (h *Handler) PostQuery(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("About to write response with ML optimization: %+v", mlOptimization)
 
-	writeJSON(w, http.StatusOK, QueryResponse{
+	resp := QueryResponse{
 		Status:            "ok",
 		Plan:              plan,
 		Result:            rows,
 		Meta:              meta,
 		MLOptimization:    mlOptimization,
 		StatisticalBounds: statisticalBounds,
+	}
+	if req.onAnswer != nil {
+		req.onAnswer(&resp)
+	}
+	if etag != "" {
+		h.cache.put(etag, resp)
+		w.Header().Set("ETag", etag)
+	}
+	withStrategyChange(&resp, strategyChange)
+	h.paginate(ctx, &resp, req.PageSize)
+	writeQueryResponse(w, http.StatusOK, resp)
+}
+
+// noteDecision records the plan sqlText ran with for its pattern and
+// returns why the pattern's strategy changed since its last run, if it
+// did. A failure is logged, not reported: the answer itself is fine.
+func (h *Handler) noteDecision(ctx context.Context, sqlText string, plan *planner.Plan) *ml.StrategyChange {
+	var change *ml.StrategyChange
+	err := h.guard.Do(ctx, func(ctx context.Context) error {
+		var err error
+		change, err = h.learner.NoteDecision(ctx, sqlText, plan)
+		return err
+	})
+	if err != nil {
+		log.Printf("strategy decision: %v", err)
+	}
+	return change
+}
+
+// withStrategyChange reports change in resp's meta, which is copied so a
+// cached answer served again doesn't repeat it.
+func withStrategyChange(resp *QueryResponse, change *ml.StrategyChange) {
+	if change == nil {
+		return
+	}
+	meta := maps.Clone(resp.Meta)
+	if meta == nil {
+		meta = make(map[string]any)
+	}
+	meta["strategy_change"] = change
+	resp.Meta = meta
+}
+
+// shadowExact runs sqlText, a query over table, exactly on the read-only
+// connection, outside the request, and returns how long it took. It runs
+// at background priority within table's maintenance window, so an
+// interactive query can cut it short and outside the window it doesn't
+// run.
+func (h *Handler) shadowExact(table, sqlText string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.config.ShadowExactTimeout)
+	defer cancel()
+
+	plan := &planner.Plan{Type: planner.PlanExact, SQL: sqlText, OriginalSQL: sqlText}
+	ctx, release, err := h.scheduleMaintenance(ctx, table)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	start := time.Now()
+	err = h.guard.Do(ctx, func(ctx context.Context) error {
+		ctx = executor.WithMemoryBudget(ctx, executor.NewMemoryBudget(h.config.MaxQueryMemoryBytes))
+		_, _, err := executor.Execute(ctx, h.readDB, plan)
+		return err
 	})
+	return time.Since(start), err
 }
 
 type CreateSampleRequest struct {
 	Table          string  `json:"table"`
 	SampleFraction float64 `json:"sample_fraction"`
+	// Columns limits the sample to these columns; InferColumns instead
+	// takes those the table's recorded queries read. Either way a query
+	// needing any other column won't be planned on the sample.
+	Columns      []string `json:"columns,omitempty"`
+	InferColumns bool     `json:"infer_columns,omitempty"`
+	// Predicate restricts the sample to the rows matching this WHERE
+	// condition; only queries whose WHERE clause implies it are planned on
+	// the sample.
+	Predicate string `json:"predicate,omitempty"`
+	// OutlierColumn makes an outlier sample: the Outliers rows (default
+	// defaultOutliers) whose OutlierColumn lies furthest from its mean are
+	// kept exactly beside a sample of the rest, for queries summing it.
+	OutlierColumn string `json:"outlier_column,omitempty"`
+	Outliers      int    `json:"outliers,omitempty"`
+	// UniverseColumn makes a universe sample: the rows whose key in this
+	// column hashes below the fraction, so universe samples of joined
+	// tables on their join keys at the same fraction join to a sample of
+	// the join.
+	UniverseColumn string `json:"universe_column,omitempty"`
+	// ReservoirSize makes a live sample of at most this many rows, in
+	// place of SampleFraction, kept current as rows are appended (see
+	// sampler.CreateReservoirSample).
+	ReservoirSize int64 `json:"reservoir_size,omitempty"`
+	// BlockRows makes a block sample: whole blocks of this many
+	// consecutive rows, read by rowid range rather than by scanning the
+	// table, with a bias warning (see sampler.CreateBlockSample).
+	BlockRows int64 `json:"block_rows,omitempty"`
+}
+
+// defaultOutliers is how many outliers an outlier sample keeps unless
+// asked for another number.
+const defaultOutliers = 100
+
+// rejectInSafeMode answers synopsis-creating requests while the server is
+// in safe mode and reports whether it did.
+func (h *Handler) rejectInSafeMode(w http.ResponseWriter) bool {
+	if !h.config.SafeMode {
+		return false
+	}
+	writeJSON(w, http.StatusServiceUnavailable, JSON{"error": "safe mode: sample and sketch creation disabled"})
+	return true
 }
 
 func (h *Handler) PostCreateSample(w http.ResponseWriter, r *http.Request) {
+	if h.rejectInSafeMode(w) {
+		return
+	}
 	var req CreateSampleRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		writeJSON(w, http.StatusBadRequest, JSON{"error": "invalid json"})
 		return
 	}
-	if req.Table == "" || req.SampleFraction <= 0 || req.SampleFraction >= 1 {
+	if req.Table == "" || (req.ReservoirSize == 0 && (req.SampleFraction <= 0 || req.SampleFraction >= 1)) {
 		writeJSON(w, http.StatusBadRequest, JSON{"error": "table and 0<sample_fraction<1 required"})
 		return
 	}
+	if req.ReservoirSize < 0 || (req.ReservoirSize > 0 && (req.SampleFraction != 0 || req.Predicate != "" || req.OutlierColumn != "" || req.UniverseColumn != "")) {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "reservoir_size must be positive and excludes sample_fraction, predicate, outlier_column and universe_column"})
+		return
+	}
+	if req.InferColumns && len(req.Columns) > 0 {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "columns and infer_columns are exclusive"})
+		return
+	}
+	if req.OutlierColumn != "" && req.Predicate != "" {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "predicate and outlier_column are exclusive"})
+		return
+	}
+	if req.UniverseColumn != "" && (req.Predicate != "" || req.OutlierColumn != "") {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "universe_column excludes predicate and outlier_column"})
+		return
+	}
+	if req.BlockRows < 0 || (req.BlockRows > 0 && (req.ReservoirSize > 0 || req.OutlierColumn != "" || req.UniverseColumn != "")) {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "block_rows must be positive and excludes reservoir_size, outlier_column and universe_column"})
+		return
+	}
+	if req.Outliers < 0 || (req.Outliers > 0 && req.OutlierColumn == "") {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "outliers must be positive and needs outlier_column"})
+		return
+	}
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
 	defer cancel()
-	name, count, err := sampler.CreateUniformSample(ctx, h.db, req.Table, req.SampleFraction)
+	resp := JSON{"status": "ok"}
+	if req.InferColumns {
+		columns, patterns, err := h.inferSampleColumns(ctx, req.Table)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
+			return
+		}
+		req.Columns = columns
+		resp["inferred_columns"], resp["inferred_from_patterns"] = columns, patterns
+	}
+	if req.Predicate != "" {
+		predicateCols, err := planner.ParsePredicate(req.Table, req.Predicate)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, JSON{"error": err.Error()})
+			return
+		}
+		if _, err := sampler.PruneColumns(ctx, h.db, req.Table, predicateCols, ""); err != nil {
+			writeJSON(w, sampleErrorStatus(err), JSON{"error": err.Error()})
+			return
+		}
+		// queries re-apply the predicate, so a pruned sample must keep its columns
+		if len(req.Columns) > 0 {
+			req.Columns = append(req.Columns, predicateCols...)
+		}
+		resp["predicate"] = req.Predicate
+	}
+	if req.OutlierColumn != "" {
+		if req.Outliers == 0 {
+			req.Outliers = defaultOutliers
+		}
+		resp["outlier_column"], resp["outliers"] = req.OutlierColumn, req.Outliers
+	}
+	if req.UniverseColumn != "" {
+		resp["universe_column"] = req.UniverseColumn
+	}
+	if req.ReservoirSize > 0 {
+		resp["reservoir_size"] = req.ReservoirSize
+	}
+	var name string
+	var count int64
+	// drawn once: a failed draw may have left part of its table behind
+	err := h.guard.DoOnce(ctx, func(ctx context.Context) error {
+		var sampleErr error
+		switch {
+		case req.ReservoirSize > 0:
+			name, count, sampleErr = sampler.CreateReservoirSample(ctx, h.db, req.Table, req.ReservoirSize, req.Columns)
+		case req.OutlierColumn != "":
+			name, count, sampleErr = sampler.CreateOutlierSample(ctx, h.db, req.Table, req.OutlierColumn, req.Outliers, req.SampleFraction, req.Columns)
+		case req.UniverseColumn != "":
+			name, count, sampleErr = sampler.CreateUniverseSample(ctx, h.db, req.Table, req.UniverseColumn, req.SampleFraction, req.Columns)
+		case req.BlockRows > 0:
+			name, count, sampleErr = sampler.CreateBlockSample(ctx, h.db, req.Table, req.Predicate, req.SampleFraction, req.BlockRows, req.Columns)
+		default:
+			name, count, sampleErr = sampler.CreateFilteredSample(ctx, h.db, req.Table, req.Predicate, req.SampleFraction, req.Columns)
+		}
+		return sampleErr
+	})
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
+		writeJSON(w, sampleErrorStatus(err), JSON{"error": err.Error()})
 		return
 	}
-	writeJSON(w, http.StatusOK, JSON{"status": "ok", "sample_table": name, "rows": count})
+	resp["sample_table"], resp["rows"] = name, count
+	if req.BlockRows > 0 {
+		// sparse rowids get a row-by-row sample instead
+		if info, err := storage.LookupSample(ctx, h.db, name); err == nil {
+			if info.BlockRows > 0 {
+				resp["block_rows"] = info.BlockRows
+			} else {
+				resp["block_fallback"] = "rowids too sparse for blocks; rows were sampled one by one"
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// inferSampleColumns returns the columns of table that its recorded query
+// patterns read, with any it declares roles for, and how many patterns
+// could use a sample at all. It returns nil columns, for a sample of every
+// column, when one of them selects * or there is nothing but COUNT(*) to
+// go on.
+func (h *Handler) inferSampleColumns(ctx context.Context, table string) ([]string, int, error) {
+	patterns, err := h.learner.TablePatterns(ctx, table)
+	if err != nil {
+		return nil, 0, err
+	}
+	tableCols, err := storage.TableColumns(ctx, h.db, table)
+	if err != nil {
+		return nil, 0, err
+	}
+	inTable := make(map[string]string, len(tableCols))
+	for _, c := range tableCols {
+		inTable[strings.ToLower(c)] = c
+	}
+	roles, err := storage.GetTableRoles(ctx, h.db, table)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	p := planner.New()
+	p.SetComplexityThreshold(h.config.ComplexityThreshold)
+	used := 0
+	seen := make(map[string]bool)
+	var columns []string
+	for _, c := range roles.Columns() {
+		if name, ok := inTable[strings.ToLower(c)]; ok && !seen[name] {
+			seen[name] = true
+			columns = append(columns, name)
+		}
+	}
+	for _, u := range patterns {
+		t, cols, all := p.SampleColumns(u.Pattern)
+		if !strings.EqualFold(t, table) {
+			continue
+		}
+		used++
+		if all {
+			return nil, used, nil
+		}
+		for _, c := range cols {
+			// other tables' columns in a join, and aliases
+			if name, ok := inTable[strings.ToLower(c)]; ok && !seen[name] {
+				seen[name] = true
+				columns = append(columns, name)
+			}
+		}
+	}
+	return columns, used, nil
+}
+
+// sampleErrorStatus is errorStatus for sample creation, where naming a
+// column the table lacks is the caller's mistake.
+func sampleErrorStatus(err error) int {
+	if errors.Is(err, sampler.ErrUnknownColumn) {
+		return http.StatusBadRequest
+	}
+	return errorStatus(err, http.StatusInternalServerError)
 }
 
 func (h *Handler) GetLearningStats(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
 	defer cancel()
 
-	learningOptimizer := ml.NewLearningOptimizer(h.db)
-	stats, err := learningOptimizer.GetLearningStats(ctx)
+	stats, err := h.learner.GetLearningStats(ctx)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
 		return
@@ -305,12 +795,45 @@ func (h *Handler) GetLearningStats(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, JSON{"status": "ok", "learning_stats": stats})
 }
 
+// GetMLAccuracy reports predicted vs measured speedups and errors per
+// strategy and table over the last ?days= days (default 30).
+func (h *Handler) GetMLAccuracy(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	days := 30
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeJSON(w, http.StatusBadRequest, JSON{"error": "days must be a positive integer"})
+			return
+		}
+		days = n
+	}
+
+	report, err := h.learner.AccuracyReport(ctx, days)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "accuracy": report})
+}
+
 func (h *Handler) PostCreateStratifiedSample(w http.ResponseWriter, r *http.Request) {
+	if h.rejectInSafeMode(w) {
+		return
+	}
+	// strata_column and variance_column default to the table's first
+	// declared dimension and measure; min_per_stratum makes the allocation
+	// congressional
 	var req struct {
-		Table          string  `json:"table"`
-		StrataColumn   string  `json:"strata_column"`
-		TotalFraction  float64 `json:"total_fraction"`
-		VarianceColumn string  `json:"variance_column,omitempty"`
+		Table          string   `json:"table"`
+		StrataColumn   string   `json:"strata_column"`
+		TotalFraction  float64  `json:"total_fraction"`
+		VarianceColumn string   `json:"variance_column,omitempty"`
+		MinPerStratum  int64    `json:"min_per_stratum,omitempty"`
+		Columns        []string `json:"columns,omitempty"` // strata_column is always kept
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -318,34 +841,74 @@ func (h *Handler) PostCreateStratifiedSample(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	if req.Table == "" || req.StrataColumn == "" || req.TotalFraction <= 0 || req.TotalFraction >= 1 {
-		writeJSON(w, http.StatusBadRequest, JSON{"error": "table, strata_column and 0<total_fraction<1 required"})
+	if req.Table == "" || req.TotalFraction <= 0 || req.TotalFraction >= 1 {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "table and 0<total_fraction<1 required"})
+		return
+	}
+	if req.MinPerStratum < 0 {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "min_per_stratum must not be negative"})
 		return
 	}
 
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Minute)
 	defer cancel()
 
-	sampleName, strata, err := sampler.CreateStratifiedSample(ctx, h.db, req.Table, req.StrataColumn, req.TotalFraction, req.VarianceColumn)
+	// a registered table stratifies by its first dimension and allocates
+	// by the variance of its first measure unless told otherwise
+	if req.StrataColumn == "" || req.VarianceColumn == "" {
+		roles, err := storage.GetTableRoles(ctx, h.db, req.Table)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
+			return
+		}
+		if req.StrataColumn == "" && roles != nil && len(roles.Dimensions) > 0 {
+			req.StrataColumn = roles.Dimensions[0]
+		}
+		if req.VarianceColumn == "" && roles != nil && len(roles.Measures) > 0 {
+			req.VarianceColumn = roles.Measures[0]
+		}
+	}
+	if req.StrataColumn == "" {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "strata_column required: the table declares no dimensions"})
+		return
+	}
+
+	var sampleName string
+	var strata []sampler.StrataInfo
+	err := h.guard.DoOnce(ctx, func(ctx context.Context) error {
+		var sampleErr error
+		sampleName, strata, sampleErr = sampler.CreateStratifiedSample(ctx, h.db, req.Table, req.StrataColumn, req.TotalFraction, req.VarianceColumn, req.MinPerStratum, req.Columns)
+		return sampleErr
+	})
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
+		writeJSON(w, sampleErrorStatus(err), JSON{"error": err.Error()})
 		return
 	}
 
-	writeJSON(w, http.StatusOK, JSON{
+	resp := JSON{
 		"status":       "ok",
 		"sample_table": sampleName,
 		"strata":       strata,
 		"allocation_type": func() string {
-			if req.VarianceColumn != "" {
+			switch {
+			case req.MinPerStratum > 0:
+				return "congressional"
+			case req.VarianceColumn != "":
 				return "neyman"
 			}
 			return "proportional"
 		}(),
-	})
+	}
+	if req.MinPerStratum > 0 {
+		resp["min_per_stratum"] = req.MinPerStratum
+	}
+	writeJSON(w, http.StatusOK, resp)
 }
 
 func (h *Handler) PostCreateSketch(w http.ResponseWriter, r *http.Request) {
+	if h.rejectInSafeMode(w) {
+		return
+	}
 	var req struct {
 		Table      string                 `json:"table"`
 		Column     string                 `json:"column,omitempty"`
@@ -371,9 +934,17 @@ func (h *Handler) PostCreateSketch(w http.ResponseWriter, r *http.Request) {
 
 	switch req.SketchType {
 	case "hyperloglog":
-		sketchData, err = h.createHyperLogLogSketch(ctx, req.Table, req.Column)
+		sketchData, err = h.createHyperLogLogSketch(ctx, req.Table, req.Column, req.Parameters)
 	case "countmin":
 		sketchData, err = h.createCountMinSketch(ctx, req.Table, req.Column, req.Parameters)
+	case "tdigest":
+		sketchData, err = h.createTDigestSketch(ctx, req.Table, req.Column, req.Parameters)
+	case "bloom":
+		sketchData, err = h.createBloomFilter(ctx, req.Table, req.Column, req.Parameters)
+	case "ams":
+		sketchData, err = h.createAMSSketch(ctx, req.Table, req.Column, req.Parameters)
+	case "topk":
+		sketchData, err = h.createTopKSketch(ctx, req.Table, req.Column, req.Parameters)
 	default:
 		writeJSON(w, http.StatusBadRequest, JSON{"error": "unsupported sketch type"})
 		return
@@ -384,10 +955,16 @@ func (h *Handler) PostCreateSketch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// base row count lets answers from this sketch report its staleness
+	var baseRows int64
+	baseRows, _ = storage.CountRows(ctx, h.db, req.Table)
+
 	parametersJSON, _ := json.Marshal(req.Parameters)
-	err = storage.UpsertSketch(ctx, h.db, req.Table, req.Column, req.SketchType, sketchData, string(parametersJSON))
+	err = h.guard.Do(ctx, func(ctx context.Context) error {
+		return storage.UpsertSketch(ctx, h.db, req.Table, req.Column, req.SketchType, sketchData, string(parametersJSON), baseRows)
+	})
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
 		return
 	}
 
@@ -413,12 +990,81 @@ func (h *Handler) GetSketches(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, JSON{"sketches": sketches})
 }
 
-func (h *Handler) createHyperLogLogSketch(ctx context.Context, table, column string) ([]byte, error) {
+// GetTopK returns the ?k= (default 10) most frequent values of a column
+// from its top-K sketch. Each count is an upper bound on the true one,
+// which is at least count-error; a value left out of the sketch occurs at
+// most threshold times.
+func (h *Handler) GetTopK(w http.ResponseWriter, r *http.Request) {
+	table, column := r.URL.Query().Get("table"), r.URL.Query().Get("column")
+	if table == "" || column == "" {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "table and column parameters required"})
+		return
+	}
+	k := 10
+	if v := r.URL.Query().Get("k"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeJSON(w, http.StatusBadRequest, JSON{"error": "k must be a positive integer"})
+			return
+		}
+		k = n
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	data, _, err := storage.GetSketch(ctx, h.db, table, column, string(storage.TopKSketchType))
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, JSON{"error": fmt.Sprintf("no top-K sketch on %s.%s; create one with sketch_type \"topk\"", table, column)})
+		return
+	}
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	topk, err := sketches.DeserializeTopKSketch(data)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
+		return
+	}
+	if k > topk.Capacity() {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": fmt.Sprintf("k must be at most the sketch's capacity of %d", topk.Capacity())})
+		return
+	}
+
+	type topKItem struct {
+		sketches.TopKItem
+		CountLow uint64 `json:"count_low"`
+	}
+	items := make([]topKItem, 0, k)
+	for _, it := range topk.Top(k) {
+		items = append(items, topKItem{TopKItem: it, CountLow: it.Count - it.Error})
+	}
+
+	resp := JSON{
+		"status":     "ok",
+		"table":      table,
+		"column":     column,
+		"k":          k,
+		"items":      items,
+		"total_rows": topk.Items(),
+		"capacity":   topk.Capacity(),
+		"threshold":  topk.Threshold(),
+	}
+	if rows, err := storage.EstimateRowCount(ctx, h.db, table); err == nil {
+		if prov, err := storage.SketchProvenance(ctx, h.db, table, column, string(storage.TopKSketchType), rows); err == nil && prov != nil {
+			resp["provenance"] = prov
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *Handler) createHyperLogLogSketch(ctx context.Context, table, column string, parameters map[string]interface{}) ([]byte, error) {
 	if column == "" {
 		return nil, fmt.Errorf("column required for HyperLogLog")
 	}
 
-	hll := sketches.NewHyperLogLog(12)
+	hll := sketches.NewHyperLogLogWithSeed(12, sketchSeed(parameters))
 
 	query := fmt.Sprintf("SELECT DISTINCT %s FROM %s WHERE %s IS NOT NULL", column, table, column)
 	rows, err := h.db.QueryContext(ctx, query)
@@ -427,21 +1073,28 @@ func (h *Handler) createHyperLogLogSketch(ctx context.Context, table, column str
 	}
 	defer rows.Close()
 
+	const batchSize = 4096
+	batch := make([]uint64, 0, batchSize)
 	count := 0
 	for rows.Next() {
 		var value string
 		if err := rows.Scan(&value); err != nil {
 			return nil, err
 		}
-		hll.AddString(value)
+		batch = append(batch, hll.HashValue([]byte(value)))
+		if len(batch) == batchSize {
+			hll.AddHashes(batch)
+			batch = batch[:0]
+		}
 		count++
 
 		if count > 1000000 {
 			break
 		}
 	}
+	hll.AddHashes(batch)
 
-	return hll.Serialize(), nil
+	return hll.Serialize(), rows.Err()
 }
 
 func (h *Handler) createCountMinSketch(ctx context.Context, table, column string, parameters map[string]interface{}) ([]byte, error) {
@@ -455,7 +1108,16 @@ func (h *Handler) createCountMinSketch(ctx context.Context, table, column string
 		delta = d
 	}
 
-	cms := sketches.NewCountMinSketch(epsilon, delta)
+	cms := sketches.NewCountMinSketchWithSeed(epsilon, delta, sketchSeed(parameters))
+	// tracked keys let GROUP BY counts be enumerated from the sketch;
+	// "max_tracked_keys": 0 turns tracking off
+	trackedKeys := sketches.DefaultTrackedKeys
+	if n, ok := parameters["max_tracked_keys"].(float64); ok {
+		trackedKeys = int(n)
+	}
+	if trackedKeys > 0 && column != "" {
+		cms.TrackKeys(trackedKeys)
+	}
 
 	var query string
 	if column != "" {
@@ -470,59 +1132,195 @@ func (h *Handler) createCountMinSketch(ctx context.Context, table, column string
 	}
 	defer rows.Close()
 
+	const batchSize = 4096
+	hashes := make([]uint64, 0, batchSize)
+	counts := make([]uint64, 0, batchSize)
+	flush := func() error {
+		err := cms.AddHashes(hashes, counts)
+		hashes, counts = hashes[:0], counts[:0]
+		return err
+	}
 	for rows.Next() {
 		var key string
 		var count uint64
 		if err := rows.Scan(&key, &count); err != nil {
 			return nil, err
 		}
-		cms.AddString(key, count)
+		cms.RecordKey([]byte(key))
+		hashes = append(hashes, cms.HashKey([]byte(key)))
+		counts = append(counts, count)
+		if len(hashes) == batchSize {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
 	}
 
-	return cms.Serialize(), nil
+	return cms.Serialize(), rows.Err()
 }
 
-func scaleMLOptimizedResults(results []map[string]any, mlOpt *ml.QueryOptimization) {
-	if mlOpt == nil || mlOpt.Strategy != ml.StrategySample || len(results) == 0 {
-		return
+func (h *Handler) createTDigestSketch(ctx context.Context, table, column string, parameters map[string]interface{}) ([]byte, error) {
+	if column == "" {
+		return nil, fmt.Errorf("column required for t-digest")
 	}
 
-	sampleFraction := 0.01
-	for _, transform := range mlOpt.Transformations {
-		if strings.Contains(transform, "fraction:") {
-			if parts := strings.Split(transform, "fraction: "); len(parts) > 1 {
-				if parsed := strings.TrimSuffix(strings.Split(parts[1], ")")[0], ")"); parsed != "" {
-					if val, err := strconv.ParseFloat(parsed, 64); err == nil {
-						sampleFraction = val
-						break
-					}
-				}
-			}
+	compression := 100.0
+	if c, ok := parameters["compression"].(float64); ok && c > 0 {
+		compression = c
+	}
+	td := sketches.NewTDigest(compression)
+
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s IS NOT NULL", column, table, column)
+	rows, err := h.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var value sql.NullFloat64
+		if err := rows.Scan(&value); err != nil {
+			return nil, fmt.Errorf("t-digest needs a numeric column: %w", err)
+		}
+		if value.Valid {
+			td.Add(value.Float64)
 		}
 	}
 
-	if sampleFraction <= 0 {
-		return
+	return td.Serialize(), rows.Err()
+}
+
+// createBloomFilter builds a Bloom filter over column's distinct keys, sized
+// for "expected_items" keys (by default the column's distinct count) at the
+// "fpr" false positive rate (default 1%). The planner pre-filters the other
+// side of joins on column through it, which only SQLite can evaluate.
+func (h *Handler) createBloomFilter(ctx context.Context, table, column string, parameters map[string]interface{}) ([]byte, error) {
+	if column == "" {
+		return nil, fmt.Errorf("column required for Bloom filter")
+	}
+	if err := storage.RequireSQLite("Bloom filters"); err != nil {
+		return nil, err
 	}
 
-	scale := 1.0 / sampleFraction
+	fpr := 0.01
+	if p, ok := parameters["fpr"].(float64); ok {
+		fpr = p
+	}
+	var expected int64
+	if n, ok := parameters["expected_items"].(float64); ok && n > 0 {
+		expected = int64(n)
+	} else if err := h.db.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(DISTINCT %s) FROM %s", column, table)).Scan(&expected); err != nil {
+		return nil, err
+	}
+	bf, err := sketches.NewBloomFilterWithSeed(uint64(expected), fpr, sketchSeed(parameters))
+	if err != nil {
+		return nil, err
+	}
 
-	for i := range results {
-		for col, val := range results[i] {
-			colUpper := strings.ToUpper(col)
-			needsScaling := strings.Contains(colUpper, "COUNT") ||
-				strings.Contains(colUpper, "SUM") ||
-				strings.Contains(colUpper, "TOTAL") ||
-				strings.Contains(colUpper, "REVENUE") ||
-				strings.Contains(colUpper, "ORDERS")
+	// unary + drops the column's declared type, so keys are read as stored,
+	// as the planner's filter function is passed them
+	query := fmt.Sprintf("SELECT DISTINCT +%s FROM %s WHERE %s IS NOT NULL", column, table, column)
+	rows, err := h.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-			if needsScaling {
-				if numVal, ok := convertToFloat64API(val); ok {
-					results[i][col] = numVal * scale
-				}
-			}
+	for rows.Next() {
+		var value any
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
 		}
+		bf.AddValue(value)
 	}
+
+	return bf.Serialize(), rows.Err()
+}
+
+// createAMSSketch builds an AMS sketch of column's value frequencies,
+// "width" counters (default 4096) by "depth" rows (default 5). The join
+// optimizer estimates the size of equi-joins on column from it and a
+// sketch of the other side's key, which must have the same width, depth
+// and seed.
+func (h *Handler) createAMSSketch(ctx context.Context, table, column string, parameters map[string]interface{}) ([]byte, error) {
+	if column == "" {
+		return nil, fmt.Errorf("column required for AMS sketch")
+	}
+	width, depth := uint32(sketches.DefaultAMSWidth), uint32(sketches.DefaultAMSDepth)
+	if n, ok := parameters["width"].(float64); ok && n > 0 {
+		width = uint32(n)
+	}
+	if n, ok := parameters["depth"].(float64); ok && n > 0 {
+		depth = uint32(n)
+	}
+	ams, err := sketches.NewAMSSketch(width, depth, sketchSeed(parameters))
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf("SELECT %s, COUNT(*) FROM %s WHERE %s IS NOT NULL GROUP BY %s", column, table, column, column)
+	rows, err := h.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var value any
+		var count int64
+		if err := rows.Scan(&value, &count); err != nil {
+			return nil, err
+		}
+		ams.AddValue(value, count)
+	}
+
+	return ams.Serialize(), rows.Err()
+}
+
+// createTopKSketch builds a Space-Saving sketch counting column's
+// "capacity" (default 1000) most frequent values, which GET /sketches/topk
+// reads.
+func (h *Handler) createTopKSketch(ctx context.Context, table, column string, parameters map[string]interface{}) ([]byte, error) {
+	if column == "" {
+		return nil, fmt.Errorf("column required for top-K sketch")
+	}
+	capacity := sketches.DefaultTopKCapacity
+	if n, ok := parameters["capacity"].(float64); ok && n > 0 {
+		capacity = int(n)
+	}
+	topk, err := sketches.NewTopKSketch(capacity)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf("SELECT %s, COUNT(*) FROM %s WHERE %s IS NOT NULL GROUP BY %s", column, table, column, column)
+	rows, err := h.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var value any
+		var count int64
+		if err := rows.Scan(&value, &count); err != nil {
+			return nil, err
+		}
+		topk.AddValue(value, uint64(count))
+	}
+
+	return topk.Serialize(), rows.Err()
+}
+
+// sketchSeed reads the optional "seed" sketch parameter (JSON numbers decode as float64).
+func sketchSeed(parameters map[string]interface{}) uint64 {
+	if seed, ok := parameters["seed"].(float64); ok && seed >= 0 {
+		return uint64(seed)
+	}
+	return 0
 }
 
 func convertToFloat64API(val any) (float64, bool) {
@@ -545,39 +1343,16 @@ func convertToFloat64API(val any) (float64, bool) {
 	return 0, false
 }
 
-func identifyAggregationColumns(results []map[string]any) []string {
-	if len(results) == 0 {
-		return nil
-	}
-
-	var aggCols []string
-	for col := range results[0] {
-		colUpper := strings.ToUpper(col)
-		if strings.Contains(colUpper, "COUNT") ||
-			strings.Contains(colUpper, "SUM") ||
-			strings.Contains(colUpper, "AVG") ||
-			strings.Contains(colUpper, "TOTAL") ||
-			strings.Contains(colUpper, "REVENUE") ||
-			strings.Contains(colUpper, "ORDERS") {
-			aggCols = append(aggCols, col)
-		}
-	}
-	return aggCols
-}
-
-func getAggregationType(columnName string) string {
-	colUpper := strings.ToUpper(columnName)
-	if strings.Contains(colUpper, "COUNT") {
+// boundsAggregationType is the ml.ErrorEstimator aggregation type for agg,
+// or "" when it has no analytical bounds.
+func boundsAggregationType(agg planner.OutputAggregate) string {
+	switch {
+	case agg.Function == "COUNT" && agg.Scaled:
 		return "COUNT"
-	}
-	if strings.Contains(colUpper, "SUM") || strings.Contains(colUpper, "TOTAL") || strings.Contains(colUpper, "REVENUE") {
+	case agg.Scaled:
 		return "SUM"
-	}
-	if strings.Contains(colUpper, "AVG") || strings.Contains(colUpper, "MEAN") {
+	case agg.Function == "AVG":
 		return "AVG"
 	}
-	if strings.Contains(colUpper, "DISTINCT") {
-		return "DISTINCT"
-	}
-	return "COUNT"
+	return ""
 }
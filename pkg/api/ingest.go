@@ -0,0 +1,331 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/sampler"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/sketches"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// maxIngestRows bounds the rows one POST /tables/{name}/rows appends.
+const maxIngestRows = 10000
+
+// IngestRequest is the body of POST /tables/{name}/rows: rows to append,
+// each mapping column names to values. Columns a row leaves out get their
+// default.
+type IngestRequest struct {
+	Rows []map[string]any `json:"rows"`
+}
+
+// IngestResult reports an append and the synopses it kept current.
+type IngestResult struct {
+	Table    string                  `json:"table"`
+	Inserted int                     `json:"inserted"`
+	Sketches []storage.SketchInfo    `json:"sketches"`
+	Samples  []*sampler.AppendResult `json:"samples"`
+	// Stale lists the synopses left to go stale: degraded sketches, which
+	// await a rebuild anyway, and samples sampler.Appendable rules out.
+	Stale []string `json:"stale,omitempty"`
+}
+
+// PostTableRows appends rows to a table and, in the same transaction,
+// folds them into every sketch of the table and draws them into every
+// sample of it, so neither needs rebuilding after the append. It needs
+// rowids to find the appended rows, so only SQLite supports it.
+func (h *Handler) PostTableRows(w http.ResponseWriter, r *http.Request) {
+	if h.rejectInSafeMode(w) {
+		return
+	}
+	if err := storage.RequireSQLite("row ingestion"); err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	table := mux.Vars(r)["name"]
+	var req IngestRequest
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber() // numbers bind as text, which the column's affinity converts
+	if err := dec.Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "invalid json"})
+		return
+	}
+	if len(req.Rows) == 0 || len(req.Rows) > maxIngestRows {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": fmt.Sprintf("between 1 and %d rows required", maxIngestRows)})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	exists, err := storage.TableExists(ctx, h.db, table)
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	if !exists {
+		writeJSON(w, http.StatusNotFound, JSON{"error": "no such table: " + table})
+		return
+	}
+	tableCols, err := storage.TableColumns(ctx, h.db, table)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
+		return
+	}
+	columns, err := ingestColumns(tableCols, req.Rows)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": err.Error()})
+		return
+	}
+
+	var res *IngestResult
+	err = h.guard.Do(ctx, func(ctx context.Context) error {
+		var ingestErr error
+		res, ingestErr = h.ingest(ctx, table, columns, req.Rows)
+		return ingestErr
+	})
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "result": res})
+}
+
+// ingestColumns resolves the columns rows name, in table order, as
+// tableCols spells them, and checks every value is a scalar.
+func ingestColumns(tableCols []string, rows []map[string]any) ([]string, error) {
+	named := make(map[string]bool)
+	for _, row := range rows {
+		for name, v := range row {
+			switch v.(type) {
+			case nil, string, bool, json.Number:
+			default:
+				return nil, fmt.Errorf("column %s: values must be strings, numbers, booleans or null", name)
+			}
+			named[strings.ToLower(name)] = true
+		}
+	}
+	var columns []string
+	for _, c := range tableCols {
+		if named[strings.ToLower(c)] {
+			columns = append(columns, c)
+			delete(named, strings.ToLower(c))
+		}
+	}
+	for name := range named {
+		return nil, fmt.Errorf("no such column: %s", name)
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("rows name no columns")
+	}
+	return columns, nil
+}
+
+// ingest appends rows and updates table's synopses in one transaction.
+func (h *Handler) ingest(ctx context.Context, table string, columns []string, rows []map[string]any) (*IngestResult, error) {
+	sks, err := storage.ListSketches(ctx, h.db, table)
+	if err != nil {
+		return nil, err
+	}
+	samples, err := storage.ListSamples(ctx, h.db, table)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	quoted := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = `"` + strings.ReplaceAll(c, `"`, `""`) + `"`
+	}
+	insert, err := tx.PrepareContext(ctx, fmt.Sprintf("INSERT INTO %s(%s) VALUES (%s)",
+		table, strings.Join(quoted, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")))
+	if err != nil {
+		return nil, err
+	}
+	defer insert.Close()
+	rowids := make([]int64, len(rows))
+	args := make([]any, len(columns))
+	for i, row := range rows {
+		for j, c := range columns {
+			args[j] = nil
+			for name, v := range row {
+				if strings.EqualFold(name, c) {
+					args[j] = v
+				}
+			}
+		}
+		r, err := insert.ExecContext(ctx, args...)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", i, err)
+		}
+		if rowids[i], err = r.LastInsertId(); err != nil {
+			return nil, err
+		}
+	}
+
+	res := &IngestResult{Table: table, Inserted: len(rows), Sketches: []storage.SketchInfo{}, Samples: []*sampler.AppendResult{}}
+	for _, sk := range sks {
+		if sk.Degraded {
+			res.Stale = append(res.Stale, fmt.Sprintf("%s sketch on %s", sk.Type, sk.Column))
+			continue
+		}
+		data, _, err := storage.GetSketch(ctx, tx, table, sk.Column, string(sk.Type))
+		if err == sql.ErrNoRows {
+			continue // dropped since it was listed
+		}
+		if err != nil {
+			return nil, err
+		}
+		if data, err = appendToSketch(ctx, tx, sk, data, rowids); err != nil {
+			return nil, fmt.Errorf("%s sketch on %s: %w", sk.Type, sk.Column, err)
+		}
+		if err := storage.AppendSketch(ctx, tx, table, sk.Column, string(sk.Type), data, int64(len(rows))); err != nil {
+			return nil, err
+		}
+		sk.BaseRows += int64(len(rows))
+		res.Sketches = append(res.Sketches, sk)
+	}
+	for _, info := range samples {
+		if !sampler.Appendable(info) {
+			res.Stale = append(res.Stale, info.SampleTable)
+			continue
+		}
+		appended, err := sampler.AppendRows(ctx, tx, info, rowids)
+		if err != nil {
+			return nil, err
+		}
+		res.Samples = append(res.Samples, appended)
+	}
+	if err := storage.AddRecordedRows(ctx, tx, table, int64(len(rows))); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// appendToSketch adds the rows rowids, just appended to sk's table in tx,
+// to data, sk serialized, reading their values as sk's builder reads its
+// table's, so the result is the sketch a rebuild would make.
+func appendToSketch(ctx context.Context, tx *sql.Tx, sk storage.SketchInfo, data []byte, rowids []int64) ([]byte, error) {
+	if sk.Column == "" {
+		if sk.Type != storage.CountMinSketchType {
+			return nil, fmt.Errorf("sketch has no column")
+		}
+		cms, err := sketches.DeserializeCountMinSketch(data)
+		if err != nil {
+			return nil, err
+		}
+		cms.Add([]byte("total"), uint64(len(rowids)))
+		return cms.Serialize(), nil
+	}
+
+	// each builder but the Bloom filter's reads the column as declared
+	expr := sk.Column
+	if sk.Type == storage.BloomFilterType {
+		expr = "+" + sk.Column
+	}
+	args := make([]any, len(rowids))
+	for i, id := range rowids {
+		args[i] = id
+	}
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s WHERE rowid IN (%s) AND %s IS NOT NULL",
+		expr, sk.Table, strings.TrimSuffix(strings.Repeat("?, ", len(rowids)), ", "), sk.Column), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var values []any
+	for rows.Next() {
+		var v any
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	switch sk.Type {
+	case storage.HyperLogLogType:
+		hll, err := sketches.DeserializeHyperLogLog(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			var s sql.NullString
+			if err := s.Scan(v); err != nil {
+				return nil, err
+			}
+			hll.Add([]byte(s.String))
+		}
+		return hll.Serialize(), nil
+	case storage.CountMinSketchType:
+		cms, err := sketches.DeserializeCountMinSketch(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			var s sql.NullString
+			if err := s.Scan(v); err != nil {
+				return nil, err
+			}
+			cms.Add([]byte(s.String), 1)
+		}
+		return cms.Serialize(), nil
+	case storage.TDigestType:
+		td, err := sketches.DeserializeTDigest(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			var f sql.NullFloat64
+			if err := f.Scan(v); err != nil {
+				return nil, fmt.Errorf("t-digest needs a numeric column: %w", err)
+			}
+			td.Add(f.Float64)
+		}
+		return td.Serialize(), nil
+	case storage.BloomFilterType:
+		bf, err := sketches.DeserializeBloomFilter(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			bf.AddValue(v)
+		}
+		return bf.Serialize(), nil
+	case storage.AMSSketchType:
+		ams, err := sketches.DeserializeAMSSketch(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			ams.AddValue(v, 1)
+		}
+		return ams.Serialize(), nil
+	case storage.TopKSketchType:
+		topk, err := sketches.DeserializeTopKSketch(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			topk.AddValue(v, 1)
+		}
+		return topk.Serialize(), nil
+	}
+	return nil, fmt.Errorf("unsupported sketch type %q", sk.Type)
+}
@@ -0,0 +1,327 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/executor"
+)
+
+// jobResultLimit is the rows a job result request reads without a limit.
+const jobResultLimit = 1000
+
+// jobErrorBody bounds how much of a failed job's response is kept to read
+// its error from.
+const jobErrorBody = 4 << 10
+
+const (
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// asyncJob is one query submitted to POST /jobs. A finished job's answer is
+// spooled like a paginated result, and the job is kept until Expires, when
+// maintenance drops it and its table.
+type asyncJob struct {
+	ID        string     `json:"job_id"`
+	SQL       string     `json:"sql"`
+	Status    string     `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	Submitted time.Time  `json:"submitted_at"`
+	Finished  *time.Time `json:"finished_at,omitempty"`
+	Rows      int        `json:"rows,omitempty"`
+	Expires   *time.Time `json:"expires_at,omitempty"`
+
+	result *spooledResult
+}
+
+// jobStore holds the async jobs running or awaiting retrieval, at most max
+// at once; finished ones expire ttl after they finish.
+type jobStore struct {
+	mu   sync.Mutex
+	max  int
+	ttl  time.Duration
+	jobs map[string]*asyncJob
+}
+
+func newJobStore(max int, ttl time.Duration) *jobStore {
+	return &jobStore{max: max, ttl: ttl, jobs: make(map[string]*asyncJob)}
+}
+
+func (s *jobStore) enabled() bool {
+	return s != nil && s.max > 0 && s.ttl > 0
+}
+
+// add registers j unless max jobs are already kept.
+func (s *jobStore) add(j *asyncJob) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.jobs) >= s.max {
+		return false
+	}
+	s.jobs[j.ID] = j
+	return true
+}
+
+// get returns a copy of id's job, or false when it is unknown or expired.
+func (s *jobStore) get(id string, now time.Time) (asyncJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok || (j.Expires != nil && now.After(*j.Expires)) {
+		return asyncJob{}, false
+	}
+	return *j, true
+}
+
+// finish records id's outcome: its spooled result, or errMsg when it failed.
+func (s *jobStore) finish(id string, result *spooledResult, errMsg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j := s.jobs[id]
+	now := time.Now()
+	expires := now.Add(s.ttl)
+	j.Finished, j.Expires = &now, &expires
+	if result == nil {
+		j.Status, j.Error = jobFailed, errMsg
+		return
+	}
+	result.expires = expires
+	j.Status, j.Rows, j.result = jobDone, result.rows, result
+}
+
+// expire removes the finished jobs past their expiry and returns their
+// result tables.
+func (s *jobStore) expire(now time.Time) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var expired []string
+	for id, j := range s.jobs {
+		if j.Expires == nil || !now.After(*j.Expires) {
+			continue
+		}
+		delete(s.jobs, id)
+		if j.result != nil {
+			expired = append(expired, j.result.table)
+		}
+	}
+	return expired
+}
+
+// PostJob runs a query in the background: it answers 202 with the job's
+// id at once, and GET /jobs/{id}/result reads the answer once it is done.
+// Jobs run at batch priority unless the request names one.
+func (h *Handler) PostJob(w http.ResponseWriter, r *http.Request) {
+	if h.config.SafeMode {
+		writeJSON(w, http.StatusServiceUnavailable, JSON{"error": "safe mode: async jobs disabled"})
+		return
+	}
+	if !h.jobs.enabled() {
+		writeJSON(w, http.StatusServiceUnavailable, JSON{"error": "async jobs disabled"})
+		return
+	}
+	var req QueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "invalid json"})
+		return
+	}
+	if req.PageToken != "" || req.Progressive || req.Explain {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "jobs take neither page_token, progressive nor explain"})
+		return
+	}
+	if req.Priority == "" {
+		req.Priority = string(PriorityBatch)
+	}
+	if err := h.resolvePassthrough(&req, h.grants(r, RoleAdmin)); err != nil {
+		writeJSON(w, errorStatus(err, http.StatusBadRequest), errorBody(err))
+		return
+	}
+	// validated now so a bad query fails here; the job prepares it again
+	check := req
+	if _, _, err := prepareQuery(&check); err != nil {
+		writeJSON(w, errorStatus(err, http.StatusBadRequest), errorBody(err))
+		return
+	}
+	if err := h.config.QueryLimits.Check(check.SQL); err != nil {
+		writeJSON(w, errorStatus(err, http.StatusBadRequest), errorBody(err))
+		return
+	}
+
+	var raw [16]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
+		return
+	}
+	h.dropStaged(r.Context(), h.jobs.expire(time.Now()))
+	j := &asyncJob{ID: hex.EncodeToString(raw[:]), SQL: check.SQL, Status: jobRunning, Submitted: time.Now()}
+	if !h.jobs.add(j) {
+		writeJSON(w, http.StatusTooManyRequests, JSON{"error": fmt.Sprintf("%d jobs already running or awaiting retrieval", h.jobs.max)})
+		return
+	}
+	go h.runJob(j.ID, req)
+	writeJSON(w, http.StatusAccepted, JSON{"job_id": j.ID, "status": jobRunning, "status_url": "/jobs/" + j.ID})
+}
+
+// runJob answers req as /query would and spools the answer for job id.
+func (h *Handler) runJob(id string, req QueryRequest) {
+	var answer *QueryResponse
+	req.PageSize = 0
+	req.onAnswer = func(resp *QueryResponse) {
+		a := *resp
+		answer = &a
+	}
+	r, err := http.NewRequestWithContext(context.Background(), http.MethodPost, "/jobs", http.NoBody)
+	if err != nil {
+		h.jobs.finish(id, nil, err.Error())
+		return
+	}
+	w := &jobWriter{header: make(http.Header)}
+	h.serveQuery(w, r, req)
+	if answer == nil {
+		h.jobs.finish(id, nil, w.errorMessage())
+		return
+	}
+	if answer.Result == nil {
+		answer.Result = executor.NewResultSet(nil)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+	e, _, err := h.spool(ctx, answer, 0, h.jobs.ttl)
+	if err != nil {
+		log.Printf("spooling job %s result: %v", id, err)
+		h.jobs.finish(id, nil, "spooling result: "+err.Error())
+		return
+	}
+	h.jobs.finish(id, e, "")
+}
+
+// jobWriter takes a job's /query response, keeping its status and, for a
+// failure, the start of its body.
+type jobWriter struct {
+	header http.Header
+	status int
+	body   []byte
+}
+
+func (w *jobWriter) Header() http.Header { return w.header }
+
+func (w *jobWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *jobWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.status >= 400 && len(w.body) < jobErrorBody {
+		w.body = append(w.body, b[:min(len(b), jobErrorBody-len(w.body))]...)
+	}
+	return len(b), nil
+}
+
+func (w *jobWriter) errorMessage() string {
+	var body struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(w.body, &body) == nil && body.Error != "" {
+		return body.Error
+	}
+	return fmt.Sprintf("query failed with status %d", w.status)
+}
+
+// GetJob returns a job's status.
+func (h *Handler) GetJob(w http.ResponseWriter, r *http.Request) {
+	j, ok := h.jobs.get(mux.Vars(r)["id"], time.Now())
+	if !ok {
+		writeJSON(w, http.StatusNotFound, JSON{"error": "job expired or unknown"})
+		return
+	}
+	resp := JSON{"job": j}
+	if j.Status == jobDone {
+		resp["result_url"] = "/jobs/" + j.ID + "/result"
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// GetJobResult reads limit rows (default jobResultLimit) of a finished
+// job's answer after the first offset, with the answer's plan and meta.
+func (h *Handler) GetJobResult(w http.ResponseWriter, r *http.Request) {
+	j, ok := h.jobs.get(mux.Vars(r)["id"], time.Now())
+	if !ok {
+		writeJSON(w, http.StatusNotFound, JSON{"error": "job expired or unknown"})
+		return
+	}
+	if j.Status != jobDone {
+		writeJSON(w, http.StatusConflict, JSON{"error": "job " + j.Status, "job": j})
+		return
+	}
+	offset, limit := 0, jobResultLimit
+	if v := r.URL.Query().Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeJSON(w, http.StatusBadRequest, JSON{"error": "offset must be a non-negative integer"})
+			return
+		}
+		offset = n
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeJSON(w, http.StatusBadRequest, JSON{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = n
+	}
+
+	e := j.result
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	var page *executor.ResultSet
+	err := h.guard.Do(ctx, func(ctx context.Context) error {
+		var readErr error
+		page, readErr = readSpooled(ctx, h.readDB, e, offset, limit)
+		return readErr
+	})
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+
+	meta := make(map[string]any, len(e.meta)+2)
+	for k, v := range e.meta {
+		meta[k] = v
+	}
+	pm := pageMeta(e, offset, page.Len())
+	if next := offset + page.Len(); next < e.rows {
+		pm["next_offset"] = next
+	}
+	meta["page"] = pm
+	meta["job_id"] = j.ID
+	writeQueryResponse(w, http.StatusOK, QueryResponse{Status: "ok", Plan: e.plan, Result: page, Meta: meta})
+}
+
+// expireResults drops the spooled pages and job results past their
+// expiry, so a result nobody reads again doesn't outlive its TTL.
+func (h *Handler) expireResults(timeout time.Duration) {
+	now := time.Now()
+	expired := h.pages.expire(now)
+	expired = append(expired, h.jobs.expire(now)...)
+	if len(expired) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	h.dropStaged(ctx, expired)
+}
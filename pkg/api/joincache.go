@@ -0,0 +1,257 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// stagedJoin is one universe join's matched sample rows, staged as a left
+// and a right table whose rowids pair them up. A join over more than the
+// cache's row limit is remembered as oversized instead, so it isn't staged
+// again until it expires.
+type stagedJoin struct {
+	left, right string
+	rows        int64
+	oversized   bool
+	stagedAt    time.Time
+	expires     time.Time
+	uses        int
+}
+
+// joinCache keeps staged universe joins keyed by their join signature (the
+// join of the two samples, as SQL) and the versions of both samples, so a
+// dashboard repeating a join reads the staged rows instead of joining the
+// samples again. A refreshed sample changes the key; entries also expire
+// after ttl, and past max the least recently used is dropped.
+type joinCache struct {
+	mu      sync.Mutex
+	max     int
+	maxRows int64
+	ttl     time.Duration
+	entries map[string]*stagedJoin
+	order   []string // least recently used first
+
+	// stageMu serializes staging, so concurrent misses stage a join once.
+	stageMu sync.Mutex
+}
+
+func newJoinCache(max int, maxRows int64, ttl time.Duration) *joinCache {
+	return &joinCache{max: max, maxRows: maxRows, ttl: ttl, entries: make(map[string]*stagedJoin)}
+}
+
+func (c *joinCache) enabled() bool {
+	return c != nil && c.max > 0 && c.ttl > 0 && storage.ActiveDialect().HasRowid()
+}
+
+// get returns key's live entry, counting the use, and the tables of any
+// entries that expired, for the caller to drop.
+func (c *joinCache) get(key string, now time.Time) (*stagedJoin, []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expired := c.expire(now)
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, expired
+	}
+	e.uses++
+	c.touch(key)
+	copied := *e
+	return &copied, expired
+}
+
+// put adds e under key and returns the tables of the entries it pushed out.
+func (c *joinCache) put(key string, e *stagedJoin) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = e
+	c.touch(key)
+	var evicted []string
+	for len(c.order) > c.max {
+		evicted = append(evicted, c.remove(c.order[0])...)
+	}
+	return evicted
+}
+
+func (c *joinCache) expire(now time.Time) []string {
+	var expired []string
+	for key, e := range c.entries {
+		if now.After(e.expires) {
+			expired = append(expired, c.remove(key)...)
+		}
+	}
+	return expired
+}
+
+func (c *joinCache) touch(key string) {
+	for i, k := range c.order {
+		if k == key {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+	c.order = append(c.order, key)
+}
+
+func (c *joinCache) remove(key string) []string {
+	e := c.entries[key]
+	delete(c.entries, key)
+	for i, k := range c.order {
+		if k == key {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+	if e == nil || e.oversized {
+		return nil
+	}
+	return []string{e.left, e.right}
+}
+
+// stageJoin returns plan reading its universe join from the join cache,
+// staging the join first on a miss, and what to report of it in the
+// answer's meta. Plans that aren't universe joins, and joins the cache
+// can't or won't hold, are returned as they are with no report.
+func (h *Handler) stageJoin(ctx context.Context, plan *planner.Plan) (*planner.Plan, map[string]any) {
+	c := h.joinCache
+	if plan.Type != planner.PlanSample || plan.JoinSampleTable == "" || h.config.SafeMode || !c.enabled() {
+		return plan, nil
+	}
+	pairs, rewrite, ok := planner.StageUniverseJoin(plan.SQL)
+	if !ok {
+		return plan, nil
+	}
+	_, leftVersion, err := storage.TableVersions(ctx, h.db, "", plan.SampleTable)
+	if err != nil {
+		return plan, nil
+	}
+	_, rightVersion, err := storage.TableVersions(ctx, h.db, "", plan.JoinSampleTable)
+	if err != nil {
+		return plan, nil
+	}
+	sum := sha256.Sum256([]byte(strings.Join([]string{pairs, leftVersion, rightVersion}, "\x00")))
+	key := hex.EncodeToString(sum[:8])
+
+	e, expired := c.get(key, time.Now())
+	h.dropStaged(ctx, expired)
+	reused := e != nil
+	if e == nil {
+		c.stageMu.Lock()
+		if e, expired = c.get(key, time.Now()); e == nil {
+			e = h.stage(ctx, key, plan, pairs)
+			if e != nil {
+				cached := *e // the cache counts uses on its own copy
+				h.dropStaged(ctx, c.put(key, &cached))
+			}
+		}
+		c.stageMu.Unlock()
+		h.dropStaged(ctx, expired)
+		reused = e != nil && e.uses > 0
+	}
+	if e == nil || e.oversized {
+		return plan, nil
+	}
+
+	staged := *plan
+	staged.SQL = rewrite(e.left, e.right)
+	return &staged, map[string]any{
+		"reused":     reused,
+		"rows":       e.rows,
+		"uses":       e.uses,
+		"staged_at":  e.stagedAt,
+		"expires_at": e.expires,
+	}
+}
+
+// stage materializes the pairs plan's join matches as the two tables of a
+// staged join keyed by key. The result is nil when staging failed, and
+// marked oversized, with its tables dropped, past the cache's row limit.
+func (h *Handler) stage(ctx context.Context, key string, plan *planner.Plan, pairs string) *stagedJoin {
+	c := h.joinCache
+	now := time.Now()
+	e := &stagedJoin{
+		left:     storage.JoinCachePrefix + key + "_l",
+		right:    storage.JoinCachePrefix + key + "_r",
+		stagedAt: now,
+		expires:  now.Add(c.ttl),
+	}
+	pairsTable := storage.JoinCachePrefix + key + "_pairs"
+	err := h.guard.Do(ctx, func(ctx context.Context) error {
+		for _, stmt := range []string{
+			"DROP TABLE IF EXISTS " + pairsTable,
+			"DROP TABLE IF EXISTS " + e.left,
+			"DROP TABLE IF EXISTS " + e.right,
+			fmt.Sprintf("CREATE TABLE %s AS %s", pairsTable, pairs),
+		} {
+			if _, err := h.db.ExecContext(ctx, stmt); err != nil {
+				return err
+			}
+		}
+		if err := h.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+pairsTable).Scan(&e.rows); err != nil {
+			return err
+		}
+		if c.maxRows > 0 && e.rows > c.maxRows {
+			e.oversized = true
+			_, err := h.db.ExecContext(ctx, "DROP TABLE IF EXISTS "+pairsTable)
+			return err
+		}
+		// rowids follow the pairs' order, so both sides' rowids pair up
+		for _, stmt := range []string{
+			fmt.Sprintf("CREATE TABLE %s AS SELECT s.* FROM %s p JOIN %s s ON s.rowid = p.l ORDER BY p.rowid", e.left, pairsTable, plan.SampleTable),
+			fmt.Sprintf("CREATE TABLE %s AS SELECT s.* FROM %s p JOIN %s s ON s.rowid = p.r ORDER BY p.rowid", e.right, pairsTable, plan.JoinSampleTable),
+			"DROP TABLE IF EXISTS " + pairsTable,
+		} {
+			if _, err := h.db.ExecContext(ctx, stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("staging join of %s and %s: %v", plan.SampleTable, plan.JoinSampleTable, err)
+		h.dropStaged(ctx, []string{pairsTable, e.left, e.right})
+		return nil
+	}
+	return e
+}
+
+// dropStaged drops staged join tables the cache let go of. A table still
+// being read is left for the next server start to sweep.
+func (h *Handler) dropStaged(ctx context.Context, tables []string) {
+	for _, t := range tables {
+		if _, err := h.db.ExecContext(ctx, "DROP TABLE IF EXISTS "+t); err != nil {
+			log.Printf("dropping staged join table %s: %v", t, err)
+		}
+	}
+}
+
+// sweepCacheTables drops the staged join and spooled result tables a
+// previous run left behind; the caches start empty, so none of them would
+// be read again.
+func (h *Handler) sweepCacheTables(ctx context.Context) {
+	rows, err := h.db.QueryContext(ctx, storage.ActiveDialect().ListTablesQuery())
+	if err != nil {
+		return
+	}
+	var stale []string
+	for rows.Next() {
+		var name string
+		if rows.Scan(&name) != nil {
+			continue
+		}
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, storage.JoinCachePrefix) || strings.HasPrefix(lower, storage.ResultSpoolPrefix) {
+			stale = append(stale, name)
+		}
+	}
+	rows.Close()
+	h.dropStaged(ctx, stale)
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/sampler"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// CreateJoinSynopsisRequest declares the join a join synopsis holds: the
+// inner join of LeftTable and RightTable on LeftKey = RightKey, over a
+// Fraction sample of LeftTable's rows. LeftTable should be the side
+// holding the foreign key, so the synopsis samples the join uniformly.
+type CreateJoinSynopsisRequest struct {
+	LeftTable  string  `json:"left_table"`
+	RightTable string  `json:"right_table"`
+	LeftKey    string  `json:"left_key"`
+	RightKey   string  `json:"right_key"`
+	Fraction   float64 `json:"fraction"`
+}
+
+// PostCreateJoinSynopsis materializes a join synopsis, replacing the one
+// on the same tables, keys and fraction. Queries joining the two tables on
+// those keys then read the synopsis instead of sampling either side.
+func (h *Handler) PostCreateJoinSynopsis(w http.ResponseWriter, r *http.Request) {
+	if h.rejectInSafeMode(w) {
+		return
+	}
+	var req CreateJoinSynopsisRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "invalid json"})
+		return
+	}
+	if req.LeftTable == "" || req.RightTable == "" || req.LeftKey == "" || req.RightKey == "" || req.Fraction <= 0 || req.Fraction >= 1 {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "left_table, right_table, left_key, right_key and 0<fraction<1 required"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+	var synopsis *storage.JoinSynopsis
+	err := h.guard.DoOnce(ctx, func(ctx context.Context) error {
+		var createErr error
+		synopsis, createErr = sampler.CreateJoinSynopsis(ctx, h.db, req.LeftTable, req.RightTable, req.LeftKey, req.RightKey, req.Fraction)
+		return createErr
+	})
+	if err != nil {
+		writeJSON(w, sampleErrorStatus(err), JSON{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "join_synopsis": synopsis})
+}
+
+// GetJoinSynopses lists the join synopses, those with ?table= on either
+// side when given.
+func (h *Handler) GetJoinSynopses(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	synopses, err := storage.ListJoinSynopses(ctx, h.readDB, r.URL.Query().Get("table"))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
+		return
+	}
+	if synopses == nil {
+		synopses = []storage.JoinSynopsis{}
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "join_synopses": synopses})
+}
+
+// DeleteJoinSynopsis drops the join synopsis {name} and its tables.
+func (h *Handler) DeleteJoinSynopsis(w http.ResponseWriter, r *http.Request) {
+	if h.rejectInSafeMode(w) {
+		return
+	}
+	name := mux.Vars(r)["name"]
+	if !strings.HasPrefix(name, storage.JoinSynopsisPrefix) {
+		writeJSON(w, http.StatusNotFound, JSON{"error": "no join synopsis " + name})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+	var found bool
+	err := h.guard.Do(ctx, func(ctx context.Context) error {
+		var dropErr error
+		found, dropErr = storage.DropJoinSynopsis(ctx, h.db, name)
+		return dropErr
+	})
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	if !found {
+		writeJSON(w, http.StatusNotFound, JSON{"error": "no join synopsis " + name})
+		return
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "dropped": name})
+}
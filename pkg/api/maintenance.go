@@ -0,0 +1,358 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/sampler"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// SynopsisMaintenance reports one maintenance pass over a table's samples
+// and sketches.
+type SynopsisMaintenance struct {
+	Table            string                   `json:"table"`
+	RecordedRows     int64                    `json:"recorded_rows"`
+	CurrentRows      int64                    `json:"current_rows"`
+	Shrunk           bool                     `json:"shrunk"`
+	DegradedSketches int64                    `json:"degraded_sketches"`
+	RebuiltSketches  []string                 `json:"rebuilt_sketches,omitempty"`
+	RefreshedSamples []*sampler.RefreshResult `json:"refreshed_samples,omitempty"`
+	CaughtUpSamples  []*sampler.AppendResult  `json:"caught_up_samples,omitempty"`
+	// Drifted is set when the table hadn't shrunk but had drifted from some
+	// of its synopses, or its checksum changed, so they were refreshed (see
+	// refreshDrifted).
+	Drifted         bool `json:"drifted,omitempty"`
+	ChecksumChanged bool `json:"checksum_changed,omitempty"`
+	// Deferred is set when rebuilds were due but left for the table's
+	// maintenance window.
+	Deferred bool     `json:"deferred,omitempty"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// maintainTable checks table for deletes and brings its synopses back in
+// line. A table has shrunk when its row count fell below the recorded count
+// or below the base count of any of its synopses; sketches can't forget
+// rows, so they are marked degraded and rebuilt, and samples are refreshed.
+// force treats the table as changed, for updates that leave the count as is.
+// A table that hasn't shrunk has the synopses it drifted from refreshed
+// and its live samples caught up with the rows appended since they last
+// were instead (see refreshDrifted). In safe mode sketches are only
+// marked degraded. A background pass rebuilds and catches up only within
+// the table's maintenance window, at background priority (see
+// scheduleMaintenance); outside it the work is deferred to a later pass.
+func (h *Handler) maintainTable(ctx context.Context, table string, force, background bool) *SynopsisMaintenance {
+	m := &SynopsisMaintenance{Table: table}
+	fail := func(what string, err error) {
+		m.Errors = append(m.Errors, fmt.Sprintf("%s: %v", what, err))
+	}
+	release := func() {}
+	defer func() { release() }()
+	admit := func() (context.Context, bool) {
+		if !background {
+			return ctx, true
+		}
+		runCtx, done, err := h.scheduleMaintenance(ctx, table)
+		switch {
+		case errors.Is(err, errOutsideWindow):
+			m.Deferred = true
+			return nil, false
+		case err != nil:
+			fail("scheduling", err)
+			return nil, false
+		}
+		ctx, release = runCtx, done
+		return ctx, true
+	}
+
+	recorded, err := storage.RecordedRowCount(ctx, h.db, table)
+	if err != nil {
+		fail("row stats", err)
+		return m
+	}
+	m.RecordedRows = recorded
+	if m.CurrentRows, err = storage.CountRows(ctx, h.db, table); err != nil {
+		fail("counting rows", err)
+		return m
+	}
+
+	sketchList, err := storage.ListSketches(ctx, h.db, table)
+	if err != nil {
+		fail("listing sketches", err)
+	}
+	samples, err := storage.ListSamples(ctx, h.db, table)
+	if err != nil {
+		fail("listing samples", err)
+	}
+
+	m.Shrunk = m.CurrentRows < recorded
+	for _, sk := range sketchList {
+		m.Shrunk = m.Shrunk || sk.BaseRows > m.CurrentRows
+	}
+	// archived samples are checked once the planner brings them back
+	hot := samples[:0]
+	for _, s := range samples {
+		if !s.Archived {
+			hot = append(hot, s)
+		}
+	}
+	samples = hot
+	for _, s := range samples {
+		m.Shrunk = m.Shrunk || s.BaseRows > m.CurrentRows
+	}
+	if !m.Shrunk && !force {
+		if !h.config.SafeMode {
+			h.refreshDrifted(ctx, m, sketchList, samples, admit)
+		}
+		return m
+	}
+
+	if len(sketchList) > 0 {
+		if m.DegradedSketches, err = storage.MarkSketchesDegraded(ctx, h.db, table); err != nil {
+			fail("marking sketches degraded", err)
+		}
+	}
+	if h.config.SafeMode {
+		return m
+	}
+	if _, ok := admit(); !ok {
+		return m
+	}
+
+	for _, sk := range sketchList {
+		if err := h.rebuildSketch(ctx, sk, m.CurrentRows); err != nil {
+			fail(fmt.Sprintf("rebuilding %s sketch on %s", sk.Type, sk.Column), err)
+			continue
+		}
+		m.RebuiltSketches = append(m.RebuiltSketches, fmt.Sprintf("%s(%s)", sk.Type, sk.Column))
+	}
+	for _, s := range samples {
+		res, err := sampler.RefreshSample(ctx, h.db, s)
+		if err != nil {
+			fail("refreshing "+s.SampleTable, err)
+			continue
+		}
+		m.RefreshedSamples = append(m.RefreshedSamples, res)
+	}
+
+	if err := storage.UpsertTableRowCount(ctx, h.db, table, m.CurrentRows); err != nil {
+		fail("recording row count", err)
+	}
+	return m
+}
+
+// catchUpLiveSamples runs sampler.CatchUpReservoir over the live samples
+// among samples that lag their table, once admit lets it, and records the
+// table's count when any caught up.
+func (h *Handler) catchUpLiveSamples(ctx context.Context, m *SynopsisMaintenance, samples []storage.SampleInfo, admit func() (context.Context, bool)) {
+	admitted := false
+	for _, s := range samples {
+		if s.ReservoirSize == 0 {
+			continue
+		}
+		lag, err := storage.ReservoirLag(ctx, h.db, s.Table, s.ScannedRowid)
+		if err != nil {
+			m.Errors = append(m.Errors, fmt.Sprintf("checking %s: %v", s.SampleTable, err))
+			continue
+		}
+		if lag == 0 {
+			continue
+		}
+		if !admitted {
+			runCtx, ok := admit()
+			if !ok {
+				return
+			}
+			ctx, admitted = runCtx, true
+		}
+		var res *sampler.AppendResult
+		err = h.guard.Do(ctx, func(ctx context.Context) error {
+			tx, err := h.db.BeginTx(ctx, nil)
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+			if res, err = sampler.CatchUpReservoir(ctx, tx, s); err != nil {
+				return err
+			}
+			return tx.Commit()
+		})
+		if err != nil {
+			m.Errors = append(m.Errors, fmt.Sprintf("catching up %s: %v", s.SampleTable, err))
+			continue
+		}
+		m.CaughtUpSamples = append(m.CaughtUpSamples, res)
+	}
+	if len(m.CaughtUpSamples) > 0 {
+		if err := storage.UpsertTableRowCount(ctx, h.db, m.Table, m.CurrentRows); err != nil {
+			m.Errors = append(m.Errors, fmt.Sprintf("recording row count: %v", err))
+		}
+	}
+}
+
+// rebuildSketch recreates sk from its stored parameters, which also clears
+// its degraded flag.
+func (h *Handler) rebuildSketch(ctx context.Context, sk storage.SketchInfo, baseRows int64) error {
+	var data []byte
+	var err error
+	switch sk.Type {
+	case storage.HyperLogLogType:
+		data, err = h.createHyperLogLogSketch(ctx, sk.Table, sk.Column, sk.Parameters)
+	case storage.CountMinSketchType:
+		data, err = h.createCountMinSketch(ctx, sk.Table, sk.Column, sk.Parameters)
+	case storage.TDigestType:
+		data, err = h.createTDigestSketch(ctx, sk.Table, sk.Column, sk.Parameters)
+	case storage.BloomFilterType:
+		data, err = h.createBloomFilter(ctx, sk.Table, sk.Column, sk.Parameters)
+	case storage.AMSSketchType:
+		data, err = h.createAMSSketch(ctx, sk.Table, sk.Column, sk.Parameters)
+	case storage.TopKSketchType:
+		data, err = h.createTopKSketch(ctx, sk.Table, sk.Column, sk.Parameters)
+	default:
+		return fmt.Errorf("unsupported sketch type %q", sk.Type)
+	}
+	if err != nil {
+		return err
+	}
+	parametersJSON, _ := json.Marshal(sk.Parameters)
+	return h.guard.Do(ctx, func(ctx context.Context) error {
+		return storage.UpsertSketch(ctx, h.db, sk.Table, sk.Column, string(sk.Type), data, string(parametersJSON), baseRows)
+	})
+}
+
+// maintainSynopses runs maintainTable over every table with a synopsis.
+func (h *Handler) maintainSynopses(ctx context.Context, force, background bool) ([]*SynopsisMaintenance, error) {
+	tables, err := storage.SynopsisTables(ctx, h.db)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*SynopsisMaintenance, 0, len(tables))
+	for _, t := range tables {
+		out = append(out, h.maintainTable(ctx, t, force, background))
+	}
+	return out, nil
+}
+
+// runMaintenance drops expired spooled results and checks every synopsis
+// for deletes and drift once per interval, archives samples unused for ArchiveAfter
+// when that is set, keeps synopses within the storage budget, and then
+// refreshes the database's planner statistics, so they reflect what
+// maintenance rebuilt.
+func (h *Handler) runMaintenance(interval time.Duration) {
+	// tables never analyzed are picked up by optimize right away; the
+	// first full ANALYZE waits for AnalyzeInterval
+	h.lastAnalyze = time.Now()
+	h.analyzeDatabase(interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		h.expireResults(interval)
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		reports, err := h.maintainSynopses(ctx, false, true)
+		cancel()
+		if err != nil {
+			log.Printf("synopsis maintenance: %v", err)
+			continue
+		}
+		for _, m := range reports {
+			for _, res := range m.CaughtUpSamples {
+				log.Printf("synopsis maintenance on %s: live sample %s caught up to %d rows, %d added, %d evicted",
+					m.Table, res.SampleTable, res.BaseRows, res.Added, res.Evicted)
+			}
+			if m.Deferred {
+				log.Printf("synopsis maintenance on %s: rebuilds deferred to its maintenance window", m.Table)
+			}
+			if m.Drifted {
+				log.Printf("synopsis maintenance on %s: drifted to %d rows (checksum changed: %v), %d sketches rebuilt, %d samples redrawn",
+					m.Table, m.CurrentRows, m.ChecksumChanged, len(m.RebuiltSketches), len(m.RefreshedSamples))
+			}
+			if m.Shrunk || len(m.Errors) > 0 {
+				log.Printf("synopsis maintenance on %s: %d -> %d rows, %d sketches degraded, %d rebuilt, %d samples refreshed, errors %v",
+					m.Table, m.RecordedRows, m.CurrentRows, m.DegradedSketches, len(m.RebuiltSketches), len(m.RefreshedSamples), m.Errors)
+			}
+		}
+		h.archiveColdSamples(interval)
+		h.purgeDropped(interval)
+		h.keepStorageBudget(interval)
+		h.analyzeDatabase(interval)
+	}
+}
+
+// analyzeDatabase runs a full ANALYZE when AnalyzeInterval has passed since
+// the last one, and PRAGMA optimize otherwise. The planner reads the
+// resulting statistics for row and group counts, and the database for its
+// own plans, exact baselines included.
+func (h *Handler) analyzeDatabase(timeout time.Duration) {
+	if h.config.AnalyzeInterval <= 0 || h.config.SafeMode {
+		return
+	}
+	full := time.Since(h.lastAnalyze) >= h.config.AnalyzeInterval
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	err := h.guard.Do(ctx, func(ctx context.Context) error {
+		return storage.Analyze(ctx, h.db, full)
+	})
+	if err != nil {
+		log.Printf("analyze (full=%v): %v", full, err)
+		return
+	}
+	if full {
+		h.lastAnalyze = time.Now()
+		log.Printf("analyze: refreshed planner statistics in %s", time.Since(start).Round(time.Millisecond))
+	}
+}
+
+// archiveColdSamples runs the background archive pass.
+func (h *Handler) archiveColdSamples(timeout time.Duration) {
+	if h.config.ArchiveAfter <= 0 || h.config.SafeMode || storage.RequireSQLite("sample archiving") != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	report, err := h.archiveSamples(ctx, nil, h.config.ArchiveAfter)
+	if err != nil {
+		log.Printf("sample archiving: %v", err)
+		return
+	}
+	if len(report.Archived) > 0 || len(report.Errors) > 0 {
+		log.Printf("sample archiving: moved %v to %s, errors %v", report.Archived, report.Path, report.Errors)
+	}
+}
+
+// PostMaintainSynopses runs synopsis maintenance now, for one table or all
+// of them. force refreshes even when no shrink is detected, e.g. after
+// UPDATEs.
+func (h *Handler) PostMaintainSynopses(w http.ResponseWriter, r *http.Request) {
+	if h.rejectInSafeMode(w) {
+		return
+	}
+	var req struct {
+		Table string `json:"table,omitempty"`
+		Force bool   `json:"force"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "invalid json"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Minute)
+	defer cancel()
+
+	var reports []*SynopsisMaintenance
+	if req.Table != "" {
+		reports = []*SynopsisMaintenance{h.maintainTable(ctx, req.Table, req.Force, false)}
+	} else {
+		var err error
+		if reports, err = h.maintainSynopses(ctx, req.Force, false); err != nil {
+			writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "tables": reports})
+}
@@ -0,0 +1,228 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/sampler"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// onboardFractions is the sample ladder onboarding draws: the planner
+// picks the smallest rung that meets a query's tolerance and escalates up
+// the ladder when it misses.
+var onboardFractions = []float64{0.001, 0.01, 0.1}
+
+const (
+	// minOnboardSampleRows is the fewest rows a rung is expected to draw;
+	// smaller rungs would answer nothing within any useful tolerance.
+	minOnboardSampleRows = 1000
+	// minHLLDistinct is the distinct count above which a dimension gets a
+	// HyperLogLog; below it COUNT(DISTINCT) over a sample is cheap enough.
+	minHLLDistinct = 1000
+	// defaultPolicyMaxRelError is the tolerance of an onboarded table's
+	// default policy.
+	defaultPolicyMaxRelError = 0.05
+)
+
+// OnboardReport is what POST /tables/{name}/onboard did to a table.
+type OnboardReport struct {
+	Table    string               `json:"table"`
+	Rows     int64                `json:"rows"`
+	Samples  []OnboardSample      `json:"samples"`
+	Sketches []OnboardSketch      `json:"sketches"`
+	Policy   *storage.TablePolicy `json:"policy,omitempty"`
+	// RowCountTracked is set when the table's row count is now kept up to
+	// date by triggers.
+	RowCountTracked bool `json:"row_count_tracked"`
+	// Skipped says why steps were left out, e.g. a table too small for a
+	// rung or one without declared roles.
+	Skipped []string `json:"skipped,omitempty"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+// OnboardSample is one rung of the sample ladder. Existing is set when a
+// uniform sample at its fraction was already there and was kept.
+type OnboardSample struct {
+	SampleTable string  `json:"sample_table"`
+	Fraction    float64 `json:"fraction"`
+	Rows        int64   `json:"rows,omitempty"`
+	Existing    bool    `json:"existing,omitempty"`
+}
+
+// OnboardSketch is a sketch onboarding built.
+type OnboardSketch struct {
+	Column     string             `json:"column"`
+	SketchType storage.SketchType `json:"sketch_type"`
+	// Distinct is the column's distinct count, for HyperLogLogs.
+	Distinct int64 `json:"distinct,omitempty"`
+}
+
+// PostOnboardTable makes a table AQE-ready in one call: it collects the
+// table's statistics (see PostAnalyzeTable), tracks its row count on SQLite
+// (see PostTrackRowCount), draws the default sample ladder, builds a
+// HyperLogLog for each high-cardinality declared dimension and a t-digest
+// for each declared measure, and registers the default accuracy policy
+// unless the table has one. Declare roles first (PUT /tables/{name}/roles)
+// for the sketches. Steps fail independently; the report lists what each
+// did.
+func (h *Handler) PostOnboardTable(w http.ResponseWriter, r *http.Request) {
+	if h.rejectInSafeMode(w) {
+		return
+	}
+	table := mux.Vars(r)["name"]
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Minute)
+	defer cancel()
+
+	exists, err := storage.TableExists(ctx, h.db, table)
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	if !exists {
+		writeJSON(w, http.StatusNotFound, JSON{"error": "no such table: " + table})
+		return
+	}
+
+	report, err := h.onboardTable(ctx, table)
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "onboarding": report})
+}
+
+// onboardTable runs PostOnboardTable's steps. It fails only when the table
+// can't be analyzed; later failures are recorded in the report.
+func (h *Handler) onboardTable(ctx context.Context, table string) (*OnboardReport, error) {
+	report := &OnboardReport{Table: table, Samples: []OnboardSample{}, Sketches: []OnboardSketch{}}
+	fail := func(what string, err error) {
+		report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", what, err))
+	}
+
+	// statistics: AQE's own for the planner's row and group estimates, and
+	// the database's for its plans
+	rows, columns, err := h.analyzeTable(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+	report.Rows = rows
+	distinct := storage.ColumnDistinct(columns)
+	if err := h.guard.Do(ctx, func(ctx context.Context) error { return storage.AnalyzeTable(ctx, h.db, table) }); err != nil {
+		fail("analyze", err)
+	}
+	if err := storage.RequireSQLite("row count tracking"); err != nil {
+		report.Skipped = append(report.Skipped, err.Error())
+	} else if err := h.guard.Do(ctx, func(ctx context.Context) error {
+		_, trackErr := storage.TrackRowCount(ctx, h.db, table)
+		return trackErr
+	}); err != nil {
+		fail("tracking row count", err)
+	} else {
+		report.RowCountTracked = true
+	}
+
+	h.onboardSamples(ctx, report, fail)
+
+	roles, err := storage.GetTableRoles(ctx, h.db, table)
+	if err != nil {
+		fail("reading roles", err)
+	} else if roles == nil {
+		report.Skipped = append(report.Skipped, "sketches: no roles declared for "+table)
+	} else {
+		h.onboardSketches(ctx, report, roles, distinct, fail)
+	}
+
+	policy, err := storage.GetTablePolicy(ctx, h.db, table)
+	switch {
+	case err != nil:
+		fail("reading policy", err)
+	case policy != nil:
+		report.Policy = policy
+	default:
+		policy = &storage.TablePolicy{Table: table, MaxRelError: defaultPolicyMaxRelError, ConfidenceLevel: planner.DefaultConfidenceLevel}
+		if err := storage.SaveTablePolicy(ctx, h.db, policy); err != nil {
+			fail("registering policy", err)
+		} else if report.Policy, err = storage.GetTablePolicy(ctx, h.db, table); err != nil {
+			fail("reading policy", err)
+		}
+	}
+	return report, nil
+}
+
+// onboardSamples draws each rung of onboardFractions the table is big
+// enough for, keeping uniform samples of every column already drawn at
+// that fraction.
+func (h *Handler) onboardSamples(ctx context.Context, report *OnboardReport, fail func(string, error)) {
+	samples, err := storage.ListSamples(ctx, h.db, report.Table)
+	if err != nil {
+		fail("listing samples", err)
+		return
+	}
+	for _, fraction := range onboardFractions {
+		if float64(report.Rows)*fraction < minOnboardSampleRows {
+			report.Skipped = append(report.Skipped, fmt.Sprintf("sample %g: %d rows would draw fewer than %d", fraction, report.Rows, minOnboardSampleRows))
+			continue
+		}
+		existing := ""
+		for _, s := range samples {
+			if s.StrataColumn == "" && s.Predicate == "" && s.OutlierTable == "" && s.UniverseColumn == "" && len(s.Columns) == 0 && math.Abs(s.Fraction-fraction) < 1e-9 {
+				existing = s.SampleTable
+				break
+			}
+		}
+		if existing != "" {
+			report.Samples = append(report.Samples, OnboardSample{SampleTable: existing, Fraction: fraction, Existing: true})
+			continue
+		}
+		var s OnboardSample
+		err := h.guard.DoOnce(ctx, func(ctx context.Context) error {
+			var sampleErr error
+			s.SampleTable, s.Rows, sampleErr = sampler.CreateUniformSample(ctx, h.db, report.Table, fraction, nil)
+			return sampleErr
+		})
+		if err != nil {
+			fail(fmt.Sprintf("sample %g", fraction), err)
+			continue
+		}
+		s.Fraction = fraction
+		report.Samples = append(report.Samples, s)
+	}
+}
+
+// onboardSketches builds HyperLogLogs for roles' dimensions with more than
+// minHLLDistinct distinct values, by the collected statistics, and
+// t-digests for its measures.
+func (h *Handler) onboardSketches(ctx context.Context, report *OnboardReport, roles *storage.TableRoles, distinctCounts map[string]int64, fail func(string, error)) {
+	build := func(column string, sketchType storage.SketchType) bool {
+		sk := storage.SketchInfo{Type: sketchType, Table: report.Table, Column: column}
+		if err := h.rebuildSketch(ctx, sk, report.Rows); err != nil {
+			fail(fmt.Sprintf("%s on %s", sketchType, column), err)
+			return false
+		}
+		return true
+	}
+
+	for _, column := range roles.Dimensions {
+		distinct := distinctCounts[strings.ToLower(column)]
+		if distinct <= minHLLDistinct {
+			report.Skipped = append(report.Skipped, fmt.Sprintf("hyperloglog on %s: %d distinct values", column, distinct))
+			continue
+		}
+		if build(column, storage.HyperLogLogType) {
+			report.Sketches = append(report.Sketches, OnboardSketch{Column: column, SketchType: storage.HyperLogLogType, Distinct: distinct})
+		}
+	}
+	for _, column := range roles.Measures {
+		if build(column, storage.TDigestType) {
+			report.Sketches = append(report.Sketches, OnboardSketch{Column: column, SketchType: storage.TDigestType})
+		}
+	}
+}
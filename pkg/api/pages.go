@@ -0,0 +1,320 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/executor"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// spoolPosColumn numbers a spooled result's rows from 1 in answer order.
+const spoolPosColumn = "aqe_pos"
+
+// spooledResult is one paginated answer, its rows written to table as
+// columns c0, c1, ... in the order of columns. plan and meta are the
+// answer's, repeated on every page.
+type spooledResult struct {
+	table    string
+	columns  []string
+	rows     int
+	pageSize int
+	plan     *planner.Plan
+	meta     map[string]any
+	expires  time.Time
+}
+
+// pageSpool keeps the spooled results clients are paging through, keyed by
+// the random id their page tokens start with. Entries expire after ttl, and
+// past max the least recently read is dropped.
+type pageSpool struct {
+	mu      sync.Mutex
+	max     int
+	ttl     time.Duration
+	entries map[string]*spooledResult
+	order   []string // least recently used first
+}
+
+func newPageSpool(max int, ttl time.Duration) *pageSpool {
+	return &pageSpool{max: max, ttl: ttl, entries: make(map[string]*spooledResult)}
+}
+
+func (s *pageSpool) enabled() bool {
+	return s != nil && s.max > 0 && s.ttl > 0
+}
+
+// get returns id's live entry and the tables of any entries that expired,
+// for the caller to drop.
+func (s *pageSpool) get(id string, now time.Time) (*spooledResult, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expired := s.expireLocked(now)
+	e, ok := s.entries[id]
+	if !ok {
+		return nil, expired
+	}
+	s.touch(id)
+	return e, expired
+}
+
+// expire removes the entries past their expiry and returns their tables.
+func (s *pageSpool) expire(now time.Time) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.expireLocked(now)
+}
+
+func (s *pageSpool) expireLocked(now time.Time) []string {
+	var expired []string
+	for key, e := range s.entries {
+		if now.After(e.expires) {
+			expired = append(expired, s.remove(key)...)
+		}
+	}
+	return expired
+}
+
+// put adds e under id and returns the tables of the entries it pushed out.
+func (s *pageSpool) put(id string, e *spooledResult) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[id] = e
+	s.touch(id)
+	var evicted []string
+	for len(s.order) > s.max {
+		evicted = append(evicted, s.remove(s.order[0])...)
+	}
+	return evicted
+}
+
+func (s *pageSpool) touch(id string) {
+	for i, k := range s.order {
+		if k == id {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	s.order = append(s.order, id)
+}
+
+func (s *pageSpool) remove(id string) []string {
+	e := s.entries[id]
+	delete(s.entries, id)
+	for i, k := range s.order {
+		if k == id {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	if e == nil {
+		return nil
+	}
+	return []string{e.table}
+}
+
+// pageToken is the opaque cursor to the page of id's result starting after
+// offset rows.
+func pageToken(id string, offset int) string {
+	return id + "." + strconv.Itoa(offset)
+}
+
+func parsePageToken(token string) (id string, offset int, err error) {
+	id, off, ok := strings.Cut(token, ".")
+	if ok {
+		offset, err = strconv.Atoi(off)
+	}
+	if !ok || err != nil || offset < 0 || id == "" {
+		return "", 0, errors.New("invalid page_token")
+	}
+	return id, offset, nil
+}
+
+// paginate cuts resp down to its first pageSize rows when it has more,
+// spooling all of them so the rest can be read with resp.NextPageToken.
+// resp's meta is copied before the page is noted in it, since cached
+// answers share theirs. Answers that can't be spooled are left whole.
+func (h *Handler) paginate(ctx context.Context, resp *QueryResponse, pageSize int) {
+	if pageSize <= 0 || resp.Result.Len() <= pageSize {
+		return
+	}
+	meta := make(map[string]any, len(resp.Meta)+1)
+	for k, v := range resp.Meta {
+		meta[k] = v
+	}
+	resp.Meta = meta
+	if !h.pages.enabled() || h.config.SafeMode {
+		meta["page"] = JSON{"spooled": false, "reason": "pagination disabled"}
+		return
+	}
+
+	e, id, err := h.spool(ctx, resp, pageSize, h.pages.ttl)
+	if err != nil {
+		log.Printf("spooling paginated result: %v", err)
+		meta["page"] = JSON{"spooled": false, "reason": err.Error()}
+		return
+	}
+	h.dropStaged(ctx, h.pages.put(id, e))
+
+	first := executor.NewResultSet(e.columns)
+	vals := make([]any, len(e.columns))
+	for i := 0; i < pageSize; i++ {
+		for j, c := range resp.Result.Columns {
+			vals[j] = c.Value(i)
+		}
+		first.AppendRow(vals)
+	}
+	resp.Result = first
+	resp.NextPageToken = pageToken(id, pageSize)
+	meta["page"] = pageMeta(e, 0, pageSize)
+}
+
+// spool writes resp's rows to a new spool table and returns its entry,
+// expiring after ttl but not yet in any spool, and id.
+func (h *Handler) spool(ctx context.Context, resp *QueryResponse, pageSize int, ttl time.Duration) (*spooledResult, string, error) {
+	var raw [16]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return nil, "", err
+	}
+	id := hex.EncodeToString(raw[:])
+	rs := resp.Result
+	e := &spooledResult{
+		table:    storage.ResultSpoolPrefix + id,
+		columns:  rs.ColumnNames(),
+		rows:     rs.Len(),
+		pageSize: pageSize,
+		plan:     resp.Plan,
+		meta:     resp.Meta,
+		expires:  time.Now().Add(ttl),
+	}
+
+	cols := []string{spoolPosColumn + " INTEGER PRIMARY KEY"}
+	marks := []string{"?"}
+	for i := range e.columns {
+		cols = append(cols, fmt.Sprintf("c%d", i))
+		marks = append(marks, "?")
+	}
+	err := h.guard.Do(ctx, func(ctx context.Context) error {
+		tx, err := h.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("CREATE TABLE %s (%s)", e.table, strings.Join(cols, ", "))); err != nil {
+			return err
+		}
+		stmt, err := tx.PrepareContext(ctx, fmt.Sprintf("INSERT INTO %s VALUES (%s)", e.table, strings.Join(marks, ", ")))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		args := make([]any, len(cols))
+		for i := 0; i < e.rows; i++ {
+			args[0] = i + 1
+			for j, c := range rs.Columns {
+				args[j+1] = c.Value(i)
+			}
+			if _, err := stmt.ExecContext(ctx, args...); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		h.dropStaged(context.Background(), []string{e.table})
+		return nil, "", err
+	}
+	return e, id, nil
+}
+
+// servePage answers a /query naming a page_token with that page of the
+// spooled result, req.PageSize rows of it or as many as the first page had.
+func (h *Handler) servePage(w http.ResponseWriter, r *http.Request, req QueryRequest) {
+	id, offset, err := parsePageToken(req.PageToken)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": err.Error()})
+		return
+	}
+	e, expired := h.pages.get(id, time.Now())
+	h.dropStaged(r.Context(), expired)
+	if e == nil || offset > e.rows {
+		writeJSON(w, http.StatusNotFound, JSON{"error": "page_token expired or unknown; run the query again"})
+		return
+	}
+	pageSize := req.PageSize
+	if pageSize <= 0 {
+		pageSize = e.pageSize
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	var page *executor.ResultSet
+	err = h.guard.Do(ctx, func(ctx context.Context) error {
+		var readErr error
+		page, readErr = readSpooled(ctx, h.readDB, e, offset, pageSize)
+		return readErr
+	})
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+
+	meta := make(map[string]any, len(e.meta)+1)
+	for k, v := range e.meta {
+		meta[k] = v
+	}
+	meta["page"] = pageMeta(e, offset, page.Len())
+	resp := QueryResponse{Status: "ok", Plan: e.plan, Result: page, Meta: meta}
+	if next := offset + page.Len(); next < e.rows {
+		resp.NextPageToken = pageToken(id, next)
+	}
+	writeQueryResponse(w, http.StatusOK, resp)
+}
+
+// readSpooled reads limit rows of e's spooled result after the first offset.
+func readSpooled(ctx context.Context, db *sql.DB, e *spooledResult, offset, limit int) (*executor.ResultSet, error) {
+	cols := make([]string, len(e.columns))
+	for i := range cols {
+		cols[i] = fmt.Sprintf("c%d", i)
+	}
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s WHERE %s > ? ORDER BY %s LIMIT ?",
+		strings.Join(cols, ", "), e.table, spoolPosColumn, spoolPosColumn), offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := executor.NewResultSet(e.columns)
+	vals := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		page.AppendRow(vals)
+	}
+	return page, rows.Err()
+}
+
+func pageMeta(e *spooledResult, offset, rows int) JSON {
+	return JSON{
+		"spooled":    true,
+		"offset":     offset,
+		"rows":       rows,
+		"total_rows": e.rows,
+		"expires_at": e.expires,
+	}
+}
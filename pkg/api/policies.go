@@ -0,0 +1,102 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// TablePolicyRequest sets a table's default accuracy:
+//
+//	{"max_rel_error": 0.02, "confidence_level": 0.99}
+//
+// A zero field leaves queries' own setting, or the server default, alone.
+type TablePolicyRequest struct {
+	MaxRelError     float64 `json:"max_rel_error"`
+	ConfidenceLevel float64 `json:"confidence_level"`
+}
+
+// PutTablePolicy registers a table's default accuracy policy, replacing
+// any registered before (onboarding registers one).
+func (h *Handler) PutTablePolicy(w http.ResponseWriter, r *http.Request) {
+	table := mux.Vars(r)["name"]
+	var req TablePolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "invalid json"})
+		return
+	}
+	if req.MaxRelError < 0 || req.ConfidenceLevel < 0 || req.ConfidenceLevel >= 1 {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "max_rel_error must be non-negative and confidence_level in (0, 1)"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	exists, err := storage.TableExists(ctx, h.db, table)
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	if !exists {
+		writeJSON(w, http.StatusNotFound, JSON{"error": "no such table: " + table})
+		return
+	}
+	policy := &storage.TablePolicy{Table: table, MaxRelError: req.MaxRelError, ConfidenceLevel: req.ConfidenceLevel}
+	if err := storage.SaveTablePolicy(ctx, h.db, policy); err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	if policy, err = storage.GetTablePolicy(ctx, h.db, table); err != nil {
+		writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "policy": policy})
+}
+
+// GetTablePolicy returns a table's default accuracy policy.
+func (h *Handler) GetTablePolicy(w http.ResponseWriter, r *http.Request) {
+	table := mux.Vars(r)["name"]
+	policy, err := storage.GetTablePolicy(r.Context(), h.db, table)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
+		return
+	}
+	if policy == nil {
+		writeJSON(w, http.StatusNotFound, JSON{"error": "no policy registered for " + table})
+		return
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "policy": policy})
+}
+
+// applyTablePolicy fills the tolerance and confidence level req leaves
+// unset from the policy of the table it reads, as a saved template's
+// defaults are. Queries forced exact take no tolerance.
+func (h *Handler) applyTablePolicy(ctx context.Context, req *QueryRequest) {
+	if (req.MaxRelError > 0 || req.PreferExact) && req.ConfidenceLevel > 0 {
+		return
+	}
+	q, err := planner.Parse(req.SQL)
+	if err != nil {
+		return // the planner reports it
+	}
+	table := q.BaseTable()
+	if table == "" {
+		return
+	}
+	policy, err := storage.GetTablePolicy(ctx, h.db, table)
+	if err != nil || policy == nil {
+		return
+	}
+	if req.MaxRelError == 0 && !req.PreferExact {
+		req.MaxRelError = policy.MaxRelError
+	}
+	if req.ConfidenceLevel == 0 {
+		req.ConfidenceLevel = policy.ConfidenceLevel
+	}
+}
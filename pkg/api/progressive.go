@@ -0,0 +1,191 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/aqeerr"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/executor"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+)
+
+// ProgressiveUpdate is one refinement streamed by a progressive query.
+type ProgressiveUpdate struct {
+	Stage    int     `json:"stage"`
+	Stages   int     `json:"stages"`
+	Fraction float64 `json:"fraction"` // share of the table read; 1 for the exact stage
+	Final    bool    `json:"final"`
+	// MaxRelError is the largest relative error across the result's
+	// intervals; 0 for exact results.
+	MaxRelError float64             `json:"max_rel_error"`
+	ElapsedMs   float64             `json:"elapsed_ms"`
+	Status      string              `json:"status"`
+	Error       string              `json:"error,omitempty"`
+	Category    string              `json:"category,omitempty"`
+	Plan        *planner.Plan       `json:"plan,omitempty"`
+	Meta        map[string]any      `json:"meta,omitempty"`
+	Result      *executor.ResultSet `json:"result,omitempty"`
+}
+
+// streamProgressive answers req as an online aggregation: estimates from
+// growing subsets of the table, each with tighter intervals, then the exact
+// answer. Updates go out as they are ready, as server-sent events when the
+// client accepts text/event-stream and as NDJSON otherwise. A client stops
+// the query by disconnecting; with max_rel_error set, the server also stops
+// at the first estimate within it.
+func (h *Handler) streamProgressive(w http.ResponseWriter, r *http.Request, req QueryRequest, priority Priority) {
+	ctx, cancel := context.WithTimeout(r.Context(), 120*time.Second)
+	defer cancel()
+
+	plans, err := h.progressivePlans(ctx, req, true)
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusBadRequest), errorBody(err))
+		return
+	}
+
+	sse := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	h.runStages(ctx, req, priority, plans, func(u *ProgressiveUpdate) bool {
+		line, err := u.appendJSON(nil)
+		if err != nil {
+			return false
+		}
+		if sse {
+			line = append(append([]byte("event: update\ndata: "), line...), "\n\n"...)
+		} else {
+			line = append(line, '\n')
+		}
+		if _, err := w.Write(line); err != nil {
+			return false
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return true
+	})
+}
+
+// progressivePlans plans req's stages. With staged false, as for exact and
+// hinted queries, it is the single plan Plan gives.
+func (h *Handler) progressivePlans(ctx context.Context, req QueryRequest, staged bool) ([]*planner.Plan, error) {
+	fractions := req.ProgressiveFractions
+	if len(fractions) == 0 {
+		fractions = planner.DefaultProgressiveFractions
+	}
+	p := planner.New()
+	p.SetComplexityThreshold(h.config.ComplexityThreshold)
+	p.SetSafeMode(h.config.SafeMode || req.SafeMode)
+	p.SetDisabledStrategies(h.config.DisabledStrategies)
+	p.SetPassthrough(h.config.Passthrough || req.passthrough)
+	p.SetSampleResolver(h.resolveSample)
+	p.SetConfidenceLevel(req.ConfidenceLevel)
+	p.SetMaxGroups(h.config.MaxResultGroups)
+	p.SetQueryLimits(h.config.QueryLimits)
+	if !staged {
+		plan, err := p.Plan(ctx, h.readDB, req.SQL, req.MaxRelError, req.PreferExact)
+		if err != nil {
+			return nil, err
+		}
+		return []*planner.Plan{plan}, nil
+	}
+	return p.ProgressivePlans(ctx, h.readDB, req.SQL, fractions, req.MaxRelError)
+}
+
+// runStages executes plans in order, handing each stage's update to send,
+// until the last stage, the first estimate within req's tolerance, an
+// error, or send returning false. It stops silently when ctx ends.
+func (h *Handler) runStages(ctx context.Context, req QueryRequest, priority Priority, plans []*planner.Plan, send func(*ProgressiveUpdate) bool) {
+	start := time.Now()
+	for i, plan := range plans {
+		u := &ProgressiveUpdate{Stage: i + 1, Stages: len(plans), Fraction: plan.SampleFraction, Plan: plan}
+		if plan.Type == planner.PlanExact {
+			u.Fraction = 1
+		}
+
+		var rows *executor.ResultSet
+		var meta map[string]any
+		runCtx, release, err := h.schedule(ctx, priority, heavyPlan(plan))
+		if err == nil {
+			err = h.guard.Do(runCtx, func(ctx context.Context) error {
+				var execErr error
+				ctx = executor.WithMemoryBudget(ctx, executor.NewMemoryBudget(h.config.MaxQueryMemoryBytes))
+				rows, meta, execErr = executor.Execute(ctx, h.readDB, plan)
+				return execErr
+			})
+			release()
+		}
+		u.ElapsedMs = float64(time.Since(start).Microseconds()) / 1000
+		if err != nil {
+			if ctx.Err() != nil {
+				return // the client went away or the query timed out
+			}
+			u.Status, u.Error, u.Category, u.Final = "error", err.Error(), aqeerr.Category(err), true
+			send(u)
+			return
+		}
+
+		u.Status, u.Meta, u.Result = "ok", meta, rows
+		var bounded bool
+		u.MaxRelError, bounded = maxResultRelError(rows)
+		u.Final = i == len(plans)-1 ||
+			req.MaxRelError > 0 && plan.Type == planner.PlanSample && bounded &&
+				u.MaxRelError <= planner.ToleranceAt(req.MaxRelError, plan.Level())
+		if !send(u) || u.Final {
+			return
+		}
+	}
+}
+
+// appendJSON appends u as one JSON object, its result rows streamed from
+// the columnar result set.
+func (u *ProgressiveUpdate) appendJSON(dst []byte) ([]byte, error) {
+	envelope := *u
+	envelope.Result = nil
+	b, err := json.Marshal(&envelope)
+	if err != nil {
+		return dst, err
+	}
+	dst = append(dst, b[:len(b)-1]...)
+	if u.Result != nil {
+		dst = append(dst, `,"result":`...)
+		if dst, err = u.Result.AppendJSON(dst); err != nil {
+			return dst, err
+		}
+	}
+	return append(dst, '}'), nil
+}
+
+// maxResultRelError is the largest finite value in rs's _rel_error columns.
+// bounded is false when there is no interval of nonzero width, as when a
+// scalar aggregate is bootstrapped from its single row; such a stage says
+// nothing about the error and must not end the stream early.
+func maxResultRelError(rs *executor.ResultSet) (worst float64, bounded bool) {
+	for _, c := range rs.Columns {
+		if !strings.HasSuffix(c.Name, "_rel_error") {
+			continue
+		}
+		for i := 0; i < rs.Len(); i++ {
+			v, ok := c.Float(i)
+			if !ok || math.IsNaN(v) || math.IsInf(v, 0) {
+				continue
+			}
+			if v > 0 {
+				bounded = true
+			}
+			worst = math.Max(worst, v)
+		}
+	}
+	return worst, bounded
+}
@@ -0,0 +1,272 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/sampler"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// rowDrift is how far current has moved from base, as a fraction of base.
+func rowDrift(base, current int64) float64 {
+	if base <= 0 {
+		return 0
+	}
+	return math.Abs(float64(current-base)) / float64(base)
+}
+
+// refreshDriftFor returns table's refresh policy, nil without one, and the
+// drift threshold that applies to it: the policy's, or the server's (see
+// Config.RefreshDrift); 0 when drift refreshes are off.
+func (h *Handler) refreshDriftFor(ctx context.Context, table string) (*storage.RefreshPolicy, float64, error) {
+	p, err := storage.GetRefreshPolicy(ctx, h.db, table)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch {
+	case p == nil:
+		return nil, h.config.RefreshDrift, nil
+	case p.Disabled:
+		return p, 0, nil
+	case p.MaxRowDrift > 0:
+		return p, p.MaxRowDrift, nil
+	}
+	return p, h.config.RefreshDrift, nil
+}
+
+// refreshDrifted redraws the samples and rebuilds the sketches of a table
+// that hasn't shrunk but has drifted from them: those whose base row count
+// is further from m.CurrentRows than the table's threshold, or all of them
+// when its policy checks its checksum and that changed since the last pass.
+// Live samples are caught up instead, unless the checksum changed. The
+// checksum of a table seen for the first time is only recorded, and it is
+// recorded again only once every due refresh succeeded, so failed ones are
+// retried.
+func (h *Handler) refreshDrifted(ctx context.Context, m *SynopsisMaintenance, sketchList []storage.SketchInfo, samples []storage.SampleInfo, admit func() (context.Context, bool)) {
+	fail := func(what string, err error) {
+		m.Errors = append(m.Errors, fmt.Sprintf("%s: %v", what, err))
+	}
+	policy, threshold, err := h.refreshDriftFor(ctx, m.Table)
+	if err != nil {
+		fail("reading refresh policy", err)
+		return
+	}
+	drifted := func(base int64) bool {
+		return threshold > 0 && rowDrift(base, m.CurrentRows) > threshold
+	}
+
+	admitted := false
+	checksum := ""
+	if policy != nil && policy.Checksum && !policy.Disabled {
+		runCtx, ok := admit()
+		if !ok {
+			return
+		}
+		ctx, admitted = runCtx, true
+		if checksum, err = storage.TableChecksum(ctx, h.readDB, m.Table); err != nil {
+			fail("checksumming", err)
+			return
+		}
+		m.ChecksumChanged = policy.LastChecksum != "" && checksum != policy.LastChecksum
+	}
+
+	var dueSketches []storage.SketchInfo
+	for _, sk := range sketchList {
+		if m.ChecksumChanged || drifted(sk.BaseRows) {
+			dueSketches = append(dueSketches, sk)
+		}
+	}
+	var dueSamples, live []storage.SampleInfo
+	for _, s := range samples {
+		switch {
+		case s.ReservoirSize > 0 && !m.ChecksumChanged:
+			live = append(live, s)
+		case m.ChecksumChanged || drifted(s.BaseRows):
+			dueSamples = append(dueSamples, s)
+		}
+	}
+	m.Drifted = len(dueSketches)+len(dueSamples) > 0
+	if m.Drifted && !admitted {
+		runCtx, ok := admit()
+		if !ok {
+			return
+		}
+		ctx, admitted = runCtx, true
+	}
+
+	before := len(m.Errors)
+	for _, sk := range dueSketches {
+		if err := h.rebuildSketch(ctx, sk, m.CurrentRows); err != nil {
+			fail(fmt.Sprintf("rebuilding %s sketch on %s", sk.Type, sk.Column), err)
+			continue
+		}
+		m.RebuiltSketches = append(m.RebuiltSketches, fmt.Sprintf("%s(%s)", sk.Type, sk.Column))
+	}
+	for _, s := range dueSamples {
+		var res *sampler.RefreshResult
+		err := h.guard.Do(ctx, func(ctx context.Context) error {
+			var err error
+			res, err = sampler.RedrawSample(ctx, h.db, s)
+			return err
+		})
+		if err != nil {
+			fail("redrawing "+s.SampleTable, err)
+			continue
+		}
+		m.RefreshedSamples = append(m.RefreshedSamples, res)
+	}
+	if m.Drifted {
+		if err := storage.UpsertTableRowCount(ctx, h.db, m.Table, m.CurrentRows); err != nil {
+			fail("recording row count", err)
+		}
+	}
+	if checksum != "" && len(m.Errors) == before {
+		if err := storage.RecordTableChecksum(ctx, h.db, m.Table, checksum); err != nil {
+			fail("recording checksum", err)
+		}
+	}
+
+	h.catchUpLiveSamples(ctx, m, live, func() (context.Context, bool) {
+		if admitted {
+			return ctx, true
+		}
+		return admit()
+	})
+}
+
+// RefreshPolicyRequest sets how a table's synopses are refreshed in the
+// background:
+//
+//	{"max_row_drift": 0.05, "checksum": true}
+//
+// max_row_drift 0 keeps the server's threshold; disabled opts the table
+// out of drift and checksum refreshes.
+type RefreshPolicyRequest struct {
+	MaxRowDrift float64 `json:"max_row_drift"`
+	Checksum    bool    `json:"checksum"`
+	Disabled    bool    `json:"disabled"`
+}
+
+// PutRefreshPolicy registers a table's refresh policy, replacing any
+// registered before.
+func (h *Handler) PutRefreshPolicy(w http.ResponseWriter, r *http.Request) {
+	table := mux.Vars(r)["name"]
+	var req RefreshPolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "invalid json"})
+		return
+	}
+	if req.MaxRowDrift < 0 || math.IsNaN(req.MaxRowDrift) {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "max_row_drift must not be negative"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	exists, err := storage.TableExists(ctx, h.db, table)
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	if !exists {
+		writeJSON(w, http.StatusNotFound, JSON{"error": "no such table: " + table})
+		return
+	}
+	p := &storage.RefreshPolicy{Table: table, MaxRowDrift: req.MaxRowDrift, Checksum: req.Checksum, Disabled: req.Disabled}
+	if err := storage.SaveRefreshPolicy(ctx, h.db, p); err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	h.writeRefreshPolicy(w, r.WithContext(ctx), table)
+}
+
+// GetRefreshPolicy reports a table's refresh policy, its own or the
+// server's, and how far each of its synopses has drifted.
+func (h *Handler) GetRefreshPolicy(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	h.writeRefreshPolicy(w, r.WithContext(ctx), mux.Vars(r)["name"])
+}
+
+// SynopsisDrift is how far a table has moved from one of its synopses.
+type SynopsisDrift struct {
+	Kind     string  `json:"kind"` // "sample" or "sketch"
+	Name     string  `json:"name"`
+	BaseRows int64   `json:"base_rows"`
+	Drift    float64 `json:"drift"`
+	Due      bool    `json:"due"`
+}
+
+func (h *Handler) writeRefreshPolicy(w http.ResponseWriter, r *http.Request, table string) {
+	ctx := r.Context()
+	exists, err := storage.TableExists(ctx, h.db, table)
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	if !exists {
+		writeJSON(w, http.StatusNotFound, JSON{"error": "no such table: " + table})
+		return
+	}
+	policy, threshold, err := h.refreshDriftFor(ctx, table)
+	var current int64
+	if err == nil {
+		current, err = storage.CountRows(ctx, h.db, table)
+	}
+	var sketchList []storage.SketchInfo
+	if err == nil {
+		sketchList, err = storage.ListSketches(ctx, h.db, table)
+	}
+	var samples []storage.SampleInfo
+	if err == nil {
+		samples, err = storage.ListSamples(ctx, h.db, table)
+	}
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+
+	synopses := []SynopsisDrift{}
+	for _, sk := range sketchList {
+		d := rowDrift(sk.BaseRows, current)
+		synopses = append(synopses, SynopsisDrift{Kind: "sketch", Name: fmt.Sprintf("%s(%s)", sk.Type, sk.Column),
+			BaseRows: sk.BaseRows, Drift: d, Due: threshold > 0 && d > threshold})
+	}
+	for _, s := range samples {
+		if s.Archived {
+			continue
+		}
+		d := rowDrift(s.BaseRows, current)
+		synopses = append(synopses, SynopsisDrift{Kind: "sample", Name: s.SampleTable,
+			BaseRows: s.BaseRows, Drift: d, Due: s.ReservoirSize == 0 && threshold > 0 && d > threshold})
+	}
+	source := "server"
+	if policy != nil {
+		source = "table"
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "policy": policy, "source": source,
+		"max_row_drift": threshold, "current_rows": current, "synopses": synopses})
+}
+
+// DeleteRefreshPolicy removes a table's policy; the server's threshold
+// applies again.
+func (h *Handler) DeleteRefreshPolicy(w http.ResponseWriter, r *http.Request) {
+	table := mux.Vars(r)["name"]
+	deleted, err := storage.DeleteRefreshPolicy(r.Context(), h.db, table)
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	if !deleted {
+		writeJSON(w, http.StatusNotFound, JSON{"error": "no refresh policy registered for " + table})
+		return
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok"})
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// TableRolesRequest registers a table's column roles:
+//
+//	{"time_columns": ["created_at"], "measures": ["amount"], "dimensions": ["region", "category"]}
+//
+// Each column takes at most one role.
+type TableRolesRequest struct {
+	TimeColumns []string `json:"time_columns"`
+	Measures    []string `json:"measures"`
+	Dimensions  []string `json:"dimensions"`
+}
+
+// PutTableRoles declares the semantic roles of a table's columns,
+// replacing any declared before. The planner buckets only declared time
+// columns, stratified samples default to the first dimension and measure,
+// inferred sample columns include every declared column, and the coverage
+// advisor only suggests sketches that fit a column's role.
+func (h *Handler) PutTableRoles(w http.ResponseWriter, r *http.Request) {
+	table := mux.Vars(r)["name"]
+	var req TableRolesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "invalid json"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	exists, err := storage.TableExists(ctx, h.db, table)
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	if !exists {
+		writeJSON(w, http.StatusNotFound, JSON{"error": "no such table: " + table})
+		return
+	}
+	tableCols, err := storage.TableColumns(ctx, h.db, table)
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	inTable := make(map[string]string, len(tableCols))
+	for _, c := range tableCols {
+		inTable[strings.ToLower(c)] = c
+	}
+
+	roles := &storage.TableRoles{Table: table}
+	declared := make(map[string]string)
+	for _, group := range []struct {
+		role string
+		cols []string
+		dst  *[]string
+	}{
+		{storage.RoleTime, req.TimeColumns, &roles.TimeColumns},
+		{storage.RoleMeasure, req.Measures, &roles.Measures},
+		{storage.RoleDimension, req.Dimensions, &roles.Dimensions},
+	} {
+		*group.dst = []string{}
+		for _, c := range group.cols {
+			name, ok := inTable[strings.ToLower(c)]
+			if !ok {
+				writeJSON(w, http.StatusBadRequest, JSON{"error": fmt.Sprintf("%s has no column %q", table, c)})
+				return
+			}
+			if prev, dup := declared[name]; dup {
+				writeJSON(w, http.StatusBadRequest, JSON{"error": fmt.Sprintf("column %s declared as both %s and %s", name, prev, group.role)})
+				return
+			}
+			declared[name] = group.role
+			*group.dst = append(*group.dst, name)
+		}
+	}
+	if len(declared) == 0 {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "declare at least one column; DELETE removes a registration"})
+		return
+	}
+
+	if err := storage.SaveTableRoles(ctx, h.db, roles); err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	if roles, err = storage.GetTableRoles(ctx, h.db, table); err != nil {
+		writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "roles": roles})
+}
+
+// GetTableRoles returns the roles declared for a table.
+func (h *Handler) GetTableRoles(w http.ResponseWriter, r *http.Request) {
+	table := mux.Vars(r)["name"]
+	roles, err := storage.GetTableRoles(r.Context(), h.db, table)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
+		return
+	}
+	if roles == nil {
+		writeJSON(w, http.StatusNotFound, JSON{"error": "no roles declared for " + table})
+		return
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "roles": roles})
+}
+
+// DeleteTableRoles removes a table's registration; its columns' roles are
+// guessed again.
+func (h *Handler) DeleteTableRoles(w http.ResponseWriter, r *http.Request) {
+	table := mux.Vars(r)["name"]
+	deleted, err := storage.DeleteTableRoles(r.Context(), h.db, table)
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	if !deleted {
+		writeJSON(w, http.StatusNotFound, JSON{"error": "no roles declared for " + table})
+		return
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok"})
+}
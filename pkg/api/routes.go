@@ -1,37 +1,195 @@
 package api
 
 import (
+	"bufio"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/aqeerr"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/executor"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/ml"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
 )
 
 type JSON map[string]any
 
-func RegisterRoutes(r *mux.Router, db *sql.DB) {
-	h := &Handler{db: db}
+// RegisterRoutes wires the API onto r. db is the writer handle used for
+// metadata, samples and sketches; readDB is a read-only handle on the same
+// database that /query plans and executes against. With API keys
+// configured, each endpoint needs a key of the role it is registered with.
+func RegisterRoutes(r *mux.Router, db, readDB *sql.DB) {
+	cfg := configFromEnv()
+	h := &Handler{
+		db:        db,
+		readDB:    readDB,
+		config:    cfg,
+		cache:     newResultCache(cfg.ResultCacheEntries),
+		joinCache: newJoinCache(cfg.JoinCacheEntries, cfg.JoinCacheMaxRows, cfg.JoinCacheTTL),
+		pages:     newPageSpool(cfg.PageSpoolEntries, cfg.PageSpoolTTL),
+		jobs:      newJobStore(cfg.MaxJobs, cfg.JobResultTTL),
+		learner:   ml.NewLearningOptimizer(db),
+		guard:     storage.NewGuard(storage.DefaultRetryPolicy(), storage.NewCircuitBreaker(5, 10*time.Second)),
+		scheduler: newScheduler(cfg.MaxHeavyQueries),
+		verifier:  &verifier{},
+	}
+
+	if !cfg.SafeMode {
+		h.sweepCacheTables(context.Background())
+	}
+	h.warmup.summary = &WarmupSummary{Status: "running", StartedAt: time.Now().UTC()}
+	go h.warmUp(context.Background())
+	if cfg.MaintenanceInterval > 0 {
+		go h.runMaintenance(cfg.MaintenanceInterval)
+	}
+	if cfg.VerifyInterval > 0 {
+		go h.runVerifier(cfg.VerifyInterval)
+	}
+	if cfg.AuditInterval > 0 {
+		go h.runAuditor(cfg.AuditInterval)
+	}
 
 	// Core endpoints
 	r.HandleFunc("/health", h.Health).Methods(http.MethodGet)
-	r.HandleFunc("/tables", h.ListTables).Methods(http.MethodGet)
-	r.HandleFunc("/query", h.PostQuery).Methods(http.MethodPost)
+	r.HandleFunc("/tables", h.require(RoleReader, h.ListTables)).Methods(http.MethodGet)
+	r.HandleFunc("/tables/{name}/coverage", h.require(RoleReader, h.GetTableCoverage)).Methods(http.MethodGet)
+	r.HandleFunc("/tables/{name}/roles", h.require(RoleBuilder, h.PutTableRoles)).Methods(http.MethodPut)
+	r.HandleFunc("/tables/{name}/roles", h.require(RoleReader, h.GetTableRoles)).Methods(http.MethodGet)
+	r.HandleFunc("/tables/{name}/roles", h.require(RoleBuilder, h.DeleteTableRoles)).Methods(http.MethodDelete)
+	r.HandleFunc("/tables/{name}/policy", h.require(RoleBuilder, h.PutTablePolicy)).Methods(http.MethodPut)
+	r.HandleFunc("/tables/{name}/policy", h.require(RoleReader, h.GetTablePolicy)).Methods(http.MethodGet)
+	r.HandleFunc("/tables/{name}/maintenance_window", h.require(RoleAdmin, h.PutMaintenanceWindow)).Methods(http.MethodPut)
+	r.HandleFunc("/tables/{name}/maintenance_window", h.require(RoleReader, h.GetMaintenanceWindow)).Methods(http.MethodGet)
+	r.HandleFunc("/tables/{name}/maintenance_window", h.require(RoleAdmin, h.DeleteMaintenanceWindow)).Methods(http.MethodDelete)
+	r.HandleFunc("/tables/{name}/refresh_policy", h.require(RoleBuilder, h.PutRefreshPolicy)).Methods(http.MethodPut)
+	r.HandleFunc("/tables/{name}/refresh_policy", h.require(RoleReader, h.GetRefreshPolicy)).Methods(http.MethodGet)
+	r.HandleFunc("/tables/{name}/refresh_policy", h.require(RoleBuilder, h.DeleteRefreshPolicy)).Methods(http.MethodDelete)
+	r.HandleFunc("/tables/{name}/onboard", h.require(RoleBuilder, h.PostOnboardTable)).Methods(http.MethodPost)
+	r.HandleFunc("/tables/{name}/analyze", h.require(RoleAdmin, h.PostAnalyzeTable)).Methods(http.MethodPost)
+	r.HandleFunc("/tables/{name}/rows", h.require(RoleAdmin, h.PostTableRows)).Methods(http.MethodPost)
+	r.HandleFunc("/tables/{name}/stats", h.require(RoleReader, h.GetTableStats)).Methods(http.MethodGet)
+	r.HandleFunc("/tables/row_counts", h.require(RoleReader, h.GetRowCounts)).Methods(http.MethodGet)
+	r.HandleFunc("/tables/{name}/row_count", h.require(RoleAdmin, h.PostTrackRowCount)).Methods(http.MethodPost)
+	r.HandleFunc("/tables/{name}/row_count", h.require(RoleReader, h.GetRowCount)).Methods(http.MethodGet)
+	r.HandleFunc("/tables/{name}/row_count", h.require(RoleAdmin, h.DeleteRowCount)).Methods(http.MethodDelete)
+	r.HandleFunc("/query", h.require(RoleReader, h.PostQuery)).Methods(http.MethodPost)
+	r.HandleFunc("/query/bundle", h.require(RoleReader, h.PostQueryBundle)).Methods(http.MethodPost)
+	r.HandleFunc("/query/compare", h.require(RoleReader, h.PostCompare)).Methods(http.MethodPost)
+	r.HandleFunc("/query/session", h.require(RoleReader, h.GetQuerySession)).Methods(http.MethodGet)
+	r.HandleFunc("/scheduler", h.require(RoleReader, h.GetScheduler)).Methods(http.MethodGet)
+
+	// Switching planner strategies off or rolling them out
+	r.HandleFunc("/strategies", h.require(RoleReader, h.GetStrategies)).Methods(http.MethodGet)
+	r.HandleFunc("/strategies/{name}", h.require(RoleAdmin, h.PutStrategyFlag)).Methods(http.MethodPut)
+	r.HandleFunc("/strategies/{name}", h.require(RoleAdmin, h.DeleteStrategyFlag)).Methods(http.MethodDelete)
+
+	// Async query jobs
+	r.HandleFunc("/jobs", h.require(RoleReader, h.PostJob)).Methods(http.MethodPost)
+	r.HandleFunc("/jobs/{id}", h.require(RoleReader, h.GetJob)).Methods(http.MethodGet)
+	r.HandleFunc("/jobs/{id}/result", h.require(RoleReader, h.GetJobResult)).Methods(http.MethodGet)
+
+	// Saved query templates
+	r.HandleFunc("/queries/templates", h.require(RoleReader, h.PostTemplate)).Methods(http.MethodPost)
+	r.HandleFunc("/queries/templates", h.require(RoleReader, h.GetTemplates)).Methods(http.MethodGet)
+	r.HandleFunc("/queries/templates/{name}", h.require(RoleBuilder, h.DeleteTemplate)).Methods(http.MethodDelete)
+	r.HandleFunc("/queries/templates/{name}/run", h.require(RoleReader, h.PostRunTemplate)).Methods(http.MethodPost)
+	r.HandleFunc("/queries/templates/{name}/runs", h.require(RoleReader, h.GetTemplateRuns)).Methods(http.MethodGet)
+	r.HandleFunc("/queries/templates/{name}/diff", h.require(RoleReader, h.GetTemplateDiff)).Methods(http.MethodGet)
 
 	// Sampling endpoints
-	r.HandleFunc("/samples/create", h.PostCreateSample).Methods(http.MethodPost)
-	r.HandleFunc("/samples/stratified", h.PostCreateStratifiedSample).Methods(http.MethodPost)
+	r.HandleFunc("/samples/create", h.require(RoleBuilder, h.PostCreateSample)).Methods(http.MethodPost)
+	r.HandleFunc("/samples/stratified", h.require(RoleBuilder, h.PostCreateStratifiedSample)).Methods(http.MethodPost)
+	r.HandleFunc("/samples", h.require(RoleReader, h.GetSamples)).Methods(http.MethodGet)
+	r.HandleFunc("/samples/{name}", h.require(RoleBuilder, h.DeleteSample)).Methods(http.MethodDelete)
+	r.HandleFunc("/samples/{name}/refresh", h.require(RoleBuilder, h.PostRefreshSample)).Methods(http.MethodPost)
 
 	// Sketch endpoints
-	r.HandleFunc("/sketches/create", h.PostCreateSketch).Methods(http.MethodPost)
-	r.HandleFunc("/sketches", h.GetSketches).Methods(http.MethodGet)
+	r.HandleFunc("/sketches/create", h.require(RoleBuilder, h.PostCreateSketch)).Methods(http.MethodPost)
+	r.HandleFunc("/sketches/topk", h.require(RoleReader, h.GetTopK)).Methods(http.MethodGet)
+	r.HandleFunc("/sketches", h.require(RoleReader, h.GetSketches)).Methods(http.MethodGet)
+	r.HandleFunc("/sketches", h.require(RoleBuilder, h.DeleteSketch)).Methods(http.MethodDelete)
+
+	// Predicting a synopsis's cost and benefit before building it
+	r.HandleFunc("/whatif/sample", h.require(RoleReader, h.PostWhatIfSample)).Methods(http.MethodPost)
+	r.HandleFunc("/whatif/sketch", h.require(RoleReader, h.PostWhatIfSketch)).Methods(http.MethodPost)
+
+	// Synopsis maintenance after deletes and updates
+	r.HandleFunc("/synopses/maintain", h.require(RoleAdmin, h.PostMaintainSynopses)).Methods(http.MethodPost)
+
+	// Startup warm-up summary
+	r.HandleFunc("/admin/warmup", h.require(RoleAdmin, h.GetWarmup)).Methods(http.MethodGet)
+
+	// Moving synopses between environments
+	r.HandleFunc("/synopses/export", h.require(RoleAdmin, h.GetExportSynopses)).Methods(http.MethodGet)
+	r.HandleFunc("/synopses/import", h.require(RoleAdmin, h.PostImportSynopses)).Methods(http.MethodPost)
+
+	// Moving cold samples out of the main database
+	r.HandleFunc("/synopses/archive", h.require(RoleAdmin, h.PostArchiveSamples)).Methods(http.MethodPost)
+
+	// Keeping synopses within a storage budget
+	r.HandleFunc("/synopses/budget", h.require(RoleReader, h.GetStorageBudget)).Methods(http.MethodGet)
+	r.HandleFunc("/synopses/budget", h.require(RoleAdmin, h.PutStorageBudget)).Methods(http.MethodPut)
+	r.HandleFunc("/synopses/budget", h.require(RoleAdmin, h.DeleteStorageBudget)).Methods(http.MethodDelete)
+	r.HandleFunc("/synopses/budget/enforce", h.require(RoleAdmin, h.PostEnforceStorageBudget)).Methods(http.MethodPost)
+
+	// Restoring dropped synopses within the retention window
+	r.HandleFunc("/synopses/dropped", h.require(RoleReader, h.GetDroppedSynopses)).Methods(http.MethodGet)
+	r.HandleFunc("/synopses/{id:[0-9]+}/restore", h.require(RoleBuilder, h.PostRestoreSynopsis)).Methods(http.MethodPost)
+
+	// Pre-computed join synopses
+	r.HandleFunc("/synopses/joins", h.require(RoleBuilder, h.PostCreateJoinSynopsis)).Methods(http.MethodPost)
+	r.HandleFunc("/synopses/joins", h.require(RoleReader, h.GetJoinSynopses)).Methods(http.MethodGet)
+	r.HandleFunc("/synopses/joins/{name}", h.require(RoleBuilder, h.DeleteJoinSynopsis)).Methods(http.MethodDelete)
 
 	// ML Learning endpoints
-	r.HandleFunc("/ml/stats", h.GetLearningStats).Methods(http.MethodGet)
+	r.HandleFunc("/ml/stats", h.require(RoleReader, h.GetLearningStats)).Methods(http.MethodGet)
+	r.HandleFunc("/ml/accuracy", h.require(RoleReader, h.GetMLAccuracy)).Methods(http.MethodGet)
+	r.HandleFunc("/ml/audit", h.require(RoleReader, h.GetAccuracyAudit)).Methods(http.MethodGet)
 }
 
 type Handler struct {
-	db *sql.DB
+	db     *sql.DB
+	readDB *sql.DB // read-only connection for user queries
+	config Config
+	guard  *storage.Guard
+	cache  *resultCache
+
+	// joinCache holds staged universe joins between queries.
+	joinCache *joinCache
+
+	// pages holds paginated /query results spooled for their later pages.
+	pages *pageSpool
+
+	// jobs holds async query jobs and their spooled results.
+	jobs *jobStore
+
+	// learner is shared by all requests; it is safe for concurrent use.
+	learner *ml.LearningOptimizer
+
+	// archiveMu serializes moving samples into and out of the archive;
+	// sampleUse maps sample tables to when their use was last recorded.
+	archiveMu sync.Mutex
+	sampleUse sync.Map
+
+	// lastAnalyze is when maintenance last ran a full ANALYZE.
+	lastAnalyze time.Time
+
+	// scheduler admits heavy queries by priority.
+	scheduler *scheduler
+
+	// verifier holds approximate answers waiting to be checked exactly.
+	verifier *verifier
+
+	// warmup reports the startup warm-up.
+	warmup warmupState
 }
 
 func writeJSON(w http.ResponseWriter, status int, v any) {
@@ -39,3 +197,68 @@ func writeJSON(w http.ResponseWriter, status int, v any) {
 	w.WriteHeader(status)
 	_ = json.NewEncoder(w).Encode(v)
 }
+
+// writeQueryResponse writes resp with the result rows streamed straight from
+// the columnar result set, so large results never pass through encoding/json.
+func writeQueryResponse(w http.ResponseWriter, status int, resp QueryResponse) {
+	result := resp.Result
+	resp.Result = nil
+	envelope, err := json.Marshal(resp)
+	if err != nil || result == nil {
+		resp.Result = result
+		writeJSON(w, status, resp)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	bw := bufio.NewWriterSize(w, 64<<10)
+	_, _ = bw.Write(envelope[:len(envelope)-1])
+	_, _ = bw.WriteString(`,"result":`)
+	if err := result.WriteJSON(bw); err != nil {
+		// the 200 is already out: break the connection rather than end a
+		// truncated body the client would take for a whole answer
+		log.Printf("writing query result: %v", err)
+		panic(http.ErrAbortHandler)
+	}
+	_, _ = bw.WriteString("}\n")
+	_ = bw.Flush()
+}
+
+// errorBody is the JSON answer for a failed query: the error, its
+// aqeerr category and, for SQL over a planner.QueryLimits limit, which
+// limit and by how much.
+func errorBody(err error) JSON {
+	body := JSON{"error": err.Error(), "category": aqeerr.Category(err)}
+	var limit *planner.LimitError
+	if errors.As(err, &limit) {
+		body["limit"] = limit
+	}
+	return body
+}
+
+// errorStatus maps storage-layer and aqeerr errors to HTTP status codes.
+func errorStatus(err error, fallback int) int {
+	switch {
+	case errors.Is(err, aqeerr.ErrUnsupportedQuery), errors.Is(err, aqeerr.ErrQueryLimit):
+		return http.StatusBadRequest
+	case errors.Is(err, aqeerr.ErrNoSample), errors.Is(err, aqeerr.ErrToleranceUnreachable), errors.Is(err, aqeerr.ErrTooManyGroups):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, aqeerr.ErrStaleStats):
+		return http.StatusConflict
+	case storage.IsReadOnly(err), errors.Is(err, errPassthroughRole):
+		return http.StatusForbidden
+	case errors.Is(err, storage.ErrCircuitOpen):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, storage.ErrUnsupportedDialect):
+		return http.StatusNotImplemented
+	case errors.Is(err, executor.ErrMemoryLimitExceeded):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, storage.ErrNoSynopsis):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrSynopsisExists):
+		return http.StatusConflict
+	}
+	return fallback
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// PostTrackRowCount starts keeping a table's exact row count up to date
+// (see storage.TrackRowCount), or recounts a tracked table. The planner
+// then sizes the table, judges its synopses' staleness and answers a bare
+// COUNT(*) from the count without scanning.
+func (h *Handler) PostTrackRowCount(w http.ResponseWriter, r *http.Request) {
+	if h.rejectInSafeMode(w) {
+		return
+	}
+	table := mux.Vars(r)["name"]
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Minute)
+	defer cancel()
+
+	exists, err := storage.TableExists(ctx, h.db, table)
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	if !exists {
+		writeJSON(w, http.StatusNotFound, JSON{"error": "no such table: " + table})
+		return
+	}
+	var rows int64
+	err = h.guard.Do(ctx, func(ctx context.Context) error {
+		var trackErr error
+		rows, trackErr = storage.TrackRowCount(ctx, h.db, table)
+		return trackErr
+	})
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "table": table, "rows": rows})
+}
+
+// GetRowCount returns a table's tracked row count.
+func (h *Handler) GetRowCount(w http.ResponseWriter, r *http.Request) {
+	table := mux.Vars(r)["name"]
+	rows, ok, err := storage.ExactRowCount(r.Context(), h.readDB, table)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
+		return
+	}
+	if !ok {
+		writeJSON(w, http.StatusNotFound, JSON{"error": table + "'s row count is not tracked"})
+		return
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "table": table, "rows": rows})
+}
+
+// DeleteRowCount stops tracking a table's row count.
+func (h *Handler) DeleteRowCount(w http.ResponseWriter, r *http.Request) {
+	if h.rejectInSafeMode(w) {
+		return
+	}
+	table := mux.Vars(r)["name"]
+	var tracked bool
+	err := h.guard.Do(r.Context(), func(ctx context.Context) error {
+		var untrackErr error
+		tracked, untrackErr = storage.UntrackRowCount(ctx, h.db, table)
+		return untrackErr
+	})
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	if !tracked {
+		writeJSON(w, http.StatusNotFound, JSON{"error": table + "'s row count is not tracked"})
+		return
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "table": table})
+}
+
+// GetRowCounts lists the tracked tables and their counts; inactive ones
+// lost their triggers and need tracking again.
+func (h *Handler) GetRowCounts(w http.ResponseWriter, r *http.Request) {
+	counts, err := storage.ListTrackedRowCounts(r.Context(), h.readDB)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
+		return
+	}
+	if counts == nil {
+		counts = []storage.TrackedRowCount{}
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "row_counts": counts})
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/sampler"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// SampleListing is a sample as GET /samples reports it.
+type SampleListing struct {
+	storage.SampleDetails
+	// Drift is how far its table's row count has moved from the count it
+	// was drawn on (see rowDrift); AgeSeconds is how long ago it last was.
+	CurrentRows int64   `json:"current_rows"`
+	Drift       float64 `json:"drift"`
+	AgeSeconds  int64   `json:"age_seconds"`
+}
+
+// GetSamples lists every sample, or ?table='s, with its fraction, strata,
+// row counts and age, and the orphan sample tables no record refers to,
+// which DELETE /samples/{name} drops.
+func (h *Handler) GetSamples(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+
+	tables := []string{r.URL.Query().Get("table")}
+	if tables[0] == "" {
+		var err error
+		if tables, err = storage.SynopsisTables(ctx, h.db); err != nil {
+			writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+			return
+		}
+	}
+	listings := []SampleListing{}
+	for _, table := range tables {
+		samples, err := storage.ListSamples(ctx, h.db, table)
+		if err != nil {
+			writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+			return
+		}
+		if len(samples) == 0 {
+			continue
+		}
+		var current int64
+		if exists, err := storage.TableExists(ctx, h.db, table); err == nil && exists {
+			current, err = storage.CountRows(ctx, h.db, table)
+			if err != nil {
+				writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+				return
+			}
+		}
+		for _, s := range samples {
+			if s.ReservoirSize > 0 && current > 0 {
+				if s.LagRows, err = storage.ReservoirLag(ctx, h.db, table, s.ScannedRowid); err != nil {
+					writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+					return
+				}
+			}
+			d, err := storage.DescribeSample(ctx, h.db, s)
+			if err != nil {
+				writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+				return
+			}
+			listings = append(listings, SampleListing{SampleDetails: *d, CurrentRows: current,
+				Drift: rowDrift(s.BaseRows, current), AgeSeconds: int64(time.Since(d.RefreshedAt).Seconds())})
+		}
+	}
+	orphans, err := storage.FindOrphanSampleTables(ctx, h.db)
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	if orphans == nil {
+		orphans = []string{}
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "samples": listings, "orphans": orphans})
+}
+
+// PostRefreshSample brings a sample back in line with its table now, as
+// maintenance does (see sampler.RefreshSample): deleted and updated rows
+// are reconciled in place where the sample allows it, and live samples
+// are caught up. ?redraw=true draws it afresh instead, which also takes in
+// rows appended since it was drawn.
+func (h *Handler) PostRefreshSample(w http.ResponseWriter, r *http.Request) {
+	if h.rejectInSafeMode(w) {
+		return
+	}
+	sampleTable := mux.Vars(r)["name"]
+	redraw, _ := strconv.ParseBool(r.URL.Query().Get("redraw"))
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Minute)
+	defer cancel()
+
+	info, err := storage.LookupSample(ctx, h.db, sampleTable)
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	if info == nil {
+		writeJSON(w, http.StatusNotFound, JSON{"error": "no sample " + sampleTable})
+		return
+	}
+	if info.Archived {
+		writeJSON(w, http.StatusConflict, JSON{"error": "sample " + sampleTable + " is archived; it is restored when a query needs it"})
+		return
+	}
+
+	resp := JSON{"status": "ok"}
+	err = h.guard.Do(ctx, func(ctx context.Context) error {
+		if info.ReservoirSize > 0 && !redraw {
+			tx, err := h.db.BeginTx(ctx, nil)
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+			res, err := sampler.CatchUpReservoir(ctx, tx, *info)
+			if err != nil {
+				return err
+			}
+			resp["caught_up"] = res
+			return tx.Commit()
+		}
+		refresh := sampler.RefreshSample
+		if redraw {
+			refresh = sampler.RedrawSample
+		}
+		res, err := refresh(ctx, h.db, *info)
+		if err != nil {
+			return err
+		}
+		resp["refreshed"] = res
+		return nil
+	})
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,300 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+)
+
+// Priority is a query's scheduling class.
+type Priority string
+
+const (
+	// PriorityInteractive is for users waiting on the answer; it is the
+	// default, goes first, and preempts background runs.
+	PriorityInteractive Priority = "interactive"
+	// PriorityBatch is for reports and exports that can wait their turn.
+	PriorityBatch Priority = "batch"
+	// PriorityBackground is for the server's own verification work, such
+	// as shadow exact runs; it only runs with no interactive work about.
+	PriorityBackground Priority = "background"
+)
+
+// priorities lists the classes highest first.
+var priorities = []Priority{PriorityInteractive, PriorityBatch, PriorityBackground}
+
+// errPreempted is the cause of a background run's context being cancelled
+// to make room for an interactive query.
+var errPreempted = errors.New("preempted by interactive query")
+
+// errOutsideWindow means background work on a table was turned away
+// because the table's maintenance window is closed.
+var errOutsideWindow = errors.New("outside the maintenance window")
+
+// parsePriority reads a request's priority; "" is interactive.
+func parsePriority(s string) (Priority, error) {
+	if s == "" {
+		return PriorityInteractive, nil
+	}
+	for _, p := range priorities {
+		if Priority(s) == p {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("priority must be interactive, batch or background, got %q", s)
+}
+
+// heavyPlan reports whether plan scans its tables in full, so it takes a
+// scheduler slot: exact and hybrid plans, but for those reading a tracked
+// row count. Sample and sketch plans read little and run unscheduled.
+func heavyPlan(plan *planner.Plan) bool {
+	return (plan.Type == planner.PlanExact && !plan.TrackedCount) || plan.Type == planner.PlanHybrid
+}
+
+// scheduler bounds the heavy queries running at once. Waiting queries are
+// admitted highest class first, in arrival order within a class.
+// Background runs are only admitted while no interactive query is running
+// or waiting, and an arriving interactive query cancels those already
+// running.
+type scheduler struct {
+	slots int // 0: unbounded
+
+	mu      sync.Mutex
+	running map[Priority]int
+	queues  map[Priority][]*schedTicket
+	// background holds the running background runs' cancel functions.
+	background map[*schedTicket]context.CancelCauseFunc
+	stats      map[Priority]*PriorityStats
+}
+
+type schedTicket struct {
+	priority Priority
+	admitted chan struct{}
+	cancel   context.CancelCauseFunc // of the context the query runs under
+}
+
+// PriorityStats describes one scheduling class.
+type PriorityStats struct {
+	Running  int   `json:"running"`
+	Queued   int   `json:"queued"`
+	Admitted int64 `json:"admitted"`
+	// Preempted counts runs cancelled for interactive queries; TimedOut,
+	// requests that gave up waiting.
+	Preempted int64 `json:"preempted,omitempty"`
+	TimedOut  int64 `json:"timed_out,omitempty"`
+	// Deferred counts background runs turned away outside their table's
+	// maintenance window.
+	Deferred    int64   `json:"deferred,omitempty"`
+	TotalWaitMs float64 `json:"total_wait_ms"`
+	MaxWaitMs   float64 `json:"max_wait_ms"`
+}
+
+// SchedulerStats is the scheduler's state for GET /scheduler.
+type SchedulerStats struct {
+	MaxHeavyQueries int                         `json:"max_heavy_queries"`
+	Running         int                         `json:"running"`
+	Queued          int                         `json:"queued"`
+	Classes         map[Priority]*PriorityStats `json:"classes"`
+}
+
+func newScheduler(slots int) *scheduler {
+	s := &scheduler{
+		slots:      slots,
+		running:    make(map[Priority]int),
+		queues:     make(map[Priority][]*schedTicket),
+		background: make(map[*schedTicket]context.CancelCauseFunc),
+		stats:      make(map[Priority]*PriorityStats),
+	}
+	for _, p := range priorities {
+		s.stats[p] = &PriorityStats{}
+	}
+	return s
+}
+
+// acquire waits for a slot for a heavy query of class p. It returns the
+// context to run the query under, cancelled with errPreempted if a
+// background run is preempted, and the function that gives the slot back.
+func (s *scheduler) acquire(ctx context.Context, p Priority) (context.Context, func(), error) {
+	start := time.Now()
+	runCtx, cancel := context.WithCancelCause(ctx)
+	t := &schedTicket{priority: p, admitted: make(chan struct{}), cancel: cancel}
+
+	s.mu.Lock()
+	if p == PriorityInteractive {
+		s.preemptLocked()
+	}
+	s.queues[p] = append(s.queues[p], t)
+	s.dispatchLocked()
+	s.mu.Unlock()
+
+	select {
+	case <-t.admitted:
+	case <-ctx.Done():
+		s.mu.Lock()
+		select {
+		case <-t.admitted:
+			// admitted as we gave up: hand the slot on
+			s.releaseLocked(t)
+		default:
+			s.removeLocked(t)
+		}
+		s.stats[p].TimedOut++
+		s.mu.Unlock()
+		cancel(nil)
+		return nil, nil, context.Cause(ctx)
+	}
+
+	wait := float64(time.Since(start).Microseconds()) / 1000
+	s.mu.Lock()
+	st := s.stats[p]
+	st.TotalWaitMs += wait
+	st.MaxWaitMs = max(st.MaxWaitMs, wait)
+	s.mu.Unlock()
+
+	var once sync.Once
+	return runCtx, func() {
+		once.Do(func() {
+			cancel(nil)
+			s.mu.Lock()
+			s.releaseLocked(t)
+			s.mu.Unlock()
+		})
+	}, nil
+}
+
+// dispatchLocked admits waiting tickets while slots are free.
+func (s *scheduler) dispatchLocked() {
+	for {
+		t := s.nextLocked()
+		if t == nil {
+			return
+		}
+		s.removeLocked(t)
+		s.running[t.priority]++
+		s.stats[t.priority].Admitted++
+		if t.priority == PriorityBackground {
+			s.background[t] = t.cancel
+		}
+		close(t.admitted)
+	}
+}
+
+// nextLocked is the ticket to admit next, or nil if none can be.
+func (s *scheduler) nextLocked() *schedTicket {
+	if s.slots > 0 && s.totalRunningLocked() >= s.slots {
+		return nil
+	}
+	for _, p := range priorities {
+		q := s.queues[p]
+		if len(q) == 0 {
+			continue
+		}
+		if p == PriorityBackground && (s.running[PriorityInteractive] > 0 || len(s.queues[PriorityInteractive]) > 0) {
+			return nil
+		}
+		return q[0]
+	}
+	return nil
+}
+
+// preemptLocked cancels every running background run; each gives its slot
+// back as it stops.
+func (s *scheduler) preemptLocked() {
+	for t, cancel := range s.background {
+		cancel(errPreempted)
+		delete(s.background, t)
+		s.stats[PriorityBackground].Preempted++
+	}
+}
+
+func (s *scheduler) releaseLocked(t *schedTicket) {
+	delete(s.background, t)
+	s.running[t.priority]--
+	s.dispatchLocked()
+}
+
+func (s *scheduler) removeLocked(t *schedTicket) {
+	q := s.queues[t.priority]
+	for i, w := range q {
+		if w == t {
+			s.queues[t.priority] = append(q[:i:i], q[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *scheduler) totalRunningLocked() int {
+	n := 0
+	for _, r := range s.running {
+		n += r
+	}
+	return n
+}
+
+// Stats returns a snapshot of the queue depths and counters.
+func (s *scheduler) Stats() SchedulerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := SchedulerStats{MaxHeavyQueries: s.slots, Classes: make(map[Priority]*PriorityStats, len(priorities))}
+	for _, p := range priorities {
+		st := *s.stats[p]
+		st.Running, st.Queued = s.running[p], len(s.queues[p])
+		out.Running += st.Running
+		out.Queued += st.Queued
+		out.Classes[p] = &st
+	}
+	return out
+}
+
+// schedule takes a scheduler slot for heavy work; light work runs straight
+// away. The returned function must be called when the work is done.
+func (h *Handler) schedule(ctx context.Context, p Priority, heavy bool) (context.Context, func(), error) {
+	if !heavy {
+		return ctx, func() {}, nil
+	}
+	return h.scheduler.acquire(ctx, p)
+}
+
+// scheduleMaintenance takes a background slot for heavy work on table,
+// such as a shadow or verifying exact run or a synopsis rebuild, while the
+// table's maintenance window is open, and returns errOutsideWindow while
+// it is closed. The work's context ends when the window closes, so it
+// never runs past it, and an interactive query preempts it as any
+// background run.
+func (h *Handler) scheduleMaintenance(ctx context.Context, table string) (context.Context, func(), error) {
+	hours, text, err := h.maintenanceHoursFor(ctx, table)
+	if err != nil {
+		return nil, nil, err
+	}
+	open, closes := hours.openAt(time.Now())
+	if !open {
+		h.scheduler.mu.Lock()
+		h.scheduler.stats[PriorityBackground].Deferred++
+		h.scheduler.mu.Unlock()
+		return nil, nil, fmt.Errorf("%s: %w (%s)", table, errOutsideWindow, text)
+	}
+	cancel := func() {}
+	if !closes.IsZero() {
+		ctx, cancel = context.WithDeadline(ctx, closes)
+	}
+	runCtx, release, err := h.scheduler.acquire(ctx, PriorityBackground)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return runCtx, func() {
+		release()
+		cancel()
+	}, nil
+}
+
+// GetScheduler reports how many heavy queries are running and queued in
+// each priority class, and how long they have waited.
+func (h *Handler) GetScheduler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "scheduler": h.scheduler.Stats()})
+}
@@ -0,0 +1,215 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/aqeerr"
+)
+
+// maxSessionQueries bounds the queries one session runs at once.
+const maxSessionQueries = 8
+
+// SessionMessage is a message on a query session, in either direction.
+//
+// Clients send:
+//
+//	{"type": "submit", "id": "q1", "query": {"sql": "SELECT ...", "max_rel_error": 0.05}}
+//	{"type": "cancel", "id": "q1"}
+//
+// and the server answers each submit with "accepted", then a "progress"
+// message per refinement, then one of "result", "error" or "cancelled".
+// Queries run as online aggregations unless they ask for an exact answer
+// or pin a sample, when they have a single stage.
+type SessionMessage struct {
+	Type string `json:"type"`
+	// ID is the client's name for the query; it must be unique among the
+	// session's running queries.
+	ID       string        `json:"id,omitempty"`
+	Query    *QueryRequest `json:"query,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Category string        `json:"category,omitempty"`
+	// Update is the stage a progress or result message reports; it is
+	// written by session.send, not marshalled from here.
+	Update *ProgressiveUpdate `json:"-"`
+}
+
+// session is one WebSocket connection and the queries running on it.
+type session struct {
+	h    *Handler
+	conn *wsConn
+	ctx  context.Context
+	// admin is whether the session's key has the admin role.
+	admin bool
+
+	mu      sync.Mutex
+	running map[string]context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// GetQuerySession upgrades to a WebSocket on which the client submits and
+// cancels queries and receives their refinements as they are ready. It
+// serves the same queries as POST /query with progressive set, but many at
+// once and each cancellable without dropping the connection.
+func (h *Handler) GetQuerySession(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &session{h: h, conn: conn, ctx: ctx, admin: h.grants(r, RoleAdmin), running: make(map[string]context.CancelFunc)}
+	defer func() {
+		cancel()
+		s.wg.Wait()
+		conn.Close()
+	}()
+
+	for {
+		data, err := conn.ReadMessage()
+		if err != nil {
+			if !errors.Is(err, errWSClosed) {
+				log.Printf("query session: %v", err)
+			}
+			return
+		}
+		var msg SessionMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			s.send(SessionMessage{Type: "error", Error: "invalid json"})
+			continue
+		}
+		switch msg.Type {
+		case "submit":
+			s.submit(msg)
+		case "cancel":
+			s.cancel(msg.ID)
+		default:
+			s.send(SessionMessage{Type: "error", ID: msg.ID, Error: "unknown message type " + msg.Type})
+		}
+	}
+}
+
+// submit starts msg's query in the background.
+func (s *session) submit(msg SessionMessage) {
+	fail := func(err error) {
+		s.send(SessionMessage{Type: "error", ID: msg.ID, Error: err.Error(), Category: aqeerr.Category(err)})
+	}
+	if msg.ID == "" {
+		fail(errors.New("id required"))
+		return
+	}
+	if msg.Query == nil {
+		fail(errors.New("query required"))
+		return
+	}
+	req := *msg.Query
+	if req.Explain {
+		fail(errors.New("explain is not supported on sessions; use POST /query"))
+		return
+	}
+	if err := s.h.resolvePassthrough(&req, s.admin); err != nil {
+		fail(err)
+		return
+	}
+	priority, hints, err := prepareQuery(&req)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if s.h.config.SafeMode || req.SafeMode {
+		req.PreferExact = true
+	}
+
+	s.mu.Lock()
+	if _, dup := s.running[msg.ID]; dup {
+		s.mu.Unlock()
+		fail(errors.New("a query with this id is already running"))
+		return
+	}
+	if len(s.running) >= maxSessionQueries {
+		s.mu.Unlock()
+		fail(errors.New("too many queries running on this session"))
+		return
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, 120*time.Second)
+	s.running[msg.ID] = cancel
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	s.send(SessionMessage{Type: "accepted", ID: msg.ID})
+	go func() {
+		defer s.wg.Done()
+		defer s.finish(msg.ID)
+		s.run(ctx, msg.ID, req, priority, !req.PreferExact && hints.SampleTable == "")
+	}()
+}
+
+// run plans and executes one query, reporting its stages.
+func (s *session) run(ctx context.Context, id string, req QueryRequest, priority Priority, staged bool) {
+	var final bool
+	plans, err := s.h.progressivePlans(ctx, req, staged)
+	if err == nil {
+		s.h.runStages(ctx, req, priority, plans, func(u *ProgressiveUpdate) bool {
+			msg := SessionMessage{Type: "progress", ID: id, Update: u}
+			switch {
+			case u.Status == "error":
+				msg.Type, msg.Error, msg.Category = "error", u.Error, u.Category
+			case u.Final:
+				msg.Type = "result"
+			}
+			final = u.Final
+			return s.send(msg)
+		})
+	}
+	switch {
+	case final || s.ctx.Err() != nil:
+	case errors.Is(ctx.Err(), context.Canceled):
+		s.send(SessionMessage{Type: "cancelled", ID: id})
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		s.send(SessionMessage{Type: "error", ID: id, Error: "query timed out"})
+	case err != nil:
+		s.send(SessionMessage{Type: "error", ID: id, Error: err.Error(), Category: aqeerr.Category(err)})
+	}
+}
+
+// cancel stops the query id; its goroutine reports it cancelled.
+func (s *session) cancel(id string) {
+	s.mu.Lock()
+	cancel, ok := s.running[id]
+	s.mu.Unlock()
+	if !ok {
+		s.send(SessionMessage{Type: "error", ID: id, Error: "no running query with this id"})
+		return
+	}
+	cancel()
+}
+
+func (s *session) finish(id string) {
+	s.mu.Lock()
+	cancel := s.running[id]
+	delete(s.running, id)
+	s.mu.Unlock()
+	cancel()
+}
+
+// send writes msg, with its update's result rows streamed from the
+// columnar result set. It reports whether the client can still be written
+// to.
+func (s *session) send(msg SessionMessage) bool {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return false
+	}
+	if msg.Update != nil {
+		b = append(b[:len(b)-1], `,"update":`...)
+		if b, err = msg.Update.appendJSON(b); err != nil {
+			return false
+		}
+		b = append(b, '}')
+	}
+	return s.conn.WriteText(b) == nil
+}
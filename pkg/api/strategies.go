@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// StrategyFlagRequest switches a planner strategy on or off for every
+// server sharing the database, or rolls it out to a share of queries:
+//
+//	{"enabled": true, "rollout_percent": 25}
+//
+// rollout_percent defaults to 100. Which queries are in the share is
+// decided by a hash of their SQL, so the same query is planned the same
+// way until the share changes.
+type StrategyFlagRequest struct {
+	Enabled        *bool    `json:"enabled"`
+	RolloutPercent *float64 `json:"rollout_percent"`
+}
+
+// strategyState is a strategy's effective setting: from its flag, from
+// AQE_DISABLED_STRATEGIES, or on by default.
+type strategyState struct {
+	Strategy       string                `json:"strategy"`
+	Enabled        bool                  `json:"enabled"`
+	RolloutPercent float64               `json:"rollout_percent"`
+	Source         string                `json:"source"`
+	Flag           *storage.StrategyFlag `json:"flag,omitempty"`
+}
+
+// GetStrategies lists every planner strategy with its effective setting.
+func (h *Handler) GetStrategies(w http.ResponseWriter, r *http.Request) {
+	flags, err := storage.ListStrategyFlags(r.Context(), h.db)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
+		return
+	}
+	states := make([]strategyState, len(planner.Strategies))
+	for i, name := range planner.Strategies {
+		states[i] = strategyState{Strategy: name, Enabled: true, RolloutPercent: 100, Source: "default"}
+		if slices.Contains(h.config.DisabledStrategies, name) {
+			states[i].Enabled, states[i].Source = false, "config"
+		}
+		for _, f := range flags {
+			if f.Strategy == name {
+				states[i].Enabled, states[i].RolloutPercent, states[i].Source, states[i].Flag = f.Enabled, f.RolloutPercent, "flag", &f
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "strategies": states})
+}
+
+// PutStrategyFlag sets a strategy's flag. The next query planned, on any
+// server sharing the database, follows it.
+func (h *Handler) PutStrategyFlag(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !planner.KnownStrategy(name) {
+		writeJSON(w, http.StatusNotFound, JSON{"error": "no such strategy: " + name, "strategies": planner.Strategies})
+		return
+	}
+	var req StrategyFlagRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "invalid json"})
+		return
+	}
+	if req.Enabled == nil {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "enabled required"})
+		return
+	}
+	flag := &storage.StrategyFlag{Strategy: name, Enabled: *req.Enabled, RolloutPercent: 100}
+	if req.RolloutPercent != nil {
+		if *req.RolloutPercent < 0 || *req.RolloutPercent > 100 {
+			writeJSON(w, http.StatusBadRequest, JSON{"error": "rollout_percent must be in [0, 100]"})
+			return
+		}
+		flag.RolloutPercent = *req.RolloutPercent
+	}
+	err := storage.SaveStrategyFlag(r.Context(), h.db, flag)
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	if flag, err = storage.GetStrategyFlag(r.Context(), h.db, name); err != nil {
+		writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "flag": flag})
+}
+
+// DeleteStrategyFlag clears a strategy's flag, returning it to its
+// configured setting.
+func (h *Handler) DeleteStrategyFlag(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	deleted, err := storage.DeleteStrategyFlag(r.Context(), h.db, name)
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	if !deleted {
+		writeJSON(w, http.StatusNotFound, JSON{"error": "no flag set for " + name})
+		return
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "strategy": name})
+}
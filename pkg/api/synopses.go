@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// sqliteHeader starts every SQLite database file.
+const sqliteHeader = "SQLite format 3\x00"
+
+// GetExportSynopses downloads the samples and sketches of the tables named
+// by repeated "table" parameters (all tables with synopses by default) as
+// one SQLite file that POST /synopses/import loads into another database.
+func (h *Handler) GetExportSynopses(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Minute)
+	defer cancel()
+
+	path, err := tempPath("aqe-synopses-*.db")
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
+		return
+	}
+	defer os.Remove(path)
+
+	export, err := storage.ExportSynopses(ctx, h.db, path, r.URL.Query()["table"])
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Disposition", `attachment; filename="synopses.db"`)
+	w.Header().Set("X-AQE-Samples", fmt.Sprint(len(export.Samples)))
+	w.Header().Set("X-AQE-Sketches", fmt.Sprint(export.Sketches))
+	if _, err := io.Copy(w, f); err != nil {
+		log.Printf("synopsis export: %v", err)
+	}
+}
+
+// PostImportSynopses loads a synopsis export sent as the request body,
+// replacing samples and sketches of the same names.
+func (h *Handler) PostImportSynopses(w http.ResponseWriter, r *http.Request) {
+	if h.rejectInSafeMode(w) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Minute)
+	defer cancel()
+
+	path, err := tempPath("aqe-import-*.db")
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
+		return
+	}
+	defer os.Remove(path)
+
+	body := http.MaxBytesReader(w, r.Body, h.config.MaxImportBytes)
+	if err := saveUpload(path, body); err != nil {
+		var tooLarge *http.MaxBytesError
+		status := http.StatusBadRequest
+		if errors.As(err, &tooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		writeJSON(w, status, JSON{"error": err.Error()})
+		return
+	}
+
+	var report *storage.SynopsisImport
+	err = h.guard.Do(ctx, func(ctx context.Context) error {
+		var err error
+		report, err = storage.ImportSynopses(ctx, h.db, path)
+		return err
+	})
+	if err != nil {
+		status := errorStatus(err, http.StatusInternalServerError)
+		if errors.Is(err, storage.ErrNotSynopsisExport) {
+			status = http.StatusBadRequest
+		}
+		writeJSON(w, status, JSON{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "imported": report})
+}
+
+// tempPath reserves a new temporary file name matching pattern.
+func tempPath(pattern string) (string, error) {
+	f, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+// saveUpload writes body to path after checking it starts like a SQLite
+// database, so nothing else is ever attached.
+func saveUpload(path string, body io.Reader) error {
+	header := make([]byte, len(sqliteHeader))
+	if _, err := io.ReadFull(body, header); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return err
+		}
+	}
+	if string(header) != sqliteHeader {
+		return fmt.Errorf("%w: body is not a SQLite database", storage.ErrNotSynopsisExport)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(header); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
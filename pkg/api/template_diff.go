@@ -0,0 +1,336 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/estimator"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/executor"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// MetricDelta is how one aggregate of a group moved between two runs.
+// CILow and CIHigh bound the change at the diff's confidence level, from
+// both runs' intervals taken as independent; exact runs contribute none.
+type MetricDelta struct {
+	From   float64 `json:"from"`
+	To     float64 `json:"to"`
+	Delta  float64 `json:"delta"`
+	CILow  float64 `json:"ci_low"`
+	CIHigh float64 `json:"ci_high"`
+	// RelChange is Delta relative to From; it is left out when From is 0.
+	RelChange *float64 `json:"rel_change,omitempty"`
+	// PIncrease is the probability the value went up.
+	PIncrease float64 `json:"p_increase"`
+	// Significant is set when the interval of the change excludes 0.
+	Significant bool `json:"significant"`
+}
+
+// GroupDiff is one group of a template's result across two runs. Status is
+// "changed" when any aggregate moved significantly, "unchanged", or
+// "added" or "removed" for groups in only one run, whose aggregates are
+// then in Values.
+type GroupDiff struct {
+	Key    map[string]any          `json:"key"`
+	Status string                  `json:"status"`
+	Deltas map[string]*MetricDelta `json:"deltas,omitempty"`
+	Values map[string]any          `json:"values,omitempty"`
+}
+
+// recordTemplateRun keeps a template run's answer for diffs. A failure is
+// logged, not reported: the answer itself is fine.
+func (h *Handler) recordTemplateRun(ctx context.Context, name, sqlText string, params map[string]json.RawMessage, resp *QueryResponse) {
+	if resp.Result == nil || resp.Plan == nil {
+		return
+	}
+	result, err := resp.Result.MarshalJSON()
+	if err != nil {
+		log.Printf("template %s: recording run: %v", name, err)
+		return
+	}
+	aggregates := resp.Plan.Aggregates
+	if len(aggregates) == 0 {
+		aggregates = planner.ResultAggregates(sqlText)
+	}
+	run := &storage.TemplateRun{
+		Template:        name,
+		SQL:             sqlText,
+		PlanType:        string(resp.Plan.Type),
+		ConfidenceLevel: resp.Plan.Level(),
+		Columns:         resp.Result.ColumnNames(),
+		Aggregates:      []string{},
+		Result:          result,
+	}
+	if len(params) > 0 {
+		run.Params, _ = json.Marshal(params)
+	}
+	for _, a := range aggregates {
+		run.Aggregates = append(run.Aggregates, a.Column)
+	}
+	if err := storage.SaveTemplateRun(ctx, h.db, run, h.config.TemplateRunHistory); err != nil {
+		log.Printf("template %s: recording run: %v", name, err)
+	}
+}
+
+// GetTemplateRuns lists the kept runs of a template, newest first and
+// without their results; ?limit= bounds them (default 20).
+func (h *Handler) GetTemplateRuns(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	limit := 20
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeJSON(w, http.StatusBadRequest, JSON{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = n
+	}
+	runs, err := storage.ListTemplateRuns(r.Context(), h.db, name, limit)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
+		return
+	}
+	if runs == nil {
+		runs = []storage.TemplateRun{}
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "template": name, "runs": runs})
+}
+
+// GetTemplateDiff compares two kept runs of a template group by group:
+// ?to= defaults to the newest run and ?from= to the one before it. Each
+// is a run id, an RFC 3339 time or a YYYY-MM-DD date (the end of that day,
+// UTC), a time picking the newest run made by then. Groups are matched on
+// the result's non-aggregate columns; each aggregate's change carries an
+// interval at ?confidence= (default the higher of the runs' levels).
+func (h *Handler) GetTemplateDiff(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	ctx := r.Context()
+	query := r.URL.Query()
+
+	to, err := h.templateRun(ctx, name, query.Get("to"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "to: " + err.Error()})
+		return
+	}
+	if to == nil {
+		writeJSON(w, http.StatusNotFound, JSON{"error": fmt.Sprintf("no run of template %q to diff to", name)})
+		return
+	}
+	var from *storage.TemplateRun
+	if v := query.Get("from"); v != "" {
+		from, err = h.templateRun(ctx, name, v)
+	} else {
+		from, err = storage.TemplateRunBefore(ctx, h.db, name, to.ID)
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "from: " + err.Error()})
+		return
+	}
+	if from == nil {
+		writeJSON(w, http.StatusNotFound, JSON{"error": fmt.Sprintf("no run of template %q to diff from", name)})
+		return
+	}
+
+	level := math.Max(from.ConfidenceLevel, to.ConfidenceLevel)
+	if v := query.Get("confidence"); v != "" {
+		level, err = strconv.ParseFloat(v, 64)
+		if err != nil || level <= 0 || level >= 1 {
+			writeJSON(w, http.StatusBadRequest, JSON{"error": "confidence must be in (0, 1)"})
+			return
+		}
+	}
+
+	keys, groups, err := diffRuns(from, to, level)
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, JSON{"error": err.Error()})
+		return
+	}
+	summary := map[string]int{"changed": 0, "unchanged": 0, "added": 0, "removed": 0}
+	for _, g := range groups {
+		summary[g.Status]++
+	}
+	from.Result, to.Result = nil, nil
+	writeJSON(w, http.StatusOK, JSON{
+		"status":           "ok",
+		"template":         name,
+		"from":             from,
+		"to":               to,
+		"confidence_level": level,
+		"key_columns":      keys,
+		"summary":          summary,
+		"groups":           groups,
+	})
+}
+
+// templateRun resolves a from or to reference; empty means the newest run.
+func (h *Handler) templateRun(ctx context.Context, name, ref string) (*storage.TemplateRun, error) {
+	if ref == "" {
+		return storage.TemplateRunAt(ctx, h.db, name, time.Now())
+	}
+	if id, err := strconv.ParseInt(ref, 10, 64); err == nil {
+		return storage.GetTemplateRun(ctx, h.db, name, id)
+	}
+	if t, err := time.Parse(time.RFC3339, ref); err == nil {
+		return storage.TemplateRunAt(ctx, h.db, name, t)
+	}
+	if t, err := time.Parse(time.DateOnly, ref); err == nil {
+		return storage.TemplateRunAt(ctx, h.db, name, t.Add(24*time.Hour-time.Second))
+	}
+	return nil, fmt.Errorf("%q is not a run id, RFC 3339 time or YYYY-MM-DD date", ref)
+}
+
+// diffRuns matches the groups of two runs and compares their aggregates
+// at confidence level. It returns the key columns and the groups, in the
+// to run's order followed by those only the from run has.
+func diffRuns(from, to *storage.TemplateRun, level float64) ([]string, []GroupDiff, error) {
+	keys := resultKeyColumns(to.Columns, to.Aggregates)
+	if fmt.Sprint(keys) != fmt.Sprint(resultKeyColumns(from.Columns, from.Aggregates)) || fmt.Sprint(from.Aggregates) != fmt.Sprint(to.Aggregates) {
+		return nil, nil, fmt.Errorf("runs %d and %d have different result columns", from.ID, to.ID)
+	}
+	fromRows, err := decodeRunResult(from)
+	if err != nil {
+		return nil, nil, fmt.Errorf("run %d: %v", from.ID, err)
+	}
+	toRows, err := decodeRunResult(to)
+	if err != nil {
+		return nil, nil, fmt.Errorf("run %d: %v", to.ID, err)
+	}
+
+	groupKey := func(rs *executor.ResultSet, i int) (string, map[string]any) {
+		key := make(map[string]any, len(keys))
+		for _, k := range keys {
+			key[k] = rs.Column(k).Value(i)
+		}
+		return rowKey(rs, keys, i), key
+	}
+	values := func(rs *executor.ResultSet, i int) map[string]any {
+		out := make(map[string]any, len(to.Aggregates))
+		for _, a := range to.Aggregates {
+			out[a] = rs.Column(a).Value(i)
+		}
+		return out
+	}
+
+	fromIndex := make(map[string]int, fromRows.Len())
+	for i := 0; i < fromRows.Len(); i++ {
+		k, _ := groupKey(fromRows, i)
+		fromIndex[k] = i
+	}
+	z := estimator.ZScore(level)
+	var groups []GroupDiff
+	matched := make(map[string]bool, fromRows.Len())
+	for i := 0; i < toRows.Len(); i++ {
+		k, key := groupKey(toRows, i)
+		fi, ok := fromIndex[k]
+		if !ok {
+			groups = append(groups, GroupDiff{Key: key, Status: "added", Values: values(toRows, i)})
+			continue
+		}
+		matched[k] = true
+		g := GroupDiff{Key: key, Status: "unchanged", Deltas: make(map[string]*MetricDelta, len(to.Aggregates))}
+		for _, a := range to.Aggregates {
+			x0, ok0 := fromRows.Column(a).Float(fi)
+			x1, ok1 := toRows.Column(a).Float(i)
+			if !ok0 || !ok1 {
+				continue
+			}
+			se0 := intervalStdError(fromRows, a, fi, from.ConfidenceLevel)
+			se1 := intervalStdError(toRows, a, i, to.ConfidenceLevel)
+			half := z * math.Sqrt(se0*se0+se1*se1)
+			d := &MetricDelta{
+				From:      x0,
+				To:        x1,
+				Delta:     x1 - x0,
+				CILow:     x1 - x0 - half,
+				CIHigh:    x1 - x0 + half,
+				PIncrease: estimator.ProbGreater(x1, se1, x0, se0),
+			}
+			if x0 != 0 {
+				rel := d.Delta / math.Abs(x0)
+				d.RelChange = &rel
+			}
+			d.Significant = d.CILow > 0 || d.CIHigh < 0
+			if d.Significant {
+				g.Status = "changed"
+			}
+			g.Deltas[a] = d
+		}
+		groups = append(groups, g)
+	}
+	for i := 0; i < fromRows.Len(); i++ {
+		if k, key := groupKey(fromRows, i); !matched[k] {
+			groups = append(groups, GroupDiff{Key: key, Status: "removed", Values: values(fromRows, i)})
+		}
+	}
+	if groups == nil {
+		groups = []GroupDiff{}
+	}
+	return keys, groups, nil
+}
+
+// resultKeyColumns are the columns of a result that identify its groups:
+// all but the aggregates and their intervals.
+func resultKeyColumns(columns, aggregates []string) []string {
+	derived := make(map[string]bool, 4*len(aggregates))
+	for _, a := range aggregates {
+		derived[a] = true
+		for _, suffix := range []string{"_ci_low", "_ci_high", "_rel_error"} {
+			derived[a+suffix] = true
+		}
+	}
+	keys := []string{}
+	for _, c := range columns {
+		if !derived[c] {
+			keys = append(keys, c)
+		}
+	}
+	return keys
+}
+
+// rowKey identifies row i of rs by its values of keys.
+func rowKey(rs *executor.ResultSet, keys []string, i int) string {
+	vals := make([]any, len(keys))
+	for j, k := range keys {
+		vals[j] = rs.Column(k).Value(i)
+	}
+	b, _ := json.Marshal(vals)
+	return string(b)
+}
+
+// decodeRunResult reads a run's stored rows back into a result set.
+func decodeRunResult(run *storage.TemplateRun) (*executor.ResultSet, error) {
+	dec := json.NewDecoder(bytes.NewReader(run.Result))
+	dec.UseNumber()
+	var rows []map[string]any
+	if err := dec.Decode(&rows); err != nil {
+		return nil, err
+	}
+	rs := executor.NewResultSet(run.Columns)
+	vals := make([]any, len(run.Columns))
+	for _, row := range rows {
+		for i, c := range run.Columns {
+			v := row[c]
+			if n, ok := v.(json.Number); ok {
+				if whole, err := n.Int64(); err == nil && !strings.ContainsAny(n.String(), ".eE") {
+					v = whole
+				} else {
+					v, _ = n.Float64()
+				}
+			}
+			vals[i] = v
+		}
+		rs.AppendRow(vals)
+	}
+	return rs, nil
+}
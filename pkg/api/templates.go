@@ -0,0 +1,179 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// templateNameRe is what a template name may be, so it fits in a URL path.
+var templateNameRe = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// TemplateRequest saves a named query template:
+//
+//	{"name": "region_revenue",
+//	 "sql": "SELECT SUM(amount) FROM purchases WHERE region = :region AND day >= :since",
+//	 "params": [{"name": "region", "type": "string"}, {"name": "since", "type": "date", "default": "2024-01-01"}],
+//	 "max_rel_error": 0.02}
+//
+// MaxRelError and ConfidenceLevel are the defaults for its runs.
+type TemplateRequest struct {
+	Name            string                  `json:"name"`
+	SQL             string                  `json:"sql"`
+	Params          []planner.TemplateParam `json:"params"`
+	MaxRelError     float64                 `json:"max_rel_error"`
+	ConfidenceLevel float64                 `json:"confidence_level,omitempty"`
+	Description     string                  `json:"description,omitempty"`
+}
+
+// TemplateRunRequest runs a saved template. Params are the parameter
+// values by name; the other fields are QueryRequest's, except that the SQL
+// is the template's, and an unset max_rel_error or confidence_level takes
+// the template's default.
+type TemplateRunRequest struct {
+	Params map[string]json.RawMessage `json:"params"`
+	QueryRequest
+}
+
+// PostTemplate saves a query template, replacing any of the same name.
+func (h *Handler) PostTemplate(w http.ResponseWriter, r *http.Request) {
+	var req TemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "invalid json"})
+		return
+	}
+	req.SQL = strings.TrimSpace(req.SQL)
+	switch {
+	case !templateNameRe.MatchString(req.Name):
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "name must be 1-64 letters, digits, '_', '.' or '-'"})
+		return
+	case req.MaxRelError < 0:
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "max_rel_error must be non-negative"})
+		return
+	case req.ConfidenceLevel < 0 || req.ConfidenceLevel >= 1:
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "confidence_level must be in (0, 1)"})
+		return
+	}
+	if err := planner.CheckTemplate(req.SQL, req.Params); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorBody(err))
+		return
+	}
+
+	if req.Params == nil {
+		req.Params = []planner.TemplateParam{}
+	}
+	params, _ := json.Marshal(req.Params)
+	t := &storage.QueryTemplate{
+		Name:            req.Name,
+		SQL:             req.SQL,
+		Params:          params,
+		MaxRelError:     req.MaxRelError,
+		ConfidenceLevel: req.ConfidenceLevel,
+		Description:     req.Description,
+	}
+	replaced, err := storage.SaveQueryTemplate(r.Context(), h.db, t)
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	if t, err = storage.GetQueryTemplate(r.Context(), h.db, req.Name); err != nil {
+		writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
+		return
+	}
+	status := http.StatusCreated
+	if replaced {
+		status = http.StatusOK
+	}
+	writeJSON(w, status, JSON{"status": "ok", "template": t})
+}
+
+// GetTemplates lists the saved query templates.
+func (h *Handler) GetTemplates(w http.ResponseWriter, r *http.Request) {
+	templates, err := storage.ListQueryTemplates(r.Context(), h.db)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
+		return
+	}
+	if templates == nil {
+		templates = []storage.QueryTemplate{}
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "templates": templates})
+}
+
+// DeleteTemplate removes a saved query template.
+func (h *Handler) DeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	deleted, err := storage.DeleteQueryTemplate(r.Context(), h.db, name)
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	if !deleted {
+		writeJSON(w, http.StatusNotFound, JSON{"error": fmt.Sprintf("no template %q", name)})
+		return
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok"})
+}
+
+// PostRunTemplate runs a saved template with the given parameters and
+// answers as POST /query does. Its runs are learned from under the
+// template's normalized SQL rather than their own, so the learner pools
+// them whatever the parameter values, and their answers are kept for
+// GetTemplateDiff.
+func (h *Handler) PostRunTemplate(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	var req TemplateRunRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "invalid json"})
+		return
+	}
+	if req.SQL != "" {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "sql is the template's; pass parameters in params"})
+		return
+	}
+
+	t, err := storage.GetQueryTemplate(r.Context(), h.db, name)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
+		return
+	}
+	if t == nil {
+		writeJSON(w, http.StatusNotFound, JSON{"error": fmt.Sprintf("no template %q", name)})
+		return
+	}
+	var params []planner.TemplateParam
+	if err := json.Unmarshal(t.Params, &params); err != nil {
+		writeJSON(w, http.StatusInternalServerError, JSON{"error": fmt.Sprintf("template %q: bad params: %v", name, err)})
+		return
+	}
+	sqlText, err := planner.RenderTemplate(t.SQL, params, req.Params)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorBody(err))
+		return
+	}
+
+	q := req.QueryRequest
+	if err := h.resolvePassthrough(&q, h.grants(r, RoleAdmin)); err != nil {
+		writeJSON(w, errorStatus(err, http.StatusBadRequest), errorBody(err))
+		return
+	}
+	q.SQL = sqlText
+	q.pattern = planner.TemplatePattern(t.SQL)
+	if h.config.TemplateRunHistory > 0 {
+		q.onAnswer = func(resp *QueryResponse) { h.recordTemplateRun(r.Context(), t.Name, sqlText, req.Params, resp) }
+	}
+	if q.MaxRelError == 0 {
+		q.MaxRelError = t.MaxRelError
+	}
+	if q.ConfidenceLevel == 0 {
+		q.ConfidenceLevel = t.ConfidenceLevel
+	}
+	h.serveQuery(w, r, q)
+}
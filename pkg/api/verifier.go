@@ -0,0 +1,155 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/executor"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+)
+
+// maxPendingVerifications bounds the verifier's queue; answers arriving
+// while it is full are not verified.
+const maxPendingVerifications = 64
+
+// verification is an approximate answer, recorded in the learning history
+// under historyID, waiting to be checked against an exact run.
+type verification struct {
+	historyID int64
+	plan      *planner.Plan
+	result    *executor.ResultSet
+}
+
+// verifier queues approximate answers for runVerifier.
+type verifier struct {
+	mu      sync.Mutex
+	pending []verification
+}
+
+func (v *verifier) enqueue(job verification) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.pending) < maxPendingVerifications {
+		v.pending = append(v.pending, job)
+	}
+}
+
+func (v *verifier) take() []verification {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	jobs := v.pending
+	v.pending = nil
+	return jobs
+}
+
+// runVerifier re-runs the queued approximate answers exactly once per
+// interval and records their true relative error in the learning history
+// in place of the error the answers claimed for themselves, so the learner
+// is scored on what its plans actually got wrong. Answers on tables whose
+// maintenance window is closed wait in the queue for it to open.
+func (h *Handler) runVerifier(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		for _, job := range h.verifier.take() {
+			trueError, ok, err := h.verify(job)
+			if errors.Is(err, errOutsideWindow) {
+				h.verifier.enqueue(job) // until the window opens
+				continue
+			}
+			if err != nil {
+				log.Printf("verifier: history record %d: %v", job.historyID, err)
+				continue
+			}
+			if !ok {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			if err := h.learner.RecordVerifiedError(ctx, job.historyID, trueError); err != nil {
+				log.Printf("verifier: history record %d: %v", job.historyID, err)
+			}
+			cancel()
+		}
+	}
+}
+
+// verify runs job's query exactly, at background priority and within its
+// table's maintenance window like shadow runs, and returns the approximate answer's true relative error. ok is
+// false when there was nothing to compare.
+func (h *Handler) verify(job verification) (trueError float64, ok bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.config.ShadowExactTimeout)
+	defer cancel()
+
+	sqlText := job.plan.OriginalSQL
+	exact := &planner.Plan{Type: planner.PlanExact, SQL: sqlText, OriginalSQL: sqlText}
+	ctx, release, err := h.scheduleMaintenance(ctx, job.plan.Table)
+	if err != nil {
+		return 0, false, err
+	}
+	defer release()
+
+	var rows *executor.ResultSet
+	err = h.guard.Do(ctx, func(ctx context.Context) error {
+		ctx = executor.WithMemoryBudget(ctx, executor.NewMemoryBudget(h.config.MaxQueryMemoryBytes))
+		var execErr error
+		rows, _, execErr = executor.Execute(ctx, h.readDB, exact)
+		return execErr
+	})
+	if err != nil {
+		return 0, false, err
+	}
+
+	aggregates := job.plan.Aggregates
+	if len(aggregates) == 0 {
+		aggregates = planner.ResultAggregates(sqlText)
+	}
+	columns := make([]string, len(aggregates))
+	for i, a := range aggregates {
+		columns[i] = a.Column
+	}
+	trueError, ok = trueRelError(job.result, rows, columns)
+	return trueError, ok, nil
+}
+
+// trueRelError is the largest relative error of approx's aggregates
+// against exact's, matching groups on the other columns. A group approx
+// missed counts as wholly wrong; exact zeros, which no relative error
+// describes, are skipped.
+func trueRelError(approx, exact *executor.ResultSet, aggregates []string) (worst float64, ok bool) {
+	keys := resultKeyColumns(exact.ColumnNames(), aggregates)
+	for _, k := range keys {
+		if approx.Column(k) == nil {
+			return 0, false
+		}
+	}
+	approxIndex := make(map[string]int, approx.Len())
+	for i := 0; i < approx.Len(); i++ {
+		approxIndex[rowKey(approx, keys, i)] = i
+	}
+
+	for i := 0; i < exact.Len(); i++ {
+		ai, found := approxIndex[rowKey(exact, keys, i)]
+		for _, a := range aggregates {
+			ec, ac := exact.Column(a), approx.Column(a)
+			if ec == nil || ac == nil {
+				continue
+			}
+			truth, isNum := ec.Float(i)
+			if !isNum || truth == 0 {
+				continue
+			}
+			rel := 1.0
+			if found {
+				if est, isNum := ac.Float(ai); isNum {
+					rel = math.Abs(est-truth) / math.Abs(truth)
+				}
+			}
+			worst, ok = math.Max(worst, rel), true
+		}
+	}
+	return worst, ok
+}
@@ -0,0 +1,214 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// WarmupSummary reports what startup warm-up did.
+type WarmupSummary struct {
+	Status     string     `json:"status"` // "running" or "done"
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// Tables are the user tables whose statistics were loaded.
+	Tables []TableWarmup `json:"tables"`
+	// Dangling are the synopsis records whose tables are gone; they are
+	// dropped unless the server is in safe mode.
+	Dangling []storage.DanglingSynopsis `json:"dangling"`
+	Dropped  int                        `json:"dropped"`
+	// Queries are the saved templates run again to fill the result cache.
+	Queries []WarmedQuery `json:"queries"`
+	Errors  []string      `json:"errors,omitempty"`
+}
+
+// TableWarmup is a table's statistics as warm-up loaded them.
+type TableWarmup struct {
+	Table    string `json:"table"`
+	Rows     int64  `json:"rows"`
+	Analyzed bool   `json:"analyzed"` // has column statistics
+}
+
+// WarmedQuery is a saved template's latest run, answered again by warm-up.
+type WarmedQuery struct {
+	Template   string  `json:"template"`
+	SQL        string  `json:"sql"`
+	PlanType   string  `json:"plan_type,omitempty"`
+	DurationMS float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// warmupState holds the warm-up summary, which RegisterRoutes starts.
+type warmupState struct {
+	mu      sync.Mutex
+	summary *WarmupSummary
+}
+
+func (s *warmupState) update(fn func(*WarmupSummary)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s.summary)
+}
+
+// snapshot copies the summary.
+func (s *warmupState) snapshot() *WarmupSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := *s.summary
+	out.Tables = append([]TableWarmup{}, s.summary.Tables...)
+	out.Dangling = append([]storage.DanglingSynopsis{}, s.summary.Dangling...)
+	out.Queries = append([]WarmedQuery{}, s.summary.Queries...)
+	out.Errors = append([]string(nil), s.summary.Errors...)
+	return &out
+}
+
+// warmUp readies the engine after startup: it loads every user table's row
+// count and column statistics, drops synopsis records whose tables are gone
+// (see storage.FindDanglingSynopses), and answers the latest runs of the
+// config.WarmupQueries most run saved templates, so the first requests of
+// those answered from synopses are served from the result cache. Safe
+// mode only reports dangling
+// records and runs no queries.
+func (h *Handler) warmUp(ctx context.Context) {
+	fail := func(step string, err error) {
+		log.Printf("warm-up: %s: %v", step, err)
+		h.warmup.update(func(s *WarmupSummary) { s.Errors = append(s.Errors, step+": "+err.Error()) })
+	}
+
+	if err := h.warmTables(ctx); err != nil {
+		fail("loading table statistics", err)
+	}
+
+	dangling, err := storage.FindDanglingSynopses(ctx, h.db)
+	if err != nil {
+		fail("validating synopses", err)
+	}
+	dropped := 0
+	for _, d := range dangling {
+		if h.config.SafeMode {
+			continue
+		}
+		err := h.guard.Do(ctx, func(ctx context.Context) error {
+			return storage.DropDanglingSynopsis(ctx, h.db, d)
+		})
+		if err != nil {
+			fail("dropping "+d.Kind+" "+d.Name, err)
+			continue
+		}
+		log.Printf("warm-up: dropped %s %s of %s: %s", d.Kind, d.Name, d.Table, d.Reason)
+		dropped++
+	}
+	h.warmup.update(func(s *WarmupSummary) {
+		s.Dangling = append(s.Dangling, dangling...)
+		s.Dropped = dropped
+	})
+
+	if !h.config.SafeMode && h.config.WarmupQueries > 0 {
+		if err := h.warmQueries(ctx); err != nil {
+			fail("warming the result cache", err)
+		}
+	}
+
+	var summary WarmupSummary
+	h.warmup.update(func(s *WarmupSummary) {
+		finished := time.Now().UTC()
+		s.Status, s.FinishedAt = "done", &finished
+		summary = *s
+	})
+	log.Printf("warm-up done in %s: %d tables loaded, %d dangling synopses (%d dropped), %d queries answered, %d errors",
+		summary.FinishedAt.Sub(summary.StartedAt).Round(time.Millisecond), len(summary.Tables),
+		len(summary.Dangling), summary.Dropped, len(summary.Queries), len(summary.Errors))
+}
+
+// warmTables reads each user table's row count and column statistics, as
+// the planner will, through the read connection.
+func (h *Handler) warmTables(ctx context.Context) error {
+	rows, err := h.readDB.QueryContext(ctx, storage.ActiveDialect().ListTablesQuery())
+	if err != nil {
+		return err
+	}
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		lower := strings.ToLower(name)
+		if !strings.HasPrefix(lower, "aqe_") && !strings.HasPrefix(lower, "ml_") {
+			tables = append(tables, name)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		n, err := storage.EstimateRowCount(ctx, h.readDB, table)
+		if err != nil {
+			return err
+		}
+		stats, err := storage.GetColumnStats(ctx, h.readDB, table)
+		if err != nil {
+			return err
+		}
+		h.warmup.update(func(s *WarmupSummary) {
+			s.Tables = append(s.Tables, TableWarmup{Table: table, Rows: n, Analyzed: len(stats) > 0})
+		})
+	}
+	return nil
+}
+
+// warmQueries answers the latest runs of the most run saved templates, at
+// their templates' defaults and with ML optimization on, as the UI asks.
+func (h *Handler) warmQueries(ctx context.Context) error {
+	runs, err := storage.FrequentTemplateRuns(ctx, h.readDB, h.config.WarmupQueries)
+	if err != nil {
+		return err
+	}
+	for _, run := range runs {
+		t, err := storage.GetQueryTemplate(ctx, h.readDB, run.Template)
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			continue
+		}
+		warmed := WarmedQuery{Template: run.Template, SQL: run.SQL}
+		req := QueryRequest{
+			SQL:               run.SQL,
+			MaxRelError:       t.MaxRelError,
+			ConfidenceLevel:   t.ConfidenceLevel,
+			UseMLOptimization: true,
+			pattern:           planner.TemplatePattern(t.SQL),
+			warmup:            true,
+			onAnswer:          func(resp *QueryResponse) { warmed.PlanType = string(resp.Plan.Type) },
+		}
+		r, err := http.NewRequestWithContext(ctx, http.MethodPost, "/query", http.NoBody)
+		if err != nil {
+			return err
+		}
+		w := &jobWriter{header: make(http.Header)}
+		start := time.Now()
+		h.serveQuery(w, r, req)
+		warmed.DurationMS = float64(time.Since(start).Microseconds()) / 1000
+		if warmed.PlanType == "" {
+			warmed.Error = w.errorMessage()
+		}
+		h.warmup.update(func(s *WarmupSummary) { s.Queries = append(s.Queries, warmed) })
+	}
+	return nil
+}
+
+// GetWarmup reports the startup warm-up: what it has done so far, or all
+// of it once its status is "done".
+func (h *Handler) GetWarmup(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "warmup": h.warmup.snapshot()})
+}
@@ -0,0 +1,436 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/ml"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/sketches"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// widthProbeRows is how many rows are read to estimate how wide a table's
+// columns are.
+const widthProbeRows = 1000
+
+// WhatIfSampleRequest is the body of POST /whatif/sample: the sample
+// POST /samples/create would build, and the error to judge it against.
+type WhatIfSampleRequest struct {
+	CreateSampleRequest
+	// MaxRelError defaults to the table's policy, else
+	// defaultPolicyMaxRelError.
+	MaxRelError float64 `json:"max_rel_error,omitempty"`
+}
+
+// WhatIfSketchRequest is the body of POST /whatif/sketch, with the fields
+// of POST /sketches/create.
+type WhatIfSketchRequest struct {
+	Table       string         `json:"table"`
+	Column      string         `json:"column"`
+	SketchType  string         `json:"sketch_type"`
+	Parameters  map[string]any `json:"parameters,omitempty"`
+	MaxRelError float64        `json:"max_rel_error,omitempty"`
+}
+
+// WhatIfPattern is a recorded query pattern the proposed synopsis could
+// answer. CurrentError is the error of the best synopsis answering it
+// today, absent when it can only run exact; errors are the planner's
+// estimates, which decide whether it approximates.
+type WhatIfPattern struct {
+	Pattern        string   `json:"pattern"`
+	Runs           int64    `json:"runs"`
+	ExactRuns      int64    `json:"exact_runs"`
+	CurrentError   *float64 `json:"current_error,omitempty"`
+	PredictedError float64  `json:"predicted_error"`
+	MeetsTarget    bool     `json:"meets_target"`
+}
+
+// WhatIfReport predicts what building a synopsis costs and what it buys
+// the table's recorded workload. RunsImproved counts runs of patterns the
+// proposal would answer more accurately than today; RunsNewlyApproximate
+// counts exact runs of patterns it would answer within TargetError, and
+// EstimatedSavedMs what they would have saved, as in CoverageGap.
+type WhatIfReport struct {
+	Table                string               `json:"table"`
+	Synopsis             planner.SynopsisNeed `json:"synopsis"`
+	RowCount             int64                `json:"row_count"`
+	SampleRows           int64                `json:"sample_rows,omitempty"`
+	EstimatedBytes       int64                `json:"estimated_bytes"`
+	EstimatedBuildMs     float64              `json:"estimated_build_ms"`
+	TargetError          float64              `json:"target_error"`
+	Patterns             []WhatIfPattern      `json:"patterns"`
+	RunsImproved         int64                `json:"runs_improved"`
+	RunsNewlyApproximate int64                `json:"runs_newly_approximate"`
+	EstimatedSavedMs     float64              `json:"estimated_saved_ms"`
+}
+
+// PostWhatIfSample predicts a sample's size, build time and effect on the
+// recorded workload without building it.
+func (h *Handler) PostWhatIfSample(w http.ResponseWriter, r *http.Request) {
+	var req WhatIfSampleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "invalid json"})
+		return
+	}
+	if req.Table == "" || req.SampleFraction <= 0 || req.SampleFraction >= 1 {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "table and 0<sample_fraction<1 required"})
+		return
+	}
+	if req.InferColumns && len(req.Columns) > 0 {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "columns and infer_columns are exclusive"})
+		return
+	}
+	if req.Predicate != "" || req.OutlierColumn != "" {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "what-if estimates are for uniform samples; drop predicate and outlier_column"})
+		return
+	}
+	if req.MaxRelError < 0 {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "max_rel_error must be non-negative"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	wi, status, err := h.newWhatIf(ctx, req.Table, req.MaxRelError)
+	if err != nil {
+		writeJSON(w, errorStatus(err, status), JSON{"error": err.Error()})
+		return
+	}
+	if req.InferColumns {
+		if req.Columns, _, err = h.inferSampleColumns(ctx, req.Table); err != nil {
+			writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
+			return
+		}
+	}
+	report, err := wi.sample(ctx, h, req.SampleFraction, req.Columns)
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "whatif": report})
+}
+
+// PostWhatIfSketch predicts a sketch's size, build time and effect on the
+// recorded workload without building it.
+func (h *Handler) PostWhatIfSketch(w http.ResponseWriter, r *http.Request) {
+	var req WhatIfSketchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "invalid json"})
+		return
+	}
+	if req.Table == "" || req.Column == "" || req.SketchType == "" {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "table, column and sketch_type required"})
+		return
+	}
+	switch storage.SketchType(req.SketchType) {
+	case storage.HyperLogLogType, storage.CountMinSketchType, storage.TDigestType:
+	default:
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "unsupported sketch type"})
+		return
+	}
+	if req.MaxRelError < 0 {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "max_rel_error must be non-negative"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	wi, status, err := h.newWhatIf(ctx, req.Table, req.MaxRelError)
+	if err != nil {
+		writeJSON(w, errorStatus(err, status), JSON{"error": err.Error()})
+		return
+	}
+	report, err := wi.sketch(ctx, h, storage.SketchType(req.SketchType), req.Column, req.Parameters)
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok", "whatif": report})
+}
+
+// whatIf is what a prediction for one table weighs a proposal against:
+// its statistics, recorded workload and the synopses it already has.
+type whatIf struct {
+	table     string
+	target    float64
+	planner   *planner.Planner
+	stats     *planner.TableStatistics
+	tableCols []string
+	patterns  []ml.PatternUsage
+	sketches  map[planner.SynopsisNeed]bool
+	uniform   []storage.SampleInfo
+}
+
+// newWhatIf loads table's side of a prediction. The status goes with a
+// non-nil error.
+func (h *Handler) newWhatIf(ctx context.Context, table string, target float64) (*whatIf, int, error) {
+	exists, err := storage.TableExists(ctx, h.db, table)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if !exists {
+		return nil, http.StatusNotFound, fmt.Errorf("no such table: %s", table)
+	}
+	wi := &whatIf{table: table, target: target, planner: planner.New(), sketches: make(map[planner.SynopsisNeed]bool)}
+	wi.planner.SetComplexityThreshold(h.config.ComplexityThreshold)
+
+	if wi.target == 0 {
+		wi.target = defaultPolicyMaxRelError
+		policy, err := storage.GetTablePolicy(ctx, h.db, table)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		if policy != nil && policy.MaxRelError > 0 {
+			wi.target = policy.MaxRelError
+		}
+	}
+	if wi.stats, err = (planner.CatalogStats{}).TableStatistics(ctx, h.db, table); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if wi.tableCols, err = storage.TableColumns(ctx, h.db, table); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if wi.patterns, err = h.learner.TablePatterns(ctx, table); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	sketchList, err := storage.ListSketches(ctx, h.db, table)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	for _, sk := range sketchList {
+		if !sk.Degraded {
+			wi.sketches[planner.SynopsisNeed{Kind: string(sk.Type), Column: strings.ToLower(sk.Column)}] = true
+		}
+	}
+	samples, err := storage.ListSamples(ctx, h.db, table)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	for _, s := range samples {
+		if s.StrataColumn == "" && s.Predicate == "" && s.OutlierTable == "" && s.UniverseColumn == "" {
+			wi.uniform = append(wi.uniform, s)
+		}
+	}
+	return wi, 0, nil
+}
+
+// sample predicts a uniform sample of fraction copying columns, or every
+// column when there are none.
+func (wi *whatIf) sample(ctx context.Context, h *Handler, fraction float64, columns []string) (*WhatIfReport, error) {
+	need := planner.SynopsisNeed{Kind: "sample"}
+	report := wi.report(need)
+	report.SampleRows = int64(math.Round(float64(report.RowCount) * fraction))
+
+	widthCols := columns
+	if len(widthCols) == 0 {
+		widthCols = wi.tableCols
+	}
+	widths, err := h.columnWidths(ctx, wi.table, widthCols)
+	if err != nil {
+		return nil, err
+	}
+	var rowBytes float64
+	for _, width := range widths {
+		rowBytes += width
+	}
+	report.EstimatedBytes = int64(math.Ceil(float64(report.SampleRows) * rowBytes))
+	report.EstimatedBuildMs = wi.planner.SynopsisBuildMs(need, report.RowCount, report.SampleRows)
+
+	speedup := wi.planner.SampleSpeedup(fraction, report.RowCount)
+	for _, u := range wi.patterns {
+		if !wi.needs(u.Pattern, need) {
+			continue
+		}
+		_, cols, all := wi.planner.SampleColumns(u.Pattern)
+		if !planner.SampleCovers(columns, cols, all, wi.tableCols) {
+			continue
+		}
+		predicted := planner.SampleError(fraction, wi.matchingRows(u.Pattern))
+		wi.add(report, u, predicted, speedup)
+	}
+	return report, nil
+}
+
+// sketch predicts a sketch of kind on column built with parameters, as
+// POST /sketches/create reads them.
+func (wi *whatIf) sketch(ctx context.Context, h *Handler, kind storage.SketchType, column string, parameters map[string]any) (*WhatIfReport, error) {
+	need := planner.SynopsisNeed{Kind: string(kind), Column: strings.ToLower(column)}
+	report := wi.report(need)
+
+	distinct, known := wi.stats.Distinct[need.Column]
+	nonNull := float64(report.RowCount)
+	if c, ok := wi.stats.Columns[need.Column]; ok {
+		nonNull *= 1 - c.NullFraction
+	}
+	switch kind {
+	case storage.HyperLogLogType:
+		report.EstimatedBytes = int64(len(sketches.NewHyperLogLog(12).Serialize()))
+	case storage.CountMinSketchType:
+		epsilon, delta := 0.01, 0.01
+		if eps, ok := parameters["epsilon"].(float64); ok {
+			epsilon = eps
+		}
+		if d, ok := parameters["delta"].(float64); ok {
+			delta = d
+		}
+		report.EstimatedBytes = int64(len(sketches.NewCountMinSketch(epsilon, delta).Serialize()))
+		trackedKeys := int64(sketches.DefaultTrackedKeys)
+		if n, ok := parameters["max_tracked_keys"].(float64); ok {
+			trackedKeys = int64(n)
+		}
+		if trackedKeys > 0 {
+			widths, err := h.columnWidths(ctx, wi.table, []string{column})
+			if err != nil {
+				return nil, err
+			}
+			keys := trackedKeys
+			if known {
+				keys = min(keys, distinct)
+			}
+			// the key list: its limit, a truncation flag and a count, then
+			// each key behind its length
+			report.EstimatedBytes += 9 + int64(math.Ceil(float64(keys)*(4+widths[need.Column])))
+		}
+	case storage.TDigestType:
+		compression := 100.0
+		if c, ok := parameters["compression"].(float64); ok && c > 0 {
+			compression = c
+		}
+		// a digest of n values keeps about compression/2 · ln n centroids
+		// of 16 bytes each
+		centroids := nonNull
+		if nonNull > 1 {
+			centroids = min(nonNull, math.Ceil(compression/2*math.Log(nonNull)))
+		}
+		report.EstimatedBytes = int64(len(sketches.NewTDigest(compression).Serialize())) + 16*int64(centroids)
+	}
+	var groups int64
+	if known {
+		groups = distinct
+	}
+	report.EstimatedBuildMs = wi.planner.SynopsisBuildMs(need, report.RowCount, groups)
+
+	speedup := wi.planner.SynopsisSpeedup(need, report.RowCount)
+	for _, u := range wi.patterns {
+		if wi.needs(u.Pattern, need) {
+			wi.add(report, u, planner.SketchError(string(kind)), speedup)
+		}
+	}
+	return report, nil
+}
+
+func (wi *whatIf) report(need planner.SynopsisNeed) *WhatIfReport {
+	return &WhatIfReport{
+		Table:       wi.table,
+		Synopsis:    need,
+		RowCount:    wi.stats.RowCount,
+		TargetError: wi.target,
+		Patterns:    []WhatIfPattern{},
+	}
+}
+
+// needs reports whether the planner would consider need for pattern.
+func (wi *whatIf) needs(pattern string, need planner.SynopsisNeed) bool {
+	_, needs := wi.planner.NeededSynopses(pattern)
+	for _, n := range needs {
+		if n.Kind == need.Kind && strings.EqualFold(n.Column, need.Column) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchingRows is how many rows of the table pattern's WHERE clause keeps.
+func (wi *whatIf) matchingRows(pattern string) float64 {
+	return float64(wi.stats.RowCount) * wi.planner.Selectivity(pattern, wi.stats.Columns)
+}
+
+// currentError is the lowest error the table's synopses answer pattern
+// with today; ok is false when none can.
+func (wi *whatIf) currentError(pattern string) (best float64, ok bool) {
+	best = math.Inf(1)
+	_, needs := wi.planner.NeededSynopses(pattern)
+	for _, need := range needs {
+		if need.Kind != "sample" {
+			if wi.sketches[planner.SynopsisNeed{Kind: need.Kind, Column: strings.ToLower(need.Column)}] {
+				best = min(best, planner.SketchError(need.Kind))
+			}
+			continue
+		}
+		_, cols, all := wi.planner.SampleColumns(pattern)
+		for _, s := range wi.uniform {
+			if planner.SampleCovers(s.Columns, cols, all, wi.tableCols) {
+				best = min(best, planner.SampleError(s.Fraction, wi.matchingRows(pattern)))
+			}
+		}
+	}
+	return best, !math.IsInf(best, 1)
+}
+
+// add records that the proposal would answer u with predicted error.
+func (wi *whatIf) add(report *WhatIfReport, u ml.PatternUsage, predicted, speedup float64) {
+	wp := WhatIfPattern{Pattern: u.Pattern, Runs: u.Runs, ExactRuns: u.ExactRuns, PredictedError: predicted, MeetsTarget: predicted <= wi.target}
+	current, ok := wi.currentError(u.Pattern)
+	if ok {
+		wp.CurrentError = &current
+	}
+	if !ok || predicted < current {
+		report.RunsImproved += u.Runs
+	}
+	if wp.MeetsTarget && (!ok || current > wi.target) {
+		report.RunsNewlyApproximate += u.ExactRuns
+		report.EstimatedSavedMs += float64(u.ExactRuns) * u.AvgExecTime * (1 - 1/speedup)
+	}
+	report.Patterns = append(report.Patterns, wp)
+}
+
+// columnWidths averages the bytes each of columns takes, by lower-case
+// name, over the first widthProbeRows rows of table.
+func (h *Handler) columnWidths(ctx context.Context, table string, columns []string) (map[string]float64, error) {
+	rows, err := h.db.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s LIMIT %d", strings.Join(columns, ", "), table, widthProbeRows))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	values := make([]any, len(columns))
+	ptrs := make([]any, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	totals := make([]float64, len(columns))
+	n := 0
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		for i, v := range values {
+			switch v := v.(type) {
+			case nil:
+				totals[i]++
+			case []byte:
+				totals[i] += float64(len(v))
+			case string:
+				totals[i] += float64(len(v))
+			default:
+				totals[i] += 8
+			}
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	widths := make(map[string]float64, len(columns))
+	for i, c := range columns {
+		if n > 0 {
+			widths[strings.ToLower(c)] = totals[i] / float64(n)
+		}
+	}
+	return widths, nil
+}
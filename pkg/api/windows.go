@@ -0,0 +1,175 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// timeSpan is a span of the day, as offsets from local midnight; a span
+// whose end comes before its start runs past midnight.
+type timeSpan struct {
+	start, end time.Duration
+}
+
+// maintenanceHours is when heavy background work may run; no spans means
+// at any time.
+type maintenanceHours []timeSpan
+
+// parseMaintenanceHours reads spans of local time such as
+// "01:00-05:00,22:30-23:30"; "" is at any time.
+func parseMaintenanceHours(s string) (maintenanceHours, error) {
+	var hours maintenanceHours
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(part, "-")
+		start, err1 := parseClock(from)
+		end, err2 := parseClock(to)
+		if !ok || err1 != nil || err2 != nil || start == end {
+			return nil, fmt.Errorf("maintenance window %q: want spans like 01:00-05:00", part)
+		}
+		hours = append(hours, timeSpan{start, end})
+	}
+	return hours, nil
+}
+
+// parseClock reads HH:MM as an offset from midnight.
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// openAt reports whether hours include t and, if they do, when the span
+// holding t closes; closes is zero when hours are at any time.
+func (hours maintenanceHours) openAt(t time.Time) (open bool, closes time.Time) {
+	if len(hours) == 0 {
+		return true, time.Time{}
+	}
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	for _, span := range hours {
+		switch {
+		case span.start < span.end && offset >= span.start && offset < span.end:
+			return true, midnight.Add(span.end)
+		case span.start > span.end && offset >= span.start:
+			return true, midnight.AddDate(0, 0, 1).Add(span.end)
+		case span.start > span.end && offset < span.end:
+			return true, midnight.Add(span.end)
+		}
+	}
+	return false, time.Time{}
+}
+
+// maintenanceHoursFor returns table's registered window or, without one,
+// the server's (see Config.MaintenanceWindow), and which it is.
+func (h *Handler) maintenanceHoursFor(ctx context.Context, table string) (maintenanceHours, string, error) {
+	w, err := storage.GetMaintenanceWindow(ctx, h.db, table)
+	if err != nil {
+		return nil, "", err
+	}
+	if w != nil {
+		hours, err := parseMaintenanceHours(w.Hours)
+		return hours, w.Hours, err
+	}
+	hours, err := parseMaintenanceHours(h.config.MaintenanceWindow)
+	return hours, h.config.MaintenanceWindow, err
+}
+
+// MaintenanceWindowRequest sets when heavy background work on a table may
+// run:
+//
+//	{"hours": "01:00-05:00"}
+type MaintenanceWindowRequest struct {
+	Hours string `json:"hours"`
+}
+
+// PutMaintenanceWindow registers a table's maintenance window, replacing
+// any registered before.
+func (h *Handler) PutMaintenanceWindow(w http.ResponseWriter, r *http.Request) {
+	table := mux.Vars(r)["name"]
+	var req MaintenanceWindowRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "invalid json"})
+		return
+	}
+	hours, err := parseMaintenanceHours(req.Hours)
+	if err != nil || len(hours) == 0 {
+		writeJSON(w, http.StatusBadRequest, JSON{"error": "hours must list spans like 01:00-05:00"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	exists, err := storage.TableExists(ctx, h.db, table)
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	if !exists {
+		writeJSON(w, http.StatusNotFound, JSON{"error": "no such table: " + table})
+		return
+	}
+	if err := storage.SaveMaintenanceWindow(ctx, h.db, &storage.MaintenanceWindow{Table: table, Hours: req.Hours}); err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	h.writeMaintenanceWindow(w, r.WithContext(ctx), table)
+}
+
+// GetMaintenanceWindow reports a table's maintenance window, its own or
+// the server's, and whether it is open now.
+func (h *Handler) GetMaintenanceWindow(w http.ResponseWriter, r *http.Request) {
+	h.writeMaintenanceWindow(w, r, mux.Vars(r)["name"])
+}
+
+func (h *Handler) writeMaintenanceWindow(w http.ResponseWriter, r *http.Request, table string) {
+	own, err := storage.GetMaintenanceWindow(r.Context(), h.db, table)
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	hours, text, err := h.maintenanceHoursFor(r.Context(), table)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, JSON{"error": err.Error()})
+		return
+	}
+	resp := JSON{"status": "ok", "table": table, "hours": text, "source": "server"}
+	if own != nil {
+		resp["source"], resp["updated_at"] = "table", own.UpdatedAt
+	}
+	open, closes := hours.openAt(time.Now())
+	resp["open"] = open
+	if !closes.IsZero() {
+		resp["closes_at"] = closes.UTC()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// DeleteMaintenanceWindow removes a table's window; the server's applies
+// again.
+func (h *Handler) DeleteMaintenanceWindow(w http.ResponseWriter, r *http.Request) {
+	table := mux.Vars(r)["name"]
+	deleted, err := storage.DeleteMaintenanceWindow(r.Context(), h.db, table)
+	if err != nil {
+		writeJSON(w, errorStatus(err, http.StatusInternalServerError), JSON{"error": err.Error()})
+		return
+	}
+	if !deleted {
+		writeJSON(w, http.StatusNotFound, JSON{"error": "no maintenance window registered for " + table})
+		return
+	}
+	writeJSON(w, http.StatusOK, JSON{"status": "ok"})
+}
@@ -0,0 +1,51 @@
+// Package aqeerr defines the error taxonomy shared by the planner, sampler,
+// ml and api packages. Wrap these sentinels with fmt.Errorf("%w: ...") so
+// callers can branch with errors.Is.
+package aqeerr
+
+import "errors"
+
+var (
+	// ErrNoSample means no usable sample exists (or one came out empty).
+	ErrNoSample = errors.New("no usable sample")
+	// ErrToleranceUnreachable means the requested error bound cannot be met.
+	ErrToleranceUnreachable = errors.New("error tolerance unreachable")
+	// ErrUnsupportedQuery means the statement is outside what AQE can plan.
+	ErrUnsupportedQuery = errors.New("unsupported query")
+	// ErrStaleStats means table statistics are missing or out of date.
+	ErrStaleStats = errors.New("stale or missing table statistics")
+	// ErrUnknownProvenance means a sample table has no recorded origin, so
+	// its sampling fraction can't be trusted.
+	ErrUnknownProvenance = errors.New("unknown sample provenance")
+	// ErrTooManyGroups means a GROUP BY would return more groups than the
+	// configured maximum and has no LIMIT bounding them.
+	ErrTooManyGroups = errors.New("too many result groups")
+	// ErrQueryLimit means the statement has more joins, tables or nested
+	// expressions than the configured limits allow.
+	ErrQueryLimit = errors.New("query exceeds a limit")
+)
+
+// Category returns a stable short label for err, used for plan fallback
+// reasons and failure counters. Unknown errors map to "other".
+func Category(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrNoSample):
+		return "no_sample"
+	case errors.Is(err, ErrToleranceUnreachable):
+		return "tolerance_unreachable"
+	case errors.Is(err, ErrUnsupportedQuery):
+		return "unsupported_query"
+	case errors.Is(err, ErrStaleStats):
+		return "stale_stats"
+	case errors.Is(err, ErrUnknownProvenance):
+		return "unknown_provenance"
+	case errors.Is(err, ErrTooManyGroups):
+		return "too_many_groups"
+	case errors.Is(err, ErrQueryLimit):
+		return "query_limit"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,180 @@
+package estimator
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Statistic selects which aggregate a bootstrap workspace resamples.
+type Statistic int
+
+const (
+	StatSum Statistic = iota
+	StatMean
+)
+
+// Bootstrap replicate counts. DefaultBootstrapIterations is used when no
+// error tolerance is known; adaptive runs start with a pilot of
+// MinBootstrapIterations and never exceed MaxBootstrapIterations.
+const (
+	DefaultBootstrapIterations = 300
+	MinBootstrapIterations     = 50
+	MaxBootstrapIterations     = 2000
+)
+
+// bootstrapNoiseShare is the share of the error tolerance the bootstrap's
+// own Monte Carlo noise may take up.
+const bootstrapNoiseShare = 0.1
+
+// BootstrapWorkspace resamples float64 columns without per-iteration
+// allocations. Reuse one workspace for all columns of a result.
+type BootstrapWorkspace struct {
+	ests []float64
+	rng  *rand.Rand
+}
+
+// NewBootstrapWorkspace preallocates room for B bootstrap estimates.
+func NewBootstrapWorkspace(B int) *BootstrapWorkspace {
+	if B < 2 {
+		B = 2
+	}
+	return &BootstrapWorkspace{
+		ests: make([]float64, B),
+		rng:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Iterations returns the most bootstrap replicates a CI can use.
+func (ws *BootstrapWorkspace) Iterations() int {
+	return len(ws.ests)
+}
+
+// CI computes a percentile bootstrap CI for stat over values, scaled by scale,
+// using every replicate the workspace holds.
+func (ws *BootstrapWorkspace) CI(values []float64, stat Statistic, scale float64, confidence float64) CIResult {
+	if len(values) == 0 {
+		return CIResult{}
+	}
+	ws.resample(values, stat, scale, ws.ests)
+	return summarizeBootstrap(ws.ests, statistic(values, stat, scale), scale, confidence)
+}
+
+// AdaptiveCI is CI with the replicate count chosen from the tolerance: a
+// pilot of MinBootstrapIterations estimates the relative standard error,
+// AdaptiveIterations turns it into B, and the pilot replicates are kept as
+// the first of the B. It also returns the B used, which the workspace's
+// capacity bounds.
+func (ws *BootstrapWorkspace) AdaptiveCI(values []float64, stat Statistic, scale, confidence, maxRelError float64) (CIResult, int) {
+	if len(values) == 0 {
+		return CIResult{}, 0
+	}
+	original := statistic(values, stat, scale)
+
+	pilot := min(MinBootstrapIterations, len(ws.ests))
+	ws.resample(values, stat, scale, ws.ests[:pilot])
+	ci := summarizeBootstrap(ws.ests[:pilot], original, scale, confidence)
+
+	B := min(AdaptiveIterations(ci.RelativeError, maxRelError, confidence), len(ws.ests))
+	if B <= pilot {
+		return ci, pilot
+	}
+	ws.resample(values, stat, scale, ws.ests[pilot:B])
+	return summarizeBootstrap(ws.ests[:B], original, scale, confidence), B
+}
+
+// AdaptiveIterations is the bootstrap replicate count for an estimate with
+// relative standard error relErr under tolerance maxRelError. The interval
+// endpoints carry Monte Carlo noise of about z·SE/√(2B); B is the smallest
+// count keeping that within a tenth of the tolerance, clamped to
+// [MinBootstrapIterations, MaxBootstrapIterations]. Without a tolerance it
+// is DefaultBootstrapIterations.
+func AdaptiveIterations(relErr, maxRelError, confidence float64) int {
+	if maxRelError <= 0 || math.IsNaN(relErr) {
+		return DefaultBootstrapIterations
+	}
+	if math.IsInf(relErr, 0) {
+		return MaxBootstrapIterations
+	}
+	ratio := ZScore(confidence) * relErr / (bootstrapNoiseShare * maxRelError)
+	B := math.Ceil(ratio * ratio / 2)
+	switch {
+	case B < MinBootstrapIterations:
+		return MinBootstrapIterations
+	case B > MaxBootstrapIterations:
+		return MaxBootstrapIterations
+	}
+	return int(B)
+}
+
+// resample fills dst with bootstrap replicates of stat over values.
+// Resamples are accumulated directly rather than materialized.
+func (ws *BootstrapWorkspace) resample(values []float64, stat Statistic, scale float64, dst []float64) {
+	n := len(values)
+	for i := range dst {
+		sum := 0.0
+		for j := 0; j < n; j++ {
+			sum += values[ws.rng.Intn(n)]
+		}
+		if stat == StatMean {
+			sum /= float64(n)
+		}
+		dst[i] = sum * scale
+	}
+}
+
+func statistic(values []float64, stat Statistic, scale float64) float64 {
+	v := sumFloats(values)
+	if stat == StatMean {
+		v /= float64(len(values))
+	}
+	return v * scale
+}
+
+func sumFloats(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum
+}
+
+// summarizeBootstrap sorts ests in place and derives percentile bounds and SE.
+func summarizeBootstrap(ests []float64, original, scale, confidence float64) CIResult {
+	B := len(ests)
+	sort.Float64s(ests)
+
+	alpha := 1.0 - confidence
+	lowerIdx := int(math.Floor(float64(B) * alpha / 2.0))
+	upperIdx := int(math.Ceil(float64(B)*(1.0-alpha/2.0))) - 1
+	if lowerIdx < 0 {
+		lowerIdx = 0
+	}
+	if upperIdx >= B {
+		upperIdx = B - 1
+	}
+
+	mean := sumFloats(ests) / float64(B)
+	variance := 0.0
+	for _, est := range ests {
+		variance += (est - mean) * (est - mean)
+	}
+	variance /= float64(B - 1)
+	stdErr := math.Sqrt(variance)
+
+	relErr := 0.0
+	if original != 0 {
+		relErr = stdErr / math.Abs(original)
+	}
+
+	return CIResult{
+		Estimate:        original,
+		StdError:        stdErr,
+		ConfidenceLevel: confidence,
+		Lower:           ests[lowerIdx],
+		Upper:           ests[upperIdx],
+		SampleFraction:  1.0 / scale,
+		RelativeError:   relErr,
+	}
+}
@@ -20,7 +20,7 @@ type CIResult struct {
 
 // ZScore returns z for a two-sided confidence level (e.g., 0.95 -> ~1.96).
 func ZScore(confidence float64) float64 {
-    // Simple mapping for common levels; could replace with inverse CDF if needed.
+    // Exact constants for common levels, the inverse normal CDF otherwise.
     switch {
     case math.Abs(confidence-0.90) < 1e-9:
         return 1.6448536269514722
@@ -28,12 +28,36 @@ func ZScore(confidence float64) float64 {
         return 1.959963984540054
     case math.Abs(confidence-0.99) < 1e-9:
         return 2.5758293035489004
+    case confidence > 0 && confidence < 1:
+        return math.Sqrt2 * math.Erfinv(confidence)
     default:
         // default to 95%
         return 1.959963984540054
     }
 }
 
+// NormalCDF is the standard normal cumulative distribution function.
+func NormalCDF(x float64) float64 {
+    return 0.5 * math.Erfc(-x/math.Sqrt2)
+}
+
+// ProbGreater returns P(A > B) for independent, approximately normal
+// estimates a and b with standard errors seA and seB. With no uncertainty
+// on either side it is 1, 0 or 0.5.
+func ProbGreater(a, seA, b, seB float64) float64 {
+    se := math.Sqrt(seA*seA + seB*seB)
+    if se == 0 {
+        switch {
+        case a > b:
+            return 1
+        case a < b:
+            return 0
+        }
+        return 0.5
+    }
+    return NormalCDF((a - b) / se)
+}
+
 // SumCI computes an analytic CI for a sum estimate scaled from a uniform sample.
 // sum_hat = sum_sample / f ; Var(sum_hat) = Var(sum_sample) / f^2
 // We approximate Var(sum_sample) via sample variance of contributing values.
@@ -65,6 +89,36 @@ func CountCI(countSample int64, f float64, confidence float64) CIResult {
     return CIResult{Estimate: est, StdError: se, ConfidenceLevel: confidence, Lower: low, Upper: high, SampleFraction: f, RelativeError: rel}
 }
 
+// TotalCI computes an analytic CI for a sum over a Bernoulli sample of
+// fraction f from the sample's sum and sum of squares, with the
+// Horvitz-Thompson variance estimate (1-f)/f^2 * sum(x^2). Unlike SumCI it
+// needs no sample size, so it holds for a group whose size is itself random.
+func TotalCI(sumSample, sumSquaresSample float64, f float64, confidence float64) CIResult {
+    est := sumSample / f
+    se := math.Sqrt(math.Max(0, (1-f)*sumSquaresSample)) / f
+    z := ZScore(confidence)
+    rel := 0.0
+    if est != 0 { rel = se / math.Abs(est) }
+    return CIResult{Estimate: est, StdError: se, ConfidenceLevel: confidence, Lower: est - z*se, Upper: est + z*se, SampleFraction: f, RelativeError: rel}
+}
+
+// MeanCI computes an analytic CI for a mean over nSample sampled values
+// from their sum and sum of squares, with the finite population correction
+// for fraction f. It needs at least two values.
+func MeanCI(nSample int64, sumSample, sumSquaresSample float64, f float64, confidence float64) (CIResult, bool) {
+    if nSample < 2 {
+        return CIResult{}, false
+    }
+    n := float64(nSample)
+    est := sumSample / n
+    variance := math.Max(0, (sumSquaresSample-n*est*est)/(n-1))
+    se := math.Sqrt(variance / n * (1 - f))
+    z := ZScore(confidence)
+    rel := 0.0
+    if est != 0 { rel = se / math.Abs(est) }
+    return CIResult{Estimate: est, StdError: se, ConfidenceLevel: confidence, Lower: est - z*se, Upper: est + z*se, SampleFraction: f, RelativeError: rel}, true
+}
+
 // BootstrapCI computes bootstrap confidence intervals for a scaled estimate.
 // values: sample values contributing to the estimate
 // scaleFunc: function to compute the estimate from resampled values (e.g., sum, mean)
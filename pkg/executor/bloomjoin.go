@@ -0,0 +1,80 @@
+package executor
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"sync"
+
+	"modernc.org/sqlite"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/sketches"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// bloomFilters holds the Bloom filters bloom join plans have loaded, by
+// bloomFilterKey. A newer load replaces the filter; a loaded filter is
+// never changed, so statements reading it need no lock.
+var bloomFilters sync.Map
+
+// SQLite registers functions on connections opened after the call, so the
+// filter function is registered before any are.
+func init() {
+	sqlite.MustRegisterScalarFunction(planner.BloomFunction, 3, bloomContains)
+}
+
+func bloomFilterKey(table, column string) string {
+	return strings.ToLower(table) + "." + strings.ToLower(column)
+}
+
+// bloomContains implements planner.BloomFunction: aqe_bloom(table, column,
+// key). A NULL key never joins; with no filter loaded every key passes.
+func bloomContains(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+	table, _ := args[0].(string)
+	column, _ := args[1].(string)
+	f, ok := bloomFilters.Load(bloomFilterKey(table, column))
+	if !ok {
+		return int64(1), nil
+	}
+	if f.(*sketches.BloomFilter).ContainsValue(args[2]) {
+		return int64(1), nil
+	}
+	return int64(0), nil
+}
+
+// loadBloomJoin loads the Bloom filter plan j reads its probed table
+// through and returns the SQL to run: j's, or sqlText, the plan's own,
+// when the filter is gone or was degraded since planning. Either answers
+// exactly; meta records which ran.
+func loadBloomJoin(ctx context.Context, db storage.Queryer, j *planner.BloomJoin, sqlText string) (string, map[string]any) {
+	meta := map[string]any{
+		"table":               j.Table,
+		"column":              j.Column,
+		"probe":               j.Probe,
+		"probe_key":           j.ProbeKey,
+		"estimated_pass_rate": j.PassRate,
+	}
+	var data []byte
+	err := db.QueryRowContext(ctx, `SELECT sketch_data FROM aqe_sketches
+		WHERE table_name = ? AND column_name = ? COLLATE NOCASE AND sketch_type = ? AND COALESCE(degraded, 0) = 0`,
+		j.Table, j.Column, string(storage.BloomFilterType)).Scan(&data)
+	var bf *sketches.BloomFilter
+	if err == nil {
+		err = reserveSketch(ctx, data)
+	}
+	if err == nil {
+		bf, err = sketches.DeserializeBloomFilter(data)
+	}
+	if err != nil {
+		meta["applied"] = false
+		meta["reason"] = fmt.Sprintf("loading Bloom filter on %s.%s: %v", j.Table, j.Column, err)
+		return sqlText, meta
+	}
+	bloomFilters.Store(bloomFilterKey(j.Table, j.Column), bf)
+	meta["applied"] = true
+	meta["filter_keys"] = bf.Items()
+	meta["filter_fpr"] = bf.EstimatedFPR()
+	return j.SQL, meta
+}
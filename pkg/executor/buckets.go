@@ -0,0 +1,152 @@
+package executor
+
+import (
+	"math"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/estimator"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+)
+
+// minBucketRows is the sample support below which a time bucket's interval
+// is considered unreliable and reported as sparse.
+const minBucketRows = 30
+
+// enrichWithBucketCIs attaches a confidence interval at plan's confidence
+// level to every row of a time-bucketed sample result, using that bucket's
+// own sample row count rather than one interval for the whole column.
+// COUNT columns use the binomial interval; the other scaled columns carry
+// the same relative error, which treats bucket membership as the dominant
+// source of variance. Other aggregates get no interval. It returns the
+// number of sparse buckets.
+func enrichWithBucketCIs(budget *MemoryBudget, results *ResultSet, bucketRows *Column, plan *planner.Plan, scaled []string) (int, error) {
+	sampleFraction, confidence := plan.SampleFraction, plan.Level()
+	n := results.Len()
+	sparse := 0
+	for i := 0; i < n; i++ {
+		if c, ok := bucketRows.Float(i); !ok || c < minBucketRows {
+			sparse++
+		}
+	}
+
+	for _, col := range scaled {
+		c := results.Column(col)
+		if c == nil {
+			continue
+		}
+
+		if err := budget.Reserve(int64(3*n)*8, "bucket intervals"); err != nil {
+			return 0, err
+		}
+		low := make([]float64, n)
+		high := make([]float64, n)
+		rel := make([]float64, n)
+		nulls := make([]bool, n)
+
+		for i := 0; i < n; i++ {
+			est, ok := c.Float(i)
+			rows, hasRows := bucketRows.Float(i)
+			if !ok || !hasRows || rows <= 0 {
+				nulls[i] = true
+				continue
+			}
+			ci := estimator.CountCI(int64(rows), sampleFraction, confidence)
+			spread := math.Abs(est) * ci.RelativeError * estimator.ZScore(confidence)
+			low[i], high[i], rel[i] = est-spread, est+spread, ci.RelativeError
+		}
+
+		results.SetFloats(col+"_ci_low", low, nulls)
+		results.SetFloats(col+"_ci_high", high, append([]bool(nil), nulls...))
+		results.SetFloats(col+"_rel_error", rel, append([]bool(nil), nulls...))
+	}
+
+	return sparse, nil
+}
+
+// enrichWithGroupCIs attaches a confidence interval at plan's confidence
+// level to every row of a grouped sample result from that group's own
+// sample moments, which the planner adds as extra columns; they are
+// dropped here. COUNT columns use the binomial interval, SUM and TOTAL the
+// Horvitz-Thompson variance of the group's sum of squares, and AVG the
+// group's sample variance. Other aggregates get no interval. Row i was
+// sampled at fractions[i] when fractions is given, at the plan's fraction
+// otherwise. It returns the number of groups with fewer than minBucketRows
+// sample rows.
+func enrichWithGroupCIs(budget *MemoryBudget, results *ResultSet, groupRows *Column, plan *planner.Plan, fractions []float64) (int, error) {
+	confidence := plan.Level()
+	sampleFraction := func(i int) float64 {
+		if fractions != nil {
+			return fractions[i]
+		}
+		return plan.SampleFraction
+	}
+	n := results.Len()
+	sparse := 0
+	for i := 0; i < n; i++ {
+		if c, ok := groupRows.Float(i); !ok || c < minBucketRows {
+			sparse++
+		}
+	}
+
+	for k, agg := range plan.Aggregates {
+		sumSquares := results.RemoveColumn(planner.SumSquaresColumn(k))
+		valueRows := results.RemoveColumn(planner.ValueRowsColumn(k))
+		c := results.Column(agg.Column)
+		if c == nil {
+			continue
+		}
+
+		var ci func(i int, est float64) (estimator.CIResult, bool)
+		switch {
+		case agg.Function == "COUNT" && agg.Scaled:
+			ci = func(i int, est float64) (estimator.CIResult, bool) {
+				f := sampleFraction(i)
+				return estimator.CountCI(int64(math.Round(est*f)), f, confidence), true
+			}
+		case (agg.Function == "SUM" || agg.Function == "TOTAL") && agg.Scaled && sumSquares != nil:
+			ci = func(i int, est float64) (estimator.CIResult, bool) {
+				sq, ok := sumSquares.Float(i)
+				f := sampleFraction(i)
+				return estimator.TotalCI(est*f, sq, f, confidence), ok
+			}
+		case agg.Function == "AVG" && sumSquares != nil && valueRows != nil:
+			ci = func(i int, est float64) (estimator.CIResult, bool) {
+				sq, ok := sumSquares.Float(i)
+				rows, hasRows := valueRows.Float(i)
+				if !ok || !hasRows {
+					return estimator.CIResult{}, false
+				}
+				return estimator.MeanCI(int64(rows), est*rows, sq, sampleFraction(i), confidence)
+			}
+		default:
+			continue
+		}
+
+		if err := budget.Reserve(int64(3*n)*8, "group intervals"); err != nil {
+			return 0, err
+		}
+		low := make([]float64, n)
+		high := make([]float64, n)
+		rel := make([]float64, n)
+		nulls := make([]bool, n)
+
+		for i := 0; i < n; i++ {
+			est, ok := c.Float(i)
+			if !ok {
+				nulls[i] = true
+				continue
+			}
+			r, ok := ci(i, est)
+			if !ok {
+				nulls[i] = true
+				continue
+			}
+			low[i], high[i], rel[i] = r.Lower, r.Upper, r.RelativeError
+		}
+
+		results.SetFloats(agg.Column+"_ci_low", low, nulls)
+		results.SetFloats(agg.Column+"_ci_high", high, append([]bool(nil), nulls...))
+		results.SetFloats(agg.Column+"_rel_error", rel, append([]bool(nil), nulls...))
+	}
+
+	return sparse, nil
+}
@@ -0,0 +1,194 @@
+package executor
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+	"unicode/utf8"
+)
+
+// flushThreshold is how much encoded output WriteJSON buffers before writing.
+const flushThreshold = 32 << 10
+
+// WriteJSON streams the result as a JSON array of row objects to w, reusing a
+// single scratch buffer. NaN and ±Inf are written as null.
+func (rs *ResultSet) WriteJSON(w io.Writer) error {
+	if rs == nil {
+		_, err := w.Write([]byte("null"))
+		return err
+	}
+
+	keys := rs.encodedKeys()
+	buf := make([]byte, 0, flushThreshold+4096)
+	buf = append(buf, '[')
+	for i := 0; i < rs.rows; i++ {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		var err error
+		if buf, err = rs.appendRow(buf, keys, i); err != nil {
+			return err
+		}
+		if len(buf) >= flushThreshold {
+			if _, err := w.Write(buf); err != nil {
+				return err
+			}
+			buf = buf[:0]
+		}
+	}
+	buf = append(buf, ']')
+	_, err := w.Write(buf)
+	return err
+}
+
+// AppendJSON appends the encoded result to dst.
+func (rs *ResultSet) AppendJSON(dst []byte) ([]byte, error) {
+	if rs == nil {
+		return append(dst, "null"...), nil
+	}
+	keys := rs.encodedKeys()
+	dst = append(dst, '[')
+	for i := 0; i < rs.rows; i++ {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		var err error
+		if dst, err = rs.appendRow(dst, keys, i); err != nil {
+			return nil, err
+		}
+	}
+	return append(dst, ']'), nil
+}
+
+// estimatedJSONSize is a rough pre-sizing hint for AppendJSON.
+func (rs *ResultSet) estimatedJSONSize() int {
+	perRow := 2
+	for _, c := range rs.Columns {
+		perRow += len(c.Name) + 24
+	}
+	return 2 + rs.rows*perRow
+}
+
+func (rs *ResultSet) encodedKeys() [][]byte {
+	keys := make([][]byte, len(rs.Columns))
+	for j, c := range rs.Columns {
+		k := appendJSONString(nil, c.Name)
+		keys[j] = append(k, ':')
+	}
+	return keys
+}
+
+func (rs *ResultSet) appendRow(dst []byte, keys [][]byte, i int) ([]byte, error) {
+	dst = append(dst, '{')
+	for j, c := range rs.Columns {
+		if j > 0 {
+			dst = append(dst, ',')
+		}
+		dst = append(dst, keys[j]...)
+		if c.Nulls[i] {
+			dst = append(dst, "null"...)
+			continue
+		}
+		switch c.Kind {
+		case KindInt:
+			dst = strconv.AppendInt(dst, c.Ints[i], 10)
+		case KindFloat:
+			dst = appendJSONFloat(dst, c.Floats[i])
+		case KindString:
+			dst = appendJSONString(dst, c.Strings[i])
+		case KindBytes:
+			dst = append(dst, '"')
+			dst = base64.StdEncoding.AppendEncode(dst, c.Bytes[i])
+			dst = append(dst, '"')
+		default:
+			switch v := c.Mixed[i].(type) {
+			case float64:
+				dst = appendJSONFloat(dst, v)
+			case int64:
+				dst = strconv.AppendInt(dst, v, 10)
+			case string:
+				dst = appendJSONString(dst, v)
+			default:
+				b, err := json.Marshal(v)
+				if err != nil {
+					return nil, err
+				}
+				dst = append(dst, b...)
+			}
+		}
+	}
+	return append(dst, '}'), nil
+}
+
+// appendJSONFloat formats f the way encoding/json does.
+func appendJSONFloat(dst []byte, f float64) []byte {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return append(dst, "null"...)
+	}
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	dst = strconv.AppendFloat(dst, f, format, -1, 64)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		n := len(dst)
+		if n >= 4 && dst[n-4] == 'e' && dst[n-3] == '-' && dst[n-2] == '0' {
+			dst[n-2] = dst[n-1]
+			dst = dst[:n-1]
+		}
+	}
+	return dst
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendJSONString quotes s as a JSON string, replacing invalid UTF-8.
+func appendJSONString(dst []byte, s string) []byte {
+	dst = append(dst, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' {
+				i++
+				continue
+			}
+			dst = append(dst, s[start:i]...)
+			switch b {
+			case '"', '\\':
+				dst = append(dst, '\\', b)
+			case '\n':
+				dst = append(dst, '\\', 'n')
+			case '\r':
+				dst = append(dst, '\\', 'r')
+			case '\t':
+				dst = append(dst, '\\', 't')
+			default:
+				dst = append(dst, '\\', 'u', '0', '0', hexDigits[b>>4], hexDigits[b&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, `\ufffd`...)
+			i += size
+			start = i
+			continue
+		}
+		if r == '\u2028' || r == '\u2029' {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, '\\', 'u', '2', '0', '2', hexDigits[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	dst = append(dst, s[start:]...)
+	return append(dst, '"')
+}
@@ -0,0 +1,42 @@
+package executor
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"testing"
+)
+
+// benchResult is a 100k-row result of the shape grouped queries return: a
+// string key, an integer count and a float estimate.
+func benchResult() *ResultSet {
+	rs := NewResultSet([]string{"region", "orders", "revenue"})
+	for i := 0; i < 100_000; i++ {
+		rs.AppendRow([]any{"region-" + strconv.Itoa(i), int64(i), float64(i) * 1.25})
+	}
+	return rs
+}
+
+func BenchmarkWriteJSON(b *testing.B) {
+	rs := benchResult()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := rs.WriteJSON(io.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkEncodingJSON encodes the same rows as maps through
+// encoding/json, as /query did before WriteJSON.
+func BenchmarkEncodingJSON(b *testing.B) {
+	rs := benchResult()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := json.NewEncoder(io.Discard).Encode(rs.Rows()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -3,15 +3,88 @@ package executor
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"strconv"
-	"strings"
 
 	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/estimator"
 	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
 )
 
-func Execute(ctx context.Context, db *sql.DB, plan *planner.Plan) ([]map[string]any, map[string]any, error) {
-	rows, err := db.QueryContext(ctx, plan.SQL)
+// Execute runs plan on db, which may be a snapshot transaction, scaling and
+// bounding sample results. HyperLogLog plans for a scalar COUNT(DISTINCT)
+// and Count-Min plans for per-group COUNT(*) are answered from the stored
+// sketch instead of running SQL, the latter only when it tracks its keys;
+// t-digest plans answer a scalar MEDIAN or PERCENTILE the same way.
+// Sketch join plans read a sample and probe the other side's sketch,
+// wander join plans walk random paths through both tables, and exact plans
+// with a Bloom join read the larger side through the smaller's filter.
+// On a *sql.DB the run gets a connection of its own, whose statement is
+// interrupted the moment ctx is done (see storage.InterruptOnDone).
+func Execute(ctx context.Context, db storage.Queryer, plan *planner.Plan) (*ResultSet, map[string]any, error) {
+	pool, ok := db.(*sql.DB)
+	if !ok {
+		return execute(ctx, db, plan)
+	}
+	conn, err := pool.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+	stop, err := storage.InterruptOnDone(ctx, conn)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer stop()
+	res, meta, err := execute(ctx, conn, plan)
+	return res, meta, interrupted(ctx, err)
+}
+
+// interrupted reports a statement interrupted because ctx is done as the
+// context's error, as the driver does for one cancelled before it ran.
+func interrupted(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil && !errors.Is(err, ctx.Err()) {
+		return fmt.Errorf("%w: %v", ctx.Err(), err)
+	}
+	return err
+}
+
+func execute(ctx context.Context, db storage.Queryer, plan *planner.Plan) (*ResultSet, map[string]any, error) {
+	if plan.Type == planner.PlanSketch && plan.SketchJoin != nil {
+		return executeSketchJoin(ctx, db, plan)
+	}
+	if plan.Type == planner.PlanSample && plan.WanderJoin != nil {
+		return executeWanderJoin(ctx, db, plan)
+	}
+	if plan.Type == planner.PlanSketch && plan.SketchType == "hyperloglog" {
+		if sd, ok := planner.ParseScalarDistinct(plan.SQL); ok {
+			return executeDistinctSketch(ctx, db, plan, sd)
+		}
+	}
+	if plan.Type == planner.PlanSketch && plan.SketchType == "tdigest" {
+		if sq, ok := planner.ParseScalarQuantile(plan.SQL); ok {
+			return executeQuantileSketch(ctx, db, plan, sq)
+		}
+	}
+	var sketchFallback string
+	if plan.Type == planner.PlanSketch && plan.SketchType == "countmin" {
+		if g, ok := planner.ParseGroupCount(plan.SQL); ok {
+			res, meta, err := executeGroupCountSketch(ctx, db, plan, g)
+			if !errors.Is(err, errUntrackedKeys) {
+				return res, meta, err
+			}
+			sketchFallback = err.Error()
+		}
+	}
+
+	sqlText := plan.SQL
+	var bloomMeta map[string]any
+	if plan.Type == planner.PlanExact && plan.BloomJoin != nil {
+		sqlText, bloomMeta = loadBloomJoin(ctx, db, plan.BloomJoin, plan.SQL)
+	}
+
+	rows, err := db.QueryContext(ctx, sqlText)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -22,56 +95,130 @@ func Execute(ctx context.Context, db *sql.DB, plan *planner.Plan) ([]map[string]
 		return nil, nil, err
 	}
 
-	res := make([]map[string]any, 0, 64)
-	var sampleData map[string][]float64
+	budget := MemoryBudgetFromContext(ctx)
+	res := NewResultSet(cols)
 
-	if plan.Type == planner.PlanSample {
-		sampleData = make(map[string][]float64)
-		for _, col := range cols {
-			sampleData[col] = make([]float64, 0)
-		}
+	vals := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
 	}
 
 	for rows.Next() {
-		vals := make([]any, len(cols))
-		ptrs := make([]any, len(cols))
-		for i := range vals {
-			ptrs[i] = &vals[i]
-		}
 		if err := rows.Scan(ptrs...); err != nil {
 			return nil, nil, err
 		}
 
-		m := map[string]any{}
-		for i, c := range cols {
-			m[c] = vals[i]
-
-			if plan.Type == planner.PlanSample {
-				if val, ok := convertToFloat64(vals[i]); ok {
-					sampleData[c] = append(sampleData[c], val)
-				}
-			}
+		if err := budget.Reserve(estimateRowBytes(vals), "result buffer"); err != nil {
+			return nil, nil, err
 		}
-		res = append(res, m)
+
+		res.AppendRow(vals)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
 	}
 
 	meta := map[string]any{
 		"plan_type":    string(plan.Type),
 		"reason":       plan.Reason,
-		"rows":         len(res),
-		"sql_executed": plan.SQL,
+		"rows":         res.Len(),
+		"sql_executed": sqlText,
+	}
+	if bloomMeta != nil {
+		meta["bloom_join"] = bloomMeta
+	}
+	annotatePlanMeta(meta, plan)
+	prov := annotateProvenance(ctx, db, meta, plan)
+	if sketchFallback != "" {
+		meta["sketch_fallback"] = sketchFallback
 	}
 
 	if plan.Type == planner.PlanSample {
 		meta["sample_fraction"] = plan.SampleFraction
 		meta["sample_table"] = plan.SampleTable
+		if plan.JoinSampleTable != "" {
+			meta["join_sample_table"] = plan.JoinSampleTable
+		}
+		if plan.JoinSynopsis != "" {
+			meta["join_synopsis"] = plan.JoinSynopsis
+		}
 
-		if len(res) > 0 {
-			scaleSampleResults(res, plan.SampleFraction, cols)
-			enrichWithBootstrapCIs(res, sampleData, plan.SampleFraction, cols)
+		// only the columns the planner marked as sums and counts scale
+		scaled := plan.ScaledColumns()
+		if bucketRows := res.RemoveColumn(planner.BucketRowsColumn); bucketRows != nil {
+			// time-bucketed GROUP BY: scale and bound each bucket on its own
+			scaleSampleResults(res, plan.SampleFraction, scaled)
+			sparse, err := enrichWithBucketCIs(budget, res, bucketRows, plan, scaled)
+			if err != nil {
+				return nil, nil, err
+			}
+			meta["time_buckets"] = plan.TimeBuckets
+			meta["sparse_buckets"] = sparse
+		} else if groupRows := res.RemoveColumn(planner.GroupRowsColumn); groupRows != nil {
+			// aggregates: bound each group, or the one row of an ungrouped
+			// query, from its own sample moments
+			fractions := strataFractions(res, plan)
+			if fractions != nil {
+				scaleStrata(res, fractions, scaled)
+				meta["strata_scaled"] = true
+			} else {
+				scaleSampleResults(res, plan.SampleFraction, scaled)
+			}
+			sparse, err := enrichWithGroupCIs(budget, res, groupRows, plan, fractions)
+			if err != nil {
+				return nil, nil, err
+			}
+			meta["sparse_groups"] = sparse
+		} else if res.Len() > 1 && len(plan.Aggregates) == 0 {
+			// the rows are sample rows: bootstrap resamples their unscaled
+			// values. Aggregate rows are not, and resampling them would
+			// only bound the spread of the answer's own groups.
+			sampleData := make(map[string][]float64, len(cols))
+			for _, col := range res.Columns {
+				sampleData[col.Name] = col.NumericValues()
+			}
+			scaleSampleResults(res, plan.SampleFraction, scaled)
+			iterations, err := enrichWithBootstrapCIs(budget, res, sampleData, plan, cols)
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(iterations) > 0 {
+				meta["bootstrap_iterations"] = iterations
+			}
+		}
+		if corr := correctStaleSample(res, prov, scaled); corr != nil {
+			meta["stale_correction"] = corr
+		}
+		if plan.OutlierSQL != "" {
+			// the outliers are exact: added after scaling and correcting
+			added, err := addOutliers(ctx, db, budget, res, plan)
+			if err != nil {
+				return nil, nil, err
+			}
+			meta["outlier_table"] = plan.OutlierTable
+			if added > 0 {
+				meta["outlier_groups"] = added
+				meta["rows"] = res.Len()
+			}
+		}
+		if len(plan.Order) > 0 {
+			overlaps, rank := reorderGroups(res, plan)
+			meta["order_reapplied"] = true
+			meta["rows"] = res.Len()
+			if overlaps > 0 {
+				meta["order_overlaps"] = overlaps
+			}
+			if rank != nil {
+				meta["top_n_rank"] = rank
+			}
 		}
 	}
 
+	if budget != nil {
+		meta["memory_bytes"] = budget.Used()
+	}
+
 	return res, meta, nil
 }
 
@@ -95,38 +242,86 @@ func convertToFloat64(val any) (float64, bool) {
 	return 0, false
 }
 
-func scaleSampleResults(results []map[string]any, sampleFraction float64, cols []string) {
-	if sampleFraction <= 0 || len(results) == 0 || len(cols) == 0 {
+// scaleSampleResults scales cols, the plan's scaled aggregate columns, by
+// the inverse of the sample fraction.
+func scaleSampleResults(results *ResultSet, sampleFraction float64, cols []string) {
+	if sampleFraction <= 0 || results.Len() == 0 || len(cols) == 0 {
 		return
 	}
 
 	scale := 1.0 / sampleFraction
 
-	for i := range results {
-		for _, col := range cols {
-			val, exists := results[i][col]
-			if !exists {
-				continue
-			}
+	for _, col := range cols {
+		if c := results.Column(col); c != nil {
+			c.ScaleNumeric(scale)
+		}
+	}
+}
 
-			colUpper := strings.ToUpper(col)
-			needsScaling := strings.Contains(colUpper, "COUNT") ||
-				strings.Contains(colUpper, "SUM") ||
-				strings.Contains(colUpper, "TOTAL") ||
-				strings.Contains(colUpper, "REVENUE")
+// strataFractions is the sample fraction of every row of a result grouped
+// by the strata column of plan's sample, read from its stratum's value,
+// or nil when plan scales every row alike. Rows of a stratum the sample
+// has no record of get its overall fraction.
+func strataFractions(results *ResultSet, plan *planner.Plan) []float64 {
+	if plan.StrataFractions == nil {
+		return nil
+	}
+	key := results.Column(plan.StrataOutput)
+	if key == nil {
+		return nil
+	}
+	fractions := make([]float64, results.Len())
+	for i := range fractions {
+		var value string
+		switch v := key.Value(i).(type) {
+		case nil:
+		case []byte:
+			value = string(v)
+		default:
+			value = fmt.Sprint(v)
+		}
+		f, ok := plan.StrataFractions[value]
+		if !ok || f <= 0 {
+			f = plan.SampleFraction
+		}
+		fractions[i] = f
+	}
+	return fractions
+}
 
-			if needsScaling {
-				if numVal, ok := convertToFloat64(val); ok {
-					results[i][col] = numVal * scale
-				}
-			}
+// scaleStrata scales row i of cols, the plan's scaled aggregate columns,
+// by the inverse of fractions[i].
+func scaleStrata(results *ResultSet, fractions []float64, cols []string) {
+	for _, col := range cols {
+		c := results.Column(col)
+		if c == nil {
+			continue
 		}
+		vals := make([]float64, c.Len())
+		nulls := make([]bool, c.Len())
+		for i := range vals {
+			v, ok := c.Float(i)
+			vals[i], nulls[i] = v/fractions[i], !ok
+		}
+		results.SetFloats(col, vals, nulls)
 	}
 }
 
-func enrichWithBootstrapCIs(results []map[string]any, sampleData map[string][]float64, sampleFraction float64, cols []string) {
-	const B = 300
-	scale := 1.0 / sampleFraction
+// enrichWithBootstrapCIs attaches bootstrap CIs at plan's confidence level
+// to cols and returns the replicate count used per column, chosen from its
+// MaxRelError and each column's observed variance (see
+// estimator.AdaptiveIterations). Scaled columns are bootstrapped as sums,
+// the rest as means.
+func enrichWithBootstrapCIs(budget *MemoryBudget, results *ResultSet, sampleData map[string][]float64, plan *planner.Plan, cols []string) (map[string]int, error) {
+	scale := 1.0 / plan.SampleFraction
+	n := results.Len()
+
+	// one workspace and one set of output buffers per column, no per-row work
+	if err := budget.Reserve(int64(estimator.MaxBootstrapIterations)*8, "bootstrap arrays"); err != nil {
+		return nil, err
+	}
+	ws := estimator.NewBootstrapWorkspace(estimator.MaxBootstrapIterations)
+	iterations := make(map[string]int, len(cols))
 
 	for _, col := range cols {
 		values, exists := sampleData[col]
@@ -134,36 +329,126 @@ func enrichWithBootstrapCIs(results []map[string]any, sampleData map[string][]fl
 			continue
 		}
 
-		var scaleFunc func([]float64) float64
-		if strings.Contains(strings.ToUpper(col), "SUM") || strings.Contains(strings.ToUpper(col), "REVENUE") || strings.Contains(strings.ToUpper(col), "TOTAL") {
-			scaleFunc = func(vals []float64) float64 {
-				sum := 0.0
-				for _, v := range vals {
-					sum += v
-				}
-				return sum
-			}
-		} else {
-			scaleFunc = func(vals []float64) float64 {
-				if len(vals) == 0 {
-					return 0
-				}
-				sum := 0.0
-				for _, v := range vals {
-					sum += v
-				}
-				return sum / float64(len(vals))
-			}
+		if err := budget.Reserve(int64(3*n)*8, "bootstrap arrays"); err != nil {
+			return nil, err
 		}
 
-		ci := estimator.BootstrapCI(values, scaleFunc, scale, B, 0.95)
+		// means of the sample are already estimates of the table's
+		stat, statScale := estimator.StatMean, 1.0
+		if a, ok := plan.Aggregate(col); ok && a.Scaled {
+			stat, statScale = estimator.StatSum, scale
+		}
+
+		ci, B := ws.AdaptiveCI(values, stat, statScale, plan.Level(), plan.MaxRelError)
+		iterations[col] = B
+
+		results.SetFloats(col+"_ci_low", fill(n, ci.Lower), nil)
+		results.SetFloats(col+"_ci_high", fill(n, ci.Upper), nil)
+		results.SetFloats(col+"_rel_error", fill(n, ci.RelativeError), nil)
+	}
+
+	return iterations, nil
+}
+
+func fill(n int, v float64) []float64 {
+	out := make([]float64, n)
+	for i := range out {
+		out[i] = v
+	}
+	return out
+}
 
-		for i := range results {
-			if _, exists := results[i][col]; exists {
-				results[i][col+"_ci_low"] = ci.Lower
-				results[i][col+"_ci_high"] = ci.Upper
-				results[i][col+"_rel_error"] = ci.RelativeError
+// annotateProvenance records which sample or sketch produced an approximate
+// answer, so a surprising number can be traced back to its synopsis.
+// Staleness is judged against a live row estimate, falling back to the
+// planner's statistics.
+func annotateProvenance(ctx context.Context, db storage.Queryer, meta map[string]any, plan *planner.Plan) *storage.Provenance {
+	if plan.Type != planner.PlanSample && plan.Type != planner.PlanSketch && plan.Type != planner.PlanHybrid {
+		return nil
+	}
+	currentRows := func(table string, fallback int64) int64 {
+		if n, err := storage.EstimateRowCount(ctx, db, table); err == nil && n > 0 {
+			return n
+		}
+		return fallback
+	}
+
+	var provs []*storage.Provenance
+	switch plan.Type {
+	case planner.PlanSample:
+		if plan.JoinSynopsis != "" {
+			// staleness is judged by the rows of the side the synopsis sampled
+			rows := currentRows(plan.JoinTable, 0)
+			if left, _ := storage.JoinSynopsisTables(plan.JoinSynopsis); left == plan.SampleTable {
+				rows = currentRows(plan.Table, plan.TableRows)
+			}
+			if prov, err := storage.JoinSynopsisProvenance(ctx, db, plan.JoinSynopsis, rows); err == nil && prov != nil {
+				provs = append(provs, prov)
 			}
+			break
+		}
+		if prov, err := storage.SampleProvenance(ctx, db, plan.SampleTable, currentRows(plan.Table, plan.TableRows)); err == nil && prov != nil {
+			provs = append(provs, prov)
+		}
+		if plan.JoinSampleTable != "" {
+			if prov, err := storage.SampleProvenance(ctx, db, plan.JoinSampleTable, currentRows(plan.JoinTable, 0)); err == nil && prov != nil {
+				provs = append(provs, prov)
+			}
+		}
+	case planner.PlanSketch:
+		if j := plan.SketchJoin; j != nil {
+			if prov, err := storage.SampleProvenance(ctx, db, plan.SampleTable, currentRows(plan.Table, plan.TableRows)); err == nil && prov != nil {
+				provs = append(provs, prov)
+			}
+			if prov, err := storage.SketchProvenance(ctx, db, j.Table, j.Column, plan.SketchType, currentRows(j.Table, 0)); err == nil && prov != nil {
+				provs = append(provs, prov)
+			}
+			break
+		}
+		if prov, err := storage.SketchProvenance(ctx, db, plan.Table, plan.SketchColumn, plan.SketchType, currentRows(plan.Table, plan.TableRows)); err == nil && prov != nil {
+			provs = append(provs, prov)
 		}
+	case planner.PlanHybrid:
+		for _, in := range plan.Inlined {
+			if prov, err := storage.SketchProvenance(ctx, db, in.Table, in.Column, "hyperloglog", currentRows(in.Table, 0)); err == nil && prov != nil {
+				provs = append(provs, prov)
+			}
+		}
+	}
+	if len(provs) == 0 {
+		return nil
+	}
+	meta["provenance"] = provs
+	for _, prov := range provs {
+		if prov.Stale {
+			meta["stale_synopsis"] = true
+		}
+	}
+	return provs[0]
+}
+
+// annotatePlanMeta copies planner fallback and latency details into the result metadata.
+func annotatePlanMeta(meta map[string]any, plan *planner.Plan) {
+	if plan.Fallback != "" {
+		meta["fallback"] = plan.Fallback
+	}
+	if plan.EstimatedLatencyMs > 0 {
+		meta["estimated_latency_ms"] = plan.EstimatedLatencyMs
+		meta["latency_source"] = plan.LatencySource
+	}
+	if len(plan.Inlined) > 0 {
+		meta["inlined_subqueries"] = plan.Inlined
+	}
+	if plan.Passthrough {
+		meta["passthrough"] = true
+	}
+	if plan.GroupLimit > 0 {
+		// only the first GroupLimit of the estimated groups were returned
+		meta["group_limit"] = plan.GroupLimit
+		meta["estimated_groups"] = plan.EstimatedGroups
+	}
+	if c := plan.Complexity; c != nil && c.WindowFunctions > 0 {
+		meta["window_functions"] = c.WindowFunctions
+		meta["approximation_disabled"] = true
 	}
 }
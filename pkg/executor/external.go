@@ -0,0 +1,282 @@
+package executor
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// DefaultExternalChunkRows is the rowid span aggregated per chunk.
+const DefaultExternalChunkRows = 1_000_000
+
+var (
+	externalGroupRe = regexp.MustCompile(`(?is)^\s*select\s+(.+?)\s+from\s+([a-zA-Z0-9_]+)(?:\s+where\s+(.+?))?\s+group\s+by\s+(.+?)(?:\s+order\s+by\s+(.+?))?(?:\s+limit\s+(\d+))?\s*;?\s*$`)
+	externalAggRe   = regexp.MustCompile(`(?is)^(count|sum|min|max|avg)\s*\((.*)\)$`)
+	aliasRe         = regexp.MustCompile(`(?is)^(.+?)\s+as\s+("?[a-zA-Z0-9_]+"?)$`)
+)
+
+// externalItem is one SELECT-list entry of a decomposable GROUP BY query.
+type externalItem struct {
+	expr   string // expression as written
+	alias  string // output column name
+	agg    string // COUNT/SUM/MIN/MAX/AVG, empty for group keys
+	arg    string
+	slots  []string // partial columns in the temp table
+	keyIdx int
+}
+
+// ExecuteExternal runs an exact GROUP BY in rowid-range chunks, spilling
+// partial aggregates to an on-disk temp table and combining them at the end,
+// so large exact baselines don't make SQLite build one huge in-memory hash.
+// Only the grouping is chunked: an ORDER BY sorts the combined groups, and
+// must name result columns. Queries it cannot decompose or whose ORDER BY
+// it cannot resolve, tables smaller than one chunk, and databases without
+// rowids run through Execute unchanged.
+func ExecuteExternal(ctx context.Context, db *sql.DB, plan *planner.Plan, chunkRows int64) (res *ResultSet, meta map[string]any, err error) {
+	if plan.Type != planner.PlanExact || !storage.ActiveDialect().HasRowid() {
+		return Execute(ctx, db, plan)
+	}
+	if chunkRows <= 0 {
+		chunkRows = DefaultExternalChunkRows
+	}
+
+	m := externalGroupRe.FindStringSubmatch(plan.SQL)
+	if m == nil || strings.Contains(strings.ToLower(m[1]), "select") {
+		return Execute(ctx, db, plan)
+	}
+	table, where, groupBy, orderBy, limit := m[2], m[3], m[4], m[5], m[6]
+
+	keys := planner.SplitTopLevel(groupBy)
+	items, ok := parseExternalItems(planner.SplitTopLevel(m[1]), keys)
+	if !ok {
+		return Execute(ctx, db, plan)
+	}
+	var order []string
+	if orderBy != "" {
+		if order, ok = externalOrder(plan.SQL, items); !ok {
+			return Execute(ctx, db, plan)
+		}
+	}
+
+	var minID, maxID sql.NullInt64
+	if err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT MIN(rowid), MAX(rowid) FROM %s", table)).Scan(&minID, &maxID); err != nil {
+		return Execute(ctx, db, plan)
+	}
+	if !minID.Valid || maxID.Int64-minID.Int64 < chunkRows {
+		return Execute(ctx, db, plan)
+	}
+
+	// temp tables are per connection, so pin one for the whole operation
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+	stop, err := storage.InterruptOnDone(ctx, conn)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer stop()
+	defer func() { err = interrupted(ctx, err) }()
+
+	restore, err := storage.AllowTempTables(ctx, conn)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer restore()
+
+	if _, err := conn.ExecContext(ctx, "PRAGMA temp_store = FILE"); err != nil {
+		return nil, nil, err
+	}
+
+	tmp := "aqe_external_partial"
+	var cols, partials []string
+	for i := range keys {
+		cols = append(cols, fmt.Sprintf("k%d", i))
+	}
+	for _, it := range items {
+		cols = append(cols, it.slots...)
+		partials = append(partials, partialExprs(it)...)
+	}
+
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS temp.%s", tmp)); err != nil {
+		return nil, nil, err
+	}
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("CREATE TEMP TABLE %s (%s)", tmp, strings.Join(cols, ", "))); err != nil {
+		return nil, nil, err
+	}
+	defer conn.ExecContext(context.Background(), fmt.Sprintf("DROP TABLE IF EXISTS temp.%s", tmp))
+
+	filter := ""
+	if where != "" {
+		filter = fmt.Sprintf(" AND (%s)", where)
+	}
+
+	chunks := 0
+	for lo := minID.Int64; lo <= maxID.Int64; lo += chunkRows {
+		insert := fmt.Sprintf("INSERT INTO %s SELECT %s, %s FROM %s WHERE rowid >= %d AND rowid < %d%s GROUP BY %s",
+			tmp, strings.Join(keys, ", "), strings.Join(partials, ", "), table, lo, lo+chunkRows, filter, groupBy)
+		if _, err := conn.ExecContext(ctx, insert); err != nil {
+			return nil, nil, fmt.Errorf("external group chunk %d: %w", chunks, err)
+		}
+		chunks++
+	}
+
+	final := buildExternalFinal(tmp, items, len(keys), order, limit)
+	rows, err := conn.QueryContext(ctx, final)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	budget := MemoryBudgetFromContext(ctx)
+	names := make([]string, len(items))
+	for i, it := range items {
+		names[i] = it.alias
+	}
+	res = NewResultSet(names)
+
+	vals := make([]any, len(names))
+	ptrs := make([]any, len(names))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, nil, err
+		}
+		if err := budget.Reserve(estimateRowBytes(vals), "result buffer"); err != nil {
+			return nil, nil, err
+		}
+		res.AppendRow(vals)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	meta = map[string]any{
+		"plan_type":       string(plan.Type),
+		"reason":          plan.Reason,
+		"rows":            res.Len(),
+		"sql_executed":    plan.SQL,
+		"external_chunks": chunks,
+	}
+	annotatePlanMeta(meta, plan)
+	if budget != nil {
+		meta["memory_bytes"] = budget.Used()
+	}
+	return res, meta, nil
+}
+
+func parseExternalItems(selectList, keys []string) ([]externalItem, bool) {
+	keySet := make(map[string]int, len(keys))
+	for i, k := range keys {
+		keySet[strings.ToLower(k)] = i
+	}
+
+	items := make([]externalItem, 0, len(selectList))
+	for i, raw := range selectList {
+		it := externalItem{expr: raw, alias: raw, keyIdx: -1}
+		if am := aliasRe.FindStringSubmatch(raw); am != nil {
+			it.expr = strings.TrimSpace(am[1])
+			it.alias = strings.Trim(am[2], `"`)
+		}
+
+		if ag := externalAggRe.FindStringSubmatch(it.expr); ag != nil {
+			it.agg = strings.ToUpper(ag[1])
+			it.arg = strings.TrimSpace(ag[2])
+			if strings.HasPrefix(strings.ToLower(it.arg), "distinct") {
+				return nil, false // distinct counts are not decomposable
+			}
+			if it.agg == "AVG" {
+				it.slots = []string{fmt.Sprintf("p%d_sum", i), fmt.Sprintf("p%d_cnt", i)}
+			} else {
+				it.slots = []string{fmt.Sprintf("p%d", i)}
+			}
+		} else if idx, ok := keySet[strings.ToLower(it.expr)]; ok {
+			it.keyIdx = idx
+		} else {
+			return nil, false
+		}
+		items = append(items, it)
+	}
+	return items, true
+}
+
+func partialExprs(it externalItem) []string {
+	switch it.agg {
+	case "":
+		return nil
+	case "AVG":
+		return []string{fmt.Sprintf("SUM(%s)", it.arg), fmt.Sprintf("COUNT(%s)", it.arg)}
+	default:
+		return []string{fmt.Sprintf("%s(%s)", it.agg, it.arg)}
+	}
+}
+
+// externalOrder resolves the ORDER BY of sqlText to the output columns of
+// items, as terms of the final query over the partials. ok is false when a
+// term is not one of them, such as an aggregate not selected, which the
+// partials can't be sorted by.
+func externalOrder(sqlText string, items []externalItem) ([]string, bool) {
+	keys, ok := planner.ResultOrder(sqlText)
+	if !ok {
+		return nil, false
+	}
+	norm := func(s string) string { return strings.ToLower(strings.Join(strings.Fields(s), "")) }
+	terms := make([]string, 0, len(keys))
+	for _, k := range keys {
+		i := slices.IndexFunc(items, func(it externalItem) bool { return norm(it.alias) == norm(k.Column) })
+		if i < 0 {
+			return nil, false
+		}
+		term := quoteIdent(items[i].alias)
+		if k.Desc {
+			term += " DESC"
+		}
+		terms = append(terms, term)
+	}
+	return terms, true
+}
+
+func buildExternalFinal(tmp string, items []externalItem, nKeys int, order []string, limit string) string {
+	sel := make([]string, len(items))
+	for i, it := range items {
+		var expr string
+		switch it.agg {
+		case "":
+			expr = fmt.Sprintf("k%d", it.keyIdx)
+		case "COUNT", "SUM":
+			expr = fmt.Sprintf("SUM(%s)", it.slots[0])
+		case "MIN", "MAX":
+			expr = fmt.Sprintf("%s(%s)", it.agg, it.slots[0])
+		case "AVG":
+			expr = fmt.Sprintf("SUM(%s) * 1.0 / NULLIF(SUM(%s), 0)", it.slots[0], it.slots[1])
+		}
+		sel[i] = fmt.Sprintf("%s AS %s", expr, quoteIdent(it.alias))
+	}
+
+	groups := make([]string, nKeys)
+	for i := range groups {
+		groups[i] = fmt.Sprintf("k%d", i)
+	}
+
+	q := fmt.Sprintf("SELECT %s FROM %s GROUP BY %s", strings.Join(sel, ", "), tmp, strings.Join(groups, ", "))
+	if len(order) > 0 {
+		q += " ORDER BY " + strings.Join(order, ", ")
+	}
+	if limit != "" {
+		q += " LIMIT " + limit
+	}
+	return q
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,86 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ErrMemoryLimitExceeded is returned when a query exceeds its memory budget.
+var ErrMemoryLimitExceeded = errors.New("query memory limit exceeded")
+
+// MemoryBudget tracks approximate bytes allocated on behalf of a single query.
+// A zero limit means unlimited (usage is still tracked).
+type MemoryBudget struct {
+	limit int64
+	used  atomic.Int64
+}
+
+// NewMemoryBudget creates a budget capped at limitBytes.
+func NewMemoryBudget(limitBytes int64) *MemoryBudget {
+	return &MemoryBudget{limit: limitBytes}
+}
+
+// Reserve accounts for n more bytes, failing once the limit is crossed.
+func (b *MemoryBudget) Reserve(n int64, what string) error {
+	if b == nil || n <= 0 {
+		return nil
+	}
+	used := b.used.Add(n)
+	if b.limit > 0 && used > b.limit {
+		return fmt.Errorf("%w: %s needs ~%d bytes, query has used %d of %d allowed",
+			ErrMemoryLimitExceeded, what, n, used, b.limit)
+	}
+	return nil
+}
+
+// Used returns the bytes accounted so far.
+func (b *MemoryBudget) Used() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.used.Load()
+}
+
+// Limit returns the configured limit in bytes (0 = unlimited).
+func (b *MemoryBudget) Limit() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.limit
+}
+
+type memoryBudgetKey struct{}
+
+// WithMemoryBudget attaches a budget to ctx for Execute and friends to charge against.
+func WithMemoryBudget(ctx context.Context, b *MemoryBudget) context.Context {
+	return context.WithValue(ctx, memoryBudgetKey{}, b)
+}
+
+// MemoryBudgetFromContext returns the budget attached to ctx, or nil.
+func MemoryBudgetFromContext(ctx context.Context) *MemoryBudget {
+	b, _ := ctx.Value(memoryBudgetKey{}).(*MemoryBudget)
+	return b
+}
+
+// reserveSketch charges a stored sketch of data to ctx's budget before it
+// is decoded; a decoded sketch takes up about as much as its encoding.
+func reserveSketch(ctx context.Context, data []byte) error {
+	return MemoryBudgetFromContext(ctx).Reserve(int64(len(data)), "sketch deserialization")
+}
+
+// estimateRowBytes approximates the columnar footprint of one scanned row.
+func estimateRowBytes(vals []any) int64 {
+	size := int64(0)
+	for _, v := range vals {
+		size += 9 // value slot + null flag
+		switch v := v.(type) {
+		case string:
+			size += int64(len(v)) + 8
+		case []byte:
+			size += int64(len(v)) + 16
+		}
+	}
+	return size
+}
@@ -0,0 +1,74 @@
+package executor
+
+import (
+	"math"
+	"sort"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+)
+
+// reorderGroups sorts the scaled groups of a sample plan by its ORDER BY
+// again, as scaling each group by its own stratum's fraction or adding
+// exact outliers can change their order, then applies the LIMIT the
+// planner moved out of the SQL. The sort is stable: groups whose estimates
+// are equal keep the order SQL returned them in. overlaps counts the
+// neighbouring groups whose intervals on the first ORDER BY column
+// overlap, which another sample could return the other way round. When
+// that column has intervals, the LIMIT is a top N whose rows get their
+// probability of being in the true top N (see rankTopN), reported in rank.
+func reorderGroups(res *ResultSet, plan *planner.Plan) (overlaps int, rank *TopNRank) {
+	keys := make([]*Column, len(plan.Order))
+	for i, k := range plan.Order {
+		if keys[i] = res.Column(k.Column); keys[i] == nil {
+			return 0, nil
+		}
+	}
+	rows := make([]int, res.Len())
+	for i := range rows {
+		rows[i] = i
+	}
+	sort.SliceStable(rows, func(a, b int) bool {
+		for i, k := range plan.Order {
+			x, y := keys[i].Value(rows[a]), keys[i].Value(rows[b])
+			if k.Desc {
+				x, y = y, x
+			}
+			if lessValue(x, y) {
+				return true
+			}
+			if lessValue(y, x) {
+				return false
+			}
+		}
+		return false
+	})
+	var probs []float64
+	if plan.ResultLimit > 0 {
+		var keep int
+		var ok bool
+		if probs, keep, rank, ok = rankTopN(res, rows, plan); ok {
+			rows, probs = rows[:keep], probs[:keep]
+		} else if int64(len(rows)) > plan.ResultLimit {
+			rows = rows[:plan.ResultLimit]
+		}
+	}
+	res.Reorder(rows)
+	if rank != nil {
+		res.SetFloats(TopNProbabilityColumn, probs, nil)
+	}
+
+	low, high := res.Column(plan.Order[0].Column+"_ci_low"), res.Column(plan.Order[0].Column+"_ci_high")
+	if low == nil || high == nil {
+		return 0, rank
+	}
+	for i := 0; i+1 < res.Len(); i++ {
+		l1, ok1 := low.Float(i)
+		h1, ok2 := high.Float(i)
+		l2, ok3 := low.Float(i + 1)
+		h2, ok4 := high.Float(i + 1)
+		if ok1 && ok2 && ok3 && ok4 && math.Max(l1, l2) <= math.Min(h1, h2) {
+			overlaps++
+		}
+	}
+	return overlaps, rank
+}
@@ -0,0 +1,170 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// addOutliers runs plan's OutlierSQL exactly and adds its answer to the
+// scaled sample result in results, returning how many groups only the
+// outliers had.
+// Rows are matched on the columns that are not aggregates: each matched
+// aggregate, and its interval, moves by the outliers' value, keeping the
+// interval's width, and groups only the outliers have are appended with
+// their exact values. The planner only pairs outlier samples with queries
+// whose aggregates all scale, so adding is all a combined answer needs.
+func addOutliers(ctx context.Context, db storage.Queryer, budget *MemoryBudget, results *ResultSet, plan *planner.Plan) (int, error) {
+	rows, err := db.QueryContext(ctx, plan.OutlierSQL)
+	if err != nil {
+		return 0, fmt.Errorf("querying outliers in %s: %w", plan.OutlierTable, err)
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	outliers := NewResultSet(cols)
+	vals := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return 0, err
+		}
+		if err := budget.Reserve(estimateRowBytes(vals), "outlier rows"); err != nil {
+			return 0, err
+		}
+		outliers.AppendRow(vals)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	aggregate := make(map[string]bool, len(plan.Aggregates))
+	var sums []string
+	for _, a := range plan.Aggregates {
+		if outliers.Column(a.Column) != nil && results.Column(a.Column) != nil {
+			aggregate[a.Column] = true
+			sums = append(sums, a.Column)
+		}
+	}
+	var keys []string
+	for _, c := range cols {
+		if !aggregate[c] && results.Column(c) != nil {
+			keys = append(keys, c)
+		}
+	}
+
+	index := make(map[string]int, results.Len())
+	for i := 0; i < results.Len(); i++ {
+		index[rowKey(results, keys, i)] = i
+	}
+	matched := make(map[int]int, outliers.Len())
+	var extra []int
+	for j := 0; j < outliers.Len(); j++ {
+		if i, ok := index[rowKey(outliers, keys, j)]; ok {
+			matched[i] = j
+		} else {
+			extra = append(extra, j)
+		}
+	}
+
+	for _, col := range sums {
+		est := floats(results.Column(col))
+		add := outliers.Column(col)
+		moved := make([]float64, len(est.vals))
+		for i, j := range matched {
+			if v, ok := add.Float(j); ok {
+				moved[i] = v
+				est.vals[i] += v
+				est.nulls[i] = false
+			}
+		}
+		results.SetFloats(col, est.vals, est.nulls)
+		for _, bound := range []string{col + "_ci_low", col + "_ci_high"} {
+			if c := results.Column(bound); c != nil {
+				b := floats(c)
+				for i := range b.vals {
+					if !b.nulls[i] {
+						b.vals[i] += moved[i]
+					}
+				}
+				results.SetFloats(bound, b.vals, b.nulls)
+			}
+		}
+		if c := results.Column(col + "_rel_error"); c != nil {
+			// the same absolute error, relative to the larger estimate
+			r := floats(c)
+			for i := range r.vals {
+				if before := est.vals[i] - moved[i]; !r.nulls[i] && est.vals[i] != 0 {
+					r.vals[i] *= math.Abs(before) / math.Abs(est.vals[i])
+				}
+			}
+			results.SetFloats(col+"_rel_error", r.vals, r.nulls)
+		}
+	}
+
+	// groups the sample missed are exact
+	row := make([]any, len(results.Columns))
+	for _, j := range extra {
+		for k, c := range results.Columns {
+			switch {
+			case outliers.Column(c.Name) != nil:
+				row[k] = outliers.Column(c.Name).Value(j)
+			case strings.HasSuffix(c.Name, "_rel_error"):
+				row[k] = 0.0
+			case strings.HasSuffix(c.Name, "_ci_low") || strings.HasSuffix(c.Name, "_ci_high"):
+				base := strings.TrimSuffix(strings.TrimSuffix(c.Name, "_ci_low"), "_ci_high")
+				if o := outliers.Column(base); o != nil {
+					row[k] = o.Value(j)
+				} else {
+					row[k] = nil
+				}
+			default:
+				row[k] = nil
+			}
+		}
+		if err := budget.Reserve(estimateRowBytes(row), "result buffer"); err != nil {
+			return 0, err
+		}
+		results.AppendRow(row)
+	}
+	return len(extra), nil
+}
+
+// floatColumn is a column's values as floats, for adding to in place.
+type floatColumn struct {
+	vals  []float64
+	nulls []bool
+}
+
+func floats(c *Column) floatColumn {
+	out := floatColumn{vals: make([]float64, c.Len()), nulls: make([]bool, c.Len())}
+	for i := range out.vals {
+		v, ok := c.Float(i)
+		out.vals[i], out.nulls[i] = v, !ok
+	}
+	return out
+}
+
+// rowKey identifies row i of rs by its keys columns.
+func rowKey(rs *ResultSet, keys []string, i int) string {
+	var b strings.Builder
+	for _, k := range keys {
+		v := rs.Column(k).Value(i)
+		if v == nil {
+			b.WriteString("\x01")
+		} else {
+			fmt.Fprint(&b, v)
+		}
+		b.WriteByte(0)
+	}
+	return b.String()
+}
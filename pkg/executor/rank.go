@@ -0,0 +1,139 @@
+package executor
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/estimator"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+)
+
+// TopNProbabilityColumn holds, for each row of a top-N answer over a
+// sample, the probability that its group is among the true top N.
+const TopNProbabilityColumn = "_top_n_probability"
+
+// rankTrials is how many draws of the groups' estimates rankTopN runs.
+const rankTrials = 2000
+
+// maxRankExpansion bounds the rows a top-N answer grows to for its
+// RankConfidence, as a multiple of N.
+const maxRankExpansion = 10
+
+// TopNRank reports how certain a top-N answer is of its groups.
+type TopNRank struct {
+	N int64 `json:"n"`
+	// Confidence is the probability that the rows returned hold the true
+	// top N groups.
+	Confidence float64 `json:"confidence"`
+	// Target is the plan's RankConfidence; Expanded counts the rows
+	// returned past N to reach it.
+	Target   float64 `json:"target,omitempty"`
+	Expanded int     `json:"expanded,omitempty"`
+	// Candidates counts the groups that could plausibly rank in the top N.
+	Candidates int `json:"candidates"`
+}
+
+// rankTopN weighs the groups of res, at positions rows in sorted order,
+// for a plan returning the first plan.ResultLimit of them. Each group's
+// estimate on the first order key is taken as normal, with the standard
+// error its interval implies, and the draws are ranked rankTrials times:
+// probs[i] is how often rows[i] drew into the top N. keep is how many rows
+// to return, N or more for plan.RankConfidence. ok is false when the key
+// has no intervals.
+func rankTopN(res *ResultSet, rows []int, plan *planner.Plan) (probs []float64, keep int, rank *TopNRank, ok bool) {
+	key := plan.Order[0]
+	est, low, high := res.Column(key.Column), res.Column(key.Column+"_ci_low"), res.Column(key.Column+"_ci_high")
+	if est == nil || low == nil || high == nil {
+		return nil, 0, nil, false
+	}
+	n := int(plan.ResultLimit)
+	rank = &TopNRank{N: plan.ResultLimit, Confidence: 1, Target: plan.RankConfidence}
+	probs = make([]float64, len(rows))
+	if len(rows) <= n {
+		for i := range probs {
+			probs[i] = 1
+		}
+		rank.Candidates = len(rows)
+		return probs, len(rows), rank, true
+	}
+
+	// larger is better: descending estimates as they are, ascending negated
+	z := estimator.ZScore(plan.Level())
+	mean := make([]float64, len(rows))
+	sd := make([]float64, len(rows))
+	for i, r := range rows {
+		v, isNum := est.Float(r)
+		if !isNum {
+			mean[i] = math.Inf(-1)
+			continue
+		}
+		if !key.Desc {
+			v = -v
+		}
+		mean[i] = v
+		l, okLow := low.Float(r)
+		h, okHigh := high.Float(r)
+		if okLow && okHigh && h > l {
+			sd[i] = (h - l) / (2 * z)
+		}
+	}
+
+	// groups more than 4 standard errors below the Nth group's reach are
+	// left out: they all but never draw into the top N
+	reach := make([]float64, len(rows))
+	for i := range rows {
+		reach[i] = mean[i] - 4*sd[i]
+	}
+	sort.Sort(sort.Reverse(sort.Float64Slice(reach)))
+	cutoff := reach[n-1]
+	var candidates []int
+	for i := range rows {
+		if !math.IsInf(mean[i], -1) && mean[i]+4*sd[i] >= cutoff {
+			candidates = append(candidates, i)
+		}
+	}
+	rank.Candidates = len(candidates)
+
+	// lastIn[p] counts the trials whose top N reached down to position p
+	rng := rand.New(rand.NewSource(1))
+	hits := make([]int, len(rows))
+	lastIn := make([]int, len(rows))
+	draws := make([]float64, len(candidates))
+	order := make([]int, len(candidates))
+	for t := 0; t < rankTrials; t++ {
+		for j, i := range candidates {
+			draws[j] = mean[i] + sd[i]*rng.NormFloat64()
+			order[j] = j
+		}
+		sort.Slice(order, func(a, b int) bool { return draws[order[a]] > draws[order[b]] })
+		last := 0
+		for _, j := range order[:min(n, len(order))] {
+			hits[candidates[j]]++
+			last = max(last, candidates[j])
+		}
+		lastIn[last]++
+	}
+	for i := range rows {
+		probs[i] = float64(hits[i]) / rankTrials
+	}
+
+	// covered(m) is the share of trials whose top N lies in the first m rows
+	covered := func(m int) float64 {
+		c := 0
+		for p := 0; p < m; p++ {
+			c += lastIn[p]
+		}
+		return float64(c) / rankTrials
+	}
+	keep = n
+	if plan.RankConfidence > 0 {
+		limit := min(len(rows), n*maxRankExpansion)
+		for keep < limit && covered(keep) < plan.RankConfidence {
+			keep++
+		}
+		rank.Expanded = keep - n
+	}
+	rank.Confidence = covered(keep)
+	return probs, keep, rank, true
+}
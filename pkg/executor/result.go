@@ -0,0 +1,319 @@
+package executor
+
+// ColumnKind is the physical type backing a result column.
+type ColumnKind uint8
+
+const (
+	KindNull ColumnKind = iota // no non-NULL value seen yet
+	KindInt
+	KindFloat
+	KindString
+	KindBytes
+	KindMixed // SQLite returned more than one type; values kept boxed
+)
+
+// Column holds one result column as a typed slice. Exactly one of the value
+// slices is populated, selected by Kind; Nulls marks NULL rows.
+type Column struct {
+	Name    string
+	Kind    ColumnKind
+	Ints    []int64
+	Floats  []float64
+	Strings []string
+	Bytes   [][]byte
+	Mixed   []any
+	Nulls   []bool
+}
+
+// Len returns the number of rows in the column.
+func (c *Column) Len() int {
+	return len(c.Nulls)
+}
+
+// Append adds a value scanned from the driver, promoting the column kind if needed.
+func (c *Column) Append(v any) {
+	if v == nil {
+		c.appendZero()
+		c.Nulls = append(c.Nulls, true)
+		return
+	}
+
+	kind := kindOf(v)
+	if c.Kind == KindNull {
+		c.setKind(kind)
+	} else if c.Kind != kind {
+		if c.Kind == KindInt && kind == KindFloat {
+			c.promoteToFloat()
+		} else if !(c.Kind == KindFloat && kind == KindInt) {
+			c.promoteToMixed()
+		}
+	}
+
+	switch c.Kind {
+	case KindInt:
+		c.Ints = append(c.Ints, v.(int64))
+	case KindFloat:
+		f, _ := convertToFloat64(v)
+		c.Floats = append(c.Floats, f)
+	case KindString:
+		c.Strings = append(c.Strings, v.(string))
+	case KindBytes:
+		c.Bytes = append(c.Bytes, v.([]byte))
+	default:
+		c.Mixed = append(c.Mixed, v)
+	}
+	c.Nulls = append(c.Nulls, false)
+}
+
+// Value returns row i boxed as the driver would have returned it.
+func (c *Column) Value(i int) any {
+	if c.Nulls[i] {
+		return nil
+	}
+	switch c.Kind {
+	case KindInt:
+		return c.Ints[i]
+	case KindFloat:
+		return c.Floats[i]
+	case KindString:
+		return c.Strings[i]
+	case KindBytes:
+		return c.Bytes[i]
+	case KindMixed:
+		return c.Mixed[i]
+	}
+	return nil
+}
+
+// Float returns row i as a float64 when it is numeric.
+func (c *Column) Float(i int) (float64, bool) {
+	if c.Nulls[i] {
+		return 0, false
+	}
+	switch c.Kind {
+	case KindInt:
+		return float64(c.Ints[i]), true
+	case KindFloat:
+		return c.Floats[i], true
+	default:
+		return convertToFloat64(c.Value(i))
+	}
+}
+
+// NumericValues returns the non-NULL numeric values of the column.
+func (c *Column) NumericValues() []float64 {
+	out := make([]float64, 0, c.Len())
+	for i := 0; i < c.Len(); i++ {
+		if f, ok := c.Float(i); ok {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// ScaleNumeric multiplies every numeric value by factor. Integer columns
+// become float columns; non-numeric values are left untouched.
+func (c *Column) ScaleNumeric(factor float64) {
+	switch c.Kind {
+	case KindInt:
+		c.promoteToFloat()
+		fallthrough
+	case KindFloat:
+		for i := range c.Floats {
+			c.Floats[i] *= factor
+		}
+	case KindString, KindMixed:
+		c.promoteToMixed()
+		for i, v := range c.Mixed {
+			if f, ok := convertToFloat64(v); ok && !c.Nulls[i] {
+				c.Mixed[i] = f * factor
+			}
+		}
+	}
+}
+
+func kindOf(v any) ColumnKind {
+	switch v.(type) {
+	case int64:
+		return KindInt
+	case float64, float32, int, int32:
+		return KindFloat
+	case string:
+		return KindString
+	case []byte:
+		return KindBytes
+	default:
+		return KindMixed
+	}
+}
+
+func (c *Column) setKind(kind ColumnKind) {
+	n := c.Len()
+	c.Kind = kind
+	switch kind {
+	case KindInt:
+		c.Ints = make([]int64, n, n+64)
+	case KindFloat:
+		c.Floats = make([]float64, n, n+64)
+	case KindString:
+		c.Strings = make([]string, n, n+64)
+	case KindBytes:
+		c.Bytes = make([][]byte, n, n+64)
+	default:
+		c.Mixed = make([]any, n, n+64)
+	}
+}
+
+func (c *Column) appendZero() {
+	switch c.Kind {
+	case KindInt:
+		c.Ints = append(c.Ints, 0)
+	case KindFloat:
+		c.Floats = append(c.Floats, 0)
+	case KindString:
+		c.Strings = append(c.Strings, "")
+	case KindBytes:
+		c.Bytes = append(c.Bytes, nil)
+	case KindMixed:
+		c.Mixed = append(c.Mixed, nil)
+	}
+}
+
+func (c *Column) promoteToFloat() {
+	floats := make([]float64, len(c.Ints), cap(c.Ints))
+	for i, v := range c.Ints {
+		floats[i] = float64(v)
+	}
+	c.Floats, c.Ints, c.Kind = floats, nil, KindFloat
+}
+
+func (c *Column) promoteToMixed() {
+	if c.Kind == KindMixed {
+		return
+	}
+	mixed := make([]any, c.Len(), c.Len()+64)
+	for i := range mixed {
+		mixed[i] = c.Value(i)
+	}
+	c.Mixed, c.Ints, c.Floats, c.Strings, c.Bytes = mixed, nil, nil, nil, nil
+	c.Kind = KindMixed
+}
+
+// ResultSet is the executor's columnar query result. It is converted to
+// row maps only at the API boundary (JSON encoding or Rows()).
+type ResultSet struct {
+	Columns []*Column
+	index   map[string]int
+	rows    int
+}
+
+// NewResultSet creates an empty result with the given column names.
+func NewResultSet(names []string) *ResultSet {
+	rs := &ResultSet{index: make(map[string]int, len(names))}
+	for _, n := range names {
+		rs.addColumn(&Column{Name: n})
+	}
+	return rs
+}
+
+func (rs *ResultSet) addColumn(c *Column) {
+	rs.index[c.Name] = len(rs.Columns)
+	rs.Columns = append(rs.Columns, c)
+}
+
+// AppendRow appends one scanned row; vals must match the column order.
+func (rs *ResultSet) AppendRow(vals []any) {
+	for i, c := range rs.Columns {
+		c.Append(vals[i])
+	}
+	rs.rows++
+}
+
+// Len returns the number of rows.
+func (rs *ResultSet) Len() int {
+	if rs == nil {
+		return 0
+	}
+	return rs.rows
+}
+
+// ColumnNames returns the column names in output order.
+func (rs *ResultSet) ColumnNames() []string {
+	names := make([]string, len(rs.Columns))
+	for i, c := range rs.Columns {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// Column returns the named column or nil.
+func (rs *ResultSet) Column(name string) *Column {
+	if i, ok := rs.index[name]; ok {
+		return rs.Columns[i]
+	}
+	return nil
+}
+
+// SetFloats adds (or replaces) a float column. nulls may be nil.
+func (rs *ResultSet) SetFloats(name string, vals []float64, nulls []bool) {
+	if nulls == nil {
+		nulls = make([]bool, len(vals))
+	}
+	c := &Column{Name: name, Kind: KindFloat, Floats: vals, Nulls: nulls}
+	if i, ok := rs.index[name]; ok {
+		rs.Columns[i] = c
+		return
+	}
+	rs.addColumn(c)
+}
+
+// Rows converts the result to row maps for callers that still need them.
+func (rs *ResultSet) Rows() []map[string]any {
+	if rs == nil {
+		return nil
+	}
+	out := make([]map[string]any, rs.rows)
+	for i := range out {
+		m := make(map[string]any, len(rs.Columns))
+		for _, c := range rs.Columns {
+			m[c.Name] = c.Value(i)
+		}
+		out[i] = m
+	}
+	return out
+}
+
+// MarshalJSON encodes the result as an array of row objects, keeping column order.
+func (rs *ResultSet) MarshalJSON() ([]byte, error) {
+	if rs == nil {
+		return []byte("null"), nil
+	}
+	return rs.AppendJSON(make([]byte, 0, rs.estimatedJSONSize()))
+}
+
+// Reorder keeps the given rows, in the order given.
+func (rs *ResultSet) Reorder(rows []int) {
+	for i, c := range rs.Columns {
+		next := &Column{Name: c.Name}
+		for _, r := range rows {
+			next.Append(c.Value(r))
+		}
+		rs.Columns[i] = next
+	}
+	rs.rows = len(rows)
+}
+
+// RemoveColumn drops the named column and returns it, or nil if absent.
+func (rs *ResultSet) RemoveColumn(name string) *Column {
+	i, ok := rs.index[name]
+	if !ok {
+		return nil
+	}
+	c := rs.Columns[i]
+	rs.Columns = append(rs.Columns[:i], rs.Columns[i+1:]...)
+	delete(rs.index, name)
+	for j := i; j < len(rs.Columns); j++ {
+		rs.index[rs.Columns[j].Name] = j
+	}
+	return c
+}
@@ -0,0 +1,237 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/sketches"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// executeDistinctSketch answers a scalar COUNT(DISTINCT col) from the
+// stored HyperLogLog without reading the base table. The single result row
+// carries the estimate and a 95% interval from the sketch's standard error,
+// in the same _ci_low/_ci_high/_rel_error columns sample results use.
+func executeDistinctSketch(ctx context.Context, db storage.Queryer, plan *planner.Plan, sd planner.ScalarDistinct) (*ResultSet, map[string]any, error) {
+	data, _, err := storage.GetSketch(ctx, db, plan.Table, sd.Column, "hyperloglog")
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading hyperloglog sketch on %s.%s: %w", plan.Table, sd.Column, err)
+	}
+	if err := reserveSketch(ctx, data); err != nil {
+		return nil, nil, err
+	}
+	hll, err := sketches.DeserializeHyperLogLog(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decoding hyperloglog sketch on %s.%s: %w", plan.Table, sd.Column, err)
+	}
+
+	estimate := hll.Count()
+	low, high := hll.ConfidenceInterval(plan.Level())
+
+	res := NewResultSet([]string{sd.Output})
+	res.AppendRow([]any{int64(estimate)})
+	res.SetFloats(sd.Output+"_ci_low", []float64{float64(low)}, nil)
+	res.SetFloats(sd.Output+"_ci_high", []float64{float64(high)}, nil)
+	res.SetFloats(sd.Output+"_rel_error", []float64{hll.StandardError()}, nil)
+
+	meta := map[string]any{
+		"plan_type":     string(plan.Type),
+		"reason":        plan.Reason,
+		"rows":          res.Len(),
+		"answered_from": "sketch",
+		"sketch_type":   plan.SketchType,
+		"sketch_column": sd.Column,
+	}
+	annotatePlanMeta(meta, plan)
+	annotateProvenance(ctx, db, meta, plan)
+	return res, meta, nil
+}
+
+// executeQuantileSketch answers a scalar MEDIAN or PERCENTILE from the
+// stored t-digest without reading the base table. The interval spans the
+// quantiles at the digest's rank error either side of the requested one.
+func executeQuantileSketch(ctx context.Context, db storage.Queryer, plan *planner.Plan, sq planner.ScalarQuantile) (*ResultSet, map[string]any, error) {
+	data, _, err := storage.GetSketch(ctx, db, plan.Table, sq.Column, "tdigest")
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading t-digest sketch on %s.%s: %w", plan.Table, sq.Column, err)
+	}
+	if err := reserveSketch(ctx, data); err != nil {
+		return nil, nil, err
+	}
+	td, err := sketches.DeserializeTDigest(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decoding t-digest sketch on %s.%s: %w", plan.Table, sq.Column, err)
+	}
+
+	res := NewResultSet([]string{sq.Output})
+	if td.Count() == 0 {
+		res.AppendRow([]any{nil})
+	} else {
+		estimate := td.Quantile(sq.Quantile)
+		delta := td.RankError(sq.Quantile)
+		low, high := td.Quantile(math.Max(sq.Quantile-delta, 0)), td.Quantile(math.Min(sq.Quantile+delta, 1))
+		relErr := 0.0
+		if estimate != 0 {
+			relErr = (high - low) / 2 / math.Abs(estimate)
+		}
+		res.AppendRow([]any{estimate})
+		res.SetFloats(sq.Output+"_ci_low", []float64{low}, nil)
+		res.SetFloats(sq.Output+"_ci_high", []float64{high}, nil)
+		res.SetFloats(sq.Output+"_rel_error", []float64{relErr}, nil)
+	}
+
+	meta := map[string]any{
+		"plan_type":     string(plan.Type),
+		"reason":        plan.Reason,
+		"rows":          res.Len(),
+		"answered_from": "sketch",
+		"sketch_type":   plan.SketchType,
+		"sketch_column": sq.Column,
+		"quantile":      sq.Quantile,
+	}
+	annotatePlanMeta(meta, plan)
+	annotateProvenance(ctx, db, meta, plan)
+	return res, meta, nil
+}
+
+// errUntrackedKeys means a Count-Min sketch can't enumerate its groups, so
+// the query has to run against the table.
+var errUntrackedKeys = errors.New("count-min sketch does not track all of its keys")
+
+// groupCount is one group answered from a Count-Min sketch.
+type groupCount struct {
+	key      any // nil for the NULL group
+	estimate uint64
+	bound    uint64 // estimate - bound <= true count <= estimate, w.p. the sketch's confidence
+}
+
+// executeGroupCountSketch answers per-group COUNT(*) from the stored
+// Count-Min sketch and the keys it tracks, without scanning the table. The
+// count column gets _ci_low/_ci_high/_rel_error columns from the sketch's
+// one-sided bound: it never undercounts and overcounts by at most ε·N with
+// probability 1-δ. Rows the sketch skipped for a NULL key are the base row
+// count recorded with the sketch minus its total. It returns
+// errUntrackedKeys when the sketch's key list is missing or incomplete.
+func executeGroupCountSketch(ctx context.Context, db storage.Queryer, plan *planner.Plan, g planner.GroupCount) (*ResultSet, map[string]any, error) {
+	data, _, err := storage.GetSketch(ctx, db, plan.Table, g.Column, "countmin")
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading count-min sketch on %s.%s: %w", plan.Table, g.Column, err)
+	}
+	if err := reserveSketch(ctx, data); err != nil {
+		return nil, nil, err
+	}
+	cms, err := sketches.DeserializeCountMinSketch(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decoding count-min sketch on %s.%s: %w", plan.Table, g.Column, err)
+	}
+	keys, complete := cms.Keys()
+	if !complete {
+		return nil, nil, errUntrackedKeys
+	}
+
+	meta := map[string]any{
+		"plan_type":         string(plan.Type),
+		"reason":            plan.Reason,
+		"answered_from":     "sketch",
+		"sketch_type":       plan.SketchType,
+		"sketch_column":     g.Column,
+		"sketch_confidence": cms.Confidence(),
+	}
+	annotatePlanMeta(meta, plan)
+	prov := annotateProvenance(ctx, db, meta, plan)
+
+	bound := cms.ErrorBound()
+	values := typedKeys(keys)
+	groups := make([]groupCount, 0, len(keys)+1)
+	if prov != nil && prov.BaseRows > int64(cms.TotalCount()) {
+		groups = append(groups, groupCount{estimate: uint64(prov.BaseRows) - cms.TotalCount()})
+	}
+	for i, k := range keys {
+		groups = append(groups, groupCount{key: values[i], estimate: cms.QueryString(k), bound: bound})
+	}
+	sortGroups(groups, g)
+	if g.Limit >= 0 && len(groups) > g.Limit {
+		groups = groups[:g.Limit]
+	}
+
+	res := NewResultSet(g.Outputs)
+	countCol := g.Outputs[1-g.KeyItem]
+	lows := make([]float64, len(groups))
+	highs := make([]float64, len(groups))
+	relErrs := make([]float64, len(groups))
+	row := make([]any, 2)
+	for i, gc := range groups {
+		row[g.KeyItem], row[1-g.KeyItem] = gc.key, int64(gc.estimate)
+		res.AppendRow(row)
+		lows[i] = math.Max(0, float64(gc.estimate)-float64(gc.bound))
+		highs[i] = float64(gc.estimate)
+		if gc.estimate > 0 {
+			relErrs[i] = float64(gc.bound) / float64(gc.estimate)
+		}
+	}
+	res.SetFloats(countCol+"_ci_low", lows, nil)
+	res.SetFloats(countCol+"_ci_high", highs, nil)
+	res.SetFloats(countCol+"_rel_error", relErrs, nil)
+
+	meta["rows"] = res.Len()
+	return res, meta, nil
+}
+
+// typedKeys converts the sketch's string keys back to integers or floats
+// when every key parses as one, so they sort and encode like the column.
+func typedKeys(keys []string) []any {
+	out := make([]any, len(keys))
+	allInts, allFloats := true, true
+	for _, k := range keys {
+		if _, err := strconv.ParseInt(k, 10, 64); err != nil {
+			allInts = false
+		}
+		if _, err := strconv.ParseFloat(k, 64); err != nil {
+			allFloats = false
+		}
+	}
+	for i, k := range keys {
+		switch {
+		case allInts:
+			out[i], _ = strconv.ParseInt(k, 10, 64)
+		case allFloats:
+			out[i], _ = strconv.ParseFloat(k, 64)
+		default:
+			out[i] = k
+		}
+	}
+	return out
+}
+
+// sortGroups orders groups as the query asks, by key when it doesn't say.
+// NULL sorts first, as in SQLite.
+func sortGroups(groups []groupCount, g planner.GroupCount) {
+	byKey := func(a, b groupCount) bool {
+		switch {
+		case a.key == nil || b.key == nil:
+			return a.key == nil && b.key != nil
+		}
+		switch ak := a.key.(type) {
+		case int64:
+			return ak < b.key.(int64)
+		case float64:
+			return ak < b.key.(float64)
+		}
+		return a.key.(string) < b.key.(string)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		a, b := groups[i], groups[j]
+		if g.OrderBy == "count" && a.estimate != b.estimate {
+			return (a.estimate < b.estimate) != g.Desc
+		}
+		if g.OrderBy == "key" && g.Desc {
+			return byKey(b, a)
+		}
+		return byKey(a, b)
+	})
+}
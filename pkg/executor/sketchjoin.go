@@ -0,0 +1,243 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/estimator"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/sketches"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// executeSketchJoin answers a join grouped by its key from a sample of one
+// side and the Count-Min sketch of the other, which is never read. Every
+// sampled row of a group matches the rows the sketch counts for its key,
+// so the group's COUNT(*) is its sampled rows times that count, and a SUM
+// its sampled sum times it, scaled by the sample's fraction. Groups whose
+// key the sketch counts no rows for are dropped, as the inner join would.
+//
+// Each aggregate's interval spans the sample's normal interval times the
+// sketch's one-sided one: the count lies between the estimate less the
+// sketch's bound and the estimate, with the sketch's confidence.
+func executeSketchJoin(ctx context.Context, db storage.Queryer, plan *planner.Plan) (*ResultSet, map[string]any, error) {
+	j := plan.SketchJoin
+	data, _, err := storage.GetSketch(ctx, db, j.Table, j.Column, "countmin")
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading count-min sketch on %s.%s: %w", j.Table, j.Column, err)
+	}
+	if err := reserveSketch(ctx, data); err != nil {
+		return nil, nil, err
+	}
+	cms, err := sketches.DeserializeCountMinSketch(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decoding count-min sketch on %s.%s: %w", j.Table, j.Column, err)
+	}
+	// with every key tracked, a key the sketch never saw has no match even
+	// if its counters collide with others'
+	var members map[string]bool
+	if keys, complete := cms.Keys(); complete {
+		members = make(map[string]bool, len(keys))
+		for _, k := range keys {
+			members[k] = true
+		}
+	}
+
+	rows, err := db.QueryContext(ctx, plan.SQL)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+	budget := MemoryBudgetFromContext(ctx)
+	vals := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+
+	f := plan.SampleFraction
+	z := estimator.ZScore(plan.Level())
+	bound := float64(cms.ErrorBound())
+	type estimate struct{ value, low, high, relErr float64 }
+	type group struct {
+		key  any
+		aggs []*estimate // per item; nil for the key and NULL sums
+	}
+	// joined scales a sampled total with sum of squares sumsq by the
+	// sketch's count c: the sample's interval times the sketch's
+	joined := func(total, sumsq, c float64) *estimate {
+		s := total / f
+		se := math.Sqrt(math.Max(sumsq, 0)*(1-f)) / f
+		e := &estimate{value: s * c, low: math.Inf(1), high: math.Inf(-1)}
+		for _, a := range []float64{s - z*se, s + z*se} {
+			for _, b := range []float64{math.Max(c-bound, 0), c} {
+				e.low, e.high = math.Min(e.low, a*b), math.Max(e.high, a*b)
+			}
+		}
+		if e.value != 0 {
+			e.relErr = (e.high - e.low) / 2 / math.Abs(e.value)
+		}
+		return e
+	}
+
+	var groups []group
+	unmatched := 0
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, nil, err
+		}
+		var keyText string
+		switch k := vals[1].(type) {
+		case string:
+			keyText = k
+		case []byte:
+			keyText = string(k)
+		}
+		c := float64(cms.QueryString(keyText))
+		if members != nil && !members[keyText] {
+			c = 0
+		}
+		if c == 0 {
+			unmatched++
+			continue
+		}
+		n, _ := convertToFloat64(vals[2])
+		g := group{key: vals[0], aggs: make([]*estimate, len(j.Items))}
+		next := 3
+		for i, it := range j.Items {
+			switch it.Function {
+			case "COUNT":
+				g.aggs[i] = joined(n, n, c)
+			case "SUM", "TOTAL":
+				total, ok := convertToFloat64(vals[next])
+				sumsq, _ := convertToFloat64(vals[next+1])
+				next += 2
+				if ok {
+					g.aggs[i] = joined(total, sumsq, c)
+				}
+			}
+		}
+		if err := budget.Reserve(estimateRowBytes(vals), "result buffer"); err != nil {
+			return nil, nil, err
+		}
+		groups = append(groups, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if j.OrderBy >= 0 {
+		o := j.OrderBy
+		value := func(g group) any {
+			if j.Items[o].Function == "" {
+				return g.key
+			}
+			if a := g.aggs[o]; a != nil {
+				return a.value
+			}
+			return nil
+		}
+		sort.SliceStable(groups, func(a, b int) bool {
+			if j.Desc {
+				return lessValue(value(groups[b]), value(groups[a]))
+			}
+			return lessValue(value(groups[a]), value(groups[b]))
+		})
+	}
+	if j.Limit >= 0 && len(groups) > j.Limit {
+		groups = groups[:j.Limit]
+	}
+
+	res := NewResultSet(j.Outputs)
+	row := make([]any, len(j.Outputs))
+	for _, g := range groups {
+		for i, it := range j.Items {
+			switch {
+			case it.Function == "":
+				row[i] = g.key
+			case g.aggs[i] == nil:
+				row[i] = nil
+			default:
+				row[i] = g.aggs[i].value
+			}
+		}
+		res.AppendRow(row)
+	}
+	for i, it := range j.Items {
+		if it.Function == "" {
+			continue
+		}
+		lows, highs, relErrs := make([]float64, len(groups)), make([]float64, len(groups)), make([]float64, len(groups))
+		nulls := make([]bool, len(groups))
+		for k, g := range groups {
+			if a := g.aggs[i]; a != nil {
+				lows[k], highs[k], relErrs[k] = a.low, a.high, a.relErr
+			} else {
+				nulls[k] = true
+			}
+		}
+		out := j.Outputs[i]
+		res.SetFloats(out+"_ci_low", lows, nulls)
+		res.SetFloats(out+"_ci_high", highs, nulls)
+		res.SetFloats(out+"_rel_error", relErrs, nulls)
+	}
+
+	meta := map[string]any{
+		"plan_type":         string(plan.Type),
+		"reason":            plan.Reason,
+		"rows":              res.Len(),
+		"sql_executed":      plan.SQL,
+		"answered_from":     "sketch_join",
+		"sample_table":      plan.SampleTable,
+		"sample_fraction":   plan.SampleFraction,
+		"sketch_type":       plan.SketchType,
+		"sketch_table":      j.Table,
+		"sketch_column":     j.Column,
+		"sketch_confidence": cms.Confidence(),
+		"unmatched_groups":  unmatched,
+	}
+	annotatePlanMeta(meta, plan)
+	annotateProvenance(ctx, db, meta, plan)
+	if budget != nil {
+		meta["memory_bytes"] = budget.Used()
+	}
+	return res, meta, nil
+}
+
+// lessValue orders result values as SQLite does: NULL, then numbers, then
+// text, then blobs.
+func lessValue(a, b any) bool {
+	rank := func(v any) int {
+		switch v.(type) {
+		case nil:
+			return 0
+		case int64, float64:
+			return 1
+		case string:
+			return 2
+		}
+		return 3
+	}
+	if ra, rb := rank(a), rank(b); ra != rb {
+		return ra < rb
+	}
+	switch av := a.(type) {
+	case int64, float64:
+		x, _ := convertToFloat64(av)
+		y, _ := convertToFloat64(b)
+		return x < y
+	case string:
+		return av < b.(string)
+	case []byte:
+		if bv, ok := b.([]byte); ok {
+			return string(av) < string(bv)
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package executor
+
+import (
+	"math"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// staleCorrectionUncertainty is the share of a stale-sample correction that
+// is added to the relative error: the correction assumes rows added since
+// the sample was drawn look like the rows it saw, which may not hold.
+const staleCorrectionUncertainty = 0.5
+
+// StaleCorrection describes the growth correction applied to a result
+// computed from a stale sample.
+type StaleCorrection struct {
+	Factor        float64  `json:"factor"` // current rows / rows at sample creation
+	BaseRows      int64    `json:"base_rows"`
+	CurrentRows   int64    `json:"current_rows"`
+	ExtraRelError float64  `json:"extra_rel_error"`
+	Columns       []string `json:"columns"`
+}
+
+// correctStaleSample rescales cols, the plan's scaled columns, by the base
+// table's growth since the sample was created and widens their intervals
+// by part of that correction. It returns nil when the sample is not stale.
+func correctStaleSample(results *ResultSet, prov *storage.Provenance, cols []string) *StaleCorrection {
+	if prov == nil || !prov.Stale || prov.BaseRows <= 0 || prov.CurrentRows <= 0 {
+		return nil
+	}
+	factor := float64(prov.CurrentRows) / float64(prov.BaseRows)
+	corr := &StaleCorrection{
+		Factor:        factor,
+		BaseRows:      prov.BaseRows,
+		CurrentRows:   prov.CurrentRows,
+		ExtraRelError: staleCorrectionUncertainty * math.Abs(factor-1),
+	}
+
+	for _, col := range cols {
+		c := results.Column(col)
+		if c == nil {
+			continue
+		}
+		c.ScaleNumeric(factor)
+		corr.Columns = append(corr.Columns, col)
+
+		low, high, rel := results.Column(col+"_ci_low"), results.Column(col+"_ci_high"), results.Column(col+"_rel_error")
+		if low == nil || high == nil {
+			continue
+		}
+		low.ScaleNumeric(factor)
+		high.ScaleNumeric(factor)
+		for i := 0; i < results.Len(); i++ {
+			est, ok := c.Float(i)
+			if !ok || low.Kind != KindFloat || high.Kind != KindFloat || low.Nulls[i] {
+				continue
+			}
+			widen := math.Abs(est) * corr.ExtraRelError
+			low.Floats[i] -= widen
+			high.Floats[i] += widen
+			if rel != nil && rel.Kind == KindFloat && !rel.Nulls[i] {
+				rel.Floats[i] += corr.ExtraRelError
+			}
+		}
+	}
+	if len(corr.Columns) == 0 {
+		return nil
+	}
+	return corr
+}
@@ -0,0 +1,370 @@
+package executor
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/estimator"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// wanderBatchWalks is how many walks run between checks of the estimates'
+// intervals; wanderChunk bounds the rowids or walks one statement lists.
+const (
+	wanderBatchWalks = 1_000
+	wanderChunk      = 500
+)
+
+// wanderSums accumulates one aggregate's walk contributions: y is a walk's
+// argument times its fan-out, x the fan-out when the argument isn't NULL.
+// Walks that reached no row of the group contribute zeros, so only sums
+// are kept.
+type wanderSums struct {
+	y, yy, x, xx, xy float64
+	nonNull          int
+}
+
+type wanderGroup struct {
+	keys []any
+	aggs []wanderSums // per item; unused for group keys
+}
+
+// executeWanderJoin answers plan, a wander join (see planner.WanderJoin),
+// by random walks: a uniformly drawn rowid of the start table, then a
+// uniformly drawn row of the probe table with the same join key. Rowids
+// missing from the start table, rows with no match, and joined rows the
+// WHERE clause drops end a walk with nothing. With span the start table's
+// rowid range, n walks and d a walk's matches, a SUM is estimated as
+// span/n times the sum of d times the argument over the walks, a COUNT the
+// same with 1 for the argument, and an AVG as their ratio; each interval
+// is the normal one from the walks' variance, by the delta method for
+// AVG. Walks run in batches until every estimate's relative error is
+// within plan.MaxRelError, or the plan's walk budget is spent.
+func executeWanderJoin(ctx context.Context, db storage.Queryer, plan *planner.Plan) (*ResultSet, map[string]any, error) {
+	j := plan.WanderJoin
+	var lo, hi sql.NullInt64
+	if err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT MIN(rowid), MAX(rowid) FROM %s", j.Start)).Scan(&lo, &hi); err != nil {
+		return nil, nil, err
+	}
+	budget := MemoryBudgetFromContext(ctx)
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	z := estimator.ZScore(plan.Level())
+	span := float64(hi.Int64 - lo.Int64 + 1)
+
+	groups := make(map[string]*wanderGroup)
+	var order []string
+	if j.Groups == 0 {
+		// a scalar aggregate answers one row even when no walk joins
+		groups[""] = &wanderGroup{aggs: make([]wanderSums, len(j.Items))}
+		order = append(order, "")
+	}
+	// degrees caches each join key's matches in the probe table
+	degrees := make(map[string]int64)
+	walks, joined := 0, 0
+	stopped := "max_walks"
+
+	for lo.Valid && walks < j.MaxWalks {
+		batch := min(wanderBatchWalks, j.MaxWalks-walks)
+		slots := make([]int64, batch)
+		for i := range slots {
+			slots[i] = lo.Int64 + rng.Int63n(int64(span))
+		}
+		keys, err := wanderStartKeys(ctx, db, j, slots)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// l, r and d of each walk that reached a probe row, by walk
+		type walk struct{ l, r, d int64 }
+		reached := make(map[int]walk)
+		for i, slot := range slots {
+			key, ok := keys[slot]
+			if !ok || key == nil {
+				continue
+			}
+			k := wanderKey(key)
+			d, ok := degrees[k]
+			if !ok {
+				err := db.QueryRowContext(ctx, fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE "%s" = ?`, j.Probe, j.ProbeKey), key).Scan(&d)
+				if err != nil {
+					return nil, nil, err
+				}
+				degrees[k] = d
+			}
+			if d == 0 {
+				continue
+			}
+			var r int64
+			err := db.QueryRowContext(ctx, fmt.Sprintf(`SELECT rowid FROM %s WHERE "%s" = ? ORDER BY rowid LIMIT 1 OFFSET ?`, j.Probe, j.ProbeKey),
+				key, rng.Int63n(d)).Scan(&r)
+			if err != nil {
+				return nil, nil, err
+			}
+			reached[walks+i] = walk{l: slot, r: r, d: d}
+		}
+
+		ids := make([]int, 0, len(reached))
+		for id := range reached {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		for c := 0; c < len(ids); c += wanderChunk {
+			chunk := ids[c:min(c+wanderChunk, len(ids))]
+			values := make([]string, len(chunk))
+			for i, id := range chunk {
+				w := reached[id]
+				values[i] = fmt.Sprintf("(%d, %d, %d)", id, w.l, w.r)
+			}
+			rows, err := db.QueryContext(ctx, "WITH aqe_walks(aqe_walk, aqe_l, aqe_r) AS (VALUES "+strings.Join(values, ", ")+") "+plan.SQL)
+			if err != nil {
+				return nil, nil, err
+			}
+			err = func() error {
+				defer rows.Close()
+				cols, err := rows.Columns()
+				if err != nil {
+					return err
+				}
+				vals := make([]any, len(cols))
+				ptrs := make([]any, len(cols))
+				for i := range vals {
+					ptrs[i] = &vals[i]
+				}
+				for rows.Next() {
+					if err := rows.Scan(ptrs...); err != nil {
+						return err
+					}
+					id, _ := vals[0].(int64)
+					d := float64(reached[int(id)].d)
+					gk := ""
+					for _, v := range vals[1 : 1+j.Groups] {
+						gk += wanderKey(v) + "\x00"
+					}
+					g, ok := groups[gk]
+					if !ok {
+						g = &wanderGroup{keys: append([]any(nil), vals[1:1+j.Groups]...), aggs: make([]wanderSums, len(j.Items))}
+						if err := budget.Reserve(estimateRowBytes(vals), "result buffer"); err != nil {
+							return err
+						}
+						groups[gk] = g
+						order = append(order, gk)
+					}
+					joined++
+					arg := 1 + j.Groups
+					for i, it := range j.Items {
+						if it.Function == "" {
+							continue
+						}
+						v := vals[arg]
+						arg++
+						if v == nil {
+							continue
+						}
+						f, _ := convertToFloat64(v)
+						if it.Function == "COUNT" {
+							f = 1
+						}
+						s := &g.aggs[i]
+						s.y += d * f
+						s.yy += d * f * d * f
+						s.x += d
+						s.xx += d * d
+						s.xy += d * d * f
+						s.nonNull++
+					}
+				}
+				return rows.Err()
+			}()
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		walks += batch
+
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		if plan.MaxRelError > 0 && wanderWithin(groups, j, walks, span, z, plan.MaxRelError) {
+			stopped = "tolerance"
+			break
+		}
+	}
+
+	sort.SliceStable(order, func(a, b int) bool {
+		ka, kb := groups[order[a]].keys, groups[order[b]].keys
+		for i := range ka {
+			if lessValue(ka[i], kb[i]) {
+				return true
+			}
+			if lessValue(kb[i], ka[i]) {
+				return false
+			}
+		}
+		return false
+	})
+
+	res := NewResultSet(j.Outputs)
+	row := make([]any, len(j.Outputs))
+	ests := make([][]wanderEstimate, len(order))
+	for k, gk := range order {
+		g := groups[gk]
+		ests[k] = make([]wanderEstimate, len(j.Items))
+		for i, it := range j.Items {
+			if it.Function == "" {
+				row[i] = g.keys[it.Group]
+				continue
+			}
+			e := estimateWander(it, g.aggs[i], walks, span, z)
+			ests[k][i] = e
+			if e.null {
+				row[i] = nil
+			} else {
+				row[i] = e.value
+			}
+		}
+		res.AppendRow(row)
+	}
+	for i, it := range j.Items {
+		if it.Function == "" {
+			continue
+		}
+		lows, highs, relErrs := make([]float64, len(order)), make([]float64, len(order)), make([]float64, len(order))
+		nulls := make([]bool, len(order))
+		for k := range order {
+			e := ests[k][i]
+			lows[k], highs[k], relErrs[k], nulls[k] = e.low, e.high, e.relErr, e.null
+		}
+		out := j.Outputs[i]
+		res.SetFloats(out+"_ci_low", lows, nulls)
+		res.SetFloats(out+"_ci_high", highs, nulls)
+		res.SetFloats(out+"_rel_error", relErrs, nulls)
+	}
+
+	meta := map[string]any{
+		"plan_type":     string(plan.Type),
+		"reason":        plan.Reason,
+		"rows":          res.Len(),
+		"sql_executed":  plan.SQL,
+		"answered_from": "wander_join",
+		"walk_start":    j.Start,
+		"walk_probe":    j.Probe,
+		"walks":         walks,
+		"joined_walks":  joined,
+		"walks_stopped": stopped,
+	}
+	annotatePlanMeta(meta, plan)
+	if budget != nil {
+		meta["memory_bytes"] = budget.Used()
+	}
+	return res, meta, nil
+}
+
+// wanderStartKeys reads the join key of the start table's rows at the
+// given rowids; rowids with no row are missing from the result.
+func wanderStartKeys(ctx context.Context, db storage.Queryer, j *planner.WanderJoin, slots []int64) (map[int64]any, error) {
+	keys := make(map[int64]any, len(slots))
+	for c := 0; c < len(slots); c += wanderChunk {
+		chunk := slots[c:min(c+wanderChunk, len(slots))]
+		ids := make([]string, len(chunk))
+		for i, id := range chunk {
+			ids[i] = strconv.FormatInt(id, 10)
+		}
+		rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT rowid, "%s" FROM %s WHERE rowid IN (%s)`, j.StartKey, j.Start, strings.Join(ids, ", ")))
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id int64
+			var key any
+			if err := rows.Scan(&id, &key); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			keys[id] = key
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// wanderKey is a map key telling v's type and value apart.
+func wanderKey(v any) string {
+	if b, ok := v.([]byte); ok {
+		v = string(b)
+	}
+	return fmt.Sprintf("%T:%v", v, v)
+}
+
+type wanderEstimate struct {
+	value, low, high, relErr float64
+	null                     bool
+}
+
+// estimateWander estimates it from its sums over n walks from a start
+// table of span rowids, with its interval at z.
+func estimateWander(it planner.WanderJoinItem, s wanderSums, n int, span, z float64) wanderEstimate {
+	if n == 0 {
+		return wanderEstimate{null: it.Function != "COUNT" && it.Function != "TOTAL"}
+	}
+	nf := float64(n)
+	// (co)variances over all n walks, those contributing nothing included
+	cov := func(sa, sb, sab float64) float64 {
+		if n < 2 {
+			return 0
+		}
+		return (sab - sa*sb/nf) / (nf - 1)
+	}
+	var value, se float64
+	switch {
+	case it.Function == "AVG":
+		if s.nonNull == 0 || s.x == 0 {
+			return wanderEstimate{null: true}
+		}
+		r := s.y / s.x
+		v := cov(s.y, s.y, s.yy) - 2*r*cov(s.y, s.x, s.xy) + r*r*cov(s.x, s.x, s.xx)
+		value, se = r, math.Sqrt(math.Max(v, 0)/nf)/(s.x/nf)
+	case it.Function == "COUNT":
+		value, se = span*s.x/nf, span*math.Sqrt(math.Max(cov(s.x, s.x, s.xx), 0)/nf)
+	default:
+		if s.nonNull == 0 && it.Function == "SUM" {
+			return wanderEstimate{null: true}
+		}
+		value, se = span*s.y/nf, span*math.Sqrt(math.Max(cov(s.y, s.y, s.yy), 0)/nf)
+	}
+	e := wanderEstimate{value: value, low: value - z*se, high: value + z*se}
+	if value != 0 {
+		e.relErr = z * se / math.Abs(value)
+	}
+	return e
+}
+
+// wanderWithin reports whether every estimate after n walks has a relative
+// error within tolerance. An estimate no walk reached has none yet.
+func wanderWithin(groups map[string]*wanderGroup, j *planner.WanderJoin, n int, span, z, tolerance float64) bool {
+	for _, g := range groups {
+		for i, it := range j.Items {
+			if it.Function == "" {
+				continue
+			}
+			if g.aggs[i].nonNull == 0 {
+				return false
+			}
+			if e := estimateWander(it, g.aggs[i], n, span, z); e.null || e.relErr > tolerance {
+				return false
+			}
+		}
+	}
+	return true
+}
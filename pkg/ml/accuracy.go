@@ -0,0 +1,190 @@
+package ml
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// overpredictionRatio flags a group whose median measured speedup is below
+// this fraction of the median predicted speedup.
+const overpredictionRatio = 0.1
+
+// Percentiles summarizes a distribution of per-query values.
+type Percentiles struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+}
+
+// AccuracyPoint is one day of predicted vs measured values for a group.
+type AccuracyPoint struct {
+	Day              string  `json:"day"`
+	Queries          int     `json:"queries"`
+	PredictedSpeedup float64 `json:"predicted_speedup_p50"`
+	ActualSpeedup    float64 `json:"actual_speedup_p50"`
+	PredictedError   float64 `json:"predicted_error_p50"`
+	ActualError      float64 `json:"actual_error_p50"`
+}
+
+// AccuracyGroup reconciles predictions with measurements for one strategy
+// on one table. SpeedupRatio is actual/predicted per query, so 1.0 means
+// the estimate was right and 0.001 means it was 1000x too optimistic.
+type AccuracyGroup struct {
+	Strategy         string          `json:"strategy"`
+	Table            string          `json:"table"`
+	Queries          int             `json:"queries"`
+	PredictedSpeedup Percentiles     `json:"predicted_speedup"`
+	ActualSpeedup    Percentiles     `json:"actual_speedup"`
+	SpeedupRatio     Percentiles     `json:"speedup_ratio"`
+	PredictedError   Percentiles     `json:"predicted_error"`
+	ActualError      Percentiles     `json:"actual_error"`
+	Overpredicted    bool            `json:"overpredicted"`
+	Daily            []AccuracyPoint `json:"daily"`
+}
+
+// AccuracyReport is the body of GET /ml/accuracy.
+type AccuracyReport struct {
+	Since    time.Time           `json:"since"`
+	Groups   []AccuracyGroup     `json:"groups"`
+	Transfer *TransferEvaluation `json:"transfer"`
+}
+
+type accuracySample struct {
+	predictedSpeedup, actualSpeedup float64
+	predictedError, actualError     float64
+}
+
+// AccuracyReport summarizes recorded history from the last days days,
+// grouped by strategy and table, with percentile breakdowns and a daily
+// series of medians.
+func (lo *LearningOptimizer) AccuracyReport(ctx context.Context, days int) (*AccuracyReport, error) {
+	if err := lo.ensurePerformanceHistoryTable(ctx); err != nil {
+		return nil, err
+	}
+	since := time.Now().AddDate(0, 0, -days)
+
+	rows, err := lo.db.QueryContext(ctx, `
+	SELECT strategy, query_pattern, timestamp,
+		predicted_speedup, actual_speedup, predicted_error, actual_error,
+		table_size, error_tolerance, query_features
+	FROM ml_query_performance_history
+	WHERE timestamp > `+storage.ActiveDialect().DaysAgo(days)+`
+	ORDER BY timestamp`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type groupKey struct{ strategy, table string }
+	all := make(map[groupKey][]accuracySample)
+	daily := make(map[groupKey]map[string][]accuracySample)
+	var runs []transferRun
+	for rows.Next() {
+		var strategy, pattern string
+		var ts time.Time
+		var s accuracySample
+		var h QueryPerformanceHistory
+		var featuresJSON sql.NullString
+		if err := rows.Scan(&strategy, &pattern, &ts, &s.predictedSpeedup, &s.actualSpeedup, &s.predictedError, &s.actualError,
+			&h.TableSize, &h.ErrorTolerance, &featuresJSON); err != nil {
+			return nil, err
+		}
+		h.QueryFeatures = featuresJSON.String
+		table := "unknown"
+		if m := tableRe.FindStringSubmatch(pattern); len(m) > 1 {
+			table = m[1]
+		}
+		k := groupKey{strategy, table}
+		all[k] = append(all[k], s)
+		runs = append(runs, transferRun{
+			table: table, strategy: strategy, tableSize: h.TableSize, tolerance: h.ErrorTolerance,
+			speedup: s.actualSpeedup, features: historyFeatures(&h),
+		})
+		if daily[k] == nil {
+			daily[k] = make(map[string][]accuracySample)
+		}
+		day := ts.UTC().Format("2006-01-02")
+		daily[k][day] = append(daily[k][day], s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	report := &AccuracyReport{Since: since, Groups: make([]AccuracyGroup, 0, len(all)), Transfer: evaluateTransfer(runs)}
+	for k, samples := range all {
+		g := AccuracyGroup{Strategy: k.strategy, Table: k.table, Queries: len(samples)}
+		g.PredictedSpeedup = percentilesOf(samples, func(s accuracySample) float64 { return s.predictedSpeedup })
+		g.ActualSpeedup = percentilesOf(samples, func(s accuracySample) float64 { return s.actualSpeedup })
+		g.SpeedupRatio = percentilesOf(samples, speedupRatio)
+		g.PredictedError = percentilesOf(samples, func(s accuracySample) float64 { return s.predictedError })
+		g.ActualError = percentilesOf(samples, func(s accuracySample) float64 { return s.actualError })
+		g.Overpredicted = g.SpeedupRatio.P50 < overpredictionRatio
+
+		dayNames := make([]string, 0, len(daily[k]))
+		for d := range daily[k] {
+			dayNames = append(dayNames, d)
+		}
+		sort.Strings(dayNames)
+		for _, d := range dayNames {
+			ds := daily[k][d]
+			g.Daily = append(g.Daily, AccuracyPoint{
+				Day:              d,
+				Queries:          len(ds),
+				PredictedSpeedup: percentilesOf(ds, func(s accuracySample) float64 { return s.predictedSpeedup }).P50,
+				ActualSpeedup:    percentilesOf(ds, func(s accuracySample) float64 { return s.actualSpeedup }).P50,
+				PredictedError:   percentilesOf(ds, func(s accuracySample) float64 { return s.predictedError }).P50,
+				ActualError:      percentilesOf(ds, func(s accuracySample) float64 { return s.actualError }).P50,
+			})
+		}
+		report.Groups = append(report.Groups, g)
+	}
+	sort.Slice(report.Groups, func(i, j int) bool {
+		if report.Groups[i].Strategy != report.Groups[j].Strategy {
+			return report.Groups[i].Strategy < report.Groups[j].Strategy
+		}
+		return report.Groups[i].Table < report.Groups[j].Table
+	})
+	return report, nil
+}
+
+func speedupRatio(s accuracySample) float64 {
+	if s.predictedSpeedup <= 0 {
+		return 1
+	}
+	return s.actualSpeedup / s.predictedSpeedup
+}
+
+// percentilesOf uses nearest-rank percentiles over the values picked from samples.
+func percentilesOf(samples []accuracySample, pick func(accuracySample) float64) Percentiles {
+	if len(samples) == 0 {
+		return Percentiles{}
+	}
+	vals := make([]float64, 0, len(samples))
+	sum := 0.0
+	for _, s := range samples {
+		v := pick(s)
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		vals = append(vals, v)
+		sum += v
+	}
+	if len(vals) == 0 {
+		return Percentiles{}
+	}
+	sort.Float64s(vals)
+	rank := func(p float64) float64 {
+		i := int(math.Ceil(p*float64(len(vals)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return vals[i]
+	}
+	return Percentiles{Mean: sum / float64(len(vals)), P50: rank(0.50), P90: rank(0.90), P99: rank(0.99)}
+}
@@ -0,0 +1,127 @@
+package ml
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+)
+
+// Attribution targets: what a FeatureAttribution's Contribution moves.
+const (
+	TargetSpeedup     = "log10_speedup"   // log10 of the estimated speedup over exact
+	TargetError       = "estimated_error" // the plan's estimated relative error
+	TargetConfidence  = "confidence"      // the plan's confidence
+	TargetEligibility = "eligibility"     // whether the plan could be chosen at all
+)
+
+// FeatureAttribution is one feature's signed contribution to the chosen
+// plan, in the units of Target.
+type FeatureAttribution struct {
+	Feature      string  `json:"feature"`
+	Value        float64 `json:"value"`
+	Contribution float64 `json:"contribution"`
+	Target       string  `json:"target"`
+	Detail       string  `json:"detail,omitempty"`
+}
+
+// attributePlan returns the feature values behind p and how each one moved
+// the decision. speedup is the final estimated speedup over exact.
+func attributePlan(p *planner.Plan, speedup float64) (map[string]float64, []FeatureAttribution) {
+	features := map[string]float64{
+		"table_rows":      float64(p.TableRows),
+		"max_rel_error":   p.MaxRelError,
+		"estimated_error": p.EstimatedError,
+		"estimated_cost":  p.EstimatedCost,
+		"baseline_cost":   p.BaselineCost,
+		"history_runs":    float64(p.HistoryRuns),
+	}
+	if p.SampleFraction > 0 {
+		features["sample_fraction"] = p.SampleFraction
+	}
+	if p.Complexity != nil {
+		features["complexity_score"] = p.Complexity.Score
+	}
+
+	var out []FeatureAttribution
+
+	// the cost model's view: how much this table's size makes the plan pay off
+	modelCost := p.EstimatedCost
+	if p.HistoryRuns > 0 {
+		modelCost = p.ModelCost
+		features["model_cost"] = p.ModelCost
+		features["model_error"] = p.ModelError
+	}
+	modelSpeedup := 1.0
+	if p.BaselineCost > 0 && modelCost > 0 {
+		modelSpeedup = p.BaselineCost / modelCost
+	}
+	if p.TableRows > 0 {
+		out = append(out, FeatureAttribution{
+			Feature:      "table_rows",
+			Value:        float64(p.TableRows),
+			Contribution: math.Log10(modelSpeedup),
+			Target:       TargetSpeedup,
+			Detail:       fmt.Sprintf("cost model speedup %.1fx", modelSpeedup),
+		})
+	}
+
+	if p.HistoryRuns > 0 {
+		out = append(out,
+			FeatureAttribution{
+				Feature:      "history_runs",
+				Value:        float64(p.HistoryRuns),
+				Contribution: p.Confidence - 0.5,
+				Target:       TargetConfidence,
+				Detail:       p.ScoredBy,
+			},
+			FeatureAttribution{
+				Feature:      "learned_speedup_adjustment",
+				Value:        speedup / modelSpeedup,
+				Contribution: math.Log10(speedup / modelSpeedup),
+				Target:       TargetSpeedup,
+			},
+			FeatureAttribution{
+				Feature:      "learned_error_adjustment",
+				Value:        p.EstimatedError,
+				Contribution: p.EstimatedError - p.ModelError,
+				Target:       TargetError,
+				Detail:       fmt.Sprintf("model estimated %.4f", p.ModelError),
+			})
+	}
+
+	if p.MaxRelError > 0 && p.Type != planner.PlanExact {
+		headroom := (p.MaxRelError - p.EstimatedError) / p.MaxRelError
+		out = append(out, FeatureAttribution{
+			Feature:      "max_rel_error",
+			Value:        p.MaxRelError,
+			Contribution: headroom,
+			Target:       TargetEligibility,
+			Detail:       fmt.Sprintf("estimated error uses %.0f%% of the tolerance", (1-headroom)*100),
+		})
+	}
+
+	if p.Complexity != nil {
+		contribution := 0.0
+		if p.Fallback == "too_complex" {
+			contribution = -1
+		}
+		out = append(out, FeatureAttribution{
+			Feature:      "complexity_score",
+			Value:        p.Complexity.Score,
+			Contribution: contribution,
+			Target:       TargetEligibility,
+		})
+	}
+
+	if p.Fallback != "" && p.Fallback != "too_complex" {
+		out = append(out, FeatureAttribution{
+			Feature:      "fallback",
+			Contribution: -1,
+			Target:       TargetEligibility,
+			Detail:       p.Fallback,
+		})
+	}
+
+	return features, out
+}
@@ -0,0 +1,80 @@
+package ml
+
+import (
+	"context"
+	"sort"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// ToleranceGroup is how one strategy's verified answers on one table
+// measured up to the tolerance each was promised. A breach is a verified
+// error above the answer's max_rel_error.
+type ToleranceGroup struct {
+	Strategy      string      `json:"strategy"`
+	Table         string      `json:"table"`
+	VerifiedRuns  int         `json:"verified_runs"`
+	Breaches      int         `json:"breaches"`
+	BreachRate    float64     `json:"breach_rate"`
+	MeasuredError Percentiles `json:"measured_error"`
+	Tolerance     Percentiles `json:"tolerance"`
+}
+
+// ToleranceAudit groups the approximate answers of the last days days that
+// the verifier measured, and that were given a tolerance, by strategy and
+// table.
+func (lo *LearningOptimizer) ToleranceAudit(ctx context.Context, days int) ([]ToleranceGroup, error) {
+	if err := lo.ensurePerformanceHistoryTable(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := lo.db.QueryContext(ctx, `
+	SELECT strategy, query_pattern, actual_error, error_tolerance
+	FROM ml_query_performance_history
+	WHERE verified = TRUE AND strategy <> 'exact' AND error_tolerance > 0
+		AND timestamp > `+storage.ActiveDialect().DaysAgo(days))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type groupKey struct{ strategy, table string }
+	all := make(map[groupKey][]accuracySample)
+	for rows.Next() {
+		var strategy, pattern string
+		var s accuracySample
+		if err := rows.Scan(&strategy, &pattern, &s.actualError, &s.predictedError); err != nil {
+			return nil, err
+		}
+		table := "unknown"
+		if m := tableRe.FindStringSubmatch(pattern); len(m) > 1 {
+			table = m[1]
+		}
+		k := groupKey{strategy, table}
+		all[k] = append(all[k], s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// predictedError holds the tolerance the answer was promised
+	groups := make([]ToleranceGroup, 0, len(all))
+	for k, samples := range all {
+		g := ToleranceGroup{Strategy: k.strategy, Table: k.table, VerifiedRuns: len(samples)}
+		for _, s := range samples {
+			if s.actualError > s.predictedError {
+				g.Breaches++
+			}
+		}
+		g.BreachRate = float64(g.Breaches) / float64(g.VerifiedRuns)
+		g.MeasuredError = percentilesOf(samples, func(s accuracySample) float64 { return s.actualError })
+		g.Tolerance = percentilesOf(samples, func(s accuracySample) float64 { return s.predictedError })
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Strategy != groups[j].Strategy {
+			return groups[i].Strategy < groups[j].Strategy
+		}
+		return groups[i].Table < groups[j].Table
+	})
+	return groups, nil
+}
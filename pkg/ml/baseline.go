@@ -0,0 +1,39 @@
+package ml
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// RecordBaseline stores a measured exact-execution time for sqlText's
+// pattern (see WithQueryPattern), replacing any earlier measurement.
+func (lo *LearningOptimizer) RecordBaseline(ctx context.Context, sqlText string, elapsed time.Duration) error {
+	if err := lo.ensurePerformanceHistoryTable(ctx); err != nil {
+		return err
+	}
+	_, err := lo.db.ExecContext(ctx, `
+	INSERT INTO ml_pattern_baselines (query_pattern, baseline_ms, observed_at)
+	VALUES (?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT(query_pattern) DO UPDATE SET
+		baseline_ms = excluded.baseline_ms,
+		observed_at = CURRENT_TIMESTAMP`,
+		lo.queryPattern(ctx, sqlText), float64(elapsed)/float64(time.Millisecond))
+	return err
+}
+
+// ObservedBaseline returns the last measured exact-execution time for
+// sqlText's pattern, if a shadow run has recorded one.
+func (lo *LearningOptimizer) ObservedBaseline(ctx context.Context, sqlText string) (time.Duration, bool) {
+	if err := lo.ensurePerformanceHistoryTable(ctx); err != nil {
+		return 0, false
+	}
+	var ms float64
+	err := lo.db.QueryRowContext(ctx, `
+	SELECT baseline_ms FROM ml_pattern_baselines WHERE query_pattern = ?`,
+		lo.queryPattern(ctx, sqlText)).Scan(&ms)
+	if err == sql.ErrNoRows || err != nil {
+		return 0, false
+	}
+	return time.Duration(ms * float64(time.Millisecond)), true
+}
@@ -0,0 +1,143 @@
+package ml
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/sketches"
+)
+
+// digestCompression bounds the centroids per compacted distribution.
+const digestCompression = 100
+
+// patternDigest is the compacted long-term history of one strategy on one
+// query pattern.
+type patternDigest struct {
+	Speedup *sketches.TDigest
+	Error   *sketches.TDigest
+}
+
+// compactHistory folds the history rows matched by where (a condition on
+// ml_query_performance_history) into per-pattern, per-strategy t-digests and
+// then deletes them, so their distribution outlives the rows.
+func (lo *LearningOptimizer) compactHistory(ctx context.Context, where string, args ...any) (int64, error) {
+	tx, err := lo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+	SELECT query_pattern, strategy, actual_speedup, actual_error
+	FROM ml_query_performance_history
+	WHERE `+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	type digestKey struct{ pattern, strategy string }
+	fresh := make(map[digestKey]*patternDigest)
+	for rows.Next() {
+		var k digestKey
+		var speedup, actualErr float64
+		if err := rows.Scan(&k.pattern, &k.strategy, &speedup, &actualErr); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		d := fresh[k]
+		if d == nil {
+			d = &patternDigest{Speedup: sketches.NewTDigest(digestCompression), Error: sketches.NewTDigest(digestCompression)}
+			fresh[k] = d
+		}
+		d.Speedup.Add(speedup)
+		d.Error.Add(actualErr)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for k, d := range fresh {
+		existing, err := loadPatternDigest(ctx, tx, k.pattern, k.strategy)
+		if err != nil {
+			return 0, err
+		}
+		if existing != nil {
+			d.Speedup.Merge(existing.Speedup)
+			d.Error.Merge(existing.Error)
+		}
+		if _, err := tx.ExecContext(ctx, `
+		INSERT INTO ml_pattern_digests (query_pattern, strategy, observations, speedup_digest, error_digest, updated_at)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(query_pattern, strategy) DO UPDATE SET
+			observations = excluded.observations,
+			speedup_digest = excluded.speedup_digest,
+			error_digest = excluded.error_digest,
+			updated_at = CURRENT_TIMESTAMP`,
+			k.pattern, k.strategy, int64(d.Speedup.Count()), d.Speedup.Serialize(), d.Error.Serialize()); err != nil {
+			return 0, fmt.Errorf("storing digest: %w", err)
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM ml_query_performance_history WHERE `+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	n, _ := result.RowsAffected()
+	return n, nil
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func loadPatternDigest(ctx context.Context, q queryRower, pattern, strategy string) (*patternDigest, error) {
+	var speedupData, errorData []byte
+	err := q.QueryRowContext(ctx, `
+	SELECT speedup_digest, error_digest FROM ml_pattern_digests
+	WHERE query_pattern = ? AND strategy = ?`, pattern, strategy).Scan(&speedupData, &errorData)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	speedup, err := sketches.DeserializeTDigest(speedupData)
+	if err != nil {
+		return nil, err
+	}
+	errDigest, err := sketches.DeserializeTDigest(errorData)
+	if err != nil {
+		return nil, err
+	}
+	return &patternDigest{Speedup: speedup, Error: errDigest}, nil
+}
+
+// patternDigests returns the compacted history of every strategy seen for
+// pattern, keyed by strategy.
+func (lo *LearningOptimizer) patternDigests(ctx context.Context, pattern string) map[OptimizationStrategy]*patternDigest {
+	rows, err := lo.db.QueryContext(ctx, `
+	SELECT strategy FROM ml_pattern_digests WHERE query_pattern = ?`, pattern)
+	if err != nil {
+		return nil
+	}
+	var strategies []string
+	for rows.Next() {
+		var s string
+		if rows.Scan(&s) == nil {
+			strategies = append(strategies, s)
+		}
+	}
+	rows.Close()
+
+	out := make(map[OptimizationStrategy]*patternDigest, len(strategies))
+	for _, s := range strategies {
+		if d, err := loadPatternDigest(ctx, lo.db, pattern, s); err == nil && d != nil {
+			out[OptimizationStrategy(s)] = d
+		}
+	}
+	return out
+}
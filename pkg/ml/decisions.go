@@ -0,0 +1,249 @@
+package ml
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+)
+
+// Decision is what a query pattern's last plan was chosen on: the strategy
+// it ran with and the factors that decide between strategies.
+type Decision struct {
+	Strategy string `json:"strategy"`
+	// Synopsis is the sample table, or the sketch and its column, read.
+	Synopsis        string            `json:"synopsis,omitempty"`
+	TableRows       int64             `json:"table_rows,omitempty"`
+	Selectivity     float64           `json:"selectivity,omitempty"`
+	MaxRelError     float64           `json:"max_rel_error"`
+	ConfidenceLevel float64           `json:"confidence_level"`
+	EstimatedCost   float64           `json:"estimated_cost"`
+	EstimatedError  float64           `json:"estimated_error"`
+	HistoryRuns     int               `json:"history_runs,omitempty"`
+	ScoredBy        string            `json:"scored_by,omitempty"`
+	Fallback        string            `json:"fallback,omitempty"`
+	StrategiesOff   map[string]string `json:"strategies_off,omitempty"`
+	Escalated       bool              `json:"escalated,omitempty"`
+	Reason          string            `json:"reason"`
+	DecidedAt       time.Time         `json:"decided_at"`
+}
+
+// FactorChange is a decisive factor that moved between two decisions.
+type FactorChange struct {
+	Factor string  `json:"factor"`
+	From   float64 `json:"from"`
+	To     float64 `json:"to"`
+}
+
+// StrategyChange explains why a pattern ran with a different strategy than
+// last time. Because lists the changes that account for it, most decisive
+// first; Note says it in one sentence.
+type StrategyChange struct {
+	From     string         `json:"from"`
+	To       string         `json:"to"`
+	Previous time.Time      `json:"previous_run"`
+	Because  []string       `json:"because"`
+	Factors  []FactorChange `json:"factors,omitempty"`
+	Note     string         `json:"note"`
+}
+
+// PlanDecision describes p as a Decision.
+func PlanDecision(p *planner.Plan) *Decision {
+	d := &Decision{
+		Strategy:        planStrategy(p),
+		TableRows:       p.TableRows,
+		Selectivity:     p.Selectivity,
+		MaxRelError:     p.MaxRelError,
+		ConfidenceLevel: p.Level(),
+		EstimatedCost:   p.EstimatedCost,
+		EstimatedError:  p.EstimatedError,
+		HistoryRuns:     p.HistoryRuns,
+		ScoredBy:        p.ScoredBy,
+		Fallback:        p.Fallback,
+		StrategiesOff:   p.StrategiesOff,
+		Escalated:       p.Escalated,
+		Reason:          p.Reason,
+		DecidedAt:       time.Now().UTC(),
+	}
+	switch {
+	case p.SampleTable != "":
+		d.Synopsis = p.SampleTable
+	case p.SketchType != "":
+		d.Synopsis = p.SketchType + " on " + p.SketchColumn
+	}
+	return d
+}
+
+// planStrategy names the strategy p runs with: one of planner.Strategies,
+// "exact" or "passthrough".
+func planStrategy(p *planner.Plan) string {
+	switch {
+	case p.Passthrough:
+		return "passthrough"
+	case p.Type == planner.PlanHybrid:
+		return planner.StrategyInlineSketch
+	case p.Type == planner.PlanSketch && p.SketchJoin != nil:
+		return planner.StrategySketchJoin
+	case p.Type == planner.PlanSketch:
+		return planner.StrategySketch
+	case p.Type == planner.PlanSample && p.WanderJoin != nil:
+		return planner.StrategyWanderJoin
+	case p.Type == planner.PlanSample && p.JoinSynopsis != "":
+		return planner.StrategyJoinSynopsis
+	case p.Type == planner.PlanSample && p.JoinSampleTable != "":
+		return planner.StrategyUniverseJoin
+	case p.Type == planner.PlanSample:
+		return planner.StrategySample
+	case p.TrackedCount:
+		return planner.StrategyTrackedCount
+	case p.BloomJoin != nil:
+		return planner.StrategyBloomJoin
+	default:
+		return string(planner.PlanExact)
+	}
+}
+
+// NoteDecision records the decision behind p, the plan sqlText's pattern
+// (see WithQueryPattern) just ran with, and returns why its strategy
+// changed since the pattern's last run, or nil if it didn't.
+func (lo *LearningOptimizer) NoteDecision(ctx context.Context, sqlText string, p *planner.Plan) (*StrategyChange, error) {
+	if err := lo.ensurePerformanceHistoryTable(ctx); err != nil {
+		return nil, err
+	}
+	pattern := lo.queryPattern(ctx, sqlText)
+	cur := PlanDecision(p)
+
+	var prev *Decision
+	var stored string
+	err := lo.db.QueryRowContext(ctx, `
+	SELECT decision FROM ml_pattern_decisions WHERE query_pattern = ?`, pattern).Scan(&stored)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return nil, err
+	default:
+		prev = &Decision{}
+		if json.Unmarshal([]byte(stored), prev) != nil {
+			prev = nil // written by an older layout: start over
+		}
+	}
+
+	data, err := json.Marshal(cur)
+	if err != nil {
+		return nil, err
+	}
+	_, err = lo.db.ExecContext(ctx, `
+	INSERT INTO ml_pattern_decisions (query_pattern, strategy, decision, decided_at)
+	VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT(query_pattern) DO UPDATE SET
+		strategy = excluded.strategy,
+		decision = excluded.decision,
+		decided_at = CURRENT_TIMESTAMP`,
+		pattern, cur.Strategy, string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if prev == nil || prev.Strategy == cur.Strategy {
+		return nil, nil
+	}
+	return explainChange(prev, cur), nil
+}
+
+// explainChange compares the decisions behind two runs of a pattern that
+// chose different strategies.
+func explainChange(prev, cur *Decision) *StrategyChange {
+	c := &StrategyChange{From: prev.Strategy, To: cur.Strategy, Previous: prev.DecidedAt}
+	because := func(format string, args ...any) {
+		c.Because = append(c.Because, fmt.Sprintf(format, args...))
+	}
+
+	// what rules strategies in or out comes first, then what weighs them
+	if cur.Escalated {
+		because("the sampled answer missed its tolerance and was escalated")
+	}
+	if cur.Fallback != "" && cur.Fallback != prev.Fallback {
+		because("the query now falls back to exact (%s)", cur.Fallback)
+	}
+	if why, off := cur.StrategiesOff[prev.Strategy]; off {
+		because("%s was switched off (%s)", prev.Strategy, why)
+	}
+	if _, wasOff := prev.StrategiesOff[cur.Strategy]; wasOff {
+		if _, off := cur.StrategiesOff[cur.Strategy]; !off {
+			because("%s was switched back on", cur.Strategy)
+		}
+	}
+	if prev.Synopsis != "" && cur.Synopsis != "" && prev.Synopsis != cur.Synopsis {
+		because("%s was read instead of %s", cur.Synopsis, prev.Synopsis)
+	}
+	if prev.MaxRelError != cur.MaxRelError {
+		because("the tolerance changed from %s to %s", percent(prev.MaxRelError), percent(cur.MaxRelError))
+	}
+	if prev.ConfidenceLevel != cur.ConfidenceLevel {
+		because("the confidence level changed from %s to %s", percent(prev.ConfidenceLevel), percent(cur.ConfidenceLevel))
+	}
+	if prev.TableRows > 0 && cur.TableRows > 0 && relChange(float64(prev.TableRows), float64(cur.TableRows)) > 0.1 {
+		verb := "grew"
+		if cur.TableRows < prev.TableRows {
+			verb = "shrank"
+		}
+		because("the table %s from %d to %d rows", verb, prev.TableRows, cur.TableRows)
+	}
+	if from, to := keptShare(prev.Selectivity), keptShare(cur.Selectivity); relChange(from, to) > 0.25 {
+		because("the WHERE clause is now estimated to keep %s of the rows, not %s", percent(to), percent(from))
+	}
+	switch {
+	case cur.ScoredBy != "" && prev.ScoredBy == "":
+		because("learned history from %d runs now adjusts the estimates", cur.HistoryRuns)
+	case cur.ScoredBy == "" && prev.ScoredBy != "":
+		because("learned history no longer adjusts the estimates")
+	case cur.ScoredBy != "" && cur.HistoryRuns != prev.HistoryRuns:
+		because("learned history grew from %d to %d runs", prev.HistoryRuns, cur.HistoryRuns)
+	}
+	if len(c.Because) == 0 {
+		because("the candidates' estimates changed: %s", cur.Reason)
+	}
+
+	factors := []FactorChange{
+		{"table_rows", float64(prev.TableRows), float64(cur.TableRows)},
+		{"selectivity", keptShare(prev.Selectivity), keptShare(cur.Selectivity)},
+		{"max_rel_error", prev.MaxRelError, cur.MaxRelError},
+		{"confidence_level", prev.ConfidenceLevel, cur.ConfidenceLevel},
+		{"estimated_cost", prev.EstimatedCost, cur.EstimatedCost},
+		{"estimated_error", prev.EstimatedError, cur.EstimatedError},
+		{"history_runs", float64(prev.HistoryRuns), float64(cur.HistoryRuns)},
+	}
+	for _, f := range factors {
+		if f.From != f.To {
+			c.Factors = append(c.Factors, f)
+		}
+	}
+	c.Note = fmt.Sprintf("strategy changed from %s to %s because %s", prev.Strategy, cur.Strategy, strings.Join(c.Because, "; "))
+	return c
+}
+
+// keptShare is the share of rows a plan's Selectivity says the WHERE
+// clause keeps; 0 stands for all of them.
+func keptShare(selectivity float64) float64 {
+	if selectivity <= 0 {
+		return 1
+	}
+	return selectivity
+}
+
+// relChange is how far to moved from from, relative to from.
+func relChange(from, to float64) float64 {
+	if from == 0 {
+		return math.Abs(to)
+	}
+	return math.Abs(to-from) / math.Abs(from)
+}
+
+func percent(f float64) string {
+	return fmt.Sprintf("%g%%", math.Round(f*10000)/100)
+}
@@ -0,0 +1,306 @@
+package ml
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/aqeerr"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+)
+
+// JoinStrategyChain is the strategy of a join of three or more tables
+// whose edges are not all exact; each edge's own strategy is in Edges.
+const JoinStrategyChain JoinOptimizationStrategy = "join_chain"
+
+// JoinChain is a join of three or more tables: Tables in FROM order and
+// one JoinInfo per JOIN, whose LeftTable is the earlier table its ON
+// condition references.
+type JoinChain struct {
+	Tables []string
+	Edges  []JoinInfo
+}
+
+// extractJoinChain reads every join of sql's main SELECT. Each joined
+// table must be named, not derived, joined with ON and appear once, so
+// its rewrite is unambiguous.
+func (jo *JoinOptimizer) extractJoinChain(sql string) (*JoinChain, error) {
+	q, err := planner.Parse(sql)
+	if err != nil {
+		return nil, err
+	}
+	if len(q.With) > 0 || len(q.Selects) != 1 {
+		return nil, fmt.Errorf("%w: join chains are read from a single SELECT", aqeerr.ErrUnsupportedQuery)
+	}
+	s := q.Main()
+	chain := &JoinChain{}
+	seen := make(map[string]bool)
+	for i, ref := range s.From {
+		if ref.Subquery != nil || seen[strings.ToLower(ref.Name)] {
+			return nil, fmt.Errorf("%w: join chains need distinct named tables", aqeerr.ErrUnsupportedQuery)
+		}
+		seen[strings.ToLower(ref.Name)] = true
+		chain.Tables = append(chain.Tables, ref.Name)
+		if i == 0 {
+			continue
+		}
+		if !strings.Contains(strings.ToUpper(ref.Join), "JOIN") || ref.On == nil {
+			return nil, fmt.Errorf("%w: %s is not joined with ON", aqeerr.ErrUnsupportedQuery, ref.Name)
+		}
+		// the condition names the earlier table it joins; else the one before
+		left := s.From[i-1]
+		for _, c := range ref.On.Columns {
+			qualifier, _, ok := strings.Cut(c, ".")
+			if !ok {
+				continue
+			}
+			for _, earlier := range s.From[:i] {
+				if strings.EqualFold(qualifier, earlier.Alias) || earlier.Alias == "" && strings.EqualFold(qualifier, earlier.Name) {
+					left = earlier
+				}
+			}
+		}
+		chain.Edges = append(chain.Edges, JoinInfo{
+			JoinType:      strings.Join(strings.Fields(strings.ToUpper(ref.Join)), " "),
+			LeftTable:     left.Name,
+			RightTable:    ref.Name,
+			LeftAlias:     left.Alias,
+			RightAlias:    ref.Alias,
+			JoinCondition: strings.TrimSpace(ref.On.Text),
+		})
+	}
+	return chain, nil
+}
+
+// chainRead is how a join chain's rewrite reads one table: through source,
+// a sample, filter or synopsis standing in for it, keeping fraction of its
+// rows, of which passRate reach the join. joint is set when the edge that
+// chose it samples its two tables together, at the edge's fraction.
+type chainRead struct {
+	source   string
+	fraction float64
+	passRate float64
+	joint    bool
+}
+
+// analyzeJoinChain picks a strategy for each edge of chain as for a join
+// of its two tables, then rewrites sql to read each table the way the
+// first edge that samples, filters or replaces it chose. An edge whose
+// table an earlier edge already rewrote joins it as read. Sketch joins,
+// which the planner runs for two tables only, are not considered.
+func (jo *JoinOptimizer) analyzeJoinChain(ctx context.Context, sql string, chain *JoinChain) *JoinAnalysis {
+	sizes := make(map[string]int64)
+	size := func(table string) int64 {
+		key := strings.ToLower(table)
+		if _, ok := sizes[key]; !ok {
+			sizes[key] = jo.getTableSize(ctx, table)
+		}
+		return sizes[key]
+	}
+
+	reads := make(map[string]*chainRead)
+	var joint []float64 // fractions of the edges sampling both tables together
+	analysis := &JoinAnalysis{
+		JoinType:   fmt.Sprintf("%d-way join", len(chain.Tables)),
+		Tables:     chain.Tables,
+		Confidence: 0.5,
+	}
+	for _, info := range chain.Edges {
+		e := &JoinAnalysis{
+			JoinType:       info.JoinType,
+			LeftTable:      info.LeftTable,
+			RightTable:     info.RightTable,
+			LeftAlias:      info.LeftAlias,
+			RightAlias:     info.RightAlias,
+			JoinCondition:  info.JoinCondition,
+			LeftTableSize:  size(info.LeftTable),
+			RightTableSize: size(info.RightTable),
+			Confidence:     0.5,
+		}
+		e.Selectivity = jo.estimateJoinSelectivity(ctx, e)
+		jo.joinBloom(ctx, e)
+		e.LeftSample, e.RightSample, e.UniverseFraction = jo.joinUniverse(ctx, e)
+		jo.joinSynopsis(ctx, "", e)
+		e.Strategy = jo.chooseJoinStrategy(e)
+		e.LeftFraction, e.RightFraction = jo.joinSampleFractions(e)
+
+		if taken := jo.chainReads(e); len(taken) > 0 {
+			for _, t := range taken {
+				if reads[strings.ToLower(t.table)] != nil {
+					e.Strategy = JoinStrategyExact
+					e.LeftFraction, e.RightFraction = 1, 1
+					break
+				}
+			}
+			if e.Strategy != JoinStrategyExact {
+				for _, t := range taken {
+					reads[strings.ToLower(t.table)] = t.read
+				}
+				if taken[0].read.joint {
+					joint = append(joint, taken[0].read.fraction)
+				}
+			}
+		}
+		e.EstimatedSpeedup = jo.calculateJoinSpeedup(e)
+		e.EstimatedError = jo.calculateJoinError(e)
+		e.Reasoning = jo.baseJoinReasoning(e)
+		analysis.Edges = append(analysis.Edges, e)
+	}
+
+	optimized := sql
+	p := 1.0
+	for i, table := range chain.Tables {
+		r := reads[strings.ToLower(table)]
+		if r == nil {
+			continue
+		}
+		keyword := "JOIN"
+		if i == 0 {
+			keyword = "FROM"
+		}
+		optimized = universeRef(optimized, keyword, table, r.source)
+		if !r.joint {
+			p *= r.fraction
+		}
+	}
+	for _, f := range joint {
+		p *= f
+	}
+	analysis.OptimizedSQL = optimized
+
+	analysis.Strategy = JoinStrategyExact
+	for _, e := range analysis.Edges {
+		if e.Strategy != JoinStrategyExact {
+			analysis.Strategy = JoinStrategyChain
+		}
+	}
+	analysis.EstimatedSpeedup = jo.chainSpeedup(chain, sizes, reads)
+	analysis.EstimatedError = chainError(chain, sizes, p)
+	analysis.Reasoning = chainReasoning(analysis)
+	return analysis
+}
+
+type chainTableRead struct {
+	table string
+	read  *chainRead
+}
+
+// chainReads is how e's strategy reads its tables in a chain's rewrite;
+// none for strategies that read both as they are.
+func (jo *JoinOptimizer) chainReads(e *JoinAnalysis) []chainTableRead {
+	sample := func(table string, size int64, fraction float64) chainTableRead {
+		n := jo.calculateSampleSize(size, fraction)
+		kept := 1.0
+		if size > 0 {
+			kept = float64(n) / float64(size)
+		}
+		return chainTableRead{table, &chainRead{
+			source:   fmt.Sprintf("(SELECT * FROM %s ORDER BY RANDOM() LIMIT %d)", table, n),
+			fraction: kept,
+			passRate: 1,
+		}}
+	}
+
+	switch e.Strategy {
+	case JoinStrategySampleLarger:
+		if e.LeftTableSize > e.RightTableSize {
+			return []chainTableRead{sample(e.LeftTable, e.LeftTableSize, sampleLargerFraction)}
+		}
+		return []chainTableRead{sample(e.RightTable, e.RightTableSize, sampleLargerFraction)}
+	case JoinStrategySampleBoth:
+		return []chainTableRead{
+			sample(e.LeftTable, e.LeftTableSize, sampleBothFraction),
+			sample(e.RightTable, e.RightTableSize, sampleBothFraction),
+		}
+	case JoinStrategyBloomFilter:
+		smaller, larger := e.RightTable, e.LeftTable
+		if e.LeftTableSize < e.RightTableSize {
+			smaller, larger = e.LeftTable, e.RightTable
+		}
+		return []chainTableRead{{larger, &chainRead{
+			source:   planner.BloomFilterSource(larger, e.BloomProbeKey, smaller, e.BloomColumn),
+			fraction: 1,
+			passRate: e.BloomPassRate,
+		}}}
+	case JoinStrategyUniverse:
+		return []chainTableRead{
+			{e.LeftTable, &chainRead{source: e.LeftSample, fraction: e.UniverseFraction, passRate: 1, joint: true}},
+			{e.RightTable, &chainRead{source: e.RightSample, fraction: e.UniverseFraction, passRate: 1, joint: true}},
+		}
+	case JoinStrategySynopsis:
+		return []chainTableRead{
+			{e.LeftTable, &chainRead{source: e.SynopsisLeft, fraction: e.SynopsisFraction, passRate: 1, joint: true}},
+			{e.RightTable, &chainRead{source: e.SynopsisRight, fraction: e.SynopsisFraction, passRate: 1, joint: true}},
+		}
+	}
+	return nil
+}
+
+// chainSpeedup is the cost model of calculateJoinSpeedup over a chain:
+// each table is read as its rewrite reads it, then the joins run in FROM
+// order, each producing about one row per row of its larger input.
+func (jo *JoinOptimizer) chainSpeedup(chain *JoinChain, sizes map[string]int64, reads map[string]*chainRead) float64 {
+	exact, approx := 0.0, 0.0
+	var exactRows, approxRows float64
+	for i, table := range chain.Tables {
+		n := float64(sizes[strings.ToLower(table)])
+		kept := n
+		exact += n
+		switch r := reads[strings.ToLower(table)]; {
+		case r == nil:
+			approx += n
+		case r.joint:
+			kept = n * r.fraction
+			approx += kept
+		case r.fraction < 1:
+			kept = n * r.fraction
+			approx += n * sampledScanCost
+		default:
+			kept = n * r.passRate
+			approx += n
+		}
+		if i == 0 {
+			exactRows, approxRows = n, kept
+			continue
+		}
+		exact += joinWork(exactRows, n)
+		approx += joinWork(approxRows, kept)
+		exactRows, approxRows = math.Max(exactRows, n), math.Max(approxRows, kept)
+	}
+	if approx <= 0 || exact <= approx {
+		return 1.0
+	}
+	return exact / approx
+}
+
+// chainError is calculateJoinError's binomial model over a chain: an
+// output row survives with probability p, the product of the fractions the
+// rewrite keeps, over about as many rows as the largest table.
+func chainError(chain *JoinChain, sizes map[string]int64, p float64) float64 {
+	if p >= 1 {
+		return 0.0
+	}
+	out := 0.0
+	for _, table := range chain.Tables {
+		out = math.Max(out, float64(sizes[strings.ToLower(table)]))
+	}
+	if out < 1 || p <= 0 {
+		return 1.0
+	}
+	return math.Sqrt((1 - p) / (p * out))
+}
+
+func chainReasoning(analysis *JoinAnalysis) string {
+	edges := make([]string, len(analysis.Edges))
+	for i, e := range analysis.Edges {
+		edges[i] = fmt.Sprintf("%s-%s: %s", e.LeftTable, e.RightTable, e.Strategy)
+	}
+	if analysis.Strategy == JoinStrategyExact {
+		return fmt.Sprintf("%s of %s - every join runs exactly (%s)",
+			analysis.JoinType, strings.Join(analysis.Tables, ", "), strings.Join(edges, "; "))
+	}
+	return fmt.Sprintf("%s of %s - per-join strategies %s combine to %.1fx speedup with %.1f%% error",
+		analysis.JoinType, strings.Join(analysis.Tables, ", "), strings.Join(edges, "; "),
+		analysis.EstimatedSpeedup, analysis.EstimatedError*100)
+}
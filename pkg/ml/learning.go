@@ -8,7 +8,17 @@ import (
 	"log"
 	"math"
 	"regexp"
+	"sync"
 	"time"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/aqeerr"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+var (
+	numberLiteralRe = regexp.MustCompile(`\b\d+\b`)
+	stringLiteralRe = regexp.MustCompile(`'[^']*'`)
+	quotedLiteralRe = regexp.MustCompile(`"[^"]*"`)
 )
 
 type QueryPerformanceHistory struct {
@@ -27,18 +37,51 @@ type QueryPerformanceHistory struct {
 	QueryFeatures    string    `json:"query_features"`
 	ImportanceScore  float64   `json:"importance_score,omitempty"`
 	Aggregated       bool      `json:"aggregated,omitempty"`
+	// Verified is set once ActualError is measured against an exact run
+	// rather than taken from the answer's own intervals.
+	Verified bool `json:"verified,omitempty"`
 }
 
+// LearningOptimizer is meant to be long-lived and shared across requests; it
+// is safe for concurrent use. The history schema is created lazily on first use.
 type LearningOptimizer struct {
 	*MLOptimizer
 	learningEnabled bool
+
+	schemaMu    sync.Mutex
+	schemaReady bool
+
+	failureMu sync.Mutex
+	failures  map[string]int64 // aqeerr category -> count
 }
 
 func NewLearningOptimizer(db *sql.DB) *LearningOptimizer {
 	return &LearningOptimizer{
 		MLOptimizer:     NewMLOptimizer(db),
 		learningEnabled: true,
+		failures:        make(map[string]int64),
+	}
+}
+
+// RecordFailure counts a planning or execution failure under its aqeerr
+// category so the stats endpoint can show why queries fell back or failed.
+func (lo *LearningOptimizer) RecordFailure(err error) {
+	if err == nil {
+		return
+	}
+	lo.failureMu.Lock()
+	lo.failures[aqeerr.Category(err)]++
+	lo.failureMu.Unlock()
+}
+
+func (lo *LearningOptimizer) failureCounts() map[string]int64 {
+	lo.failureMu.Lock()
+	defer lo.failureMu.Unlock()
+	out := make(map[string]int64, len(lo.failures))
+	for k, v := range lo.failures {
+		out[k] = v
 	}
+	return out
 }
 
 // ExtractQueryFeatures is a public wrapper around the private extractQueryFeatures method
@@ -46,12 +89,30 @@ func (lo *LearningOptimizer) ExtractQueryFeatures(ctx context.Context, sql strin
 	return lo.extractQueryFeatures(ctx, sql, errorTolerance)
 }
 
+// OptimizeQueryWithLearning produces its own rewritten SQL independently of
+// the planner.
+//
+// Deprecated: install the LearningOptimizer as a planner.Scorer instead so a
+// single pipeline makes the decision.
 func (lo *LearningOptimizer) OptimizeQueryWithLearning(ctx context.Context, originalSQL string, errorTolerance float64) (*QueryOptimization, error) {
 	features, err := lo.extractQueryFeatures(ctx, originalSQL, errorTolerance)
 	if err != nil {
+		lo.RecordFailure(err)
 		return lo.OptimizeQuery(ctx, originalSQL, errorTolerance)
 	}
 
+	if features.HasWindow || features.HasCorrelated {
+		return &QueryOptimization{
+			Strategy:         StrategyExact,
+			ModifiedSQL:      originalSQL,
+			OriginalSQL:      originalSQL,
+			Confidence:       0.99,
+			EstimatedSpeedup: 1.0,
+			Reasoning:        "Window function or correlated subquery detected; executing exactly",
+			Transformations:  make([]string, 0),
+		}, nil
+	}
+
 	joinOptimizer := NewJoinOptimizer(lo)
 	joinAnalysis, err := joinOptimizer.AnalyzeJoinQuery(ctx, originalSQL)
 	if err == nil && joinAnalysis != nil {
@@ -59,7 +120,7 @@ func (lo *LearningOptimizer) OptimizeQueryWithLearning(ctx context.Context, orig
 			Strategy:         OptimizationStrategy(joinAnalysis.Strategy),
 			ModifiedSQL:      joinAnalysis.OptimizedSQL,
 			OriginalSQL:      originalSQL,
-			Confidence:       0.85,
+			Confidence:       joinAnalysis.Confidence,
 			EstimatedSpeedup: joinAnalysis.EstimatedSpeedup,
 			EstimatedError:   joinAnalysis.EstimatedError,
 			Reasoning:        joinAnalysis.Reasoning,
@@ -96,16 +157,18 @@ func (lo *LearningOptimizer) OptimizeQueryWithLearning(ctx context.Context, orig
 	return optimization, nil
 }
 
-// RecordQueryPerformance stores actual execution results for learning with optimizations
+// RecordQueryPerformance stores actual execution results for learning with optimizations.
+// It returns the id of the history record, or 0 when the query was not recorded;
+// RecordVerifiedError later replaces actualError with a measured one.
 func (lo *LearningOptimizer) RecordQueryPerformance(ctx context.Context,
 	optimization *QueryOptimization,
 	features *QueryFeatures,
 	actualExecutionTime time.Duration,
 	actualError float64,
-	baselineExecutionTime time.Duration) error {
+	baselineExecutionTime time.Duration) (int64, error) {
 
 	if !lo.learningEnabled {
-		return nil
+		return 0, nil
 	}
 
 	// OPTIMIZATION 1: Sampling to reduce volume in high-traffic scenarios
@@ -121,13 +184,13 @@ func (lo *LearningOptimizer) RecordQueryPerformance(ctx context.Context,
 	// Always record if there's significant deviation from prediction, otherwise sample
 	shouldRecord := speedupDeviation > 0.5 || errorDeviation > 0.1 || (time.Now().Unix()%5 == 0)
 	if !shouldRecord {
-		return nil // Skip recording this query
+		return 0, nil // Skip recording this query
 	}
 
 	// Ensure the performance history table exists
 	if err := lo.ensurePerformanceHistoryTable(ctx); err != nil {
 		log.Printf("Warning: Could not create performance history table: %v", err)
-		return err
+		return 0, err
 	}
 
 	actualSpeedup := float64(baselineExecutionTime) / float64(actualExecutionTime)
@@ -135,7 +198,7 @@ func (lo *LearningOptimizer) RecordQueryPerformance(ctx context.Context,
 		actualSpeedup = 0.1 // Prevent division issues
 	}
 
-	queryPattern := lo.normalizeQueryPattern(optimization.OriginalSQL)
+	queryPattern := lo.queryPattern(ctx, optimization.OriginalSQL)
 	featuresJSON, _ := json.Marshal(features)
 
 	// Validate optimization values before storing
@@ -164,7 +227,7 @@ func (lo *LearningOptimizer) RecordQueryPerformance(ctx context.Context,
 		QueryFeatures:    string(featuresJSON),
 	}
 
-	result := lo.storePerformanceHistory(ctx, perf)
+	id, err := lo.storePerformanceHistory(ctx, perf)
 
 	// OPTIMIZATION 2: Periodic maintenance to prevent table growth
 	// Trigger maintenance every 100 recordings (approximately)
@@ -176,7 +239,20 @@ func (lo *LearningOptimizer) RecordQueryPerformance(ctx context.Context,
 		}()
 	}
 
-	return result
+	return id, err
+}
+
+// RecordVerifiedError replaces the actual error of history record id, as
+// RecordQueryPerformance returned it, with trueError, the relative error
+// of its answer measured against an exact run. Records compacted away in
+// the meantime are skipped.
+func (lo *LearningOptimizer) RecordVerifiedError(ctx context.Context, id int64, trueError float64) error {
+	if err := lo.ensurePerformanceHistoryTable(ctx); err != nil {
+		return err
+	}
+	_, err := lo.db.ExecContext(ctx, `UPDATE ml_query_performance_history
+		SET actual_error = ?, verified = TRUE WHERE id = ?`, trueError, id)
+	return err
 }
 
 // performDataMaintenance performs cleanup and aggregation of old ML learning data
@@ -206,7 +282,7 @@ func (lo *LearningOptimizer) performDataMaintenance(ctx context.Context) error {
 // aggregateOldData moves old detailed records into summary statistics
 func (lo *LearningOptimizer) aggregateOldData(ctx context.Context) error {
 	aggregateSQL := `
-	INSERT OR REPLACE INTO ml_query_performance_summary 
+	INSERT INTO ml_query_performance_summary 
 	(query_pattern, table_size_range, strategy, avg_speedup, avg_error, sample_count, last_updated, confidence_level)
 	SELECT 
 		query_pattern,
@@ -220,14 +296,14 @@ func (lo *LearningOptimizer) aggregateOldData(ctx context.Context) error {
 		AVG(actual_speedup) as avg_speedup,
 		AVG(actual_error) as avg_error,
 		COUNT(*) as sample_count,
-		datetime('now') as last_updated,
+		` + storage.ActiveDialect().Now() + ` as last_updated,
 		CASE 
 			WHEN COUNT(*) >= 10 THEN 0.9
 			WHEN COUNT(*) >= 5 THEN 0.7
 			ELSE 0.5
 		END as confidence_level
 	FROM ml_query_performance_history 
-	WHERE timestamp < datetime('now', '-30 days')
+	WHERE timestamp < ` + storage.ActiveDialect().DaysAgo(30) + `
 	AND aggregated = FALSE
 	GROUP BY query_pattern, table_size_range, strategy
 	HAVING COUNT(*) >= 3`
@@ -240,7 +316,7 @@ func (lo *LearningOptimizer) aggregateOldData(ctx context.Context) error {
 	markSQL := `
 	UPDATE ml_query_performance_history 
 	SET aggregated = TRUE 
-	WHERE timestamp < datetime('now', '-30 days')
+	WHERE timestamp < ` + storage.ActiveDialect().DaysAgo(30) + `
 	AND aggregated = FALSE`
 
 	if _, err := lo.db.ExecContext(ctx, markSQL); err != nil {
@@ -252,24 +328,43 @@ func (lo *LearningOptimizer) aggregateOldData(ctx context.Context) error {
 
 // cleanupOldRecords removes old aggregated data to prevent infinite growth
 func (lo *LearningOptimizer) cleanupOldRecords(ctx context.Context) error {
-	// Delete aggregated records older than 90 days
-	deleteSQL := `
-	DELETE FROM ml_query_performance_history 
-	WHERE timestamp < datetime('now', '-90 days')
-	AND aggregated = TRUE`
+	// Compact aggregated records older than 90 days into pattern digests
+	where := `timestamp < ` + storage.ActiveDialect().DaysAgo(90) + `
+	AND aggregated = TRUE
+	AND id NOT IN (` + patternQuotaIDsSQL + `)`
 
-	result, err := lo.db.ExecContext(ctx, deleteSQL)
+	rowsAffected, err := lo.compactHistory(ctx, where, minRecordsPerPattern)
 	if err != nil {
 		return fmt.Errorf("cleanup failed: %w", err)
 	}
 
-	if rowsAffected, _ := result.RowsAffected(); rowsAffected > 0 {
-		log.Printf("Cleaned up %d old ML learning records", rowsAffected)
+	if rowsAffected > 0 {
+		log.Printf("Compacted %d old ML learning records into pattern digests", rowsAffected)
 	}
 
 	return nil
 }
 
+const (
+	// historyRetentionLimit is how many of last week's records trimming
+	// keeps by importance alone.
+	historyRetentionLimit = 10000
+	// minRecordsPerPattern is the per-pattern quota kept regardless of
+	// importance or age.
+	minRecordsPerPattern = 5
+)
+
+// patternQuotaIDsSQL selects the ids of each query pattern's most important
+// records, up to the quota bound as its single parameter.
+const patternQuotaIDsSQL = `
+		SELECT id FROM (
+			SELECT id, ROW_NUMBER() OVER (
+				PARTITION BY query_pattern
+				ORDER BY importance_score DESC, timestamp DESC
+			) AS pattern_rank
+			FROM ml_query_performance_history
+		) AS ranked WHERE pattern_rank <= ?`
+
 // trimToImportantRecords keeps only the most valuable recent records
 func (lo *LearningOptimizer) trimToImportantRecords(ctx context.Context) error {
 	// Calculate importance score and keep only top 10,000 recent records
@@ -277,43 +372,59 @@ func (lo *LearningOptimizer) trimToImportantRecords(ctx context.Context) error {
 	UPDATE ml_query_performance_history 
 	SET importance_score = (
 		(ABS(actual_speedup - predicted_speedup) * 2) +  -- Prediction accuracy matters
-		(1.0 / (1 + (julianday('now') - julianday(timestamp)))) +  -- Recency matters
+		(1.0 / (1 + ` + storage.ActiveDialect().AgeDays("timestamp") + `)) +  -- Recency matters
 		(CASE WHEN user_satisfaction > 0 THEN user_satisfaction/5.0 ELSE 0 END)  -- User feedback matters
 	)
 	WHERE aggregated = FALSE
-	AND timestamp > datetime('now', '-7 days')`
+	AND timestamp > ` + storage.ActiveDialect().DaysAgo(7)
 
 	if _, err := lo.db.ExecContext(ctx, updateImportanceSQL); err != nil {
 		return fmt.Errorf("importance score update failed: %w", err)
 	}
 
-	// Keep only top 10,000 most important records from the last week
-	trimSQL := `
-	DELETE FROM ml_query_performance_history 
-	WHERE id NOT IN (
+	// Keep only top 10,000 most important records from the last week, plus
+	// each pattern's quota so rare patterns never lose all their coverage
+	where := `id NOT IN (
 		SELECT id FROM ml_query_performance_history 
 		WHERE aggregated = FALSE
-		AND timestamp > datetime('now', '-7 days')
+		AND timestamp > ` + storage.ActiveDialect().DaysAgo(7) + `
 		ORDER BY importance_score DESC, timestamp DESC 
-		LIMIT 10000
+		LIMIT ?
 	)
+	AND id NOT IN (` + patternQuotaIDsSQL + `)
 	AND aggregated = FALSE
-	AND timestamp > datetime('now', '-7 days')`
+	AND timestamp > ` + storage.ActiveDialect().DaysAgo(7)
 
-	result, err := lo.db.ExecContext(ctx, trimSQL)
+	rowsAffected, err := lo.compactHistory(ctx, where, historyRetentionLimit, minRecordsPerPattern)
 	if err != nil {
 		return fmt.Errorf("trimming failed: %w", err)
 	}
 
-	if rowsAffected, _ := result.RowsAffected(); rowsAffected > 0 {
-		log.Printf("Trimmed %d less important ML learning records", rowsAffected)
+	if rowsAffected > 0 {
+		log.Printf("Compacted %d less important ML learning records into pattern digests", rowsAffected)
 	}
 
 	return nil
 }
 
-// ensurePerformanceHistoryTable creates the learning table if it doesn't exist
+// ensurePerformanceHistoryTable creates the learning tables once per optimizer;
+// failed attempts are retried on the next call
 func (lo *LearningOptimizer) ensurePerformanceHistoryTable(ctx context.Context) error {
+	lo.schemaMu.Lock()
+	defer lo.schemaMu.Unlock()
+
+	if lo.schemaReady {
+		return nil
+	}
+	if err := lo.createPerformanceHistoryTables(ctx); err != nil {
+		return err
+	}
+	lo.schemaReady = true
+	return nil
+}
+
+// createPerformanceHistoryTables creates the learning table if it doesn't exist
+func (lo *LearningOptimizer) createPerformanceHistoryTables(ctx context.Context) error {
 	// Main ML learning table with optimizations for millions of records
 	createSQL := `
 	CREATE TABLE IF NOT EXISTS ml_query_performance_history (
@@ -332,10 +443,14 @@ func (lo *LearningOptimizer) ensurePerformanceHistoryTable(ctx context.Context)
 		query_features TEXT,
 		-- Add retention fields
 		importance_score REAL DEFAULT 1.0,
-		aggregated BOOLEAN DEFAULT FALSE
+		aggregated BOOLEAN DEFAULT FALSE,
+		verified BOOLEAN DEFAULT FALSE
 	)`
 
-	if _, err := lo.db.ExecContext(ctx, createSQL); err != nil {
+	if _, err := lo.db.ExecContext(ctx, storage.ActiveDialect().DDL(createSQL)); err != nil {
+		return err
+	}
+	if err := storage.EnsureColumn(ctx, lo.db, "ml_query_performance_history", "verified", "BOOLEAN DEFAULT FALSE"); err != nil {
 		return err
 	}
 
@@ -353,7 +468,48 @@ func (lo *LearningOptimizer) ensurePerformanceHistoryTable(ctx context.Context)
 		confidence_level REAL DEFAULT 0.8
 	)`
 
-	if _, err := lo.db.ExecContext(ctx, createAggregatedSQL); err != nil {
+	if _, err := lo.db.ExecContext(ctx, storage.ActiveDialect().DDL(createAggregatedSQL)); err != nil {
+		return err
+	}
+
+	// Compacted long-term distributions per pattern and strategy
+	createDigestsSQL := `
+	CREATE TABLE IF NOT EXISTS ml_pattern_digests (
+		query_pattern TEXT NOT NULL,
+		strategy TEXT NOT NULL,
+		observations INTEGER NOT NULL,
+		speedup_digest BLOB NOT NULL,
+		error_digest BLOB NOT NULL,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (query_pattern, strategy)
+	)`
+
+	if _, err := lo.db.ExecContext(ctx, storage.ActiveDialect().DDL(createDigestsSQL)); err != nil {
+		return err
+	}
+
+	// Exact-execution times measured by shadow runs, per pattern
+	createBaselinesSQL := `
+	CREATE TABLE IF NOT EXISTS ml_pattern_baselines (
+		query_pattern TEXT PRIMARY KEY,
+		baseline_ms REAL NOT NULL,
+		observed_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	if _, err := lo.db.ExecContext(ctx, storage.ActiveDialect().DDL(createBaselinesSQL)); err != nil {
+		return err
+	}
+
+	// The decision behind each pattern's last plan, to explain strategy changes
+	createDecisionsSQL := `
+	CREATE TABLE IF NOT EXISTS ml_pattern_decisions (
+		query_pattern TEXT PRIMARY KEY,
+		strategy TEXT NOT NULL,
+		decision TEXT NOT NULL,
+		decided_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	if _, err := lo.db.ExecContext(ctx, storage.ActiveDialect().DDL(createDecisionsSQL)); err != nil {
 		return err
 	}
 
@@ -383,28 +539,20 @@ func (lo *LearningOptimizer) ensurePerformanceHistoryTable(ctx context.Context)
 func (lo *LearningOptimizer) getHistoricalPerformance(ctx context.Context, features *QueryFeatures) ([]*QueryPerformanceHistory, error) {
 	// OPTIMIZATION 3: Query recent detailed data first, then fall back to aggregated summaries
 
-	// First, get recent detailed performance data (last 7 days)
+	// First, get recent detailed performance data (last 7 days). Runs are
+	// matched in normalized feature space rather than by raw table size, so
+	// history from similarly shaped tables carries over.
 	recentQuery := `
 	SELECT id, query_pattern, table_size, strategy, actual_speedup, actual_error,
 		   predicted_speedup, predicted_error, execution_time_ms, error_tolerance,
 		   user_satisfaction, timestamp, query_features
 	FROM ml_query_performance_history 
-	WHERE table_size BETWEEN ? AND ?
-	AND error_tolerance BETWEEN ? AND ?
-	AND timestamp > datetime('now', '-7 days')
+	WHERE timestamp > ` + storage.ActiveDialect().DaysAgo(7) + `
 	AND aggregated = FALSE
 	ORDER BY importance_score DESC, timestamp DESC 
-	LIMIT 20`
-
-	tableSizeRange := float64(features.TableSize) * 0.5 // ±50% table size
-	errorRange := features.ErrorTolerance * 0.5         // ±50% error tolerance
+	LIMIT 500`
 
-	rows, err := lo.db.QueryContext(ctx, recentQuery,
-		int64(float64(features.TableSize)-tableSizeRange),
-		int64(float64(features.TableSize)+tableSizeRange),
-		features.ErrorTolerance-errorRange,
-		features.ErrorTolerance+errorRange,
-	)
+	rows, err := lo.db.QueryContext(ctx, recentQuery)
 	if err != nil {
 		return nil, err
 	}
@@ -422,6 +570,7 @@ func (lo *LearningOptimizer) getHistoricalPerformance(ctx context.Context, featu
 		}
 		history = append(history, &h)
 	}
+	history = nearestHistory(normalizeFeatures(features), history)
 
 	// If we don't have enough recent data, supplement with aggregated historical data
 	if len(history) < 10 {
@@ -479,7 +628,7 @@ func (lo *LearningOptimizer) getTableSizeRange(tableSize int64) string {
 // chooseStrategyWithLearning uses historical data to improve strategy selection
 func (lo *LearningOptimizer) chooseStrategyWithLearning(features *QueryFeatures, history []*QueryPerformanceHistory) (OptimizationStrategy, float64) {
 	// If no historical data, use base strategy
-	if len(history) == 0 {
+	if len(history) == 0 || features.HasWindow || features.HasCorrelated {
 		return lo.chooseStrategy(features)
 	}
 
@@ -607,22 +756,43 @@ func (lo *LearningOptimizer) applyTransformationsWithLearning(ctx context.Contex
 	return modifiedSQL, transformations, speedup, estimatedError
 }
 
-// storePerformanceHistory saves execution results for learning
-func (lo *LearningOptimizer) storePerformanceHistory(ctx context.Context, perf *QueryPerformanceHistory) error {
+// storePerformanceHistory saves execution results for learning and returns the record's id
+func (lo *LearningOptimizer) storePerformanceHistory(ctx context.Context, perf *QueryPerformanceHistory) (int64, error) {
 	insertSQL := `
 	INSERT INTO ml_query_performance_history 
 	(query_pattern, table_size, strategy, actual_speedup, actual_error, 
 	 predicted_speedup, predicted_error, execution_time_ms, error_tolerance, 
 	 user_satisfaction, timestamp, query_features)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`
 
-	_, err := lo.db.ExecContext(ctx, insertSQL,
+	var id int64
+	err := lo.db.QueryRowContext(ctx, insertSQL,
 		perf.QueryPattern, perf.TableSize, perf.Strategy, perf.ActualSpeedup,
 		perf.ActualError, perf.PredictedSpeedup, perf.PredictedError,
 		perf.ExecutionTimeMs, perf.ErrorTolerance, perf.UserSatisfaction,
-		perf.Timestamp, perf.QueryFeatures)
+		perf.Timestamp, perf.QueryFeatures).Scan(&id)
 
-	return err
+	return id, err
+}
+
+type queryPatternKey struct{}
+
+// WithQueryPattern makes the learner file queries run under ctx under
+// pattern, such as a saved template's SQL, instead of their own SQL, so
+// runs that differ only in parameters share one history.
+func WithQueryPattern(ctx context.Context, pattern string) context.Context {
+	if pattern == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, queryPatternKey{}, pattern)
+}
+
+// queryPattern is the pattern sqlText is filed under when run under ctx.
+func (lo *LearningOptimizer) queryPattern(ctx context.Context, sqlText string) string {
+	if pattern, ok := ctx.Value(queryPatternKey{}).(string); ok {
+		sqlText = pattern
+	}
+	return lo.normalizeQueryPattern(sqlText)
 }
 
 // normalizeQueryPattern creates a pattern from SQL for similarity matching
@@ -632,9 +802,9 @@ func (lo *LearningOptimizer) normalizeQueryPattern(sql string) string {
 	pattern := sql
 
 	// Normalize common patterns
-	pattern = regexp.MustCompile(`\b\d+\b`).ReplaceAllString(pattern, "?")
-	pattern = regexp.MustCompile(`'[^']*'`).ReplaceAllString(pattern, "?")
-	pattern = regexp.MustCompile(`"[^"]*"`).ReplaceAllString(pattern, "?")
+	pattern = numberLiteralRe.ReplaceAllString(pattern, "?")
+	pattern = stringLiteralRe.ReplaceAllString(pattern, "?")
+	pattern = quotedLiteralRe.ReplaceAllString(pattern, "?")
 
 	return pattern
 }
@@ -673,6 +843,10 @@ func (lo *LearningOptimizer) generateLearningReasoning(strategy OptimizationStra
 
 // GetLearningStats returns statistics about the learning system
 func (lo *LearningOptimizer) GetLearningStats(ctx context.Context) (map[string]interface{}, error) {
+	if err := lo.ensurePerformanceHistoryTable(ctx); err != nil {
+		return nil, err
+	}
+
 	query := `
 	SELECT 
 		strategy,
@@ -680,9 +854,10 @@ func (lo *LearningOptimizer) GetLearningStats(ctx context.Context) (map[string]i
 		AVG(actual_speedup) as avg_speedup,
 		AVG(actual_error) as avg_error,
 		AVG(ABS(actual_speedup - predicted_speedup) / predicted_speedup) as speedup_prediction_error,
-		AVG(ABS(actual_error - predicted_error) / CASE WHEN predicted_error > 0 THEN predicted_error ELSE 0.01 END) as error_prediction_error
+		AVG(ABS(actual_error - predicted_error) / CASE WHEN predicted_error > 0 THEN predicted_error ELSE 0.01 END) as error_prediction_error,
+		SUM(CASE WHEN verified THEN 1 ELSE 0 END) as verified_count
 	FROM ml_query_performance_history 
-	WHERE timestamp > datetime('now', '-30 days')
+	WHERE timestamp > ` + storage.ActiveDialect().DaysAgo(30) + `
 	GROUP BY strategy`
 
 	rows, err := lo.db.QueryContext(ctx, query)
@@ -698,8 +873,9 @@ func (lo *LearningOptimizer) GetLearningStats(ctx context.Context) (map[string]i
 		var strategy string
 		var queryCount int
 		var avgSpeedup, avgError, speedupPredError, errorPredError float64
+		var verifiedCount int
 
-		err := rows.Scan(&strategy, &queryCount, &avgSpeedup, &avgError, &speedupPredError, &errorPredError)
+		err := rows.Scan(&strategy, &queryCount, &avgSpeedup, &avgError, &speedupPredError, &errorPredError, &verifiedCount)
 		if err != nil {
 			continue
 		}
@@ -710,11 +886,16 @@ func (lo *LearningOptimizer) GetLearningStats(ctx context.Context) (map[string]i
 			"avg_error":                   avgError,
 			"speedup_prediction_accuracy": 1.0 - speedupPredError,
 			"error_prediction_accuracy":   1.0 - errorPredError,
+			"verified_queries":            float64(verifiedCount),
 		}
 	}
 
 	stats["strategies"] = strategies
 	stats["learning_enabled"] = lo.learningEnabled
+	stats["failures_by_category"] = lo.failureCounts()
+	if constructs, err := storage.ListUnsupportedConstructs(ctx, lo.db); err == nil {
+		stats["unsupported_constructs"] = constructs
+	}
 
 	// Get total historical data count
 	var totalQueries int
@@ -7,6 +7,11 @@ import (
 	"math"
 	"regexp"
 	"strings"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/aqeerr"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/sampler"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
 )
 
 type OptimizationStrategy string
@@ -28,6 +33,9 @@ type QueryOptimization struct {
 	Reasoning        string               `json:"reasoning"`
 	Transformations  []string             `json:"transformations"`
 	JoinAnalysis     *JoinAnalysis        `json:"join_analysis,omitempty"`
+	// Features and Attributions are the structured evidence behind Reasoning.
+	Features     map[string]float64   `json:"features,omitempty"`
+	Attributions []FeatureAttribution `json:"attributions,omitempty"`
 }
 
 type QueryFeatures struct {
@@ -42,8 +50,21 @@ type QueryFeatures struct {
 	QueryLength        int     `json:"query_length"`
 	TableName          string  `json:"table_name"`
 	ErrorTolerance     float64 `json:"error_tolerance"`
+	HasWindow          bool    `json:"has_window,omitempty"`
+	HasCorrelated      bool    `json:"has_correlated_subquery,omitempty"`
+	GroupSkew          float64 `json:"group_skew,omitempty"`
+	GroupRatio         float64 `json:"group_ratio,omitempty"`
 }
 
+var (
+	tableRe           = regexp.MustCompile(`(?i)from\s+([a-zA-Z0-9_]+)`)
+	groupByColumnsRe  = regexp.MustCompile(`(?i)group\s+by\s+([^having^order^limit]+)`)
+	whereClauseRe     = regexp.MustCompile(`(?i)where\s+(.+?)(?:\s+group|\s+order|\s+limit|$)`)
+	firstGroupByColRe = regexp.MustCompile(`(?i)group\s+by\s+([a-zA-Z0-9_]+)`)
+)
+
+// MLOptimizer is stateless apart from the database handle and is safe for
+// concurrent use.
 type MLOptimizer struct {
 	db *sql.DB
 }
@@ -81,23 +102,31 @@ func (opt *MLOptimizer) OptimizeQuery(ctx context.Context, originalSQL string, e
 }
 
 func (opt *MLOptimizer) extractQueryFeatures(ctx context.Context, sql string, errorTolerance float64) (*QueryFeatures, error) {
+	features, err := parseQueryFeatures(sql, errorTolerance)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := storage.CountRows(ctx, opt.db, features.TableName)
+	if err == nil {
+		features.TableSize = count
+	}
+	opt.probeDistribution(ctx, features, sql)
+	return features, nil
+}
+
+// parseQueryFeatures extracts the SQL-only features; TableSize is left zero.
+func parseQueryFeatures(sql string, errorTolerance float64) (*QueryFeatures, error) {
 	features := &QueryFeatures{
 		ErrorTolerance: errorTolerance,
 		QueryLength:    len(sql),
 	}
 
-	tableRe := regexp.MustCompile(`(?i)from\s+([a-zA-Z0-9_]+)`)
 	if match := tableRe.FindStringSubmatch(sql); len(match) > 1 {
 		features.TableName = match[1]
 	}
-
-	if features.TableName != "" {
-		var count int64
-		err := opt.db.QueryRowContext(ctx,
-			"SELECT COUNT(*) FROM "+features.TableName).Scan(&count)
-		if err == nil {
-			features.TableSize = count
-		}
+	if features.TableName == "" {
+		return nil, fmt.Errorf("%w: no FROM table", aqeerr.ErrUnsupportedQuery)
 	}
 
 	sqlUpper := strings.ToUpper(sql)
@@ -110,17 +139,19 @@ func (opt *MLOptimizer) extractQueryFeatures(ctx context.Context, sql string, er
 		(strings.Contains(sqlUpper, "DISTINCT") && strings.Contains(sqlUpper, "COUNT"))
 
 	features.HasGroupBy = strings.Contains(sqlUpper, "GROUP BY")
+	features.HasWindow = planner.AnalyzeComplexity(sql).WindowFunctions > 0
+	for _, sq := range planner.DetectSubqueries(sql) {
+		features.HasCorrelated = features.HasCorrelated || sq.Correlated
+	}
 
 	if features.HasGroupBy {
-		groupByRe := regexp.MustCompile(`(?i)group\s+by\s+([^having^order^limit]+)`)
-		if match := groupByRe.FindStringSubmatch(sql); len(match) > 1 {
+		if match := groupByColumnsRe.FindStringSubmatch(sql); len(match) > 1 {
 			columns := strings.Split(match[1], ",")
 			features.GroupByCardinality = len(columns)
 		}
 	}
 
-	whereRe := regexp.MustCompile(`(?i)where\s+(.+?)(?:\s+group|\s+order|\s+limit|$)`)
-	if match := whereRe.FindStringSubmatch(sql); len(match) > 1 {
+	if match := whereClauseRe.FindStringSubmatch(sql); len(match) > 1 {
 		whereClause := match[1]
 		features.WhereComplexity = strings.Count(strings.ToUpper(whereClause), " AND ") +
 			strings.Count(strings.ToUpper(whereClause), " OR ")
@@ -130,6 +161,11 @@ func (opt *MLOptimizer) extractQueryFeatures(ctx context.Context, sql string, er
 }
 
 func (opt *MLOptimizer) chooseStrategy(features *QueryFeatures) (OptimizationStrategy, float64) {
+	// Window functions and correlated subqueries are never rewritten
+	if features.HasWindow || features.HasCorrelated {
+		return StrategyExact, 0.99
+	}
+
 	// Small tables should use exact computation (including 1K table)
 	if features.TableSize <= 1000 {
 		return StrategyExact, 0.95
@@ -277,13 +313,12 @@ func (opt *MLOptimizer) applySketchTransformation(originalSQL string, features *
 func (opt *MLOptimizer) applyStratifiedTransformation(originalSQL string, features *QueryFeatures) (string, string) {
 	strataCol := "id"
 	if features.HasGroupBy {
-		groupByRe := regexp.MustCompile(`(?i)group\s+by\s+([a-zA-Z0-9_]+)`)
-		if match := groupByRe.FindStringSubmatch(originalSQL); len(match) > 1 {
+		if match := firstGroupByColRe.FindStringSubmatch(originalSQL); len(match) > 1 {
 			strataCol = strings.TrimSpace(match[1])
 		}
 	}
 
-	sampleTableName := fmt.Sprintf("%s__strat_sample_%s_0_6", features.TableName, strataCol)
+	sampleTableName := sampler.SampleName(features.TableName, strataCol, 0.6)
 
 	modifiedSQL := strings.Replace(originalSQL, features.TableName, sampleTableName, -1)
 
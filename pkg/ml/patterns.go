@@ -0,0 +1,44 @@
+package ml
+
+import (
+	"context"
+	"strings"
+)
+
+// PatternUsage summarizes how often a normalized query pattern ran and how
+// it was executed.
+type PatternUsage struct {
+	Pattern     string  `json:"pattern"`
+	Runs        int64   `json:"runs"`
+	ExactRuns   int64   `json:"exact_runs"`
+	AvgExecTime float64 `json:"avg_execution_time_ms"`
+}
+
+// TablePatterns returns the recorded query patterns reading table, most
+// frequent first.
+func (lo *LearningOptimizer) TablePatterns(ctx context.Context, table string) ([]PatternUsage, error) {
+	if err := lo.ensurePerformanceHistoryTable(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := lo.db.QueryContext(ctx, `
+	SELECT query_pattern, COUNT(*), SUM(CASE WHEN strategy = 'exact' THEN 1 ELSE 0 END), AVG(execution_time_ms)
+	FROM ml_query_performance_history
+	GROUP BY query_pattern
+	ORDER BY COUNT(*) DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []PatternUsage
+	for rows.Next() {
+		var u PatternUsage
+		if err := rows.Scan(&u.Pattern, &u.Runs, &u.ExactRuns, &u.AvgExecTime); err != nil {
+			return nil, err
+		}
+		if m := tableRe.FindStringSubmatch(u.Pattern); len(m) > 1 && strings.EqualFold(m[1], table) {
+			out = append(out, u)
+		}
+	}
+	return out, rows.Err()
+}
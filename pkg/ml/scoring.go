@@ -0,0 +1,167 @@
+package ml
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/planner"
+)
+
+// minScoringHistory is how many past runs of a strategy are needed before
+// the learned numbers start to move a candidate's estimates.
+const minScoringHistory = 3
+
+// ScorePlans implements planner.Scorer: each candidate's estimated error and
+// cost are blended with the error and speedup observed for the same strategy
+// on similar past queries. The more history, the more weight it gets.
+func (lo *LearningOptimizer) ScorePlans(ctx context.Context, sqlText string, maxRelError float64, candidates []*planner.Plan) {
+	if !lo.learningEnabled || len(candidates) == 0 {
+		return
+	}
+
+	features, err := parseQueryFeatures(sqlText, maxRelError)
+	if err != nil {
+		lo.RecordFailure(err)
+		return
+	}
+	features.TableSize = candidates[0].TableRows
+	lo.probeDistribution(ctx, features, sqlText)
+
+	if err := lo.ensurePerformanceHistoryTable(ctx); err != nil {
+		log.Printf("Warning: Could not create performance history table: %v", err)
+		return
+	}
+	history, err := lo.getHistoricalPerformance(ctx, features)
+	if err != nil {
+		log.Printf("Warning: Could not fetch historical performance: %v", err)
+		return
+	}
+
+	perf := make(map[OptimizationStrategy]*StrategyStats)
+	latencies := make(map[OptimizationStrategy][]float64)
+	for _, h := range history {
+		if h.TableSize > 0 && features.TableSize > 0 {
+			// normalize to this table's size before taking the median
+			ms := float64(h.ExecutionTimeMs) * float64(features.TableSize) / float64(h.TableSize)
+			latencies[OptimizationStrategy(h.Strategy)] = append(latencies[OptimizationStrategy(h.Strategy)], ms)
+		}
+		st := perf[OptimizationStrategy(h.Strategy)]
+		if st == nil {
+			st = &StrategyStats{}
+			perf[OptimizationStrategy(h.Strategy)] = st
+		}
+		st.Count++
+		st.AvgSpeedup += h.ActualSpeedup
+		st.AvgError += h.ActualError
+	}
+
+	var digests map[OptimizationStrategy]*patternDigest
+	for _, c := range candidates {
+		st := perf[StrategyForPlan(c)]
+		if st == nil || st.Count < minScoringHistory {
+			// fall back to this pattern's compacted long-term history
+			if digests == nil {
+				digests = lo.patternDigests(ctx, lo.queryPattern(ctx, sqlText))
+			}
+			d := digests[StrategyForPlan(c)]
+			if d == nil || d.Speedup.Count() < minScoringHistory {
+				continue
+			}
+			n := int(d.Speedup.Count())
+			cv := 0.0
+			if mean := d.Speedup.Mean(); mean > 0 {
+				cv = math.Sqrt(d.Speedup.Variance()) / mean
+			}
+			blendCandidate(c, d.Speedup.Mean(), d.Error.Mean(), n, cv)
+			c.ScoredBy = fmt.Sprintf("compacted history (%d runs)", n)
+			continue
+		}
+		if ls := latencies[StrategyForPlan(c)]; len(ls) >= minScoringHistory {
+			c.EstimatedLatencyMs = math.Max(median(ls), 1) // ms timings round sub-ms runs to 0
+			c.LatencySource = "history"
+		}
+		blendCandidate(c, st.AvgSpeedup/float64(st.Count), st.AvgError/float64(st.Count), st.Count, 0)
+		c.ScoredBy = fmt.Sprintf("learning (%d runs)", st.Count)
+	}
+}
+
+// blendCandidate moves c's error and cost toward the observed averages,
+// weighted by the number of runs. cv, the coefficient of variation of the
+// observed speedups, lowers the resulting confidence.
+func blendCandidate(c *planner.Plan, avgSpeedup, avgError float64, runs int, cv float64) {
+	c.ModelCost, c.ModelError, c.HistoryRuns = c.EstimatedCost, c.EstimatedError, runs
+	w := float64(runs) / float64(runs+5)
+	if c.Type != planner.PlanExact {
+		c.EstimatedError = (1-w)*c.EstimatedError + w*avgError
+		if avgSpeedup > 0 && c.BaselineCost > 0 {
+			c.EstimatedCost = (1-w)*c.EstimatedCost + w*c.BaselineCost/avgSpeedup
+		}
+	}
+	c.Confidence = 0.5 + 0.45*w/(1+cv)
+}
+
+// median sorts values in place and returns the middle value.
+func median(values []float64) float64 {
+	sort.Float64s(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
+
+// StrategyForPlan maps a planner plan onto the learning system's strategy names.
+func StrategyForPlan(p *planner.Plan) OptimizationStrategy {
+	switch p.Type {
+	case planner.PlanSample:
+		if p.StrataColumn != "" {
+			return StrategyStratified
+		}
+		return StrategySample
+	case planner.PlanSketch, planner.PlanHybrid:
+		return StrategySketch
+	default:
+		return StrategyExact
+	}
+}
+
+// DescribePlan reports the planner's chosen plan as a QueryOptimization so
+// the API response and performance history keep their existing shape.
+func (lo *LearningOptimizer) DescribePlan(p *planner.Plan) *QueryOptimization {
+	speedup := 1.0
+	if p.BaselineCost > 0 && p.EstimatedCost > 0 {
+		speedup = p.BaselineCost / p.EstimatedCost
+	}
+	confidence := p.Confidence
+	if confidence == 0 {
+		confidence = 0.6
+	}
+
+	reasoning := p.Reason
+	if p.ScoredBy != "" {
+		reasoning += "; estimates adjusted by " + p.ScoredBy
+	}
+
+	transformations := append(make([]string, 0, len(p.Rewrites)+1), p.Rewrites...)
+	if p.SampleTable != "" {
+		transformations = append(transformations, fmt.Sprintf("Read %s (fraction: %g)", p.SampleTable, p.SampleFraction))
+	}
+
+	features, attributions := attributePlan(p, speedup)
+
+	return &QueryOptimization{
+		Strategy:         StrategyForPlan(p),
+		ModifiedSQL:      p.SQL,
+		OriginalSQL:      p.OriginalSQL,
+		Confidence:       confidence,
+		EstimatedSpeedup: speedup,
+		EstimatedError:   p.EstimatedError,
+		Reasoning:        reasoning,
+		Features:         features,
+		Attributions:     attributions,
+		Transformations:  transformations,
+	}
+}
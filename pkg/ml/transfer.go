@@ -0,0 +1,224 @@
+package ml
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	// distributionProbeRows bounds the scan used to estimate group skew and
+	// cardinality ratio for a query's first GROUP BY column.
+	distributionProbeRows = 10000
+
+	// transferRadius is the largest normalized feature distance at which a
+	// past run still counts as similar, whatever table it ran on.
+	transferRadius = 1.5
+
+	// maxTransferNeighbours caps how many similar runs feed one decision.
+	maxTransferNeighbours = 20
+)
+
+// NormalizedFeatures places a query in a table-independent space: sizes on
+// a log scale, group structure as ratios, and the query shape as flags, so
+// history from one table applies to another of similar shape.
+type NormalizedFeatures struct {
+	LogSize      float64 `json:"log_size"`
+	LogTolerance float64 `json:"log_tolerance"`
+	Skew         float64 `json:"skew"`        // log2 of top-group share over uniform share; 0 when unknown
+	GroupRatio   float64 `json:"group_ratio"` // distinct groups per probed row; 0 when unknown
+	HasCount     bool    `json:"has_count"`
+	HasSum       bool    `json:"has_sum"`
+	HasAvg       bool    `json:"has_avg"`
+	HasDistinct  bool    `json:"has_distinct"`
+	HasGroupBy   bool    `json:"has_group_by"`
+}
+
+func normalizeFeatures(f *QueryFeatures) NormalizedFeatures {
+	n := NormalizedFeatures{
+		LogSize:     math.Log10(math.Max(float64(f.TableSize), 1)),
+		HasCount:    f.HasCount,
+		HasSum:      f.HasSum,
+		HasAvg:      f.HasAvg,
+		HasDistinct: f.HasDistinct,
+		HasGroupBy:  f.HasGroupBy,
+		GroupRatio:  f.GroupRatio,
+	}
+	if f.ErrorTolerance > 0 {
+		n.LogTolerance = math.Log10(f.ErrorTolerance)
+	}
+	if f.GroupSkew > 0 {
+		n.Skew = math.Log2(f.GroupSkew)
+	}
+	return n
+}
+
+// featureDistance is an L1 distance in normalized space. Distribution terms
+// only count when both sides measured them; a differing query shape costs
+// one unit per flag.
+func featureDistance(a, b NormalizedFeatures) float64 {
+	d := math.Abs(a.LogSize-b.LogSize) + 0.5*math.Abs(a.LogTolerance-b.LogTolerance)
+	if a.Skew != 0 && b.Skew != 0 {
+		d += 0.5 * math.Abs(a.Skew-b.Skew)
+	}
+	if a.GroupRatio > 0 && b.GroupRatio > 0 {
+		d += math.Abs(math.Log10(a.GroupRatio) - math.Log10(b.GroupRatio))
+	}
+	for _, same := range []bool{
+		a.HasCount == b.HasCount, a.HasSum == b.HasSum, a.HasAvg == b.HasAvg,
+		a.HasDistinct == b.HasDistinct, a.HasGroupBy == b.HasGroupBy,
+	} {
+		if !same {
+			d++
+		}
+	}
+	return d
+}
+
+// probeDistribution fills GroupSkew and GroupRatio from a bounded scan of
+// the first GROUP BY column. Failures leave them zero (unknown).
+func (opt *MLOptimizer) probeDistribution(ctx context.Context, features *QueryFeatures, sql string) {
+	if !features.HasGroupBy || features.TableName == "" {
+		return
+	}
+	match := firstGroupByColRe.FindStringSubmatch(sql)
+	if len(match) < 2 {
+		return
+	}
+
+	rows, err := opt.db.QueryContext(ctx, fmt.Sprintf(
+		"SELECT COUNT(*) FROM (SELECT %s AS g FROM %s LIMIT %d) GROUP BY g",
+		match[1], features.TableName, distributionProbeRows))
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	var groups, total, top int64
+	for rows.Next() {
+		var c int64
+		if rows.Scan(&c) != nil {
+			return
+		}
+		groups++
+		total += c
+		if c > top {
+			top = c
+		}
+	}
+	if rows.Err() != nil || groups == 0 || total == 0 {
+		return
+	}
+	features.GroupRatio = float64(groups) / float64(total)
+	features.GroupSkew = float64(top) * float64(groups) / float64(total)
+}
+
+// historyFeatures decodes the normalized features of a recorded run, falling
+// back to its table size when the stored JSON is missing or unreadable.
+func historyFeatures(h *QueryPerformanceHistory) NormalizedFeatures {
+	var f QueryFeatures
+	if h.QueryFeatures == "" || json.Unmarshal([]byte(h.QueryFeatures), &f) != nil {
+		f = QueryFeatures{ErrorTolerance: h.ErrorTolerance}
+	}
+	f.TableSize = h.TableSize
+	return normalizeFeatures(&f)
+}
+
+// nearestHistory keeps the runs within transferRadius of target, closest
+// first, capped at maxTransferNeighbours.
+func nearestHistory(target NormalizedFeatures, candidates []*QueryPerformanceHistory) []*QueryPerformanceHistory {
+	type scored struct {
+		h *QueryPerformanceHistory
+		d float64
+	}
+	var kept []scored
+	for _, h := range candidates {
+		if d := featureDistance(target, historyFeatures(h)); d <= transferRadius {
+			kept = append(kept, scored{h, d})
+		}
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].d < kept[j].d })
+	if len(kept) > maxTransferNeighbours {
+		kept = kept[:maxTransferNeighbours]
+	}
+	out := make([]*QueryPerformanceHistory, len(kept))
+	for i, s := range kept {
+		out[i] = s.h
+	}
+	return out
+}
+
+// TransferEvaluation compares, by leave-one-out over recorded runs, how well
+// other tables' history predicts a run's measured speedup when neighbours
+// are matched on raw table size ranges versus normalized features. Errors
+// are mean absolute log10 errors; coverage is the share of runs for which
+// any neighbour was found.
+type TransferEvaluation struct {
+	Runs               int     `json:"runs"`
+	RawRangeError      float64 `json:"raw_range_log_error"`
+	RawRangeCoverage   float64 `json:"raw_range_coverage"`
+	NormalizedError    float64 `json:"normalized_log_error"`
+	NormalizedCoverage float64 `json:"normalized_coverage"`
+}
+
+type transferRun struct {
+	table     string
+	strategy  string
+	tableSize int64
+	tolerance float64
+	speedup   float64
+	features  NormalizedFeatures
+}
+
+func evaluateTransfer(runs []transferRun) *TransferEvaluation {
+	ev := &TransferEvaluation{Runs: len(runs)}
+	var rawErr, normErr float64
+	var rawHits, normHits int
+
+	for i, target := range runs {
+		var rawSum, normSum float64
+		var rawN, normN int
+		for j, other := range runs {
+			if i == j || other.table == target.table || other.strategy != target.strategy || other.speedup <= 0 {
+				continue
+			}
+			lo, hi := float64(target.tableSize)*0.5, float64(target.tableSize)*1.5
+			tol := target.tolerance * 0.5
+			if float64(other.tableSize) >= lo && float64(other.tableSize) <= hi &&
+				math.Abs(other.tolerance-target.tolerance) <= tol {
+				rawSum += math.Log10(other.speedup)
+				rawN++
+			}
+			if featureDistance(target.features, other.features) <= transferRadius {
+				normSum += math.Log10(other.speedup)
+				normN++
+			}
+		}
+		if target.speedup <= 0 {
+			continue
+		}
+		actual := math.Log10(target.speedup)
+		if rawN > 0 {
+			rawErr += math.Abs(rawSum/float64(rawN) - actual)
+			rawHits++
+		}
+		if normN > 0 {
+			normErr += math.Abs(normSum/float64(normN) - actual)
+			normHits++
+		}
+	}
+
+	if len(runs) > 0 {
+		ev.RawRangeCoverage = float64(rawHits) / float64(len(runs))
+		ev.NormalizedCoverage = float64(normHits) / float64(len(runs))
+	}
+	if rawHits > 0 {
+		ev.RawRangeError = rawErr / float64(rawHits)
+	}
+	if normHits > 0 {
+		ev.NormalizedError = normErr / float64(normHits)
+	}
+	return ev
+}
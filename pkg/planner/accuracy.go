@@ -0,0 +1,127 @@
+package planner
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/estimator"
+)
+
+// DefaultConfidenceLevel is the confidence level error tolerances and
+// reported intervals are at unless a query states another.
+const DefaultConfidenceLevel = 0.95
+
+// Accuracy is what a trailing accuracy clause asks for:
+//
+//	SELECT SUM(amount) FROM purchases WITHIN 2% ERROR AT 95% CONFIDENCE
+//
+// Either part may be left out. Values are percentages or fractions;
+// Confidence is zero when not given.
+type Accuracy struct {
+	MaxError    float64
+	HasMaxError bool
+	Confidence  float64
+}
+
+// StripAccuracyClause splits a trailing WITHIN ... ERROR / AT ...
+// CONFIDENCE clause off sqlText, returning the SQL without it and the
+// accuracy it asks for, or sqlText unchanged and nil when there is none. A
+// clause that starts out right but is malformed is an
+// aqeerr.ErrUnsupportedQuery error.
+func StripAccuracyClause(sqlText string) (string, *Accuracy, error) {
+	toks, err := tokenize(sqlText)
+	if err != nil {
+		return sqlText, nil, nil // Parse reports it
+	}
+	for len(toks) > 0 && toks[len(toks)-1].isPunct(";") {
+		toks = toks[:len(toks)-1]
+	}
+
+	// the clause starts at the first top-level WITHIN or AT followed by a
+	// number; WITHIN GROUP and the like are not followed by one
+	start, depth := -1, 0
+	for i, t := range toks {
+		switch {
+		case t.isPunct("("):
+			depth++
+		case t.isPunct(")"):
+			depth--
+		case depth == 0 && (t.isWord("within") || t.isWord("at")) &&
+			i+1 < len(toks) && toks[i+1].kind == tokNumber:
+			start = i
+		}
+		if start >= 0 {
+			break
+		}
+	}
+	if start < 0 {
+		return sqlText, nil, nil
+	}
+
+	acc := &Accuracy{}
+	rest := toks[start:]
+	if rest[0].isWord("within") {
+		v, n, ok := accuracyValue(rest[1:], "error")
+		if !ok || v < 0 {
+			return "", nil, unsupported("WITHIN expects a non-negative error such as WITHIN 2%% ERROR")
+		}
+		acc.MaxError, acc.HasMaxError, rest = v, true, rest[1+n:]
+	}
+	if len(rest) > 0 && rest[0].isWord("at") {
+		v, n, ok := accuracyValue(rest[1:], "confidence")
+		if !ok || v <= 0 || v >= 1 {
+			return "", nil, unsupported("AT expects a confidence level between 0 and 100%% such as AT 95%% CONFIDENCE")
+		}
+		acc.Confidence, rest = v, rest[1+n:]
+	}
+	if len(rest) > 0 {
+		return "", nil, unsupported("unexpected %q after accuracy clause", rest[0].text)
+	}
+	return strings.TrimSpace(sqlText[:toks[start].start]), acc, nil
+}
+
+// accuracyValue reads "<number>[%] <keyword>" from toks, returning the
+// value as a fraction and the tokens it used.
+func accuracyValue(toks []token, keyword string) (float64, int, bool) {
+	if len(toks) < 2 || toks[0].kind != tokNumber {
+		return 0, 0, false
+	}
+	v, err := strconv.ParseFloat(toks[0].text, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	n := 1
+	if toks[n].isPunct("%") {
+		v /= 100
+		n++
+	}
+	if n >= len(toks) || !toks[n].isWord(keyword) {
+		return 0, 0, false
+	}
+	return v, n + 1, true
+}
+
+// Override applies the clause to a request's tolerance and confidence
+// level, leaving the parts it doesn't state as they are.
+func (a *Accuracy) Override(maxRelError, confidence float64) (float64, float64) {
+	if a == nil {
+		return maxRelError, confidence
+	}
+	if a.HasMaxError {
+		maxRelError = a.MaxError
+	}
+	if a.Confidence > 0 {
+		confidence = a.Confidence
+	}
+	return maxRelError, confidence
+}
+
+// ToleranceAt converts maxRelError, a tolerance at confidence, to the
+// planner's error estimates, which are calibrated at
+// DefaultConfidenceLevel: a higher confidence level narrows it.
+func ToleranceAt(maxRelError, confidence float64) float64 {
+	if confidence <= 0 || confidence == DefaultConfidenceLevel {
+		return maxRelError
+	}
+	return maxRelError * estimator.ZScore(DefaultConfidenceLevel) / estimator.ZScore(confidence)
+}
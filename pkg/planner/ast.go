@@ -0,0 +1,297 @@
+package planner
+
+import "strings"
+
+// Query is a parsed SELECT statement: an optional WITH clause, then one or
+// more SELECT cores joined by set operators.
+type Query struct {
+	With    []CTE
+	Selects []*Select
+	SetOps  []string // operator between Selects[i] and Selects[i+1], e.g. "UNION ALL"
+}
+
+// CTE is one WITH-clause entry.
+type CTE struct {
+	Name  string
+	Query *Query
+}
+
+// Select is one SELECT core. Expressions are kept as source text with the
+// column references and function calls found in them.
+type Select struct {
+	Distinct bool
+	Items    []SelectItem
+	From     []TableRef
+	Where    *Expr
+	GroupBy  []Expr
+	Having   *Expr
+	OrderBy  []Expr
+	Limit    *Expr
+	Offset   *Expr
+	// Calls are the function calls in this SELECT's own expressions and
+	// Subqueries the queries nested in them; neither reaches into derived
+	// tables in FROM, which are parsed as TableRef.Subquery.
+	Calls      []FuncCall
+	Subqueries []*Query
+}
+
+// SelectItem is one entry of the SELECT list.
+type SelectItem struct {
+	Expr  Expr
+	Alias string
+
+	end int // end of the item including its alias
+}
+
+// TableRef is one FROM-clause source: a named table, or a derived table
+// when Subquery is set. Join is empty for the first source and "," or the
+// join keywords (e.g. "LEFT JOIN") for the others.
+type TableRef struct {
+	Name     string
+	Alias    string
+	Subquery *Query
+	Join     string
+	On       *Expr
+
+	nameStart, nameEnd int // span of the table name, for rewriting
+}
+
+// Expr is an expression as written, with the column references in it
+// ("amount", "o.amount"), not counting those inside nested subqueries.
+type Expr struct {
+	Text    string
+	Columns []string
+
+	start, end int
+}
+
+// FuncCall is a function call inside an expression.
+type FuncCall struct {
+	Name     string // upper-cased
+	Distinct bool
+	Args     []string
+	Filter   bool // has a FILTER (WHERE ...) clause
+	Window   bool // has an OVER clause
+
+	start, end int
+}
+
+var aggregateFuncs = map[string]bool{
+	"COUNT": true, "SUM": true, "AVG": true, "MIN": true, "MAX": true,
+	"MEDIAN": true, "PERCENTILE": true, "TOTAL": true,
+}
+
+// Main is the first (or only) SELECT of q.
+func (q *Query) Main() *Select {
+	return q.Selects[0]
+}
+
+// BaseTable is the table q reads its rows from: the first FROM source of
+// its main SELECT, followed through CTEs and derived tables.
+func (q *Query) BaseTable() string {
+	return baseTable(q, nil, 0)
+}
+
+func baseTable(q *Query, scope []*Query, depth int) string {
+	s := q.Main()
+	if len(s.From) == 0 || depth > maxResolveDepth {
+		return ""
+	}
+	scope = append(scope, q)
+	ref := s.From[0]
+	if ref.Subquery != nil {
+		return baseTable(ref.Subquery, scope, depth+1)
+	}
+	if cte := lookupCTE(scope, ref.Name); cte != nil {
+		return baseTable(cte, scope, depth+1)
+	}
+	return ref.Name
+}
+
+// maxResolveDepth bounds CTE resolution, which recursive CTEs would
+// otherwise follow forever.
+const maxResolveDepth = 16
+
+// lookupCTE finds the innermost WITH entry named name visible from scope.
+func lookupCTE(scope []*Query, name string) *Query {
+	for i := len(scope) - 1; i >= 0; i-- {
+		for _, c := range scope[i].With {
+			if strings.EqualFold(c.Name, name) {
+				return c.Query
+			}
+		}
+	}
+	return nil
+}
+
+// Aggregates returns the aggregate calls of s, excluding window functions.
+func (s *Select) Aggregates() []FuncCall {
+	var out []FuncCall
+	for _, c := range s.Calls {
+		if aggregateFuncs[c.Name] && !c.Window {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// passThrough reports whether s only filters and projects rows, so reading
+// a sample through it keeps each sampled row's weight.
+func (s *Select) passThrough() bool {
+	return !s.Distinct && len(s.GroupBy) == 0 && s.Having == nil && s.Limit == nil && len(s.Aggregates()) == 0
+}
+
+// qualifies reports whether any expression of s refers to a column as
+// name.column.
+func (s *Select) qualifies(name string) bool {
+	exprs := make([]*Expr, 0, len(s.Items)+len(s.GroupBy)+len(s.OrderBy)+4)
+	for i := range s.Items {
+		exprs = append(exprs, &s.Items[i].Expr)
+	}
+	for i := range s.GroupBy {
+		exprs = append(exprs, &s.GroupBy[i])
+	}
+	for i := range s.OrderBy {
+		exprs = append(exprs, &s.OrderBy[i])
+	}
+	for i := range s.From {
+		exprs = append(exprs, s.From[i].On)
+	}
+	exprs = append(exprs, s.Where, s.Having)
+	for _, e := range exprs {
+		if e == nil {
+			continue
+		}
+		for _, c := range e.Columns {
+			if dot := strings.LastIndexByte(c, '.'); dot > 0 && strings.EqualFold(c[:dot], name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sampleRef is a FROM source to be swapped for a sample, and the SELECT
+// whose expressions may refer to it by name.
+type sampleRef struct {
+	ref   *TableRef
+	owner *Select
+}
+
+// sampleRefs returns the FROM sources reading table in q's main SELECT and
+// in the CTEs and derived tables it reads through. Tables read by nested
+// subqueries in expressions are left alone. ok is false when table is read
+// under an aggregation, DISTINCT or LIMIT, or q is a set operation, where
+// substituting a sample would not scale.
+func (q *Query) sampleRefs(table string) ([]sampleRef, bool) {
+	if len(q.Selects) > 1 {
+		return nil, false
+	}
+	seen := make(map[*TableRef]bool)
+	refs, blocker := collectSampleRefs(q, nil, table, true, seen, 0, "")
+	return refs, blocker == ""
+}
+
+// collectSampleRefs gathers sampleRefs' references under q, which is read
+// as kind (a CTE or derived table, or "" at the top). blocker names the
+// construct that keeps a sample from standing in for table, if one does.
+func collectSampleRefs(q *Query, scope []*Query, table string, top bool, seen map[*TableRef]bool, depth int, kind string) (refs []sampleRef, blocker string) {
+	switch {
+	case len(q.Selects) > 1:
+		return nil, ConstructSetOperation
+	case depth > maxResolveDepth:
+		return nil, ConstructRecursiveCTE
+	}
+	scope = append(scope, q)
+	s := q.Main()
+	for i := range s.From {
+		ref := &s.From[i]
+		var inner *Query
+		innerKind := ConstructDerivedTable
+		switch {
+		case ref.Subquery != nil:
+			inner = ref.Subquery
+		case lookupCTE(scope, ref.Name) != nil:
+			inner, innerKind = lookupCTE(scope, ref.Name), ConstructCTE
+		case strings.EqualFold(ref.Name, table):
+			if !seen[ref] {
+				seen[ref] = true
+				refs = append(refs, sampleRef{ref: ref, owner: s})
+			}
+			continue
+		default:
+			continue
+		}
+		sub, blocker := collectSampleRefs(inner, scope, table, false, seen, depth+1, innerKind)
+		if blocker != "" {
+			return nil, blocker
+		}
+		refs = append(refs, sub...)
+	}
+	if !top && len(refs) > 0 && !s.passThrough() {
+		return nil, kind
+	}
+	return refs, ""
+}
+
+// sampleColumns returns the columns the SELECTs owning refs name, either
+// unqualified or qualified by the reference's name or alias; these are the
+// columns a sample substituted for refs must hold, plus, in joins, some
+// belonging to other tables. all is set when one of them selects *, so only
+// a sample of every column will do.
+func sampleColumns(refs []sampleRef) (columns []string, all bool) {
+	seen := make(map[string]bool)
+	for _, r := range refs {
+		s := r.owner
+		for _, item := range s.Items {
+			text := strings.TrimSpace(item.Expr.Text)
+			if text == "*" || strings.HasSuffix(text, ".*") && (r.qualifier(text[:len(text)-2]) || len(s.From) == 1) {
+				return nil, true
+			}
+		}
+		exprs := make([]*Expr, 0, len(s.Items)+len(s.GroupBy)+len(s.OrderBy)+len(s.From)+2)
+		for i := range s.Items {
+			exprs = append(exprs, &s.Items[i].Expr)
+		}
+		for i := range s.GroupBy {
+			exprs = append(exprs, &s.GroupBy[i])
+		}
+		for i := range s.OrderBy {
+			exprs = append(exprs, &s.OrderBy[i])
+		}
+		for i := range s.From {
+			exprs = append(exprs, s.From[i].On)
+		}
+		exprs = append(exprs, s.Where, s.Having)
+		for _, e := range exprs {
+			if e == nil {
+				continue
+			}
+			for _, c := range e.Columns {
+				if dot := strings.LastIndexByte(c, '.'); dot > 0 {
+					if !r.qualifier(c[:dot]) {
+						continue
+					}
+					c = c[dot+1:]
+				}
+				if key := strings.ToLower(c); !seen[key] {
+					seen[key] = true
+					columns = append(columns, c)
+				}
+			}
+		}
+	}
+	return columns, false
+}
+
+// qualifier reports whether name (possibly schema-qualified) refers to r's
+// table in its owner's expressions.
+func (r sampleRef) qualifier(name string) bool {
+	if dot := strings.LastIndexByte(name, '.'); dot >= 0 {
+		name = name[dot+1:]
+	}
+	if r.ref.Alias != "" {
+		return strings.EqualFold(name, r.ref.Alias)
+	}
+	return strings.EqualFold(name, r.ref.Name)
+}
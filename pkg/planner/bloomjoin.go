@@ -0,0 +1,134 @@
+package planner
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/sketches"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// BloomFunction is the SQL function the executor registers to test a key
+// against a stored Bloom filter: aqe_bloom(table, column, key) is 1 when
+// the filter on table.column may contain key, 0 when it can't, and 1 when
+// no filter was loaded, so a query using it is exact either way.
+const BloomFunction = "aqe_bloom"
+
+// bloomMaxPass is the largest share of the probed table's rows a Bloom
+// filter may be expected to keep for pre-filtering to be worth a function
+// call per row.
+const bloomMaxPass = 0.5
+
+// BloomJoin is how an exact plan pre-filters the larger side of an inner
+// equi-join: Probe is read through the Bloom filter on Table.Column, the
+// smaller side's join key, so only rows whose ProbeKey may match reach the
+// join. The filter has no false negatives, so the answer is exact; SQL is
+// the plan's SQL reading Probe that way, which the executor runs once it
+// has loaded the filter.
+type BloomJoin struct {
+	Table    string `json:"table"`
+	Column   string `json:"column"`
+	Probe    string `json:"probe"`
+	ProbeKey string `json:"probe_key"`
+	// PassRate is the estimated share of Probe's rows the filter keeps.
+	PassRate float64 `json:"pass_rate"`
+	SQL      string  `json:"-"`
+}
+
+func (j *BloomJoin) describe() string {
+	return fmt.Sprintf("pre-filtering %s through the Bloom filter on %s.%s (about %.1f%% of its rows kept)",
+		j.Probe, j.Table, j.Column, j.PassRate*100)
+}
+
+// BloomFilterSource is a derived table reading probe's rows whose probeKey
+// the Bloom filter on table.column may contain.
+func BloomFilterSource(probe, probeKey, table, column string) string {
+	quote := func(s string) string { return "'" + strings.ReplaceAll(s, "'", "''") + "'" }
+	return fmt.Sprintf(`(SELECT * FROM %s WHERE %s(%s, %s, "%s"))`,
+		probe, BloomFunction, quote(table), quote(column), probeKey)
+}
+
+// BloomPassRate estimates the share of a probed table's rows a Bloom
+// filter over items keys of the other side keeps: the rows whose key is
+// among them, assuming each of the probed table's distinct keys is as
+// frequent, plus the false positives among the rest.
+func BloomPassRate(items uint64, fpr float64, probeDistinct int64) float64 {
+	if probeDistinct <= 0 {
+		return 1
+	}
+	matched := math.Min(1, float64(items)/float64(probeDistinct))
+	return matched + (1-matched)*fpr
+}
+
+// evaluateBloomJoin finds a Bloom filter to pre-filter q's inner
+// equi-join of two tables through: one on the join key of the side with
+// fewer rows, not degraded and built over at least the rows it has now,
+// expected to keep at most bloomMaxPass of the other side's rows by that
+// side's analyzed distinct keys. It returns nil when there is none, or on
+// databases other than SQLite, which can't call the filter.
+func (p *Planner) evaluateBloomJoin(ctx context.Context, db *sql.DB, q *Query, sqlText string) *BloomJoin {
+	if !p.allows(StrategyBloomJoin) || storage.RequireSQLite("Bloom joins") != nil || len(q.With) > 0 || len(q.Selects) != 1 {
+		return nil
+	}
+	s := q.Main()
+	if len(s.From) != 2 || s.From[0].Subquery != nil || s.From[1].Subquery != nil ||
+		(s.From[1].Join != "JOIN" && s.From[1].Join != "INNER JOIN") {
+		return nil
+	}
+	firstKey, secondKey, ok := equiJoinKeys(s)
+	if !ok || strings.EqualFold(s.From[0].Name, s.From[1].Name) {
+		return nil
+	}
+	firstRows, err := storage.EstimateRowCount(ctx, db, s.From[0].Name)
+	if err != nil {
+		return nil
+	}
+	secondRows, err := storage.EstimateRowCount(ctx, db, s.From[1].Name)
+	if err != nil {
+		return nil
+	}
+	small, probe := 0, 1
+	keys := []string{firstKey, secondKey}
+	if secondRows < firstRows {
+		small, probe = 1, 0
+	}
+	j := BloomJoin{Table: s.From[small].Name, Column: keys[small], Probe: s.From[probe].Name, ProbeKey: keys[probe]}
+
+	var data []byte
+	var baseRows, smallRows int64
+	err = db.QueryRowContext(ctx, `SELECT sketch_data, COALESCE(base_row_count, 0) FROM aqe_sketches
+		WHERE table_name = ? AND column_name = ? COLLATE NOCASE AND sketch_type = ? AND COALESCE(degraded, 0) = 0`,
+		j.Table, j.Column, string(storage.BloomFilterType)).Scan(&data, &baseRows)
+	if err != nil {
+		return nil
+	}
+	// rows added since the filter was built may hold keys it lacks
+	if smallRows, err = storage.CountRows(ctx, db, j.Table); err != nil || smallRows > baseRows {
+		return nil
+	}
+	bf, err := sketches.DeserializeBloomFilter(data)
+	if err != nil {
+		return nil
+	}
+	stats, err := storage.GetColumnStats(ctx, db, j.Probe)
+	if err != nil {
+		return nil
+	}
+	j.PassRate = BloomPassRate(bf.Items(), bf.EstimatedFPR(), storage.ColumnDistinct(stats)[strings.ToLower(j.ProbeKey)])
+	if j.PassRate > bloomMaxPass {
+		return nil
+	}
+
+	ref := &s.From[probe]
+	j.SQL = replaceRefs(sqlText, []sampleRef{{ref: ref, owner: s}}, func(r sampleRef) string {
+		source := BloomFilterSource(j.Probe, j.ProbeKey, j.Table, j.Column)
+		if r.ref.Alias == "" {
+			return source + " AS " + r.ref.Name
+		}
+		return source
+	})
+	return &j
+}
@@ -0,0 +1,72 @@
+package planner
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+var informationSchemaRe = regexp.MustCompile(`(?i)\binformation_schema\s*\.\s*(tables|columns)\b`)
+
+// expandInformationSchema replaces the references in sqlText to
+// information_schema.tables and information_schema.columns, which SQLite
+// lacks and which know nothing of samples, with the queries standing in
+// for them (see storage.InformationSchemaTables), so tools that discover
+// a database through them see its samples for what they are. References
+// keep their alias, or get the view's name as one, and columns qualified
+// by the view's full name are qualified by that. ok is false when sqlText
+// has no such reference.
+func expandInformationSchema(sqlText string) (expanded string, ok bool) {
+	masked := maskLiterals(sqlText)
+	locs := informationSchemaRe.FindAllStringSubmatchIndex(masked, -1)
+	if len(locs) == 0 {
+		return sqlText, false
+	}
+	var b strings.Builder
+	prev := 0
+	for _, loc := range locs {
+		b.WriteString(sqlText[prev:loc[0]])
+		prev = loc[1]
+		view := strings.ToLower(sqlText[loc[2]:loc[3]])
+		rest := strings.TrimLeftFunc(masked[loc[1]:], unicode.IsSpace)
+		if strings.HasPrefix(rest, ".") {
+			// information_schema.tables.table_name
+			b.WriteString(view)
+			continue
+		}
+		query := storage.InformationSchemaTables()
+		if view == "columns" {
+			query = storage.InformationSchemaColumns()
+		}
+		b.WriteString("(" + query + ")")
+		if word := leadingWord(rest); word == "" || notAlias[strings.ToLower(word)] {
+			b.WriteString(" AS " + view)
+		}
+	}
+	b.WriteString(sqlText[prev:])
+	return b.String(), true
+}
+
+// leadingWord returns the identifier s starts with, if any.
+func leadingWord(s string) string {
+	end := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' })
+	if end < 0 {
+		return s
+	}
+	return s[:end]
+}
+
+// catalogPlan answers a query over the emulated information_schema views
+// exactly. Its answer changes with any table, so it is never cached.
+func catalogPlan(sqlText, expanded string) *Plan {
+	return &Plan{
+		Type:        PlanExact,
+		SQL:         expanded,
+		OriginalSQL: sqlText,
+		Reason:      "information_schema is answered exactly from the database's catalog and AQE's synopsis records",
+		Fallback:    "information_schema",
+		Catalog:     true,
+	}
+}
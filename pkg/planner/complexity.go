@@ -0,0 +1,96 @@
+package planner
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultComplexityThreshold is the score above which a query is considered
+// too complex to approximate safely.
+const DefaultComplexityThreshold = 8.0
+
+var (
+	joinKwRe    = regexp.MustCompile(`(?i)\bjoin\b`)
+	subqueryRe  = regexp.MustCompile(`(?i)\(\s*select\b`)
+	windowRe    = regexp.MustCompile(`(?i)\bover(\s*\(|\s+[a-z_][a-z0-9_]*\b)`) // OVER (...) or OVER name
+	cteRe       = regexp.MustCompile(`(?i)^\s*with\b`)
+	setOpRe     = regexp.MustCompile(`(?i)\b(union|intersect|except)\b`)
+	stringLitRe = regexp.MustCompile(`'(?:[^']|'')*'`)
+)
+
+// Complexity summarizes the structural features that make a query risky to
+// rewrite or scale.
+type Complexity struct {
+	Joins           int     `json:"joins"`
+	Subqueries      int     `json:"subqueries"`
+	WindowFunctions int     `json:"window_functions"`
+	SetOperations   int     `json:"set_operations"`
+	HasCTE          bool    `json:"has_cte"`
+	ExpressionDepth int     `json:"expression_depth"`
+	Score           float64 `json:"score"`
+}
+
+// AnalyzeComplexity scores sqlText. String literals are ignored so keywords
+// inside them don't count.
+func AnalyzeComplexity(sqlText string) Complexity {
+	s := stringLitRe.ReplaceAllString(sqlText, "''")
+
+	c := Complexity{
+		Joins:           len(joinKwRe.FindAllStringIndex(s, -1)),
+		Subqueries:      len(subqueryRe.FindAllStringIndex(s, -1)),
+		WindowFunctions: len(windowRe.FindAllStringIndex(s, -1)),
+		SetOperations:   len(setOpRe.FindAllStringIndex(s, -1)),
+		HasCTE:          cteRe.MatchString(s),
+		ExpressionDepth: parenDepth(s),
+	}
+
+	c.Score = 2*float64(c.Joins) + 3*float64(c.Subqueries) + 4*float64(c.WindowFunctions) + 3*float64(c.SetOperations)
+	if c.HasCTE {
+		c.Score += 2
+	}
+	if c.ExpressionDepth > 3 {
+		c.Score += float64(c.ExpressionDepth - 3)
+	}
+	return c
+}
+
+// TooComplex reports whether the score exceeds threshold.
+func (c Complexity) TooComplex(threshold float64) bool {
+	return c.Score > threshold
+}
+
+func parenDepth(s string) int {
+	depth, max := 0, 0
+	for _, r := range s {
+		switch r {
+		case '(':
+			depth++
+			if depth > max {
+				max = depth
+			}
+		case ')':
+			if depth > 0 {
+				depth--
+			}
+		}
+	}
+	return max
+}
+
+func (c Complexity) summary() string {
+	var parts []string
+	add := func(n int, what string) {
+		if n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, what))
+		}
+	}
+	add(c.Joins, "joins")
+	add(c.Subqueries, "subqueries")
+	add(c.WindowFunctions, "window functions")
+	add(c.SetOperations, "set operations")
+	if c.HasCTE {
+		parts = append(parts, "CTE")
+	}
+	return strings.Join(parts, ", ")
+}
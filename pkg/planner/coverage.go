@@ -0,0 +1,165 @@
+package planner
+
+import (
+	"math"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// AdvisorSampleFraction is the sample fraction assumed when estimating the
+// benefit of a sample that does not exist yet.
+const AdvisorSampleFraction = 0.01
+
+// SynopsisNeed is a synopsis the planner could use to approximate a query.
+type SynopsisNeed struct {
+	Kind   string `json:"kind"` // "sample", "hyperloglog", "countmin" or "tdigest"
+	Column string `json:"column,omitempty"`
+}
+
+// NeededSynopses returns the table sqlText reads and the synopses the
+// planner would consider for it, using the same rules as Plan. Queries the
+// planner always runs exact need nothing.
+func (p *Planner) NeededSynopses(sqlText string) (string, []SynopsisNeed) {
+	q, sqlText, table := p.approximable(sqlText)
+	if q == nil {
+		return "", nil
+	}
+
+	features := p.parseQueryFeatures(q)
+	var needs []SynopsisNeed
+	if features.HasDistinct {
+		if sd, ok := ParseScalarDistinct(sqlText); ok {
+			needs = append(needs, SynopsisNeed{Kind: "hyperloglog", Column: sd.Column})
+		}
+	}
+	if features.IsHeavyHitter {
+		if g, ok := ParseGroupCount(sqlText); ok {
+			needs = append(needs, SynopsisNeed{Kind: "countmin", Column: g.Column})
+		}
+	}
+	if features.HasQuantile {
+		if sq, ok := ParseScalarQuantile(sqlText); ok {
+			needs = append(needs, SynopsisNeed{Kind: "tdigest", Column: sq.Column})
+		}
+	}
+	if _, ok := q.sampleRefs(table); ok {
+		needs = append(needs, SynopsisNeed{Kind: "sample"})
+	}
+	return table, needs
+}
+
+// SampleColumns returns the table a sample would stand in for when
+// answering sqlText and the columns the query names from it (see
+// SampleCovers); all is set when it selects *. table is "" when no sample
+// could answer the query.
+func (p *Planner) SampleColumns(sqlText string) (table string, columns []string, all bool) {
+	q, _, table := p.approximable(sqlText)
+	if q == nil {
+		return "", nil, false
+	}
+	refs, ok := q.sampleRefs(table)
+	if !ok {
+		return "", nil, false
+	}
+	columns, all = sampleColumns(refs)
+	return table, columns, all
+}
+
+// approximable parses sqlText, after decorrelating it, when Plan would
+// consider approximating it, returning the statement it would plan and its
+// base table. q is nil for queries Plan always runs exact.
+func (p *Planner) approximable(sqlText string) (q *Query, rewritten, table string) {
+	if !isSelect(sqlText) {
+		return nil, "", ""
+	}
+	complexity := AnalyzeComplexity(sqlText)
+	if complexity.TooComplex(p.complexityThreshold) || complexity.WindowFunctions > 0 {
+		return nil, "", ""
+	}
+	if hasCorrelated(DetectSubqueries(sqlText)) {
+		decorrelated, _, remaining := Decorrelate(sqlText)
+		if remaining {
+			return nil, "", ""
+		}
+		sqlText = decorrelated
+	}
+
+	q, err := Parse(sqlText)
+	if err != nil {
+		return nil, "", ""
+	}
+	table = q.BaseTable()
+	if table == "" || looksLikeSample(table) {
+		return nil, "", ""
+	}
+	return q, sqlText, table
+}
+
+// SynopsisSpeedup is the cost model's speedup over exact for answering a
+// query on a rows-row table with need.
+func (p *Planner) SynopsisSpeedup(need SynopsisNeed, rows int64) float64 {
+	if need.Kind == "sample" {
+		return p.SampleSpeedup(AdvisorSampleFraction, rows)
+	}
+	return p.speedup(float64(rows)*p.costModel.ScanCostPerRow, p.costModel.SketchQueryCost)
+}
+
+// SampleSpeedup is SynopsisSpeedup for a sample of fraction.
+func (p *Planner) SampleSpeedup(fraction float64, rows int64) float64 {
+	exact := float64(rows) * p.costModel.ScanCostPerRow
+	return p.speedup(exact, exact*fraction+p.costModel.SampleSetupCost)
+}
+
+func (p *Planner) speedup(exact, cost float64) float64 {
+	if cost <= 0 || exact <= cost {
+		return 1
+	}
+	return exact / cost
+}
+
+// SynopsisBuildMs is the cost model's time to build need over a rows-row
+// table: a scan, plus writing the out rows a sample keeps or grouping the
+// out distinct values a HyperLogLog or Count-Min build reads.
+func (p *Planner) SynopsisBuildMs(need SynopsisNeed, rows, out int64) float64 {
+	cost := float64(rows) * p.costModel.ScanCostPerRow
+	switch need.Kind {
+	case "sample":
+		cost += float64(out) * p.costModel.ScanCostPerRow
+	case "hyperloglog", "countmin":
+		cost += float64(out) * p.costModel.HashCostPerGroup
+	}
+	return p.costModel.LatencyMs(cost)
+}
+
+// SampleError is the relative error Plan estimates for a sample of fraction
+// answering a query whose WHERE clause keeps matchingRows of its table.
+func SampleError(fraction, matchingRows float64) float64 {
+	return math.Sqrt(1.0 / (fraction * matchingRows))
+}
+
+// SketchError is the relative error Plan estimates for an answer from a
+// sketch of kind.
+func SketchError(kind string) float64 {
+	switch kind {
+	case "hyperloglog":
+		// HyperLogLog standard error ≈ 1.04/√m, assume m=1024
+		return 1.04 / math.Sqrt(1024) // ≈ 3.25%
+	case "countmin":
+		// Count-Min error ≈ ε * total_count, assume ε = 0.01
+		return 0.01
+	case "tdigest":
+		// t-digest rank error ≈ 1/compression, assume compression = 100
+		return 0.01
+	}
+	return 1
+}
+
+// Selectivity estimates the share of its base table's rows sqlText keeps,
+// as Plan would from columns; it is 1 for queries Plan runs exact.
+func (p *Planner) Selectivity(sqlText string, columns map[string]storage.ColumnStats) float64 {
+	q, _, table := p.approximable(sqlText)
+	if q == nil {
+		return 1
+	}
+	return whereSelectivity(q, table, columns)
+}
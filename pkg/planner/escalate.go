@@ -0,0 +1,78 @@
+package planner
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+)
+
+// Escalate plans a re-run of plan, a sample plan whose realized error
+// missed its tolerance: the query on the next larger uniform sample that
+// covers it, or exactly when there is none or plan is a wander join, whose
+// walks already ran as long as they could. It returns nil for plans that
+// can't be escalated: other types, direct queries on samples, and plans
+// pinned by a SAMPLE hint.
+func (p *Planner) Escalate(ctx context.Context, db *sql.DB, plan *Plan) (*Plan, error) {
+	if plan.Type != PlanSample || plan.baseSQL == "" {
+		return nil, nil
+	}
+	if plan.WanderJoin != nil {
+		exact := &Plan{
+			Type:   PlanExact,
+			SQL:    plan.baseSQL,
+			Table:  plan.Table,
+			Reason: fmt.Sprintf("escalated from a wander join: %d walks missed the tolerance", plan.WanderJoin.MaxWalks),
+		}
+		return escalated(plan, exact), nil
+	}
+	q, err := Parse(plan.baseSQL)
+	if err != nil {
+		return nil, err
+	}
+
+	samples := uniformSamples(ctx, db, plan.Table, q)
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Fraction < samples[j].Fraction })
+	features := p.parseQueryFeatures(q)
+	p.applyRoles(ctx, db, plan.Table, &features)
+	for _, s := range samples {
+		if s.Fraction <= plan.SampleFraction || !p.sampleReady(ctx, db, s.SampleTable) {
+			continue
+		}
+		stats := &TableStats{RowCount: plan.TableRows, Selectivity: plan.Selectivity, BestSampleTable: s.SampleTable, BestSampleFraction: s.Fraction}
+		next := p.evaluateSampleStrategy(ctx, db, q, plan.baseSQL, plan.Table, features, stats)
+		if next == nil {
+			continue
+		}
+		applyResultOrder(q, next)
+		next.Reason = fmt.Sprintf("escalated from the %.2f%% sample: %s", plan.SampleFraction*100, next.Reason)
+		return escalated(plan, next), nil
+	}
+
+	exact := &Plan{
+		Type:   PlanExact,
+		SQL:    plan.baseSQL,
+		Table:  plan.Table,
+		Reason: fmt.Sprintf("escalated from the %.2f%% sample: no larger sample covers the query", plan.SampleFraction*100),
+	}
+	return escalated(plan, exact), nil
+}
+
+// escalated carries what plan was planned under over to next.
+func escalated(plan, next *Plan) *Plan {
+	next.OriginalSQL = plan.OriginalSQL
+	next.Rewrites = plan.Rewrites
+	next.Hints = plan.Hints
+	next.TableRows = plan.TableRows
+	next.Selectivity = plan.Selectivity
+	next.TimeBuckets = plan.TimeBuckets
+	next.MaxRelError = plan.MaxRelError
+	next.ConfidenceLevel = plan.ConfidenceLevel
+	if next.ResultLimit > 0 {
+		next.RankConfidence = plan.RankConfidence
+	}
+	next.Complexity = plan.Complexity
+	next.Escalated = true
+	next.baseSQL = plan.baseSQL
+	return next
+}
@@ -0,0 +1,165 @@
+package planner
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/aqeerr"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// ParsePredicate checks that predicate is a condition on table's rows a
+// filtered sample can be restricted to: a WHERE clause without subqueries
+// or aggregates. It returns the columns predicate reads.
+func ParsePredicate(table, predicate string) ([]string, error) {
+	q, err := Parse("SELECT * FROM " + table + " WHERE " + predicate)
+	if err != nil {
+		return nil, fmt.Errorf("%w: predicate %q: %v", aqeerr.ErrUnsupportedQuery, predicate, err)
+	}
+	s := q.Main()
+	if len(q.Selects) != 1 || s.Where == nil || strings.TrimSpace(s.Where.Text) != strings.TrimSpace(predicate) ||
+		len(s.GroupBy) > 0 || s.Having != nil || len(s.OrderBy) > 0 || s.Limit != nil {
+		return nil, fmt.Errorf("%w: predicate %q is not a single WHERE condition", aqeerr.ErrUnsupportedQuery, predicate)
+	}
+	if len(s.Subqueries) > 0 {
+		return nil, fmt.Errorf("%w: predicate %q has a subquery", aqeerr.ErrUnsupportedQuery, predicate)
+	}
+	for _, c := range s.Calls {
+		if aggregateFuncs[c.Name] {
+			return nil, fmt.Errorf("%w: predicate %q has an aggregate", aqeerr.ErrUnsupportedQuery, predicate)
+		}
+	}
+	seen := make(map[string]bool)
+	var columns []string
+	for _, c := range s.Where.Columns {
+		c = unqualified(c)
+		if !seen[strings.ToLower(c)] {
+			seen[strings.ToLower(c)] = true
+			columns = append(columns, c)
+		}
+	}
+	return columns, nil
+}
+
+// predicateCovers reports whether every row where matches is one
+// predicate matches too: each top-level conjunct of predicate, or
+// predicate itself when it has a top-level OR, is also one of where's.
+// Conjuncts are compared as written, ignoring case, spacing, table
+// qualifiers and enclosing parentheses.
+func predicateCovers(where, predicate string) bool {
+	wToks, err := tokenize(where)
+	if err != nil {
+		return false
+	}
+	wParts, ok := conjuncts(wToks)
+	if !ok {
+		return false
+	}
+	pToks, err := tokenize(predicate)
+	if err != nil || len(pToks) == 0 {
+		return false
+	}
+	pParts, ok := conjuncts(pToks)
+	if !ok {
+		pParts = [][]token{pToks}
+	}
+	have := make(map[string]bool, len(wParts))
+	for _, c := range wParts {
+		have[conjunctKey(c)] = true
+	}
+	for _, c := range pParts {
+		if !have[conjunctKey(c)] {
+			return false
+		}
+	}
+	return true
+}
+
+// conjunctKey is the form conjuncts are compared in by predicateCovers.
+func conjunctKey(toks []token) string {
+	for len(toks) >= 2 && toks[0].isPunct("(") && closes(toks) {
+		toks = toks[1 : len(toks)-1]
+	}
+	var b strings.Builder
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		if t.isIdent() && i+2 < len(toks) && toks[i+1].isPunct(".") && toks[i+2].isIdent() {
+			continue // qualifier; the dot is skipped next
+		}
+		if t.isPunct(".") && i > 0 && toks[i-1].isIdent() {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		switch t.kind {
+		case tokString, tokNumber:
+			b.WriteString(t.text)
+		default:
+			b.WriteString(strings.ToLower(t.name()))
+		}
+	}
+	return b.String()
+}
+
+// closes reports whether the parenthesis opening toks closes at its end.
+func closes(toks []token) bool {
+	depth := 0
+	for i, t := range toks {
+		switch {
+		case t.isPunct("("):
+			depth++
+		case t.isPunct(")"):
+			depth--
+			if depth == 0 {
+				return i == len(toks)-1
+			}
+		}
+	}
+	return false
+}
+
+// filteredSamples returns table's filtered samples, by their records, that
+// copied the columns q needs and whose predicate covers the WHERE clause
+// of q's main SELECT, each at its fraction of the predicate's rows and
+// holding that fraction of the share of the table the predicate keeps.
+func filteredSamples(ctx context.Context, db *sql.DB, table string, q *Query, columns map[string]storage.ColumnStats) []sampleCandidate {
+	s := q.Main()
+	if s.Where == nil || len(s.From) != 1 || s.From[0].Subquery != nil || !strings.EqualFold(s.From[0].Name, table) {
+		return nil
+	}
+	var need []string
+	var all bool
+	if refs, ok := q.sampleRefs(table); ok {
+		need, all = sampleColumns(refs)
+	}
+	samples, err := storage.ListSamples(ctx, db, table)
+	if err != nil {
+		return nil
+	}
+	var out []sampleCandidate
+	var tableCols []string
+	for _, info := range samples {
+		if info.Predicate == "" || info.StrataColumn != "" || info.Fraction <= 0 || info.Fraction >= 1 {
+			continue
+		}
+		if !predicateCovers(s.Where.Text, info.Predicate) {
+			continue
+		}
+		if len(info.Columns) > 0 && tableCols == nil {
+			tableCols, _ = storage.TableColumns(ctx, db, table)
+		}
+		if !SampleCovers(info.Columns, need, all, tableCols) {
+			continue
+		}
+		out = append(out, sampleCandidate{
+			table:     info.SampleTable,
+			predicate: info.Predicate,
+			fraction:  info.Fraction,
+			size:      info.Fraction * EstimateSelectivity(info.Predicate, columns),
+		})
+	}
+	return out
+}
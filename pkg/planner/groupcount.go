@@ -0,0 +1,135 @@
+package planner
+
+import (
+	"strconv"
+	"strings"
+)
+
+// GroupCount is a query that only counts rows per value of one column of
+// one table, the shape a Count-Min sketch with tracked keys answers:
+//
+//	SELECT col, COUNT(*) [AS n] FROM t GROUP BY col [ORDER BY ...] [LIMIT k]
+//
+// Outputs are the result column names in SELECT order and KeyItem indexes
+// the group key among them. OrderBy is "", "key" or "count".
+type GroupCount struct {
+	Table   string
+	Column  string
+	Outputs []string
+	KeyItem int
+	OrderBy string
+	Desc    bool
+	Limit   int // -1 when absent
+}
+
+// ParseGroupCount reports whether sqlText has the GroupCount shape.
+func ParseGroupCount(sqlText string) (GroupCount, bool) {
+	q, err := Parse(sqlText)
+	if err != nil || len(q.With) > 0 || len(q.Selects) != 1 {
+		return GroupCount{}, false
+	}
+	s := q.Main()
+	if s.Distinct || len(s.Items) != 2 || len(s.From) != 1 || s.From[0].Subquery != nil || s.Where != nil ||
+		len(s.GroupBy) != 1 || s.Having != nil || s.Offset != nil || len(s.Calls) != 1 || len(s.Subqueries) > 0 ||
+		len(s.OrderBy) > 1 {
+		return GroupCount{}, false
+	}
+
+	key := s.GroupBy[0]
+	if n, err := strconv.Atoi(key.Text); err == nil {
+		if n < 1 || n > len(s.Items) {
+			return GroupCount{}, false
+		}
+		key = s.Items[n-1].Expr
+	}
+	column, ok := bareColumn(key)
+	if !ok {
+		return GroupCount{}, false
+	}
+
+	g := GroupCount{Table: s.From[0].Name, Column: column, KeyItem: -1, Limit: -1}
+	countItem := -1
+	for i, item := range s.Items {
+		name := item.Alias
+		if col, ok := bareColumn(item.Expr); ok && strings.EqualFold(col, column) {
+			g.KeyItem = i
+			if name == "" {
+				name = col
+			}
+		} else if isCountStar(s.Calls[0], item.Expr) {
+			countItem = i
+			if name == "" {
+				name = item.Expr.Text
+			}
+		}
+		g.Outputs = append(g.Outputs, name)
+	}
+	if g.KeyItem < 0 || countItem < 0 {
+		return GroupCount{}, false
+	}
+
+	if len(s.OrderBy) == 1 {
+		target, desc := orderDirection(s.OrderBy[0].Text)
+		item := orderTarget(target, s.Items, g.Outputs)
+		switch {
+		case item == g.KeyItem || item < 0 && strings.EqualFold(unqualified(target), column):
+			g.OrderBy = "key"
+		case item == countItem:
+			g.OrderBy = "count"
+		default:
+			return GroupCount{}, false
+		}
+		g.Desc = desc
+	}
+	if s.Limit != nil {
+		n, err := strconv.Atoi(strings.TrimSpace(s.Limit.Text))
+		if err != nil || n < 0 {
+			return GroupCount{}, false
+		}
+		g.Limit = n
+	}
+	return g, true
+}
+
+// bareColumn returns the column e consists of, if it is just one column
+// reference.
+func bareColumn(e Expr) (string, bool) {
+	if len(e.Columns) != 1 || unqualified(e.Text) != unqualified(e.Columns[0]) {
+		return "", false
+	}
+	return unqualified(e.Columns[0]), true
+}
+
+func isCountStar(c FuncCall, e Expr) bool {
+	return c.Name == "COUNT" && !c.Distinct && !c.Window && len(c.Args) == 1 &&
+		(c.Args[0] == "*" || c.Args[0] == "1") && c.start == e.start && c.end == e.end
+}
+
+// orderDirection splits a trailing ASC or DESC off an ORDER BY term.
+func orderDirection(term string) (string, bool) {
+	fields := strings.Fields(term)
+	if n := len(fields); n > 1 {
+		switch strings.ToLower(fields[n-1]) {
+		case "desc":
+			return strings.Join(fields[:n-1], " "), true
+		case "asc":
+			return strings.Join(fields[:n-1], " "), false
+		}
+	}
+	return term, false
+}
+
+// orderTarget resolves an ORDER BY term to the SELECT item it names by
+// ordinal, output name or expression, or -1.
+func orderTarget(term string, items []SelectItem, outputs []string) int {
+	if n, err := strconv.Atoi(term); err == nil {
+		return n - 1
+	}
+	norm := func(s string) string { return strings.ToLower(strings.Join(strings.Fields(s), "")) }
+	for i, item := range items {
+		if norm(term) == norm(outputs[i]) || norm(term) == norm(item.Expr.Text) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,141 @@
+package planner
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/aqeerr"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/sketches"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// SetMaxGroups caps the groups a GROUP BY query may return: one estimated
+// to return more, without a LIMIT of at most n, is answered with its top n
+// groups by count when it only counts rows per value of one column, from
+// the column's Count-Min sketch (or exactly, when exact answers are
+// preferred), and rejected otherwise. 0 turns the cap off.
+func (p *Planner) SetMaxGroups(n int64) {
+	p.maxGroups = n
+}
+
+// guardGroups applies the SetMaxGroups cap to plan, the plan chosen for q
+// (as sqlText) on table, and records the groups it is estimated to return.
+// Queries whose groups can't be estimated are let through.
+func (p *Planner) guardGroups(ctx context.Context, db *sql.DB, q *Query, sqlText, table string, features QueryFeatures, stats *TableStats, plan *Plan, preferExact bool) (*Plan, error) {
+	if !features.HasGroupBy {
+		return plan, nil
+	}
+	groups, ok := p.estimateGroups(ctx, db, table, features.GroupByColumns, stats)
+	if !ok {
+		return plan, nil
+	}
+	plan.EstimatedGroups = groups
+	if p.maxGroups <= 0 || groups <= float64(p.maxGroups) {
+		return plan, nil
+	}
+	if limit, ok := literalLimit(q.Main()); ok && limit <= p.maxGroups {
+		return plan, nil // already pages through the groups
+	}
+
+	if topK, ok := p.topGroupCounts(q, sqlText, table, stats, preferExact || !p.allows(StrategySketch)); ok {
+		topK.Reason = fmt.Sprintf("an estimated %.0f groups exceed the limit of %d: %s", groups, p.maxGroups, topK.Reason)
+		topK.OriginalSQL = plan.OriginalSQL
+		topK.Complexity = plan.Complexity
+		topK.TableRows = plan.TableRows
+		topK.EstimatedGroups = groups
+		topK.GroupLimit = p.maxGroups
+		return topK, nil
+	}
+	return nil, fmt.Errorf("%w: an estimated %.0f groups exceed the limit of %d; add a LIMIT of at most %d, with OFFSET to page through them",
+		aqeerr.ErrTooManyGroups, groups, p.maxGroups, p.maxGroups)
+}
+
+// estimateGroups estimates the groups of a GROUP BY over columns of table:
+// the product of their distinct counts from the table's statistics, or
+// from HyperLogLog sketches for columns without one, at most the rows the
+// WHERE clause keeps. ok is false unless every key is a column with one.
+func (p *Planner) estimateGroups(ctx context.Context, db *sql.DB, table string, columns []string, stats *TableStats) (float64, bool) {
+	groups := 1.0
+	for _, c := range columns {
+		column := unqualified(strings.TrimSpace(c))
+		if n, ok := stats.groupCount([]string{column}); ok {
+			groups *= n
+			continue
+		}
+		data, _, err := storage.GetSketch(ctx, db, table, column, "hyperloglog")
+		if err != nil {
+			return 0, false
+		}
+		hll, err := sketches.DeserializeHyperLogLog(data)
+		if err != nil || hll.Count() == 0 {
+			return 0, false
+		}
+		groups *= float64(hll.Count())
+	}
+	if rows := stats.matchingRows(); rows > 0 {
+		groups = math.Min(groups, rows)
+	}
+	return groups, len(columns) > 0
+}
+
+// topGroupCounts plans q, a query counting rows per value of one column
+// (see GroupCount), as its first SetMaxGroups groups: in the order it asks
+// for, or the largest counts first when it doesn't. They are read from the
+// column's Count-Min sketch, or, with exact set or should the sketch not
+// track every key, by running the same bounded SQL.
+func (p *Planner) topGroupCounts(q *Query, sqlText, table string, stats *TableStats, exact bool) (*Plan, bool) {
+	g, ok := ParseGroupCount(sqlText)
+	if !ok || !exact && !stats.HasSketches[sketchKey("countmin", g.Column)] {
+		return nil, false
+	}
+	s := q.Main()
+	items := make([]string, len(s.Items))
+	for i, item := range s.Items {
+		items[i] = item.Expr.Text
+		if item.Alias != "" {
+			items[i] += " AS " + item.Alias
+		}
+	}
+	from := s.From[0].Name
+	if s.From[0].Alias != "" {
+		from += " " + s.From[0].Alias
+	}
+	order := fmt.Sprintf("%d DESC", 2-g.KeyItem) // the count's ordinal
+	if len(s.OrderBy) == 1 {
+		order = s.OrderBy[0].Text
+	}
+	topSQL := fmt.Sprintf("SELECT %s FROM %s GROUP BY %s ORDER BY %s LIMIT %d",
+		strings.Join(items, ", "), from, s.GroupBy[0].Text, order, p.maxGroups)
+	if exact {
+		return &Plan{
+			Type:          PlanExact,
+			SQL:           topSQL,
+			Table:         table,
+			EstimatedCost: float64(stats.RowCount) * p.costModel.ScanCostPerRow,
+			Reason:        fmt.Sprintf("returning the top %d groups", p.maxGroups),
+		}, true
+	}
+	return &Plan{
+		Type:           PlanSketch,
+		SQL:            topSQL, // answered from the sketch by the executor
+		Table:          table,
+		SketchType:     "countmin",
+		SketchColumn:   g.Column,
+		EstimatedCost:  p.costModel.SketchQueryCost,
+		EstimatedError: SketchError("countmin"),
+		Reason:         fmt.Sprintf("answering the top %d groups from the Count-Min sketch on %s", p.maxGroups, g.Column),
+	}, true
+}
+
+// literalLimit returns s's LIMIT when it is a plain number.
+func literalLimit(s *Select) (int64, bool) {
+	if s.Limit == nil {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(s.Limit.Text), 10, 64)
+	return n, err == nil && n >= 0
+}
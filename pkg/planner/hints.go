@@ -0,0 +1,163 @@
+package planner
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Hints are the approximation controls a query can carry in its SQL, for
+// clients that can't set request fields, in optimizer-hint comments:
+//
+//	SELECT /*+ MAX_ERROR(0.05) SAMPLE(purchases, 0.01) */ COUNT(*) FROM purchases
+//
+// MAX_ERROR sets the error tolerance, EXACT asks for an exact answer, and
+// SAMPLE reads the query's base table from its uniform sample of the given
+// fraction whatever the cost model would have picked.
+type Hints struct {
+	MaxError    float64
+	HasMaxError bool
+	Exact       bool
+	// SampleTable and SampleFraction are set by SAMPLE.
+	SampleTable    string
+	SampleFraction float64
+	// Applied lists the hints as given, normalized, for the plan to report.
+	Applied []string
+}
+
+// ParseHints reads the /*+ ... */ comments in sqlText; other comments and
+// string literals are skipped. Names are case-insensitive and separated by
+// spaces or commas. Unknown hints and bad arguments are
+// aqeerr.ErrUnsupportedQuery errors rather than ignored, so a typo doesn't
+// silently change what runs.
+func ParseHints(sqlText string) (Hints, error) {
+	var h Hints
+	for _, body := range hintComments(sqlText) {
+		toks, err := tokenize(body)
+		if err != nil {
+			return Hints{}, unsupported("hint: %v", err)
+		}
+		for i := 0; i < len(toks); {
+			if toks[i].isPunct(",") {
+				i++
+				continue
+			}
+			if toks[i].kind != tokWord {
+				return Hints{}, unsupported("hint: expected a hint name, got %q", toks[i].text)
+			}
+			name := strings.ToUpper(toks[i].text)
+			i++
+			var args []token
+			if i < len(toks) && toks[i].isPunct("(") {
+				end := i + 1
+				for end < len(toks) && !toks[end].isPunct(")") {
+					if !toks[end].isPunct(",") {
+						args = append(args, toks[end])
+					}
+					end++
+				}
+				if end == len(toks) {
+					return Hints{}, unsupported("hint %s: missing )", name)
+				}
+				i = end + 1
+			}
+			if err := h.add(name, args); err != nil {
+				return Hints{}, err
+			}
+		}
+	}
+	if h.Exact && h.SampleTable != "" {
+		return Hints{}, unsupported("hints EXACT and SAMPLE conflict")
+	}
+	return h, nil
+}
+
+// add records one hint.
+func (h *Hints) add(name string, args []token) error {
+	switch name {
+	case "EXACT":
+		if len(args) != 0 {
+			return unsupported("hint EXACT takes no arguments")
+		}
+		h.Exact = true
+		h.Applied = append(h.Applied, "EXACT")
+	case "MAX_ERROR":
+		if len(args) != 1 {
+			return unsupported("hint MAX_ERROR takes one argument, the relative error")
+		}
+		v, err := hintNumber(args[0])
+		if err != nil || v < 0 {
+			return unsupported("hint MAX_ERROR: %q is not a non-negative number", args[0].text)
+		}
+		h.MaxError, h.HasMaxError = v, true
+		h.Applied = append(h.Applied, fmt.Sprintf("MAX_ERROR(%g)", v))
+	case "SAMPLE":
+		if len(args) != 2 || !args[0].isIdent() && args[0].kind != tokString {
+			return unsupported("hint SAMPLE takes a table and a fraction")
+		}
+		f, err := hintNumber(args[1])
+		if err != nil || f <= 0 || f >= 1 {
+			return unsupported("hint SAMPLE: fraction %q must be between 0 and 1", args[1].text)
+		}
+		if h.SampleTable != "" {
+			return unsupported("hint SAMPLE given twice")
+		}
+		h.SampleTable, h.SampleFraction = args[0].name(), f
+		h.Applied = append(h.Applied, fmt.Sprintf("SAMPLE(%s, %g)", h.SampleTable, f))
+	default:
+		return unsupported("unknown hint %s", name)
+	}
+	return nil
+}
+
+// Override applies the hints to a request's tolerance and exactness: a
+// MAX_ERROR hint replaces maxRelError and EXACT forces preferExact.
+func (h Hints) Override(maxRelError float64, preferExact bool) (float64, bool) {
+	if h.HasMaxError {
+		maxRelError = h.MaxError
+	}
+	return maxRelError, preferExact || h.Exact
+}
+
+func hintNumber(t token) (float64, error) {
+	if t.kind != tokNumber {
+		return 0, fmt.Errorf("not a number")
+	}
+	v, err := strconv.ParseFloat(t.text, 64)
+	if err == nil && (math.IsNaN(v) || math.IsInf(v, 0)) {
+		err = fmt.Errorf("not finite")
+	}
+	return v, err
+}
+
+// hintComments returns the bodies of the /*+ ... */ comments in s.
+func hintComments(s string) []string {
+	var bodies []string
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			end, ok := closeQuote(s, i)
+			if !ok {
+				return bodies
+			}
+			i = end - 1
+		case c == '-' && strings.HasPrefix(s[i:], "--"):
+			end := strings.IndexByte(s[i:], '\n')
+			if end < 0 {
+				return bodies
+			}
+			i += end
+		case c == '/' && strings.HasPrefix(s[i:], "/*"):
+			end := strings.Index(s[i+2:], "*/")
+			if end < 0 {
+				return bodies
+			}
+			if body := s[i+2 : i+2+end]; strings.HasPrefix(body, "+") {
+				bodies = append(bodies, body[1:])
+			}
+			i += end + 3
+		}
+	}
+	return bodies
+}
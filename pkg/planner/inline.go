@@ -0,0 +1,120 @@
+package planner
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/sketches"
+)
+
+// ScalarDistinct is a query that is only an unfiltered COUNT(DISTINCT
+// Column) over Table, the shape a HyperLogLog sketch answers on its own.
+// Output is the result column's name: the alias, or the expression as
+// written, which is what SQLite would call it.
+type ScalarDistinct struct {
+	Table  string
+	Column string
+	Output string
+}
+
+// ParseScalarDistinct reports whether sqlText has the ScalarDistinct shape.
+func ParseScalarDistinct(sqlText string) (ScalarDistinct, bool) {
+	q, err := Parse(sqlText)
+	if err != nil || len(q.With) > 0 || len(q.Selects) != 1 {
+		return ScalarDistinct{}, false
+	}
+	s := q.Main()
+	if len(s.Items) != 1 || len(s.From) != 1 || s.From[0].Subquery != nil || s.Where != nil ||
+		len(s.GroupBy) > 0 || s.Having != nil || s.Limit != nil || len(s.Calls) != 1 || len(s.Subqueries) > 0 {
+		return ScalarDistinct{}, false
+	}
+	c, item := s.Calls[0], s.Items[0]
+	if c.Name != "COUNT" || !c.Distinct || c.Window || len(c.Args) != 1 || c.start != item.Expr.start || c.end != item.Expr.end ||
+		len(item.Expr.Columns) != 1 || item.Expr.Columns[0] != unqualified(item.Expr.Columns[0]) {
+		return ScalarDistinct{}, false
+	}
+	sd := ScalarDistinct{Table: s.From[0].Name, Column: item.Expr.Columns[0], Output: item.Alias}
+	if sd.Output == "" {
+		sd.Output = item.Expr.Text
+	}
+	return sd, true
+}
+
+// InlinedSubquery is a scalar subquery replaced by a sketch estimate.
+type InlinedSubquery struct {
+	SQL      string  `json:"sql"`
+	Table    string  `json:"table"`
+	Column   string  `json:"column"`
+	Estimate uint64  `json:"estimate"`
+	RelError float64 `json:"rel_error"` // the sketch's standard error
+}
+
+// inlineSketchSubqueries replaces every uncorrelated scalar
+// COUNT(DISTINCT col) subquery that has a live HyperLogLog sketch, and
+// whose standard error fits maxRelError, with the sketch's estimate.
+func inlineSketchSubqueries(ctx context.Context, db *sql.DB, sqlText string, maxRelError float64) (string, []InlinedSubquery) {
+	subs := DetectSubqueries(sqlText)
+	var inlined []InlinedSubquery
+	out := sqlText
+
+	// replace right to left so earlier offsets stay valid
+	for k := len(subs) - 1; k >= 0; k-- {
+		sq := subs[k]
+		if sq.Kind != SubqueryScalar || sq.Correlated {
+			continue
+		}
+		sd, ok := ParseScalarDistinct(sq.SQL)
+		if !ok {
+			continue
+		}
+		table, column := sd.Table, sd.Column
+
+		var data []byte
+		err := db.QueryRowContext(ctx, `SELECT sketch_data FROM aqe_sketches
+			WHERE table_name = ? AND column_name = ? AND sketch_type = 'hyperloglog' AND COALESCE(degraded, 0) = 0`,
+			table, column).Scan(&data)
+		if err != nil {
+			continue
+		}
+		hll, err := sketches.DeserializeHyperLogLog(data)
+		if err != nil || hll.StandardError() > maxRelError {
+			continue
+		}
+
+		estimate := hll.Count()
+		out = out[:sq.start] + strconv.FormatUint(estimate, 10) + out[sq.end:]
+		inlined = append([]InlinedSubquery{{
+			SQL: sq.SQL, Table: table, Column: column, Estimate: estimate, RelError: hll.StandardError(),
+		}}, inlined...)
+	}
+	return out, inlined
+}
+
+// hybridPlan answers inlined subqueries from sketches and runs the rest of
+// sqlText exactly.
+func hybridPlan(originalSQL, rewritten, table string, inlined []InlinedSubquery) *Plan {
+	var maxErr float64
+	notes := make([]string, 0, len(inlined))
+	for _, in := range inlined {
+		if in.RelError > maxErr {
+			maxErr = in.RelError
+		}
+		notes = append(notes, fmt.Sprintf("inlined COUNT(DISTINCT %s) on %s from HyperLogLog sketch", in.Column, in.Table))
+	}
+	noun := "subquery"
+	if len(inlined) > 1 {
+		noun = "subqueries"
+	}
+	return &Plan{
+		Type:           PlanHybrid,
+		SQL:            rewritten,
+		OriginalSQL:    originalSQL,
+		Table:          table,
+		EstimatedError: maxErr,
+		Reason:         fmt.Sprintf("answered %d scalar %s from sketches; outer query exact", len(inlined), noun),
+		Rewrites:       notes,
+		Inlined:        inlined,
+	}
+}
@@ -0,0 +1,133 @@
+package planner
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// evaluateJoinSynopsisStrategy plans an inner equi-join of two tables on a
+// join synopsis of them on the same keys (see storage.JoinSynopsis): the
+// join is read already made, from the synopsis's two tables paired on
+// their rowids, and scales by 1/fraction like a uniform sample of it. Its
+// error is that of a sample of the synopsis's left table, whose rows are
+// what it drew. joinedRows is the joined table's rows, which every other
+// plan reads in full; it is 0 when no synopsis applies.
+func (p *Planner) evaluateJoinSynopsisStrategy(ctx context.Context, db *sql.DB, q *Query, sqlText string, features QueryFeatures, stats *TableStats, tolerance float64) (plan *Plan, joinedRows float64) {
+	if !p.allows(StrategyJoinSynopsis) || len(q.With) > 0 || len(q.Selects) != 1 || stats.RowCount <= 0 {
+		return nil, 0
+	}
+	s := q.Main()
+	if len(s.From) != 2 || s.From[0].Subquery != nil || s.From[1].Subquery != nil ||
+		(s.From[1].Join != "JOIN" && s.From[1].Join != "INNER JOIN") {
+		return nil, 0
+	}
+	firstKey, secondKey, ok := equiJoinKeys(s)
+	first, second := s.From[0].Name, s.From[1].Name
+	if !ok || strings.EqualFold(first, second) {
+		return nil, 0
+	}
+	for _, table := range []string{first, second} {
+		if refs, ok := q.sampleRefs(table); !ok || len(refs) != 1 {
+			return nil, 0
+		}
+	}
+	synopses, err := storage.ListJoinSynopses(ctx, db, first)
+	if err != nil {
+		return nil, 0
+	}
+
+	var best *storage.JoinSynopsis
+	var bestRows float64
+	var swapped bool
+	for i := range synopses {
+		syn := &synopses[i]
+		// rows is how many rows of the synopsis's left table the query keeps
+		var rows float64
+		var sw bool
+		switch {
+		case strings.EqualFold(syn.LeftTable, first) && strings.EqualFold(syn.RightTable, second) &&
+			strings.EqualFold(syn.LeftKey, firstKey) && strings.EqualFold(syn.RightKey, secondKey):
+			rows = stats.matchingRows()
+		case strings.EqualFold(syn.LeftTable, second) && strings.EqualFold(syn.RightTable, first) &&
+			strings.EqualFold(syn.LeftKey, secondKey) && strings.EqualFold(syn.RightKey, firstKey):
+			rows, sw = float64(syn.LeftRows), true
+		default:
+			continue
+		}
+		if syn.Fraction*rows < minSampleMatches || !p.joinSynopsisReady(ctx, db, syn) {
+			continue
+		}
+		ok := SampleError(syn.Fraction, rows) <= tolerance
+		bestOK := best != nil && SampleError(best.Fraction, bestRows) <= tolerance
+		switch {
+		case best == nil,
+			ok && (!bestOK || syn.Fraction < best.Fraction),
+			!ok && !bestOK && syn.Fraction > best.Fraction:
+			best, bestRows, swapped = syn, rows, sw
+		}
+	}
+	if best == nil {
+		return nil, 0
+	}
+
+	firstTable, secondTable := best.LeftSynopsis, best.RightSynopsis
+	if swapped {
+		firstTable, secondTable = secondTable, firstTable
+	}
+	rewritten, ok := JoinSynopsisSQL(sqlText, firstTable, secondTable)
+	if !ok {
+		return nil, 0
+	}
+	if n, err := storage.EstimateRowCount(ctx, db, second); err == nil {
+		joinedRows = float64(n)
+	}
+	if len(features.TimeBuckets) > 0 {
+		rewritten = withBucketRowCount(rewritten)
+	} else {
+		rewritten = withGroupMoments(rewritten)
+	}
+	return &Plan{
+		Type:           PlanSample,
+		SQL:            rewritten,
+		OriginalSQL:    sqlText,
+		Table:          first,
+		SampleTable:    firstTable,
+		SampleFraction: best.Fraction,
+		JoinTable:      second,
+		JoinSynopsis:   best.Name,
+		Aggregates:     outputAggregates(s),
+		// both of the synopsis's tables are read, one row each per joined row
+		EstimatedCost:  2*float64(best.Rows)*p.costModel.ScanCostPerRow + p.costModel.SampleSetupCost,
+		EstimatedError: SampleError(best.Fraction, math.Max(bestRows, 1)),
+		Reason: fmt.Sprintf("join synopsis: %s holds the join of a %.1f%% sample of %s with %s on %s = %s",
+			best.Name, best.Fraction*100, best.LeftTable, best.RightTable, best.LeftKey, best.RightKey),
+	}, joinedRows
+}
+
+// joinSynopsisReady reports whether both of syn's tables are there to read.
+func (p *Planner) joinSynopsisReady(ctx context.Context, db *sql.DB, syn *storage.JoinSynopsis) bool {
+	for _, t := range []string{syn.LeftSynopsis, syn.RightSynopsis} {
+		if exists, err := storage.TableExists(ctx, db, t); err != nil || !exists {
+			return false
+		}
+	}
+	return true
+}
+
+// JoinSynopsisSQL returns sqlText, an inner join of two tables on an ON
+// condition, reading the join from a join synopsis instead: first holds
+// the rows of the table it names first and second those of the other,
+// paired on their rowids like a staged universe join (see
+// StageUniverseJoin). ok is false when sqlText is no such join.
+func JoinSynopsisSQL(sqlText, first, second string) (string, bool) {
+	_, rewrite, ok := StageUniverseJoin(sqlText)
+	if !ok {
+		return sqlText, false
+	}
+	return rewrite(first, second), true
+}
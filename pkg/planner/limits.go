@@ -0,0 +1,128 @@
+package planner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/aqeerr"
+)
+
+// The limits a QueryLimits check names in a LimitError.
+const (
+	LimitJoins           = "joins"
+	LimitExpressionDepth = "expression_depth"
+	LimitTables          = "tables"
+)
+
+// QueryLimits are hard caps on the shape of a statement, checked on its
+// tokens before anything parses it, so machine-generated SQL joining
+// hundreds of tables or nesting thousands of parentheses is turned away
+// before the parser recursion, the complexity regexes and the join
+// optimizer's join graph ever see it. A zero field is no limit.
+type QueryLimits struct {
+	// MaxJoins caps the joins, JOIN keywords and commas in FROM lists, in
+	// the whole statement, subqueries included.
+	MaxJoins int `json:"max_joins"`
+	// MaxExpressionDepth caps how deep parentheses nest, counting those of
+	// subqueries and function calls.
+	MaxExpressionDepth int `json:"max_expression_depth"`
+	// MaxTables caps the tables read, a table read twice counting twice.
+	MaxTables int `json:"max_tables"`
+}
+
+// DefaultQueryLimits are the limits a new Planner checks.
+var DefaultQueryLimits = QueryLimits{MaxJoins: 10, MaxExpressionDepth: 20, MaxTables: 16}
+
+// LimitError is a statement over one of its QueryLimits: Actual of what
+// Limit names against the Max allowed. It wraps aqeerr.ErrQueryLimit.
+type LimitError struct {
+	Limit  string `json:"limit"`
+	Max    int    `json:"max"`
+	Actual int    `json:"actual"`
+}
+
+func (e *LimitError) Error() string {
+	what := fmt.Sprintf("%d joins", e.Actual)
+	switch e.Limit {
+	case LimitExpressionDepth:
+		what = fmt.Sprintf("parentheses nested %d deep", e.Actual)
+	case LimitTables:
+		what = fmt.Sprintf("%d table reads", e.Actual)
+	}
+	return fmt.Sprintf("%v: %s exceed the limit of %d", aqeerr.ErrQueryLimit, what, e.Max)
+}
+
+func (e *LimitError) Unwrap() error { return aqeerr.ErrQueryLimit }
+
+// SetQueryLimits replaces DefaultQueryLimits.
+func (p *Planner) SetQueryLimits(l QueryLimits) {
+	p.limits = l
+}
+
+// Check returns a *LimitError for the first of l sqlText exceeds, joins
+// first. SQL that does not tokenize is let through for the parser to
+// reject.
+func (l QueryLimits) Check(sqlText string) error {
+	if l == (QueryLimits{}) {
+		return nil
+	}
+	toks, err := tokenize(sqlText)
+	if err != nil {
+		return nil
+	}
+	joins, depth, tables := measureShape(toks)
+	switch {
+	case l.MaxJoins > 0 && joins > l.MaxJoins:
+		return &LimitError{Limit: LimitJoins, Max: l.MaxJoins, Actual: joins}
+	case l.MaxExpressionDepth > 0 && depth > l.MaxExpressionDepth:
+		return &LimitError{Limit: LimitExpressionDepth, Max: l.MaxExpressionDepth, Actual: depth}
+	case l.MaxTables > 0 && tables > l.MaxTables:
+		return &LimitError{Limit: LimitTables, Max: l.MaxTables, Actual: tables}
+	}
+	return nil
+}
+
+// fromEnd are the keywords that end a FROM clause.
+var fromEnd = map[string]bool{
+	"where": true, "group": true, "having": true, "order": true, "limit": true,
+	"union": true, "intersect": true, "except": true, "window": true,
+}
+
+// measureShape counts the joins and table reads in toks and how deep their
+// parentheses nest, in one pass and without parsing: each parenthesis
+// level tracks whether it is in the FROM clause of a SELECT, where JOIN
+// and commas join and the identifier after them is a table.
+func measureShape(toks []token) (joins, depth, tables int) {
+	type level struct {
+		query, inFrom, wantTable bool
+	}
+	levels := []level{{}}
+	for i, t := range toks {
+		cur := &levels[len(levels)-1]
+		switch {
+		case t.isPunct("("):
+			cur.wantTable = false // a derived table: its own FROM counts
+			levels = append(levels, level{})
+			depth = max(depth, len(levels)-1)
+		case t.isPunct(")"):
+			if len(levels) > 1 {
+				levels = levels[:len(levels)-1]
+			}
+		case t.isWord("select"):
+			cur.query, cur.inFrom, cur.wantTable = true, false, false
+		case t.isWord("from") && cur.query && !(i > 0 && toks[i-1].isWord("distinct")):
+			cur.inFrom, cur.wantTable = true, true
+		case t.kind == tokWord && fromEnd[strings.ToLower(t.text)]:
+			cur.inFrom, cur.wantTable = false, false
+		case cur.inFrom && (t.isWord("join") || t.isPunct(",")):
+			joins++
+			cur.wantTable = true
+		case cur.wantTable:
+			if t.isIdent() {
+				tables++
+			}
+			cur.wantTable = false
+		}
+	}
+	return joins, depth, tables
+}
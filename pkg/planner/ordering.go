@@ -0,0 +1,110 @@
+package planner
+
+import "strings"
+
+// OrderKey is one ORDER BY term of a grouped sample query, resolved to the
+// result column it sorts on.
+type OrderKey struct {
+	Column string `json:"column"`
+	Desc   bool   `json:"desc,omitempty"`
+}
+
+// resultOrder resolves s's ORDER BY to result columns, so the executor can
+// sort the groups again once their estimates are scaled. ok is false when
+// a term isn't a result column (by ordinal, output name or expression) or
+// sets a collation or NULLS FIRST/LAST, which the re-sort wouldn't honour.
+func resultOrder(s *Select) (keys []OrderKey, ok bool) {
+	outputs := make([]string, len(s.Items))
+	for i, item := range s.Items {
+		outputs[i] = item.Alias
+		if outputs[i] == "" {
+			outputs[i] = item.Expr.Text
+		}
+	}
+	for _, term := range s.OrderBy {
+		lower := " " + strings.ToLower(strings.Join(strings.Fields(term.Text), " ")) + " "
+		if strings.Contains(lower, " collate ") || strings.Contains(lower, " nulls ") {
+			return nil, false
+		}
+		target, desc := orderDirection(term.Text)
+		i := orderTarget(target, s.Items, outputs)
+		if i < 0 || i >= len(outputs) {
+			return nil, false
+		}
+		keys = append(keys, OrderKey{Column: outputs[i], Desc: desc})
+	}
+	return keys, len(keys) > 0
+}
+
+// ResultOrder resolves the ORDER BY of sqlText, a single SELECT, to its
+// result columns (see resultOrder). ok is false when it has none, or one
+// a result column can't stand for.
+func ResultOrder(sqlText string) ([]OrderKey, bool) {
+	q, err := Parse(sqlText)
+	if err != nil || len(q.Selects) != 1 {
+		return nil, false
+	}
+	return resultOrder(q.Main())
+}
+
+// applyResultOrder makes plan, a sample plan for q, re-apply q's ORDER BY
+// to its scaled groups. A LIMIT is moved from the SQL to the executor,
+// which applies it after sorting, when groups scaled by their own
+// stratum's fraction can change places when scaled, or when the groups
+// are ranked by an estimate with intervals, so the executor can weigh the
+// groups just past the LIMIT against those within it. The sample's groups
+// are few enough to read in full.
+func applyResultOrder(q *Query, plan *Plan) {
+	s := q.Main()
+	if plan.Type != PlanSample || len(q.Selects) != 1 || len(s.GroupBy) == 0 {
+		return
+	}
+	keys, ok := resultOrder(s)
+	if !ok {
+		return
+	}
+	plan.Order = keys
+	if !(plan.StrataFractions != nil && ordersByScaled(plan)) && !ranksByEstimate(plan) {
+		return
+	}
+	if sqlText, limit, ok := withoutLimit(plan.SQL); ok {
+		plan.SQL, plan.ResultLimit = sqlText, limit
+	}
+}
+
+// ranksByEstimate reports whether plan's first order key is an aggregate
+// the executor bounds per group: a scaled count or sum, or an average.
+func ranksByEstimate(plan *Plan) bool {
+	a, ok := plan.Aggregate(plan.Order[0].Column)
+	return ok && (a.Scaled && a.Function != "EXPR" || a.Function == "AVG")
+}
+
+// ordersByScaled reports whether one of plan's order keys is a column it
+// scales.
+func ordersByScaled(plan *Plan) bool {
+	for _, k := range plan.Order {
+		if a, ok := plan.Aggregate(k.Column); ok && a.Scaled {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutLimit removes the literal LIMIT, without OFFSET, of sqlText's
+// SELECT and returns it.
+func withoutLimit(sqlText string) (string, int64, bool) {
+	q, err := Parse(sqlText)
+	if err != nil || len(q.Selects) != 1 || q.Main().Offset != nil {
+		return sqlText, 0, false
+	}
+	s := q.Main()
+	limit, ok := literalLimit(s)
+	if !ok || limit == 0 {
+		return sqlText, 0, false
+	}
+	kw := strings.LastIndex(strings.ToLower(sqlText[:s.Limit.start]), "limit")
+	if kw < 0 {
+		return sqlText, 0, false
+	}
+	return strings.TrimRight(sqlText[:kw], " \t\r\n") + sqlText[s.Limit.end:], limit, true
+}
@@ -0,0 +1,75 @@
+package planner
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// outlierSamples returns table's outlier samples, by their records, that
+// copied the columns q needs and whose measure q sums, each at its
+// fraction of the rows left after the outliers. The outlier table is
+// small beside the sample, so a candidate's size is its fraction.
+//
+// Only queries whose answer is the sample's plus the outliers' qualify:
+// a single SELECT over table alone whose aggregates all scale, without
+// HAVING or LIMIT, nor ORDER BY when grouped, since both parts' groups
+// are merged after they run.
+func outlierSamples(ctx context.Context, db *sql.DB, table string, q *Query) []sampleCandidate {
+	if len(q.Selects) != 1 {
+		return nil
+	}
+	s := q.Main()
+	if len(s.From) != 1 || s.From[0].Subquery != nil || !strings.EqualFold(s.From[0].Name, table) ||
+		s.Distinct || s.Having != nil || s.Limit != nil || (len(s.GroupBy) > 0 && len(s.OrderBy) > 0) {
+		return nil
+	}
+	aggregates := outputAggregates(s)
+	if len(aggregates) == 0 {
+		return nil
+	}
+	summed := make(map[string]bool)
+	for _, a := range aggregates {
+		if !a.Scaled {
+			return nil
+		}
+		if a.arg != "" && (a.Function == "SUM" || a.Function == "TOTAL") {
+			summed[strings.ToLower(unqualified(a.arg))] = true
+		}
+	}
+	if len(summed) == 0 {
+		return nil
+	}
+
+	var need []string
+	var all bool
+	if refs, ok := q.sampleRefs(table); ok {
+		need, all = sampleColumns(refs)
+	}
+	samples, err := storage.ListSamples(ctx, db, table)
+	if err != nil {
+		return nil
+	}
+	var out []sampleCandidate
+	var tableCols []string
+	for _, info := range samples {
+		if info.OutlierTable == "" || !summed[strings.ToLower(info.OutlierColumn)] || info.Fraction <= 0 || info.Fraction >= 1 {
+			continue
+		}
+		if len(info.Columns) > 0 && tableCols == nil {
+			tableCols, _ = storage.TableColumns(ctx, db, table)
+		}
+		if !SampleCovers(info.Columns, need, all, tableCols) {
+			continue
+		}
+		out = append(out, sampleCandidate{
+			table:    info.SampleTable,
+			outliers: info.OutlierTable,
+			fraction: info.Fraction,
+			size:     info.Fraction,
+		})
+	}
+	return out
+}
@@ -0,0 +1,223 @@
+package planner
+
+import (
+	"fmt"
+	"strings"
+)
+
+// OutputAggregate describes a result column computed from aggregates, so
+// the executor can tell which columns a sample plan must scale without
+// guessing from their names.
+type OutputAggregate struct {
+	// Column is the result column: the item's alias, or its text as
+	// written, which is the name SQLite gives it.
+	Column string `json:"column"`
+	// Function is the aggregate, e.g. "SUM", or "EXPR" for an expression
+	// over several.
+	Function string `json:"function"`
+	Distinct bool   `json:"distinct,omitempty"`
+	// Scaled is set for row counts and sums, and linear expressions of
+	// them, which a sample underestimates by its fraction. Averages,
+	// extremes, quantiles, distinct counts and ratios are not scaled.
+	Scaled bool `json:"scaled"`
+
+	// arg is the argument of a lone unfiltered, non-distinct call, for
+	// the per-group moments of withGroupMoments.
+	arg string
+}
+
+// scaledFuncs are the aggregates that grow with the rows aggregated.
+var scaledFuncs = map[string]bool{"COUNT": true, "SUM": true, "TOTAL": true}
+
+// linearWords are the words that may surround scaled aggregates in an
+// expression without making it non-linear in them.
+var linearWords = map[string]bool{
+	"round": true, "cast": true, "as": true, "coalesce": true, "ifnull": true,
+	"real": true, "integer": true, "int": true, "bigint": true, "numeric": true,
+	"decimal": true, "float": true, "double": true, "precision": true,
+}
+
+// outputAggregates describes the aggregate columns of s's SELECT list.
+func outputAggregates(s *Select) []OutputAggregate {
+	var out []OutputAggregate
+	aggregates := s.Aggregates()
+	for _, item := range s.Items {
+		var calls []FuncCall
+		for _, c := range aggregates {
+			if c.start >= item.Expr.start && c.end <= item.Expr.end {
+				calls = append(calls, c)
+			}
+		}
+		if len(calls) == 0 {
+			continue
+		}
+		a := OutputAggregate{Column: item.Alias, Function: "EXPR", Scaled: true}
+		if a.Column == "" {
+			a.Column = item.Expr.Text
+		}
+		if len(calls) == 1 {
+			c := calls[0]
+			a.Function, a.Distinct = c.Name, c.Distinct
+			if !c.Distinct && !c.Filter && len(c.Args) == 1 && c.Args[0] != "*" {
+				a.arg = c.Args[0]
+			}
+		}
+		for _, c := range calls {
+			if !scaledFuncs[c.Name] || c.Distinct {
+				a.Scaled = false
+			}
+		}
+		if a.Scaled {
+			a.Scaled = linearIn(item.Expr, calls)
+		}
+		out = append(out, a)
+	}
+	return out
+}
+
+// ResultAggregates describes the aggregate columns of sqlText's result,
+// as Plan.Aggregates does for sample plans; it is nil if sqlText doesn't
+// parse.
+func ResultAggregates(sqlText string) []OutputAggregate {
+	q, err := Parse(sqlText)
+	if err != nil {
+		return nil
+	}
+	return outputAggregates(q.Main())
+}
+
+// linearIn reports whether e is a linear expression of calls: only sums
+// and differences of them, multiplied or divided by constants, and wrapped
+// in casts, rounding or null defaults.
+func linearIn(e Expr, calls []FuncCall) bool {
+	toks, err := tokenize(e.Text)
+	if err != nil {
+		return false
+	}
+	// the expression with each call folded into one tokEOF token
+	var elems []token
+	for i := 0; i < len(toks); i++ {
+		off := e.start + toks[i].start
+		folded := false
+		for _, c := range calls {
+			if off != c.start {
+				continue
+			}
+			for i < len(toks) && e.start+toks[i].end < c.end {
+				i++
+			}
+			// COUNT(*) FILTER (WHERE ...) belongs to the call
+			if i+2 < len(toks) && toks[i+1].isWord("filter") && toks[i+2].isPunct("(") {
+				depth := 0
+				for i++; i+1 < len(toks); i++ {
+					if toks[i+1].isPunct("(") {
+						depth++
+					} else if toks[i+1].isPunct(")") {
+						if depth--; depth == 0 {
+							i++
+							break
+						}
+					}
+				}
+			}
+			elems = append(elems, token{kind: tokEOF})
+			folded = true
+			break
+		}
+		if !folded {
+			elems = append(elems, toks[i])
+		}
+	}
+
+	isNumber := func(i int) bool { return i >= 0 && i < len(elems) && elems[i].kind == tokNumber }
+	for i, t := range elems {
+		switch {
+		case t.kind == tokEOF, t.kind == tokNumber:
+		case t.kind == tokWord && linearWords[strings.ToLower(t.text)]:
+		case t.isPunct("(") || t.isPunct(")") || t.isPunct(",") || t.isPunct("+") || t.isPunct("-"):
+		case t.isPunct("*"):
+			if !isNumber(i-1) && !isNumber(i+1) {
+				return false
+			}
+		case t.isPunct("/"):
+			if !isNumber(i + 1) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// GroupRowsColumn is the per-group sample row count that sample plans over
+// aggregate queries add to the SELECT list, with the moments of
+// SumSquaresColumn and ValueRowsColumn; an ungrouped query is one group. The executor derives each group's
+// confidence intervals from them and drops them from the result.
+const GroupRowsColumn = "__group_rows"
+
+// SumSquaresColumn is the per-group sum of squares of the argument of
+// Plan.Aggregates[i], added for SUM, TOTAL and AVG.
+func SumSquaresColumn(i int) string { return fmt.Sprintf("__sumsq_%d", i) }
+
+// ValueRowsColumn is the per-group count of non-null arguments of
+// Plan.Aggregates[i], added for AVG.
+func ValueRowsColumn(i int) string { return fmt.Sprintf("__rows_%d", i) }
+
+// withGroupMoments appends GroupRowsColumn and the moment columns to the
+// outer SELECT list of an aggregate sample query, grouped or not: an
+// ungrouped one answers in one row, which the moments bound as a single
+// group. Compound and SELECT DISTINCT queries, whose rows the extra
+// columns would change, and queries without aggregates are returned as
+// they are.
+func withGroupMoments(sqlText string) string {
+	q, err := Parse(sqlText)
+	if err != nil || len(q.Selects) != 1 {
+		return sqlText
+	}
+	s := q.Main()
+	if s.Distinct || len(s.GroupBy) == 0 && len(s.Aggregates()) == 0 {
+		return sqlText
+	}
+	var b strings.Builder
+	b.WriteString(", COUNT(*) AS " + GroupRowsColumn)
+	for i, a := range outputAggregates(s) {
+		if a.arg == "" {
+			continue
+		}
+		switch a.Function {
+		case "SUM", "TOTAL", "AVG":
+			// in floating point: squares of large integers overflow
+			x := "CAST(" + a.arg + " AS DOUBLE PRECISION)"
+			fmt.Fprintf(&b, ", SUM(%s * %s) AS %s", x, x, SumSquaresColumn(i))
+		}
+		if a.Function == "AVG" {
+			fmt.Fprintf(&b, ", COUNT(%s) AS %s", a.arg, ValueRowsColumn(i))
+		}
+	}
+	end := s.Items[len(s.Items)-1].end
+	return sqlText[:end] + b.String() + sqlText[end:]
+}
+
+// ScaledColumns are the result columns a sample plan scales by the inverse
+// of its fraction.
+func (p *Plan) ScaledColumns() []string {
+	var out []string
+	for _, a := range p.Aggregates {
+		if a.Scaled {
+			out = append(out, a.Column)
+		}
+	}
+	return out
+}
+
+// Aggregate is the description of result column col, if it is an
+// aggregate one.
+func (p *Plan) Aggregate(col string) (OutputAggregate, bool) {
+	for _, a := range p.Aggregates {
+		if a.Column == col {
+			return a, true
+		}
+	}
+	return OutputAggregate{}, false
+}
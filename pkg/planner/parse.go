@@ -0,0 +1,744 @@
+package planner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/aqeerr"
+)
+
+type tokenKind uint8
+
+const (
+	tokEOF    tokenKind = iota
+	tokWord             // keyword or bare identifier
+	tokQuoted           // "ident", `ident` or [ident]
+	tokString           // 'literal'
+	tokNumber
+	tokParam // ?, ?1, :name, @name, $name
+	tokPunct
+)
+
+type token struct {
+	kind       tokenKind
+	text       string
+	start, end int
+}
+
+func (t token) isWord(kw string) bool {
+	return t.kind == tokWord && strings.EqualFold(t.text, kw)
+}
+
+func (t token) isPunct(p string) bool {
+	return t.kind == tokPunct && t.text == p
+}
+
+// isIdent reports whether t can name a table, column or alias.
+func (t token) isIdent() bool {
+	return t.kind == tokQuoted || t.kind == tokWord && !sqlKeywords[strings.ToLower(t.text)]
+}
+
+// name is the identifier t spells, with any quoting removed.
+func (t token) name() string {
+	if t.kind != tokQuoted && t.kind != tokString {
+		return t.text
+	}
+	inner := t.text[1 : len(t.text)-1]
+	switch q := t.text[0]; q {
+	case '"', '`', '\'':
+		inner = strings.ReplaceAll(inner, string([]byte{q, q}), string(q))
+	}
+	return inner
+}
+
+// sqlKeywords are the reserved words that never name a column, table or
+// alias when unquoted.
+var sqlKeywords = map[string]bool{
+	"all": true, "and": true, "as": true, "asc": true, "between": true, "by": true, "case": true,
+	"cast": true, "collate": true, "cross": true, "current_date": true, "current_time": true,
+	"current_timestamp": true, "desc": true, "distinct": true, "else": true, "end": true,
+	"escape": true, "except": true, "exists": true, "false": true, "filter": true, "from": true,
+	"full": true, "glob": true, "group": true, "having": true, "in": true, "indexed": true,
+	"inner": true, "intersect": true, "is": true, "isnull": true, "join": true, "left": true,
+	"like": true, "limit": true, "match": true, "natural": true, "not": true, "notnull": true,
+	"null": true, "nulls": true, "offset": true, "on": true, "or": true, "order": true,
+	"outer": true, "over": true, "partition": true, "regexp": true, "right": true, "select": true,
+	"then": true, "true": true, "union": true, "using": true, "when": true, "where": true,
+	"window": true, "with": true,
+}
+
+// operandEnd are the keywords that can end an operand, so an identifier
+// right after them is a bare alias.
+var operandEnd = map[string]bool{
+	"end": true, "null": true, "true": true, "false": true,
+	"current_date": true, "current_time": true, "current_timestamp": true,
+}
+
+// callKeywords are the keywords that are also function names, so a
+// parenthesis right after them opens an argument list.
+var callKeywords = map[string]bool{"cast": true, "glob": true, "like": true, "match": true, "regexp": true}
+
+func unsupported(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", aqeerr.ErrUnsupportedQuery, fmt.Sprintf(format, args...))
+}
+
+// tokenize splits s into tokens, dropping whitespace and comments.
+func tokenize(s string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		j := i + 1
+		kind := tokPunct
+		switch {
+		case isSpace(c) || c == '\f' || c == '\v':
+			i++
+			continue
+		case c == '-' && strings.HasPrefix(s[i:], "--"):
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+			continue
+		case c == '/' && strings.HasPrefix(s[i:], "/*"):
+			end := strings.Index(s[i+2:], "*/")
+			if end < 0 {
+				return nil, unsupported("unterminated comment at offset %d", i)
+			}
+			i += end + 4
+			continue
+		case c == '\'' || c == '"' || c == '`':
+			end, ok := closeQuote(s, i)
+			if !ok {
+				return nil, unsupported("unterminated quote at offset %d", i)
+			}
+			j, kind = end, tokQuoted
+			if c == '\'' {
+				kind = tokString
+			}
+		case c == '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return nil, unsupported("unterminated identifier at offset %d", i)
+			}
+			j, kind = i+end+1, tokQuoted
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9':
+			hex := strings.HasPrefix(strings.ToLower(s[i:]), "0x")
+			for j < len(s) && (isIdentByte(s[j]) || s[j] == '.' ||
+				!hex && (s[j] == '+' || s[j] == '-') && (s[j-1] == 'e' || s[j-1] == 'E')) {
+				j++
+			}
+			kind = tokNumber
+		case isIdentByte(c) || c >= 0x80:
+			for j < len(s) && (isIdentByte(s[j]) || s[j] == '$' || s[j] >= 0x80) {
+				j++
+			}
+			kind = tokWord
+		case c == '?' || c == ':' || c == '@' || c == '$':
+			for j < len(s) && isIdentByte(s[j]) {
+				j++
+			}
+			kind = tokParam
+		default:
+			for _, op := range []string{"->>", "<=", ">=", "<>", "!=", "==", "||", "<<", ">>", "->"} {
+				if strings.HasPrefix(s[i:], op) {
+					j = i + len(op)
+					break
+				}
+			}
+		}
+		toks = append(toks, token{kind: kind, text: s[i:j], start: i, end: j})
+		i = j
+	}
+	return toks, nil
+}
+
+// closeQuote returns the offset just past the quote opened at s[open],
+// treating a doubled quote character as an escape.
+func closeQuote(s string, open int) (int, bool) {
+	q := s[open]
+	for j := open + 1; j < len(s); j++ {
+		if s[j] != q {
+			continue
+		}
+		if j+1 < len(s) && s[j+1] == q {
+			j++
+			continue
+		}
+		return j + 1, true
+	}
+	return 0, false
+}
+
+// isSelect reports whether sqlText is a SELECT statement, possibly behind
+// comments or a WITH clause.
+func isSelect(sqlText string) bool {
+	toks, err := tokenize(sqlText)
+	return err == nil && len(toks) > 0 && (toks[0].isWord("select") || toks[0].isWord("with"))
+}
+
+// singleStatement returns an aqeerr.ErrUnsupportedQuery error unless
+// sqlText tokenizes and holds at most one statement, a trailing semicolon
+// allowed, so nothing it passes ever reaches the driver as a batch.
+func singleStatement(sqlText string) error {
+	toks, err := tokenize(sqlText)
+	if err != nil {
+		return err
+	}
+	for i, t := range toks {
+		if t.isPunct(";") && i < len(toks)-1 {
+			return unsupported("at offset %d: only one statement can be run at a time", t.start)
+		}
+	}
+	return nil
+}
+
+type parser struct {
+	src  string
+	toks []token
+	pos  int
+}
+
+// Parse parses one SELECT statement, with optional WITH clause and set
+// operations, into a Query. Statements outside that grammar, and
+// parenthesized joins and table-valued functions in FROM, return an
+// aqeerr.ErrUnsupportedQuery error.
+func Parse(sqlText string) (*Query, error) {
+	toks, err := tokenize(sqlText)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{src: sqlText, toks: toks}
+	q, err := p.query()
+	if err != nil {
+		return nil, err
+	}
+	p.acceptPunct(";")
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t, "unexpected %q", t.text)
+	}
+	return q, nil
+}
+
+func (p *parser) errorf(t token, format string, args ...any) error {
+	return unsupported("at offset %d: %s", t.start, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) at(i int) token {
+	if i >= 0 && i < len(p.toks) {
+		return p.toks[i]
+	}
+	return token{kind: tokEOF, start: len(p.src), end: len(p.src)}
+}
+
+func (p *parser) peek() token {
+	return p.at(p.pos)
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) acceptWord(kw string) bool {
+	if p.peek().isWord(kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) acceptPunct(s string) bool {
+	if p.peek().isPunct(s) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// acceptWords consumes the keyword sequence kws if it comes next.
+func (p *parser) acceptWords(kws ...string) bool {
+	for i, kw := range kws {
+		if !p.at(p.pos + i).isWord(kw) {
+			return false
+		}
+	}
+	p.pos += len(kws)
+	return true
+}
+
+func (p *parser) ident(what string) (token, error) {
+	t := p.next()
+	if !t.isIdent() {
+		return t, p.errorf(t, "expected %s", what)
+	}
+	return t, nil
+}
+
+// startsQuery reports whether the parenthesis at i opens a nested query.
+func (p *parser) startsQuery(i int) bool {
+	return p.at(i).isPunct("(") && (p.at(i+1).isWord("select") || p.at(i+1).isWord("with"))
+}
+
+// subquery parses a parenthesized query starting at the current "(".
+func (p *parser) subquery() (*Query, error) {
+	p.pos++
+	q, err := p.query()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.next(); !t.isPunct(")") {
+		return nil, p.errorf(t, "expected ) after subquery")
+	}
+	return q, nil
+}
+
+func (p *parser) skipParens() error {
+	depth := 0
+	for {
+		t := p.next()
+		switch {
+		case t.kind == tokEOF:
+			return p.errorf(t, "unbalanced parentheses")
+		case t.isPunct("("):
+			depth++
+		case t.isPunct(")"):
+			if depth--; depth == 0 {
+				return nil
+			}
+		}
+	}
+}
+
+func (p *parser) query() (*Query, error) {
+	q := &Query{}
+	if p.acceptWord("with") {
+		p.acceptWord("recursive")
+		for {
+			name, err := p.ident("CTE name")
+			if err != nil {
+				return nil, err
+			}
+			if p.peek().isPunct("(") {
+				if err := p.skipParens(); err != nil {
+					return nil, err
+				}
+			}
+			if !p.acceptWord("as") {
+				return nil, p.errorf(p.peek(), "expected AS after CTE name")
+			}
+			if !p.acceptWords("not", "materialized") {
+				p.acceptWord("materialized")
+			}
+			if !p.startsQuery(p.pos) {
+				return nil, p.errorf(p.peek(), "expected (SELECT ...) for CTE %s", name.name())
+			}
+			sub, err := p.subquery()
+			if err != nil {
+				return nil, err
+			}
+			q.With = append(q.With, CTE{Name: name.name(), Query: sub})
+			if !p.acceptPunct(",") {
+				break
+			}
+		}
+	}
+
+	for {
+		s, err := p.selectCore()
+		if err != nil {
+			return nil, err
+		}
+		q.Selects = append(q.Selects, s)
+
+		var op string
+		switch {
+		case p.acceptWords("union", "all"):
+			op = "UNION ALL"
+		case p.acceptWord("union"):
+			op = "UNION"
+		case p.acceptWord("intersect"):
+			op = "INTERSECT"
+		case p.acceptWord("except"):
+			op = "EXCEPT"
+		default:
+			return q, nil
+		}
+		q.SetOps = append(q.SetOps, op)
+	}
+}
+
+// clause-ending keywords shared by the stop sets below
+var (
+	itemStop   = []string{",", "as", "from", "where", "group", "having", "window", "order", "limit", "union", "intersect", "except"}
+	onStop     = []string{",", "join", "inner", "left", "right", "full", "cross", "natural", "where", "group", "having", "window", "order", "limit", "union", "intersect", "except"}
+	whereStop  = []string{"group", "having", "window", "order", "limit", "union", "intersect", "except"}
+	groupStop  = []string{",", "having", "window", "order", "limit", "union", "intersect", "except"}
+	windowStop = []string{"order", "limit", "union", "intersect", "except"}
+	orderStop  = []string{",", "limit", "union", "intersect", "except"}
+	limitStop  = []string{",", "offset", "union", "intersect", "except"}
+)
+
+func (p *parser) selectCore() (*Select, error) {
+	if t := p.next(); !t.isWord("select") {
+		return nil, p.errorf(t, "expected SELECT")
+	}
+	s := &Select{}
+	if p.acceptWord("distinct") {
+		s.Distinct = true
+	} else {
+		p.acceptWord("all")
+	}
+
+	for {
+		item, err := p.selectItem(s)
+		if err != nil {
+			return nil, err
+		}
+		s.Items = append(s.Items, item)
+		if !p.acceptPunct(",") {
+			break
+		}
+	}
+
+	if p.acceptWord("from") {
+		if err := p.fromClause(s); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptWord("where") {
+		e, err := p.expr(s, whereStop)
+		if err != nil {
+			return nil, err
+		}
+		s.Where = &e
+	}
+	if p.acceptWords("group", "by") {
+		list, err := p.exprList(s, groupStop)
+		if err != nil {
+			return nil, err
+		}
+		s.GroupBy = list
+	}
+	if p.acceptWord("having") {
+		e, err := p.expr(s, whereStop)
+		if err != nil {
+			return nil, err
+		}
+		s.Having = &e
+	}
+	if p.acceptWord("window") {
+		// named window definitions only matter to the OVER clauses using them
+		p.pos = p.extent(windowStop)
+	}
+	if p.acceptWords("order", "by") {
+		list, err := p.exprList(s, orderStop)
+		if err != nil {
+			return nil, err
+		}
+		s.OrderBy = list
+	}
+	if p.acceptWord("limit") {
+		e, err := p.expr(s, limitStop)
+		if err != nil {
+			return nil, err
+		}
+		s.Limit = &e
+		// "LIMIT a, b" is SQLite's LIMIT b OFFSET a
+		comma := p.peek().isPunct(",")
+		if p.acceptWord("offset") || p.acceptPunct(",") {
+			off, err := p.expr(s, limitStop)
+			if err != nil {
+				return nil, err
+			}
+			if comma {
+				off, e = e, off // s.Limit points at e
+			}
+			s.Offset = &off
+		}
+	}
+	return s, nil
+}
+
+func (p *parser) selectItem(s *Select) (SelectItem, error) {
+	end := p.extent(itemStop)
+	var alias string
+	switch {
+	case p.at(end).isWord("as"):
+		t := p.at(end + 1)
+		if !t.isIdent() && t.kind != tokString {
+			return SelectItem{}, p.errorf(t, "expected alias after AS")
+		}
+		alias = t.name()
+	case end-p.pos >= 2 && p.at(end-1).isIdent() && endsOperand(p.at(end-2)):
+		// bare alias: "SUM(x) total"
+		alias = p.at(end - 1).name()
+		end--
+	}
+	e, err := p.exprUntil(s, end)
+	if err != nil {
+		return SelectItem{}, err
+	}
+	if e.Text == "" {
+		return SelectItem{}, p.errorf(p.peek(), "expected expression")
+	}
+	if p.acceptWord("as") || alias != "" {
+		p.pos++ // the alias
+	}
+	return SelectItem{Expr: e, Alias: alias, end: p.at(p.pos - 1).end}, nil
+}
+
+// endsOperand reports whether t can be the last token of an operand.
+func endsOperand(t token) bool {
+	switch t.kind {
+	case tokQuoted, tokString, tokNumber, tokParam:
+		return true
+	case tokWord:
+		return t.isIdent() || operandEnd[strings.ToLower(t.text)]
+	case tokPunct:
+		return t.text == ")" || t.text == "*"
+	}
+	return false
+}
+
+func (p *parser) fromClause(s *Select) error {
+	join := ""
+	for {
+		ref, err := p.tableRef()
+		if err != nil {
+			return err
+		}
+		ref.Join = join
+		if join != "" && join != "," {
+			switch {
+			case p.acceptWord("on"):
+				e, err := p.expr(s, onStop)
+				if err != nil {
+					return err
+				}
+				ref.On = &e
+			case p.acceptWord("using"):
+				if err := p.skipParens(); err != nil {
+					return err
+				}
+			}
+		}
+		s.From = append(s.From, ref)
+
+		if p.acceptPunct(",") {
+			join = ","
+			continue
+		}
+		var words []string
+		for _, kw := range []string{"natural", "left", "right", "full", "inner", "outer", "cross"} {
+			if p.acceptWord(kw) {
+				words = append(words, strings.ToUpper(kw))
+			}
+		}
+		if !p.acceptWord("join") {
+			if len(words) > 0 {
+				return p.errorf(p.peek(), "expected JOIN")
+			}
+			return nil
+		}
+		join = strings.Join(append(words, "JOIN"), " ")
+	}
+}
+
+func (p *parser) tableRef() (TableRef, error) {
+	var ref TableRef
+	t := p.peek()
+	switch {
+	case p.startsQuery(p.pos):
+		sub, err := p.subquery()
+		if err != nil {
+			return ref, err
+		}
+		ref.Subquery = sub
+	case t.isPunct("("):
+		return ref, p.constructf(t, ConstructParenthesizedJoin, "parenthesized joins are not supported")
+	case t.isIdent():
+		p.pos++
+		ref.Name, ref.nameStart, ref.nameEnd = t.name(), t.start, t.end
+		if p.acceptPunct(".") { // schema-qualified
+			nt, err := p.ident("table name")
+			if err != nil {
+				return ref, err
+			}
+			ref.Name, ref.nameEnd = nt.name(), nt.end
+		}
+		if p.peek().isPunct("(") {
+			return ref, p.constructf(p.peek(), ConstructTableFunction, "table-valued function %s is not supported", ref.Name)
+		}
+	default:
+		return ref, p.errorf(t, "expected table name")
+	}
+
+	if p.acceptWord("as") {
+		a, err := p.ident("alias")
+		if err != nil {
+			return ref, err
+		}
+		ref.Alias = a.name()
+	} else if p.peek().isIdent() {
+		ref.Alias = p.next().name()
+	}
+	if p.acceptWords("indexed", "by") {
+		if _, err := p.ident("index name"); err != nil {
+			return ref, err
+		}
+	} else {
+		p.acceptWords("not", "indexed")
+	}
+	return ref, nil
+}
+
+func (p *parser) exprList(s *Select, stop []string) ([]Expr, error) {
+	var list []Expr
+	for {
+		e, err := p.expr(s, stop)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+		if !p.acceptPunct(",") {
+			return list, nil
+		}
+	}
+}
+
+func (p *parser) expr(s *Select, stop []string) (Expr, error) {
+	e, err := p.exprUntil(s, p.extent(stop))
+	if err == nil && e.Text == "" {
+		err = p.errorf(p.peek(), "expected expression")
+	}
+	return e, err
+}
+
+// extent returns the index of the first token at parenthesis depth zero
+// that is in stop, a ";", an unmatched ")", or the end of input.
+func (p *parser) extent(stop []string) int {
+	depth := 0
+	for i := p.pos; i < len(p.toks); i++ {
+		t := p.toks[i]
+		switch {
+		case t.isPunct("("):
+			depth++
+		case t.isPunct(")"):
+			if depth == 0 {
+				return i
+			}
+			depth--
+		case depth == 0 && t.isPunct(";"):
+			return i
+		case depth == 0:
+			for _, w := range stop {
+				if t.isWord(w) || t.isPunct(w) {
+					return i
+				}
+			}
+		}
+	}
+	return len(p.toks)
+}
+
+// exprUntil consumes the tokens before index end as one expression,
+// recording its column references on the Expr and its function calls and
+// nested queries on s.
+func (p *parser) exprUntil(s *Select, end int) (Expr, error) {
+	e := Expr{start: p.peek().start, end: p.peek().start}
+	if p.pos >= end {
+		return e, nil
+	}
+	// open parentheses: index into s.Calls, or -1 for grouping
+	var open []int
+	for p.pos < end {
+		i := p.pos
+		t := p.toks[i]
+		prev := p.at(i - 1)
+		switch {
+		case p.startsQuery(i):
+			sub, err := p.subquery()
+			if err != nil {
+				return e, err
+			}
+			s.Subqueries = append(s.Subqueries, sub)
+			e.end = p.toks[p.pos-1].end
+			continue
+
+		case t.isPunct("("):
+			call := -1
+			if (prev.isIdent() || prev.kind == tokWord && callKeywords[strings.ToLower(prev.text)]) && !p.at(i-2).isWord("as") {
+				fc := FuncCall{Name: strings.ToUpper(prev.name()), start: prev.start}
+				if p.at(i + 1).isWord("distinct") {
+					fc.Distinct = true
+				}
+				s.Calls = append(s.Calls, fc)
+				call = len(s.Calls) - 1
+			}
+			open = append(open, call)
+
+		case t.isPunct(")"):
+			if len(open) == 0 {
+				return e, p.errorf(t, "unbalanced parentheses")
+			}
+			if call := open[len(open)-1]; call >= 0 {
+				fc := &s.Calls[call]
+				argStart := p.src[fc.start:t.start]
+				argStart = argStart[strings.IndexByte(argStart, '(')+1:]
+				if fc.Distinct {
+					argStart = strings.TrimSpace(argStart)[len("distinct"):]
+				}
+				if args := strings.TrimSpace(argStart); args != "" {
+					fc.Args = SplitTopLevel(args)
+				}
+				fc.end = t.end
+				next := p.at(i + 1)
+				if next.isWord("filter") {
+					fc.Filter = true
+					next = p.at(p.matchingParen(i+2) + 1)
+				}
+				fc.Window = next.isWord("over")
+			}
+			open = open[:len(open)-1]
+
+		case t.isIdent() && !p.at(i+1).isPunct("(") && !prev.isPunct(".") && !prev.isWord("as") && !prev.isWord("collate"):
+			if p.at(i+1).isPunct(".") && !p.at(i+2).isIdent() {
+				break // t.*
+			}
+			col := t.name()
+			for j := i + 1; j+1 < end && p.toks[j].isPunct(".") && p.toks[j+1].isIdent(); j += 2 {
+				col += "." + p.toks[j+1].name()
+			}
+			e.Columns = append(e.Columns, col)
+		}
+		e.end = t.end
+		p.pos++
+	}
+	if len(open) > 0 {
+		return e, p.errorf(p.peek(), "unbalanced parentheses")
+	}
+	e.Text = p.src[e.start:e.end]
+	return e, nil
+}
+
+// matchingParen returns the index of the ")" closing the "(" at i, or i if
+// there is no "(" there.
+func (p *parser) matchingParen(i int) int {
+	if !p.at(i).isPunct("(") {
+		return i
+	}
+	depth := 0
+	for j := i; j < len(p.toks); j++ {
+		switch {
+		case p.toks[j].isPunct("("):
+			depth++
+		case p.toks[j].isPunct(")"):
+			if depth--; depth == 0 {
+				return j
+			}
+		}
+	}
+	return len(p.toks)
+}
@@ -5,9 +5,11 @@ import (
 	"database/sql"
 	"fmt"
 	"math"
-	"regexp"
-	"strconv"
+	"sort"
 	"strings"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/aqeerr"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
 )
 
 // PlanType indicates which path to use
@@ -17,6 +19,9 @@ const (
 	PlanExact  PlanType = "exact"
 	PlanSample PlanType = "sample"
 	PlanSketch PlanType = "sketch"
+	// PlanHybrid runs the outer query exactly after replacing some of its
+	// scalar subqueries with constants answered from sketches.
+	PlanHybrid PlanType = "hybrid"
 )
 
 type Plan struct {
@@ -26,20 +31,158 @@ type Plan struct {
 	Table          string   `json:"table,omitempty"`
 	SampleTable    string   `json:"sample_table,omitempty"`
 	SampleFraction float64  `json:"sample_fraction,omitempty"`
-	SketchType     string   `json:"sketch_type,omitempty"`
-	SketchColumn   string   `json:"sketch_column,omitempty"`
-	EstimatedCost  float64  `json:"estimated_cost"`
-	EstimatedError float64  `json:"estimated_error"`
-	Reason         string   `json:"reason"`
+	StrataColumn   string   `json:"strata_column,omitempty"` // set when SampleTable is stratified
+	// SamplePredicate is set when SampleTable is a filtered sample, holding
+	// SampleFraction of the rows matching it.
+	SamplePredicate string `json:"sample_predicate,omitempty"`
+	// OutlierTable is set when SampleTable is an outlier sample; OutlierSQL
+	// is the query run exactly on it, whose answer the executor adds to
+	// the scaled sample's.
+	OutlierTable string `json:"outlier_table,omitempty"`
+	OutlierSQL   string `json:"outlier_sql,omitempty"`
+	// StrataFractions is set when a query grouped by StrataColumn reads
+	// every stratum of SampleTable: each result row is scaled by the
+	// fraction of its stratum, keyed by the value in column StrataOutput,
+	// and SampleFraction is the share of the table the sample holds.
+	StrataFractions map[string]float64 `json:"strata_fractions,omitempty"`
+	StrataOutput    string             `json:"strata_output,omitempty"`
+	// JoinTable is set when a join reads universe samples of both its
+	// tables: the joined table, read through JoinSampleTable, its universe
+	// sample on the join key at SampleFraction.
+	JoinTable       string `json:"join_table,omitempty"`
+	JoinSampleTable string `json:"join_sample_table,omitempty"`
+	// JoinSynopsis is set when a join reads a join synopsis of its tables
+	// instead (see storage.JoinSynopsis): SampleTable is the synopsis table
+	// of Table and JoinTable's rows are read from the other.
+	JoinSynopsis string `json:"join_synopsis,omitempty"`
+	// WanderJoin is set on sample plans that estimate a join from random
+	// walks over Table and JoinTable instead of reading a sample.
+	WanderJoin *WanderJoin `json:"wander_join,omitempty"`
+	// BloomJoin is set on exact plans that read the larger side of their
+	// join through the Bloom filter on the smaller side's key.
+	BloomJoin *BloomJoin `json:"bloom_join,omitempty"`
+	// TrackedCount is set on exact plans that answer COUNT(*) from Table's
+	// tracked row count (see storage.TrackRowCount) instead of scanning it.
+	TrackedCount bool   `json:"tracked_count,omitempty"`
+	SketchType   string `json:"sketch_type,omitempty"`
+	SketchColumn string `json:"sketch_column,omitempty"`
+	// SketchJoin is set on sketch plans that join SampleTable to a table
+	// read only through its Count-Min sketch, SketchColumn of SketchJoin.Table.
+	SketchJoin     *SketchJoin `json:"sketch_join,omitempty"`
+	EstimatedCost  float64     `json:"estimated_cost"`
+	EstimatedError float64     `json:"estimated_error"`
+	Reason         string      `json:"reason"`
+	// Fallback is the aqeerr category that forced an exact plan, if any.
+	Fallback string `json:"fallback,omitempty"`
+	// Unsupported names the constructs (see ConstructCTE and the others)
+	// that kept an exact plan from being approximated.
+	Unsupported []string    `json:"unsupported,omitempty"`
+	Complexity  *Complexity `json:"complexity,omitempty"`
+	Rewrites    []string    `json:"rewrites,omitempty"`
+	// TableRows and BaselineCost (the exact plan's cost) give scorers a
+	// common reference; Confidence and ScoredBy are set by a Scorer.
+	TableRows    int64   `json:"table_rows,omitempty"`
+	BaselineCost float64 `json:"baseline_cost,omitempty"`
+	Confidence   float64 `json:"confidence,omitempty"`
+	ScoredBy     string  `json:"scored_by,omitempty"`
+	// Selectivity is the estimated share of TableRows the WHERE clause
+	// keeps, when it keeps fewer than all of them.
+	Selectivity float64 `json:"selectivity,omitempty"`
+	// MaxRelError is the tolerance the plan was chosen under. A Scorer that
+	// adjusts a candidate records the runs it used and the cost model's
+	// estimates from before the adjustment.
+	MaxRelError float64 `json:"max_rel_error,omitempty"`
+	HistoryRuns int     `json:"history_runs,omitempty"`
+	ModelCost   float64 `json:"model_cost,omitempty"`
+	ModelError  float64 `json:"model_error,omitempty"`
+	// EstimatedLatencyMs converts EstimatedCost to wall time; LatencySource
+	// is "cost_model" or "history" when a Scorer calibrated it.
+	EstimatedLatencyMs float64        `json:"estimated_latency_ms,omitempty"`
+	LatencySource      string         `json:"latency_source,omitempty"`
+	Alternatives       []PlanEstimate `json:"alternatives,omitempty"`
+	// TimeBuckets lists GROUP BY keys that bucket a timestamp column.
+	TimeBuckets []TimeBucket `json:"time_buckets,omitempty"`
+	// Inlined lists the scalar subqueries a hybrid plan answered from sketches.
+	Inlined []InlinedSubquery `json:"inlined_subqueries,omitempty"`
+	// Passthrough marks a statement the planner could not handle, run
+	// verbatim: not optimized, not approximated.
+	Passthrough bool `json:"passthrough,omitempty"`
+	// Catalog marks a query over information_schema (see
+	// expandInformationSchema).
+	Catalog bool `json:"catalog,omitempty"`
+	// Hints lists the optimizer hints the SQL carried.
+	Hints []string `json:"hints,omitempty"`
+	// StrategiesOff lists the strategies switched off for this query, and
+	// why (see SetDisabledStrategies).
+	StrategiesOff map[string]string `json:"strategies_off,omitempty"`
+	// ConfidenceLevel is the level MaxRelError and the result's intervals
+	// are at, when not DefaultConfidenceLevel.
+	ConfidenceLevel float64 `json:"confidence_level,omitempty"`
+	// Aggregates describes the aggregate result columns of sample plans.
+	Aggregates []OutputAggregate `json:"aggregates,omitempty"`
+	// Escalated is set on plans Escalate made after a realized error missed
+	// the tolerance.
+	Escalated bool `json:"escalated,omitempty"`
+	// EstimatedGroups is how many groups a GROUP BY is estimated to return;
+	// GroupLimit is set when that exceeded SetMaxGroups and the plan
+	// returns only the first GroupLimit of them.
+	EstimatedGroups float64 `json:"estimated_groups,omitempty"`
+	GroupLimit      int64   `json:"group_limit,omitempty"`
+	// Order is the ORDER BY of a grouped sample plan as result columns,
+	// which the executor sorts the scaled groups by again; ResultLimit is
+	// a LIMIT it applies after, moved out of SQL because scaling can
+	// reorder the groups.
+	Order       []OrderKey `json:"order,omitempty"`
+	ResultLimit int64      `json:"result_limit,omitempty"`
+	// RankConfidence, when set on a plan with a ResultLimit, is how likely
+	// the rows returned should be to hold the true top ResultLimit groups:
+	// the executor returns more of the next-ranked groups until they are
+	// (see SetRankConfidence).
+	RankConfidence float64 `json:"rank_confidence,omitempty"`
+
+	// baseSQL is the query the planner chose among strategies for, after
+	// its rewrites; Escalate plans from it. It is unset for plans the
+	// caller pinned, which are not escalated.
+	baseSQL string
+}
+
+// Level is the confidence level the plan's error bounds are at.
+func (p *Plan) Level() float64 {
+	if p.ConfidenceLevel > 0 {
+		return p.ConfidenceLevel
+	}
+	return DefaultConfidenceLevel
+}
+
+// PlanEstimate summarizes a candidate plan that was not chosen.
+type PlanEstimate struct {
+	Type               PlanType `json:"type"`
+	SampleTable        string   `json:"sample_table,omitempty"`
+	SketchType         string   `json:"sketch_type,omitempty"`
+	EstimatedCost      float64  `json:"estimated_cost"`
+	EstimatedError     float64  `json:"estimated_error"`
+	EstimatedLatencyMs float64  `json:"estimated_latency_ms"`
+	LatencySource      string   `json:"latency_source,omitempty"`
+}
+
+// Scorer re-ranks the planner's candidate plans, typically from learned
+// execution history. It may adjust EstimatedCost, EstimatedError and
+// Confidence in place but must not change a candidate's SQL; the planner
+// still makes the final choice.
+type Scorer interface {
+	ScorePlans(ctx context.Context, sqlText string, maxRelError float64, candidates []*Plan)
 }
 
 type QueryFeatures struct {
-	HasDistinct    bool
-	HasGroupBy     bool
-	AggregateTypes []string
-	GroupByColumns []string
-	WhereColumns   []string
-	IsHeavyHitter  bool
+	HasDistinct     bool
+	HasGroupBy      bool
+	AggregateTypes  []string
+	GroupByColumns  []string
+	DistinctColumns []string // arguments of COUNT(DISTINCT ...)
+	TimeBuckets     []TimeBucket
+	WhereColumns    []string
+	IsHeavyHitter   bool
+	HasQuantile     bool // MEDIAN or PERCENTILE
 }
 
 type CostModel struct {
@@ -47,187 +190,864 @@ type CostModel struct {
 	HashCostPerGroup float64
 	SketchQueryCost  float64
 	SampleSetupCost  float64
+	// MsPerCostUnit and OverheadMs turn cost units into milliseconds.
+	MsPerCostUnit float64
+	OverheadMs    float64
+}
+
+// LatencyMs is the deterministic latency estimate for a plan of the given cost.
+func (m CostModel) LatencyMs(cost float64) float64 {
+	return m.OverheadMs + cost*m.MsPerCostUnit
 }
 
 type Planner struct {
-	costModel CostModel
+	costModel           CostModel
+	complexityThreshold float64
+	scorer              Scorer
+	safeMode            bool
+	passthrough         bool
+	sampleResolver      SampleResolver
+	confidenceLevel     float64
+	statsProvider       StatsProvider
+	maxGroups           int64
+	rankConfidence      float64
+	disabled            map[string]bool
+	limits              QueryLimits
+
+	// off holds the strategies the statement being planned may not use,
+	// and why (see strategiesOff).
+	off map[string]string
+}
+
+// SampleResolver is called before the planner reads a sample table. It can
+// record the use, and bring back a sample moved out of the database so the
+// plan can still read it; an error leaves the sample as it is.
+type SampleResolver func(ctx context.Context, sampleTable string) error
+
+// SetSampleResolver installs a SampleResolver.
+func (p *Planner) SetSampleResolver(r SampleResolver) {
+	p.sampleResolver = r
+}
+
+// sampleReady reports whether sampleTable can be read, after giving the
+// resolver a chance to restore it.
+func (p *Planner) sampleReady(ctx context.Context, db *sql.DB, sampleTable string) bool {
+	if p.sampleResolver != nil {
+		_ = p.sampleResolver(ctx, sampleTable)
+	}
+	exists, err := storage.TableExists(ctx, db, sampleTable)
+	return err == nil && exists
+}
+
+// SetConfidenceLevel sets the confidence level maxRelError is at, in
+// (0, 1); DefaultConfidenceLevel otherwise.
+func (p *Planner) SetConfidenceLevel(c float64) {
+	if c > 0 && c < 1 {
+		p.confidenceLevel = c
+	}
+}
+
+// SetRankConfidence asks top-N answers over samples (ORDER BY an
+// estimated aggregate with a LIMIT) to return, beyond their N rows, the
+// next-ranked groups until the rows hold the true top N with probability
+// c, in (0, 1). 0, the default, returns N rows.
+func (p *Planner) SetRankConfidence(c float64) {
+	if c >= 0 && c < 1 {
+		p.rankConfidence = c
+	}
+}
+
+// SetComplexityThreshold overrides DefaultComplexityThreshold; queries
+// scoring above it are always planned exact.
+func (p *Planner) SetComplexityThreshold(t float64) {
+	p.complexityThreshold = t
+}
+
+// SetSafeMode makes every plan exact over the unmodified SQL, with no
+// sampling, sketches or decorrelation rewrites.
+func (p *Planner) SetSafeMode(on bool) {
+	p.safeMode = on
+}
+
+// SetPassthrough makes SELECTs outside the parser's grammar plan as
+// passthrough: exact over the SQL exactly as given, rather than an error.
+// Anything but a single SELECT is refused all the same.
+func (p *Planner) SetPassthrough(on bool) {
+	p.passthrough = on
+}
+
+// SetScorer installs a Scorer consulted before the best plan is chosen.
+func (p *Planner) SetScorer(s Scorer) {
+	p.scorer = s
 }
 
 func New() *Planner {
 	return &Planner{
+		complexityThreshold: DefaultComplexityThreshold,
+		confidenceLevel:     DefaultConfidenceLevel,
+		statsProvider:       CatalogStats{},
+		limits:              DefaultQueryLimits,
 		costModel: CostModel{
 			ScanCostPerRow:   1.0,
 			HashCostPerGroup: 2.0,
 			SketchQueryCost:  10.0,
 			SampleSetupCost:  5.0,
+			MsPerCostUnit:    0.0005, // ~2M rows/s full scan
+			OverheadMs:       1.0,
 		},
 	}
 }
 
-var (
-	fromRe     = regexp.MustCompile(`(?i)from\s+([a-zA-Z0-9_]+)`)
-	distinctRe = regexp.MustCompile(`(?i)select\s+distinct|count\s*\(\s*distinct`)
-	aggRe      = regexp.MustCompile(`(?i)(count|sum|avg|min|max)\s*\(`)
-	groupByRe  = regexp.MustCompile(`(?i)group\s+by\s+([^having^order^limit]+)`)
-	whereRe    = regexp.MustCompile(`(?i)where\s+([^group^order^limit]+)`)
-)
-
+// Plan chooses how to run sqlText. A trailing accuracy clause (see
+// StripAccuracyClause) is removed from it and, like hints in the SQL (see
+// ParseHints), overrides maxRelError, preferExact and the confidence level.
+// SQL over the planner's QueryLimits is rejected with a *LimitError.
 func (p *Planner) Plan(ctx context.Context, db *sql.DB, sqlText string, maxRelError float64, preferExact bool) (*Plan, error) {
-	features := p.parseQueryFeatures(sqlText)
+	if err := p.limits.Check(sqlText); err != nil {
+		return nil, err
+	}
+	if err := singleStatement(sqlText); err != nil {
+		return nil, err
+	}
+	if !isSelect(sqlText) {
+		return nil, fmt.Errorf("%w: only SELECT statements can be planned", aqeerr.ErrUnsupportedQuery)
+	}
+	sqlText, accuracy, err := StripAccuracyClause(sqlText)
+	if err != nil {
+		return nil, err
+	}
+	maxRelError, confidence := accuracy.Override(maxRelError, p.confidenceLevel)
+	hints, err := ParseHints(sqlText)
+	if err != nil {
+		return nil, err
+	}
+	maxRelError, preferExact = hints.Override(maxRelError, preferExact)
+	if expanded, ok := expandInformationSchema(sqlText); ok {
+		plan := catalogPlan(sqlText, expanded)
+		plan.Hints = hints.Applied
+		return plan, nil
+	}
+	p.off = p.strategiesOff(ctx, db, sqlText)
+	plan, err := p.plan(ctx, db, sqlText, maxRelError, preferExact, hints, confidence)
+	if plan != nil {
+		plan.Hints = hints.Applied
+		if len(p.off) > 0 && !p.safeMode {
+			plan.StrategiesOff = p.off
+		}
+		if confidence != DefaultConfidenceLevel {
+			plan.ConfidenceLevel = confidence
+		}
+		if plan.ResultLimit > 0 {
+			plan.RankConfidence = p.rankConfidence
+		}
+	}
+	return plan, err
+}
 
-	table := p.extractTableName(sqlText)
-	if table == "" {
-		return &Plan{Type: PlanExact, SQL: sqlText, OriginalSQL: sqlText, Reason: "no table found"}, nil
+// plan is Plan after the SQL's own settings are applied. maxRelError is at
+// confidence; candidates are weighed against it converted to the level
+// their error estimates are at.
+func (p *Planner) plan(ctx context.Context, db *sql.DB, sqlText string, maxRelError float64, preferExact bool, hints Hints, confidence float64) (*Plan, error) {
+	if maxRelError < 0 || math.IsNaN(maxRelError) {
+		return nil, fmt.Errorf("%w: max_rel_error must be non-negative, got %v", aqeerr.ErrToleranceUnreachable, maxRelError)
 	}
 
-	if originalTable, fraction, isSample := p.parseSampleTableName(table); isSample {
+	if p.safeMode {
 		return &Plan{
-			Type:           PlanSample,
-			SQL:            sqlText,
-			OriginalSQL:    sqlText,
-			Table:          originalTable,
-			SampleTable:    table,
-			SampleFraction: fraction,
-			Reason:         fmt.Sprintf("direct query on sample table (fraction: %.4f)", fraction),
+			Type:        PlanExact,
+			SQL:         sqlText,
+			OriginalSQL: sqlText,
+			Table:       p.extractTableName(sqlText),
+			Reason:      "safe mode: exact execution only",
+			Fallback:    "safe_mode",
 		}, nil
 	}
 
-	if preferExact {
-		return &Plan{Type: PlanExact, SQL: sqlText, OriginalSQL: sqlText, Table: table, Reason: "user prefers exact"}, nil
+	complexity := AnalyzeComplexity(sqlText)
+	if complexity.TooComplex(p.complexityThreshold) {
+		return &Plan{
+			Type:        PlanExact,
+			SQL:         sqlText,
+			OriginalSQL: sqlText,
+			Table:       p.extractTableName(sqlText),
+			Reason:      fmt.Sprintf("too complex to approximate (score %.0f: %s)", complexity.Score, complexity.summary()),
+			Fallback:    "too_complex",
+			Unsupported: complexityConstructs(complexity),
+			Complexity:  &complexity,
+		}, nil
+	}
+
+	// window results are per-row over a frame; scaling or sampling them is wrong
+	if complexity.WindowFunctions > 0 {
+		return &Plan{
+			Type:        PlanExact,
+			SQL:         sqlText,
+			OriginalSQL: sqlText,
+			Table:       p.extractTableName(sqlText),
+			Reason:      "window functions are executed exactly",
+			Fallback:    "window_function",
+			Unsupported: []string{ConstructWindowFunction},
+			Complexity:  &complexity,
+		}, nil
+	}
+
+	// correlated subqueries are re-evaluated per outer row, so sampling either
+	// side changes the answer; decorrelate what we can, otherwise run exact
+	originalSQL := sqlText
+	var rewrites []string
+	if hasCorrelated(DetectSubqueries(sqlText)) {
+		rewritten, notes, remaining := Decorrelate(sqlText)
+		if remaining {
+			return &Plan{
+				Type:        PlanExact,
+				SQL:         sqlText,
+				OriginalSQL: sqlText,
+				Table:       p.extractTableName(sqlText),
+				Reason:      "correlated subquery; sampling disabled",
+				Fallback:    "correlated_subquery",
+				Unsupported: []string{ConstructCorrelatedSubquery},
+				Complexity:  &complexity,
+			}, nil
+		}
+		sqlText, rewrites = rewritten, notes
 	}
 
-	tableStats, err := p.getTableStats(ctx, db, table)
+	query, err := Parse(sqlText)
+	if err != nil && p.passthrough {
+		// whatever decorrelation did, run what the user wrote
+		plan := passthroughPlan(originalSQL, fmt.Sprintf("could not parse query (%v)", err))
+		plan.Unsupported = []string{parseConstruct(err)}
+		return plan, nil
+	}
 	if err != nil {
-		return &Plan{Type: PlanExact, SQL: sqlText, OriginalSQL: sqlText, Table: table, Reason: "no table stats available"}, nil
+		// neither what the user wrote nor a half-finished rewrite of it is
+		// run unparsed
+		return nil, fmt.Errorf("could not parse query: %w", err)
 	}
+	features := p.parseQueryFeatures(query)
 
-	strategies := p.evaluateStrategies(ctx, db, sqlText, table, features, tableStats, maxRelError)
+	table := query.BaseTable()
+	if table == "" {
+		return &Plan{Type: PlanExact, SQL: sqlText, OriginalSQL: sqlText, Reason: "no table found"}, nil
+	}
+	p.applyRoles(ctx, db, table, &features)
 
-	bestStrategy := p.chooseBestStrategy(strategies, maxRelError)
+	if plan := samplePlan(ctx, db, sqlText, table, query); plan != nil {
+		return plan, nil
+	}
+	if p.allows(StrategyTrackedCount) {
+		if plan := trackedCountPlan(ctx, db, query, sqlText); plan != nil {
+			return plan, nil
+		}
+	}
 
-	return bestStrategy, nil
-}
+	if preferExact {
+		plan := &Plan{Type: PlanExact, SQL: sqlText, OriginalSQL: sqlText, Table: table, Reason: "user prefers exact"}
+		if j := p.evaluateBloomJoin(ctx, db, query, sqlText); j != nil {
+			plan.BloomJoin = j
+			plan.Reason += ", " + j.describe()
+		}
+		if stats, err := p.getTableStats(ctx, db, table, query); err == nil {
+			return p.guardGroups(ctx, db, query, sqlText, table, features, stats, plan, true)
+		}
+		return plan, nil
+	}
 
-func (p *Planner) parseQueryFeatures(sql string) QueryFeatures {
-	features := QueryFeatures{}
+	// scalar subqueries a sketch can answer are inlined as constants and the
+	// outer query runs exact, unless a hint asks for a sample
+	tolerance := ToleranceAt(maxRelError, confidence)
+	if hints.SampleTable == "" && p.allows(StrategyInlineSketch) {
+		if rewritten, inlined := inlineSketchSubqueries(ctx, db, sqlText, tolerance); len(inlined) > 0 {
+			plan := hybridPlan(originalSQL, rewritten, table, inlined)
+			plan.Rewrites = append(rewrites, plan.Rewrites...)
+			plan.Complexity = &complexity
+			plan.MaxRelError = maxRelError
+			return plan, nil
+		}
+	}
+
+	tableStats, err := p.getTableStats(ctx, db, table, query)
+	if err != nil {
+		return &Plan{Type: PlanExact, SQL: sqlText, OriginalSQL: sqlText, Table: table, Reason: "no table stats available",
+			Fallback: aqeerr.Category(err)}, nil
+	}
+
+	if hints.SampleTable != "" {
+		if err := p.pinSample(ctx, db, table, query, tableStats, hints); err != nil {
+			return nil, err
+		}
+	} else {
+		p.chooseSample(ctx, db, table, query, tableStats, tolerance)
+	}
 
-	features.HasDistinct = distinctRe.MatchString(sql)
+	strategies := p.evaluateStrategies(ctx, db, query, sqlText, table, features, tableStats, tolerance)
+	for _, s := range strategies {
+		s.TimeBuckets = features.TimeBuckets
+		s.TableRows = tableStats.RowCount
+		if tableStats.Selectivity < 1 {
+			s.Selectivity = tableStats.Selectivity
+		}
+		s.BaselineCost = strategies[0].EstimatedCost
+		s.EstimatedLatencyMs = p.costModel.LatencyMs(s.EstimatedCost)
+		s.LatencySource = "cost_model"
+	}
+	if p.scorer != nil {
+		p.scorer.ScorePlans(ctx, sqlText, tolerance, strategies)
+	}
 
-	aggMatches := aggRe.FindAllStringSubmatch(sql, -1)
-	for _, match := range aggMatches {
-		if len(match) > 1 {
-			features.AggregateTypes = append(features.AggregateTypes, strings.ToUpper(match[1]))
+	bestStrategy := p.chooseBestStrategy(strategies, tolerance)
+	if hints.SampleTable != "" {
+		if bestStrategy = hintedSample(strategies, tableStats.BestSampleTable); bestStrategy == nil {
+			if why, off := p.off[StrategySample]; off {
+				return nil, fmt.Errorf("%w: hint SAMPLE(%s, %g): sampling is %s",
+					aqeerr.ErrNoSample, hints.SampleTable, hints.SampleFraction, why)
+			}
+			return nil, fmt.Errorf("%w: hint SAMPLE(%s, %g): the sample cannot answer this query",
+				aqeerr.ErrNoSample, hints.SampleTable, hints.SampleFraction)
+		}
+		bestStrategy.Reason += " (SAMPLE hint)"
+	}
+	if bestStrategy.Type == PlanExact {
+		bestStrategy.Fallback = aqeerr.Category(exactFallbackReason(strategies, tolerance))
+		// no approximate candidate at all: a CTE, derived table or set
+		// operation may be what kept the sample out
+		if bestStrategy.Fallback == "no_sample" && p.allows(StrategySample) {
+			if blocker := query.sampleBlocker(table); blocker != "" {
+				bestStrategy.Unsupported = []string{blocker}
+			}
+		}
+		if tableStats.sampleNote != "" {
+			bestStrategy.Reason += "; " + tableStats.sampleNote
 		}
 	}
+	bestStrategy.Complexity = &complexity
+	for _, s := range strategies {
+		if s == bestStrategy {
+			continue
+		}
+		bestStrategy.Alternatives = append(bestStrategy.Alternatives, PlanEstimate{
+			Type:               s.Type,
+			SampleTable:        s.SampleTable,
+			SketchType:         s.SketchType,
+			EstimatedCost:      s.EstimatedCost,
+			EstimatedError:     s.EstimatedError,
+			EstimatedLatencyMs: s.EstimatedLatencyMs,
+			LatencySource:      s.LatencySource,
+		})
+	}
+	applyResultOrder(query, bestStrategy)
+	bestStrategy.OriginalSQL = originalSQL
+	bestStrategy.Rewrites = rewrites
+	bestStrategy.MaxRelError = maxRelError
+	if hints.SampleTable == "" {
+		bestStrategy.baseSQL = sqlText
+	}
+
+	return p.guardGroups(ctx, db, query, sqlText, table, features, tableStats, bestStrategy, false)
+}
+
+// parseQueryFeatures reads the features of q's main SELECT; subqueries and
+// CTEs it reads from don't count.
+func (p *Planner) parseQueryFeatures(q *Query) QueryFeatures {
+	features := QueryFeatures{}
+	s := q.Main()
 
-	groupByMatch := groupByRe.FindStringSubmatch(sql)
-	if len(groupByMatch) > 1 {
-		features.HasGroupBy = true
-		groupByCols := strings.Split(strings.TrimSpace(groupByMatch[1]), ",")
-		for _, col := range groupByCols {
-			col = strings.TrimSpace(col)
-			if col != "" {
-				features.GroupByColumns = append(features.GroupByColumns, col)
+	features.HasDistinct = s.Distinct
+	for _, call := range s.Aggregates() {
+		features.AggregateTypes = append(features.AggregateTypes, call.Name)
+		if call.Name == "MEDIAN" || call.Name == "PERCENTILE" {
+			features.HasQuantile = true
+		}
+		if call.Distinct {
+			features.HasDistinct = true
+			if call.Name == "COUNT" && len(call.Args) == 1 {
+				features.DistinctColumns = append(features.DistinctColumns, unqualified(call.Args[0]))
 			}
 		}
 	}
 
+	for _, g := range s.GroupBy {
+		features.GroupByColumns = append(features.GroupByColumns, g.Text)
+	}
+	features.HasGroupBy = len(features.GroupByColumns) > 0
+	if s.Where != nil {
+		features.WhereColumns = s.Where.Columns
+	}
+
+	features.TimeBuckets = DetectTimeBuckets(features.GroupByColumns)
 	features.IsHeavyHitter = features.HasGroupBy && len(features.GroupByColumns) <= 2
 
 	return features
 }
 
-func (p *Planner) extractTableName(sql string) string {
-	match := fromRe.FindStringSubmatch(sql)
-	if len(match) >= 2 {
-		return match[1]
+// applyRoles narrows features to what table's declared column roles allow:
+// only declared time columns are bucketed.
+func (p *Planner) applyRoles(ctx context.Context, db *sql.DB, table string, features *QueryFeatures) {
+	roles, err := storage.GetTableRoles(ctx, db, table)
+	if err != nil {
+		return
 	}
-	return ""
+	features.TimeBuckets = declaredTimeBuckets(features.TimeBuckets, roles)
 }
 
-func (p *Planner) parseSampleTableName(tableName string) (string, float64, bool) {
-	if strings.Contains(tableName, "__sample_") {
-		if idx := strings.Index(tableName, "__sample_"); idx >= 0 {
-			originalTable := tableName[:idx]
-			fractionPart := tableName[idx+10:]
+// extractTableName is the base table of sql, or "" if it can't be parsed.
+func (p *Planner) extractTableName(sql string) string {
+	q, err := Parse(sql)
+	if err != nil {
+		return ""
+	}
+	return q.BaseTable()
+}
 
-			fractionStr := strings.Replace(fractionPart, "_", ".", -1)
-			if fraction, err := strconv.ParseFloat(fractionStr, 64); err == nil {
-				return originalTable, fraction, true
-			}
-		}
+// unqualified strips any table qualifier and quoting from a column reference.
+func unqualified(col string) string {
+	if dot := strings.LastIndexByte(col, '.'); dot >= 0 {
+		col = col[dot+1:]
 	}
+	return strings.Trim(col, "\"`[]")
+}
 
-	if strings.Contains(tableName, "__strat_sample_") {
-		if idx := strings.Index(tableName, "__strat_sample_"); idx >= 0 {
-			originalTable := tableName[:idx]
-			remaining := tableName[idx+15:]
+// looksLikeSample reports whether name is a sample table by its name: an
+// opaque aqe_sample_ name, or one of the <table>__sample_<fraction> and
+// <table>__strat_sample_<column>_<fraction> names used before them.
+func looksLikeSample(name string) bool {
+	return strings.HasPrefix(strings.ToLower(name), storage.SampleTablePrefix) ||
+		strings.Contains(name, "__sample_") || strings.Contains(name, "__strat_sample_")
+}
 
-			lastUnderscore := strings.LastIndex(remaining, "_")
-			if lastUnderscore >= 0 {
-				fractionPart := remaining[lastUnderscore+1:]
-				fractionStr := strings.Replace(fractionPart, "_", ".", -1)
-				if fraction, err := strconv.ParseFloat(fractionStr, 64); err == nil {
-					return originalTable, fraction, true
-				}
-			}
+// passthroughPlan runs sqlText untouched; why says what the planner could
+// not handle.
+func passthroughPlan(sqlText, why string) *Plan {
+	return &Plan{
+		Type:        PlanExact,
+		SQL:         sqlText,
+		OriginalSQL: sqlText,
+		Reason:      why + "; passed through verbatim, not optimized, not approximated",
+		Fallback:    "passthrough",
+		Passthrough: true,
+	}
+}
+
+// samplePlan plans a query that reads table directly when table is a
+// recorded sample, scaling by the fraction aqe_samples records; names are
+// never parsed for it. A table that only looks like a sample (e.g. created
+// by hand) runs exactly and unscaled. It returns nil for other tables.
+func samplePlan(ctx context.Context, db *sql.DB, sqlText, table string, q *Query) *Plan {
+	info, err := storage.LookupSample(ctx, db, table)
+	if err == nil && info == nil && !looksLikeSample(table) {
+		return nil
+	}
+	if err != nil || info == nil || info.Fraction <= 0 || info.Fraction > 1 {
+		if err == nil {
+			err = fmt.Errorf("%w: %s has no usable aqe_samples record", aqeerr.ErrUnknownProvenance, table)
+		}
+		return &Plan{
+			Type:        PlanExact,
+			SQL:         sqlText,
+			OriginalSQL: sqlText,
+			Table:       table,
+			Reason:      fmt.Sprintf("direct query on sample table %s with unknown provenance; results are not scaled", table),
+			Fallback:    aqeerr.Category(err),
 		}
 	}
 
-	return tableName, 0, false
+	reason := fmt.Sprintf("direct query on sample of %s (fraction: %.4f)", info.Table, info.Fraction)
+	switch {
+	case info.StrataColumn != "":
+		reason = fmt.Sprintf("direct query on sample of %s stratified by %s (fraction: %.4f)", info.Table, info.StrataColumn, info.Fraction)
+	case info.Predicate != "":
+		reason = fmt.Sprintf("direct query on sample of %s where %s (fraction: %.4f)", info.Table, info.Predicate, info.Fraction)
+	case info.OutlierTable != "":
+		reason = fmt.Sprintf("direct query on sample of %s without its %s outliers (fraction: %.4f)", info.Table, info.OutlierColumn, info.Fraction)
+	}
+	return &Plan{
+		Type:            PlanSample,
+		SQL:             withGroupMoments(sqlText),
+		OriginalSQL:     sqlText,
+		Table:           info.Table,
+		SampleTable:     table,
+		SampleFraction:  info.Fraction,
+		StrataColumn:    info.StrataColumn,
+		SamplePredicate: info.Predicate,
+		Aggregates:      outputAggregates(q.Main()),
+		Reason:          reason,
+	}
 }
 
 // TableStats contains table metadata for cost estimation
 type TableStats struct {
 	RowCount            int64
-	DistinctValueCounts map[string]int64 // column -> distinct count
-	HasSketches         map[string]bool  // column -> has sketch
-	BestSampleFraction  float64
+	DistinctValueCounts map[string]int64 // lower-case column -> distinct count
+	HasSketches         map[string]bool  // sketchKey(type, column) -> has sketch
+	// BestSampleTable is the smallest uniform sample holding every column
+	// the query reads from the table.
+	BestSampleTable    string
+	BestSampleFraction float64
+	// BestSampleStrata is set when chooseSample picked a stratified sample
+	// whose strata column the WHERE clause pins to BestSampleStratum; then
+	// BestSampleFraction is that stratum's fraction and BestSampleSize the
+	// share of the table the whole sample holds.
+	BestSampleStrata  string
+	BestSampleStratum string
+	BestSampleSize    float64
+	// BestSamplePredicate is set when chooseSample picked a filtered sample
+	// of the rows matching it; BestSampleSize is then its share of the
+	// table too.
+	BestSamplePredicate string
+	// BestSampleOutliers is set when chooseSample picked an outlier sample:
+	// the table holding the outliers left out of it.
+	BestSampleOutliers string
+	// BestSampleGroups is set when chooseSample picked a stratified sample
+	// whose strata column the query groups by, in result column
+	// BestSampleGroupOutput: every stratum's fraction by strata value.
+	// BestSampleFraction and BestSampleSize are then the share of the
+	// table the sample holds.
+	BestSampleGroups      map[string]float64
+	BestSampleGroupOutput string
+	// sampleNote says why chooseSample left the query no sample.
+	sampleNote string
+	// Selectivity is the estimated share of rows the query's WHERE clause
+	// keeps; 0 means all of them.
+	Selectivity float64
+	// columns are the table's column statistics, by lower-case name.
+	columns map[string]storage.ColumnStats
 }
 
-// getTableStats retrieves table statistics for planning
-func (p *Planner) getTableStats(ctx context.Context, db *sql.DB, table string) (*TableStats, error) {
+// matchingRows is the estimated number of rows the query's WHERE clause
+// keeps.
+func (s *TableStats) matchingRows() float64 {
+	if s.Selectivity > 0 && s.Selectivity < 1 {
+		return float64(s.RowCount) * s.Selectivity
+	}
+	return float64(s.RowCount)
+}
+
+// getTableStats retrieves table statistics for planning q
+func (p *Planner) getTableStats(ctx context.Context, db *sql.DB, table string, q *Query) (*TableStats, error) {
 	stats := &TableStats{
 		DistinctValueCounts: make(map[string]int64),
 		HasSketches:         make(map[string]bool),
 	}
 
-	// Get row count
-	err := db.QueryRowContext(ctx, "SELECT row_count FROM aqe_table_stats WHERE table_name = ?", table).Scan(&stats.RowCount)
+	provided, err := p.statsProvider.TableStatistics(ctx, db, table)
 	if err != nil {
-		// Fallback: count directly
-		err = db.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s", table)).Scan(&stats.RowCount)
-		if err != nil {
-			return nil, err
-		}
+		return nil, err
+	}
+	stats.RowCount = provided.RowCount
+	for column, n := range provided.Distinct {
+		stats.DistinctValueCounts[column] = n
 	}
+	stats.Selectivity = whereSelectivity(q, table, provided.Columns)
+	stats.columns = provided.Columns
 
 	// Check for available sketches
-	rows, err := db.QueryContext(ctx, "SELECT column_name, sketch_type FROM aqe_sketches WHERE table_name = ?", table)
+	rows, err := db.QueryContext(ctx, "SELECT column_name, sketch_type FROM aqe_sketches WHERE table_name = ? AND COALESCE(degraded, 0) = 0", table)
 	if err == nil {
 		defer rows.Close()
 		for rows.Next() {
 			var column, sketchType string
 			if err := rows.Scan(&column, &sketchType); err == nil {
-				stats.HasSketches[column] = true
+				stats.HasSketches[sketchKey(sketchType, column)] = true
 			}
 		}
 	}
 
-	// Find best available sample
-	var bestFraction float64
-	err = db.QueryRowContext(ctx,
-		"SELECT sample_fraction FROM aqe_samples WHERE table_name = ? ORDER BY sample_fraction ASC LIMIT 1",
-		table).Scan(&bestFraction)
-	if err == nil {
-		stats.BestSampleFraction = bestFraction
+	// Find best available sample: the smallest uniform one, by its record,
+	// that copied the columns q needs
+	for _, s := range uniformSamples(ctx, db, table, q) {
+		if stats.BestSampleTable == "" || s.Fraction < stats.BestSampleFraction {
+			stats.BestSampleTable, stats.BestSampleFraction = s.SampleTable, s.Fraction
+		}
 	}
 
 	return stats, nil
 }
 
+// uniformSamples returns table's uniform samples, by their records, that
+// copied the columns q needs. Live samples count among them while they
+// have seen all but storage.StaleRowDrift of their table's rows; past
+// that, their fraction overstates what they hold of it until maintenance
+// catches them up.
+func uniformSamples(ctx context.Context, db *sql.DB, table string, q *Query) []storage.SampleInfo {
+	var need []string
+	var all bool
+	if refs, ok := q.sampleRefs(table); ok {
+		need, all = sampleColumns(refs)
+	}
+	samples, err := storage.ListSamples(ctx, db, table)
+	if err != nil {
+		return nil
+	}
+	var out []storage.SampleInfo
+	var tableCols []string
+	for _, s := range samples {
+		if s.StrataColumn != "" || s.Predicate != "" || s.OutlierTable != "" || s.UniverseColumn != "" || s.Fraction <= 0 || s.Fraction >= 1 {
+			continue
+		}
+		if s.ReservoirSize > 0 {
+			lag, err := storage.ReservoirLag(ctx, db, table, s.ScannedRowid)
+			if err != nil || float64(lag) > storage.StaleRowDrift*float64(s.BaseRows) {
+				continue
+			}
+		}
+		if len(s.Columns) > 0 && tableCols == nil {
+			tableCols, _ = storage.TableColumns(ctx, db, table)
+		}
+		if SampleCovers(s.Columns, need, all, tableCols) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// minSampleMatches is the fewest rows matching the WHERE clause a sample
+// must be expected to hold to estimate from; below it the error estimate
+// is not worth trusting.
+const minSampleMatches = 30
+
+// sampleCandidate is a sample chooseSample weighs: its fraction of the
+// rows q's WHERE clause keeps, and its share of the whole table.
+type sampleCandidate struct {
+	table, strata, stratum, predicate, outliers string
+	fraction, size                              float64
+	// groups and groupOutput are set for a stratified sample read whole
+	// by a query grouped by its strata column (see strataOutput).
+	groups      map[string]float64
+	groupOutput string
+}
+
+// chooseSample makes stats' best sample the cheapest one expected to
+// answer q within tolerance: a covering uniform sample, larger than the
+// smallest when the WHERE clause is selective, or a stratified sample
+// whose strata column the clause pins to one value, read at that
+// stratum's fraction, or a filtered sample whose predicate the clause
+// implies, or an outlier sample of a measure q sums, or a stratified
+// sample read whole when q groups by its strata column. Ties go to outlier
+// samples, whose exact outliers cut variance SampleError doesn't model,
+// and the latter beat other samples meeting tolerance, as every group is
+// bounded from its own stratum. When none meets tolerance the most accurate is kept, and when none is
+// expected to hold minSampleMatches matching rows the query is left no
+// sample.
+func (p *Planner) chooseSample(ctx context.Context, db *sql.DB, table string, q *Query, stats *TableStats, tolerance float64) {
+	candidates := outlierSamples(ctx, db, table, q)
+	for _, s := range uniformSamples(ctx, db, table, q) {
+		candidates = append(candidates, sampleCandidate{table: s.SampleTable, fraction: s.Fraction, size: s.Fraction})
+	}
+	candidates = append(candidates, stratifiedSamples(ctx, db, table, q)...)
+	candidates = append(candidates, filteredSamples(ctx, db, table, q, stats.columns)...)
+	if len(candidates) == 0 {
+		return
+	}
+
+	matching := stats.matchingRows()
+	var best *sampleCandidate
+	for i := range candidates {
+		c := &candidates[i]
+		if c.fraction*matching < minSampleMatches {
+			continue
+		}
+		ok := SampleError(c.fraction, matching) <= tolerance
+		switch {
+		case best == nil:
+			best = c
+		case ok && SampleError(best.fraction, matching) > tolerance:
+			best = c
+		case ok && c.groups != nil && best.groups == nil:
+			best = c
+		case ok && (c.groups == nil) == (best.groups == nil) && c.size < best.size:
+			best = c
+		case !ok && SampleError(best.fraction, matching) > tolerance && c.fraction > best.fraction:
+			best = c
+		}
+	}
+	if best == nil {
+		largest := candidates[0]
+		for _, c := range candidates[1:] {
+			if c.fraction > largest.fraction {
+				largest = c
+			}
+		}
+		stats.BestSampleTable, stats.BestSampleFraction = "", 0
+		stats.sampleNote = fmt.Sprintf("too few sample rows match the WHERE clause (about %.0f in %s)",
+			largest.fraction*matching, largest.table)
+		return
+	}
+	stats.BestSampleTable, stats.BestSampleFraction = best.table, best.fraction
+	stats.BestSampleStrata, stats.BestSampleStratum = best.strata, best.stratum
+	stats.BestSamplePredicate = best.predicate
+	stats.BestSampleOutliers = best.outliers
+	stats.BestSampleGroups, stats.BestSampleGroupOutput = best.groups, best.groupOutput
+	if best.strata != "" || best.predicate != "" {
+		stats.BestSampleSize = best.size
+	}
+}
+
+// stratifiedSamples returns table's stratified samples, by their records,
+// that copied the columns q needs and whose strata column the WHERE clause
+// of q's main SELECT pins to one value, each at that stratum's fraction,
+// or that the SELECT groups by, each with every stratum's fraction.
+func stratifiedSamples(ctx context.Context, db *sql.DB, table string, q *Query) []sampleCandidate {
+	s := q.Main()
+	if len(s.From) != 1 || s.From[0].Subquery != nil || !strings.EqualFold(s.From[0].Name, table) {
+		return nil
+	}
+	var need []string
+	var all bool
+	if refs, ok := q.sampleRefs(table); ok {
+		need, all = sampleColumns(refs)
+	}
+	samples, err := storage.ListSamples(ctx, db, table)
+	if err != nil {
+		return nil
+	}
+	var out []sampleCandidate
+	var tableCols []string
+	for _, info := range samples {
+		if info.StrataColumn == "" {
+			continue
+		}
+		var value, output string
+		pinned, grouped := false, false
+		if s.Where != nil {
+			value, pinned = pinnedValue(s.Where.Text, info.StrataColumn)
+		}
+		if !pinned {
+			output, grouped = strataOutput(s, info.StrataColumn)
+		}
+		if !pinned && !grouped {
+			continue
+		}
+		if len(info.Columns) > 0 && tableCols == nil {
+			tableCols, _ = storage.TableColumns(ctx, db, table)
+		}
+		if !SampleCovers(info.Columns, need, all, tableCols) {
+			continue
+		}
+		if grouped {
+			fractions, share, err := storage.StratumFractions(ctx, db, info.SampleTable)
+			if err != nil || len(fractions) == 0 || share <= 0 || share > 1 {
+				continue
+			}
+			usable := true
+			for _, f := range fractions {
+				usable = usable && f > 0 && f <= 1
+			}
+			if usable {
+				out = append(out, sampleCandidate{
+					table:       info.SampleTable,
+					strata:      info.StrataColumn,
+					fraction:    share,
+					size:        share,
+					groups:      fractions,
+					groupOutput: output,
+				})
+			}
+			continue
+		}
+		fraction, ok, err := storage.StratumFraction(ctx, db, info.SampleTable, value)
+		if err != nil || !ok || fraction <= 0 || fraction > 1 {
+			continue
+		}
+		out = append(out, sampleCandidate{
+			table:    info.SampleTable,
+			strata:   info.StrataColumn,
+			stratum:  value,
+			fraction: fraction,
+			size:     info.Fraction,
+		})
+	}
+	return out
+}
+
+// strataOutput is the result column of s holding strataCol when s groups
+// by it, selects it bare and buckets no timestamp, so every result row
+// falls in one stratum, which the executor scales it by.
+func strataOutput(s *Select, strataCol string) (string, bool) {
+	var keys []string
+	grouped := false
+	for _, g := range s.GroupBy {
+		keys = append(keys, g.Text)
+		if c, ok := bareColumn(g); ok && strings.EqualFold(c, strataCol) {
+			grouped = true
+		}
+	}
+	if !grouped || len(DetectTimeBuckets(keys)) > 0 {
+		return "", false
+	}
+	for _, item := range s.Items {
+		if c, ok := bareColumn(item.Expr); ok && strings.EqualFold(c, strataCol) {
+			if item.Alias != "" {
+				return item.Alias, true
+			}
+			return c, true
+		}
+	}
+	return "", false
+}
+
+// pinSample makes the uniform sample a SAMPLE hint names stats' best
+// sample. The hint must name the query's base table, and a sample of that
+// fraction covering the query must exist.
+func (p *Planner) pinSample(ctx context.Context, db *sql.DB, table string, q *Query, stats *TableStats, hints Hints) error {
+	if !strings.EqualFold(hints.SampleTable, table) {
+		return fmt.Errorf("%w: hint SAMPLE(%s, %g): only the query's base table %s can be sampled",
+			aqeerr.ErrUnsupportedQuery, hints.SampleTable, hints.SampleFraction, table)
+	}
+	for _, s := range uniformSamples(ctx, db, table, q) {
+		if math.Abs(s.Fraction-hints.SampleFraction) <= 1e-9 {
+			stats.BestSampleTable, stats.BestSampleFraction = s.SampleTable, s.Fraction
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: hint SAMPLE(%s, %g): no uniform sample of that fraction covers the query",
+		aqeerr.ErrNoSample, hints.SampleTable, hints.SampleFraction)
+}
+
+// hintedSample is the candidate reading sampleTable, or nil.
+func hintedSample(strategies []*Plan, sampleTable string) *Plan {
+	for _, s := range strategies {
+		if s.Type == PlanSample && s.SampleTable == sampleTable {
+			return s
+		}
+	}
+	return nil
+}
+
+// SampleCovers reports whether a sample copying sampleCols (empty for
+// all) holds the columns need of a table with tableCols; all asks for every
+// column. Names the table doesn't have belong to other tables in a join and
+// are ignored, unless tableCols is nil, when they all count.
+func SampleCovers(sampleCols, need []string, all bool, tableCols []string) bool {
+	if len(sampleCols) == 0 {
+		return true
+	}
+	if all {
+		return false
+	}
+	has := make(map[string]bool, len(sampleCols))
+	for _, c := range sampleCols {
+		has[strings.ToLower(c)] = true
+	}
+	inTable := make(map[string]bool, len(tableCols))
+	for _, c := range tableCols {
+		inTable[strings.ToLower(c)] = true
+	}
+	for _, c := range need {
+		c = strings.ToLower(c)
+		if (tableCols == nil || inTable[c]) && !has[c] {
+			return false
+		}
+	}
+	return true
+}
+
+// sketchKey indexes TableStats.HasSketches.
+func sketchKey(sketchType, column string) string {
+	return sketchType + ":" + column
+}
+
 // evaluateStrategies generates and evaluates different execution plans
-func (p *Planner) evaluateStrategies(ctx context.Context, db *sql.DB, sql, table string, features QueryFeatures, stats *TableStats, maxRelError float64) []*Plan {
+func (p *Planner) evaluateStrategies(ctx context.Context, db *sql.DB, q *Query, sql, table string, features QueryFeatures, stats *TableStats, maxRelError float64) []*Plan {
 	var strategies []*Plan
 
 	// Strategy 1: Exact execution
@@ -240,9 +1060,13 @@ func (p *Planner) evaluateStrategies(ctx context.Context, db *sql.DB, sql, table
 		EstimatedError: 0.0,
 		Reason:         "exact execution",
 	}
+	if j := p.evaluateBloomJoin(ctx, db, q, sql); j != nil {
+		exactPlan.BloomJoin = j
+		exactPlan.Reason += ", " + j.describe()
+	}
 	strategies = append(strategies, exactPlan)
 
-	// Strategy 2: Sketch-based (for DISTINCT or heavy-hitter queries)
+	// Strategy 2: Sketch-based (for DISTINCT, heavy-hitter or percentile queries)
 	if features.HasDistinct {
 		sketchPlan := p.evaluateSketchStrategy(sql, table, features, stats, "hyperloglog")
 		if sketchPlan != nil {
@@ -257,17 +1081,69 @@ func (p *Planner) evaluateStrategies(ctx context.Context, db *sql.DB, sql, table
 		}
 	}
 
+	if features.HasQuantile {
+		sketchPlan := p.evaluateSketchStrategy(sql, table, features, stats, "tdigest")
+		if sketchPlan != nil {
+			strategies = append(strategies, sketchPlan)
+		}
+	}
+
 	// Strategy 3: Sample-based
 	if stats.BestSampleFraction > 0 {
-		samplePlan := p.evaluateSampleStrategy(ctx, db, sql, table, features, stats)
+		samplePlan := p.evaluateSampleStrategy(ctx, db, q, sql, table, features, stats)
 		if samplePlan != nil {
 			strategies = append(strategies, samplePlan)
 		}
 	}
 
+	// Strategies 4 to 7: Sketch, universe, synopsis and wander joins,
+	// reading the joined table through its sketch, a universe sample, a
+	// join synopsis or its join key's index; every other plan reads it in
+	// full. Wander joins are for joins nothing was built for.
+	var joins []*Plan
+	var joinedRows float64
+	var built bool // a universe sample or join synopsis serves the join
+	if sketchJoin, smallRows := p.evaluateSketchJoinStrategy(ctx, db, q, sql, stats, maxRelError); sketchJoin != nil {
+		joins, joinedRows = append(joins, sketchJoin), smallRows
+	}
+	if universeJoin, rows := p.evaluateUniverseJoinStrategy(ctx, db, q, sql, features, stats, maxRelError); universeJoin != nil {
+		joins, joinedRows, built = append(joins, universeJoin), rows, true
+	}
+	if synopsisJoin, rows := p.evaluateJoinSynopsisStrategy(ctx, db, q, sql, features, stats, maxRelError); synopsisJoin != nil {
+		joins, joinedRows, built = append(joins, synopsisJoin), rows, true
+	}
+	if !built {
+		if wanderJoin, rows := p.evaluateWanderJoinStrategy(ctx, db, q, sql, features, stats, maxRelError); wanderJoin != nil {
+			joins, joinedRows = append(joins, wanderJoin), rows
+		}
+	}
+	for _, s := range strategies {
+		s.EstimatedCost += joinedRows * p.costModel.ScanCostPerRow
+	}
+	strategies = append(strategies, joins...)
+
 	return strategies
 }
 
+// groupCount estimates the groups of a GROUP BY over columns as the
+// product of their distinct counts, assuming independence. ok is false
+// unless every key is a column with a known count.
+func (s *TableStats) groupCount(columns []string) (float64, bool) {
+	groups := 1.0
+	for _, c := range columns {
+		c = strings.Trim(strings.ToLower(c), "\"`")
+		if i := strings.LastIndexByte(c, '.'); i >= 0 {
+			c = strings.Trim(c[i+1:], "\"`")
+		}
+		n, ok := s.DistinctValueCounts[c]
+		if !ok || n <= 0 {
+			return 0, false
+		}
+		groups *= float64(n)
+	}
+	return groups, len(columns) > 0
+}
+
 // estimateExactCost estimates the cost of exact execution
 func (p *Planner) estimateExactCost(features QueryFeatures, stats *TableStats) float64 {
 	cost := float64(stats.RowCount) * p.costModel.ScanCostPerRow
@@ -275,7 +1151,10 @@ func (p *Planner) estimateExactCost(features QueryFeatures, stats *TableStats) f
 	// Add GROUP BY cost
 	if features.HasGroupBy {
 		// Estimate number of groups (heuristic)
-		estimatedGroups := math.Min(float64(stats.RowCount), 10000) // cap at 10k groups
+		estimatedGroups := math.Min(stats.matchingRows(), 10000) // cap at 10k groups
+		if groups, ok := stats.groupCount(features.GroupByColumns); ok {
+			estimatedGroups = math.Min(stats.matchingRows(), groups)
+		}
 		cost += estimatedGroups * p.costModel.HashCostPerGroup
 	}
 
@@ -284,23 +1163,24 @@ func (p *Planner) estimateExactCost(features QueryFeatures, stats *TableStats) f
 
 // evaluateSketchStrategy creates a sketch-based plan if applicable
 func (p *Planner) evaluateSketchStrategy(sql, table string, features QueryFeatures, stats *TableStats, sketchType string) *Plan {
+	if !p.allows(StrategySketch) {
+		return nil
+	}
 	var column string
 	var estimatedError float64
 
 	if sketchType == "hyperloglog" && features.HasDistinct {
-		// Extract DISTINCT column (simplified)
-		if strings.Contains(strings.ToUpper(sql), "COUNT(DISTINCT") {
-			// Try to extract column name
-			column = "id" // simplified - would need better parsing
-		}
+		// the sketch counts the whole column, so only an unfiltered scalar
+		// COUNT(DISTINCT col) can be answered from it
+		sd, ok := ParseScalarDistinct(sql)
+		column = sd.Column
 
-		if stats.HasSketches[column] {
-			// HyperLogLog standard error ≈ 1.04/√m, assume m=1024
-			estimatedError = 1.04 / math.Sqrt(1024) // ≈ 3.25%
+		if ok && stats.HasSketches[sketchKey(sketchType, column)] {
+			estimatedError = SketchError(sketchType)
 
 			return &Plan{
 				Type:           PlanSketch,
-				SQL:            sql, // Would need rewriting for sketch
+				SQL:            sql, // answered from the sketch by the executor
 				OriginalSQL:    sql,
 				Table:          table,
 				SketchType:     sketchType,
@@ -313,18 +1193,17 @@ func (p *Planner) evaluateSketchStrategy(sql, table string, features QueryFeatur
 	}
 
 	if sketchType == "countmin" && features.IsHeavyHitter {
-		// Count-Min sketch for heavy hitters
-		if len(features.GroupByColumns) > 0 {
-			column = features.GroupByColumns[0]
-		}
+		// per-group counts are read from the sketch for its tracked keys,
+		// so only a plain COUNT(*) ... GROUP BY col can be answered
+		g, ok := ParseGroupCount(sql)
+		column = g.Column
 
-		if stats.HasSketches[column] {
-			// Count-Min error ≈ ε * total_count, assume ε = 0.01
-			estimatedError = 0.01 // 1%
+		if ok && stats.HasSketches[sketchKey(sketchType, column)] {
+			estimatedError = SketchError(sketchType)
 
 			return &Plan{
 				Type:           PlanSketch,
-				SQL:            sql, // Would need rewriting for sketch
+				SQL:            sql, // answered from the sketch by the executor
 				OriginalSQL:    sql,
 				Table:          table,
 				SketchType:     sketchType,
@@ -336,41 +1215,104 @@ func (p *Planner) evaluateSketchStrategy(sql, table string, features QueryFeatur
 		}
 	}
 
+	if sketchType == "tdigest" && features.HasQuantile {
+		// the digest summarizes the whole column, so only an unfiltered
+		// scalar MEDIAN or PERCENTILE can be answered from it
+		sq, ok := ParseScalarQuantile(sql)
+		column = sq.Column
+
+		if ok && stats.HasSketches[sketchKey(sketchType, column)] {
+			estimatedError = SketchError(sketchType)
+
+			return &Plan{
+				Type:           PlanSketch,
+				SQL:            sql, // answered from the sketch by the executor
+				OriginalSQL:    sql,
+				Table:          table,
+				SketchType:     sketchType,
+				SketchColumn:   column,
+				EstimatedCost:  p.costModel.SketchQueryCost,
+				EstimatedError: estimatedError,
+				Reason:         "using t-digest sketch for percentiles",
+			}
+		}
+	}
+
 	return nil
 }
 
 // evaluateSampleStrategy creates a sample-based plan
-func (p *Planner) evaluateSampleStrategy(ctx context.Context, db *sql.DB, sql, table string, features QueryFeatures, stats *TableStats) *Plan {
-	sampleTable := fmt.Sprintf("%s__sample_%s", table, fractionName(stats.BestSampleFraction))
+func (p *Planner) evaluateSampleStrategy(ctx context.Context, db *sql.DB, q *Query, sql, table string, features QueryFeatures, stats *TableStats) *Plan {
+	if !p.allows(StrategySample) {
+		return nil
+	}
+	sampleTable := stats.BestSampleTable
 
 	// Check if sample table exists
-	var exists int
-	err := db.QueryRowContext(ctx,
-		"SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name=?",
-		sampleTable).Scan(&exists)
-
-	if err != nil || exists == 0 {
+	if !p.sampleReady(ctx, db, sampleTable) {
 		return nil // Sample doesn't exist
 	}
 
-	// Estimate sample error (simplified)
-	estimatedError := math.Sqrt(1.0 / (stats.BestSampleFraction * float64(stats.RowCount)))
+	// Estimate sample error from the sample rows the WHERE clause keeps
+	estimatedError := SampleError(stats.BestSampleFraction, stats.matchingRows())
 
-	// Rewrite SQL for sample (basic approach)
-	rewrittenSQL := p.rewriteSQLForSample(sql, table, sampleTable, stats.BestSampleFraction)
+	rewrittenSQL, ok := p.rewriteSQLForSample(q, sql, table, sampleTable)
+	if !ok {
+		return nil // the table is read where a sample would not scale
+	}
+	if len(features.TimeBuckets) > 0 {
+		rewrittenSQL = withBucketRowCount(rewrittenSQL)
+	} else {
+		rewrittenSQL = withGroupMoments(rewrittenSQL)
+	}
 
-	sampleCost := float64(stats.RowCount)*stats.BestSampleFraction*p.costModel.ScanCostPerRow + p.costModel.SampleSetupCost
+	size := stats.BestSampleFraction
+	reason := fmt.Sprintf("using %.1f%% sample", size*100)
+	switch {
+	case stats.BestSampleGroups != nil:
+		if !strings.Contains(rewrittenSQL, GroupRowsColumn) {
+			return nil // per-stratum scaling needs the grouped moments
+		}
+		size = stats.BestSampleSize
+		reason = fmt.Sprintf("using %.1f%% sample stratified by %s, each group scaled by its own stratum's fraction",
+			size*100, stats.BestSampleStrata)
+	case stats.BestSampleStrata != "":
+		size = stats.BestSampleSize
+		reason = fmt.Sprintf("using the %s = %s stratum (%.1f%%) of a sample stratified by %s",
+			stats.BestSampleStrata, stats.BestSampleStratum, stats.BestSampleFraction*100, stats.BestSampleStrata)
+	case stats.BestSamplePredicate != "":
+		size = stats.BestSampleSize
+		reason = fmt.Sprintf("using %.1f%% sample of rows where %s", stats.BestSampleFraction*100, stats.BestSamplePredicate)
+	}
+	var outlierSQL string
+	if stats.BestSampleOutliers != "" {
+		if exists, err := storage.TableExists(ctx, db, stats.BestSampleOutliers); err != nil || !exists {
+			return nil // the sample would be missing its outliers
+		}
+		if outlierSQL, ok = p.rewriteSQLForSample(q, sql, table, stats.BestSampleOutliers); !ok {
+			return nil
+		}
+		reason = fmt.Sprintf("using %.1f%% sample plus the exact outliers in %s", stats.BestSampleFraction*100, stats.BestSampleOutliers)
+	}
+	sampleCost := float64(stats.RowCount)*size*p.costModel.ScanCostPerRow + p.costModel.SampleSetupCost
 
 	return &Plan{
-		Type:           PlanSample,
-		SQL:            rewrittenSQL,
-		OriginalSQL:    sql,
-		Table:          table,
-		SampleTable:    sampleTable,
-		SampleFraction: stats.BestSampleFraction,
-		EstimatedCost:  sampleCost,
-		EstimatedError: estimatedError,
-		Reason:         fmt.Sprintf("using %.1f%% sample", stats.BestSampleFraction*100),
+		Type:            PlanSample,
+		SQL:             rewrittenSQL,
+		OriginalSQL:     sql,
+		Table:           table,
+		SampleTable:     sampleTable,
+		SampleFraction:  stats.BestSampleFraction,
+		Aggregates:      outputAggregates(q.Main()),
+		EstimatedCost:   sampleCost,
+		EstimatedError:  estimatedError,
+		StrataColumn:    stats.BestSampleStrata,
+		StrataFractions: stats.BestSampleGroups,
+		StrataOutput:    stats.BestSampleGroupOutput,
+		SamplePredicate: stats.BestSamplePredicate,
+		OutlierTable:    stats.BestSampleOutliers,
+		OutlierSQL:      outlierSQL,
+		Reason:          reason,
 	}
 }
 
@@ -404,30 +1346,50 @@ func (p *Planner) chooseBestStrategy(strategies []*Plan, maxRelError float64) *P
 	return bestStrategy
 }
 
-// rewriteSQLForSample transforms SQL to use sample table
-func (p *Planner) rewriteSQLForSample(sql, originalTable, sampleTable string, fraction float64) string {
-	// Replace table name
-	rewritten := strings.Replace(sql, originalTable, sampleTable, -1)
+// exactFallbackReason explains why an approximate plan was not chosen:
+// either none could be built, or none met the error tolerance.
+func exactFallbackReason(strategies []*Plan, maxRelError float64) error {
+	approx := 0
+	for _, s := range strategies {
+		if s.Type == PlanExact {
+			continue
+		}
+		approx++
+		if s.EstimatedError <= maxRelError {
+			return nil // an approximate plan qualified but exact was cheaper
+		}
+	}
+	if approx == 0 {
+		return aqeerr.ErrNoSample
+	}
+	return aqeerr.ErrToleranceUnreachable
+}
 
-	// This is a simplified rewriting - production would need a proper SQL parser
-	if strings.Contains(strings.ToUpper(rewritten), "COUNT(") {
-		// Would need to wrap COUNT() with scaling
-		// For now, leave as-is since scaling happens in executor
+// rewriteSQLForSample points the FROM sources of q that read originalTable
+// at sampleTable. Tables read by subqueries in expressions keep reading the
+// full table. An unaliased source is aliased back to originalTable when
+// columns are qualified with its name. ok is false when the table can't be
+// sampled (see Query.sampleRefs).
+func (p *Planner) rewriteSQLForSample(q *Query, sql, originalTable, sampleTable string) (string, bool) {
+	refs, ok := q.sampleRefs(originalTable)
+	if !ok || len(refs) == 0 {
+		return "", false
 	}
 
-	return rewritten
+	return replaceRefs(sql, refs, func(r sampleRef) string {
+		if r.ref.Alias == "" && r.owner.qualifies(r.ref.Name) {
+			return sampleTable + " AS " + r.ref.Name
+		}
+		return sampleTable
+	}), true
 }
 
-// Helper function to convert fraction to string
-func fractionName(f float64) string {
-	if f <= 0 {
-		return "0_000"
-	}
-	s := fmt.Sprintf("%.3f", f)
-	s = strings.Replace(s, ".", "_", 1)
-	s = strings.TrimRight(s, "0")
-	if strings.HasSuffix(s, "_") {
-		s += "0"
+// replaceRefs replaces the table name of each of refs in sql with repl(ref).
+func replaceRefs(sql string, refs []sampleRef, repl func(sampleRef) string) string {
+	// replace right to left so earlier offsets stay valid
+	sort.Slice(refs, func(i, j int) bool { return refs[i].ref.nameStart > refs[j].ref.nameStart })
+	for _, r := range refs {
+		sql = sql[:r.ref.nameStart] + repl(r) + sql[r.ref.nameEnd:]
 	}
-	return s
+	return sql
 }
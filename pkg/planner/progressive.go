@@ -0,0 +1,91 @@
+package planner
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// DefaultProgressiveFractions are the subset sizes a progressive query is
+// refined through before its exact answer.
+var DefaultProgressiveFractions = []float64{0.001, 0.01, 0.1}
+
+// ProgressivePlans plans sqlText as an online aggregation: sample plans
+// over growing subsets of its base table, one per fraction in (0, 1) in
+// ascending order, followed by the exact plan. Subsets are nested, so each
+// stage refines the last rather than starting over. A stage no larger than
+// the table's smallest uniform sample reads a subset of that sample instead of
+// the table. Queries a sample can't answer get just the plan Plan would
+// give them, as do direct queries on a sample and every query while
+// sampling is switched off.
+func (p *Planner) ProgressivePlans(ctx context.Context, db *sql.DB, sqlText string, fractions []float64, maxRelError float64) ([]*Plan, error) {
+	exact, err := p.Plan(ctx, db, sqlText, maxRelError, true)
+	if err != nil {
+		return nil, err
+	}
+	if exact.Type != PlanExact || exact.Fallback != "" || exact.Table == "" || !p.allows(StrategySample) {
+		return []*Plan{exact}, nil
+	}
+	q, err := Parse(exact.SQL)
+	if err != nil {
+		return []*Plan{exact}, nil
+	}
+	if _, ok := q.sampleRefs(exact.Table); !ok {
+		return []*Plan{exact}, nil
+	}
+	stats, err := p.getTableStats(ctx, db, exact.Table, q)
+	if err != nil {
+		return []*Plan{exact}, nil
+	}
+	features := p.parseQueryFeatures(q)
+	p.applyRoles(ctx, db, exact.Table, &features)
+	if stats.BestSampleTable != "" && !p.sampleReady(ctx, db, stats.BestSampleTable) {
+		stats.BestSampleTable = ""
+	}
+	aggregates := outputAggregates(q.Main())
+
+	fractions = append([]float64(nil), fractions...)
+	sort.Float64s(fractions)
+	var plans []*Plan
+	for _, f := range fractions {
+		if f <= 0 || f >= 1 || len(plans) > 0 && f == plans[len(plans)-1].SampleFraction {
+			continue
+		}
+		source, subset, sampleTable := exact.Table, f, ""
+		if stats.BestSampleTable != "" && f <= stats.BestSampleFraction {
+			source, subset, sampleTable = stats.BestSampleTable, f/stats.BestSampleFraction, stats.BestSampleTable
+		}
+		refs, _ := q.sampleRefs(exact.Table)
+		derived := "(" + storage.ActiveDialect().Subset(source, subset) + ")"
+		stageSQL := replaceRefs(exact.SQL, refs, func(r sampleRef) string {
+			if r.ref.Alias == "" {
+				return derived + " AS " + r.ref.Name
+			}
+			return derived
+		})
+		if len(features.TimeBuckets) > 0 {
+			stageSQL = withBucketRowCount(stageSQL)
+		} else {
+			stageSQL = withGroupMoments(stageSQL)
+		}
+		plans = append(plans, &Plan{
+			Type:            PlanSample,
+			SQL:             stageSQL,
+			OriginalSQL:     exact.OriginalSQL,
+			Table:           exact.Table,
+			SampleTable:     sampleTable,
+			SampleFraction:  f,
+			Aggregates:      aggregates,
+			TableRows:       stats.RowCount,
+			MaxRelError:     maxRelError,
+			ConfidenceLevel: exact.ConfidenceLevel,
+			TimeBuckets:     features.TimeBuckets,
+			Reason:          fmt.Sprintf("progressive stage over %.2f%% of %s", f*100, exact.Table),
+		})
+	}
+	exact.Reason = "progressive final stage: exact execution"
+	return append(plans, exact), nil
+}
@@ -0,0 +1,56 @@
+package planner
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ScalarQuantile is a query that is only an unfiltered MEDIAN(Column) or
+// PERCENTILE(Column, p) over Table, the shape a t-digest answers on its
+// own. Quantile is in [0, 1]; PERCENTILE takes p in [0, 100] like SQLite's
+// percentile extension. Output is the result column's name.
+type ScalarQuantile struct {
+	Table    string
+	Column   string
+	Output   string
+	Quantile float64
+}
+
+// ParseScalarQuantile reports whether sqlText has the ScalarQuantile shape.
+func ParseScalarQuantile(sqlText string) (ScalarQuantile, bool) {
+	q, err := Parse(sqlText)
+	if err != nil || len(q.With) > 0 || len(q.Selects) != 1 {
+		return ScalarQuantile{}, false
+	}
+	s := q.Main()
+	if len(s.Items) != 1 || len(s.From) != 1 || s.From[0].Subquery != nil || s.Where != nil ||
+		len(s.GroupBy) > 0 || s.Having != nil || s.Limit != nil || len(s.Calls) != 1 || len(s.Subqueries) > 0 {
+		return ScalarQuantile{}, false
+	}
+	c, item := s.Calls[0], s.Items[0]
+	if c.Distinct || c.Window || c.start != item.Expr.start || c.end != item.Expr.end ||
+		len(item.Expr.Columns) != 1 || item.Expr.Columns[0] != unqualified(item.Expr.Columns[0]) {
+		return ScalarQuantile{}, false
+	}
+
+	sq := ScalarQuantile{Table: s.From[0].Name, Column: item.Expr.Columns[0], Output: item.Alias}
+	switch {
+	case c.Name == "MEDIAN" && len(c.Args) == 1:
+		sq.Quantile = 0.5
+	case c.Name == "PERCENTILE" && len(c.Args) == 2:
+		p, err := strconv.ParseFloat(strings.TrimSpace(c.Args[1]), 64)
+		if err != nil || p < 0 || p > 100 {
+			return ScalarQuantile{}, false
+		}
+		sq.Quantile = p / 100
+	default:
+		return ScalarQuantile{}, false
+	}
+	if unqualified(strings.TrimSpace(c.Args[0])) != sq.Column {
+		return ScalarQuantile{}, false
+	}
+	if sq.Output == "" {
+		sq.Output = item.Expr.Text
+	}
+	return sq, true
+}
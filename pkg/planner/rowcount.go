@@ -0,0 +1,53 @@
+package planner
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// trackedCountPlan answers a bare SELECT COUNT(*) FROM t, with no WHERE,
+// grouping or other outputs, from t's tracked row count when it has one,
+// so the exact answer costs a lookup instead of a scan. Should t stop
+// being tracked before the plan runs, its SQL counts the rows instead.
+func trackedCountPlan(ctx context.Context, db *sql.DB, q *Query, sqlText string) *Plan {
+	if len(q.With) > 0 || len(q.Selects) != 1 {
+		return nil
+	}
+	s := q.Main()
+	if s.Distinct || len(s.From) != 1 || s.From[0].Subquery != nil || s.Where != nil || len(s.GroupBy) > 0 ||
+		s.Having != nil || s.Limit != nil || s.Offset != nil || len(s.Subqueries) > 0 || len(s.Items) == 0 {
+		return nil
+	}
+	table := s.From[0].Name
+	outputs := make([]string, len(s.Items))
+	for i, item := range s.Items {
+		call, ok := itemCall(s, item.Expr)
+		if !ok || !isCountStar(call, item.Expr) {
+			return nil
+		}
+		name := item.Alias
+		if name == "" {
+			name = item.Expr.Text // as SQLite names the column
+		}
+		outputs[i] = "aqe_count AS " + `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+	}
+	n, ok, err := storage.ExactRowCount(ctx, db, table)
+	if err != nil || !ok {
+		return nil
+	}
+
+	literal := "'" + strings.ReplaceAll(strings.ToLower(table), "'", "''") + "'"
+	return &Plan{
+		Type: PlanExact,
+		SQL: fmt.Sprintf("SELECT %s FROM (SELECT COALESCE((SELECT row_count FROM aqe_row_counts WHERE table_name = %s), (SELECT COUNT(*) FROM %s)) AS aqe_count)",
+			strings.Join(outputs, ", "), literal, table),
+		OriginalSQL:  sqlText,
+		Table:        table,
+		TrackedCount: true,
+		Reason:       fmt.Sprintf("COUNT(*) read from %s's tracked row count (%d rows)", table, n),
+	}
+}
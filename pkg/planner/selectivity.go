@@ -0,0 +1,447 @@
+package planner
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// Selectivities assumed for predicates the collected statistics can't
+// estimate: an unanalyzed column, a parameter, an expression.
+const (
+	defaultEqSelectivity    = 0.1
+	defaultRangeSelectivity = 1.0 / 3
+	defaultSelectivity      = 0.5
+)
+
+// EstimateSelectivity estimates the fraction of a table's rows where
+// matches, from the statistics collected for its columns (lower-case column
+// -> stats). Conjuncts are taken as independent. It is 1 for an empty
+// where.
+func EstimateSelectivity(where string, columns map[string]storage.ColumnStats) float64 {
+	toks, err := tokenize(where)
+	if err != nil || len(toks) == 0 {
+		return 1
+	}
+	e := &selectivityEstimator{toks: toks, columns: columns}
+	sel := e.or()
+	return clampFraction(sel)
+}
+
+// whereSelectivity is EstimateSelectivity for the WHERE clause of q's main
+// SELECT when it reads table directly; 1 otherwise.
+func whereSelectivity(q *Query, table string, columns map[string]storage.ColumnStats) float64 {
+	s := q.Main()
+	if s.Where == nil || len(s.From) != 1 || s.From[0].Subquery != nil || !strings.EqualFold(s.From[0].Name, table) {
+		return 1
+	}
+	return EstimateSelectivity(s.Where.Text, columns)
+}
+
+type selectivityEstimator struct {
+	toks    []token
+	pos     int
+	columns map[string]storage.ColumnStats
+}
+
+func (e *selectivityEstimator) peek() token {
+	if e.pos < len(e.toks) {
+		return e.toks[e.pos]
+	}
+	return token{kind: tokEOF}
+}
+
+func (e *selectivityEstimator) next() token {
+	t := e.peek()
+	if e.pos < len(e.toks) {
+		e.pos++
+	}
+	return t
+}
+
+func (e *selectivityEstimator) or() float64 {
+	sel := e.and()
+	for e.peek().isWord("or") {
+		e.next()
+		other := e.and()
+		sel = sel + other - sel*other
+	}
+	return sel
+}
+
+func (e *selectivityEstimator) and() float64 {
+	sel := e.not()
+	for e.peek().isWord("and") {
+		e.next()
+		sel *= e.not()
+	}
+	return sel
+}
+
+func (e *selectivityEstimator) not() float64 {
+	if e.peek().isWord("not") {
+		e.next()
+		return 1 - e.not()
+	}
+	return e.predicate()
+}
+
+// predicate estimates one comparison, or a parenthesized condition. What
+// it can't read it skips to the next AND, OR or closing parenthesis.
+func (e *selectivityEstimator) predicate() float64 {
+	if e.peek().isPunct("(") {
+		e.next()
+		sel := e.or()
+		if e.peek().isPunct(")") {
+			e.next()
+		}
+		return sel
+	}
+
+	start := e.pos
+	col, colOK := e.column()
+	if !colOK {
+		// a literal on the left: flip "5 < amount" around
+		e.pos = start
+		if v, ok := e.number(); ok {
+			if op := e.peek(); op.kind == tokPunct && isRangeOp(op.text) {
+				e.next()
+				if col, ok := e.column(); ok && e.atConditionEnd() {
+					return e.rangeSelectivity(col, flipOp(op.text), v)
+				}
+			}
+		}
+		e.skip()
+		return defaultSelectivity
+	}
+	stats, known := e.columns[strings.ToLower(unqualified(col))]
+
+	t := e.next()
+	negate := false
+	if t.isWord("not") {
+		negate, t = true, e.next()
+	}
+	var sel float64
+	switch {
+	case t.isWord("is"):
+		not := e.peek().isWord("not")
+		if not {
+			e.next()
+		}
+		if !e.next().isWord("null") {
+			e.skip()
+			return defaultSelectivity
+		}
+		sel = defaultEqSelectivity
+		if known {
+			sel = stats.NullFraction
+		}
+		if not {
+			sel = 1 - sel
+		}
+	case t.isWord("isnull") || t.isWord("notnull"):
+		sel = defaultEqSelectivity
+		if known {
+			sel = stats.NullFraction
+		}
+		if t.isWord("notnull") {
+			sel = 1 - sel
+		}
+	case t.isPunct("=") || t.isPunct("=="):
+		sel = e.eqSelectivity(stats, known, 1)
+		e.skip() // the other operand
+	case t.isPunct("<>") || t.isPunct("!="):
+		sel = nonNull(stats, known) - e.eqSelectivity(stats, known, 1)
+		e.skip() // the other operand
+	case t.kind == tokPunct && isRangeOp(t.text):
+		if v, ok := e.number(); ok && e.atConditionEnd() {
+			sel = e.rangeSelectivity(col, t.text, v)
+		} else {
+			e.skip()
+			sel = defaultRangeSelectivity
+		}
+	case t.isWord("between"):
+		lo, loOK := e.number()
+		if !loOK || !e.peek().isWord("and") {
+			loOK = false
+			e.skip()
+		}
+		if !e.next().isWord("and") {
+			return defaultRangeSelectivity
+		}
+		hi, hiOK := e.number()
+		if !hiOK || !e.atConditionEnd() {
+			hiOK = false
+			e.skip()
+		}
+		sel = defaultRangeSelectivity
+		if loOK && hiOK && known && len(stats.Histogram) > 1 {
+			sel = (histogramFraction(stats.Histogram, hi) - histogramFraction(stats.Histogram, lo)) * (1 - stats.NullFraction)
+		}
+	case t.isWord("in"):
+		sel = e.inSelectivity(stats, known)
+	default:
+		e.skip()
+		return defaultSelectivity
+	}
+	if negate {
+		sel = nonNull(stats, known) - sel
+	}
+	return clampFraction(sel)
+}
+
+// column reads a possibly qualified column name.
+func (e *selectivityEstimator) column() (string, bool) {
+	t := e.next()
+	if !t.isIdent() || e.peek().isPunct("(") {
+		return "", false
+	}
+	name := t.name()
+	for e.peek().isPunct(".") {
+		e.next()
+		t = e.next()
+		if !t.isIdent() {
+			return "", false
+		}
+		name += "." + t.name()
+	}
+	return name, true
+}
+
+// number reads a numeric literal, with an optional sign.
+func (e *selectivityEstimator) number() (float64, bool) {
+	neg := false
+	if t := e.peek(); t.isPunct("-") || t.isPunct("+") {
+		neg = t.isPunct("-")
+		e.next()
+	}
+	t := e.peek()
+	if t.kind != tokNumber {
+		return 0, false
+	}
+	e.next()
+	v, err := strconv.ParseFloat(t.text, 64)
+	if err != nil {
+		return 0, false
+	}
+	if neg {
+		v = -v
+	}
+	return v, true
+}
+
+// skip moves past the rest of the current condition: to the next AND, OR
+// or closing parenthesis at this nesting level.
+func (e *selectivityEstimator) skip() {
+	depth := 0
+	for {
+		t := e.peek()
+		switch {
+		case t.kind == tokEOF:
+			return
+		case t.isPunct("("):
+			depth++
+		case t.isPunct(")"):
+			if depth == 0 {
+				return
+			}
+			depth--
+		case depth == 0 && (t.isWord("and") || t.isWord("or")):
+			return
+		}
+		e.next()
+	}
+}
+
+// atConditionEnd reports whether the current condition ends here, so a
+// literal just read is the whole operand rather than the start of an
+// expression.
+func (e *selectivityEstimator) atConditionEnd() bool {
+	t := e.peek()
+	return t.kind == tokEOF || t.isPunct(")") || t.isWord("and") || t.isWord("or")
+}
+
+// eqSelectivity is the share of rows equal to k values of the column.
+func (e *selectivityEstimator) eqSelectivity(stats storage.ColumnStats, known bool, k int) float64 {
+	if !known || stats.Distinct <= 0 {
+		return min(1, defaultEqSelectivity*float64(k))
+	}
+	return min(1, float64(k)/float64(stats.Distinct)) * (1 - stats.NullFraction)
+}
+
+// inSelectivity reads an IN list and estimates it as that many equalities;
+// an IN subquery is a default guess.
+func (e *selectivityEstimator) inSelectivity(stats storage.ColumnStats, known bool) float64 {
+	if !e.peek().isPunct("(") {
+		e.skip()
+		return defaultSelectivity
+	}
+	e.next()
+	if t := e.peek(); t.isWord("select") || t.isWord("with") {
+		e.pos--
+		e.skip()
+		return defaultSelectivity
+	}
+	k, depth := 1, 0
+	for {
+		t := e.next()
+		switch {
+		case t.kind == tokEOF:
+			return e.eqSelectivity(stats, known, k)
+		case t.isPunct("("):
+			depth++
+		case t.isPunct(")"):
+			if depth == 0 {
+				return e.eqSelectivity(stats, known, k)
+			}
+			depth--
+		case t.isPunct(",") && depth == 0:
+			k++
+		}
+	}
+}
+
+// rangeSelectivity is the share of rows where col op v, from col's
+// histogram.
+func (e *selectivityEstimator) rangeSelectivity(col, op string, v float64) float64 {
+	stats, ok := e.columns[strings.ToLower(unqualified(col))]
+	if !ok || len(stats.Histogram) < 2 {
+		return defaultRangeSelectivity
+	}
+	below := histogramFraction(stats.Histogram, v)
+	if op == ">" || op == ">=" {
+		below = 1 - below
+	}
+	return below * (1 - stats.NullFraction)
+}
+
+// histogramFraction is the share of a column's non-null values below v,
+// interpolating linearly inside the bucket v falls in. bounds are the
+// equi-depth histogram's bucket bounds.
+func histogramFraction(bounds []float64, v float64) float64 {
+	buckets := len(bounds) - 1
+	var below float64
+	for i := 0; i < buckets; i++ {
+		lo, hi := bounds[i], bounds[i+1]
+		switch {
+		case v >= hi:
+			below++
+		case v > lo:
+			below += (v - lo) / (hi - lo)
+		}
+	}
+	return below / float64(buckets)
+}
+
+func isRangeOp(op string) bool {
+	return op == "<" || op == "<=" || op == ">" || op == ">="
+}
+
+// flipOp turns "v op col" into "col flipOp(op) v".
+func flipOp(op string) string {
+	switch op {
+	case "<":
+		return ">"
+	case "<=":
+		return ">="
+	case ">":
+		return "<"
+	case ">=":
+		return "<="
+	}
+	return op
+}
+
+// nonNull is the share of rows where the column is not NULL.
+func nonNull(stats storage.ColumnStats, known bool) float64 {
+	if !known {
+		return 1
+	}
+	return 1 - stats.NullFraction
+}
+
+func clampFraction(f float64) float64 {
+	return max(0, min(1, f))
+}
+
+// pinnedValue returns the value where pins column to: the literal of a
+// top-level "column = literal" conjunct. ok is false when there is none,
+// or when where has a top-level OR, which could match other values too.
+func pinnedValue(where, column string) (value string, ok bool) {
+	toks, err := tokenize(where)
+	if err != nil {
+		return "", false
+	}
+	parts, ok := conjuncts(toks)
+	if !ok {
+		return "", false
+	}
+	for _, c := range parts {
+		if v, match := equalsLiteral(c, column); match {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// conjuncts splits a condition into its top-level AND operands, leaving
+// BETWEEN's own AND alone. ok is false when it has a top-level OR.
+func conjuncts(toks []token) (parts [][]token, ok bool) {
+	depth, start := 0, 0
+	between := false
+	for i, t := range toks {
+		switch {
+		case t.isPunct("("):
+			depth++
+		case t.isPunct(")"):
+			depth--
+		case depth == 0 && t.isWord("or"):
+			return nil, false
+		case depth == 0 && t.isWord("between"):
+			between = true
+		case depth == 0 && t.isWord("and") && between:
+			between = false
+		case depth == 0 && t.isWord("and"):
+			parts = append(parts, toks[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, toks[start:]), true
+}
+
+// equalsLiteral matches a conjunct comparing column, possibly qualified,
+// to a string or numeric literal, either way round.
+func equalsLiteral(toks []token, column string) (string, bool) {
+	for _, side := range [][]token{toks, reversedComparison(toks)} {
+		eq := len(side) - 2
+		if eq < 1 || !side[eq].isPunct("=") {
+			continue
+		}
+		lit := side[eq+1]
+		if lit.kind != tokString && lit.kind != tokNumber {
+			continue
+		}
+		col := side[:eq]
+		if len(col) == 3 && col[1].isPunct(".") && col[0].isIdent() {
+			col = col[2:]
+		}
+		if len(col) == 1 && col[0].isIdent() && strings.EqualFold(col[0].name(), column) {
+			if lit.kind == tokString {
+				return lit.name(), true
+			}
+			return lit.text, true
+		}
+	}
+	return "", false
+}
+
+// reversedComparison turns "literal = column" into "column = literal";
+// anything else comes back empty.
+func reversedComparison(toks []token) []token {
+	if len(toks) < 3 || !toks[1].isPunct("=") {
+		return nil
+	}
+	out := append([]token{}, toks[2:]...)
+	return append(out, toks[1], toks[0])
+}
@@ -0,0 +1,385 @@
+package planner
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/sketches"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// SketchJoin is how a sketch join plan answers
+//
+//	SELECT b.k, COUNT(*), SUM(b.x) FROM big b JOIN small s ON b.k = s.k GROUP BY b.k
+//
+// without reading small: each group of big's sample is weighted by the
+// rows of small the Count-Min sketch on Table.Column counts for its key,
+// and the product scaled by the sample's fraction. The plan's SQL reads
+// the sample grouped by key, returning the key, the key as text (as the
+// sketch hashed it), the group's rows, then the sum and the sum of
+// squares of each SUM or TOTAL item's column, in Items order.
+type SketchJoin struct {
+	Table  string `json:"table"`
+	Column string `json:"column"`
+	// Outputs are the result column names in SELECT order; Items, the
+	// same length, say what each is.
+	Outputs []string         `json:"-"`
+	Items   []SketchJoinItem `json:"-"`
+	OrderBy int              `json:"-"` // the item ORDER BY sorts on, or -1
+	Desc    bool             `json:"-"`
+	Limit   int              `json:"-"` // -1 when absent
+}
+
+// SketchJoinItem is one output of a sketch join: the join key when
+// Function is "", else COUNT(*), or SUM or TOTAL of Column of the sampled
+// side.
+type SketchJoinItem struct {
+	Function string
+	Column   string
+}
+
+// sketchJoinSource is what evaluateSketchJoinStrategy reads a sketch join
+// query as: the sampled table and its join key, the sketched table and
+// its join key, and the outputs.
+type sketchJoinSource struct {
+	table, key   string
+	sketched     string
+	sketchedKey  string
+	join         SketchJoin
+	bigQualifier func(string) bool
+}
+
+// parseSketchJoin reports whether q is an inner equi-join of two tables
+// grouped by the join key whose other outputs are COUNT(*) and sums of the
+// first table's columns, optionally ordered by one output and limited.
+// Sums whose column isn't qualified are left for the planner to place.
+func parseSketchJoin(q *Query) (sketchJoinSource, bool) {
+	var src sketchJoinSource
+	if len(q.With) > 0 || len(q.Selects) != 1 {
+		return src, false
+	}
+	s := q.Main()
+	if s.Distinct || len(s.From) != 2 || s.From[0].Subquery != nil || s.From[1].Subquery != nil ||
+		(s.From[1].Join != "JOIN" && s.From[1].Join != "INNER JOIN") || s.From[1].On == nil ||
+		s.Where != nil || len(s.GroupBy) != 1 || s.Having != nil || s.Offset != nil ||
+		len(s.Subqueries) > 0 || len(s.OrderBy) > 1 {
+		return src, false
+	}
+	big, small := s.From[0], s.From[1]
+	names := func(ref TableRef) func(string) bool {
+		return func(qualifier string) bool {
+			return strings.EqualFold(qualifier, ref.Alias) || strings.EqualFold(qualifier, ref.Name)
+		}
+	}
+	isBig, isSmall := names(big), names(small)
+	src.table, src.sketched, src.bigQualifier = big.Name, small.Name, isBig
+	var ok bool
+	if src.key, src.sketchedKey, ok = equiJoinKeys(s); !ok {
+		return src, false
+	}
+	// either side's key names the group, the join makes them equal
+	isKey := func(e Expr) bool {
+		col, ok := bareColumn(e)
+		if !ok {
+			return false
+		}
+		if qualifier, ok := columnQualifier(e.Columns[0]); ok {
+			return isBig(qualifier) && strings.EqualFold(col, src.key) || isSmall(qualifier) && strings.EqualFold(col, src.sketchedKey)
+		}
+		return strings.EqualFold(col, src.key) || strings.EqualFold(col, src.sketchedKey)
+	}
+
+	group := s.GroupBy[0]
+	if n, err := strconv.Atoi(group.Text); err == nil {
+		if n < 1 || n > len(s.Items) {
+			return src, false
+		}
+		group = s.Items[n-1].Expr
+	}
+	if !isKey(group) {
+		return src, false
+	}
+
+	j := SketchJoin{Table: small.Name, Column: src.sketchedKey, OrderBy: -1, Limit: -1}
+	hasKey := false
+	for _, item := range s.Items {
+		name := item.Alias
+		call, isCall := itemCall(s, item.Expr)
+		switch {
+		case isKey(item.Expr):
+			hasKey = true
+			j.Items = append(j.Items, SketchJoinItem{})
+			if name == "" {
+				name = unqualified(item.Expr.Text)
+			}
+		case isCall && isCountStar(call, item.Expr):
+			j.Items = append(j.Items, SketchJoinItem{Function: "COUNT"})
+		case isCall && (call.Name == "SUM" || call.Name == "TOTAL") && !call.Distinct && !call.Filter && len(call.Args) == 1:
+			arg := Expr{Text: call.Args[0], Columns: item.Expr.Columns}
+			col, ok := bareColumn(arg)
+			if !ok {
+				return src, false
+			}
+			if qualifier, ok := columnQualifier(item.Expr.Columns[0]); ok && !isBig(qualifier) {
+				return src, false // only the sampled side's rows are read
+			}
+			j.Items = append(j.Items, SketchJoinItem{Function: call.Name, Column: col})
+		default:
+			return src, false
+		}
+		if name == "" {
+			name = item.Expr.Text
+		}
+		j.Outputs = append(j.Outputs, name)
+	}
+	if !hasKey || len(j.Items) < 2 {
+		return src, false
+	}
+
+	if len(s.OrderBy) == 1 {
+		target, desc := orderDirection(s.OrderBy[0].Text)
+		item := orderTarget(target, s.Items, j.Outputs)
+		if item < 0 {
+			if !isKey(Expr{Text: target, Columns: []string{target}}) {
+				return src, false
+			}
+			for i, it := range j.Items {
+				if it.Function == "" {
+					item = i
+				}
+			}
+		}
+		j.OrderBy, j.Desc = item, desc
+	}
+	if s.Limit != nil {
+		n, err := strconv.Atoi(strings.TrimSpace(s.Limit.Text))
+		if err != nil || n < 0 {
+			return src, false
+		}
+		j.Limit = n
+	}
+	src.join = j
+	return src, true
+}
+
+// equiJoinKeys reads the ON condition joining s's two FROM sources as
+// a.k = b.k, either way round and with both columns qualified, and returns
+// the first source's key and the second's.
+func equiJoinKeys(s *Select) (firstKey, secondKey string, ok bool) {
+	if len(s.From) != 2 || s.From[1].On == nil {
+		return "", "", false
+	}
+	names := func(ref TableRef) func(string) bool {
+		return func(qualifier string) bool {
+			return strings.EqualFold(qualifier, ref.Alias) || strings.EqualFold(qualifier, ref.Name)
+		}
+	}
+	isFirst, isSecond := names(s.From[0]), names(s.From[1])
+	on := s.From[1].On
+	if len(on.Columns) != 2 {
+		return "", "", false
+	}
+	a, b := on.Columns[0], on.Columns[1]
+	if compact := strings.Join(strings.Fields(on.Text), ""); compact != a+"="+b {
+		return "", "", false
+	}
+	for _, c := range []string{a, b} {
+		qualifier, ok := columnQualifier(c)
+		switch {
+		case !ok:
+			return "", "", false
+		case isFirst(qualifier) && firstKey == "":
+			firstKey = unqualified(c)
+		case isSecond(qualifier) && secondKey == "":
+			secondKey = unqualified(c)
+		default:
+			return "", "", false
+		}
+	}
+	return firstKey, secondKey, firstKey != "" && secondKey != ""
+}
+
+// columnQualifier is the table name or alias qualifying a column
+// reference, if any.
+func columnQualifier(col string) (string, bool) {
+	dot := strings.LastIndexByte(col, '.')
+	if dot <= 0 {
+		return "", false
+	}
+	return strings.Trim(col[:dot], "\"`[]"), true
+}
+
+// itemCall is the function call e consists of, if it is just one call.
+func itemCall(s *Select, e Expr) (FuncCall, bool) {
+	for _, c := range s.Calls {
+		if c.start == e.start && c.end == e.end && !c.Window {
+			return c, true
+		}
+	}
+	return FuncCall{}, false
+}
+
+// evaluateSketchJoinStrategy plans q as a sketch join when the table it
+// joins has a Count-Min sketch on the join key whose error can be
+// estimated (see sketchJoinError) and the table it reads from a uniform
+// sample covering the key and the summed columns. smallRows is the joined table's rows, which every other
+// plan reads in full; it is 0 when no sketch join is possible.
+//
+// The sketch never undercounts, so the estimate errs high by at most the
+// sketch's bound per key. When the sketch tracks all its keys, a key of
+// the sample missing from them has no match; otherwise a key small never
+// had can be counted as a match, as with a Bloom filter.
+func (p *Planner) evaluateSketchJoinStrategy(ctx context.Context, db *sql.DB, q *Query, sqlText string, stats *TableStats, tolerance float64) (plan *Plan, smallRows float64) {
+	src, ok := parseSketchJoin(q)
+	if !ok || !p.allows(StrategySketchJoin) || stats.RowCount <= 0 {
+		return nil, 0
+	}
+	sketchErr, ok := sketchJoinError(ctx, db, src.sketched, src.sketchedKey)
+	if !ok {
+		return nil, 0
+	}
+	// unqualified sums must be the sampled side's columns
+	var bigCols, smallCols map[string]bool
+	for _, it := range src.join.Items {
+		if it.Column == "" {
+			continue
+		}
+		if bigCols == nil {
+			bigCols, smallCols = columnSet(ctx, db, src.table), columnSet(ctx, db, src.sketched)
+		}
+		col := strings.ToLower(it.Column)
+		if !bigCols[col] || smallCols[col] && !qualifiedSum(q, it.Column, src.bigQualifier) {
+			return nil, 0
+		}
+	}
+
+	need := []string{src.key}
+	for _, it := range src.join.Items {
+		if it.Column != "" {
+			need = append(need, it.Column)
+		}
+	}
+	rows := float64(stats.RowCount)
+	var best *storage.SampleInfo
+	samples, err := storage.ListSamples(ctx, db, src.table)
+	if err != nil {
+		return nil, 0
+	}
+	var tableCols []string
+	for i := range samples {
+		s := &samples[i]
+		if s.StrataColumn != "" || s.Predicate != "" || s.OutlierTable != "" || s.UniverseColumn != "" || s.Fraction <= 0 || s.Fraction >= 1 ||
+			s.Fraction*rows < minSampleMatches {
+			continue
+		}
+		if len(s.Columns) > 0 && tableCols == nil {
+			tableCols, _ = storage.TableColumns(ctx, db, src.table)
+		}
+		if !SampleCovers(s.Columns, need, false, tableCols) || !p.sampleReady(ctx, db, s.SampleTable) {
+			continue
+		}
+		ok := SampleError(s.Fraction, rows) <= tolerance
+		switch {
+		case best == nil:
+			best = s
+		case ok && (SampleError(best.Fraction, rows) > tolerance || s.Fraction < best.Fraction):
+			best = s
+		case !ok && SampleError(best.Fraction, rows) > tolerance && s.Fraction > best.Fraction:
+			best = s
+		}
+	}
+	if best == nil {
+		return nil, 0
+	}
+	if n, err := storage.EstimateRowCount(ctx, db, src.sketched); err == nil {
+		smallRows = float64(n)
+	}
+
+	key := `"` + src.key + `"`
+	selects := []string{key, fmt.Sprintf("CAST(%s AS TEXT)", key), "COUNT(*)"}
+	for _, it := range src.join.Items {
+		if it.Column != "" {
+			col := `"` + it.Column + `"`
+			selects = append(selects, fmt.Sprintf("%s(%s)", it.Function, col), fmt.Sprintf("SUM(1.0 * %s * %s)", col, col))
+		}
+	}
+	join := src.join
+	sampleErr := SampleError(best.Fraction, rows)
+	return &Plan{
+		Type: PlanSketch,
+		SQL: fmt.Sprintf("SELECT %s FROM %s WHERE %s IS NOT NULL GROUP BY %s",
+			strings.Join(selects, ", "), best.SampleTable, key, key),
+		OriginalSQL:    sqlText,
+		Table:          src.table,
+		SampleTable:    best.SampleTable,
+		SampleFraction: best.Fraction,
+		SketchType:     "countmin",
+		SketchColumn:   src.sketchedKey,
+		SketchJoin:     &join,
+		EstimatedCost: rows*best.Fraction*p.costModel.ScanCostPerRow + p.costModel.SampleSetupCost +
+			p.costModel.SketchQueryCost,
+		EstimatedError: math.Sqrt(sampleErr*sampleErr + sketchErr*sketchErr),
+		Reason: fmt.Sprintf("sketch join: %.1f%% sample of %s probing the Count-Min sketch on %s.%s instead of reading %s",
+			best.Fraction*100, src.table, src.sketched, src.sketchedKey, src.sketched),
+	}, smallRows
+}
+
+// sketchJoinError is the relative error of the counts the Count-Min
+// sketch on table.column gives a key: its bound against the mean count of
+// a key, from the keys it tracks or the column's analyzed distinct count.
+// ok is false when there is no such sketch, it is degraded, or neither
+// says how many keys share its counters.
+func sketchJoinError(ctx context.Context, db *sql.DB, table, column string) (float64, bool) {
+	var data []byte
+	err := db.QueryRowContext(ctx, `SELECT sketch_data FROM aqe_sketches
+		WHERE table_name = ? AND column_name = ? AND sketch_type = 'countmin' AND COALESCE(degraded, 0) = 0`,
+		table, column).Scan(&data)
+	if err != nil {
+		return 0, false
+	}
+	cms, err := sketches.DeserializeCountMinSketch(data)
+	if err != nil || cms.TotalCount() == 0 {
+		return 0, false
+	}
+	var distinct int64
+	if keys, complete := cms.Keys(); complete {
+		distinct = int64(len(keys))
+	} else if stats, err := storage.GetColumnStats(ctx, db, table); err == nil {
+		distinct = storage.ColumnDistinct(stats)[strings.ToLower(column)]
+	}
+	if distinct <= 0 {
+		return 0, false
+	}
+	return float64(cms.ErrorBound()) * float64(distinct) / float64(cms.TotalCount()), true
+}
+
+// columnSet is table's columns, lower-cased.
+func columnSet(ctx context.Context, db *sql.DB, table string) map[string]bool {
+	cols, _ := storage.TableColumns(ctx, db, table)
+	out := make(map[string]bool, len(cols))
+	for _, c := range cols {
+		out[strings.ToLower(c)] = true
+	}
+	return out
+}
+
+// qualifiedSum reports whether every reference to column in q's main
+// SELECT is qualified by the sampled side, so a name both tables have is
+// not ambiguous.
+func qualifiedSum(q *Query, column string, isBig func(string) bool) bool {
+	for _, item := range q.Main().Items {
+		for _, c := range item.Expr.Columns {
+			if !strings.EqualFold(unqualified(c), column) {
+				continue
+			}
+			if qualifier, ok := columnQualifier(c); !ok || !isBig(qualifier) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,73 @@
+package planner
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/aqeerr"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// TableStatistics are the row and distinct counts a StatsProvider knows
+// for a table.
+type TableStatistics struct {
+	RowCount int64
+	// Distinct maps lower-case columns to their distinct counts; columns
+	// without one are absent.
+	Distinct map[string]int64
+	// Columns maps lower-case columns to the statistics collected for them,
+	// histograms included, for selectivity estimates.
+	Columns map[string]storage.ColumnStats
+}
+
+// StatsProvider supplies the statistics the planner costs a table's plans
+// with. It must not scan the table: it is called for every query.
+type StatsProvider interface {
+	TableStatistics(ctx context.Context, db *sql.DB, table string) (*TableStatistics, error)
+}
+
+// SetStatsProvider replaces the default CatalogStats.
+func (p *Planner) SetStatsProvider(sp StatsProvider) {
+	p.statsProvider = sp
+}
+
+// CatalogStats is the default StatsProvider. It reads what POST
+// /tables/{name}/analyze collected, then the database's own ANALYZE
+// statistics for columns that left out, and takes the table's tracked row
+// count (see storage.TrackRowCount), else the one AQE recorded, else
+// ANALYZE's, else the dialect's cheap estimate.
+type CatalogStats struct{}
+
+func (CatalogStats) TableStatistics(ctx context.Context, db *sql.DB, table string) (*TableStatistics, error) {
+	stats := &TableStatistics{Distinct: make(map[string]int64), Columns: make(map[string]storage.ColumnStats)}
+
+	analyzed, _ := storage.ReadAnalyzedStats(ctx, db, table)
+	if analyzed != nil {
+		for column, n := range analyzed.Distinct {
+			stats.Distinct[column] = n
+		}
+	}
+	if collected, err := storage.GetColumnStats(ctx, db, table); err == nil {
+		for _, c := range collected {
+			stats.Distinct[strings.ToLower(c.Column)] = c.Distinct
+			stats.Columns[strings.ToLower(c.Column)] = c
+		}
+	}
+
+	if n, ok, err := storage.ExactRowCount(ctx, db, table); err == nil && ok {
+		stats.RowCount = n
+		return stats, nil
+	}
+	err := db.QueryRowContext(ctx, "SELECT row_count FROM aqe_table_stats WHERE table_name = ?", table).Scan(&stats.RowCount)
+	if err != nil && analyzed != nil && analyzed.Rows > 0 {
+		stats.RowCount, err = analyzed.Rows, nil
+	}
+	if err != nil {
+		if stats.RowCount, err = storage.EstimateRowCount(ctx, db, table); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", aqeerr.ErrStaleStats, table, err)
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,101 @@
+package planner
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// The strategies the planner can be told not to use. Exact execution is
+// always available.
+const (
+	// StrategySample reads a uniform, stratified, filtered or outlier
+	// sample of the table.
+	StrategySample = "sample"
+	// StrategySketch answers DISTINCT, heavy-hitter, percentile and
+	// too-many-groups queries from a sketch.
+	StrategySketch = "sketch"
+	// StrategyInlineSketch answers scalar subqueries from sketches in an
+	// otherwise exact query.
+	StrategyInlineSketch = "inline_sketch"
+	StrategySketchJoin   = "sketch_join"
+	StrategyUniverseJoin = "universe_join"
+	StrategyJoinSynopsis = "join_synopsis"
+	StrategyWanderJoin   = "wander_join"
+	// StrategyBloomJoin pre-filters exact joins through a Bloom filter.
+	StrategyBloomJoin = "bloom_join"
+	// StrategyTrackedCount answers COUNT(*) from a tracked row count.
+	StrategyTrackedCount = "tracked_count"
+)
+
+// Strategies lists the strategies in the order the planner considers them.
+var Strategies = []string{
+	StrategyTrackedCount, StrategyInlineSketch, StrategySketch, StrategySample, StrategySketchJoin,
+	StrategyUniverseJoin, StrategyJoinSynopsis, StrategyWanderJoin, StrategyBloomJoin,
+}
+
+// KnownStrategy reports whether name is one of Strategies.
+func KnownStrategy(name string) bool {
+	for _, s := range Strategies {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SetDisabledStrategies turns strategies off unless a strategy flag in the
+// database (see storage.StrategyFlag) turns them back on.
+func (p *Planner) SetDisabledStrategies(names []string) {
+	p.disabled = make(map[string]bool, len(names))
+	for _, name := range names {
+		p.disabled[name] = true
+	}
+}
+
+// allows reports whether strategy may plan the statement being planned.
+func (p *Planner) allows(strategy string) bool {
+	_, off := p.off[strategy]
+	return !off
+}
+
+// strategiesOff returns the strategies that may not plan sqlText, and why:
+// those SetDisabledStrategies turned off, overridden by the strategy
+// flags, which are read for every statement so a change applies to the
+// next one. A strategy rolled out to a share of queries plans those whose
+// RolloutBucket falls below it.
+func (p *Planner) strategiesOff(ctx context.Context, db *sql.DB, sqlText string) map[string]string {
+	off := make(map[string]string)
+	for name := range p.disabled {
+		off[name] = "disabled by configuration"
+	}
+	flags, err := storage.ListStrategyFlags(ctx, db)
+	if err != nil {
+		return off // no flags table yet
+	}
+	bucket := RolloutBucket(sqlText)
+	for _, f := range flags {
+		switch {
+		case !f.Enabled:
+			off[f.Strategy] = "disabled"
+		case bucket >= f.RolloutPercent:
+			off[f.Strategy] = fmt.Sprintf("rolled out to %g%% of queries", f.RolloutPercent)
+		default:
+			delete(off, f.Strategy)
+		}
+	}
+	return off
+}
+
+// RolloutBucket places sqlText in [0, 100) by a hash of its text with
+// whitespace collapsed, so a partial rollout always plans the same query
+// the same way.
+func RolloutBucket(sqlText string) float64 {
+	h := fnv.New32a()
+	h.Write([]byte(strings.Join(strings.Fields(sqlText), " ")))
+	return float64(h.Sum32()%10000) / 100
+}
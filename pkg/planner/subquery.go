@@ -0,0 +1,310 @@
+package planner
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// SubqueryKind is how a subquery is used by its enclosing query.
+type SubqueryKind string
+
+const (
+	SubqueryExists SubqueryKind = "exists"
+	SubqueryIn     SubqueryKind = "in"
+	SubqueryScalar SubqueryKind = "scalar"
+)
+
+// Subquery describes one top-level parenthesized SELECT inside a query.
+type Subquery struct {
+	Kind       SubqueryKind `json:"kind"`
+	Negated    bool         `json:"negated,omitempty"`
+	SQL        string       `json:"sql"`
+	Tables     []string     `json:"tables"`
+	Correlated bool         `json:"correlated"`
+	OuterRefs  []string     `json:"outer_refs,omitempty"`
+
+	start, end int // span of the subquery including its predicate keyword
+	bodyStart  int // offset of the SELECT inside the parentheses
+}
+
+var (
+	scopeTableRe   = regexp.MustCompile(`(?i)\b(?:from|join)\s+([a-zA-Z_][a-zA-Z0-9_]*)(?:\s+(?:as\s+)?([a-zA-Z_][a-zA-Z0-9_]*))?`)
+	qualifiedRefRe = regexp.MustCompile(`\b([a-zA-Z_][a-zA-Z0-9_]*)\.([a-zA-Z_][a-zA-Z0-9_]*)\b`)
+	subqueryBodyRe = regexp.MustCompile(`(?is)^\s*select\s+.+?\s+from\s+([a-zA-Z_][a-zA-Z0-9_]*)(?:\s+(?:as\s+)?([a-zA-Z_][a-zA-Z0-9_]*))?\s+where\s+(.+?)\s*$`)
+	topLevelAndRe  = regexp.MustCompile(`(?i)\s+and\s+`)
+	equiPredRe     = regexp.MustCompile(`^\s*([a-zA-Z_][a-zA-Z0-9_.]*)\s*=\s*([a-zA-Z_][a-zA-Z0-9_.]*)\s*$`)
+	betweenRe      = regexp.MustCompile(`(?i)\bbetween\b`)
+	innerClauseRe  = regexp.MustCompile(`(?i)\b(group\s+by|having|limit|order\s+by|union|intersect|except)\b`)
+)
+
+// keywords that can follow a table name and must not be read as its alias
+var notAlias = map[string]bool{
+	"where": true, "join": true, "inner": true, "left": true, "right": true, "full": true,
+	"cross": true, "natural": true, "outer": true, "on": true, "using": true, "group": true,
+	"order": true, "limit": true, "having": true, "union": true, "intersect": true, "except": true,
+}
+
+// DetectSubqueries finds the top-level subqueries in sqlText and marks those
+// that reference a table or alias of the outer query. Only qualified
+// references (alias.column) are detected; unqualified outer columns need a
+// schema to resolve and are not.
+func DetectSubqueries(sqlText string) []Subquery {
+	masked := maskLiterals(sqlText)
+
+	var subs []Subquery
+	for i := 0; i < len(masked); i++ {
+		if masked[i] != '(' {
+			continue
+		}
+		j := i + 1
+		for j < len(masked) && isSpace(masked[j]) {
+			j++
+		}
+		if !hasKeywordAt(masked, j, "select") {
+			continue
+		}
+		end := matchParen(masked, i)
+		if end < 0 {
+			break
+		}
+		sq := Subquery{Kind: SubqueryScalar, SQL: strings.TrimSpace(sqlText[i+1 : end]), start: i, end: end + 1, bodyStart: i + 1}
+		word, wordStart := prevWord(masked, i)
+		switch word {
+		case "exists":
+			sq.Kind, sq.start = SubqueryExists, wordStart
+		case "in":
+			sq.Kind = SubqueryIn
+		}
+		if neg, negStart := prevWord(masked, wordStart); sq.Kind != SubqueryScalar && neg == "not" {
+			sq.Negated = true
+			if sq.Kind == SubqueryExists {
+				sq.start = negStart
+			}
+		}
+		subs = append(subs, sq)
+		i = end
+	}
+	if len(subs) == 0 {
+		return nil
+	}
+
+	// outer scope is everything outside the subqueries
+	var outer strings.Builder
+	prev := 0
+	for _, sq := range subs {
+		outer.WriteString(masked[prev:sq.bodyStart])
+		prev = sq.end - 1
+	}
+	outer.WriteString(masked[prev:])
+	outerNames, _ := scopeNames(outer.String())
+
+	for k := range subs {
+		body := maskLiterals(subs[k].SQL)
+		innerNames, tables := scopeNames(body)
+		subs[k].Tables = tables
+		seen := map[string]bool{}
+		for _, m := range qualifiedRefRe.FindAllStringSubmatch(body, -1) {
+			q := strings.ToLower(m[1])
+			if outerNames[q] && !innerNames[q] && !seen[m[0]] {
+				seen[m[0]] = true
+				subs[k].Correlated = true
+				subs[k].OuterRefs = append(subs[k].OuterRefs, m[0])
+			}
+		}
+	}
+	return subs
+}
+
+func hasCorrelated(subs []Subquery) bool {
+	for _, sq := range subs {
+		if sq.Correlated {
+			return true
+		}
+	}
+	return false
+}
+
+// Decorrelate rewrites correlated EXISTS subqueries of the form
+//
+//	EXISTS (SELECT ... FROM t x WHERE x.k = outer.k [AND <uncorrelated>])
+//
+// into the equivalent semi-join outer.k IN (SELECT x.k FROM t x WHERE ...),
+// which no longer depends on the outer row. NOT EXISTS is left alone since
+// NOT IN differs when the key is NULL. It returns the rewritten SQL, a note
+// per rewrite, and whether any correlated subquery remains.
+func Decorrelate(sqlText string) (string, []string, bool) {
+	subs := DetectSubqueries(sqlText)
+	var notes []string
+	remaining := false
+	out := sqlText
+
+	// rewrite right to left so earlier offsets stay valid
+	for k := len(subs) - 1; k >= 0; k-- {
+		sq := subs[k]
+		if !sq.Correlated {
+			continue
+		}
+		repl, ok := semiJoinRewrite(sq)
+		if !ok {
+			remaining = true
+			continue
+		}
+		out = out[:sq.start] + repl + out[sq.end:]
+		notes = append(notes, fmt.Sprintf("rewrote correlated EXISTS on %s as IN semi-join", strings.Join(sq.Tables, ", ")))
+	}
+	return out, notes, remaining
+}
+
+func semiJoinRewrite(sq Subquery) (string, bool) {
+	if sq.Kind != SubqueryExists || sq.Negated || len(sq.OuterRefs) != 1 {
+		return "", false
+	}
+	m := subqueryBodyRe.FindStringSubmatch(sq.SQL)
+	if m == nil || innerClauseRe.MatchString(maskLiterals(m[3])) || strings.Contains(strings.ToLower(m[3]), "select") {
+		return "", false
+	}
+	table, alias, where := m[1], m[2], m[3]
+	if notAlias[strings.ToLower(alias)] {
+		alias = ""
+	}
+
+	outerRef := sq.OuterRefs[0]
+	var innerRef string
+	var rest []string
+	for _, conj := range splitConjuncts(where) {
+		if !strings.Contains(conj, outerRef) {
+			rest = append(rest, conj)
+			continue
+		}
+		eq := equiPredRe.FindStringSubmatch(conj)
+		if eq == nil || innerRef != "" {
+			return "", false
+		}
+		switch outerRef {
+		case eq[1]:
+			innerRef = eq[2]
+		case eq[2]:
+			innerRef = eq[1]
+		default:
+			return "", false
+		}
+	}
+	if innerRef == "" {
+		return "", false
+	}
+
+	from := table
+	if alias != "" {
+		from += " " + alias
+	}
+	q := fmt.Sprintf("%s IN (SELECT %s FROM %s", outerRef, innerRef, from)
+	if len(rest) > 0 {
+		q += " WHERE " + strings.Join(rest, " AND ")
+	}
+	return q + ")", true
+}
+
+// splitConjuncts splits a WHERE clause on top-level ANDs. Clauses with a
+// top-level OR are returned whole so the outer reference stays inside.
+func splitConjuncts(where string) []string {
+	masked := maskLiterals(where)
+	var parts []string
+	prev, scanned := 0, 0
+	for _, loc := range topLevelAndRe.FindAllStringIndex(masked, -1) {
+		// the AND of "x BETWEEN a AND b" does not separate conjuncts
+		between := betweenRe.MatchString(masked[scanned:loc[0]])
+		scanned = loc[1]
+		if between || depthAt(masked, loc[0]) != 0 {
+			continue
+		}
+		parts = append(parts, strings.TrimSpace(where[prev:loc[0]]))
+		prev = loc[1]
+	}
+	return append(parts, strings.TrimSpace(where[prev:]))
+}
+
+// scopeNames returns the lower-cased names (alias, or table if unaliased)
+// introduced by FROM/JOIN in s, plus the table names in order.
+func scopeNames(s string) (map[string]bool, []string) {
+	names := map[string]bool{}
+	var tables []string
+	for _, m := range scopeTableRe.FindAllStringSubmatch(s, -1) {
+		t := strings.ToLower(m[1])
+		if t == "select" {
+			continue
+		}
+		tables = append(tables, m[1])
+		// an aliased table is only visible by its alias
+		if a := strings.ToLower(m[2]); a != "" && !notAlias[a] {
+			names[a] = true
+		} else {
+			names[t] = true
+		}
+	}
+	return names, tables
+}
+
+// maskLiterals blanks the contents of string literals, keeping offsets.
+func maskLiterals(s string) string {
+	return stringLitRe.ReplaceAllStringFunc(s, func(lit string) string {
+		return "'" + strings.Repeat("_", len(lit)-2) + "'"
+	})
+}
+
+func matchParen(s string, open int) int {
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func depthAt(s string, pos int) int {
+	depth := 0
+	for i := 0; i < pos; i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+	}
+	return depth
+}
+
+// prevWord returns the lower-cased word ending just before pos and its start.
+func prevWord(s string, pos int) (string, int) {
+	i := pos - 1
+	for i >= 0 && isSpace(s[i]) {
+		i--
+	}
+	end := i + 1
+	for i >= 0 && isIdentByte(s[i]) {
+		i--
+	}
+	return strings.ToLower(s[i+1 : end]), i + 1
+}
+
+func hasKeywordAt(s string, pos int, kw string) bool {
+	if pos+len(kw) > len(s) || !strings.EqualFold(s[pos:pos+len(kw)], kw) {
+		return false
+	}
+	return pos+len(kw) == len(s) || !isIdentByte(s[pos+len(kw)])
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
+
+func isIdentByte(b byte) bool {
+	return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
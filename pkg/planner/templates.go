@@ -0,0 +1,203 @@
+package planner
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TemplateParam is a typed parameter of a saved query template, written
+// :name in its SQL.
+type TemplateParam struct {
+	Name string `json:"name"`
+	// Type is string, integer, number, boolean or date (YYYY-MM-DD).
+	Type string `json:"type"`
+	// Default is used when a run doesn't give the parameter; without one
+	// the parameter is required.
+	Default json.RawMessage `json:"default,omitempty"`
+}
+
+var templateParamTypes = map[string]bool{
+	"string": true, "integer": true, "number": true, "boolean": true, "date": true,
+}
+
+var templateParamNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// CheckTemplate validates a template: sqlText must be a SELECT whose only
+// parameters are :name ones, each declared once in params with a known
+// type and a valid default, and every declared parameter must be used.
+// Problems are aqeerr.ErrUnsupportedQuery errors.
+func CheckTemplate(sqlText string, params []TemplateParam) error {
+	if !isSelect(sqlText) {
+		return unsupported("a template must be a SELECT statement")
+	}
+	declared := make(map[string]TemplateParam, len(params))
+	for _, p := range params {
+		if !templateParamNameRe.MatchString(p.Name) {
+			return unsupported("template parameter name %q is not an identifier", p.Name)
+		}
+		if _, dup := declared[p.Name]; dup {
+			return unsupported("template parameter %s declared twice", p.Name)
+		}
+		if !templateParamTypes[p.Type] {
+			return unsupported("template parameter %s: type must be string, integer, number, boolean or date, got %q", p.Name, p.Type)
+		}
+		if len(p.Default) > 0 {
+			if _, err := templateLiteral(p, p.Default); err != nil {
+				return unsupported("template parameter %s: default: %v", p.Name, err)
+			}
+		}
+		declared[p.Name] = p
+	}
+
+	used, err := templateParams(sqlText)
+	if err != nil {
+		return err
+	}
+	for _, t := range used {
+		if _, ok := declared[t.text[1:]]; !ok {
+			return unsupported("template parameter %s is not declared", t.text)
+		}
+		delete(declared, t.text[1:])
+	}
+	for name := range declared {
+		return unsupported("template parameter %s is declared but not used", name)
+	}
+	return nil
+}
+
+// RenderTemplate substitutes values, raw JSON by parameter name, for the
+// parameters of a template CheckTemplate accepted, falling back to their
+// defaults. Values are written as SQL literals of the parameter's type,
+// never spliced in as text. Missing, unknown and mistyped values are
+// aqeerr.ErrUnsupportedQuery errors.
+func RenderTemplate(sqlText string, params []TemplateParam, values map[string]json.RawMessage) (string, error) {
+	byName := make(map[string]TemplateParam, len(params))
+	for _, p := range params {
+		byName[p.Name] = p
+	}
+	for name := range values {
+		if _, ok := byName[name]; !ok {
+			return "", unsupported("unknown template parameter %s", name)
+		}
+	}
+
+	used, err := templateParams(sqlText)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	last := 0
+	for _, t := range used {
+		p := byName[t.text[1:]]
+		raw, ok := values[p.Name]
+		if !ok {
+			raw = p.Default
+		}
+		if len(raw) == 0 {
+			return "", unsupported("template parameter %s is required", p.Name)
+		}
+		lit, err := templateLiteral(p, raw)
+		if err != nil {
+			return "", unsupported("template parameter %s: %v", p.Name, err)
+		}
+		b.WriteString(sqlText[last:t.start])
+		b.WriteString(lit)
+		last = t.end
+	}
+	b.WriteString(sqlText[last:])
+	return b.String(), nil
+}
+
+// TemplatePattern is sqlText with each parameter replaced by ?, the shape
+// every run of the template shares.
+func TemplatePattern(sqlText string) string {
+	used, err := templateParams(sqlText)
+	if err != nil {
+		return sqlText
+	}
+	var b strings.Builder
+	last := 0
+	for _, t := range used {
+		b.WriteString(sqlText[last:t.start])
+		b.WriteString("?")
+		last = t.end
+	}
+	b.WriteString(sqlText[last:])
+	return b.String()
+}
+
+// templateParams returns the parameter tokens of sqlText, which must all
+// be :name ones.
+func templateParams(sqlText string) ([]token, error) {
+	toks, err := tokenize(sqlText)
+	if err != nil {
+		return nil, err
+	}
+	var params []token
+	for _, t := range toks {
+		if t.kind != tokParam {
+			continue
+		}
+		if t.text[0] != ':' || !templateParamNameRe.MatchString(t.text[1:]) {
+			return nil, unsupported("template parameters are written :name, got %q", t.text)
+		}
+		params = append(params, t)
+	}
+	return params, nil
+}
+
+// templateLiteral writes raw, a JSON value, as a SQL literal of p's type.
+func templateLiteral(p TemplateParam, raw json.RawMessage) (string, error) {
+	if string(raw) == "null" {
+		return "", fmt.Errorf("null is not a %s", p.Type)
+	}
+	switch p.Type {
+	case "string", "date":
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return "", fmt.Errorf("expected a string, got %s", raw)
+		}
+		if p.Type == "date" {
+			if _, err := time.Parse(time.DateOnly, s); err != nil {
+				return "", fmt.Errorf("expected a date as YYYY-MM-DD, got %q", s)
+			}
+		}
+		return "'" + strings.ReplaceAll(s, "'", "''") + "'", nil
+	case "integer":
+		n, err := strconv.ParseInt(string(raw), 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("expected an integer, got %s", raw)
+		}
+		return signed(strconv.FormatInt(n, 10)), nil
+	case "number":
+		f, err := strconv.ParseFloat(string(raw), 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return "", fmt.Errorf("expected a number, got %s", raw)
+		}
+		return signed(strconv.FormatFloat(f, 'g', -1, 64)), nil
+	case "boolean":
+		var v bool
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return "", fmt.Errorf("expected true or false, got %s", raw)
+		}
+		if v {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	}
+	return "", fmt.Errorf("unknown type %q", p.Type)
+}
+
+// signed parenthesizes a negative number, so it can't run into a minus
+// before it as a -- comment.
+func signed(num string) string {
+	if strings.HasPrefix(num, "-") {
+		return "(" + num + ")"
+	}
+	return num
+}
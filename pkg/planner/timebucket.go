@@ -0,0 +1,150 @@
+package planner
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// TimeBucket is a GROUP BY key recognized as a calendar bucketing of a
+// timestamp column, normalized to a granularity.
+type TimeBucket struct {
+	Expr        string `json:"expr"`
+	Column      string `json:"column"`
+	Granularity string `json:"granularity"` // year, month, week, day, hour, minute
+}
+
+var (
+	dateFuncRe     = regexp.MustCompile(`(?is)^date\s*\(\s*([a-zA-Z0-9_."]+)\s*(?:,\s*'([^']*)')?\s*\)$`)
+	datetimeFuncRe = regexp.MustCompile(`(?is)^datetime\s*\(\s*([a-zA-Z0-9_."]+)\s*,\s*'start of (day|month|year)'\s*\)$`)
+	strftimeRe     = regexp.MustCompile(`(?is)^strftime\s*\(\s*'([^']*)'\s*,\s*([a-zA-Z0-9_."]+)\s*\)$`)
+	substrDateRe   = regexp.MustCompile(`(?is)^substr(?:ing)?\s*\(\s*([a-zA-Z0-9_."]+)\s*,\s*1\s*,\s*(4|7|10|13|16)\s*\)$`)
+)
+
+// strftime formats mapped to the bucket they produce
+var strftimeGranularity = map[string]string{
+	"%Y":             "year",
+	"%Y-%m":          "month",
+	"%Y-%W":          "week",
+	"%Y-%m-%d":       "day",
+	"%Y-%m-%d %H":    "hour",
+	"%Y-%m-%d %H:00": "hour",
+	"%Y-%m-%d %H:%M": "minute",
+}
+
+var substrGranularity = map[string]string{
+	"4": "year", "7": "month", "10": "day", "13": "hour", "16": "minute",
+}
+
+// ParseTimeBucket recognizes SQLite time-bucketing expressions such as
+// date(ts), strftime('%Y-%m', ts), substr(ts, 1, 10) and
+// datetime(ts, 'start of month').
+func ParseTimeBucket(expr string) (TimeBucket, bool) {
+	e := strings.TrimSpace(expr)
+	tb := TimeBucket{Expr: e}
+
+	if m := dateFuncRe.FindStringSubmatch(e); m != nil {
+		tb.Column = m[1]
+		switch strings.ToLower(m[2]) {
+		case "":
+			tb.Granularity = "day"
+		case "start of month":
+			tb.Granularity = "month"
+		case "start of year":
+			tb.Granularity = "year"
+		default:
+			return TimeBucket{}, false
+		}
+		return tb, true
+	}
+	if m := datetimeFuncRe.FindStringSubmatch(e); m != nil {
+		tb.Column, tb.Granularity = m[1], strings.ToLower(m[2])
+		return tb, true
+	}
+	if m := strftimeRe.FindStringSubmatch(e); m != nil {
+		g, ok := strftimeGranularity[m[1]]
+		if !ok {
+			return TimeBucket{}, false
+		}
+		tb.Column, tb.Granularity = m[2], g
+		return tb, true
+	}
+	if m := substrDateRe.FindStringSubmatch(e); m != nil {
+		tb.Column, tb.Granularity = m[1], substrGranularity[m[2]]
+		return tb, true
+	}
+	return TimeBucket{}, false
+}
+
+// DetectTimeBuckets returns the time-bucket keys among groupBy.
+func DetectTimeBuckets(groupBy []string) []TimeBucket {
+	var out []TimeBucket
+	for _, g := range groupBy {
+		if tb, ok := ParseTimeBucket(g); ok {
+			out = append(out, tb)
+		}
+	}
+	return out
+}
+
+// declaredTimeBuckets keeps the buckets over columns roles declares as
+// timestamps. Tables without declared roles keep every bucket the
+// expressions suggest.
+func declaredTimeBuckets(buckets []TimeBucket, roles *storage.TableRoles) []TimeBucket {
+	if roles == nil {
+		return buckets
+	}
+	var out []TimeBucket
+	for _, tb := range buckets {
+		if roles.Role(unqualified(tb.Column)) == storage.RoleTime {
+			out = append(out, tb)
+		}
+	}
+	return out
+}
+
+// SplitTopLevel splits a SQL list on commas outside parentheses and quotes.
+func SplitTopLevel(s string) []string {
+	var parts []string
+	depth := 0
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(parts, strings.TrimSpace(s[start:]))
+}
+
+// BucketRowsColumn is the per-group sample row count that sample plans over
+// time buckets add to the SELECT list. The executor derives per-bucket
+// confidence intervals from it and drops it from the result.
+const BucketRowsColumn = "__bucket_rows"
+
+// withBucketRowCount appends COUNT(*) AS BucketRowsColumn to the outer
+// SELECT list of a sample query. The GROUP BY keys are left untouched so the
+// sampled buckets line up one-to-one with those of the original query.
+func withBucketRowCount(sqlText string) string {
+	q, err := Parse(sqlText)
+	if err != nil {
+		return sqlText
+	}
+	items := q.Main().Items
+	end := items[len(items)-1].end
+	return sqlText[:end] + ", COUNT(*) AS " + BucketRowsColumn + sqlText[end:]
+}
@@ -0,0 +1,176 @@
+package planner
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// evaluateUniverseJoinStrategy plans an inner equi-join of two tables on
+// universe samples of both, hashed on their join keys at the same
+// fraction (see sampler.CreateUniverseSample). Both keep the same keys, so
+// the join of the samples holds every joined row of a fraction of the
+// keys and scales like a sample of the join. Its error is that of a
+// sample of the left side's distinct keys rather than its rows, as joined
+// rows are kept or dropped a key at a time. joinedRows is the joined
+// table's rows, which every other plan reads in full; it is 0 when no
+// universe join is possible.
+func (p *Planner) evaluateUniverseJoinStrategy(ctx context.Context, db *sql.DB, q *Query, sqlText string, features QueryFeatures, stats *TableStats, tolerance float64) (plan *Plan, joinedRows float64) {
+	if !p.allows(StrategyUniverseJoin) || len(q.With) > 0 || len(q.Selects) != 1 || stats.RowCount <= 0 {
+		return nil, 0
+	}
+	s := q.Main()
+	if len(s.From) != 2 || s.From[0].Subquery != nil || s.From[1].Subquery != nil ||
+		(s.From[1].Join != "JOIN" && s.From[1].Join != "INNER JOIN") {
+		return nil, 0
+	}
+	leftKey, rightKey, ok := equiJoinKeys(s)
+	left, right := s.From[0].Name, s.From[1].Name
+	if !ok || strings.EqualFold(left, right) {
+		return nil, 0
+	}
+	leftRefs, ok := q.sampleRefs(left)
+	if !ok || len(leftRefs) != 1 {
+		return nil, 0
+	}
+	rightRefs, ok := q.sampleRefs(right)
+	if !ok || len(rightRefs) != 1 {
+		return nil, 0
+	}
+
+	rows := stats.matchingRows()
+	keys := rows
+	if n := float64(stats.DistinctValueCounts[strings.ToLower(leftKey)]); n > 0 && n < keys {
+		keys = n
+	}
+	rightSamples := p.universeSamples(ctx, db, right, rightKey, rightRefs)
+	var best, bestRight *storage.SampleInfo
+	for _, l := range p.universeSamples(ctx, db, left, leftKey, leftRefs) {
+		var match *storage.SampleInfo
+		for _, r := range rightSamples {
+			if math.Abs(r.Fraction-l.Fraction) <= 1e-9 {
+				match = r
+			}
+		}
+		if match == nil || l.Fraction*keys < minSampleMatches {
+			continue
+		}
+		ok := SampleError(l.Fraction, keys) <= tolerance
+		switch {
+		case best == nil:
+			best, bestRight = l, match
+		case ok && (SampleError(best.Fraction, keys) > tolerance || l.Fraction < best.Fraction):
+			best, bestRight = l, match
+		case !ok && SampleError(best.Fraction, keys) > tolerance && l.Fraction > best.Fraction:
+			best, bestRight = l, match
+		}
+	}
+	if best == nil {
+		return nil, 0
+	}
+	if n, err := storage.EstimateRowCount(ctx, db, right); err == nil {
+		joinedRows = float64(n)
+	}
+
+	samples := map[string]string{strings.ToLower(left): best.SampleTable, strings.ToLower(right): bestRight.SampleTable}
+	rewritten := replaceRefs(sqlText, append(leftRefs, rightRefs...), func(r sampleRef) string {
+		sample := samples[strings.ToLower(r.ref.Name)]
+		if r.ref.Alias == "" && r.owner.qualifies(r.ref.Name) {
+			return sample + " AS " + r.ref.Name
+		}
+		return sample
+	})
+	if len(features.TimeBuckets) > 0 {
+		rewritten = withBucketRowCount(rewritten)
+	} else {
+		rewritten = withGroupMoments(rewritten)
+	}
+	return &Plan{
+		Type:            PlanSample,
+		SQL:             rewritten,
+		OriginalSQL:     sqlText,
+		Table:           left,
+		SampleTable:     best.SampleTable,
+		SampleFraction:  best.Fraction,
+		JoinTable:       right,
+		JoinSampleTable: bestRight.SampleTable,
+		Aggregates:      outputAggregates(s),
+		EstimatedCost:   (float64(stats.RowCount)+joinedRows)*best.Fraction*p.costModel.ScanCostPerRow + p.costModel.SampleSetupCost,
+		EstimatedError:  SampleError(best.Fraction, keys),
+		Reason: fmt.Sprintf("universe join: %.1f%% universe samples of %s and %s hashed on %s and %s keep the same join keys",
+			best.Fraction*100, left, right, leftKey, rightKey),
+	}, joinedRows
+}
+
+// universeSamples returns table's universe samples on key, by their
+// records, that hold the columns refs read and are ready to query.
+func (p *Planner) universeSamples(ctx context.Context, db *sql.DB, table, key string, refs []sampleRef) []*storage.SampleInfo {
+	samples, err := storage.ListSamples(ctx, db, table)
+	if err != nil {
+		return nil
+	}
+	need, all := sampleColumns(refs)
+	var out []*storage.SampleInfo
+	var tableCols []string
+	for i := range samples {
+		s := &samples[i]
+		if !strings.EqualFold(s.UniverseColumn, key) || s.Fraction <= 0 || s.Fraction >= 1 {
+			continue
+		}
+		if len(s.Columns) > 0 && tableCols == nil {
+			tableCols, _ = storage.TableColumns(ctx, db, table)
+		}
+		if SampleCovers(s.Columns, need, all, tableCols) && p.sampleReady(ctx, db, s.SampleTable) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// StageUniverseJoin splits a universe join plan's SQL around its join so
+// the joined samples can be staged once and reused. pairs selects the
+// rowids of each pair of sample rows the join matches, as columns l and r;
+// rewrite returns the plan's SQL reading stagedLeft and stagedRight
+// instead, tables holding the left and right rows of those pairs in pair
+// order, joined on their rowids. ok is false when sqlText is not a join of
+// two tables on an ON condition.
+func StageUniverseJoin(sqlText string) (pairs string, rewrite func(stagedLeft, stagedRight string) string, ok bool) {
+	q, err := Parse(sqlText)
+	if err != nil || len(q.With) > 0 || len(q.Selects) != 1 {
+		return "", nil, false
+	}
+	s := q.Main()
+	if len(s.From) != 2 || s.From[0].Subquery != nil || s.From[1].Subquery != nil || s.From[1].On == nil ||
+		(s.From[1].Join != "JOIN" && s.From[1].Join != "INNER JOIN") {
+		return "", nil, false
+	}
+	left, right, on := s.From[0], s.From[1], s.From[1].On
+	qualifier := func(r TableRef) string {
+		if r.Alias != "" {
+			return r.Alias
+		}
+		return r.Name
+	}
+	ql, qr := qualifier(left), qualifier(right)
+	pairs = fmt.Sprintf("SELECT %s.rowid AS l, %s.rowid AS r FROM %s AS %s JOIN %s AS %s ON %s",
+		ql, qr, left.Name, ql, right.Name, qr, on.Text)
+	rewrite = func(stagedLeft, stagedRight string) string {
+		// right to left so earlier offsets stay valid
+		out := sqlText[:on.start] + ql + ".rowid = " + qr + ".rowid" + sqlText[on.end:]
+		out = out[:right.nameStart] + stagedRight + asName(right) + out[right.nameEnd:]
+		return out[:left.nameStart] + stagedLeft + asName(left) + out[left.nameEnd:]
+	}
+	return pairs, rewrite, true
+}
+
+// asName keeps an unaliased reference's name for its replacement.
+func asName(r TableRef) string {
+	if r.Alias != "" {
+		return ""
+	}
+	return " AS " + r.Name
+}
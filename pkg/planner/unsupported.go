@@ -0,0 +1,70 @@
+package planner
+
+import "errors"
+
+// The constructs that keep a query exact, reported in Plan.Unsupported so
+// they can be counted across the workload.
+const (
+	ConstructWindowFunction     = "window_function"
+	ConstructCorrelatedSubquery = "correlated_subquery"
+	ConstructCTE                = "cte"
+	ConstructDerivedTable       = "derived_table"
+	ConstructSubquery           = "subquery"
+	ConstructSetOperation       = "set_operation"
+	ConstructRecursiveCTE       = "recursive_cte"
+	ConstructParenthesizedJoin  = "parenthesized_join"
+	ConstructTableFunction      = "table_valued_function"
+	ConstructUnparsable         = "unparsable"
+)
+
+// constructError is a parse error caused by a construct the parser knows
+// but doesn't support, named by kind.
+type constructError struct {
+	kind string
+	err  error
+}
+
+func (e *constructError) Error() string { return e.err.Error() }
+func (e *constructError) Unwrap() error { return e.err }
+
+// constructf is errorf for a construct of kind.
+func (p *parser) constructf(t token, kind, format string, args ...any) error {
+	return &constructError{kind: kind, err: p.errorf(t, format, args...)}
+}
+
+// parseConstruct names what made Parse fail: a known unsupported construct
+// or, for any other error, ConstructUnparsable.
+func parseConstruct(err error) string {
+	var c *constructError
+	if errors.As(err, &c) {
+		return c.kind
+	}
+	return ConstructUnparsable
+}
+
+// complexityConstructs lists the constructs counted toward c's score, for
+// queries too complex to approximate. Joins, which every strategy
+// handles, are left out.
+func complexityConstructs(c Complexity) []string {
+	var kinds []string
+	if c.WindowFunctions > 0 {
+		kinds = append(kinds, ConstructWindowFunction)
+	}
+	if c.Subqueries > 0 {
+		kinds = append(kinds, ConstructSubquery)
+	}
+	if c.SetOperations > 0 {
+		kinds = append(kinds, ConstructSetOperation)
+	}
+	if c.HasCTE {
+		kinds = append(kinds, ConstructCTE)
+	}
+	return kinds
+}
+
+// sampleBlocker names the construct that keeps a sample from standing in
+// for table in q (see sampleRefs), or "" if none does.
+func (q *Query) sampleBlocker(table string) string {
+	_, blocker := collectSampleRefs(q, nil, table, true, make(map[*TableRef]bool), 0, "")
+	return blocker
+}
@@ -0,0 +1,223 @@
+package planner
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// Walks of a wander join: at least wanderMinWalks are planned, and the
+// executor may run wanderWalkSlack times the planned walks, at most
+// wanderMaxWalks, before giving up on the tolerance. wanderWalkCV is the
+// coefficient of variation planning assumes for one walk's contribution,
+// which the join's fan-out skews well above a plain sample's.
+const (
+	wanderMinWalks  = 1_000
+	wanderMaxWalks  = 200_000
+	wanderWalkSlack = 8
+	wanderWalkCV    = 2.0
+)
+
+// WanderJoin is how a wander join plan answers an aggregate over an inner
+// equi-join without reading either table in full: each walk draws a
+// uniformly random rowid of Start, then one of the rows of Probe matching
+// its StartKey, found through the index on ProbeKey. A walk reaching a
+// joined row that passes the WHERE clause contributes its aggregate
+// arguments times the number of rows it could have picked, scaled by
+// Start's rowid range over the walks, so every estimate is unbiased and
+// its interval comes from the walks' variance. Walks continue in batches
+// until every estimate is within the plan's tolerance or MaxWalks ran;
+// Walks is how many planning expected that to take.
+//
+// The plan's SQL evaluates walks listed in aqe_walks(aqe_walk, aqe_l,
+// aqe_r), Start's and Probe's rowids, which the executor supplies: it
+// returns the walk, each GROUP BY expression, then each item's argument.
+type WanderJoin struct {
+	Start    string `json:"start"`
+	StartKey string `json:"start_key"`
+	Probe    string `json:"probe"`
+	ProbeKey string `json:"probe_key"`
+	Walks    int    `json:"walks"`
+	MaxWalks int    `json:"max_walks"`
+	// Outputs are the result column names in SELECT order; Items, the
+	// same length, say what each is. Groups is the number of GROUP BY
+	// expressions.
+	Outputs []string         `json:"-"`
+	Items   []WanderJoinItem `json:"-"`
+	Groups  int              `json:"-"`
+}
+
+// WanderJoinItem is one output of a wander join: GROUP BY expression Group
+// when Function is "", else COUNT, SUM, TOTAL or AVG of its argument, or
+// COUNT(*) when Star is set.
+type WanderJoinItem struct {
+	Function string
+	Star     bool
+	Group    int
+}
+
+// parseWanderJoin reads q as an inner equi-join of two tables whose
+// outputs are its GROUP BY expressions and COUNT, SUM, TOTAL and AVG
+// aggregates, with no HAVING, ORDER BY or LIMIT. It returns the join's
+// description without tables chosen and the SELECT list to evaluate walks
+// with after the walk.
+func parseWanderJoin(q *Query) (WanderJoin, []string, bool) {
+	var j WanderJoin
+	if len(q.With) > 0 || len(q.Selects) != 1 {
+		return j, nil, false
+	}
+	s := q.Main()
+	if s.Distinct || len(s.From) != 2 || s.From[0].Subquery != nil || s.From[1].Subquery != nil ||
+		(s.From[1].Join != "JOIN" && s.From[1].Join != "INNER JOIN") || s.From[1].On == nil ||
+		s.Having != nil || len(s.OrderBy) > 0 || s.Limit != nil || s.Offset != nil || len(s.Subqueries) > 0 {
+		return j, nil, false
+	}
+	for _, c := range s.Calls {
+		if c.Window {
+			return j, nil, false
+		}
+	}
+
+	norm := func(text string) string { return strings.ToLower(strings.Join(strings.Fields(text), "")) }
+	var selects []string
+	groups := make([]string, len(s.GroupBy))
+	for i, g := range s.GroupBy {
+		text := g.Text
+		if n, err := strconv.Atoi(text); err == nil {
+			if n < 1 || n > len(s.Items) {
+				return j, nil, false
+			}
+			text = s.Items[n-1].Expr.Text
+		}
+		groups[i] = norm(text)
+		selects = append(selects, text)
+	}
+	j.Groups = len(groups)
+
+	aggregates := 0
+	for _, item := range s.Items {
+		group := -1
+		for i, g := range groups {
+			if g == norm(item.Expr.Text) {
+				group = i
+			}
+		}
+		name := item.Alias
+		if col, ok := bareColumn(item.Expr); name == "" && ok {
+			name = col // as SQLite names a column output
+		}
+		if name == "" {
+			name = item.Expr.Text
+		}
+		j.Outputs = append(j.Outputs, name)
+
+		call, isCall := itemCall(s, item.Expr)
+		switch {
+		case group >= 0:
+			j.Items = append(j.Items, WanderJoinItem{Group: group})
+		case isCall && isCountStar(call, item.Expr):
+			j.Items = append(j.Items, WanderJoinItem{Function: "COUNT", Star: true})
+			selects = append(selects, "1")
+			aggregates++
+		case isCall && !call.Distinct && !call.Filter && len(call.Args) == 1 &&
+			(call.Name == "COUNT" || call.Name == "SUM" || call.Name == "TOTAL" || call.Name == "AVG"):
+			j.Items = append(j.Items, WanderJoinItem{Function: call.Name})
+			selects = append(selects, call.Args[0])
+			aggregates++
+		default:
+			return j, nil, false
+		}
+	}
+	return j, selects, aggregates > 0
+}
+
+// evaluateWanderJoinStrategy plans an inner equi-join of two tables that
+// no universe samples or join synopsis serve as a wander join (see
+// WanderJoin), walking from the first table when the second's join key is
+// indexed, else from the second when the first's is. The walks planned
+// are what the assumed per-walk variation needs to reach tolerance in each
+// of the estimated groups, which must be known for a grouped query.
+// joinedRows is the joined table's rows, which every other plan reads in
+// full; it is 0 when no wander join is possible.
+func (p *Planner) evaluateWanderJoinStrategy(ctx context.Context, db *sql.DB, q *Query, sqlText string, features QueryFeatures, stats *TableStats, tolerance float64) (plan *Plan, joinedRows float64) {
+	if !p.allows(StrategyWanderJoin) || !storage.ActiveDialect().HasRowid() || stats.RowCount <= 0 || tolerance <= 0 {
+		return nil, 0
+	}
+	j, selects, ok := parseWanderJoin(q)
+	if !ok {
+		return nil, 0
+	}
+	s := q.Main()
+	firstKey, secondKey, ok := equiJoinKeys(s)
+	first, second := s.From[0], s.From[1]
+	if !ok || strings.EqualFold(first.Name, second.Name) {
+		return nil, 0
+	}
+	groups := 1.0
+	if j.Groups > 0 {
+		if groups, ok = stats.groupCount(features.GroupByColumns); !ok {
+			return nil, 0
+		}
+	}
+
+	n, err := storage.EstimateRowCount(ctx, db, second.Name)
+	if err != nil || n <= 0 {
+		return nil, 0
+	}
+	joinedRows = float64(n)
+	start, probe := first, second
+	j.StartKey, j.ProbeKey = firstKey, secondKey
+	// the share of walks whose start row the WHERE clause keeps
+	share := stats.matchingRows() / float64(stats.RowCount)
+	probeRows := joinedRows
+	if indexed, err := storage.KeyIndexed(ctx, db, second.Name, secondKey); err != nil || !indexed {
+		if indexed, err := storage.KeyIndexed(ctx, db, first.Name, firstKey); err != nil || !indexed {
+			return nil, 0
+		}
+		start, probe = second, first
+		j.StartKey, j.ProbeKey = secondKey, firstKey
+		share, probeRows = 1, float64(stats.RowCount)
+	}
+	j.Start, j.Probe = start.Name, probe.Name
+
+	need := wanderWalkCV * wanderWalkCV * groups / (tolerance * tolerance * share)
+	j.Walks = int(math.Min(math.Max(math.Ceil(need), wanderMinWalks), wanderMaxWalks))
+	j.MaxWalks = min(j.Walks*wanderWalkSlack, wanderMaxWalks)
+
+	ref := func(t TableRef) (from, qualifier string) {
+		if t.Alias != "" {
+			return t.Name + " AS " + t.Alias, t.Alias
+		}
+		return t.Name, t.Name
+	}
+	startFrom, startQ := ref(start)
+	probeFrom, probeQ := ref(probe)
+	walkSQL := fmt.Sprintf("SELECT aqe_walks.aqe_walk, %s FROM aqe_walks JOIN %s ON %s.rowid = aqe_walks.aqe_l JOIN %s ON %s.rowid = aqe_walks.aqe_r",
+		strings.Join(selects, ", "), startFrom, startQ, probeFrom, probeQ)
+	if s.Where != nil {
+		walkSQL += " WHERE " + s.Where.Text
+	}
+
+	// each walk reads its start row, counts and picks its matches through
+	// the index, and reads both rows again to evaluate: about four B-tree
+	// descents
+	walkCost := 4 * math.Log2(probeRows+2) * p.costModel.ScanCostPerRow
+	return &Plan{
+		Type:           PlanSample,
+		SQL:            walkSQL,
+		OriginalSQL:    sqlText,
+		Table:          first.Name,
+		JoinTable:      second.Name,
+		WanderJoin:     &j,
+		Aggregates:     outputAggregates(s),
+		EstimatedCost:  float64(j.Walks)*walkCost + p.costModel.SampleSetupCost,
+		EstimatedError: wanderWalkCV * math.Sqrt(groups/(float64(j.Walks)*share)),
+		Reason: fmt.Sprintf("wander join: about %d random walks from %s probing the index on %s.%s",
+			j.Walks, j.Start, j.Probe, j.ProbeKey),
+	}, joinedRows
+}
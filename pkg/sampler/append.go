@@ -0,0 +1,221 @@
+package sampler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// appendBatch is how many appended rowids one statement lists.
+const appendBatch = 500
+
+// AppendResult reports what a sample took from rows appended to its base
+// table.
+type AppendResult struct {
+	SampleTable string `json:"sample_table"`
+	Added       int64  `json:"added"`
+	// Evicted counts the rows a live sample's reservoir gave up for them.
+	Evicted  int64 `json:"evicted,omitempty"`
+	BaseRows int64 `json:"base_rows"`
+}
+
+// Appendable reports whether AppendRows can keep info's sample current.
+// Outlier samples can't, since new rows may displace their outliers, nor
+// archived ones, whose rows are offline, nor any sample on a database
+// without rowids to find the appended rows by; they go stale and are
+// refreshed as usual.
+func Appendable(info storage.SampleInfo) bool {
+	return storage.ActiveDialect().HasRowid() && info.OutlierTable == "" && !info.Archived
+}
+
+// AppendRows draws the rows rowids, just appended to info.Table in tx,
+// into info's sample as if they had been there when it was drawn: each
+// with the sample's fraction, or its stratum's in a stratified sample, if
+// it matches a filtered sample's predicate, and in a universe sample if
+// its key is kept; a block sample's rows are drawn one by one, each still
+// with its fraction. It records the sample, and its strata, as drawn from
+// the appended rows too, so appending doesn't make it stale. A live sample
+// instead catches up with every row appended since it last did, rowids
+// among them (see CatchUpReservoir).
+func AppendRows(ctx context.Context, tx *sql.Tx, info storage.SampleInfo, rowids []int64) (*AppendResult, error) {
+	if info.ReservoirSize > 0 {
+		return CatchUpReservoir(ctx, tx, info)
+	}
+	res := &AppendResult{SampleTable: info.SampleTable, BaseRows: info.BaseRows + int64(len(rowids))}
+	cols, err := storage.TableColumns(ctx, tx, info.SampleTable)
+	if err != nil {
+		return nil, err
+	}
+
+	var kept []int64
+	switch {
+	case info.StrataColumn != "":
+		kept, err = appendStrata(ctx, tx, info, rowids)
+	case info.UniverseColumn != "":
+		err = eachAppended(ctx, tx, info.Table, quoteColumns([]string{info.UniverseColumn}), "", rowids, func(rowid int64, v any) {
+			if UniverseKeeps(v, info.Fraction) {
+				kept = append(kept, rowid)
+			}
+		})
+	default:
+		err = eachAppended(ctx, tx, info.Table, "", info.Predicate, rowids, func(rowid int64, _ any) {
+			if rand.Float64() < info.Fraction {
+				kept = append(kept, rowid)
+			}
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	list := quoteColumns(cols)
+	for start := 0; start < len(kept); start += appendBatch {
+		batch := kept[start:min(start+appendBatch, len(kept))]
+		ids, marks := rowidArgs(batch)
+		insert := fmt.Sprintf("INSERT INTO %s(%s) SELECT %s FROM %s WHERE rowid IN (%s)", info.SampleTable, list, list, info.Table, marks)
+		if info.BaseRowids {
+			insert = fmt.Sprintf("INSERT INTO %s(rowid, %s) SELECT rowid, %s FROM %s WHERE rowid IN (%s)", info.SampleTable, list, list, info.Table, marks)
+		}
+		r, err := tx.ExecContext(ctx, insert, ids...)
+		if err != nil {
+			return nil, fmt.Errorf("appending to %s: %w", info.SampleTable, err)
+		}
+		n, _ := r.RowsAffected()
+		res.Added += n
+	}
+
+	var strata, predicate, minStratum, universe, block, warning any
+	if info.BlockRows > 0 {
+		block, warning = info.BlockRows, info.BiasWarning
+	}
+	if info.StrataColumn != "" {
+		strata = info.StrataColumn
+	}
+	if info.Predicate != "" {
+		predicate = info.Predicate
+	}
+	if info.MinStratumRows > 0 {
+		minStratum = info.MinStratumRows
+	}
+	if info.UniverseColumn != "" {
+		universe = info.UniverseColumn
+	}
+	baseIDs := 0
+	if info.BaseRowids {
+		baseIDs = 1
+	}
+	if _, err := tx.ExecContext(ctx, `
+        INSERT INTO aqe_samples(table_name, sample_table, sample_fraction, strata_column, base_row_count, base_rowids, sample_columns, sample_predicate, min_stratum_rows, universe_column, block_rows, bias_warning, created_at)
+        VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+		info.Table, info.SampleTable, info.Fraction, strata, res.BaseRows, baseIDs, storage.EncodeSampleColumns(info.Columns), predicate, minStratum, universe,
+		block, warning); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// appendStrata draws the appended rows rowids of a stratified sample, each
+// with its stratum's fraction, or the sample's for a stratum new to it,
+// and records each stratum as drawn from its appended rows too. Rows
+// without a strata value belong to no stratum and are never drawn.
+func appendStrata(ctx context.Context, tx *sql.Tx, info storage.SampleInfo, rowids []int64) ([]int64, error) {
+	byStratum := make(map[string][]int64)
+	var values []string
+	err := eachAppended(ctx, tx, info.Table, quoteColumns([]string{info.StrataColumn}), "", rowids, func(rowid int64, v any) {
+		var value sql.NullString
+		if value.Scan(v) != nil || !value.Valid {
+			return
+		}
+		if _, ok := byStratum[value.String]; !ok {
+			values = append(values, value.String)
+		}
+		byStratum[value.String] = append(byStratum[value.String], rowid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var kept []int64
+	for _, value := range values {
+		st := StrataInfo{StrataKey: info.StrataColumn, StrataValue: value, Fraction: info.Fraction}
+		err := tx.QueryRowContext(ctx, `
+            SELECT strata_key, pop_size, sample_size, fraction, weight, variance FROM aqe_strata_info
+            WHERE sample_table = ? AND strata_value = ? ORDER BY id DESC LIMIT 1`, info.SampleTable, value).
+			Scan(&st.StrataKey, &st.PopSize, &st.SampleSize, &st.Fraction, &st.Weight, &st.Variance)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		added := int64(len(byStratum[value]))
+		if st.PopSize > 0 {
+			// allocation weighs strata by their size
+			st.Weight *= float64(st.PopSize+added) / float64(st.PopSize)
+		} else {
+			st.Weight = float64(added)
+		}
+		st.PopSize += added
+		for _, rowid := range byStratum[value] {
+			if rand.Float64() < st.Fraction {
+				kept = append(kept, rowid)
+				st.SampleSize++
+			}
+		}
+		if _, err := tx.ExecContext(ctx, `
+            INSERT INTO aqe_strata_info(sample_table, strata_key, strata_value, pop_size, sample_size, fraction, weight, variance)
+            VALUES(?, ?, ?, ?, ?, ?, ?, ?)`,
+			info.SampleTable, st.StrataKey, st.StrataValue, st.PopSize, st.SampleSize, st.Fraction, st.Weight, st.Variance); err != nil {
+			return nil, err
+		}
+	}
+	return kept, nil
+}
+
+// eachAppended calls fn with the rowid of each of table's rows rowids that
+// matches filter ("" for all), and its value of column when given.
+func eachAppended(ctx context.Context, tx *sql.Tx, table, column, filter string, rowids []int64, fn func(rowid int64, v any)) error {
+	sel := "rowid"
+	if column != "" {
+		sel += ", " + column
+	}
+	for start := 0; start < len(rowids); start += appendBatch {
+		ids, marks := rowidArgs(rowids[start:min(start+appendBatch, len(rowids))])
+		q := fmt.Sprintf("SELECT %s FROM %s WHERE rowid IN (%s)", sel, table, marks)
+		if filter != "" {
+			q += " AND (" + filter + ")"
+		}
+		rows, err := tx.QueryContext(ctx, q, ids...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var rowid int64
+			var v any
+			dest := []any{&rowid}
+			if column != "" {
+				dest = append(dest, &v)
+			}
+			if err := rows.Scan(dest...); err != nil {
+				rows.Close()
+				return err
+			}
+			fn(rowid, v)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rowidArgs binds rowids to a list of placeholders.
+func rowidArgs(rowids []int64) ([]any, string) {
+	args := make([]any, len(rowids))
+	for i, id := range rowids {
+		args[i] = id
+	}
+	return args, strings.TrimSuffix(strings.Repeat("?, ", len(rowids)), ", ")
+}
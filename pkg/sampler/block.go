@@ -0,0 +1,197 @@
+package sampler
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/aqeerr"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// CreateBlockSample materializes a block sample of fraction of the rows
+// of table matching predicate ("" for every row). Rather than deciding row
+// by row, which reads the whole table, it keeps whole blocks of blockRows
+// consecutive rowids, each with probability fraction, and reads only
+// those, by rowid range; Postgres keeps whole pages instead. Every row is
+// still kept with probability fraction, so estimates stay unbiased, but
+// the rows of a block are kept or skipped together: when values cluster
+// by insertion order, as load dates and batches do, the sample varies more
+// than a uniform one of its size, and intervals computed as if its rows
+// were drawn one by one understate the error. The sample records a
+// warning saying so. Only columns are copied when given (see
+// PruneColumns). When the rowids are too sparse for blocks of blockRows to
+// hold about that many rows, as with ids drawn from clocks or snowflakes,
+// a row-by-row sample is drawn instead (see CreateFilteredSample); it
+// records no block size.
+func CreateBlockSample(ctx context.Context, db *sql.DB, table, predicate string, fraction float64, blockRows int64, columns []string) (string, int64, error) {
+	if fraction <= 0 || fraction >= 1 {
+		return "", 0, fmt.Errorf("invalid fraction")
+	}
+	if blockRows <= 0 {
+		return "", 0, fmt.Errorf("invalid block size")
+	}
+	columns, err := PruneColumns(ctx, db, table, columns, "")
+	if err != nil {
+		return "", 0, err
+	}
+	name := SampleName(table, "", fraction, columns...)
+	if predicate != "" {
+		name = filteredSampleName(name, predicate)
+	}
+	name = blockSampleName(name, blockRows)
+	if _, err := db.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", name)); err != nil {
+		return "", 0, err
+	}
+	cols, err := createEmptyLike(ctx, db, table, name, columns)
+	if err != nil {
+		return "", 0, err
+	}
+	if err := fillBlocks(ctx, db, table, name, cols, predicate, fraction, blockRows); err != nil {
+		_, _ = db.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", name))
+		if errors.Is(err, errSparseRowids) {
+			return CreateFilteredSample(ctx, db, table, predicate, fraction, columns)
+		}
+		return "", 0, err
+	}
+	var cnt int64
+	if err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT count(*) FROM %s", name)).Scan(&cnt); err != nil {
+		return name, 0, err
+	}
+	if cnt == 0 {
+		_, _ = db.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", name))
+		return "", 0, fmt.Errorf("%w: %.4f block sample of %s drew no rows", aqeerr.ErrNoSample, fraction, table)
+	}
+	_ = recordSampleMeta(ctx, db, table, name, fraction, columns, predicate, "", blockRows, nil)
+	return name, cnt, nil
+}
+
+const (
+	// maxRowidSparsity is how many times the rows a table holds its rowid
+	// span may be before blocks are given up on: each block would hold
+	// only a fraction of its blockRows rows.
+	maxRowidSparsity = 4
+	// maxBlocks caps the blocks the rowid span is split into when the
+	// table's row count isn't known.
+	maxBlocks = 1 << 22
+)
+
+// errSparseRowids means the rowid span is too wide for block sampling.
+var errSparseRowids = errors.New("rowids too sparse for block sampling")
+
+// knownRowCount is table's row count as far as it is known without reading
+// the table: its tracked count, else the one last recorded, else the
+// analyzed one; 0 when none is.
+func knownRowCount(ctx context.Context, db *sql.DB, table string) int64 {
+	if n, ok, err := storage.ExactRowCount(ctx, db, table); err == nil && ok {
+		return n
+	}
+	if n, err := storage.RecordedRowCount(ctx, db, table); err == nil && n > 0 {
+		return n
+	}
+	if stats, err := storage.ReadAnalyzedStats(ctx, db, table); err == nil && stats != nil {
+		return stats.Rows
+	}
+	return 0
+}
+
+// blockSampleName is the table the sample that would be named name is
+// materialized in when drawn in blocks of blockRows.
+func blockSampleName(name string, blockRows int64) string {
+	sum := sha256.Sum256([]byte(name + "\x00block\x00" + strconv.FormatInt(blockRows, 10)))
+	return storage.SampleTablePrefix + hex.EncodeToString(sum[:8])
+}
+
+// blockBiasWarning is the warning a block sample of blockRows records.
+func blockBiasWarning(blockRows int64) string {
+	unit := fmt.Sprintf("blocks of %d consecutive rows", blockRows)
+	if !storage.ActiveDialect().HasRowid() {
+		unit = "whole pages"
+	}
+	return "block sample: rows were kept in " + unit + ", not one by one, so its intervals understate the error when values cluster by insertion order"
+}
+
+// fillBlocks copies cols of the rows of table matching predicate in
+// randomly chosen blocks into sample, in one transaction.
+func fillBlocks(ctx context.Context, db *sql.DB, table, sample, cols, predicate string, fraction float64, blockRows int64) error {
+	filter := ""
+	if predicate != "" {
+		filter = " AND (" + predicate + ")"
+	}
+	if from := storage.ActiveDialect().BlockSampleSource(table, fraction); from != "" {
+		q := sampleInsert(sample, cols) + "SELECT " + cols + " FROM " + from
+		if predicate != "" {
+			q += " WHERE " + predicate
+		}
+		_, err := db.ExecContext(ctx, q)
+		return err
+	}
+
+	// the ends of the rowid index, each its own lookup: SQLite scans for
+	// MIN and MAX in one query
+	var lo, hi sql.NullInt64
+	if err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT (SELECT MIN(rowid) FROM %s), (SELECT MAX(rowid) FROM %s)", table, table)).Scan(&lo, &hi); err != nil {
+		return err
+	}
+	if !lo.Valid {
+		return nil
+	}
+	span := hi.Int64 - lo.Int64 + 1
+	if rows := knownRowCount(ctx, db, table); rows > 0 && span/maxRowidSparsity > rows || span/blockRows > maxBlocks {
+		return errSparseRowids
+	}
+	ranges, err := chooseBlocks(ctx, lo.Int64, hi.Int64, blockRows, fraction)
+	if err != nil {
+		return err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for start := 0; start < len(ranges); start += appendBatch {
+		batch := ranges[start:min(start+appendBatch, len(ranges))]
+		conds := make([]string, len(batch))
+		args := make([]any, 0, 2*len(batch))
+		for i, r := range batch {
+			conds[i] = "rowid BETWEEN ? AND ?"
+			args = append(args, r[0], r[1])
+		}
+		q := fmt.Sprintf("%sSELECT rowid, %s FROM %s WHERE (%s)%s",
+			sampleInsert(sample, cols), cols, table, strings.Join(conds, " OR "), filter)
+		if _, err := tx.ExecContext(ctx, q, args...); err != nil {
+			return fmt.Errorf("copying blocks of %s: %w", table, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// chooseBlocks splits the rowids lo to hi into blocks of blockRows and
+// keeps each with probability fraction, returning the kept ones as rowid
+// ranges, adjacent ones merged. It stops when ctx is done.
+func chooseBlocks(ctx context.Context, lo, hi, blockRows int64, fraction float64) ([][2]int64, error) {
+	var ranges [][2]int64
+	for start, i := lo, 0; start <= hi; start, i = start+blockRows, i+1 {
+		if i%4096 == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		if rand.Float64() >= fraction {
+			continue
+		}
+		end := min(start+blockRows-1, hi)
+		if n := len(ranges); n > 0 && ranges[n-1][1] == start-1 {
+			ranges[n-1][1] = end
+		} else {
+			ranges = append(ranges, [2]int64{start, end})
+		}
+	}
+	return ranges, nil
+}
@@ -0,0 +1,131 @@
+package sampler
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/aqeerr"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// CreateJoinSynopsis materializes a join synopsis of the inner join of
+// left and right on leftKey = rightKey: a Bernoulli sample of fraction of
+// left's rows, joined with right in full (see storage.JoinSynopsis). For a
+// foreign key join, left being the side holding the foreign key, that is
+// a uniform sample of fraction of the join; any join keeps each joined
+// row with its left row. Both sides' rows are copied whole, so queries
+// can read any column of either. Joining reads base rowids, so databases
+// without them have no join synopses.
+func CreateJoinSynopsis(ctx context.Context, db *sql.DB, left, right, leftKey, rightKey string, fraction float64) (*storage.JoinSynopsis, error) {
+	if fraction <= 0 || fraction >= 1 {
+		return nil, fmt.Errorf("invalid fraction")
+	}
+	if !storage.ActiveDialect().HasRowid() {
+		return nil, fmt.Errorf("%w: join synopses need rowids", storage.ErrUnsupportedDialect)
+	}
+	if strings.EqualFold(left, right) {
+		return nil, fmt.Errorf("%w: a join synopsis joins two different tables", aqeerr.ErrUnsupportedQuery)
+	}
+	leftKey, err := resolveColumn(ctx, db, left, leftKey)
+	if err != nil {
+		return nil, err
+	}
+	rightKey, err = resolveColumn(ctx, db, right, rightKey)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &storage.JoinSynopsis{
+		Name:       joinSynopsisName(left, right, leftKey, rightKey, fraction),
+		LeftTable:  left,
+		RightTable: right,
+		LeftKey:    leftKey,
+		RightKey:   rightKey,
+		Fraction:   fraction,
+	}
+	s.LeftSynopsis, s.RightSynopsis = storage.JoinSynopsisTables(s.Name)
+	draw, pairs := s.Name+"_draw", s.Name+"_pairs"
+	drop := func(tables ...string) {
+		for _, t := range tables {
+			_, _ = db.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", t))
+		}
+	}
+	drop(draw, pairs, s.LeftSynopsis, s.RightSynopsis)
+
+	// the draw is materialized first, so a left row joining many right
+	// rows is kept or dropped with all of them
+	from, pred := storage.ActiveDialect().SampleSource(left, fraction)
+	stmts := []string{
+		fmt.Sprintf("CREATE TABLE %s AS SELECT rowid AS id FROM %s WHERE %s", draw, from, pred),
+		fmt.Sprintf("CREATE TABLE %s AS SELECT l.rowid AS aqe_l, r.rowid AS aqe_r FROM %s d JOIN %s l ON l.rowid = d.id JOIN %s r ON l.%s = r.%s",
+			pairs, draw, left, right, quoteColumns([]string{leftKey}), quoteColumns([]string{rightKey})),
+	}
+	for _, stmt := range stmts {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			drop(draw, pairs)
+			return nil, err
+		}
+	}
+	defer drop(draw, pairs)
+
+	// each side's row of a pair takes the pair's rowid
+	for _, side := range []struct{ table, synopsis, column string }{
+		{left, s.LeftSynopsis, "aqe_l"},
+		{right, s.RightSynopsis, "aqe_r"},
+	} {
+		cols, err := createEmptyLike(ctx, db, side.table, side.synopsis, nil)
+		if err == nil {
+			_, err = db.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s(rowid, %s) SELECT p.rowid, %s FROM %s p JOIN %s t ON t.rowid = p.%s",
+				side.synopsis, cols, cols, pairs, side.table, side.column))
+		}
+		if err != nil {
+			drop(s.LeftSynopsis, s.RightSynopsis)
+			return nil, err
+		}
+	}
+
+	if err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT count(*) FROM %s", s.LeftSynopsis)).Scan(&s.Rows); err != nil {
+		drop(s.LeftSynopsis, s.RightSynopsis)
+		return nil, err
+	}
+	if s.Rows == 0 {
+		drop(s.LeftSynopsis, s.RightSynopsis)
+		return nil, fmt.Errorf("%w: %.4f join synopsis of %s and %s drew no joined rows", aqeerr.ErrNoSample, fraction, left, right)
+	}
+	if err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT count(*) FROM %s", left)).Scan(&s.LeftRows); err != nil {
+		drop(s.LeftSynopsis, s.RightSynopsis)
+		return nil, err
+	}
+	if err := storage.RecordJoinSynopsis(ctx, db, s); err != nil {
+		drop(s.LeftSynopsis, s.RightSynopsis)
+		return nil, err
+	}
+	s.CreatedAt = time.Now().UTC()
+	return s, nil
+}
+
+// resolveColumn returns column as table spells it.
+func resolveColumn(ctx context.Context, db *sql.DB, table, column string) (string, error) {
+	resolved, err := PruneColumns(ctx, db, table, []string{column}, "")
+	if err != nil {
+		return "", err
+	}
+	if len(resolved) == 1 {
+		return resolved[0], nil
+	}
+	return column, nil // the table's only column
+}
+
+// joinSynopsisName is the stable name of the join synopsis of left and
+// right on leftKey = rightKey at fraction, so drawing it again replaces it.
+func joinSynopsisName(left, right, leftKey, rightKey string, fraction float64) string {
+	key := strings.ToLower(strings.Join([]string{left, right, leftKey, rightKey}, "\x00")) + "\x00" + strconv.FormatFloat(fraction, 'g', -1, 64)
+	sum := sha256.Sum256([]byte(key))
+	return storage.JoinSynopsisPrefix + hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,126 @@
+package sampler
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/aqeerr"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// outlierIndex is what aqe_samples records of an outlier sample: the side
+// table holding its outliers, the measure they are extreme in, and the
+// most it was asked to keep.
+type outlierIndex struct {
+	table, column string
+	k             int
+}
+
+// CreateOutlierSample materializes an outlier sample of table for sums of
+// measure: the at most k rows whose measure lies furthest from its mean
+// are copied exactly into an outlier table, and a Bernoulli sample of
+// fraction of the other rows into the sample table. A sum over a
+// heavy-tailed measure then varies only with the bulk of its values; the
+// executor adds the extreme ones back exactly. Rows tied at the cutoff and
+// rows with a NULL measure are sampled with the bulk. Only columns are
+// copied when given (see PruneColumns); measure always is.
+func CreateOutlierSample(ctx context.Context, db *sql.DB, table, measure string, k int, fraction float64, columns []string) (string, int64, error) {
+	if fraction <= 0 || fraction >= 1 {
+		return "", 0, fmt.Errorf("invalid fraction")
+	}
+	if k <= 0 {
+		return "", 0, fmt.Errorf("invalid outlier count: %d", k)
+	}
+	resolved, err := PruneColumns(ctx, db, table, []string{measure}, "")
+	if err != nil {
+		return "", 0, err
+	}
+	if len(resolved) == 1 {
+		measure = resolved[0] // as the table spells it
+	}
+	columns, err = PruneColumns(ctx, db, table, columns, measure)
+	if err != nil {
+		return "", 0, err
+	}
+	name := outlierSampleName(SampleName(table, "", fraction, columns...), measure, k)
+	index := &outlierIndex{
+		table:  storage.OutlierTablePrefix + strings.TrimPrefix(name, storage.SampleTablePrefix),
+		column: measure,
+		k:      k,
+	}
+
+	m := quoteColumns([]string{measure})
+	var mean sql.NullFloat64
+	if err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT AVG(%s) FROM %s", m, table)).Scan(&mean); err != nil {
+		return "", 0, err
+	}
+	if !mean.Valid {
+		return "", 0, fmt.Errorf("%w: %s has no non-NULL values in %s", aqeerr.ErrNoSample, table, measure)
+	}
+	deviation := fmt.Sprintf("ABS(%s - %s)", m, strconv.FormatFloat(mean.Float64, 'g', -1, 64))
+	// the (k+1)th largest deviation: rows beyond it are the outliers, so
+	// there are at most k of them however many tie
+	var cutoff float64
+	err = db.QueryRowContext(ctx, fmt.Sprintf("SELECT %s FROM %s WHERE %s IS NOT NULL ORDER BY 1 DESC LIMIT 1 OFFSET %d",
+		deviation, table, m, k)).Scan(&cutoff)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", 0, fmt.Errorf("%w: %s has no more than %d rows with a %s; query it exactly", aqeerr.ErrNoSample, table, k, measure)
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	limit := strconv.FormatFloat(cutoff, 'g', -1, 64)
+
+	for _, t := range []string{name, index.table} {
+		if _, err := db.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", t)); err != nil {
+			return "", 0, err
+		}
+	}
+	drop := func() {
+		_, _ = db.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", name))
+		_, _ = db.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", index.table))
+	}
+	cols, err := createEmptyLike(ctx, db, table, name, columns)
+	if err != nil {
+		return "", 0, err
+	}
+	rest := fmt.Sprintf("(%s IS NULL OR %s <= %s)", m, deviation, limit)
+	if _, err := db.ExecContext(ctx, sampleInsert(name, cols)+sampleSelect(table, cols, rest, fraction)); err != nil {
+		drop()
+		return "", 0, err
+	}
+	if _, err := createEmptyLike(ctx, db, table, index.table, columns); err != nil {
+		drop()
+		return "", 0, err
+	}
+	if _, err := db.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s(%s) SELECT %s FROM %s WHERE %s > %s",
+		index.table, cols, cols, table, deviation, limit)); err != nil {
+		drop()
+		return "", 0, err
+	}
+
+	var cnt int64
+	if err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT count(*) FROM %s", name)).Scan(&cnt); err != nil {
+		return name, 0, err
+	}
+	if cnt == 0 {
+		drop()
+		return "", 0, fmt.Errorf("%w: %.4f sample of %s beside its %s outliers drew no rows", aqeerr.ErrNoSample, fraction, table, measure)
+	}
+	_ = recordSampleMeta(ctx, db, table, name, fraction, columns, "", "", 0, index)
+	return name, cnt, nil
+}
+
+// outlierSampleName is the table the sample that would be named name
+// without outliers is materialized in when the k outliers of measure are
+// kept aside.
+func outlierSampleName(name, measure string, k int) string {
+	sum := sha256.Sum256([]byte(name + "\x00outliers\x00" + strings.ToLower(measure) + "\x00" + strconv.Itoa(k)))
+	return storage.SampleTablePrefix + hex.EncodeToString(sum[:8])
+}
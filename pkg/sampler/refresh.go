@@ -0,0 +1,173 @@
+package sampler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// RefreshResult reports how a sample was brought back in line with its
+// base table.
+type RefreshResult struct {
+	SampleTable string `json:"sample_table"`
+	Mode        string `json:"mode"` // "tombstone" or "rebuild"
+	Deleted     int64  `json:"deleted"`
+	Resynced    int64  `json:"resynced"`
+	BaseRows    int64  `json:"base_rows"`
+}
+
+// createEmptyLike creates sample with table's columns, or just columns when
+// given, and no rows, and returns the quoted column list for copying rows
+// into it.
+func createEmptyLike(ctx context.Context, db *sql.DB, table, sample string, columns []string) (string, error) {
+	list := "*"
+	if len(columns) > 0 {
+		list = quoteColumns(columns)
+	}
+	if _, err := db.ExecContext(ctx, fmt.Sprintf("CREATE TABLE %s AS SELECT %s FROM %s WHERE 1 = 0", sample, list, table)); err != nil {
+		return "", err
+	}
+	return quotedColumns(ctx, db, sample)
+}
+
+// RefreshSample reconciles a sample with deletes and updates on its base
+// table. Samples that keep base rowids drop rows whose base row is gone
+// (tombstones) and re-copy the survivors' current values, and filtered
+// ones also drop rows no longer matching their predicate; older samples
+// are redrawn, stratified ones with proportional allocation and their
+// congressional minimum, if any, as are all samples on databases without
+// rowids, outlier samples, whose outliers move with the data, universe
+// samples, whose rows move with their keys, and live samples, whose
+// reservoir would otherwise shrink.
+func RefreshSample(ctx context.Context, db *sql.DB, info storage.SampleInfo) (*RefreshResult, error) {
+	if !info.BaseRowids || !storage.ActiveDialect().HasRowid() || info.OutlierTable != "" || info.UniverseColumn != "" || info.ReservoirSize > 0 {
+		return RedrawSample(ctx, db, info)
+	}
+
+	res := &RefreshResult{SampleTable: info.SampleTable, Mode: "tombstone"}
+	cols, err := quotedColumns(ctx, db, info.SampleTable)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	deleted, err := tx.ExecContext(ctx, fmt.Sprintf(
+		"DELETE FROM %s WHERE NOT EXISTS (SELECT 1 FROM %s b WHERE b.rowid = %s.rowid)",
+		info.SampleTable, info.Table, info.SampleTable))
+	if err != nil {
+		return nil, fmt.Errorf("removing tombstoned rows: %w", err)
+	}
+	res.Deleted, _ = deleted.RowsAffected()
+
+	resynced, err := tx.ExecContext(ctx, fmt.Sprintf(
+		"INSERT OR REPLACE INTO %s(rowid, %s) SELECT rowid, %s FROM %s WHERE rowid IN (SELECT rowid FROM %s)",
+		info.SampleTable, cols, cols, info.Table, info.SampleTable))
+	if err != nil {
+		return nil, fmt.Errorf("resyncing updated rows: %w", err)
+	}
+	res.Resynced, _ = resynced.RowsAffected()
+
+	if info.Predicate != "" {
+		// rows updated out of the predicate no longer belong in the sample
+		left, err := tx.ExecContext(ctx, fmt.Sprintf(
+			"DELETE FROM %s WHERE rowid NOT IN (SELECT rowid FROM %s WHERE %s)",
+			info.SampleTable, info.Table, info.Predicate))
+		if err != nil {
+			return nil, fmt.Errorf("removing rows outside the predicate: %w", err)
+		}
+		n, _ := left.RowsAffected()
+		res.Deleted += n
+	}
+
+	if err := tx.QueryRowContext(ctx, fmt.Sprintf("SELECT count(*) FROM %s", info.Table)).Scan(&res.BaseRows); err != nil {
+		return nil, err
+	}
+	var strata, predicate, minStratum, block, warning any
+	if info.BlockRows > 0 {
+		block, warning = info.BlockRows, info.BiasWarning
+	}
+	if info.StrataColumn != "" {
+		strata = info.StrataColumn
+	}
+	if info.MinStratumRows > 0 {
+		minStratum = info.MinStratumRows
+	}
+	if info.Predicate != "" {
+		predicate = info.Predicate
+	}
+	if _, err := tx.ExecContext(ctx, `
+        INSERT INTO aqe_samples(table_name, sample_table, sample_fraction, strata_column, base_row_count, base_rowids, sample_columns, sample_predicate, min_stratum_rows, block_rows, bias_warning, created_at)
+        VALUES(?, ?, ?, ?, ?, 1, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+		info.Table, info.SampleTable, info.Fraction, strata, res.BaseRows, storage.EncodeSampleColumns(info.Columns), predicate, minStratum,
+		block, warning); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO aqe_table_stats(table_name,row_count,updated_at)
+        VALUES(?,?,CURRENT_TIMESTAMP)
+        ON CONFLICT(table_name) DO UPDATE SET row_count=excluded.row_count, updated_at=CURRENT_TIMESTAMP`,
+		info.Table, res.BaseRows); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// RedrawSample draws info's sample afresh from its table as it is now, with
+// the parameters it was drawn with, which RefreshSample can't do for rows
+// appended since.
+func RedrawSample(ctx context.Context, db *sql.DB, info storage.SampleInfo) (*RefreshResult, error) {
+	res := &RefreshResult{SampleTable: info.SampleTable, Mode: "rebuild"}
+	var err error
+	switch {
+	case info.ReservoirSize > 0:
+		res.SampleTable, _, err = CreateReservoirSample(ctx, db, info.Table, info.ReservoirSize, info.Columns)
+	case info.StrataColumn != "":
+		res.SampleTable, _, err = CreateStratifiedSample(ctx, db, info.Table, info.StrataColumn, info.Fraction, "", info.MinStratumRows, info.Columns)
+	case info.UniverseColumn != "":
+		res.SampleTable, _, err = CreateUniverseSample(ctx, db, info.Table, info.UniverseColumn, info.Fraction, info.Columns)
+	case info.OutlierTable != "":
+		res.SampleTable, _, err = CreateOutlierSample(ctx, db, info.Table, info.OutlierColumn, info.Outliers, info.Fraction, info.Columns)
+	case info.BlockRows > 0:
+		res.SampleTable, _, err = CreateBlockSample(ctx, db, info.Table, info.Predicate, info.Fraction, info.BlockRows, info.Columns)
+	default:
+		res.SampleTable, _, err = CreateFilteredSample(ctx, db, info.Table, info.Predicate, info.Fraction, info.Columns)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// samples named before opaque names get a new table; drop the old one
+	if !strings.EqualFold(res.SampleTable, info.SampleTable) {
+		if err := storage.DropSample(ctx, db, info.SampleTable); err != nil {
+			return nil, err
+		}
+	}
+	err = db.QueryRowContext(ctx, fmt.Sprintf("SELECT count(*) FROM %s", info.Table)).Scan(&res.BaseRows)
+	return res, err
+}
+
+// quotedColumns returns table's column names, quoted and comma-separated.
+func quotedColumns(ctx context.Context, db *sql.DB, table string) (string, error) {
+	cols, err := storage.TableColumns(ctx, db, table)
+	if err != nil {
+		return "", err
+	}
+	return quoteColumns(cols), nil
+}
+
+func quoteColumns(cols []string) string {
+	quoted := make([]string, len(cols))
+	for i, c := range cols {
+		quoted[i] = `"` + strings.ReplaceAll(c, `"`, `""`) + `"`
+	}
+	return strings.Join(quoted, ", ")
+}
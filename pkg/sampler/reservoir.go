@@ -0,0 +1,197 @@
+package sampler
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"strconv"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/aqeerr"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// reservoirSampleName is the table a live sample of table holding at most
+// size rows of columns is materialized in.
+func reservoirSampleName(table string, size int64, columns []string) string {
+	sum := sha256.Sum256([]byte(SampleName(table, "", 0, columns...) + "\x00reservoir\x00" + strconv.FormatInt(size, 10)))
+	return storage.SampleTablePrefix + hex.EncodeToString(sum[:8])
+}
+
+// CreateReservoirSample materializes a live sample of table: a reservoir
+// of size rows drawn uniformly at random, or all of them when the table
+// has fewer, that CatchUpReservoir keeps a uniform sample of the table as
+// rows are appended. Its fraction is its size over the rows it has seen,
+// which falls as the table grows. Only columns are copied when given (see
+// PruneColumns). It needs rowids to find appended rows, so only SQLite
+// supports it.
+func CreateReservoirSample(ctx context.Context, db *sql.DB, table string, size int64, columns []string) (string, int64, error) {
+	if err := storage.RequireSQLite("live samples"); err != nil {
+		return "", 0, err
+	}
+	if size <= 0 {
+		return "", 0, fmt.Errorf("invalid reservoir size")
+	}
+	columns, err := PruneColumns(ctx, db, table, columns, "")
+	if err != nil {
+		return "", 0, err
+	}
+	name := reservoirSampleName(table, size, columns)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	defer tx.Rollback()
+	// rows past the high-water mark are left for the first catch-up
+	var scanned, seen int64
+	if err := tx.QueryRowContext(ctx, fmt.Sprintf("SELECT COALESCE(MAX(rowid), 0), count(*) FROM %s", table)).Scan(&scanned, &seen); err != nil {
+		return "", 0, err
+	}
+	if seen == 0 {
+		return "", 0, fmt.Errorf("%w: live sample of %s drew no rows", aqeerr.ErrNoSample, table)
+	}
+	list := "*"
+	if len(columns) > 0 {
+		list = quoteColumns(columns)
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", name)); err != nil {
+		return "", 0, err
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("CREATE TABLE %s AS SELECT %s FROM %s WHERE 1 = 0", name, list, table)); err != nil {
+		return "", 0, err
+	}
+	cols, err := storage.TableColumns(ctx, tx, name)
+	if err != nil {
+		return "", 0, err
+	}
+	r, err := tx.ExecContext(ctx, sampleInsert(name, quoteColumns(cols))+sampleFixed(table, quoteColumns(cols), fmt.Sprintf("rowid <= %d", scanned), size))
+	if err != nil {
+		return "", 0, err
+	}
+	cnt, _ := r.RowsAffected()
+	info := storage.SampleInfo{Table: table, SampleTable: name, Columns: columns, ReservoirSize: size}
+	if err := recordReservoir(ctx, tx, info, cnt, seen, scanned); err != nil {
+		return "", 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO aqe_table_stats(table_name,row_count,updated_at)
+        VALUES(?,?,CURRENT_TIMESTAMP)
+        ON CONFLICT(table_name) DO UPDATE SET row_count=excluded.row_count, updated_at=CURRENT_TIMESTAMP`, table, seen); err != nil {
+		return "", 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", 0, err
+	}
+	_ = storage.TouchSample(ctx, db, name)
+	return name, cnt, nil
+}
+
+// CatchUpReservoir brings info's live sample up to date with the rows
+// appended to its table since it last scanned it, those past its scanned
+// rowid, which serve as its change log. Each is offered to the reservoir
+// in rowid order (Vitter's algorithm R): the n-th row seen replaces a
+// random row of a full reservoir with probability size/n, so the sample
+// stays a uniform sample of every row seen. Rows inserted with a rowid
+// below the scanned one, which SQLite only assigns when told to, are
+// missed until the sample is redrawn, as deleted and updated rows are
+// (see RefreshSample).
+func CatchUpReservoir(ctx context.Context, tx *sql.Tx, info storage.SampleInfo) (*AppendResult, error) {
+	res := &AppendResult{SampleTable: info.SampleTable, BaseRows: info.BaseRows}
+	var slots []int64
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT rowid FROM %s", info.SampleTable))
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		slots = append(slots, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	held := make(map[int64]bool, len(slots))
+	for _, id := range slots {
+		held[id] = true
+	}
+
+	scanned := info.ScannedRowid
+	rows, err = tx.QueryContext(ctx, fmt.Sprintf("SELECT rowid FROM %s WHERE rowid > ? ORDER BY rowid", info.Table), scanned)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		if err := rows.Scan(&scanned); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		res.BaseRows++
+		if int64(len(slots)) < info.ReservoirSize {
+			slots = append(slots, scanned)
+		} else if j := rand.Int63n(res.BaseRows); j < info.ReservoirSize {
+			slots[j] = scanned
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if scanned == info.ScannedRowid {
+		return res, nil
+	}
+
+	var added []int64
+	for _, id := range slots {
+		if held[id] {
+			delete(held, id)
+		} else {
+			added = append(added, id)
+		}
+	}
+	evicted := make([]int64, 0, len(held))
+	for id := range held {
+		evicted = append(evicted, id)
+	}
+	for start := 0; start < len(evicted); start += appendBatch {
+		ids, marks := rowidArgs(evicted[start:min(start+appendBatch, len(evicted))])
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE rowid IN (%s)", info.SampleTable, marks), ids...); err != nil {
+			return nil, fmt.Errorf("evicting from %s: %w", info.SampleTable, err)
+		}
+	}
+	res.Evicted = int64(len(evicted))
+	cols, err := storage.TableColumns(ctx, tx, info.SampleTable)
+	if err != nil {
+		return nil, err
+	}
+	list := quoteColumns(cols)
+	for start := 0; start < len(added); start += appendBatch {
+		ids, marks := rowidArgs(added[start:min(start+appendBatch, len(added))])
+		r, err := tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s(rowid, %s) SELECT rowid, %s FROM %s WHERE rowid IN (%s)",
+			info.SampleTable, list, list, info.Table, marks), ids...)
+		if err != nil {
+			return nil, fmt.Errorf("appending to %s: %w", info.SampleTable, err)
+		}
+		n, _ := r.RowsAffected()
+		res.Added += n
+	}
+	if err := recordReservoir(ctx, tx, info, int64(len(slots)), res.BaseRows, scanned); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// recordReservoir records info's live sample as holding held of the seen
+// rows up to rowid scanned.
+func recordReservoir(ctx context.Context, tx *sql.Tx, info storage.SampleInfo, held, seen, scanned int64) error {
+	_, err := tx.ExecContext(ctx, `
+        INSERT INTO aqe_samples(table_name, sample_table, sample_fraction, base_row_count, base_rowids, sample_columns, reservoir_size, scanned_rowid, created_at)
+        VALUES(?, ?, ?, ?, 1, ?, ?, ?, CURRENT_TIMESTAMP)`,
+		info.Table, info.SampleTable, float64(held)/float64(seen), seen, storage.EncodeSampleColumns(info.Columns), info.ReservoirSize, scanned)
+	return err
+}
@@ -2,23 +2,53 @@ package sampler
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"math"
+	"sort"
+	"strconv"
 	"strings"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/aqeerr"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
 )
 
-func CreateUniformSample(ctx context.Context, db *sql.DB, table string, fraction float64) (string, int64, error) {
+// CreateUniformSample materializes a Bernoulli sample of fraction of
+// table's rows, copying only columns when given (see PruneColumns).
+func CreateUniformSample(ctx context.Context, db *sql.DB, table string, fraction float64, columns []string) (string, int64, error) {
+	return CreateFilteredSample(ctx, db, table, "", fraction, columns)
+}
+
+// CreateFilteredSample materializes a Bernoulli sample of fraction of the
+// rows of table matching predicate, a WHERE condition ("" for every row),
+// and records the predicate with it. Only columns are copied when given
+// (see PruneColumns); they must include those predicate reads for queries
+// filtering on them to use the sample.
+func CreateFilteredSample(ctx context.Context, db *sql.DB, table, predicate string, fraction float64, columns []string) (string, int64, error) {
 	if fraction <= 0 || fraction >= 1 {
 		return "", 0, fmt.Errorf("invalid fraction")
 	}
-	name := fmt.Sprintf("%s__sample_%s", table, fractionName(fraction))
-	_, err := db.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", name))
+	columns, err := PruneColumns(ctx, db, table, columns, "")
 	if err != nil {
 		return "", 0, err
 	}
-	q := fmt.Sprintf("CREATE TABLE %s AS SELECT * FROM %s WHERE (abs(random())/9223372036854775807.0) < %f", name, table, fraction)
-	if _, err := db.ExecContext(ctx, q); err != nil {
+	name := SampleName(table, "", fraction, columns...)
+	if predicate != "" {
+		name = filteredSampleName(name, predicate)
+	}
+	_, err = db.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", name))
+	if err != nil {
+		return "", 0, err
+	}
+	cols, err := createEmptyLike(ctx, db, table, name, columns)
+	if err != nil {
+		return "", 0, err
+	}
+	if _, err := db.ExecContext(ctx, sampleInsert(name, cols)+sampleSelect(table, cols, predicate, fraction)); err != nil {
+		_, _ = db.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", name))
 		return "", 0, err
 	}
 	var cnt int64
@@ -26,46 +56,170 @@ func CreateUniformSample(ctx context.Context, db *sql.DB, table string, fraction
 	if err := row.Scan(&cnt); err != nil {
 		return name, 0, err
 	}
-	_ = recordSampleMeta(ctx, db, table, name, fraction)
+	if cnt == 0 {
+		_, _ = db.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", name))
+		if predicate != "" {
+			return "", 0, fmt.Errorf("%w: %.4f sample of %s where %s drew no rows", aqeerr.ErrNoSample, fraction, table, predicate)
+		}
+		return "", 0, fmt.Errorf("%w: %.4f sample of %s drew no rows", aqeerr.ErrNoSample, fraction, table)
+	}
+	_ = recordSampleMeta(ctx, db, table, name, fraction, columns, predicate, "", 0, nil)
 	return name, cnt, nil
 }
 
-func fractionName(f float64) string {
-	if f <= 0 {
-		return "0_000"
+// SampleName is the table a sample of table, stratified on strataCol ("" for
+// uniform) at fraction, is materialized in; columns, as PruneColumns returns
+// them, name a pruned sample. The name is stable, so drawing the same sample
+// again replaces it, and distinct for distinct samples, but opaque:
+// aqe_samples is the only record of what it holds.
+func SampleName(table, strataCol string, fraction float64, columns ...string) string {
+	key := strings.ToLower(table) + "\x00" + strings.ToLower(strataCol) + "\x00" + strconv.FormatFloat(fraction, 'g', -1, 64)
+	if len(columns) > 0 {
+		key += "\x00" + strings.ToLower(strings.Join(columns, "\x00"))
 	}
-	prec := 3
-	if f < 0.001 {
-		prec = 6
+	sum := sha256.Sum256([]byte(key))
+	return storage.SampleTablePrefix + hex.EncodeToString(sum[:8])
+}
+
+// filteredSampleName is the table the sample that would be named name
+// unfiltered is materialized in when restricted to predicate.
+func filteredSampleName(name, predicate string) string {
+	sum := sha256.Sum256([]byte(name + "\x00" + strings.Join(strings.Fields(predicate), " ")))
+	return storage.SampleTablePrefix + hex.EncodeToString(sum[:8])
+}
+
+// ErrUnknownColumn means a sample was asked to copy a column its base table
+// does not have.
+var ErrUnknownColumn = errors.New("unknown column")
+
+// PruneColumns resolves the columns a sample of table should copy: the
+// requested ones as the table spells them, in table order, plus strataCol
+// when stratifying. It returns nil, meaning every column, when none are
+// requested or they cover the whole table.
+func PruneColumns(ctx context.Context, db *sql.DB, table string, columns []string, strataCol string) ([]string, error) {
+	if len(columns) == 0 {
+		return nil, nil
 	}
-	s := fmt.Sprintf("%.*f", prec, f)
-	s = strings.Replace(s, ".", "_", 1)
-	s = strings.TrimRight(s, "0")
-	if strings.HasSuffix(s, "_") {
-		s += "0"
+	all, err := storage.TableColumns(ctx, db, table)
+	if err != nil {
+		return nil, err
 	}
-	if len(s) > 12 {
-		e := int(math.Log10(f))
-		mant := f / math.Pow(10, float64(e))
-		s = fmt.Sprintf("%0.2fE%d", mant, e)
-		s = strings.ReplaceAll(s, ".", "_")
-		s = strings.ReplaceAll(s, "+", "p")
-		s = strings.ReplaceAll(s, "-", "m")
+	want := make(map[string]bool, len(columns)+1)
+	for _, c := range append(columns, strataCol) {
+		if c != "" {
+			want[strings.ToLower(c)] = true
+		}
 	}
-	if !strings.HasPrefix(s, "0_") {
-		s = "0_" + s
+	var out []string
+	for _, c := range all {
+		if want[strings.ToLower(c)] {
+			out = append(out, c)
+			delete(want, strings.ToLower(c))
+		}
 	}
-	return s
+	if len(want) > 0 {
+		missing := make([]string, 0, len(want))
+		for c := range want {
+			missing = append(missing, c)
+		}
+		sort.Strings(missing)
+		return nil, fmt.Errorf("%w: %s has no column %s", ErrUnknownColumn, table, strings.Join(missing, ", "))
+	}
+	if len(out) == len(all) {
+		return nil, nil
+	}
+	return out, nil
+}
+
+// sampleInsert starts the INSERT that fills sample with rows selected by
+// sampleSelect.
+func sampleInsert(sample, cols string) string {
+	if storage.ActiveDialect().HasRowid() {
+		return fmt.Sprintf("INSERT INTO %s(rowid, %s) ", sample, cols)
+	}
+	return fmt.Sprintf("INSERT INTO %s(%s) ", sample, cols)
+}
+
+// sampleSelect selects cols, and the base rowid where the database has
+// one, from the rows of table matching filter ("" for all), keeping each
+// with probability fraction.
+func sampleSelect(table, cols, filter string, fraction float64) string {
+	d := storage.ActiveDialect()
+	from, pred := d.SampleSource(table, fraction)
+	var conds []string
+	for _, c := range []string{filter, pred} {
+		if c != "" {
+			conds = append(conds, c)
+		}
+	}
+	q := "SELECT " + cols + " FROM " + from
+	if d.HasRowid() {
+		q = "SELECT rowid, " + cols + " FROM " + from
+	}
+	if len(conds) > 0 {
+		q += " WHERE " + strings.Join(conds, " AND ")
+	}
+	return q
+}
+
+// sampleFixed selects cols, and the base rowid where the database has one,
+// from n rows of table matching filter drawn at random, or all of them when
+// fewer match.
+func sampleFixed(table, cols, filter string, n int64) string {
+	q := "SELECT " + cols + " FROM " + table
+	if storage.ActiveDialect().HasRowid() {
+		q = "SELECT rowid, " + cols + " FROM " + table
+	}
+	return fmt.Sprintf("SELECT * FROM (%s WHERE %s ORDER BY RANDOM() LIMIT %d) AS fixed", q, filter, n)
+}
+
+// baseRowids is the aqe_samples.base_rowids flag for samples drawn here.
+func baseRowids() int {
+	if storage.ActiveDialect().HasRowid() {
+		return 1
+	}
+	return 0
 }
 
-func recordSampleMeta(ctx context.Context, db *sql.DB, table, sample string, fraction float64) error {
+// recordSampleMeta records a freshly drawn sample; universe is the key of
+// a universe sample, blockRows the block size of a block sample, and
+// outliers is set for an outlier sample.
+func recordSampleMeta(ctx context.Context, db *sql.DB, table, sample string, fraction float64, columns []string, predicate, universe string, blockRows int64, outliers *outlierIndex) error {
 	var baseCnt int64
-	_ = db.QueryRowContext(ctx, fmt.Sprintf("SELECT count(*) FROM %s", table)).Scan(&baseCnt)
-	_, _ = db.ExecContext(ctx, `INSERT INTO aqe_table_stats(table_name,row_count,updated_at)
+	if blockRows > 0 {
+		// counting would read the whole table the blocks spared: take the
+		// count already known, else the rowid estimate, which only runs
+		// high after deletes and so never replaces a recorded count
+		if baseCnt = knownRowCount(ctx, db, table); baseCnt == 0 {
+			baseCnt, _ = storage.EstimateRowCount(ctx, db, table)
+			_, _ = db.ExecContext(ctx, `INSERT INTO aqe_table_stats(table_name,row_count,updated_at)
+        VALUES(?,?,CURRENT_TIMESTAMP)
+        ON CONFLICT(table_name) DO NOTHING`, table, baseCnt)
+		}
+	} else {
+		_ = db.QueryRowContext(ctx, fmt.Sprintf("SELECT count(*) FROM %s", table)).Scan(&baseCnt)
+		_, _ = db.ExecContext(ctx, `INSERT INTO aqe_table_stats(table_name,row_count,updated_at)
         VALUES(?,?,CURRENT_TIMESTAMP)
         ON CONFLICT(table_name) DO UPDATE SET row_count=excluded.row_count, updated_at=CURRENT_TIMESTAMP`, table, baseCnt)
-	_, _ = db.ExecContext(ctx, `INSERT INTO aqe_samples(table_name,sample_table,sample_fraction,created_at)
-        VALUES(?,?,?,CURRENT_TIMESTAMP)`, table, sample, fraction)
+	}
+	var pred, universeColumn, outlierTable, outlierColumn, outlierCount, block, warning any
+	if predicate != "" {
+		pred = predicate
+	}
+	if universe != "" {
+		universeColumn = universe
+	}
+	if outliers != nil {
+		outlierTable, outlierColumn, outlierCount = outliers.table, outliers.column, outliers.k
+	}
+	if blockRows > 0 {
+		block, warning = blockRows, blockBiasWarning(blockRows)
+	}
+	_, _ = db.ExecContext(ctx, `INSERT INTO aqe_samples(table_name,sample_table,sample_fraction,base_row_count,base_rowids,sample_columns,sample_predicate,outlier_table,outlier_column,outlier_count,universe_column,block_rows,bias_warning,created_at)
+        VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?,CURRENT_TIMESTAMP)`, table, sample, fraction, baseCnt, baseRowids(), storage.EncodeSampleColumns(columns), pred,
+		outlierTable, outlierColumn, outlierCount, universeColumn, block, warning)
+	// a freshly drawn sample starts hot, even if an older one was archived
+	_ = storage.TouchSample(ctx, db, sample)
 	return nil
 }
 
@@ -77,24 +231,47 @@ type StrataInfo struct {
 	Fraction    float64 `json:"fraction"`
 	Weight      float64 `json:"weight"`
 	Variance    float64 `json:"variance"`
+	// Boosted is set when congressional allocation raised the stratum to
+	// its minimum; it then holds exactly SampleSize rows drawn at random.
+	Boosted bool `json:"boosted,omitempty"`
 }
 
-func CreateStratifiedSample(ctx context.Context, db *sql.DB, table string, strataCol string, totalFraction float64, varianceCol string) (string, []StrataInfo, error) {
+// CreateStratifiedSample materializes a sample of table stratified on
+// strataCol, allocating totalFraction across strata by Neyman allocation on
+// varianceCol when given and proportionally otherwise. A positive
+// minPerStratum makes the allocation congressional: every stratum holds at
+// least that many rows, or all of its rows, so GROUP BY strataCol sees
+// rare groups (see allocateCongressional). Only columns are copied when
+// given (see PruneColumns); strataCol always is.
+func CreateStratifiedSample(ctx context.Context, db *sql.DB, table string, strataCol string, totalFraction float64, varianceCol string, minPerStratum int64, columns []string) (string, []StrataInfo, error) {
 	if totalFraction <= 0 || totalFraction >= 1 {
 		return "", nil, fmt.Errorf("invalid total fraction: %f", totalFraction)
 	}
+	if minPerStratum < 0 {
+		return "", nil, fmt.Errorf("invalid minimum stratum size: %d", minPerStratum)
+	}
+	columns, err := PruneColumns(ctx, db, table, columns, strataCol)
+	if err != nil {
+		return "", nil, err
+	}
 
 	strata, err := analyzeStrata(ctx, db, table, strataCol, varianceCol)
 	if err != nil {
 		return "", nil, fmt.Errorf("failed to analyze strata: %w", err)
 	}
+	if len(strata) == 0 {
+		return "", nil, fmt.Errorf("%w: %s has no non-NULL values in %s", aqeerr.ErrNoSample, table, strataCol)
+	}
 
 	if varianceCol != "" {
 		allocateNeymanOptimal(strata, totalFraction)
 	} else {
 		allocateProportional(strata, totalFraction)
 	}
-	sampleName := fmt.Sprintf("%s__strat_sample_%s_%s", table, strataCol, fractionName(totalFraction))
+	if minPerStratum > 0 {
+		allocateCongressional(strata, minPerStratum)
+	}
+	sampleName := SampleName(table, strataCol, totalFraction, columns...)
 
 	// Drop existing sample if it exists
 	_, err = db.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", sampleName))
@@ -102,14 +279,18 @@ func CreateStratifiedSample(ctx context.Context, db *sql.DB, table string, strat
 		return "", nil, err
 	}
 
-	// Build the stratified sampling query
-	query := buildStratifiedSampleQuery(table, sampleName, strataCol, strata)
-
-	_, err = db.ExecContext(ctx, query)
+	cols, err := createEmptyLike(ctx, db, table, sampleName, columns)
 	if err != nil {
 		return "", nil, fmt.Errorf("failed to create stratified sample: %w", err)
 	}
 
+	// Build the stratified sampling query
+	if query := buildStratifiedSampleQuery(table, sampleName, strataCol, cols, strata); query != "" {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return "", nil, fmt.Errorf("failed to create stratified sample: %w", err)
+		}
+	}
+
 	// Update actual sample sizes
 	err = updateActualSampleSizes(ctx, db, sampleName, strataCol, strata)
 	if err != nil {
@@ -117,7 +298,7 @@ func CreateStratifiedSample(ctx context.Context, db *sql.DB, table string, strat
 	}
 
 	// Record metadata
-	err = recordStratifiedSampleMeta(ctx, db, table, sampleName, strataCol, totalFraction, strata)
+	err = recordStratifiedSampleMeta(ctx, db, table, sampleName, strataCol, totalFraction, minPerStratum, strata, columns)
 	if err != nil {
 		return "", nil, fmt.Errorf("failed to record metadata: %w", err)
 	}
@@ -221,27 +402,82 @@ func allocateProportional(strata []StrataInfo, totalFraction float64) {
 	}
 }
 
-// buildStratifiedSampleQuery constructs the SQL for creating stratified sample
-func buildStratifiedSampleQuery(table, sampleName, strataCol string, strata []StrataInfo) string {
+// allocateCongressional raises every stratum of an allocation below
+// minRows to minRows, or to its whole population when smaller, and scales
+// the other strata's fractions down by a common factor so the sample keeps
+// its total size. Small strata are boosted at the expense of large ones,
+// whose relative error grows least; when the minimums alone exceed the
+// total, every stratum gets just its minimum and the sample is larger.
+func allocateCongressional(strata []StrataInfo, minRows int64) {
+	floor := func(s StrataInfo) float64 {
+		if s.PopSize < minRows {
+			return float64(s.PopSize)
+		}
+		return float64(minRows)
+	}
+	var total float64
+	for _, s := range strata {
+		total += s.Fraction * float64(s.PopSize)
+	}
+	size := func(scale float64) float64 {
+		var n float64
+		for _, s := range strata {
+			n += math.Max(scale*s.Fraction*float64(s.PopSize), floor(s))
+		}
+		return n
+	}
+
+	// size grows with scale, and size(1) >= total
+	lo, hi := 0.0, 1.0
+	if size(0) >= total {
+		hi = 0
+	}
+	for i := 0; i < 50 && hi > 0; i++ {
+		mid := (lo + hi) / 2
+		if size(mid) > total {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+
+	for i := range strata {
+		s := &strata[i]
+		if n := hi * s.Fraction * float64(s.PopSize); n >= floor(*s) {
+			s.Fraction *= hi
+			s.SampleSize = int64(n)
+			continue
+		}
+		s.SampleSize = int64(floor(*s))
+		s.Fraction = float64(s.SampleSize) / float64(s.PopSize)
+		s.Boosted = true
+	}
+}
+
+// buildStratifiedSampleQuery constructs the SQL that fills the (already
+// created) stratified sample, keeping base rowids where the database has
+// them. Boosted strata take exactly their sample size, the others each
+// row with their fraction. It returns "" when no stratum is sampled.
+func buildStratifiedSampleQuery(table, sampleName, strataCol, cols string, strata []StrataInfo) string {
 	var unionParts []string
 
 	for _, stratum := range strata {
 		if stratum.SampleSize > 0 {
 			// For each stratum, sample with the calculated fraction
-			part := fmt.Sprintf(`
-                SELECT * FROM %s 
-                WHERE %s = '%s' AND (abs(random())/9223372036854775807.0) < %f`,
-				table, strataCol, stratum.StrataValue, stratum.Fraction)
-			unionParts = append(unionParts, part)
+			filter := fmt.Sprintf("%s = '%s'", strataCol, stratum.StrataValue)
+			if stratum.Boosted {
+				unionParts = append(unionParts, sampleFixed(table, cols, filter, stratum.SampleSize))
+				continue
+			}
+			unionParts = append(unionParts, sampleSelect(table, cols, filter, stratum.Fraction))
 		}
 	}
 
 	if len(unionParts) == 0 {
-		return fmt.Sprintf("CREATE TABLE %s AS SELECT * FROM %s WHERE 1=0", sampleName, table)
+		return ""
 	}
 
-	query := fmt.Sprintf("CREATE TABLE %s AS %s", sampleName, strings.Join(unionParts, " UNION ALL "))
-	return query
+	return sampleInsert(sampleName, cols) + strings.Join(unionParts, " UNION ALL ")
 }
 
 // updateActualSampleSizes updates the StrataInfo with actual achieved sample sizes
@@ -279,20 +515,29 @@ func updateActualSampleSizes(ctx context.Context, db *sql.DB, sampleName, strata
 	return rows.Err()
 }
 
-// recordStratifiedSampleMeta records metadata about the stratified sample
-func recordStratifiedSampleMeta(ctx context.Context, db *sql.DB, table, sampleName, strataCol string, totalFraction float64, strata []StrataInfo) error {
+// recordStratifiedSampleMeta records metadata about the stratified sample;
+// minRows is its congressional minimum, 0 for none.
+func recordStratifiedSampleMeta(ctx context.Context, db *sql.DB, table, sampleName, strataCol string, totalFraction float64, minRows int64, strata []StrataInfo, columns []string) error {
+	var baseCnt int64
+	_ = db.QueryRowContext(ctx, fmt.Sprintf("SELECT count(*) FROM %s", table)).Scan(&baseCnt)
+	var minStratum any
+	if minRows > 0 {
+		minStratum = minRows
+	}
+
 	// Record in main samples table
 	_, err := db.ExecContext(ctx, `
-        INSERT INTO aqe_samples(table_name, sample_table, sample_fraction, strata_column, created_at)
-        VALUES(?, ?, ?, ?, CURRENT_TIMESTAMP)`,
-		table, sampleName, totalFraction, strataCol)
+        INSERT INTO aqe_samples(table_name, sample_table, sample_fraction, strata_column, base_row_count, base_rowids, sample_columns, min_stratum_rows, created_at)
+        VALUES(?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+		table, sampleName, totalFraction, strataCol, baseCnt, baseRowids(), storage.EncodeSampleColumns(columns), minStratum)
 
 	if err != nil {
 		return err
 	}
+	_ = storage.TouchSample(ctx, db, sampleName)
 
 	// Create strata info table if it doesn't exist
-	_, err = db.ExecContext(ctx, `
+	_, err = db.ExecContext(ctx, storage.ActiveDialect().DDL(`
         CREATE TABLE IF NOT EXISTS aqe_strata_info (
             id INTEGER PRIMARY KEY AUTOINCREMENT,
             sample_table TEXT NOT NULL,
@@ -304,12 +549,17 @@ func recordStratifiedSampleMeta(ctx context.Context, db *sql.DB, table, sampleNa
             weight REAL NOT NULL,
             variance REAL NOT NULL,
             created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-        )`)
+        )`))
 
 	if err != nil {
 		return err
 	}
 
+	// a redraw replaces the strata of the draw before, some of which may
+	// be gone
+	if _, err := db.ExecContext(ctx, `DELETE FROM aqe_strata_info WHERE sample_table = ?`, sampleName); err != nil {
+		return err
+	}
 	// Record each stratum's info
 	for _, stratum := range strata {
 		_, err = db.ExecContext(ctx, `
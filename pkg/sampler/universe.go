@@ -0,0 +1,146 @@
+package sampler
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/aqeerr"
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/storage"
+)
+
+// universeBatch is how many kept keys one INSERT of a universe sample
+// lists.
+const universeBatch = 500
+
+// CreateUniverseSample materializes a universe sample of table on key:
+// every row whose key value is kept by UniverseKeeps at fraction, and no
+// other. Whether a key is kept depends only on its value, so universe
+// samples of two tables on their join keys at the same fraction keep the
+// same keys, and joining them yields every joined row of those keys: a
+// sample of fraction of the join's keys, which scales by 1/fraction like
+// a uniform sample, where two independent samples would keep only
+// fraction squared of the joined rows. Rows with a NULL key join nothing
+// and are left out. Only columns are copied when given (see
+// PruneColumns); key always is.
+func CreateUniverseSample(ctx context.Context, db *sql.DB, table, key string, fraction float64, columns []string) (string, int64, error) {
+	if fraction <= 0 || fraction >= 1 {
+		return "", 0, fmt.Errorf("invalid fraction")
+	}
+	resolved, err := PruneColumns(ctx, db, table, []string{key}, "")
+	if err != nil {
+		return "", 0, err
+	}
+	if len(resolved) == 1 {
+		key = resolved[0] // as the table spells it
+	}
+	columns, err = PruneColumns(ctx, db, table, columns, key)
+	if err != nil {
+		return "", 0, err
+	}
+	name := universeSampleName(SampleName(table, "", fraction, columns...), key)
+	k := quoteColumns([]string{key})
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT DISTINCT %s FROM %s WHERE %s IS NOT NULL", k, table, k))
+	if err != nil {
+		return "", 0, err
+	}
+	var kept []any
+	for rows.Next() {
+		var v any
+		if err := rows.Scan(&v); err != nil {
+			rows.Close()
+			return "", 0, err
+		}
+		if UniverseKeeps(v, fraction) {
+			kept = append(kept, v)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", 0, err
+	}
+	if len(kept) == 0 {
+		return "", 0, fmt.Errorf("%w: %.4f universe sample of %s on %s kept no keys", aqeerr.ErrNoSample, fraction, table, key)
+	}
+
+	if _, err := db.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", name)); err != nil {
+		return "", 0, err
+	}
+	cols, err := createEmptyLike(ctx, db, table, name, columns)
+	if err != nil {
+		return "", 0, err
+	}
+	drop := func() { _, _ = db.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", name)) }
+	source := "SELECT " + cols + " FROM " + table
+	if storage.ActiveDialect().HasRowid() {
+		source = "SELECT rowid, " + cols + " FROM " + table
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		drop()
+		return "", 0, err
+	}
+	for start := 0; start < len(kept); start += universeBatch {
+		batch := kept[start:min(start+universeBatch, len(kept))]
+		marks := strings.TrimSuffix(strings.Repeat("?, ", len(batch)), ", ")
+		if _, err := tx.ExecContext(ctx, sampleInsert(name, cols)+fmt.Sprintf("%s WHERE %s IN (%s)", source, k, marks), batch...); err != nil {
+			_ = tx.Rollback()
+			drop()
+			return "", 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		drop()
+		return "", 0, err
+	}
+
+	var cnt int64
+	if err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT count(*) FROM %s", name)).Scan(&cnt); err != nil {
+		return name, 0, err
+	}
+	_ = recordSampleMeta(ctx, db, table, name, fraction, columns, "", key, 0, nil)
+	return name, cnt, nil
+}
+
+// UniverseKeeps reports whether a universe sample at fraction keeps the
+// rows whose key is v: whether v's text, as the database returns it,
+// hashes into the first fraction of the hash range. Equal keys in
+// different tables are kept alike.
+func UniverseKeeps(v any, fraction float64) bool {
+	var text string
+	switch k := v.(type) {
+	case nil:
+		return false
+	case []byte:
+		text = string(k)
+	case string:
+		text = k
+	case int64:
+		text = strconv.FormatInt(k, 10)
+	case float64:
+		text = strconv.FormatFloat(k, 'g', -1, 64)
+	default:
+		text = fmt.Sprint(k)
+	}
+	h := fnv.New64a()
+	h.Write([]byte(text))
+	// FNV mixes short keys' high bits poorly; finish with splitmix64's
+	x := h.Sum64()
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	x ^= x >> 31
+	return float64(x>>11)/(1<<53) < fraction
+}
+
+// universeSampleName is the table the sample that would be named name is
+// materialized in when drawn as a universe sample on key.
+func universeSampleName(name, key string) string {
+	sum := sha256.Sum256([]byte(name + "\x00universe\x00" + strings.ToLower(key)))
+	return storage.SampleTablePrefix + hex.EncodeToString(sum[:8])
+}
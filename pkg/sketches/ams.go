@@ -0,0 +1,208 @@
+package sketches
+
+import (
+    "bytes"
+    "encoding/binary"
+    "fmt"
+    "math"
+    "sort"
+)
+
+// amsMagic prefixes serialized AMS sketches.
+var amsMagic = []byte{'A', 'Q', 'A', 1}
+
+// amsHeaderSize is magic(4) + seed(8) + depth(4) + width(4) + items(8).
+const amsHeaderSize = 28
+
+// MaxAMSCounters caps a sketch at 128 MiB of counters.
+const MaxAMSCounters = 1 << 24
+
+// Default AMS dimensions. Sketches are only joined with sketches of the
+// same dimensions and seed, so columns meant to be joined are sketched
+// with the same parameters.
+const (
+    DefaultAMSWidth = 4096
+    DefaultAMSDepth = 5
+)
+
+// AMSSketch is a Fast-AGMS (AMS) sketch of a column's value frequencies:
+// each of depth rows hashes a value to one of width counters and adds its
+// count with a random sign. The inner product of two sketches' rows
+// estimates the size of the equi-join of their columns, Σ f(v)·g(v), with
+// the median over rows taken to bound the error.
+type AMSSketch struct {
+    counters [][]int64 // counters[depth][width]
+    depth    uint32
+    width    uint32
+    items    uint64 // rows added
+    seed     uint64
+}
+
+// NewAMSSketch creates an empty sketch of width counters per row and
+// depth rows, hashing with seeded xxHash64.
+func NewAMSSketch(width, depth uint32, seed uint64) (*AMSSketch, error) {
+    if width == 0 || depth == 0 {
+        return nil, fmt.Errorf("AMS sketch width and depth must be positive, got %d and %d", width, depth)
+    }
+    if uint64(width)*uint64(depth) > MaxAMSCounters {
+        return nil, fmt.Errorf("AMS sketch of %d x %d counters is over the %d limit", depth, width, MaxAMSCounters)
+    }
+    counters := make([][]int64, depth)
+    for i := range counters {
+        counters[i] = make([]int64, width)
+    }
+    return &AMSSketch{counters: counters, depth: depth, width: width, seed: seed}, nil
+}
+
+// Add counts key count times; a negative count removes rows.
+func (s *AMSSketch) Add(key []byte, count int64) {
+    for i := uint32(0); i < s.depth; i++ {
+        // the bucket from the low bits, the sign from the top one
+        h := XXH64(key, s.seed+uint64(i))
+        if h>>63 == 1 {
+            s.counters[i][h%uint64(s.width)] -= count
+        } else {
+            s.counters[i][h%uint64(s.width)] += count
+        }
+    }
+    if count > 0 {
+        s.items += uint64(count)
+    } else {
+        s.items -= min(s.items, uint64(-count))
+    }
+}
+
+// AddValue counts a value as database/sql reads it (see ValueKey), so
+// values SQL compares equal are counted alike; NULL, which joins nothing,
+// is not counted.
+func (s *AMSSketch) AddValue(v any, count int64) {
+    if key, ok := ValueKey(v); ok {
+        s.Add(key, count)
+    }
+}
+
+// Compatible reports why s and other can't be joined or merged, if they
+// can't: they need the same dimensions and seed.
+func (s *AMSSketch) Compatible(other *AMSSketch) error {
+    if s.width != other.width || s.depth != other.depth {
+        return fmt.Errorf("AMS sketches of %d x %d and %d x %d counters can't be combined",
+            s.depth, s.width, other.depth, other.width)
+    }
+    if s.seed != other.seed {
+        return fmt.Errorf("AMS sketches with different seeds can't be combined")
+    }
+    return nil
+}
+
+// JoinSize estimates how many rows an equi-join of the two sketched
+// columns returns: the median over rows of their counters' inner product.
+// The estimate is unbiased, so it can be negative for joins of almost
+// no rows.
+func (s *AMSSketch) JoinSize(other *AMSSketch) (float64, error) {
+    if err := s.Compatible(other); err != nil {
+        return 0, err
+    }
+    estimates := make([]float64, s.depth)
+    for i := range estimates {
+        var dot float64
+        for j, c := range s.counters[i] {
+            dot += float64(c) * float64(other.counters[i][j])
+        }
+        estimates[i] = dot
+    }
+    sort.Float64s(estimates)
+    mid := len(estimates) / 2
+    if len(estimates)%2 == 0 {
+        return (estimates[mid-1] + estimates[mid]) / 2, nil
+    }
+    return estimates[mid], nil
+}
+
+// SelfJoinSize estimates the column's second frequency moment, Σ f(v)²:
+// the size of its join with itself.
+func (s *AMSSketch) SelfJoinSize() float64 {
+    n, _ := s.JoinSize(s)
+    return n
+}
+
+// JoinSizeError is the standard error of one row's join size estimate,
+// sqrt(2·F2(s)·F2(other)/width); the median over rows is usually closer.
+func (s *AMSSketch) JoinSizeError(other *AMSSketch) float64 {
+    return math.Sqrt(2 * math.Max(s.SelfJoinSize(), 0) * math.Max(other.SelfJoinSize(), 0) / float64(s.width))
+}
+
+// Merge adds other's rows to this sketch (must be compatible).
+func (s *AMSSketch) Merge(other *AMSSketch) error {
+    if err := s.Compatible(other); err != nil {
+        return err
+    }
+    for i := range s.counters {
+        for j, c := range other.counters[i] {
+            s.counters[i][j] += c
+        }
+    }
+    s.items += other.items
+    return nil
+}
+
+// Items returns how many rows were counted.
+func (s *AMSSketch) Items() uint64 {
+    return s.items
+}
+
+// Width returns the counters per row.
+func (s *AMSSketch) Width() uint32 {
+    return s.width
+}
+
+// Depth returns the rows of counters.
+func (s *AMSSketch) Depth() uint32 {
+    return s.depth
+}
+
+// Seed returns the hash seed
+func (s *AMSSketch) Seed() uint64 {
+    return s.seed
+}
+
+// Serialize returns the sketch as bytes
+func (s *AMSSketch) Serialize() []byte {
+    data := make([]byte, amsHeaderSize+int(s.depth)*int(s.width)*8)
+    copy(data[0:4], amsMagic)
+    binary.LittleEndian.PutUint64(data[4:12], s.seed)
+    binary.LittleEndian.PutUint32(data[12:16], s.depth)
+    binary.LittleEndian.PutUint32(data[16:20], s.width)
+    binary.LittleEndian.PutUint64(data[20:28], s.items)
+    off := amsHeaderSize
+    for _, row := range s.counters {
+        for _, c := range row {
+            binary.LittleEndian.PutUint64(data[off:], uint64(c))
+            off += 8
+        }
+    }
+    return data
+}
+
+// DeserializeAMSSketch loads a sketch from bytes
+func DeserializeAMSSketch(data []byte) (*AMSSketch, error) {
+    if len(data) < amsHeaderSize || !bytes.Equal(data[0:4], amsMagic) {
+        return nil, fmt.Errorf("not a serialized AMS sketch")
+    }
+    s, err := NewAMSSketch(binary.LittleEndian.Uint32(data[16:20]), binary.LittleEndian.Uint32(data[12:16]),
+        binary.LittleEndian.Uint64(data[4:12]))
+    if err != nil {
+        return nil, err
+    }
+    if want := amsHeaderSize + int(s.depth)*int(s.width)*8; len(data) != want {
+        return nil, fmt.Errorf("data length mismatch: expected %d, got %d", want, len(data))
+    }
+    s.items = binary.LittleEndian.Uint64(data[20:28])
+    off := amsHeaderSize
+    for _, row := range s.counters {
+        for j := range row {
+            row[j] = int64(binary.LittleEndian.Uint64(data[off:]))
+            off += 8
+        }
+    }
+    return s, nil
+}
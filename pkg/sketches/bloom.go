@@ -0,0 +1,260 @@
+package sketches
+
+import (
+    "bytes"
+    "encoding/binary"
+    "fmt"
+    "math"
+    "math/bits"
+    "strconv"
+    "strings"
+)
+
+// bloomMagic prefixes serialized Bloom filters.
+var bloomMagic = []byte{'A', 'Q', 'B', 1}
+
+// bloomHeaderSize is magic(4) + seed(8) + k(4) + m(8) + items(8) + fpr(8).
+const bloomHeaderSize = 40
+
+// MaxBloomBits caps a filter at 512 MiB of bits.
+const MaxBloomBits = 1 << 32
+
+// BloomFilter answers whether a key may be in a set: never no for a key
+// that was added, and yes for one that wasn't with about the false
+// positive rate it was sized for.
+type BloomFilter struct {
+    words []uint64
+    m     uint64  // bits
+    k     uint32  // hash functions
+    items uint64  // keys added
+    fpr   float64 // target false positive rate at the expected items
+    seed  uint64
+}
+
+// NewBloomFilter sizes a filter for expectedItems keys at false positive
+// rate fpr: m = -n ln p / (ln 2)^2 bits and k = m/n ln 2 hash functions.
+func NewBloomFilter(expectedItems uint64, fpr float64) (*BloomFilter, error) {
+    return NewBloomFilterWithSeed(expectedItems, fpr, 0)
+}
+
+// NewBloomFilterWithSeed sizes a filter like NewBloomFilter, hashing with
+// seeded xxHash64.
+func NewBloomFilterWithSeed(expectedItems uint64, fpr float64, seed uint64) (*BloomFilter, error) {
+    if fpr <= 0 || fpr >= 1 {
+        return nil, fmt.Errorf("bloom filter false positive rate must be in (0, 1), got %g", fpr)
+    }
+    n := float64(max(expectedItems, 1))
+    m := math.Ceil(-n * math.Log(fpr) / (math.Ln2 * math.Ln2))
+    if m > MaxBloomBits {
+        return nil, fmt.Errorf("bloom filter for %d items at %g false positives needs %.0f bits, over the %d limit",
+            expectedItems, fpr, m, uint64(MaxBloomBits))
+    }
+    m = math.Max(64, math.Ceil(m/64)*64)
+    k := uint32(math.Max(1, math.Round(m/n*math.Ln2)))
+    return &BloomFilter{
+        words: make([]uint64, uint64(m)/64),
+        m:     uint64(m),
+        k:     k,
+        fpr:   fpr,
+        seed:  seed,
+    }, nil
+}
+
+// HashKey hashes a key with this filter's seed, for AddHash and ContainsHash.
+func (bf *BloomFilter) HashKey(key []byte) uint64 {
+    return XXH64(key, bf.seed)
+}
+
+// Add inserts key.
+func (bf *BloomFilter) Add(key []byte) {
+    bf.AddHash(bf.HashKey(key))
+}
+
+// AddString is a convenience method for string keys
+func (bf *BloomFilter) AddString(key string) {
+    bf.Add([]byte(key))
+}
+
+// AddHash inserts a key hashed with HashKey, setting k bits by double
+// hashing the two halves of h.
+func (bf *BloomFilter) AddHash(h uint64) {
+    h1, h2 := h&0xffffffff, h>>32|1
+    for i := uint64(0); i < uint64(bf.k); i++ {
+        bit := (h1 + i*h2) % bf.m
+        bf.words[bit/64] |= 1 << (bit % 64)
+    }
+    bf.items++
+}
+
+// Contains reports whether key may have been added.
+func (bf *BloomFilter) Contains(key []byte) bool {
+    return bf.ContainsHash(bf.HashKey(key))
+}
+
+// ContainsString is a convenience method for string keys
+func (bf *BloomFilter) ContainsString(key string) bool {
+    return bf.Contains([]byte(key))
+}
+
+// ContainsHash reports whether a key hashed with HashKey may have been added.
+func (bf *BloomFilter) ContainsHash(h uint64) bool {
+    h1, h2 := h&0xffffffff, h>>32|1
+    for i := uint64(0); i < uint64(bf.k); i++ {
+        bit := (h1 + i*h2) % bf.m
+        if bf.words[bit/64]&(1<<(bit%64)) == 0 {
+            return false
+        }
+    }
+    return true
+}
+
+// AddValue inserts a value as database/sql reads it (see ValueKey); NULL
+// is not added.
+func (bf *BloomFilter) AddValue(v any) {
+    if key, ok := ValueKey(v); ok {
+        bf.Add(key)
+    }
+}
+
+// ContainsValue reports whether a value ValueKey reads may have been
+// added; NULL never was.
+func (bf *BloomFilter) ContainsValue(v any) bool {
+    key, ok := ValueKey(v)
+    return ok && bf.Contains(key)
+}
+
+// ValueKey is the bytes a SQL value is hashed as, so values SQL compares
+// equal hash alike: integers, integral reals and text that reads as either
+// become the same decimal integer, other numbers their shortest decimal
+// form, and other text and blobs their bytes. Distinct values may share a
+// key, which only adds false positives. ok is false for NULL.
+func ValueKey(v any) ([]byte, bool) {
+    switch x := v.(type) {
+    case nil:
+        return nil, false
+    case int64:
+        return strconv.AppendInt(nil, x, 10), true
+    case float64:
+        return numberKey(x), true
+    case bool:
+        if x {
+            return []byte{'1'}, true
+        }
+        return []byte{'0'}, true
+    case string:
+        return textKey([]byte(x)), true
+    case []byte:
+        return textKey(x), true
+    default:
+        return []byte(fmt.Sprint(x)), true
+    }
+}
+
+func numberKey(f float64) []byte {
+    if f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+        return strconv.AppendInt(nil, int64(f), 10)
+    }
+    return strconv.AppendFloat(nil, f, 'g', -1, 64)
+}
+
+func textKey(b []byte) []byte {
+    s := strings.TrimSpace(string(b))
+    if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+        return strconv.AppendInt(nil, n, 10)
+    }
+    if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+        return numberKey(f)
+    }
+    return b
+}
+
+// Items returns how many keys were added.
+func (bf *BloomFilter) Items() uint64 {
+    return bf.items
+}
+
+// Bits returns the filter's size in bits.
+func (bf *BloomFilter) Bits() uint64 {
+    return bf.m
+}
+
+// Hashes returns how many bits each key sets.
+func (bf *BloomFilter) Hashes() uint32 {
+    return bf.k
+}
+
+// TargetFPR returns the false positive rate the filter was sized for.
+func (bf *BloomFilter) TargetFPR() float64 {
+    return bf.fpr
+}
+
+// EstimatedFPR is the false positive rate at the filter's current fill:
+// a key that wasn't added passes when all k of its bits are set.
+func (bf *BloomFilter) EstimatedFPR() float64 {
+    set := 0
+    for _, w := range bf.words {
+        set += bits.OnesCount64(w)
+    }
+    return math.Pow(float64(set)/float64(bf.m), float64(bf.k))
+}
+
+// Merge adds other's keys to this filter (must have the same size, hashes
+// and seed).
+func (bf *BloomFilter) Merge(other *BloomFilter) error {
+    if bf.m != other.m || bf.k != other.k {
+        return fmt.Errorf("cannot merge Bloom filters with different parameters")
+    }
+    if bf.seed != other.seed {
+        return fmt.Errorf("cannot merge Bloom filters with different seeds")
+    }
+    for i, w := range other.words {
+        bf.words[i] |= w
+    }
+    bf.items += other.items
+    return nil
+}
+
+// Seed returns the hash seed
+func (bf *BloomFilter) Seed() uint64 {
+    return bf.seed
+}
+
+// Serialize returns the filter as bytes
+func (bf *BloomFilter) Serialize() []byte {
+    data := make([]byte, bloomHeaderSize+len(bf.words)*8)
+    copy(data[0:4], bloomMagic)
+    binary.LittleEndian.PutUint64(data[4:12], bf.seed)
+    binary.LittleEndian.PutUint32(data[12:16], bf.k)
+    binary.LittleEndian.PutUint64(data[16:24], bf.m)
+    binary.LittleEndian.PutUint64(data[24:32], bf.items)
+    binary.LittleEndian.PutUint64(data[32:40], math.Float64bits(bf.fpr))
+    for i, w := range bf.words {
+        binary.LittleEndian.PutUint64(data[bloomHeaderSize+i*8:], w)
+    }
+    return data
+}
+
+// DeserializeBloomFilter loads a filter from bytes
+func DeserializeBloomFilter(data []byte) (*BloomFilter, error) {
+    if len(data) < bloomHeaderSize || !bytes.Equal(data[0:4], bloomMagic) {
+        return nil, fmt.Errorf("not a serialized Bloom filter")
+    }
+    bf := &BloomFilter{
+        seed:  binary.LittleEndian.Uint64(data[4:12]),
+        k:     binary.LittleEndian.Uint32(data[12:16]),
+        m:     binary.LittleEndian.Uint64(data[16:24]),
+        items: binary.LittleEndian.Uint64(data[24:32]),
+        fpr:   math.Float64frombits(binary.LittleEndian.Uint64(data[32:40])),
+    }
+    if bf.k == 0 || bf.m == 0 || bf.m%64 != 0 || bf.m > MaxBloomBits {
+        return nil, fmt.Errorf("invalid Bloom filter header: %d bits, %d hashes", bf.m, bf.k)
+    }
+    if want := bloomHeaderSize + int(bf.m/8); len(data) != want {
+        return nil, fmt.Errorf("data length mismatch: expected %d, got %d", want, len(data))
+    }
+    bf.words = make([]uint64, bf.m/64)
+    for i := range bf.words {
+        bf.words[i] = binary.LittleEndian.Uint64(data[bloomHeaderSize+i*8:])
+    }
+    return bf, nil
+}
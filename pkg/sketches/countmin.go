@@ -1,12 +1,35 @@
 package sketches
 
 import (
+    "bytes"
     "encoding/binary"
     "fmt"
     "hash/fnv"
     "math"
+    "sort"
 )
 
+// cmsMagic prefixes versioned CMS serializations. Read as the legacy header's
+// depth it would be ~37M rows, so the two formats cannot be confused.
+// Version 3 appends the tracked keys after the counters.
+var cmsMagic = []byte{'A', 'Q', 'C', 2}
+var cmsKeyedMagic = []byte{'A', 'Q', 'C', 3}
+
+// DefaultTrackedKeys is how many distinct keys a key-tracking sketch
+// remembers before marking its key list incomplete.
+const DefaultTrackedKeys = 10000
+
+// maxCMSDepth and maxCMSWidth bound the table a serialized sketch may
+// declare: far beyond what NewCountMinSketch builds for any sensible
+// epsilon and delta, yet at most a 1 GiB table.
+const (
+    maxCMSDepth = 64
+    maxCMSWidth = 1 << 21
+)
+
+// cmsHeaderSize is magic(4) + hash algorithm(1) + seed(8) + the legacy 32-byte header.
+const cmsHeaderSize = 45
+
 // CountMinSketch implements the Count-Min Sketch for frequency estimation
 type CountMinSketch struct {
     table  [][]uint64 // count table[d][w]
@@ -15,12 +38,24 @@ type CountMinSketch struct {
     epsilon float64   // relative error bound
     delta   float64   // probability bound
     count   uint64    // total count of all items
+    hashAlgo HashAlgorithm
+    seed     uint64
+    // keys holds the distinct keys added when tracking is on (nil otherwise),
+    // up to keyLimit; keysTruncated records that some were dropped.
+    keys          map[string]struct{}
+    keyLimit      int
+    keysTruncated bool
 }
 
 // NewCountMinSketch creates a new Count-Min Sketch
 // epsilon: relative error bound (e.g., 0.01 for 1% error)
 // delta: probability bound (e.g., 0.01 for 99% confidence)
 func NewCountMinSketch(epsilon, delta float64) *CountMinSketch {
+    return NewCountMinSketchWithSeed(epsilon, delta, 0)
+}
+
+// NewCountMinSketchWithSeed creates a Count-Min Sketch hashing with seeded xxHash64
+func NewCountMinSketchWithSeed(epsilon, delta float64, seed uint64) *CountMinSketch {
     if epsilon <= 0 || epsilon >= 1 {
         epsilon = 0.01 // default 1% error
     }
@@ -45,11 +80,56 @@ func NewCountMinSketch(epsilon, delta float64) *CountMinSketch {
         epsilon: epsilon,
         delta:   delta,
         count:   0,
+        hashAlgo: HashXXH64,
+        seed:     seed,
+    }
+}
+
+// TrackKeys makes the sketch remember up to limit distinct keys, so the
+// groups it counts can be enumerated. Keys added before the call are not
+// known, so it must be called on an empty sketch.
+func (cms *CountMinSketch) TrackKeys(limit int) {
+    if limit <= 0 {
+        limit = DefaultTrackedKeys
     }
+    cms.keys = make(map[string]struct{})
+    cms.keyLimit = limit
+    cms.keysTruncated = cms.count > 0
+}
+
+// RecordKey remembers key if the sketch tracks keys. Add and AddBatch
+// record their keys; callers feeding AddHashes must record them here.
+func (cms *CountMinSketch) RecordKey(key []byte) {
+    if cms.keys == nil {
+        return
+    }
+    if _, ok := cms.keys[string(key)]; ok {
+        return
+    }
+    if len(cms.keys) >= cms.keyLimit {
+        cms.keysTruncated = true
+        return
+    }
+    cms.keys[string(key)] = struct{}{}
+}
+
+// Keys returns the tracked keys in sorted order and whether they are every
+// key the sketch counted. A sketch that doesn't track keys returns nil, false.
+func (cms *CountMinSketch) Keys() ([]string, bool) {
+    if cms.keys == nil {
+        return nil, false
+    }
+    keys := make([]string, 0, len(cms.keys))
+    for k := range cms.keys {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    return keys, !cms.keysTruncated
 }
 
 // Add increments the count for a key by delta
 func (cms *CountMinSketch) Add(key []byte, delta uint64) {
+    cms.RecordKey(key)
     hashes := cms.hash(key)
     
     for i := uint32(0); i < cms.d; i++ {
@@ -60,6 +140,77 @@ func (cms *CountMinSketch) Add(key []byte, delta uint64) {
     cms.count += delta
 }
 
+// AddBatch increments the counts for a batch of keys. deltas may be nil to
+// add 1 per key; otherwise it must be the same length as keys.
+func (cms *CountMinSketch) AddBatch(keys [][]byte, deltas []uint64) error {
+    if deltas != nil && len(deltas) != len(keys) {
+        return fmt.Errorf("keys and deltas length mismatch: %d vs %d", len(keys), len(deltas))
+    }
+
+    for _, key := range keys {
+        cms.RecordKey(key)
+    }
+
+    if cms.hashAlgo == HashFNV {
+        hashes := make([]uint32, cms.d)
+        for k, key := range keys {
+            delta := uint64(1)
+            if deltas != nil {
+                delta = deltas[k]
+            }
+            cms.legacyHash(key, hashes)
+            for i := uint32(0); i < cms.d; i++ {
+                cms.table[i][hashes[i]%cms.w] += delta
+            }
+            cms.count += delta
+        }
+        return nil
+    }
+
+    for k, key := range keys {
+        delta := uint64(1)
+        if deltas != nil {
+            delta = deltas[k]
+        }
+        cms.addHash(XXH64(key, cms.seed), delta)
+    }
+    return nil
+}
+
+// HashKey hashes a key with this sketch's hash function and seed, for
+// callers that pre-hash keys and feed them through AddHashes
+func (cms *CountMinSketch) HashKey(key []byte) uint64 {
+    return XXH64(key, cms.seed)
+}
+
+// AddHashes increments the counts for a batch of pre-hashed keys (see
+// HashKey). Legacy FNV sketches hash per row and cannot accept hashes.
+func (cms *CountMinSketch) AddHashes(hashes []uint64, deltas []uint64) error {
+    if cms.hashAlgo == HashFNV {
+        return fmt.Errorf("legacy FNV count-min sketch does not support pre-hashed keys")
+    }
+    if deltas != nil && len(deltas) != len(hashes) {
+        return fmt.Errorf("hashes and deltas length mismatch: %d vs %d", len(hashes), len(deltas))
+    }
+    for k, h := range hashes {
+        delta := uint64(1)
+        if deltas != nil {
+            delta = deltas[k]
+        }
+        cms.addHash(h, delta)
+    }
+    return nil
+}
+
+// addHash applies one double-hashed update without allocating
+func (cms *CountMinSketch) addHash(h uint64, delta uint64) {
+    h1, h2 := uint32(h), uint32(h>>32)
+    for i := uint32(0); i < cms.d; i++ {
+        cms.table[i][(h1+i*h2)%cms.w] += delta
+    }
+    cms.count += delta
+}
+
 // AddString is a convenience method for string keys
 func (cms *CountMinSketch) AddString(key string, delta uint64) {
     cms.Add([]byte(key), delta)
@@ -101,8 +252,8 @@ func (cms *CountMinSketch) Confidence() float64 {
     return 1.0 - cms.delta
 }
 
-// HeavyHitters returns keys with estimated count > threshold
-// Note: This is a simplified version - production would need key tracking
+// HeavyHitters returns the counters above threshold, not their keys; a
+// TopKSketch tracks the most frequent keys themselves.
 func (cms *CountMinSketch) HeavyHitters(threshold uint64) []uint64 {
     var heavyHitters []uint64
     
@@ -128,6 +279,9 @@ func (cms *CountMinSketch) Merge(other *CountMinSketch) error {
     if cms.d != other.d || cms.w != other.w {
         return fmt.Errorf("cannot merge CMS with different parameters")
     }
+    if cms.hashAlgo != other.hashAlgo || cms.seed != other.seed {
+        return fmt.Errorf("cannot merge CMS with different hash functions or seeds")
+    }
     
     for i := uint32(0); i < cms.d; i++ {
         for j := uint32(0); j < cms.w; j++ {
@@ -136,38 +290,92 @@ func (cms *CountMinSketch) Merge(other *CountMinSketch) error {
     }
     
     cms.count += other.count
+
+    // the merged key list is only complete if both sides tracked theirs
+    if cms.keys != nil {
+        if other.keys == nil {
+            cms.keysTruncated = true
+        } else {
+            cms.keysTruncated = cms.keysTruncated || other.keysTruncated
+            for k := range other.keys {
+                cms.RecordKey([]byte(k))
+            }
+        }
+    }
     return nil
 }
 
-// Serialize returns the CMS state as bytes
+// Seed returns the hash seed
+func (cms *CountMinSketch) Seed() uint64 {
+    return cms.seed
+}
+
+// HashAlgorithm returns the hash function the sketch was built with
+func (cms *CountMinSketch) HashAlgorithm() HashAlgorithm {
+    return cms.hashAlgo
+}
+
+// Serialize returns the CMS state as bytes in the versioned format
 func (cms *CountMinSketch) Serialize() []byte {
-    // Header: d(4) + w(4) + epsilon(8) + delta(8) + count(8) = 32 bytes
+    // Header: magic(4) + algo(1) + seed(8) + d(4) + w(4) + epsilon(8) + delta(8) + count(8) = 45 bytes
     // Data: d * w * 8 bytes for uint64 values
-    headerSize := 32
-    dataSize := int(cms.d * cms.w * 8)
-    data := make([]byte, headerSize+dataSize)
+    // Keys (version 3): limit(4) + truncated(1) + n(4) + n * (len(4) + key)
+    dataSize := int(cms.d) * int(cms.w) * 8
+    data := make([]byte, cmsHeaderSize+dataSize)
     
     // Write header
-    binary.LittleEndian.PutUint32(data[0:4], cms.d)
-    binary.LittleEndian.PutUint32(data[4:8], cms.w)
-    binary.LittleEndian.PutUint64(data[8:16], math.Float64bits(cms.epsilon))
-    binary.LittleEndian.PutUint64(data[16:24], math.Float64bits(cms.delta))
-    binary.LittleEndian.PutUint64(data[24:32], cms.count)
+    copy(data[0:4], cmsMagic)
+    if cms.keys != nil {
+        copy(data[0:4], cmsKeyedMagic)
+    }
+    data[4] = byte(cms.hashAlgo)
+    binary.LittleEndian.PutUint64(data[5:13], cms.seed)
+    binary.LittleEndian.PutUint32(data[13:17], cms.d)
+    binary.LittleEndian.PutUint32(data[17:21], cms.w)
+    binary.LittleEndian.PutUint64(data[21:29], math.Float64bits(cms.epsilon))
+    binary.LittleEndian.PutUint64(data[29:37], math.Float64bits(cms.delta))
+    binary.LittleEndian.PutUint64(data[37:45], cms.count)
     
     // Write table data
-    offset := headerSize
+    offset := cmsHeaderSize
     for i := uint32(0); i < cms.d; i++ {
         for j := uint32(0); j < cms.w; j++ {
             binary.LittleEndian.PutUint64(data[offset:offset+8], cms.table[i][j])
             offset += 8
         }
     }
+
+    if cms.keys != nil {
+        keys, _ := cms.Keys()
+        data = binary.LittleEndian.AppendUint32(data, uint32(cms.keyLimit))
+        truncated := byte(0)
+        if cms.keysTruncated {
+            truncated = 1
+        }
+        data = append(data, truncated)
+        data = binary.LittleEndian.AppendUint32(data, uint32(len(keys)))
+        for _, k := range keys {
+            data = binary.LittleEndian.AppendUint32(data, uint32(len(k)))
+            data = append(data, k...)
+        }
+    }
     
     return data
 }
 
-// DeserializeCountMinSketch loads CMS state from bytes
+// DeserializeCountMinSketch loads CMS state from bytes, accepting both the
+// versioned format and the legacy unversioned FNV format
 func DeserializeCountMinSketch(data []byte) (*CountMinSketch, error) {
+    algo := HashFNV
+    var seed uint64
+    keyed := false
+    if len(data) >= cmsHeaderSize && (bytes.Equal(data[0:4], cmsMagic) || bytes.Equal(data[0:4], cmsKeyedMagic)) {
+        keyed = data[3] == cmsKeyedMagic[3]
+        algo = HashAlgorithm(data[4])
+        seed = binary.LittleEndian.Uint64(data[5:13])
+        data = data[13:]
+    }
+
     if len(data) < 32 {
         return nil, fmt.Errorf("insufficient data for CMS deserialization")
     }
@@ -179,10 +387,18 @@ func DeserializeCountMinSketch(data []byte) (*CountMinSketch, error) {
     delta := math.Float64frombits(binary.LittleEndian.Uint64(data[16:24]))
     count := binary.LittleEndian.Uint64(data[24:32])
     
-    expectedSize := 32 + int(d*w*8)
-    if len(data) != expectedSize {
-        return nil, fmt.Errorf("data length mismatch: expected %d, got %d", expectedSize, len(data))
+    if d == 0 || d > maxCMSDepth || w == 0 || w > maxCMSWidth {
+        return nil, fmt.Errorf("invalid CMS dimensions %dx%d", d, w)
     }
+    if algo != HashFNV && algo != HashXXH64 {
+        return nil, fmt.Errorf("unknown CMS hash algorithm %d", algo)
+    }
+    // checked in int64 before anything is allocated for the table
+    size := 32 + int64(d)*int64(w)*8
+    if int64(len(data)) != size && !(keyed && int64(len(data)) > size) {
+        return nil, fmt.Errorf("data length mismatch: expected %d, got %d", size, len(data))
+    }
+    expectedSize := int(size)
     
     // Create CMS
     cms := &CountMinSketch{
@@ -192,6 +408,8 @@ func DeserializeCountMinSketch(data []byte) (*CountMinSketch, error) {
         epsilon: epsilon,
         delta:   delta,
         count:   count,
+        hashAlgo: algo,
+        seed:     seed,
     }
     
     for i := range cms.table {
@@ -206,27 +424,75 @@ func DeserializeCountMinSketch(data []byte) (*CountMinSketch, error) {
             offset += 8
         }
     }
+
+    if keyed {
+        if err := cms.readKeys(data[expectedSize:]); err != nil {
+            return nil, err
+        }
+    }
     
     return cms, nil
 }
 
+// readKeys decodes the version 3 key section.
+func (cms *CountMinSketch) readKeys(data []byte) error {
+    if len(data) < 9 {
+        return fmt.Errorf("insufficient data for CMS keys")
+    }
+    cms.keyLimit = int(binary.LittleEndian.Uint32(data[0:4]))
+    cms.keysTruncated = data[4] != 0
+    n := binary.LittleEndian.Uint32(data[5:9])
+    data = data[9:]
+    // each key takes at least its 4-byte length
+    if int64(n) > int64(len(data))/4 {
+        return fmt.Errorf("CMS declares %d keys in %d bytes", n, len(data))
+    }
+    cms.keys = make(map[string]struct{}, n)
+    for i := uint32(0); i < n; i++ {
+        if len(data) < 4 {
+            return fmt.Errorf("truncated CMS key %d", i)
+        }
+        size := int64(binary.LittleEndian.Uint32(data[0:4]))
+        if int64(len(data)) < 4+size {
+            return fmt.Errorf("truncated CMS key %d", i)
+        }
+        cms.keys[string(data[4:4+size])] = struct{}{}
+        data = data[4+size:]
+    }
+    if len(data) != 0 {
+        return fmt.Errorf("trailing data after CMS keys: %d bytes", len(data))
+    }
+    return nil
+}
+
 // hash generates d independent hash values for a key
 func (cms *CountMinSketch) hash(key []byte) []uint32 {
     hashes := make([]uint32, cms.d)
-    
-    // Use FNV-1a as base hash
+
+    if cms.hashAlgo == HashFNV {
+        return cms.legacyHash(key, hashes)
+    }
+
+    // Kirsch-Mitzenmacher double hashing from one seeded 64-bit hash
+    h := XXH64(key, cms.seed)
+    h1, h2 := uint32(h), uint32(h>>32)
+    for i := uint32(0); i < cms.d; i++ {
+        hashes[i] = h1 + i*h2
+    }
+
+    return hashes
+}
+
+// legacyHash reproduces the salted FNV-32a hashing of unversioned sketches
+func (cms *CountMinSketch) legacyHash(key []byte, hashes []uint32) []uint32 {
     h := fnv.New32a()
-    
-    // Generate independent hashes using double hashing
+    salt := make([]byte, 4)
     for i := uint32(0); i < cms.d; i++ {
         h.Reset()
         h.Write(key)
-        // Add salt based on row index
-        salt := make([]byte, 4)
         binary.LittleEndian.PutUint32(salt, i)
         h.Write(salt)
         hashes[i] = h.Sum32()
     }
-    
     return hashes
-}
\ No newline at end of file
+}
@@ -0,0 +1,99 @@
+package sketches
+
+import (
+    "encoding/binary"
+    "hash/fnv"
+    "math/bits"
+)
+
+// HashAlgorithm identifies the hash function a sketch was built with. It is
+// stored in the serialized header so sketches are only merged or queried with
+// the hash they were built with.
+type HashAlgorithm uint8
+
+const (
+    // HashFNV is the original FNV-1a hashing used by unversioned sketches.
+    HashFNV HashAlgorithm = 0
+    // HashXXH64 is seeded xxHash64, the default for new sketches.
+    HashXXH64 HashAlgorithm = 1
+)
+
+const (
+    xxPrime1 uint64 = 11400714785074694791
+    xxPrime2 uint64 = 14029467366897019727
+    xxPrime3 uint64 = 1609587929392839161
+    xxPrime4 uint64 = 9650029242287828579
+    xxPrime5 uint64 = 2870177450012600261
+)
+
+// XXH64 computes the 64-bit xxHash of b with the given seed.
+func XXH64(b []byte, seed uint64) uint64 {
+    n := len(b)
+    var h uint64
+
+    if n >= 32 {
+        v1 := seed + xxPrime1 + xxPrime2
+        v2 := seed + xxPrime2
+        v3 := seed
+        v4 := seed - xxPrime1
+        for len(b) >= 32 {
+            v1 = xxRound(v1, binary.LittleEndian.Uint64(b[0:8]))
+            v2 = xxRound(v2, binary.LittleEndian.Uint64(b[8:16]))
+            v3 = xxRound(v3, binary.LittleEndian.Uint64(b[16:24]))
+            v4 = xxRound(v4, binary.LittleEndian.Uint64(b[24:32]))
+            b = b[32:]
+        }
+        h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) +
+            bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+        h = xxMergeRound(h, v1)
+        h = xxMergeRound(h, v2)
+        h = xxMergeRound(h, v3)
+        h = xxMergeRound(h, v4)
+    } else {
+        h = seed + xxPrime5
+    }
+
+    h += uint64(n)
+
+    for ; len(b) >= 8; b = b[8:] {
+        h ^= xxRound(0, binary.LittleEndian.Uint64(b[:8]))
+        h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+    }
+    if len(b) >= 4 {
+        h ^= uint64(binary.LittleEndian.Uint32(b[:4])) * xxPrime1
+        h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+        b = b[4:]
+    }
+    for ; len(b) > 0; b = b[1:] {
+        h ^= uint64(b[0]) * xxPrime5
+        h = bits.RotateLeft64(h, 11) * xxPrime1
+    }
+
+    h ^= h >> 33
+    h *= xxPrime2
+    h ^= h >> 29
+    h *= xxPrime3
+    h ^= h >> 32
+    return h
+}
+
+func xxRound(acc, input uint64) uint64 {
+    acc += input * xxPrime2
+    acc = bits.RotateLeft64(acc, 31)
+    return acc * xxPrime1
+}
+
+func xxMergeRound(acc, val uint64) uint64 {
+    acc ^= xxRound(0, val)
+    return acc*xxPrime1 + xxPrime4
+}
+
+// hashWith returns the 64-bit hash of data under the given algorithm and seed.
+func hashWith(algo HashAlgorithm, seed uint64, data []byte) uint64 {
+    if algo == HashFNV {
+        h := fnv.New64a()
+        h.Write(data)
+        return h.Sum64()
+    }
+    return XXH64(data, seed)
+}
@@ -0,0 +1,51 @@
+package sketches
+
+import (
+    "strconv"
+    "testing"
+)
+
+// benchKeys are column values of the length sketches typically ingest.
+func benchKeys() [][]byte {
+    keys := make([][]byte, 4096)
+    for i := range keys {
+        keys[i] = []byte("customer-" + strconv.Itoa(i*7919))
+    }
+    return keys
+}
+
+// hashPaths are the legacy FNV-1a hashing and the xxHash64 default.
+var hashPaths = []struct {
+    name string
+    algo HashAlgorithm
+}{{"fnv", HashFNV}, {"xxh64", HashXXH64}}
+
+func BenchmarkHyperLogLogAdd(b *testing.B) {
+    keys := benchKeys()
+    for _, path := range hashPaths {
+        b.Run(path.name, func(b *testing.B) {
+            hll := NewHyperLogLog(14)
+            hll.hashAlgo = path.algo
+            b.ReportAllocs()
+            b.ResetTimer()
+            for i := 0; i < b.N; i++ {
+                hll.Add(keys[i%len(keys)])
+            }
+        })
+    }
+}
+
+func BenchmarkCountMinAdd(b *testing.B) {
+    keys := benchKeys()
+    for _, path := range hashPaths {
+        b.Run(path.name, func(b *testing.B) {
+            cms := NewCountMinSketch(0.001, 0.01)
+            cms.hashAlgo = path.algo
+            b.ReportAllocs()
+            b.ResetTimer()
+            for i := 0; i < b.N; i++ {
+                cms.Add(keys[i%len(keys)], 1)
+            }
+        })
+    }
+}
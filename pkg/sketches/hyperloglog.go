@@ -1,23 +1,38 @@
 package sketches
 
 import (
+    "bytes"
     "encoding/binary"
     "fmt"
-    "hash/fnv"
     "math"
+    "math/bits"
 )
 
+// hllMagic prefixes versioned HLL serializations. Unversioned (legacy) data
+// starts with the precision byte b, which is always < 'A'.
+var hllMagic = []byte{'A', 'Q', 'H', 2}
+
+// hllHeaderSize is magic(4) + hash algorithm(1) + seed(8) + b(1) + m(4).
+const hllHeaderSize = 18
+
 // HyperLogLog implements the HyperLogLog algorithm for cardinality estimation
 type HyperLogLog struct {
     registers []uint8
     b         uint8    // number of bits for register selection (m = 2^b)
     m         uint32   // number of registers
     alpha     float64  // bias correction constant
+    hashAlgo  HashAlgorithm
+    seed      uint64
 }
 
 // NewHyperLogLog creates a new HyperLogLog with 2^b registers
 // Standard values: b=10 (1024 registers), b=12 (4096 registers)
 func NewHyperLogLog(b uint8) *HyperLogLog {
+    return NewHyperLogLogWithSeed(b, 0)
+}
+
+// NewHyperLogLogWithSeed creates a HyperLogLog hashing with seeded xxHash64
+func NewHyperLogLogWithSeed(b uint8, seed uint64) *HyperLogLog {
     if b < 4 || b > 16 {
         b = 10 // default to 1024 registers
     }
@@ -44,32 +59,64 @@ func NewHyperLogLog(b uint8) *HyperLogLog {
         b:         b,
         m:         m,
         alpha:     alpha,
+        hashAlgo:  HashXXH64,
+        seed:      seed,
     }
 }
 
 // Add adds a value to the HyperLogLog
 func (hll *HyperLogLog) Add(value []byte) {
-    hash := hash64(value)
-    
-    // Use first b bits for register selection
-    j := hash & ((1 << hll.b) - 1)
-    
-    // Use remaining bits for leading zero count
+    hll.AddHash(hashWith(hll.hashAlgo, hll.seed, value))
+}
+
+// HashValue hashes a value with this sketch's hash function and seed, for
+// callers that pre-hash values and feed them through AddHashes
+func (hll *HyperLogLog) HashValue(value []byte) uint64 {
+    return hashWith(hll.hashAlgo, hll.seed, value)
+}
+
+// AddHash adds an already-hashed value
+func (hll *HyperLogLog) AddHash(hash uint64) {
+    mask := uint64(1)<<hll.b - 1
+    maxRank := 65 - hll.b
+    hll.updateRegister(hash, mask, maxRank)
+}
+
+// AddHashes adds a batch of already-hashed values, hoisting the per-call
+// setup out of the register update loop
+func (hll *HyperLogLog) AddHashes(hashes []uint64) {
+    mask := uint64(1)<<hll.b - 1
+    maxRank := 65 - hll.b
+    for _, hash := range hashes {
+        hll.updateRegister(hash, mask, maxRank)
+    }
+}
+
+// AddBatch hashes and adds a batch of values
+func (hll *HyperLogLog) AddBatch(values [][]byte) {
+    mask := uint64(1)<<hll.b - 1
+    maxRank := 65 - hll.b
+    for _, v := range values {
+        hll.updateRegister(hashWith(hll.hashAlgo, hll.seed, v), mask, maxRank)
+    }
+}
+
+// updateRegister uses the low b bits of hash for register selection and the
+// position of the lowest set bit in the remainder as the rank
+func (hll *HyperLogLog) updateRegister(hash, mask uint64, maxRank uint8) {
+    j := hash & mask
     w := hash >> hll.b
-    
-    // Count leading zeros in w + 1
-    leadingZeros := uint8(1)
-    for w > 0 && leadingZeros <= 64-hll.b {
-        if w&1 == 1 {
-            break
+
+    rank := uint8(1)
+    if w != 0 {
+        rank = uint8(bits.TrailingZeros64(w)) + 1
+        if rank > maxRank {
+            rank = maxRank
         }
-        leadingZeros++
-        w >>= 1
     }
-    
-    // Update register with maximum leading zero count
-    if leadingZeros > hll.registers[j] {
-        hll.registers[j] = leadingZeros
+
+    if rank > hll.registers[j] {
+        hll.registers[j] = rank
     }
 }
 
@@ -118,6 +165,8 @@ func (hll *HyperLogLog) ConfidenceInterval(confidence float64) (uint64, uint64)
         z = 1.96
     case math.Abs(confidence-0.99) < 1e-9:
         z = 2.576
+    case confidence > 0 && confidence < 1:
+        z = math.Sqrt2 * math.Erfinv(confidence)
     default:
         z = 1.96 // default to 95%
     }
@@ -134,6 +183,9 @@ func (hll *HyperLogLog) Merge(other *HyperLogLog) error {
     if hll.m != other.m || hll.b != other.b {
         return fmt.Errorf("cannot merge HLLs with different parameters")
     }
+    if hll.hashAlgo != other.hashAlgo || hll.seed != other.seed {
+        return fmt.Errorf("cannot merge HLLs with different hash functions or seeds")
+    }
     
     for i := uint32(0); i < hll.m; i++ {
         if other.registers[i] > hll.registers[i] {
@@ -144,42 +196,88 @@ func (hll *HyperLogLog) Merge(other *HyperLogLog) error {
     return nil
 }
 
-// Serialize returns the HLL state as bytes
+// Seed returns the hash seed
+func (hll *HyperLogLog) Seed() uint64 {
+    return hll.seed
+}
+
+// HashAlgorithm returns the hash function the sketch was built with
+func (hll *HyperLogLog) HashAlgorithm() HashAlgorithm {
+    return hll.hashAlgo
+}
+
+// Serialize returns the HLL state as bytes in the versioned format
 func (hll *HyperLogLog) Serialize() []byte {
-    data := make([]byte, 5+len(hll.registers))
-    data[0] = hll.b
-    binary.LittleEndian.PutUint32(data[1:5], hll.m)
-    copy(data[5:], hll.registers)
+    data := make([]byte, hllHeaderSize+len(hll.registers))
+    copy(data[0:4], hllMagic)
+    data[4] = byte(hll.hashAlgo)
+    binary.LittleEndian.PutUint64(data[5:13], hll.seed)
+    data[13] = hll.b
+    binary.LittleEndian.PutUint32(data[14:18], hll.m)
+    copy(data[hllHeaderSize:], hll.registers)
     return data
 }
 
-// Deserialize loads HLL state from bytes
+// Deserialize loads HLL state from bytes, accepting both the versioned
+// format and the legacy unversioned FNV format
 func DeserializeHyperLogLog(data []byte) (*HyperLogLog, error) {
+    if len(data) >= hllHeaderSize && bytes.Equal(data[0:4], hllMagic) {
+        algo := HashAlgorithm(data[4])
+        seed := binary.LittleEndian.Uint64(data[5:13])
+        b := data[13]
+        m := binary.LittleEndian.Uint32(data[14:18])
+        if err := checkHLLPrecision(b, m); err != nil {
+            return nil, err
+        }
+        if algo != HashFNV && algo != HashXXH64 {
+            return nil, fmt.Errorf("unknown HLL hash algorithm %d", algo)
+        }
+        if int64(len(data)) != hllHeaderSize+int64(m) {
+            return nil, fmt.Errorf("data length mismatch")
+        }
+
+        hll := NewHyperLogLogWithSeed(b, seed)
+        hll.hashAlgo = algo
+        copy(hll.registers, data[hllHeaderSize:])
+        return hll, nil
+    }
+
     if len(data) < 5 {
         return nil, fmt.Errorf("insufficient data for HLL deserialization")
     }
     
     b := data[0]
     m := binary.LittleEndian.Uint32(data[1:5])
+    if err := checkHLLPrecision(b, m); err != nil {
+        return nil, err
+    }
     
-    if len(data) != int(5+m) {
+    if int64(len(data)) != 5+int64(m) {
         return nil, fmt.Errorf("data length mismatch")
     }
     
     hll := NewHyperLogLog(b)
+    hll.hashAlgo = HashFNV
     copy(hll.registers, data[5:])
     
     return hll, nil
 }
 
-// Helper functions
-
-func hash64(data []byte) uint64 {
-    h := fnv.New64a()
-    h.Write(data)
-    return h.Sum64()
+// checkHLLPrecision rejects a serialized precision b outside the 4..16
+// NewHyperLogLog accepts, which it would quietly replace, and a register
+// count m other than 2^b.
+func checkHLLPrecision(b uint8, m uint32) error {
+    if b < 4 || b > 16 {
+        return fmt.Errorf("invalid HLL precision %d", b)
+    }
+    if m != 1<<b {
+        return fmt.Errorf("HLL precision %d with %d registers", b, m)
+    }
+    return nil
 }
 
+// Helper functions
+
 func (hll *HyperLogLog) harmonicMean() float64 {
     sum := 0.0
     for _, reg := range hll.registers {
@@ -0,0 +1,76 @@
+package sketches
+
+import (
+    "encoding/binary"
+    "testing"
+)
+
+func TestDeserializeCountMinSketchRoundTrip(t *testing.T) {
+    cms := NewCountMinSketch(0.01, 0.01)
+    cms.TrackKeys(8)
+    cms.Add([]byte("a"), 3)
+    got, err := DeserializeCountMinSketch(cms.Serialize())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n := got.Query([]byte("a")); n != 3 {
+        t.Fatalf("Query(a) = %d after a round trip, want 3", n)
+    }
+}
+
+func TestDeserializeCountMinSketchBounds(t *testing.T) {
+    for _, dims := range []struct{ d, w uint32 }{
+        {0, 100},
+        {5, 0},
+        {maxCMSDepth + 1, 100},
+        {5, maxCMSWidth + 1},
+        // d*w*8 wraps to 0 in uint32
+        {1 << 16, 1 << 13},
+    } {
+        data := NewCountMinSketch(0.01, 0.01).Serialize()
+        binary.LittleEndian.PutUint32(data[13:17], dims.d)
+        binary.LittleEndian.PutUint32(data[17:21], dims.w)
+        if _, err := DeserializeCountMinSketch(data); err == nil {
+            t.Errorf("%dx%d sketch deserialized", dims.d, dims.w)
+        }
+    }
+
+    // a keyed sketch claiming more keys than its bytes could hold
+    cms := NewCountMinSketch(0.01, 0.01)
+    cms.TrackKeys(8)
+    data := cms.Serialize()
+    keys := cmsHeaderSize + int(cms.d)*int(cms.w)*8
+    binary.LittleEndian.PutUint32(data[keys+5:keys+9], 1<<31)
+    if _, err := DeserializeCountMinSketch(data); err == nil {
+        t.Error("sketch with 2^31 declared keys deserialized")
+    }
+}
+
+func TestDeserializeHyperLogLogBounds(t *testing.T) {
+    hll := NewHyperLogLog(12)
+    hll.Add([]byte("a"))
+    if _, err := DeserializeHyperLogLog(hll.Serialize()); err != nil {
+        t.Fatalf("round trip: %v", err)
+    }
+
+    for _, b := range []uint8{0, 3, 17, 255} {
+        data := hll.Serialize()
+        data[13] = b
+        if _, err := DeserializeHyperLogLog(data); err == nil {
+            t.Errorf("precision %d deserialized", b)
+        }
+    }
+    data := hll.Serialize()
+    binary.LittleEndian.PutUint32(data[14:18], 1<<11)
+    if _, err := DeserializeHyperLogLog(data); err == nil {
+        t.Error("precision 12 with 2^11 registers deserialized")
+    }
+
+    // legacy layout: precision(1) + registers(4) + registers
+    legacy := make([]byte, 5)
+    legacy[0] = 20
+    binary.LittleEndian.PutUint32(legacy[1:5], 0xFFFFFFFF)
+    if _, err := DeserializeHyperLogLog(legacy); err == nil {
+        t.Error("legacy sketch with precision 20 deserialized")
+    }
+}
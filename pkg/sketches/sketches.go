@@ -7,6 +7,10 @@ type SketchType string
 const (
     HyperLogLogType   SketchType = "hyperloglog"
     CountMinSketchType SketchType = "countmin"
+    TDigestType        SketchType = "tdigest"
+    BloomFilterType    SketchType = "bloom"
+    AMSSketchType      SketchType = "ams"
+    TopKSketchType     SketchType = "topk"
 )
 
 // SketchInfo contains metadata about a sketch
@@ -63,6 +67,10 @@ type FrequencySketch interface {
 // Ensure implementations satisfy interfaces
 var _ CardinalitySketch = (*HyperLogLog)(nil)
 var _ FrequencySketch = (*CountMinSketch)(nil)
+var _ Sketch = (*TDigest)(nil)
+var _ Sketch = (*BloomFilter)(nil)
+var _ Sketch = (*AMSSketch)(nil)
+var _ Sketch = (*TopKSketch)(nil)
 
 // Type implementations
 func (hll *HyperLogLog) Type() SketchType {
@@ -71,4 +79,20 @@ func (hll *HyperLogLog) Type() SketchType {
 
 func (cms *CountMinSketch) Type() SketchType {
     return CountMinSketchType
-}
\ No newline at end of file
+}
+
+func (td *TDigest) Type() SketchType {
+    return TDigestType
+}
+
+func (bf *BloomFilter) Type() SketchType {
+    return BloomFilterType
+}
+
+func (s *AMSSketch) Type() SketchType {
+    return AMSSketchType
+}
+
+func (s *TopKSketch) Type() SketchType {
+    return TopKSketchType
+}
@@ -0,0 +1,233 @@
+package sketches
+
+import (
+    "bytes"
+    "encoding/binary"
+    "fmt"
+    "math"
+    "sort"
+)
+
+// tdigestMagic prefixes serialized t-digests.
+var tdigestMagic = []byte{'A', 'Q', 'T', 1}
+
+// tdigestHeaderSize is magic(4) + compression(8) + count(8) + min(8) + max(8) + sum(8) + sumSq(8) + centroids(4).
+const tdigestHeaderSize = 56
+
+type centroid struct {
+    mean   float64
+    weight float64
+}
+
+// TDigest summarizes a distribution of float64 values in a bounded number
+// of centroids, keeping tail quantiles accurate. It also tracks exact sum
+// and sum of squares so mean and variance survive compaction.
+type TDigest struct {
+    compression float64
+    centroids   []centroid // sorted by mean once compressed
+    buffer      []float64  // values added since the last compression
+    count       float64
+    min, max    float64
+    sum, sumSq  float64
+}
+
+// NewTDigest creates a t-digest; compression bounds the centroid count
+// (e.g., 100 keeps roughly 100-200 centroids).
+func NewTDigest(compression float64) *TDigest {
+    if compression <= 0 {
+        compression = 100
+    }
+    return &TDigest{compression: compression, min: math.Inf(1), max: math.Inf(-1)}
+}
+
+// Add records one value.
+func (td *TDigest) Add(x float64) {
+    if math.IsNaN(x) || math.IsInf(x, 0) {
+        return
+    }
+    td.buffer = append(td.buffer, x)
+    td.count++
+    td.sum += x
+    td.sumSq += x * x
+    td.min = math.Min(td.min, x)
+    td.max = math.Max(td.max, x)
+    if len(td.buffer) >= int(td.compression)*4 {
+        td.compress()
+    }
+}
+
+// Merge folds other into td.
+func (td *TDigest) Merge(other *TDigest) {
+    if other == nil || other.count == 0 {
+        return
+    }
+    other.compress()
+    td.compress()
+    td.centroids = append(td.centroids, other.centroids...)
+    td.count += other.count
+    td.sum += other.sum
+    td.sumSq += other.sumSq
+    td.min = math.Min(td.min, other.min)
+    td.max = math.Max(td.max, other.max)
+    td.recluster()
+}
+
+// compress folds the buffer into the centroid list.
+func (td *TDigest) compress() {
+    if len(td.buffer) == 0 {
+        return
+    }
+    for _, x := range td.buffer {
+        td.centroids = append(td.centroids, centroid{mean: x, weight: 1})
+    }
+    td.buffer = td.buffer[:0]
+    td.recluster()
+}
+
+// recluster merges adjacent centroids while each stays under the size bound
+// 4·n·q·(1-q)/compression, which keeps centroids small near the tails.
+func (td *TDigest) recluster() {
+    sort.Slice(td.centroids, func(i, j int) bool { return td.centroids[i].mean < td.centroids[j].mean })
+    if len(td.centroids) == 0 {
+        return
+    }
+    total := 0.0
+    for _, c := range td.centroids {
+        total += c.weight
+    }
+
+    merged := td.centroids[:1]
+    seen := 0.0
+    for _, c := range td.centroids[1:] {
+        last := &merged[len(merged)-1]
+        q := (seen + last.weight + c.weight/2) / total
+        limit := 4 * total * q * (1 - q) / td.compression
+        if last.weight+c.weight <= math.Max(limit, 1) {
+            w := last.weight + c.weight
+            last.mean += (c.mean - last.mean) * c.weight / w
+            last.weight = w
+            continue
+        }
+        seen += last.weight
+        merged = append(merged, c)
+    }
+    td.centroids = merged
+}
+
+// Count returns the number of values added.
+func (td *TDigest) Count() uint64 {
+    return uint64(td.count)
+}
+
+// Mean returns the exact mean of the added values.
+func (td *TDigest) Mean() float64 {
+    if td.count == 0 {
+        return 0
+    }
+    return td.sum / td.count
+}
+
+// Variance returns the exact sample variance of the added values.
+func (td *TDigest) Variance() float64 {
+    if td.count < 2 {
+        return 0
+    }
+    mean := td.sum / td.count
+    v := (td.sumSq - td.count*mean*mean) / (td.count - 1)
+    return math.Max(v, 0)
+}
+
+// Quantile estimates the q-th quantile (0 <= q <= 1) by interpolating
+// between centroid means.
+func (td *TDigest) Quantile(q float64) float64 {
+    td.compress()
+    if len(td.centroids) == 0 {
+        return 0
+    }
+    if q <= 0 {
+        return td.min
+    }
+    if q >= 1 {
+        return td.max
+    }
+
+    target := q * td.count
+    seen := 0.0
+    prevMean, prevMid := td.min, 0.0
+    for _, c := range td.centroids {
+        mid := seen + c.weight/2
+        if target < mid {
+            if mid == prevMid {
+                return c.mean
+            }
+            return prevMean + (c.mean-prevMean)*(target-prevMid)/(mid-prevMid)
+        }
+        prevMean, prevMid = c.mean, mid
+        seen += c.weight
+    }
+    if td.count == prevMid {
+        return td.max
+    }
+    return prevMean + (td.max-prevMean)*(target-prevMid)/(td.count-prevMid)
+}
+
+// RankError bounds how far, as a fraction of the count, the rank of
+// Quantile(q) may be from q: half the largest centroid allowed at q, and
+// never less than half a value.
+func (td *TDigest) RankError(q float64) float64 {
+    td.compress()
+    if td.count == 0 {
+        return 0
+    }
+    return math.Max(2*q*(1-q)/td.compression, 0.5/td.count)
+}
+
+// Serialize returns the t-digest state as bytes.
+func (td *TDigest) Serialize() []byte {
+    td.compress()
+    data := make([]byte, tdigestHeaderSize+len(td.centroids)*16)
+    copy(data[0:4], tdigestMagic)
+    binary.LittleEndian.PutUint64(data[4:12], math.Float64bits(td.compression))
+    binary.LittleEndian.PutUint64(data[12:20], math.Float64bits(td.count))
+    binary.LittleEndian.PutUint64(data[20:28], math.Float64bits(td.min))
+    binary.LittleEndian.PutUint64(data[28:36], math.Float64bits(td.max))
+    binary.LittleEndian.PutUint64(data[36:44], math.Float64bits(td.sum))
+    binary.LittleEndian.PutUint64(data[44:52], math.Float64bits(td.sumSq))
+    binary.LittleEndian.PutUint32(data[52:56], uint32(len(td.centroids)))
+
+    offset := tdigestHeaderSize
+    for _, c := range td.centroids {
+        binary.LittleEndian.PutUint64(data[offset:offset+8], math.Float64bits(c.mean))
+        binary.LittleEndian.PutUint64(data[offset+8:offset+16], math.Float64bits(c.weight))
+        offset += 16
+    }
+    return data
+}
+
+// DeserializeTDigest loads t-digest state from bytes.
+func DeserializeTDigest(data []byte) (*TDigest, error) {
+    if len(data) < tdigestHeaderSize || !bytes.Equal(data[0:4], tdigestMagic) {
+        return nil, fmt.Errorf("invalid t-digest data")
+    }
+    n := int(binary.LittleEndian.Uint32(data[52:56]))
+    if len(data) != tdigestHeaderSize+n*16 {
+        return nil, fmt.Errorf("data length mismatch: expected %d, got %d", tdigestHeaderSize+n*16, len(data))
+    }
+
+    td := &TDigest{
+        compression: math.Float64frombits(binary.LittleEndian.Uint64(data[4:12])),
+        count:       math.Float64frombits(binary.LittleEndian.Uint64(data[12:20])),
+        min:         math.Float64frombits(binary.LittleEndian.Uint64(data[20:28])),
+        max:         math.Float64frombits(binary.LittleEndian.Uint64(data[28:36])),
+        sum:         math.Float64frombits(binary.LittleEndian.Uint64(data[36:44])),
+        sumSq:       math.Float64frombits(binary.LittleEndian.Uint64(data[44:52])),
+        centroids:   make([]centroid, n),
+    }
+    offset := tdigestHeaderSize
+    for i := range td.centroids {
+        td.centroids[i].mean = math.Float64frombits(binary.LittleEndian.Uint64(data[offset : offset+8]))
+        td.centroids[i].weight = math.Float64frombits(binary.LittleEndian.Uint64(data[offset+8 : offset+16]))
+        offset += 16
+    }
+    return td, nil
+}
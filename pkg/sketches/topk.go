@@ -0,0 +1,206 @@
+package sketches
+
+import (
+    "bytes"
+    "container/heap"
+    "encoding/binary"
+    "fmt"
+    "sort"
+)
+
+// topkMagic prefixes serialized top-K sketches.
+var topkMagic = []byte{'A', 'Q', 'K', 1}
+
+// topkHeaderSize is magic(4) + capacity(4) + items(8) + counters(4).
+const topkHeaderSize = 20
+
+// DefaultTopKCapacity is how many keys a top-K sketch counts by default.
+const DefaultTopKCapacity = 1000
+
+// MaxTopKCapacity caps the keys a top-K sketch counts.
+const MaxTopKCapacity = 1 << 20
+
+// TopKSketch is a Space-Saving summary of a column's most frequent values.
+// It counts up to capacity keys; a key it doesn't count yet takes the place
+// of the least counted one and inherits that count, which becomes the most
+// its own count can be over by. Every value occurring more than
+// items/capacity times is counted, each count is at least the true one and
+// exceeds it by at most its error.
+type TopKSketch struct {
+    capacity int
+    items    uint64 // rows added
+    counters topkHeap
+    index    map[string]*topkCounter
+}
+
+type topkCounter struct {
+    key   string
+    count uint64
+    err   uint64
+    pos   int // index in the heap
+}
+
+// topkHeap is a min-heap of counters by count.
+type topkHeap []*topkCounter
+
+func (h topkHeap) Len() int           { return len(h) }
+func (h topkHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h topkHeap) Swap(i, j int) {
+    h[i], h[j] = h[j], h[i]
+    h[i].pos, h[j].pos = i, j
+}
+func (h *topkHeap) Push(x any) {
+    c := x.(*topkCounter)
+    c.pos = len(*h)
+    *h = append(*h, c)
+}
+func (h *topkHeap) Pop() any {
+    old := *h
+    c := old[len(old)-1]
+    *h = old[:len(old)-1]
+    return c
+}
+
+// TopKItem is a counted key: its true count is between Count-Error and
+// Count.
+type TopKItem struct {
+    Key   string `json:"key"`
+    Count uint64 `json:"count"`
+    Error uint64 `json:"error"`
+}
+
+// NewTopKSketch creates an empty sketch counting up to capacity keys.
+func NewTopKSketch(capacity int) (*TopKSketch, error) {
+    if capacity <= 0 || capacity > MaxTopKCapacity {
+        return nil, fmt.Errorf("top-K sketch capacity must be in [1, %d], got %d", MaxTopKCapacity, capacity)
+    }
+    return &TopKSketch{capacity: capacity, index: make(map[string]*topkCounter)}, nil
+}
+
+// Add counts key count times.
+func (s *TopKSketch) Add(key []byte, count uint64) {
+    if count == 0 {
+        return
+    }
+    s.items += count
+    if c, ok := s.index[string(key)]; ok {
+        c.count += count
+        heap.Fix(&s.counters, c.pos)
+        return
+    }
+    if len(s.counters) < s.capacity {
+        c := &topkCounter{key: string(key), count: count}
+        s.index[c.key] = c
+        heap.Push(&s.counters, c)
+        return
+    }
+    // the least counted key makes way, its count the newcomer's error
+    c := s.counters[0]
+    delete(s.index, c.key)
+    c.key, c.err = string(key), c.count
+    c.count += count
+    s.index[c.key] = c
+    heap.Fix(&s.counters, 0)
+}
+
+// AddValue counts a value as database/sql reads it (see ValueKey), so
+// values SQL compares equal are counted as one key; NULL is not counted.
+func (s *TopKSketch) AddValue(v any, count uint64) {
+    if key, ok := ValueKey(v); ok {
+        s.Add(key, count)
+    }
+}
+
+// Top returns the k most counted keys, largest count first.
+func (s *TopKSketch) Top(k int) []TopKItem {
+    items := make([]TopKItem, len(s.counters))
+    for i, c := range s.counters {
+        items[i] = TopKItem{Key: c.key, Count: c.count, Error: c.err}
+    }
+    sort.Slice(items, func(i, j int) bool {
+        if items[i].Count != items[j].Count {
+            return items[i].Count > items[j].Count
+        }
+        return items[i].Key < items[j].Key
+    })
+    if k >= 0 && k < len(items) {
+        items = items[:k]
+    }
+    return items
+}
+
+// Threshold is the most rows a key the sketch doesn't count can have: the
+// least count once every counter is taken, else 0.
+func (s *TopKSketch) Threshold() uint64 {
+    if len(s.counters) < s.capacity {
+        return 0
+    }
+    return s.counters[0].count
+}
+
+// Capacity returns how many keys the sketch counts.
+func (s *TopKSketch) Capacity() int {
+    return s.capacity
+}
+
+// Items returns how many rows were counted.
+func (s *TopKSketch) Items() uint64 {
+    return s.items
+}
+
+// Serialize returns the sketch as bytes
+func (s *TopKSketch) Serialize() []byte {
+    var buf bytes.Buffer
+    buf.Write(topkMagic)
+    binary.Write(&buf, binary.LittleEndian, uint32(s.capacity))
+    binary.Write(&buf, binary.LittleEndian, s.items)
+    binary.Write(&buf, binary.LittleEndian, uint32(len(s.counters)))
+    for _, c := range s.counters {
+        binary.Write(&buf, binary.LittleEndian, c.count)
+        binary.Write(&buf, binary.LittleEndian, c.err)
+        binary.Write(&buf, binary.LittleEndian, uint32(len(c.key)))
+        buf.WriteString(c.key)
+    }
+    return buf.Bytes()
+}
+
+// DeserializeTopKSketch loads a sketch from bytes
+func DeserializeTopKSketch(data []byte) (*TopKSketch, error) {
+    if len(data) < topkHeaderSize || !bytes.Equal(data[0:4], topkMagic) {
+        return nil, fmt.Errorf("not a serialized top-K sketch")
+    }
+    s, err := NewTopKSketch(int(binary.LittleEndian.Uint32(data[4:8])))
+    if err != nil {
+        return nil, err
+    }
+    s.items = binary.LittleEndian.Uint64(data[8:16])
+    n := int(binary.LittleEndian.Uint32(data[16:20]))
+    if n > s.capacity {
+        return nil, fmt.Errorf("top-K sketch holds %d counters over its capacity of %d", n, s.capacity)
+    }
+    off := topkHeaderSize
+    for i := 0; i < n; i++ {
+        if len(data)-off < 20 {
+            return nil, fmt.Errorf("truncated top-K sketch")
+        }
+        c := &topkCounter{
+            count: binary.LittleEndian.Uint64(data[off:]),
+            err:   binary.LittleEndian.Uint64(data[off+8:]),
+        }
+        keyLen := int(binary.LittleEndian.Uint32(data[off+16:]))
+        off += 20
+        if keyLen > len(data)-off {
+            return nil, fmt.Errorf("truncated top-K sketch")
+        }
+        c.key = string(data[off : off+keyLen])
+        off += keyLen
+        s.index[c.key] = c
+        c.pos = len(s.counters)
+        s.counters = append(s.counters, c)
+    }
+    if off != len(data) {
+        return nil, fmt.Errorf("data length mismatch: expected %d, got %d", off, len(data))
+    }
+    heap.Init(&s.counters)
+    return s, nil
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// AccuracyAlert records that one strategy's verified answers on a table
+// broke their tolerance more often than they should. It stays open, its
+// figures refreshed by each audit, until an audit finds the breaches back
+// within bounds.
+type AccuracyAlert struct {
+	Table            string     `json:"table"`
+	Strategy         string     `json:"strategy"`
+	VerifiedRuns     int        `json:"verified_runs"`
+	Breaches         int        `json:"breaches"`
+	BreachRate       float64    `json:"breach_rate"`
+	MeasuredErrorP90 float64    `json:"measured_error_p90"`
+	ToleranceP50     float64    `json:"tolerance_p50"`
+	OpenedAt         time.Time  `json:"opened_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+	ResolvedAt       *time.Time `json:"resolved_at,omitempty"`
+}
+
+// RaiseAccuracyAlert opens the alert for a.Table and a.Strategy, or
+// refreshes its figures if it is open already. opened reports whether it
+// was not.
+func RaiseAccuracyAlert(ctx context.Context, db *sql.DB, a *AccuracyAlert) (opened bool, err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var open bool
+	err = tx.QueryRowContext(ctx, `SELECT resolved_at IS NULL FROM aqe_accuracy_alerts
+		WHERE table_name = ? AND strategy = ?`, a.Table, a.Strategy).Scan(&open)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO aqe_accuracy_alerts(table_name, strategy, verified_runs, breaches,
+			breach_rate, measured_error_p90, tolerance_p50, opened_at, updated_at, resolved_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, NULL)
+		ON CONFLICT(table_name, strategy) DO UPDATE SET verified_runs=excluded.verified_runs,
+			breaches=excluded.breaches, breach_rate=excluded.breach_rate,
+			measured_error_p90=excluded.measured_error_p90, tolerance_p50=excluded.tolerance_p50,
+			opened_at=CASE WHEN aqe_accuracy_alerts.resolved_at IS NULL THEN aqe_accuracy_alerts.opened_at ELSE CURRENT_TIMESTAMP END,
+			updated_at=CURRENT_TIMESTAMP, resolved_at=NULL`,
+		a.Table, a.Strategy, a.VerifiedRuns, a.Breaches, a.BreachRate, a.MeasuredErrorP90, a.ToleranceP50)
+	if err != nil {
+		return false, err
+	}
+	return !open, tx.Commit()
+}
+
+// ResolveAccuracyAlert closes the open alert for table and strategy, if
+// there is one, and reports whether there was.
+func ResolveAccuracyAlert(ctx context.Context, db *sql.DB, table, strategy string) (bool, error) {
+	res, err := db.ExecContext(ctx, `UPDATE aqe_accuracy_alerts SET resolved_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE table_name = ? AND strategy = ? AND resolved_at IS NULL`, table, strategy)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ListAccuracyAlerts returns the open alerts, and the resolved ones too
+// when all is set, most recently opened first.
+func ListAccuracyAlerts(ctx context.Context, db Queryer, all bool) ([]AccuracyAlert, error) {
+	query := `SELECT table_name, strategy, verified_runs, breaches, breach_rate, measured_error_p90, tolerance_p50,
+		` + active.Epoch("opened_at") + `, ` + active.Epoch("updated_at") + `, ` + active.Epoch("resolved_at") + `
+		FROM aqe_accuracy_alerts`
+	if !all {
+		query += ` WHERE resolved_at IS NULL`
+	}
+	rows, err := db.QueryContext(ctx, query+` ORDER BY opened_at DESC, table_name, strategy`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []AccuracyAlert
+	for rows.Next() {
+		var a AccuracyAlert
+		var opened, updated int64
+		var resolved sql.NullInt64
+		if err := rows.Scan(&a.Table, &a.Strategy, &a.VerifiedRuns, &a.Breaches, &a.BreachRate,
+			&a.MeasuredErrorP90, &a.ToleranceP50, &opened, &updated, &resolved); err != nil {
+			return nil, err
+		}
+		a.OpenedAt, a.UpdatedAt = time.Unix(opened, 0).UTC(), time.Unix(updated, 0).UTC()
+		if resolved.Valid {
+			t := time.Unix(resolved.Int64, 0).UTC()
+			a.ResolvedAt = &t
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Analyze refreshes the statistics the database keeps for its own query
+// planner. full runs ANALYZE over every table; otherwise SQLite runs PRAGMA
+// optimize, which only analyzes tables whose statistics are missing or out
+// of date and bounds the work per table. The 0x10000 bit makes it consider
+// every table rather than those queried on its pooled connection.
+// PostgreSQL always runs ANALYZE.
+func Analyze(ctx context.Context, db *sql.DB, full bool) error {
+	stmt := "ANALYZE"
+	if active.Name() == "sqlite" && !full {
+		stmt = "PRAGMA optimize=0x10002"
+	}
+	_, err := db.ExecContext(ctx, stmt)
+	return err
+}
+
+// AnalyzeTable runs ANALYZE over table alone, for a table new enough that
+// the database has no statistics for it yet.
+func AnalyzeTable(ctx context.Context, db *sql.DB, table string) error {
+	_, err := db.ExecContext(ctx, "ANALYZE "+table)
+	return err
+}
+
+// AnalyzedStats is what the database's own ANALYZE last recorded about a
+// table.
+type AnalyzedStats struct {
+	Rows int64
+	// Distinct estimates the distinct values of the columns the database
+	// has statistics for: on SQLite, the leading column of each index.
+	Distinct map[string]int64
+}
+
+// ReadAnalyzedStats returns table's planner statistics, or nil when the
+// table has never been analyzed.
+func ReadAnalyzedStats(ctx context.Context, db Queryer, table string) (*AnalyzedStats, error) {
+	if active.Name() == "postgres" {
+		return readPostgresStats(ctx, db, table)
+	}
+	if ok, err := TableExists(ctx, db, "sqlite_stat1"); err != nil || !ok {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, `SELECT idx, stat FROM sqlite_stat1 WHERE tbl = ? COLLATE NOCASE`, table)
+	if err != nil {
+		return nil, err
+	}
+	type entry struct {
+		idx  sql.NullString
+		stat string
+	}
+	var entries []entry
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.idx, &e.stat); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(entries) == 0 {
+		return nil, err
+	}
+
+	// stat is "rows avg1 avg2 ...": the index's entries, then the average
+	// rows per distinct value of each prefix of its columns
+	stats := &AnalyzedStats{Distinct: make(map[string]int64)}
+	for _, e := range entries {
+		fields := strings.Fields(e.stat)
+		if len(fields) == 0 {
+			continue
+		}
+		n, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		stats.Rows = max(stats.Rows, n)
+		if !e.idx.Valid || len(fields) < 2 {
+			continue
+		}
+		perValue, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || perValue <= 0 {
+			continue
+		}
+		var column sql.NullString
+		if err := db.QueryRowContext(ctx, `SELECT name FROM pragma_index_info(?) WHERE seqno = 0`, e.idx.String).Scan(&column); err != nil || !column.Valid {
+			continue // an expression index
+		}
+		stats.Distinct[strings.ToLower(column.String)] = int64(math.Ceil(float64(n) / float64(perValue)))
+	}
+	return stats, nil
+}
+
+// readPostgresStats reads reltuples and pg_stats, which cover every
+// analyzed column. A negative n_distinct is a fraction of the rows.
+func readPostgresStats(ctx context.Context, db Queryer, table string) (*AnalyzedStats, error) {
+	var n sql.NullInt64
+	if err := db.QueryRowContext(ctx, active.RowCountEstimateQuery(table)).Scan(&n); err != nil || !n.Valid || n.Int64 < 0 {
+		return nil, err
+	}
+	stats := &AnalyzedStats{Rows: n.Int64, Distinct: make(map[string]int64)}
+	rows, err := db.QueryContext(ctx, `SELECT attname, n_distinct FROM pg_stats
+		WHERE schemaname = current_schema() AND tablename = lower(?)`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var column string
+		var distinct float64
+		if err := rows.Scan(&column, &distinct); err != nil {
+			return nil, err
+		}
+		if distinct < 0 {
+			distinct = -distinct * float64(stats.Rows)
+		}
+		stats.Distinct[strings.ToLower(column)] = int64(math.Ceil(distinct))
+	}
+	return stats, rows.Err()
+}
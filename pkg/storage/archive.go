@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// sampleArchive is the schema name archive files are attached under while
+// samples move in or out of them.
+const sampleArchive = "aqe_archive"
+
+// DefaultArchivePath is where cold samples of the SQLite database db are
+// archived when no path is configured: next to its main file, with an
+// .archive suffix. It is "" for in-memory databases.
+func DefaultArchivePath(ctx context.Context, db Queryer) (string, error) {
+	if err := RequireSQLite("sample archiving"); err != nil {
+		return "", err
+	}
+	var file string
+	if err := db.QueryRowContext(ctx, `SELECT file FROM pragma_database_list WHERE name = 'main'`).Scan(&file); err != nil {
+		return "", err
+	}
+	if file == "" {
+		return "", nil
+	}
+	return file + ".archive", nil
+}
+
+// TouchSample records that sampleTable was just read from the main
+// database, so it stays hot.
+func TouchSample(ctx context.Context, db *sql.DB, sampleTable string) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO aqe_sample_usage(sample_table, last_used, archive_file)
+		VALUES(?, CURRENT_TIMESTAMP, NULL)
+		ON CONFLICT(sample_table) DO UPDATE SET last_used = CURRENT_TIMESTAMP, archive_file = NULL`, sampleTable)
+	return err
+}
+
+// ColdSamples returns the samples in the main database last used, or if
+// never used last materialized, more than idle ago.
+func ColdSamples(ctx context.Context, db *sql.DB, idle time.Duration) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT s.sample_table FROM aqe_samples s
+		LEFT JOIN aqe_sample_usage u ON u.sample_table = s.sample_table
+		WHERE s.id = (SELECT MAX(id) FROM aqe_samples WHERE sample_table = s.sample_table)
+		AND u.archive_file IS NULL
+		AND `+active.Epoch("COALESCE(u.last_used, s.created_at)")+` < ?
+		ORDER BY s.sample_table`, time.Now().Add(-idle).Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		out = append(out, name)
+	}
+	return out, rows.Err()
+}
+
+// ArchiveSample moves sampleTable out of the main database into the
+// SQLite file at path, keeping its rows, their rowids and its records, and
+// marks it archived. It needs a SQLite database.
+func ArchiveSample(ctx context.Context, db *sql.DB, sampleTable, path string) error {
+	if err := RequireSQLite("sample archiving"); err != nil {
+		return err
+	}
+	return withArchive(ctx, db, path, func(tx *sql.Tx) error {
+		if !tableExists(ctx, tx, "main", sampleTable) {
+			return fmt.Errorf("sample %s does not exist", sampleTable)
+		}
+		if err := copySampleTable(ctx, tx, "main", sampleArchive, sampleTable); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("DROP TABLE main.%s", quoteIdent(sampleTable))); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `INSERT INTO main.aqe_sample_usage(sample_table, archive_file)
+			VALUES(?, ?)
+			ON CONFLICT(sample_table) DO UPDATE SET archive_file = excluded.archive_file`, sampleTable, path)
+		return err
+	})
+}
+
+// RestoreSample moves sampleTable back into the main database from the
+// archive file it was moved to, and marks it used. It is a no-op for
+// samples that are not archived. A sample materialized again since it was
+// archived keeps its new rows; the archived copy is discarded.
+func RestoreSample(ctx context.Context, db *sql.DB, sampleTable string) error {
+	var path sql.NullString
+	err := db.QueryRowContext(ctx, `SELECT archive_file FROM aqe_sample_usage WHERE sample_table = ?`, sampleTable).Scan(&path)
+	if err == sql.ErrNoRows || err == nil && !path.Valid {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := RequireSQLite("sample archiving"); err != nil {
+		return err
+	}
+	return withArchive(ctx, db, path.String, func(tx *sql.Tx) error {
+		inArchive := tableExists(ctx, tx, sampleArchive, sampleTable)
+		if !tableExists(ctx, tx, "main", sampleTable) {
+			if !inArchive {
+				return fmt.Errorf("sample %s is missing from archive %s", sampleTable, path.String)
+			}
+			if err := copySampleTable(ctx, tx, sampleArchive, "main", sampleTable); err != nil {
+				return err
+			}
+		}
+		if inArchive {
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf("DROP TABLE %s.%s", sampleArchive, quoteIdent(sampleTable))); err != nil {
+				return err
+			}
+		}
+		_, err := tx.ExecContext(ctx, `UPDATE main.aqe_sample_usage
+			SET archive_file = NULL, last_used = CURRENT_TIMESTAMP WHERE sample_table = ?`, sampleTable)
+		return err
+	})
+}
+
+// withArchive attaches the archive file at path to one connection and runs
+// fn in a transaction on it.
+func withArchive(ctx context.Context, db *sql.DB, path string, fn func(*sql.Tx) error) error {
+	if path == "" {
+		return fmt.Errorf("no sample archive path")
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, `ATTACH DATABASE ? AS `+sampleArchive, path); err != nil {
+		return fmt.Errorf("opening sample archive: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), `DETACH DATABASE `+sampleArchive)
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// copySampleTable copies sampleTable from schema from to schema to,
+// replacing any table of that name there. Rowids are kept, since samples
+// that track base rowids are reconciled by them.
+func copySampleTable(ctx context.Context, tx *sql.Tx, from, to, sampleTable string) error {
+	name := quoteIdent(sampleTable)
+	var cols []string
+	rows, err := tx.QueryContext(ctx, `SELECT name FROM pragma_table_info(?, ?) ORDER BY cid`, sampleTable, from)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var c string
+		if err := rows.Scan(&c); err != nil {
+			rows.Close()
+			return err
+		}
+		cols = append(cols, quoteIdent(c))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	list := strings.Join(cols, ", ")
+	for _, stmt := range []string{
+		fmt.Sprintf("DROP TABLE IF EXISTS %s.%s", to, name),
+		fmt.Sprintf("CREATE TABLE %s.%s AS SELECT * FROM %s.%s WHERE 1 = 0", to, name, from, name),
+		fmt.Sprintf("INSERT INTO %s.%s(rowid, %s) SELECT rowid, %s FROM %s.%s", to, name, list, list, from, name),
+	} {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("moving %s to %s: %w", sampleTable, to, err)
+		}
+	}
+	return nil
+}
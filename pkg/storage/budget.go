@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// StorageBudget caps the bytes the samples and sketches in the main
+// database may take up together. Over it, maintenance shrinks or drops the
+// least used of them until they fit; Shrink lets it redraw uniform samples
+// at a smaller fraction before dropping them.
+type StorageBudget struct {
+	MaxBytes  int64     `json:"max_bytes"`
+	Shrink    bool      `json:"shrink"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SaveStorageBudget replaces the registered budget.
+func SaveStorageBudget(ctx context.Context, db *sql.DB, b *StorageBudget) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO aqe_storage_budget(id, max_bytes, shrink, updated_at)
+		VALUES(1, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(id) DO UPDATE SET max_bytes=excluded.max_bytes, shrink=excluded.shrink, updated_at=CURRENT_TIMESTAMP`,
+		b.MaxBytes, b.Shrink)
+	return err
+}
+
+// GetStorageBudget returns the registered budget, or nil when none is.
+func GetStorageBudget(ctx context.Context, db Queryer) (*StorageBudget, error) {
+	b := &StorageBudget{}
+	var updated int64
+	err := db.QueryRowContext(ctx, `SELECT max_bytes, shrink, `+active.Epoch("updated_at")+`
+		FROM aqe_storage_budget WHERE id = 1`).Scan(&b.MaxBytes, &b.Shrink, &updated)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	b.UpdatedAt = time.Unix(updated, 0).UTC()
+	return b, nil
+}
+
+// DeleteStorageBudget removes the registered budget, reporting whether
+// there was one.
+func DeleteStorageBudget(ctx context.Context, db *sql.DB) (bool, error) {
+	res, err := db.ExecContext(ctx, `DELETE FROM aqe_storage_budget WHERE id = 1`)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// TableBytes returns the bytes table and its indexes take up on disk, 0
+// when it does not exist.
+func TableBytes(ctx context.Context, db Queryer, table string) (int64, error) {
+	var n int64
+	err := db.QueryRowContext(ctx, active.TableBytesQuery(), table).Scan(&n)
+	return n, err
+}
+
+// SketchSize is a stored sketch and the bytes its data takes up.
+type SketchSize struct {
+	Table  string     `json:"table"`
+	Column string     `json:"column"`
+	Type   SketchType `json:"type"`
+	Bytes  int64      `json:"bytes"`
+}
+
+// SketchSizes returns every stored sketch with the size of its data.
+func SketchSizes(ctx context.Context, db Queryer) ([]SketchSize, error) {
+	rows, err := db.QueryContext(ctx, `SELECT table_name, COALESCE(column_name, ''), sketch_type, length(sketch_data)
+		FROM aqe_sketches ORDER BY table_name, column_name, sketch_type`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []SketchSize
+	for rows.Next() {
+		var s SketchSize
+		if err := rows.Scan(&s.Table, &s.Column, &s.Type, &s.Bytes); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
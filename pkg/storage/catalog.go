@@ -0,0 +1,41 @@
+package storage
+
+// latestSamples selects the latest record of every sample.
+const latestSamples = `SELECT * FROM aqe_samples a
+	WHERE a.id = (SELECT MAX(b.id) FROM aqe_samples b WHERE b.sample_table = a.sample_table)`
+
+// InformationSchemaTables is a query standing in for
+// information_schema.tables: the database's own tables and views (see
+// Dialect.CatalogTablesQuery) with what AQE records of them. is_sample is
+// 'YES' for samples and join synopsis tables, source_table is the table a
+// sample, outlier or join synopsis table was drawn from, fraction the
+// share of its rows it holds, and synopsis_kind is "sample", "outliers",
+// "join_synopsis" or "internal" for AQE's other tables, NULL for the
+// user's own.
+func InformationSchemaTables() string {
+	return `SELECT t.table_catalog, t.table_schema, t.table_name, t.table_type,
+			CASE WHEN s.sample_table IS NOT NULL OR j.name IS NOT NULL THEN 'YES' ELSE 'NO' END AS is_sample,
+			COALESCE(s.table_name, o.table_name,
+				CASE WHEN lower(t.table_name) = lower(j.name) || '_r' THEN j.right_table ELSE j.left_table END) AS source_table,
+			COALESCE(s.sample_fraction, j.fraction) AS fraction,
+			CASE WHEN s.sample_table IS NOT NULL THEN 'sample'
+				WHEN o.outlier_table IS NOT NULL THEN 'outliers'
+				WHEN j.name IS NOT NULL THEN 'join_synopsis'
+				WHEN lower(t.table_name) LIKE 'aqe!_%' ESCAPE '!' OR lower(t.table_name) LIKE 'ml!_%' ESCAPE '!' THEN 'internal'
+			END AS synopsis_kind
+		FROM (` + active.CatalogTablesQuery() + `) t
+		LEFT JOIN (` + latestSamples + `) s ON lower(s.sample_table) = lower(t.table_name)
+		LEFT JOIN (` + latestSamples + `) o ON lower(o.outlier_table) = lower(t.table_name)
+		LEFT JOIN aqe_join_synopses j ON lower(t.table_name) IN (lower(j.name) || '_l', lower(j.name) || '_r')`
+}
+
+// InformationSchemaColumns is a query standing in for
+// information_schema.columns: every column of the database's tables and
+// views, with the is_sample, source_table and synopsis_kind of its table
+// (see InformationSchemaTables).
+func InformationSchemaColumns() string {
+	return `SELECT c.table_catalog, c.table_schema, c.table_name, c.column_name, c.ordinal_position,
+			c.data_type, c.is_nullable, c.column_default, t.is_sample, t.source_table, t.synopsis_kind
+		FROM (` + active.CatalogColumnsQuery() + `) c
+		JOIN (` + InformationSchemaTables() + `) t ON t.table_schema = c.table_schema AND t.table_name = c.table_name`
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// ColumnStats is what POST /tables/{name}/analyze collected about one
+// column of a table.
+type ColumnStats struct {
+	Column string `json:"column"`
+	// Distinct is a HyperLogLog estimate of the column's distinct non-null
+	// values.
+	Distinct     int64   `json:"distinct"`
+	NullFraction float64 `json:"null_fraction"`
+	// Numeric is set when every non-null value is a number; Min and Max
+	// then compare as numbers, otherwise as text.
+	Numeric bool   `json:"numeric"`
+	Min     string `json:"min,omitempty"`
+	Max     string `json:"max,omitempty"`
+	// Histogram holds the bounds of equi-depth buckets over a numeric
+	// column: bucket i spans Histogram[i] to Histogram[i+1] and holds an
+	// equal share of the non-null rows.
+	Histogram  []float64 `json:"histogram,omitempty"`
+	AnalyzedAt time.Time `json:"analyzed_at"`
+}
+
+// SaveColumnStats replaces the statistics collected for table and records
+// its row count.
+func SaveColumnStats(ctx context.Context, db *sql.DB, table string, rows int64, columns []ColumnStats) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM aqe_column_stats WHERE table_name = ?`, table); err != nil {
+		return err
+	}
+	for _, c := range columns {
+		var histogram any
+		if len(c.Histogram) > 0 {
+			b, err := json.Marshal(c.Histogram)
+			if err != nil {
+				return err
+			}
+			histogram = string(b)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO aqe_column_stats(table_name, column_name, distinct_count, null_fraction, is_numeric, min_value, max_value, histogram, analyzed_at)
+			VALUES(?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+			table, c.Column, c.Distinct, c.NullFraction, c.Numeric, c.Min, c.Max, histogram); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO aqe_table_stats(table_name,row_count,updated_at)
+		VALUES(?,?,CURRENT_TIMESTAMP)
+		ON CONFLICT(table_name) DO UPDATE SET row_count=excluded.row_count, updated_at=CURRENT_TIMESTAMP`, table, rows); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetColumnStats returns the statistics collected for table's columns,
+// none when it was never analyzed.
+func GetColumnStats(ctx context.Context, db Queryer, table string) ([]ColumnStats, error) {
+	rows, err := db.QueryContext(ctx, `SELECT column_name, distinct_count, null_fraction, is_numeric, min_value, max_value, histogram, `+active.Epoch("analyzed_at")+`
+		FROM aqe_column_stats WHERE table_name = ? ORDER BY column_name`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []ColumnStats
+	for rows.Next() {
+		var c ColumnStats
+		var lo, hi, histogram sql.NullString
+		var analyzed int64
+		if err := rows.Scan(&c.Column, &c.Distinct, &c.NullFraction, &c.Numeric, &lo, &hi, &histogram, &analyzed); err != nil {
+			return nil, err
+		}
+		c.Min, c.Max = lo.String, hi.String
+		if histogram.Valid && histogram.String != "" {
+			if err := json.Unmarshal([]byte(histogram.String), &c.Histogram); err != nil {
+				return nil, err
+			}
+		}
+		c.AnalyzedAt = time.Unix(analyzed, 0).UTC()
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// ColumnDistinct maps the lower-cased columns of stats to their distinct
+// counts.
+func ColumnDistinct(stats []ColumnStats) map[string]int64 {
+	out := make(map[string]int64, len(stats))
+	for _, c := range stats {
+		out[strings.ToLower(c.Column)] = c.Distinct
+	}
+	return out
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+)
+
+// DanglingSynopsis is a synopsis record whose tables no longer match the
+// database.
+type DanglingSynopsis struct {
+	Kind   string `json:"kind"` // "sample", "sketch" or "join_synopsis"
+	Name   string `json:"name"` // the sample table, sketch type or join synopsis
+	Table  string `json:"table"`
+	Column string `json:"column,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// FindDanglingSynopses returns the synopsis records left behind by tables
+// dropped outside the API: samples and sketches of a table that is gone,
+// samples whose own or outlier table is gone without being archived, and
+// join synopses missing a joined or synopsis table.
+func FindDanglingSynopses(ctx context.Context, db *sql.DB) ([]DanglingSynopsis, error) {
+	exists := make(map[string]bool)
+	has := func(table string) (bool, error) {
+		if ok, seen := exists[table]; seen {
+			return ok, nil
+		}
+		ok, err := TableExists(ctx, db, table)
+		exists[table] = ok
+		return ok, err
+	}
+
+	tables, err := SynopsisTables(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	var out []DanglingSynopsis
+	for _, table := range tables {
+		base, err := has(table)
+		if err != nil {
+			return nil, err
+		}
+		samples, err := ListSamples(ctx, db, table)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range samples {
+			d := DanglingSynopsis{Kind: "sample", Name: s.SampleTable, Table: table}
+			switch own, err := has(s.SampleTable); {
+			case err != nil:
+				return nil, err
+			case !base:
+				d.Reason = "base table is gone"
+			case !own && !s.Archived:
+				d.Reason = "sample table is gone"
+			case s.OutlierTable != "":
+				outliers, err := has(s.OutlierTable)
+				if err != nil {
+					return nil, err
+				}
+				if !outliers {
+					d.Reason = "outlier table is gone"
+				}
+			}
+			if d.Reason != "" {
+				out = append(out, d)
+			}
+		}
+		if base {
+			continue
+		}
+		sketches, err := ListSketches(ctx, db, table)
+		if err != nil {
+			return nil, err
+		}
+		for _, sk := range sketches {
+			out = append(out, DanglingSynopsis{Kind: "sketch", Name: string(sk.Type), Table: table, Column: sk.Column,
+				Reason: "base table is gone"})
+		}
+	}
+
+	joins, err := ListJoinSynopses(ctx, db, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, j := range joins {
+		for _, t := range []string{j.LeftTable, j.RightTable, j.LeftSynopsis, j.RightSynopsis} {
+			ok, err := has(t)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				out = append(out, DanglingSynopsis{Kind: "join_synopsis", Name: j.Name, Table: j.LeftTable,
+					Reason: t + " is gone"})
+				break
+			}
+		}
+	}
+	return out, nil
+}
+
+// DropDanglingSynopsis forgets d, dropping whichever of its tables are
+// left.
+func DropDanglingSynopsis(ctx context.Context, db *sql.DB, d DanglingSynopsis) error {
+	switch d.Kind {
+	case "sample":
+		return DropSample(ctx, db, d.Name)
+	case "sketch":
+		_, err := db.ExecContext(ctx, `DELETE FROM aqe_sketches
+			WHERE table_name = ? AND column_name = ? AND sketch_type = ?`, d.Table, d.Column, d.Name)
+		return err
+	case "join_synopsis":
+		_, err := DropJoinSynopsis(ctx, db, d.Name)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,300 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Dialect covers the SQL that differs between the databases AQE runs on.
+// Statements elsewhere are written in the subset SQLite and PostgreSQL
+// share, with ? placeholders; what they can't share goes through here.
+type Dialect interface {
+	// Name is "sqlite" or "postgres".
+	Name() string
+	// DDL adapts a CREATE TABLE statement written for SQLite.
+	DDL(stmt string) string
+	// ListTablesQuery selects the name of every user table.
+	ListTablesQuery() string
+	// TableExistsQuery counts the tables named by its one parameter.
+	TableExistsQuery() string
+	// TableColumnsQuery selects, in order, the column names of the table
+	// named by its one parameter.
+	TableColumnsQuery() string
+	// CatalogTablesQuery selects table_catalog, table_schema, table_name and
+	// table_type of every table and view, as information_schema.tables
+	// would; CatalogColumnsQuery selects table_catalog, table_schema,
+	// table_name, column_name, ordinal_position, data_type, is_nullable
+	// and column_default of their columns, as information_schema.columns
+	// would.
+	CatalogTablesQuery() string
+	CatalogColumnsQuery() string
+	// Epoch is the Unix time in seconds of a timestamp expression.
+	Epoch(expr string) string
+	// Now is the current timestamp; DaysAgo is the one days before it.
+	Now() string
+	DaysAgo(days int) string
+	// AgeDays is the age of a timestamp expression in fractional days.
+	AgeDays(expr string) string
+	// SampleSource draws a Bernoulli sample of fraction of table's rows: the
+	// FROM item to read, and a predicate to AND into the WHERE clause ("" for
+	// none).
+	SampleSource(table string, fraction float64) (from, where string)
+	// BlockSampleSource draws whole pages of table, each with probability
+	// fraction: the FROM item to read, or "" where blocks of rows are
+	// chosen by rowid range instead (see sampler.CreateBlockSample).
+	BlockSampleSource(table string, fraction float64) string
+	// Subset selects about fraction of table's rows, chosen
+	// deterministically so that the rows kept at one fraction are kept at
+	// every larger one.
+	Subset(table string, fraction float64) string
+	// RowCountEstimateQuery selects a cheap estimate of table's row count.
+	RowCountEstimateQuery(table string) string
+	// TableBytesQuery selects the bytes the table named by its one
+	// parameter, and its indexes, take up on disk; 0 when there is none.
+	TableBytesQuery() string
+	// HasRowid reports whether tables have a stable integer rowid, which
+	// in-place sample reconciliation and chunked exact execution rely on.
+	HasRowid() bool
+}
+
+// ErrUnsupportedDialect means a feature is not available on the database
+// AQE is connected to.
+var ErrUnsupportedDialect = errors.New("not supported on this database")
+
+// SQLite is the dialect of modernc.org/sqlite databases, the default.
+var SQLite Dialect = sqliteDialect{}
+
+// Postgres is the dialect of PostgreSQL databases opened through Open.
+var Postgres Dialect = postgresDialect{}
+
+// active is the dialect of the database the process is connected to. One
+// server talks to one database, so it is set once, by Open.
+var active = SQLite
+
+// ActiveDialect returns the dialect of the database opened by Open.
+func ActiveDialect() Dialect {
+	return active
+}
+
+// SetDialect overrides the active dialect, for callers that open their
+// database handles themselves.
+func SetDialect(d Dialect) {
+	active = d
+}
+
+// RequireSQLite returns an ErrUnsupportedDialect error naming feature
+// unless the active dialect is SQLite.
+func RequireSQLite(feature string) error {
+	if active.Name() != "sqlite" {
+		return fmt.Errorf("%s: %w (%s)", feature, ErrUnsupportedDialect, active.Name())
+	}
+	return nil
+}
+
+// TableExists reports whether the database has a table named table.
+func TableExists(ctx context.Context, db Queryer, table string) (bool, error) {
+	var n int
+	if err := db.QueryRowContext(ctx, active.TableExistsQuery(), table).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// KeyIndexed reports whether rows of table can be looked up by column
+// through an index: one whose first column it is, or, in SQLite, the
+// rowid when column is its INTEGER PRIMARY KEY alias. Only SQLite indexes
+// are inspected; on other databases it reports false.
+func KeyIndexed(ctx context.Context, db Queryer, table, column string) (bool, error) {
+	if active.Name() != "sqlite" {
+		return false, nil
+	}
+	var n int
+	err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM pragma_index_list(?) l, pragma_index_info(l.name) i
+		WHERE i.seqno = 0 AND i.name = ? COLLATE NOCASE`, table, column).Scan(&n)
+	if err != nil || n > 0 {
+		return n > 0, err
+	}
+	var pks, alias int
+	err = db.QueryRowContext(ctx, `SELECT COUNT(*), COALESCE(SUM(name = ? COLLATE NOCASE AND upper(type) = 'INTEGER'), 0)
+		FROM pragma_table_info(?) WHERE pk > 0`, column, table).Scan(&pks, &alias)
+	return pks == 1 && alias == 1, err
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string { return "sqlite" }
+
+func (sqliteDialect) DDL(stmt string) string { return stmt }
+
+func (sqliteDialect) ListTablesQuery() string {
+	return `SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%' ORDER BY 1`
+}
+
+func (sqliteDialect) TableExistsQuery() string {
+	return `SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name=?`
+}
+
+func (sqliteDialect) TableColumnsQuery() string {
+	return `SELECT name FROM pragma_table_info(?) ORDER BY cid`
+}
+
+func (sqliteDialect) CatalogTablesQuery() string {
+	return `SELECT 'main' AS table_catalog, 'main' AS table_schema, name AS table_name,
+			CASE type WHEN 'view' THEN 'VIEW' ELSE 'BASE TABLE' END AS table_type
+		FROM sqlite_master WHERE type IN ('table', 'view') AND name NOT LIKE 'sqlite%'`
+}
+
+func (sqliteDialect) CatalogColumnsQuery() string {
+	return `SELECT 'main' AS table_catalog, 'main' AS table_schema, m.name AS table_name, c.name AS column_name,
+			c.cid + 1 AS ordinal_position, c.type AS data_type,
+			CASE WHEN c."notnull" THEN 'NO' ELSE 'YES' END AS is_nullable, c.dflt_value AS column_default
+		FROM sqlite_master m, pragma_table_info(m.name) c
+		WHERE m.type IN ('table', 'view') AND m.name NOT LIKE 'sqlite%'`
+}
+
+func (sqliteDialect) Epoch(expr string) string {
+	return fmt.Sprintf("CAST(strftime('%%s', %s) AS INTEGER)", expr)
+}
+
+func (sqliteDialect) Now() string { return "datetime('now')" }
+
+func (sqliteDialect) DaysAgo(days int) string {
+	return fmt.Sprintf("datetime('now', '-%d days')", days)
+}
+
+func (sqliteDialect) AgeDays(expr string) string {
+	return fmt.Sprintf("(julianday('now') - julianday(%s))", expr)
+}
+
+func (sqliteDialect) SampleSource(table string, fraction float64) (string, string) {
+	return table, fmt.Sprintf("(abs(random())/9223372036854775807.0) < %f", fraction)
+}
+
+// BlockSampleSource is "": SQLite has no TABLESAMPLE, so blocks are
+// rowid ranges.
+func (sqliteDialect) BlockSampleSource(table string, fraction float64) string {
+	return ""
+}
+
+// subsetModulus is the prime rowids are hashed modulo to draw nested
+// pseudo-random subsets; subsetMultiplier scatters consecutive rowids.
+const (
+	subsetModulus    = 1000003
+	subsetMultiplier = 738163
+)
+
+// Subset keeps rows by a hash of their rowid.
+func (sqliteDialect) Subset(table string, fraction float64) string {
+	threshold := int64(math.Ceil(fraction * subsetModulus))
+	return fmt.Sprintf("SELECT * FROM %s WHERE (rowid %% %d) * %d %% %d < %d",
+		table, subsetModulus, subsetMultiplier, subsetModulus, threshold)
+}
+
+// RowCountEstimateQuery reads the largest rowid, an index lookup rather than
+// a scan. It is exact for append-only tables and overestimates after
+// deletes.
+func (sqliteDialect) RowCountEstimateQuery(table string) string {
+	return fmt.Sprintf("SELECT MAX(rowid) FROM %s", table)
+}
+
+// TableBytesQuery sums the pages dbstat reports for the table and its
+// indexes.
+func (sqliteDialect) TableBytesQuery() string {
+	return `SELECT COALESCE(SUM(pgsize), 0) FROM dbstat
+		WHERE name IN (SELECT name FROM sqlite_master WHERE tbl_name = ?)`
+}
+
+func (sqliteDialect) HasRowid() bool { return true }
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string { return "postgres" }
+
+// postgresTypes maps the SQLite column declarations used by the meta
+// tables to PostgreSQL ones; the longer declarations come first so they win.
+var postgresTypes = strings.NewReplacer(
+	"INTEGER PRIMARY KEY AUTOINCREMENT", "BIGSERIAL PRIMARY KEY",
+	"INTEGER", "BIGINT",
+	"REAL", "DOUBLE PRECISION",
+	"BLOB", "BYTEA",
+	"DATETIME", "TIMESTAMP",
+)
+
+func (postgresDialect) DDL(stmt string) string { return postgresTypes.Replace(stmt) }
+
+func (postgresDialect) ListTablesQuery() string {
+	return `SELECT table_name FROM information_schema.tables
+		WHERE table_schema = current_schema() AND table_type = 'BASE TABLE' ORDER BY 1`
+}
+
+func (postgresDialect) TableExistsQuery() string {
+	return `SELECT COUNT(*) FROM information_schema.tables
+		WHERE table_schema = current_schema() AND table_name = lower(?)`
+}
+
+func (postgresDialect) TableColumnsQuery() string {
+	return `SELECT column_name FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = lower(?) ORDER BY ordinal_position`
+}
+
+func (postgresDialect) CatalogTablesQuery() string {
+	return `SELECT table_catalog, table_schema, table_name, table_type FROM information_schema.tables
+		WHERE table_schema = current_schema()`
+}
+
+func (postgresDialect) CatalogColumnsQuery() string {
+	return `SELECT table_catalog, table_schema, table_name, column_name, ordinal_position, data_type,
+			is_nullable, column_default
+		FROM information_schema.columns WHERE table_schema = current_schema()`
+}
+
+func (postgresDialect) Epoch(expr string) string {
+	return fmt.Sprintf("CAST(EXTRACT(EPOCH FROM %s) AS BIGINT)", expr)
+}
+
+func (postgresDialect) Now() string { return "CURRENT_TIMESTAMP" }
+
+func (postgresDialect) DaysAgo(days int) string {
+	return fmt.Sprintf("(CURRENT_TIMESTAMP - INTERVAL '%d days')", days)
+}
+
+func (postgresDialect) AgeDays(expr string) string {
+	return fmt.Sprintf("(EXTRACT(EPOCH FROM (CURRENT_TIMESTAMP - %s)) / 86400.0)", expr)
+}
+
+// SampleSource uses TABLESAMPLE BERNOULLI, which decides row by row like
+// SQLite's random() filter but without evaluating an expression per row.
+func (postgresDialect) SampleSource(table string, fraction float64) (string, string) {
+	return fmt.Sprintf("%s TABLESAMPLE BERNOULLI (%g)", table, fraction*100), ""
+}
+
+// BlockSampleSource uses TABLESAMPLE SYSTEM, which keeps or skips whole
+// pages and reads only the pages it keeps.
+func (postgresDialect) BlockSampleSource(table string, fraction float64) string {
+	return fmt.Sprintf("%s TABLESAMPLE SYSTEM (%g)", table, fraction*100)
+}
+
+// subsetSeed makes progressive subsets repeatable, and so nested.
+const subsetSeed = 738163
+
+// Subset uses a REPEATABLE Bernoulli sample: with a fixed seed, a row
+// chosen at one percentage is chosen at every larger one.
+func (postgresDialect) Subset(table string, fraction float64) string {
+	return fmt.Sprintf("SELECT * FROM %s TABLESAMPLE BERNOULLI (%g) REPEATABLE (%d)", table, fraction*100, subsetSeed)
+}
+
+// RowCountEstimateQuery reads the planner's estimate from pg_class, kept
+// current by autovacuum; it is -1 for tables never analyzed.
+func (postgresDialect) RowCountEstimateQuery(table string) string {
+	return fmt.Sprintf("SELECT CAST(reltuples AS BIGINT) FROM pg_class WHERE oid = to_regclass('%s')",
+		strings.ReplaceAll(table, "'", "''"))
+}
+
+func (postgresDialect) TableBytesQuery() string {
+	return `SELECT COALESCE(pg_total_relation_size(to_regclass(lower(?))), 0)`
+}
+
+func (postgresDialect) HasRowid() bool { return false }
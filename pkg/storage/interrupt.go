@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"unsafe"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// sqliteConn mirrors the leading fields of modernc.org/sqlite's driver
+// connection, which the driver keeps unexported: its sqlite3* handle, the
+// TLS it was opened with, and the mutex it takes against a concurrent
+// Close. sqlite3_interrupt and sqlite3_limit need the first two; the
+// driver offers neither for a connection in use.
+type sqliteConn struct {
+	db  uintptr
+	tls *libc.TLS
+	sync.Mutex
+}
+
+// sqliteConnOf returns driverConn as a *sqliteConn, after checking that
+// it is the driver's connection type and that the fields sqliteConn
+// mirrors are laid out exactly as it expects: same names, types and
+// offsets. Any other driver, or a version laid out differently, is an
+// error naming the mismatch, never a guess.
+func sqliteConnOf(driverConn any) (*sqliteConn, error) {
+	v := reflect.ValueOf(driverConn)
+	t := v.Type()
+	if t.Kind() != reflect.Pointer || t.Elem().Kind() != reflect.Struct ||
+		t.Elem().PkgPath() != "modernc.org/sqlite" || t.Elem().Name() != "conn" {
+		return nil, fmt.Errorf("%w: %T is not a modernc.org/sqlite connection", errSQLiteLayout, driverConn)
+	}
+	if v.IsNil() {
+		return nil, fmt.Errorf("%w: nil connection", errSQLiteLayout)
+	}
+	want := reflect.TypeOf(sqliteConn{})
+	got := t.Elem()
+	if got.NumField() < want.NumField() {
+		return nil, fmt.Errorf("%w: %s has %d fields", errSQLiteLayout, got, got.NumField())
+	}
+	for i := 0; i < want.NumField(); i++ {
+		w, g := want.Field(i), got.Field(i)
+		if g.Name != w.Name || g.Type != w.Type || g.Offset != w.Offset || g.Anonymous != w.Anonymous {
+			return nil, fmt.Errorf("%w: field %d of %s is %s %s at %d, want %s %s at %d",
+				errSQLiteLayout, i, got, g.Name, g.Type, g.Offset, w.Name, w.Type, w.Offset)
+		}
+	}
+	c := (*sqliteConn)(unsafe.Pointer(v.Pointer()))
+	if c.db == 0 || c.tls == nil {
+		return nil, fmt.Errorf("%w: connection is closed", errSQLiteLayout)
+	}
+	return c, nil
+}
+
+// errSQLiteLayout means the SQLite driver's connections no longer look as
+// sqliteConn expects, as after a driver upgrade: the code reaching into
+// them must be checked against the new version.
+var errSQLiteLayout = errors.New("unexpected modernc.org/sqlite connection layout")
+
+// InterruptOnDone interrupts the statement running on conn as soon as ctx
+// is done. The SQLite driver interrupts a statement on its own only while
+// QueryContext or ExecContext runs, not while the rows they return are
+// stepped through, and database/sql can't close those rows until the step
+// in progress returns, so a cancelled scan would otherwise run on to its
+// next row or its end. stop must be called once conn's statements are done
+// with, before conn is closed. On PostgreSQL, whose driver cancels on the
+// server, stop does nothing. A SQLite connection it can't reach into is an
+// error (see sqliteConnOf).
+func InterruptOnDone(ctx context.Context, conn *sql.Conn) (stop func(), err error) {
+	stop = func() {}
+	if active.Name() != "sqlite" || ctx.Done() == nil {
+		return stop, nil
+	}
+	var c *sqliteConn
+	if err := conn.Raw(func(driverConn any) (err error) {
+		c, err = sqliteConnOf(driverConn)
+		return err
+	}); err != nil {
+		return stop, err
+	}
+
+	var mu sync.Mutex
+	stopped := false
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			// once stopped, conn may be running someone else's statement
+			mu.Lock()
+			if !stopped {
+				c.Lock()
+				sqlite3.Xsqlite3_interrupt(c.tls, c.db)
+				c.Unlock()
+			}
+			mu.Unlock()
+		case <-done:
+		}
+	}()
+	return func() {
+		mu.Lock()
+		stopped = true
+		mu.Unlock()
+		close(done)
+	}, nil
+}
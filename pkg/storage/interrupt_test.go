@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestSQLiteConnLayout(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "layout.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.Raw(func(driverConn any) error {
+		_, err := sqliteConnOf(driverConn)
+		return err
+	}); err != nil {
+		t.Fatalf("driver connection not recognized: %v", err)
+	}
+	if _, err := sqliteConnOf(struct{}{}); !errors.Is(err, errSQLiteLayout) {
+		t.Fatalf("sqliteConnOf(struct{}{}) = %v, want errSQLiteLayout", err)
+	}
+}
+
+func TestInterruptOnDone(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "interrupt.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	stop, err := InterruptOnDone(ctx, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	// an endless scan: only the interrupt ends it
+	rows, err := conn.QueryContext(ctx,
+		"WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n) SELECT i FROM n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	time.AfterFunc(50*time.Millisecond, cancel)
+	deadline := time.Now().Add(10 * time.Second)
+	for rows.Next() {
+		if time.Now().After(deadline) {
+			t.Fatal("scan ran on after its context was cancelled")
+		}
+	}
+	if err := rows.Err(); err == nil || errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("rows.Err() = %v, want an interrupt", err)
+	}
+}
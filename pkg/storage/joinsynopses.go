@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// JoinSynopsis is a materialized sample of the inner join of LeftTable and
+// RightTable on LeftKey = RightKey: each LeftTable row is kept with
+// probability Fraction, along with every RightTable row it joins. Each
+// joined row is thus kept with probability Fraction, and the synopsis
+// scales by 1/Fraction like a uniform sample of the join. It is stored as
+// two tables, LeftSynopsis holding the left row and RightSynopsis the
+// right row of each joined row, whose rowids pair them up.
+type JoinSynopsis struct {
+	Name          string  `json:"name"`
+	LeftTable     string  `json:"left_table"`
+	RightTable    string  `json:"right_table"`
+	LeftKey       string  `json:"left_key"`
+	RightKey      string  `json:"right_key"`
+	Fraction      float64 `json:"fraction"`
+	LeftSynopsis  string  `json:"left_synopsis"`
+	RightSynopsis string  `json:"right_synopsis"`
+	// LeftRows is LeftTable's rows when the synopsis was drawn, and Rows
+	// the joined rows it holds.
+	LeftRows  int64     `json:"left_rows"`
+	Rows      int64     `json:"rows"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// JoinSynopsisTables returns the tables the join synopsis called name is
+// stored in.
+func JoinSynopsisTables(name string) (left, right string) {
+	return name + "_l", name + "_r"
+}
+
+// RecordJoinSynopsis records a freshly drawn join synopsis, replacing any
+// earlier record of the same name.
+func RecordJoinSynopsis(ctx context.Context, db *sql.DB, s *JoinSynopsis) error {
+	if _, err := db.ExecContext(ctx, `DELETE FROM aqe_join_synopses WHERE name = ?`, s.Name); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, `INSERT INTO aqe_join_synopses(name, left_table, right_table, left_key, right_key, fraction, left_rows, synopsis_rows, created_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+		s.Name, s.LeftTable, s.RightTable, s.LeftKey, s.RightKey, s.Fraction, s.LeftRows, s.Rows)
+	return err
+}
+
+// ListJoinSynopses returns the join synopses with table on either side, or
+// every one when table is "", by name.
+func ListJoinSynopses(ctx context.Context, db Queryer, table string) ([]JoinSynopsis, error) {
+	rows, err := db.QueryContext(ctx, `SELECT name, left_table, right_table, left_key, right_key, fraction,
+			COALESCE(left_rows, 0), COALESCE(synopsis_rows, 0), `+active.Epoch("created_at")+`
+		FROM aqe_join_synopses
+		WHERE ? = '' OR lower(left_table) = lower(?) OR lower(right_table) = lower(?)
+		ORDER BY name`, table, table, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var synopses []JoinSynopsis
+	for rows.Next() {
+		var s JoinSynopsis
+		var created int64
+		if err := rows.Scan(&s.Name, &s.LeftTable, &s.RightTable, &s.LeftKey, &s.RightKey, &s.Fraction,
+			&s.LeftRows, &s.Rows, &created); err != nil {
+			return nil, err
+		}
+		s.LeftSynopsis, s.RightSynopsis = JoinSynopsisTables(s.Name)
+		s.CreatedAt = time.Unix(created, 0).UTC()
+		synopses = append(synopses, s)
+	}
+	return synopses, rows.Err()
+}
+
+// JoinSynopsisVersion returns an opaque version string for the latest
+// drawing of the join synopsis called name, empty when it has none.
+func JoinSynopsisVersion(ctx context.Context, db Queryer, name string) (string, error) {
+	var version sql.NullString
+	err := db.QueryRowContext(ctx, `SELECT MAX(id) || '@' || MAX(created_at) FROM aqe_join_synopses
+		WHERE name = ?`, name).Scan(&version)
+	if err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+	return version.String, nil
+}
+
+// DropJoinSynopsis drops the join synopsis called name and its record,
+// reporting whether there was one.
+func DropJoinSynopsis(ctx context.Context, db *sql.DB, name string) (bool, error) {
+	res, err := db.ExecContext(ctx, `DELETE FROM aqe_join_synopses WHERE name = ?`, name)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	left, right := JoinSynopsisTables(name)
+	for _, t := range []string{left, right} {
+		if _, err := db.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", t)); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// JoinSynopsisProvenance describes the latest drawing of the join synopsis
+// called name, with staleness judged against currentRows, its left table's
+// rows. It returns nil when there is no such synopsis.
+func JoinSynopsisProvenance(ctx context.Context, db Queryer, name string, currentRows int64) (*Provenance, error) {
+	p := &Provenance{Kind: "join_synopsis", Name: name}
+	var baseRows sql.NullInt64
+	var createdAt int64
+	err := db.QueryRowContext(ctx, `SELECT left_table, fraction, left_rows, `+active.Epoch("created_at")+`
+		FROM aqe_join_synopses WHERE name = ? ORDER BY id DESC LIMIT 1`, name).
+		Scan(&p.Table, &p.Fraction, &baseRows, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.assess(time.Unix(createdAt, 0), baseRows.Int64, currentRows)
+	return p, nil
+}
@@ -3,8 +3,17 @@ package storage
 import (
     "context"
     "database/sql"
+    "encoding/json"
+    "fmt"
+    "math"
+    "strings"
+    "time"
 )
 
+// StaleRowDrift is the relative change in base table rows past which a
+// sample or sketch is reported as stale.
+const StaleRowDrift = 0.1
+
 func EnsureMetaTables(ctx context.Context, db *sql.DB) error {
     stmts := []string{
         `CREATE TABLE IF NOT EXISTS aqe_table_stats (
@@ -18,6 +27,8 @@ func EnsureMetaTables(ctx context.Context, db *sql.DB) error {
             sample_table TEXT NOT NULL,
             sample_fraction REAL NOT NULL,
             strata_column TEXT,
+            base_row_count INTEGER,
+            base_rowids INTEGER DEFAULT 0,
             created_at DATETIME DEFAULT CURRENT_TIMESTAMP
         );`,
         `CREATE TABLE IF NOT EXISTS aqe_sketches (
@@ -27,6 +38,8 @@ func EnsureMetaTables(ctx context.Context, db *sql.DB) error {
             sketch_type TEXT NOT NULL,
             sketch_data BLOB NOT NULL,
             parameters TEXT,
+            base_row_count INTEGER,
+            degraded INTEGER DEFAULT 0,
             created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
             UNIQUE(table_name, column_name, sketch_type)
         );`,
@@ -42,13 +55,174 @@ func EnsureMetaTables(ctx context.Context, db *sql.DB) error {
             variance REAL NOT NULL,
             created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
         );`,
+        `CREATE TABLE IF NOT EXISTS aqe_sample_usage (
+            sample_table TEXT PRIMARY KEY,
+            last_used DATETIME,
+            archive_file TEXT
+        );`,
+        `CREATE TABLE IF NOT EXISTS aqe_query_templates (
+            name TEXT PRIMARY KEY,
+            sql_text TEXT NOT NULL,
+            params TEXT NOT NULL,
+            max_rel_error REAL DEFAULT 0,
+            confidence_level REAL DEFAULT 0,
+            description TEXT,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        );`,
+        `CREATE TABLE IF NOT EXISTS aqe_template_runs (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            template TEXT NOT NULL,
+            sql_text TEXT NOT NULL,
+            params TEXT,
+            plan_type TEXT NOT NULL,
+            confidence_level REAL NOT NULL,
+            columns TEXT NOT NULL,
+            aggregates TEXT NOT NULL,
+            result TEXT NOT NULL,
+            ran_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        );`,
+        `CREATE TABLE IF NOT EXISTS aqe_table_roles (
+            table_name TEXT NOT NULL,
+            column_name TEXT NOT NULL,
+            role TEXT NOT NULL,
+            position INTEGER NOT NULL,
+            updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            PRIMARY KEY (table_name, column_name)
+        );`,
+        `CREATE TABLE IF NOT EXISTS aqe_table_policies (
+            table_name TEXT PRIMARY KEY,
+            max_rel_error REAL DEFAULT 0,
+            confidence_level REAL DEFAULT 0,
+            updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        );`,
+        `CREATE TABLE IF NOT EXISTS aqe_column_stats (
+            table_name TEXT NOT NULL,
+            column_name TEXT NOT NULL,
+            distinct_count INTEGER NOT NULL,
+            null_fraction REAL NOT NULL,
+            is_numeric BOOLEAN DEFAULT FALSE,
+            min_value TEXT,
+            max_value TEXT,
+            histogram TEXT,
+            analyzed_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            PRIMARY KEY (table_name, column_name)
+        );`,
+        `CREATE TABLE IF NOT EXISTS aqe_accuracy_alerts (
+            table_name TEXT NOT NULL,
+            strategy TEXT NOT NULL,
+            verified_runs INTEGER NOT NULL,
+            breaches INTEGER NOT NULL,
+            breach_rate REAL NOT NULL,
+            measured_error_p90 REAL NOT NULL,
+            tolerance_p50 REAL NOT NULL,
+            opened_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            resolved_at DATETIME,
+            PRIMARY KEY (table_name, strategy)
+        );`,
+        `CREATE TABLE IF NOT EXISTS aqe_dropped_synopses (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            kind TEXT NOT NULL,
+            table_name TEXT NOT NULL,
+            name TEXT NOT NULL,
+            column_name TEXT,
+            sketch_type TEXT,
+            dropped_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        );`,
+        `CREATE TABLE IF NOT EXISTS aqe_join_synopses (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            name TEXT NOT NULL,
+            left_table TEXT NOT NULL,
+            right_table TEXT NOT NULL,
+            left_key TEXT NOT NULL,
+            right_key TEXT NOT NULL,
+            fraction REAL NOT NULL,
+            left_rows INTEGER,
+            synopsis_rows INTEGER,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        );`,
+        `CREATE TABLE IF NOT EXISTS aqe_row_counts (
+            table_name TEXT PRIMARY KEY,
+            row_count INTEGER NOT NULL,
+            tracked_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        );`,
+        `CREATE TABLE IF NOT EXISTS aqe_strategy_flags (
+            strategy TEXT PRIMARY KEY,
+            enabled INTEGER NOT NULL DEFAULT 1,
+            rollout_percent REAL NOT NULL DEFAULT 100,
+            updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        );`,
+        `CREATE TABLE IF NOT EXISTS aqe_maintenance_windows (
+            table_name TEXT PRIMARY KEY,
+            hours TEXT NOT NULL,
+            updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        );`,
+        `CREATE TABLE IF NOT EXISTS aqe_refresh_policies (
+            table_name TEXT PRIMARY KEY,
+            max_row_drift REAL DEFAULT 0,
+            checksum BOOLEAN DEFAULT FALSE,
+            disabled BOOLEAN DEFAULT FALSE,
+            last_checksum TEXT,
+            checked_at DATETIME,
+            updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        );`,
+        `CREATE TABLE IF NOT EXISTS aqe_storage_budget (
+            id INTEGER PRIMARY KEY,
+            max_bytes INTEGER NOT NULL,
+            shrink BOOLEAN DEFAULT FALSE,
+            updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        );`,
+        `CREATE TABLE IF NOT EXISTS aqe_unsupported_constructs (
+            construct TEXT PRIMARY KEY,
+            query_count INTEGER NOT NULL DEFAULT 0,
+            last_sql TEXT,
+            first_seen DATETIME DEFAULT CURRENT_TIMESTAMP,
+            last_seen DATETIME DEFAULT CURRENT_TIMESTAMP
+        );`,
     }
     for _, s := range stmts {
-        if _, err := db.ExecContext(ctx, s); err != nil { return err }
+        if _, err := db.ExecContext(ctx, active.DDL(s)); err != nil { return err }
+    }
+    // columns added after the tables first shipped
+    for _, c := range []struct{ table, column, decl string }{
+        {"aqe_samples", "base_row_count", "INTEGER"},
+        {"aqe_sketches", "base_row_count", "INTEGER"},
+        {"aqe_samples", "base_rowids", "INTEGER DEFAULT 0"},
+        {"aqe_sketches", "degraded", "INTEGER DEFAULT 0"},
+        {"aqe_samples", "sample_columns", "TEXT"},
+        {"aqe_samples", "sample_predicate", "TEXT"},
+        {"aqe_samples", "outlier_table", "TEXT"},
+        {"aqe_samples", "outlier_column", "TEXT"},
+        {"aqe_samples", "outlier_count", "INTEGER"},
+        {"aqe_samples", "min_stratum_rows", "INTEGER"},
+        {"aqe_samples", "universe_column", "TEXT"},
+        {"aqe_samples", "reservoir_size", "INTEGER"},
+        {"aqe_samples", "scanned_rowid", "INTEGER"},
+        {"aqe_samples", "block_rows", "INTEGER"},
+        {"aqe_samples", "bias_warning", "TEXT"},
+    } {
+        if err := EnsureColumn(ctx, db, c.table, c.column, c.decl); err != nil { return err }
     }
     return nil
 }
 
+// EnsureColumn adds column, declared decl, to table unless it has it, for
+// tables created before the column was.
+func EnsureColumn(ctx context.Context, db *sql.DB, table, column, decl string) error {
+    cols, err := TableColumns(ctx, db, table)
+    if err != nil {
+        return err
+    }
+    for _, c := range cols {
+        if strings.EqualFold(c, column) {
+            return nil
+        }
+    }
+    _, err = db.ExecContext(ctx, active.DDL(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, decl)))
+    return err
+}
+
 // UpsertTableRowCount sets the row_count for a table.
 func UpsertTableRowCount(ctx context.Context, db *sql.DB, table string, count int64) error {
     _, err := db.ExecContext(ctx, `INSERT INTO aqe_table_stats(table_name,row_count,updated_at)
@@ -57,26 +231,234 @@ func UpsertTableRowCount(ctx context.Context, db *sql.DB, table string, count in
     return err
 }
 
-// InsertSampleMeta records a materialized sample.
-func InsertSampleMeta(ctx context.Context, db *sql.DB, table, sampleTable string, fraction float64) error {
-    _, err := db.ExecContext(ctx, `INSERT INTO aqe_samples(table_name,sample_table,sample_fraction,created_at)
-        VALUES(?,?,?,CURRENT_TIMESTAMP)`, table, sampleTable, fraction)
+// SampleTablePrefix starts the opaque name of every sample table. What a
+// sample holds is recorded in aqe_samples, never encoded in its name.
+const SampleTablePrefix = "aqe_sample_"
+
+// OutlierTablePrefix starts the name of the side table holding an outlier
+// sample's extreme rows (see SampleInfo.OutlierTable).
+const OutlierTablePrefix = "aqe_outliers_"
+
+// JoinCachePrefix starts the name of the tables a staged universe join is
+// kept in between queries; they are a cache and can be dropped any time.
+const JoinCachePrefix = "aqe_joincache_"
+
+// JoinSynopsisPrefix starts the name of a join synopsis; its two tables
+// add "_l" and "_r" (see JoinSynopsis).
+const JoinSynopsisPrefix = "aqe_joinsyn_"
+
+// ResultSpoolPrefix starts the name of a table a paginated /query result is
+// spooled to while its pages are read; like the join cache, it can be
+// dropped any time.
+const ResultSpoolPrefix = "aqe_spool_"
+
+// DropSample drops sampleTable, and its outlier table if it has one, and
+// forgets every record of it.
+func DropSample(ctx context.Context, db *sql.DB, sampleTable string) error {
+    var outliers sql.NullString
+    _ = db.QueryRowContext(ctx, `SELECT outlier_table FROM aqe_samples
+        WHERE sample_table = ? ORDER BY id DESC LIMIT 1`, sampleTable).Scan(&outliers)
+    if outliers.String != "" {
+        if _, err := db.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", outliers.String)); err != nil {
+            return err
+        }
+    }
+    if _, err := db.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", sampleTable)); err != nil {
+        return err
+    }
+    if _, err := db.ExecContext(ctx, `DELETE FROM aqe_strata_info WHERE sample_table = ?`, sampleTable); err != nil {
+        return err
+    }
+    if _, err := db.ExecContext(ctx, `DELETE FROM aqe_sample_usage WHERE sample_table = ?`, sampleTable); err != nil {
+        return err
+    }
+    _, err := db.ExecContext(ctx, `DELETE FROM aqe_samples WHERE sample_table = ?`, sampleTable)
+    return err
+}
+
+// DropSketch drops the sketchType sketch on table's column for good.
+func DropSketch(ctx context.Context, db *sql.DB, table, column string, sketchType SketchType) error {
+    _, err := db.ExecContext(ctx, `DELETE FROM aqe_sketches
+        WHERE table_name = ? AND COALESCE(column_name, '') = ? AND sketch_type = ?`, table, column, string(sketchType))
     return err
 }
 
-// UpsertSketch stores or updates a sketch
-func UpsertSketch(ctx context.Context, db *sql.DB, table, column, sketchType string, data []byte, parameters string) error {
+// InsertSampleMeta records a materialized sample drawn from baseRows rows.
+func InsertSampleMeta(ctx context.Context, db *sql.DB, table, sampleTable string, fraction float64, baseRows int64) error {
+    _, err := db.ExecContext(ctx, `INSERT INTO aqe_samples(table_name,sample_table,sample_fraction,base_row_count,created_at)
+        VALUES(?,?,?,?,CURRENT_TIMESTAMP)`, table, sampleTable, fraction, baseRows)
+    return err
+}
+
+// TableVersions returns opaque version strings for a table's recorded
+// statistics and for a sample table's latest materialization. Either is
+// empty when nothing has been recorded.
+func TableVersions(ctx context.Context, db *sql.DB, table, sampleTable string) (string, string, error) {
+    var stats, sample sql.NullString
+    if table != "" {
+        err := db.QueryRowContext(ctx, `SELECT row_count || '@' || updated_at FROM aqe_table_stats
+            WHERE table_name = ?`, table).Scan(&stats)
+        if err != nil && err != sql.ErrNoRows {
+            return "", "", err
+        }
+    }
+    if sampleTable != "" {
+        err := db.QueryRowContext(ctx, `SELECT MAX(id) || '@' || MAX(created_at) FROM aqe_samples
+            WHERE sample_table = ?`, sampleTable).Scan(&sample)
+        if err != nil && err != sql.ErrNoRows {
+            return "", "", err
+        }
+    }
+    return stats.String, sample.String, nil
+}
+
+// Queryer is the read side shared by *sql.DB, *sql.Conn and *sql.Tx, so
+// lookups can run inside a snapshot transaction.
+type Queryer interface {
+    QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+    QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Provenance identifies the sample or sketch behind an approximate answer
+// and how far its base table has moved since it was built.
+type Provenance struct {
+    Kind        string    `json:"kind"` // "sample", "sketch" or "join_synopsis"
+    Name        string    `json:"name"`
+    Table       string    `json:"table"`
+    Column      string    `json:"column,omitempty"`
+    Fraction    float64   `json:"fraction,omitempty"`
+    CreatedAt   time.Time `json:"created_at"`
+    BaseRows    int64     `json:"base_rows,omitempty"` // base table rows at creation; 0 when not recorded
+    AgeSeconds  float64   `json:"age_seconds"`
+    CurrentRows int64     `json:"current_rows,omitempty"`
+    RowDrift    float64   `json:"row_drift"` // (current - base) / base
+    Stale       bool      `json:"stale"`
+    Degraded    bool      `json:"degraded,omitempty"` // sketch invalidated by deletes, awaiting rebuild
+    // Live is set for a live sample, whose staleness is judged by the
+    // rows appended past its scanned rowid, LagRows, rather than by
+    // comparing row counts: it is current while it has seen them all.
+    Live    bool  `json:"live,omitempty"`
+    LagRows int64 `json:"lag_rows,omitempty"`
+    // BiasWarning is set for a block sample (see SampleInfo.BlockRows).
+    BiasWarning string `json:"bias_warning,omitempty"`
+}
+
+// SampleProvenance describes the latest materialization of sampleTable,
+// with staleness judged against currentRows. It returns nil when the
+// sample was never recorded.
+func SampleProvenance(ctx context.Context, db Queryer, sampleTable string, currentRows int64) (*Provenance, error) {
+    p := &Provenance{Kind: "sample", Name: sampleTable}
+    var baseRows sql.NullInt64
+    var createdAt, reservoir, scanned int64
+    err := db.QueryRowContext(ctx, `SELECT table_name, sample_fraction, base_row_count, `+active.Epoch("created_at")+`,
+            COALESCE(reservoir_size, 0), COALESCE(scanned_rowid, 0), COALESCE(bias_warning, '')
+        FROM aqe_samples WHERE sample_table = ? ORDER BY id DESC LIMIT 1`, sampleTable).
+        Scan(&p.Table, &p.Fraction, &baseRows, &createdAt, &reservoir, &scanned, &p.BiasWarning)
+    if err == sql.ErrNoRows {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    if reservoir > 0 {
+        if p.LagRows, err = ReservoirLag(ctx, db, p.Table, scanned); err != nil {
+            return nil, err
+        }
+        p.Live = true
+        currentRows = baseRows.Int64 + p.LagRows
+    }
+    p.assess(time.Unix(createdAt, 0), baseRows.Int64, currentRows)
+    return p, nil
+}
+
+// SketchProvenance describes the stored sketch of sketchType on
+// table.column, with staleness judged against currentRows. It returns nil
+// when no such sketch exists.
+func SketchProvenance(ctx context.Context, db Queryer, table, column, sketchType string, currentRows int64) (*Provenance, error) {
+    p := &Provenance{Kind: "sketch", Name: sketchType, Table: table, Column: column}
+    var baseRows sql.NullInt64
+    var createdAt int64
+    err := db.QueryRowContext(ctx, `SELECT base_row_count, `+active.Epoch("created_at")+`, COALESCE(degraded, 0)
+        FROM aqe_sketches WHERE table_name = ? AND column_name = ? AND sketch_type = ?`,
+        table, column, sketchType).Scan(&baseRows, &createdAt, &p.Degraded)
+    if err == sql.ErrNoRows {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    p.assess(time.Unix(createdAt, 0), baseRows.Int64, currentRows)
+    return p, nil
+}
+
+// EstimateRowCount returns an estimate of a table's current row count
+// that is cheaper than counting: its exact tracked count when it has one
+// (see TrackRowCount), else Dialect.RowCountEstimateQuery's.
+func EstimateRowCount(ctx context.Context, db Queryer, table string) (int64, error) {
+    if n, ok, err := ExactRowCount(ctx, db, table); err == nil && ok {
+        return n, nil
+    }
+    var n sql.NullInt64
+    err := db.QueryRowContext(ctx, active.RowCountEstimateQuery(table)).Scan(&n)
+    return n.Int64, err
+}
+
+func (p *Provenance) assess(createdAt time.Time, baseRows, currentRows int64) {
+    p.CreatedAt = createdAt.UTC()
+    p.AgeSeconds = time.Since(createdAt).Seconds()
+    p.BaseRows = baseRows
+    p.CurrentRows = currentRows
+    if baseRows > 0 && currentRows > 0 {
+        p.RowDrift = float64(currentRows-baseRows) / float64(baseRows)
+        p.Stale = math.Abs(p.RowDrift) > StaleRowDrift
+    }
+}
+
+// UpsertSketch stores or updates a sketch built over baseRows rows
+func UpsertSketch(ctx context.Context, db *sql.DB, table, column, sketchType string, data []byte, parameters string, baseRows int64) error {
     _, err := db.ExecContext(ctx, `
-        INSERT INTO aqe_sketches(table_name, column_name, sketch_type, sketch_data, parameters, created_at)
-        VALUES(?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+        INSERT INTO aqe_sketches(table_name, column_name, sketch_type, sketch_data, parameters, base_row_count, degraded, created_at)
+        VALUES(?, ?, ?, ?, ?, ?, 0, CURRENT_TIMESTAMP)
         ON CONFLICT(table_name, column_name, sketch_type) 
-        DO UPDATE SET sketch_data=excluded.sketch_data, parameters=excluded.parameters, created_at=CURRENT_TIMESTAMP`,
-        table, column, sketchType, data, parameters)
+        DO UPDATE SET sketch_data=excluded.sketch_data, parameters=excluded.parameters,
+            base_row_count=excluded.base_row_count, degraded=0, created_at=CURRENT_TIMESTAMP`,
+        table, column, sketchType, data, parameters, baseRows)
+    return err
+}
+
+// AppendSketch replaces a sketch's data with the same sketch updated for
+// added rows appended to its table, so it is current without a rebuild.
+// Unlike UpsertSketch it keeps the sketch's creation time and degraded flag.
+func AppendSketch(ctx context.Context, tx *sql.Tx, table, column, sketchType string, data []byte, added int64) error {
+    _, err := tx.ExecContext(ctx, `
+        UPDATE aqe_sketches SET sketch_data = ?, base_row_count = COALESCE(base_row_count, 0) + ?
+        WHERE table_name = ? AND column_name = ? AND sketch_type = ?`,
+        data, added, table, column, sketchType)
+    return err
+}
+
+// AddRecordedRows moves table's recorded row_count on by added rows, when
+// one is recorded.
+func AddRecordedRows(ctx context.Context, tx *sql.Tx, table string, added int64) error {
+    _, err := tx.ExecContext(ctx, `UPDATE aqe_table_stats SET row_count = row_count + ?, updated_at = CURRENT_TIMESTAMP
+        WHERE table_name = ?`, added, table)
     return err
 }
 
+// MarkSketchesDegraded flags every sketch on table as no longer trustworthy.
+// HyperLogLog and Count-Min sketches cannot forget deleted rows, so the
+// planner stops using them until they are rebuilt.
+func MarkSketchesDegraded(ctx context.Context, db *sql.DB, table string) (int64, error) {
+    res, err := db.ExecContext(ctx, `UPDATE aqe_sketches SET degraded = 1
+        WHERE table_name = ? AND COALESCE(degraded, 0) = 0`, table)
+    if err != nil {
+        return 0, err
+    }
+    return res.RowsAffected()
+}
+
 // GetSketch retrieves a sketch
-func GetSketch(ctx context.Context, db *sql.DB, table, column, sketchType string) ([]byte, string, error) {
+func GetSketch(ctx context.Context, db Queryer, table, column, sketchType string) ([]byte, string, error) {
     var data []byte
     var parameters string
     err := db.QueryRowContext(ctx, `
@@ -90,7 +472,8 @@ func GetSketch(ctx context.Context, db *sql.DB, table, column, sketchType string
 func ListSketches(ctx context.Context, db *sql.DB, table string) ([]SketchInfo, error) {
     rows, err := db.QueryContext(ctx, `
         SELECT column_name, sketch_type, parameters, 
-               strftime('%s', created_at) as created_at
+               `+active.Epoch("created_at")+` as created_at,
+               COALESCE(base_row_count, 0), COALESCE(degraded, 0)
         FROM aqe_sketches 
         WHERE table_name = ?
         ORDER BY created_at DESC`, table)
@@ -102,20 +485,25 @@ func ListSketches(ctx context.Context, db *sql.DB, table string) ([]SketchInfo,
     var sketches []SketchInfo
     for rows.Next() {
         var info SketchInfo
-        var column, sketchType, parameters string
+        var column, sketchType, parameters sql.NullString
         var createdAt int64
         
-        err := rows.Scan(&column, &sketchType, &parameters, &createdAt)
+        err := rows.Scan(&column, &sketchType, &parameters, &createdAt, &info.BaseRows, &info.Degraded)
         if err != nil {
             return nil, err
         }
         
         info.Table = table
-        info.Column = column
-        info.Type = SketchType(sketchType)
+        info.Column = column.String
+        info.Type = SketchType(sketchType.String)
         info.CreatedAt = createdAt
-        // Parse parameters if needed
         info.Parameters = make(map[string]interface{})
+        if parameters.String != "" {
+            _ = json.Unmarshal([]byte(parameters.String), &info.Parameters)
+        }
+        if info.Parameters == nil {
+            info.Parameters = make(map[string]interface{})
+        }
         
         sketches = append(sketches, info)
     }
@@ -130,6 +518,266 @@ type SketchInfo struct {
     Column     string     `json:"column,omitempty"`
     CreatedAt  int64      `json:"created_at"`
     Parameters map[string]interface{} `json:"parameters"`
+    BaseRows   int64      `json:"base_rows,omitempty"`
+    Degraded   bool       `json:"degraded"`
+}
+
+// SampleInfo describes the latest materialization of a sample table.
+type SampleInfo struct {
+    Table        string  `json:"table"`
+    SampleTable  string  `json:"sample_table"`
+    Fraction     float64 `json:"fraction"`
+    StrataColumn string  `json:"strata_column,omitempty"`
+    BaseRows     int64   `json:"base_rows,omitempty"`
+    // BaseRowids is set when sample rows keep their base table rowids, so
+    // deleted and updated rows can be reconciled in place.
+    BaseRowids bool `json:"base_rowids"`
+    // Columns lists the base table columns the sample copies; empty means
+    // all of them.
+    Columns []string `json:"columns,omitempty"`
+    // Archived is set while the sample table lives in the cold archive
+    // file rather than the main database (see ArchiveSample).
+    Archived bool `json:"archived,omitempty"`
+    // Predicate is set for a filtered sample: a WHERE condition, and the
+    // sample holds Fraction of the rows matching it.
+    Predicate string `json:"predicate,omitempty"`
+    // OutlierTable is set for an outlier sample: the rows whose
+    // OutlierColumn values lie furthest from its mean, at most Outliers of
+    // them, are kept exactly in OutlierTable, and SampleTable holds
+    // Fraction of the others.
+    OutlierTable  string `json:"outlier_table,omitempty"`
+    OutlierColumn string `json:"outlier_column,omitempty"`
+    Outliers      int    `json:"outliers,omitempty"`
+    // MinStratumRows is set for a stratified sample drawn with congressional
+    // allocation: every stratum holds at least that many rows, or all of
+    // its rows when it has fewer.
+    MinStratumRows int64 `json:"min_stratum_rows,omitempty"`
+    // UniverseColumn is set for a universe sample: it holds every row whose
+    // UniverseColumn value hashes below Fraction (see sampler.UniverseKeeps), so
+    // universe samples of joined tables on their join keys keep the same
+    // keys and join to a sample of the join.
+    UniverseColumn string `json:"universe_column,omitempty"`
+    // ReservoirSize is set for a live sample: a reservoir of at most that
+    // many rows, kept a uniform sample of every row up to ScannedRowid as
+    // rows are appended (see sampler.CatchUpReservoir). BaseRows counts
+    // the rows it has seen, and Fraction is its size over BaseRows.
+    ReservoirSize int64 `json:"reservoir_size,omitempty"`
+    ScannedRowid  int64 `json:"scanned_rowid,omitempty"`
+    // BlockRows is set for a block sample: it holds the rows of whole
+    // blocks of that many consecutive rowids, each kept with probability
+    // Fraction (see sampler.CreateBlockSample). BiasWarning says why its
+    // intervals may understate the error.
+    BlockRows   int64  `json:"block_rows,omitempty"`
+    BiasWarning string `json:"bias_warning,omitempty"`
+    // LagRows is how many rows a live sample has yet to see, set by
+    // callers that check it (see ReservoirLag).
+    LagRows int64 `json:"lag_rows,omitempty"`
+}
+
+// EncodeSampleColumns is the aqe_samples.sample_columns value recording
+// columns: a JSON array, or NULL for a sample of every column.
+func EncodeSampleColumns(columns []string) any {
+    if len(columns) == 0 {
+        return nil
+    }
+    data, _ := json.Marshal(columns)
+    return string(data)
+}
+
+func decodeSampleColumns(s string) []string {
+    var columns []string
+    if s != "" {
+        _ = json.Unmarshal([]byte(s), &columns)
+    }
+    return columns
+}
+
+// ListSamples returns the latest record of every sample drawn from table.
+func ListSamples(ctx context.Context, db *sql.DB, table string) ([]SampleInfo, error) {
+    rows, err := db.QueryContext(ctx, `
+        SELECT s.sample_table, s.sample_fraction, COALESCE(s.strata_column, ''),
+               COALESCE(s.base_row_count, 0), COALESCE(s.base_rowids, 0), COALESCE(s.sample_columns, ''),
+               CASE WHEN u.archive_file IS NULL THEN 0 ELSE 1 END, COALESCE(s.sample_predicate, ''),
+               COALESCE(s.outlier_table, ''), COALESCE(s.outlier_column, ''), COALESCE(s.outlier_count, 0),
+               COALESCE(s.min_stratum_rows, 0), COALESCE(s.universe_column, ''),
+               COALESCE(s.reservoir_size, 0), COALESCE(s.scanned_rowid, 0),
+               COALESCE(s.block_rows, 0), COALESCE(s.bias_warning, '')
+        FROM aqe_samples s
+        LEFT JOIN aqe_sample_usage u ON u.sample_table = s.sample_table
+        WHERE s.table_name = ? AND s.id = (
+            SELECT MAX(id) FROM aqe_samples WHERE sample_table = s.sample_table)
+        ORDER BY s.sample_table`, table)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var samples []SampleInfo
+    for rows.Next() {
+        info := SampleInfo{Table: table}
+        var columns string
+        if err := rows.Scan(&info.SampleTable, &info.Fraction, &info.StrataColumn, &info.BaseRows, &info.BaseRowids, &columns, &info.Archived, &info.Predicate,
+            &info.OutlierTable, &info.OutlierColumn, &info.Outliers, &info.MinStratumRows, &info.UniverseColumn,
+            &info.ReservoirSize, &info.ScannedRowid, &info.BlockRows, &info.BiasWarning); err != nil {
+            return nil, err
+        }
+        info.Columns = decodeSampleColumns(columns)
+        samples = append(samples, info)
+    }
+    return samples, rows.Err()
+}
+
+// LookupSample returns the latest record of sampleTable, or nil when it
+// was never recorded, e.g. because it was created outside the API.
+func LookupSample(ctx context.Context, db Queryer, sampleTable string) (*SampleInfo, error) {
+    info := &SampleInfo{SampleTable: sampleTable}
+    var columns string
+    err := db.QueryRowContext(ctx, `
+        SELECT s.table_name, s.sample_fraction, COALESCE(s.strata_column, ''),
+               COALESCE(s.base_row_count, 0), COALESCE(s.base_rowids, 0), COALESCE(s.sample_columns, ''),
+               CASE WHEN u.archive_file IS NULL THEN 0 ELSE 1 END, COALESCE(s.sample_predicate, ''),
+               COALESCE(s.outlier_table, ''), COALESCE(s.outlier_column, ''), COALESCE(s.outlier_count, 0),
+               COALESCE(s.min_stratum_rows, 0), COALESCE(s.universe_column, ''),
+               COALESCE(s.reservoir_size, 0), COALESCE(s.scanned_rowid, 0),
+               COALESCE(s.block_rows, 0), COALESCE(s.bias_warning, '')
+        FROM aqe_samples s
+        LEFT JOIN aqe_sample_usage u ON u.sample_table = s.sample_table
+        WHERE s.sample_table = ? ORDER BY s.id DESC LIMIT 1`, sampleTable).
+        Scan(&info.Table, &info.Fraction, &info.StrataColumn, &info.BaseRows, &info.BaseRowids, &columns, &info.Archived, &info.Predicate,
+            &info.OutlierTable, &info.OutlierColumn, &info.Outliers, &info.MinStratumRows, &info.UniverseColumn,
+            &info.ReservoirSize, &info.ScannedRowid, &info.BlockRows, &info.BiasWarning)
+    if err == sql.ErrNoRows {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    info.Columns = decodeSampleColumns(columns)
+    return info, nil
+}
+
+// ReservoirLag returns how many of table's rows lie past scannedRowid, the
+// rows appended since a live sample last saw its table: a range read of
+// the rowid index, short while the sample keeps up.
+func ReservoirLag(ctx context.Context, db Queryer, table string, scannedRowid int64) (int64, error) {
+    if err := RequireSQLite("live samples"); err != nil {
+        return 0, err
+    }
+    var n int64
+    err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT count(*) FROM %s WHERE rowid > ?", table), scannedRowid).Scan(&n)
+    return n, err
+}
+
+// StratumFraction returns the share of its stratum's rows that the
+// stratified sample sampleTable holds for the strata value value. ok is
+// false when the sample has no such stratum.
+func StratumFraction(ctx context.Context, db Queryer, sampleTable, value string) (fraction float64, ok bool, err error) {
+    err = db.QueryRowContext(ctx, `
+        SELECT fraction FROM aqe_strata_info
+        WHERE sample_table = ? AND strata_value = ? ORDER BY id DESC LIMIT 1`, sampleTable, value).Scan(&fraction)
+    if err == sql.ErrNoRows {
+        return 0, false, nil
+    }
+    if err != nil {
+        return 0, false, err
+    }
+    return fraction, true, nil
+}
+
+// StratumFractions returns the share of each stratum's rows that the
+// stratified sample sampleTable holds, by strata value, and the share of
+// all the strata's rows it holds.
+func StratumFractions(ctx context.Context, db Queryer, sampleTable string) (fractions map[string]float64, share float64, err error) {
+    rows, err := db.QueryContext(ctx, `
+        SELECT strata_value, pop_size, sample_size, fraction FROM aqe_strata_info
+        WHERE sample_table = ? ORDER BY id`, sampleTable)
+    if err != nil {
+        return nil, 0, err
+    }
+    defer rows.Close()
+    type stratum struct{ pop, size int64 }
+    strata := make(map[string]stratum)
+    fractions = make(map[string]float64)
+    for rows.Next() {
+        var value string
+        var st stratum
+        var fraction float64
+        if err := rows.Scan(&value, &st.pop, &st.size, &fraction); err != nil {
+            return nil, 0, err
+        }
+        // later draws override earlier ones
+        strata[value], fractions[value] = st, fraction
+    }
+    if err := rows.Err(); err != nil {
+        return nil, 0, err
+    }
+    var pop, size int64
+    for _, st := range strata {
+        pop += st.pop
+        size += st.size
+    }
+    if pop > 0 {
+        share = float64(size) / float64(pop)
+    }
+    return fractions, share, nil
+}
+
+// TableColumns returns table's column names in order.
+func TableColumns(ctx context.Context, db Queryer, table string) ([]string, error) {
+    rows, err := db.QueryContext(ctx, active.TableColumnsQuery(), table)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var cols []string
+    for rows.Next() {
+        var name string
+        if err := rows.Scan(&name); err != nil {
+            return nil, err
+        }
+        cols = append(cols, name)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    if len(cols) == 0 {
+        return nil, fmt.Errorf("table %s has no columns", table)
+    }
+    return cols, nil
+}
+
+// SynopsisTables returns every table with a recorded sample or sketch.
+func SynopsisTables(ctx context.Context, db *sql.DB) ([]string, error) {
+    rows, err := db.QueryContext(ctx, `
+        SELECT table_name FROM aqe_samples
+        UNION
+        SELECT table_name FROM aqe_sketches
+        ORDER BY 1`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var tables []string
+    for rows.Next() {
+        var t string
+        if err := rows.Scan(&t); err != nil {
+            return nil, err
+        }
+        tables = append(tables, t)
+    }
+    return tables, rows.Err()
+}
+
+// RecordedRowCount returns the row_count last recorded for table, or 0.
+func RecordedRowCount(ctx context.Context, db *sql.DB, table string) (int64, error) {
+    var n int64
+    err := db.QueryRowContext(ctx, `SELECT row_count FROM aqe_table_stats WHERE table_name = ?`, table).Scan(&n)
+    if err == sql.ErrNoRows {
+        return 0, nil
+    }
+    return n, err
 }
 
 // SketchType represents the type of sketch
@@ -138,4 +786,8 @@ type SketchType string
 const (
     HyperLogLogType   SketchType = "hyperloglog"
     CountMinSketchType SketchType = "countmin"
+    TDigestType        SketchType = "tdigest"
+    BloomFilterType    SketchType = "bloom"
+    AMSSketchType      SketchType = "ams"
+    TopKSketchType     SketchType = "topk"
 )
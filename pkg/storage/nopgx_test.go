@@ -0,0 +1,6 @@
+//go:build !postgres
+
+package storage
+
+// postgresLinked is whether the test binary has pgx's driver registered.
+const postgresLinked = false
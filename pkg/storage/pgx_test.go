@@ -0,0 +1,8 @@
+//go:build postgres
+
+package storage
+
+import _ "github.com/jackc/pgx/v5/stdlib"
+
+// postgresLinked is whether the test binary has pgx's driver registered.
+const postgresLinked = true
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// TablePolicy is a table's default accuracy: the tolerance and confidence
+// level queries over it are answered at when they set none of their own.
+// Zero fields leave the query's setting alone.
+type TablePolicy struct {
+	Table           string    `json:"table"`
+	MaxRelError     float64   `json:"max_rel_error"`
+	ConfidenceLevel float64   `json:"confidence_level"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// SaveTablePolicy replaces the policy registered for p.Table.
+func SaveTablePolicy(ctx context.Context, db *sql.DB, p *TablePolicy) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO aqe_table_policies(table_name, max_rel_error, confidence_level, updated_at)
+		VALUES(?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(table_name) DO UPDATE SET max_rel_error=excluded.max_rel_error,
+			confidence_level=excluded.confidence_level, updated_at=CURRENT_TIMESTAMP`,
+		p.Table, p.MaxRelError, p.ConfidenceLevel)
+	return err
+}
+
+// GetTablePolicy returns the policy registered for table, or nil when none
+// is.
+func GetTablePolicy(ctx context.Context, db Queryer, table string) (*TablePolicy, error) {
+	p := &TablePolicy{Table: table}
+	var updated int64
+	err := db.QueryRowContext(ctx, `SELECT max_rel_error, confidence_level, `+active.Epoch("updated_at")+`
+		FROM aqe_table_policies WHERE table_name = ?`, table).Scan(&p.MaxRelError, &p.ConfidenceLevel, &updated)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.UpdatedAt = time.Unix(updated, 0).UTC()
+	return p, nil
+}
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// postgresBaseDriver is the database/sql driver that talks to PostgreSQL.
+// AQE doesn't link it by default; building with -tags postgres registers
+// pgx's under this name (see cmd/aqe-server/postgres.go).
+const postgresBaseDriver = "pgx"
+
+// postgresDriver is the name AQE registers its wrapper around the base
+// driver under. The wrapper rewrites ? placeholders to $1, $2, ... so
+// statements are written once for both databases.
+const postgresDriver = "aqe-postgres"
+
+var registerPostgres = sync.OnceValue(func() error {
+	base, err := sql.Open(postgresBaseDriver, "")
+	if err != nil {
+		return fmt.Errorf("PostgreSQL support is not compiled in; rebuild with -tags postgres: %w", err)
+	}
+	sql.Register(postgresDriver, rebindDriver{base.Driver()})
+	return base.Close()
+})
+
+// IsPostgresDSN reports whether dsn is a PostgreSQL connection URL rather
+// than a SQLite path.
+func IsPostgresDSN(dsn string) bool {
+	return strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://")
+}
+
+// Open opens the database named by dsn, a postgres:// URL or a SQLite
+// path, and makes its dialect the active one.
+func Open(dsn string) (*sql.DB, error) {
+	if !IsPostgresDSN(dsn) {
+		SetDialect(SQLite)
+		return sql.Open("sqlite", dsn)
+	}
+	if err := registerPostgres(); err != nil {
+		return nil, err
+	}
+	SetDialect(Postgres)
+	return sql.Open(postgresDriver, dsn)
+}
+
+// Rebind rewrites the ? placeholders in query to PostgreSQL's $1, $2, ...,
+// leaving question marks inside string literals, quoted identifiers and
+// comments alone.
+func Rebind(query string) string {
+	if !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(query[i+1:], c)
+			if end < 0 {
+				b.WriteString(query[i:])
+				return b.String()
+			}
+			b.WriteString(query[i : i+end+2])
+			i += end + 1
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				b.WriteString(query[i:])
+				return b.String()
+			}
+			b.WriteString(query[i : i+end+1])
+			i += end
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				b.WriteString(query[i:])
+				return b.String()
+			}
+			b.WriteString(query[i : i+end+4])
+			i += end + 3
+		case c == '?':
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// rebindDriver wraps a driver so every statement goes through Rebind.
+type rebindDriver struct {
+	base driver.Driver
+}
+
+func (d rebindDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.base.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return rebindConn{c}, nil
+}
+
+// rebindConn forwards to the base connection, rebinding statement text on
+// the way. The optional interfaces it implements return driver.ErrSkip when
+// the base connection lacks them, so database/sql falls back as it would
+// have without the wrapper.
+type rebindConn struct {
+	driver.Conn
+}
+
+func (c rebindConn) Prepare(query string) (driver.Stmt, error) {
+	return c.Conn.Prepare(Rebind(query))
+}
+
+func (c rebindConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, Rebind(query))
+	}
+	return c.Conn.Prepare(Rebind(query))
+}
+
+func (c rebindConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if q, ok := c.Conn.(driver.QueryerContext); ok {
+		return q.QueryContext(ctx, Rebind(query), args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c rebindConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if e, ok := c.Conn.(driver.ExecerContext); ok {
+		return e.ExecContext(ctx, Rebind(query), args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c rebindConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c rebindConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c rebindConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c rebindConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c rebindConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if ch, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return ch.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRebind(t *testing.T) {
+	for _, c := range []struct{ in, want string }{
+		{"SELECT 1", "SELECT 1"},
+		{"SELECT * FROM t WHERE a = ? AND b = ?", "SELECT * FROM t WHERE a = $1 AND b = $2"},
+		{"SELECT '?' FROM t WHERE a = ?", "SELECT '?' FROM t WHERE a = $1"},
+		{`SELECT "a?" FROM t WHERE a = ?`, `SELECT "a?" FROM t WHERE a = $1`},
+		{"SELECT 'it''s ?' , ?", "SELECT 'it''s ?' , $1"},
+		{"SELECT ? -- why?\n, ?", "SELECT $1 -- why?\n, $2"},
+		{"SELECT /* ? */ ?", "SELECT /* ? */ $1"},
+		{"SELECT 'unterminated ?", "SELECT 'unterminated ?"},
+	} {
+		if got := Rebind(c.in); got != c.want {
+			t.Errorf("Rebind(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestIsPostgresDSN(t *testing.T) {
+	for dsn, want := range map[string]bool{
+		"postgres://aqe@localhost/analytics":   true,
+		"postgresql://aqe@localhost/analytics": true,
+		"aqe.db":                               false,
+		"file:aqe.db?mode=ro":                  false,
+		"/var/lib/postgres/aqe.db":             false,
+	} {
+		if got := IsPostgresDSN(dsn); got != want {
+			t.Errorf("IsPostgresDSN(%q) = %v, want %v", dsn, got, want)
+		}
+	}
+}
+
+func TestOpenSelectsDialect(t *testing.T) {
+	defer SetDialect(SQLite)
+
+	SetDialect(Postgres)
+	db, err := Open(filepath.Join(t.TempDir(), "aqe.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if ActiveDialect() != SQLite {
+		t.Errorf("dialect after opening a SQLite path is %s, want sqlite", ActiveDialect().Name())
+	}
+
+	db, err = Open("postgres://aqe@localhost:1/analytics")
+	if !postgresLinked {
+		if err == nil {
+			db.Close()
+			t.Fatal("opened a postgres:// URL without pgx linked in")
+		}
+		if ActiveDialect() != SQLite {
+			t.Errorf("dialect after a failed open is %s, want sqlite", ActiveDialect().Name())
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if ActiveDialect() != Postgres {
+		t.Errorf("dialect after opening a postgres:// URL is %s, want postgres", ActiveDialect().Name())
+	}
+}
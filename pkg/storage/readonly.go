@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"net/url"
+	"strings"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// ReadOnlyDSN turns a SQLite path into a URI that opens the file read-only
+// and runs PRAGMA query_only on every connection, so nothing can be written
+// through it, temp tables included (see AllowTempTables). ATTACH, which
+// mode=ro alone does not stop, is refused on those connections too: Open
+// sets their attached-database limit to zero. A PostgreSQL URL gets
+// sessions whose transactions default to read-only, which still allows
+// temp tables.
+func ReadOnlyDSN(path string) string {
+	if IsPostgresDSN(path) {
+		sep := "?"
+		if strings.Contains(path, "?") {
+			sep = "&"
+		}
+		return path + sep + "default_transaction_read_only=on"
+	}
+	if !strings.HasPrefix(path, "file:") {
+		path = "file:" + path
+	}
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return path + sep + "mode=ro&_pragma=query_only(1)"
+}
+
+func init() {
+	sqlite.RegisterConnectionHook(func(conn sqlite.ExecQuerierContext, dsn string) error {
+		if !isReadOnlyDSN(dsn) {
+			return nil
+		}
+		c, err := sqliteConnOf(conn)
+		if err != nil {
+			return err
+		}
+		c.Lock()
+		defer c.Unlock()
+		sqlite3.Xsqlite3_limit(c.tls, c.db, sqlite3.SQLITE_LIMIT_ATTACHED, 0)
+		return nil
+	})
+}
+
+// isReadOnlyDSN reports whether dsn is a SQLite URI opening its file with
+// mode=ro.
+func isReadOnlyDSN(dsn string) bool {
+	_, query, ok := strings.Cut(dsn, "?")
+	if !ok {
+		return false
+	}
+	q, err := url.ParseQuery(query)
+	return err == nil && q.Get("mode") == "ro"
+}
+
+// AllowTempTables lifts query_only on conn, a connection of a read-only
+// handle, so it can create and fill temp tables; the main file stays
+// read-only and ATTACH stays refused. restore must be called before conn is
+// closed: it turns query_only back on, or, failing that, has conn discarded
+// instead of returned to the pool. On PostgreSQL it does nothing.
+func AllowTempTables(ctx context.Context, conn *sql.Conn) (restore func(), err error) {
+	if active.Name() != "sqlite" {
+		return func() {}, nil
+	}
+	if _, err := conn.ExecContext(ctx, "PRAGMA query_only = 0"); err != nil {
+		return nil, err
+	}
+	return func() {
+		if _, err := conn.ExecContext(context.Background(), "PRAGMA query_only = 1"); err != nil {
+			conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+	}, nil
+}
+
+// OpenReadOnly opens a second handle on the database at path, as Open
+// would, that refuses writes at the connection level. It is used for user
+// query execution, as defense in depth behind the SELECT-only validation,
+// while metadata writes keep going through the writer handle.
+func OpenReadOnly(path string) (*sql.DB, error) {
+	db, err := Open(ReadOnlyDSN(path))
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// IsReadOnly reports whether err is the database refusing a write on a
+// read-only connection.
+func IsReadOnly(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "sqlite_readonly") ||
+		strings.Contains(msg, "readonly database") ||
+		strings.Contains(msg, "read-only transaction")
+}
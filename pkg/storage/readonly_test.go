@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadOnlyRefusesWrites(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ro.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE t (a INTEGER); INSERT INTO t VALUES (1)"); err != nil {
+		t.Fatal(err)
+	}
+	ro, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+
+	for _, stmt := range []string{
+		"INSERT INTO t VALUES (2)",
+		"DELETE FROM t",
+		"CREATE TABLE u (a INTEGER)",
+		"CREATE TEMP TABLE v (a INTEGER)",
+		"ATTACH DATABASE '" + path + "' AS again",
+		"ATTACH DATABASE '" + filepath.Join(dir, "new.db") + "' AS fresh",
+	} {
+		if _, err := ro.Exec(stmt); err == nil {
+			t.Errorf("%s through the read-only handle succeeded", stmt)
+		}
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM t").Scan(&n); err != nil || n != 1 {
+		t.Fatalf("t has %d rows (%v), want 1", n, err)
+	}
+	if m, _ := filepath.Glob(filepath.Join(dir, "new.db")); len(m) > 0 {
+		t.Error("ATTACH through the read-only handle created a file")
+	}
+}
+
+func TestAllowTempTables(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "temp.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE t (a INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+	ro, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	ro.SetMaxOpenConns(1)
+
+	ctx := context.Background()
+	conn, err := ro.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restore, err := AllowTempTables(ctx, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.ExecContext(ctx, "CREATE TEMP TABLE v (a INTEGER); INSERT INTO v VALUES (1); DROP TABLE v"); err != nil {
+		t.Errorf("temp table after AllowTempTables: %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, "INSERT INTO t VALUES (1)"); err == nil {
+		t.Error("AllowTempTables let a write to the main file through")
+	}
+	restore()
+	conn.Close()
+
+	// the pool's one connection is query_only again
+	if _, err := ro.Exec("CREATE TEMP TABLE v (a INTEGER)"); err == nil {
+		t.Error("query_only was not restored")
+	}
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"time"
+)
+
+// RefreshPolicy is how a table's samples and sketches are refreshed in the
+// background: each once the table's row count drifts from the count it
+// was built on by more than MaxRowDrift (0 for the server's threshold),
+// and, with Checksum, all of them once the table's contents checksum
+// changes, which catches updates that leave the count alone. Disabled
+// opts the table out.
+type RefreshPolicy struct {
+	Table       string  `json:"table"`
+	MaxRowDrift float64 `json:"max_row_drift"`
+	Checksum    bool    `json:"checksum"`
+	Disabled    bool    `json:"disabled"`
+	// LastChecksum is the table's checksum when it was last checked, and
+	// CheckedAt when that was.
+	LastChecksum string     `json:"last_checksum,omitempty"`
+	CheckedAt    *time.Time `json:"checked_at,omitempty"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// SaveRefreshPolicy replaces the policy registered for p.Table, keeping
+// the checksum last recorded for it.
+func SaveRefreshPolicy(ctx context.Context, db *sql.DB, p *RefreshPolicy) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO aqe_refresh_policies(table_name, max_row_drift, checksum, disabled, updated_at)
+		VALUES(?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(table_name) DO UPDATE SET max_row_drift=excluded.max_row_drift, checksum=excluded.checksum,
+			disabled=excluded.disabled, updated_at=CURRENT_TIMESTAMP`,
+		p.Table, p.MaxRowDrift, p.Checksum, p.Disabled)
+	return err
+}
+
+// GetRefreshPolicy returns the policy registered for table, or nil when
+// none is.
+func GetRefreshPolicy(ctx context.Context, db Queryer, table string) (*RefreshPolicy, error) {
+	p := &RefreshPolicy{Table: table}
+	var checked sql.NullInt64
+	var updated int64
+	err := db.QueryRowContext(ctx, `SELECT max_row_drift, checksum, disabled, COALESCE(last_checksum, ''),
+			`+active.Epoch("checked_at")+`, `+active.Epoch("updated_at")+`
+		FROM aqe_refresh_policies WHERE table_name = ?`, table).
+		Scan(&p.MaxRowDrift, &p.Checksum, &p.Disabled, &p.LastChecksum, &checked, &updated)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if checked.Valid {
+		t := time.Unix(checked.Int64, 0).UTC()
+		p.CheckedAt = &t
+	}
+	p.UpdatedAt = time.Unix(updated, 0).UTC()
+	return p, nil
+}
+
+// DeleteRefreshPolicy removes table's policy, reporting whether it had one.
+func DeleteRefreshPolicy(ctx context.Context, db *sql.DB, table string) (bool, error) {
+	res, err := db.ExecContext(ctx, `DELETE FROM aqe_refresh_policies WHERE table_name = ?`, table)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// RecordTableChecksum stores checksum as table's latest, for its policy.
+func RecordTableChecksum(ctx context.Context, db *sql.DB, table, checksum string) error {
+	_, err := db.ExecContext(ctx, `UPDATE aqe_refresh_policies SET last_checksum = ?, checked_at = CURRENT_TIMESTAMP
+		WHERE table_name = ?`, checksum, table)
+	return err
+}
+
+// TableChecksum is a checksum of table's contents that ignores row order:
+// the sum of a hash of every row, so inserts, deletes and updates all
+// change it. It reads the whole table.
+func TableChecksum(ctx context.Context, db Queryer, table string) (string, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT * FROM %s", table))
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	values := make([]any, len(cols))
+	dest := make([]any, len(cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	h := fnv.New64a()
+	var sum uint64
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return "", err
+		}
+		h.Reset()
+		for _, v := range values {
+			fmt.Fprintf(h, "%T:%v\x00", v, v)
+		}
+		sum += h.Sum64()
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	return strconv.FormatUint(sum, 16), nil
+}
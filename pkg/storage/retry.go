@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned when the circuit breaker is shedding load.
+var ErrCircuitOpen = errors.New("storage circuit breaker open: database is saturated, retry later")
+
+// RetryPolicy controls how transient database errors are retried.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+}
+
+// DefaultRetryPolicy retries busy/locked errors a few times with exponential backoff.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    4,
+		InitialBackoff: 25 * time.Millisecond,
+		MaxBackoff:     500 * time.Millisecond,
+		Multiplier:     2.0,
+	}
+}
+
+// IsRetryable reports whether err is a transient contention error: SQLite
+// busy or locked, or a PostgreSQL serialization failure or deadlock.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "sqlite_busy") ||
+		strings.Contains(msg, "sqlite_locked") ||
+		strings.Contains(msg, "database is locked") ||
+		strings.Contains(msg, "database table is locked") ||
+		strings.Contains(msg, "could not serialize access") ||
+		strings.Contains(msg, "deadlock detected")
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+// CircuitBreaker trips after consecutive retryable failures and rejects calls
+// until the cooldown has elapsed, then lets a single probe through.
+type CircuitBreaker struct {
+	mu               sync.Mutex
+	state            breakerState
+	failures         int
+	failureThreshold int
+	cooldown         time.Duration
+	openedAt         time.Time
+	probeInFlight    bool
+}
+
+// NewCircuitBreaker creates a breaker that opens after threshold consecutive failures.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	if threshold <= 0 {
+		threshold = 5
+	}
+	if cooldown <= 0 {
+		cooldown = 10 * time.Second
+	}
+	return &CircuitBreaker{failureThreshold: threshold, cooldown: cooldown}
+}
+
+// Allow reports whether a call may proceed.
+func (cb *CircuitBreaker) Allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case breakerOpen:
+		if time.Since(cb.openedAt) < cb.cooldown {
+			return false
+		}
+		cb.state = breakerHalfOpen
+		cb.probeInFlight = true
+		return true
+	case breakerHalfOpen:
+		if cb.probeInFlight {
+			return false
+		}
+		cb.probeInFlight = true
+		return true
+	default:
+		return true
+	}
+}
+
+// Record updates breaker state with the outcome of a call. Only retryable
+// (saturation) errors count as failures; query errors are the caller's problem.
+func (cb *CircuitBreaker) Record(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.probeInFlight = false
+	if !IsRetryable(err) {
+		cb.state = breakerClosed
+		cb.failures = 0
+		return
+	}
+
+	cb.failures++
+	if cb.state == breakerHalfOpen || cb.failures >= cb.failureThreshold {
+		cb.state = breakerOpen
+		cb.openedAt = time.Now()
+	}
+}
+
+// State returns the breaker state as a string for health reporting.
+func (cb *CircuitBreaker) State() string {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state.String()
+}
+
+// Guard combines a retry policy with a circuit breaker around database operations.
+type Guard struct {
+	policy  RetryPolicy
+	breaker *CircuitBreaker
+}
+
+// NewGuard creates a Guard. A nil breaker disables load shedding.
+func NewGuard(policy RetryPolicy, breaker *CircuitBreaker) *Guard {
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = 1
+	}
+	if policy.Multiplier < 1 {
+		policy.Multiplier = 1
+	}
+	return &Guard{policy: policy, breaker: breaker}
+}
+
+// Do runs fn, retrying transient errors with backoff. It returns ErrCircuitOpen
+// without calling fn when the breaker is shedding load.
+func (g *Guard) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	backoff := g.policy.InitialBackoff
+	var err error
+
+	for attempt := 1; attempt <= g.policy.MaxAttempts; attempt++ {
+		if g.breaker != nil && !g.breaker.Allow() {
+			return ErrCircuitOpen
+		}
+
+		err = fn(ctx)
+		if g.breaker != nil {
+			g.breaker.Record(err)
+		}
+		if !IsRetryable(err) || attempt == g.policy.MaxAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff = time.Duration(float64(backoff) * g.policy.Multiplier)
+		if g.policy.MaxBackoff > 0 && backoff > g.policy.MaxBackoff {
+			backoff = g.policy.MaxBackoff
+		}
+	}
+
+	return err
+}
+
+// DoOnce runs fn once, behind the circuit breaker like Do and counting
+// toward it, but never retries: for work that is not safe to repeat, such
+// as drawing a sample, which a failure can leave half-written.
+func (g *Guard) DoOnce(ctx context.Context, fn func(ctx context.Context) error) error {
+	if g.breaker != nil && !g.breaker.Allow() {
+		return ErrCircuitOpen
+	}
+	err := fn(ctx)
+	if g.breaker != nil {
+		g.breaker.Record(err)
+	}
+	return err
+}
+
+// BreakerState reports the current circuit breaker state.
+func (g *Guard) BreakerState() string {
+	if g.breaker == nil {
+		return breakerClosed.String()
+	}
+	return g.breaker.State()
+}
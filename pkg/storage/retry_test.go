@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGuardDoOnce(t *testing.T) {
+	busy := errors.New("database is locked (5) (SQLITE_BUSY)")
+	g := NewGuard(RetryPolicy{MaxAttempts: 4, InitialBackoff: time.Millisecond}, NewCircuitBreaker(2, time.Hour))
+	ctx := context.Background()
+
+	calls := 0
+	fn := func(context.Context) error {
+		calls++
+		return busy
+	}
+	for i := 0; i < 2; i++ {
+		if err := g.DoOnce(ctx, fn); !errors.Is(err, busy) {
+			t.Fatalf("DoOnce = %v, want the busy error", err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("fn ran %d times over two DoOnce calls, want 2", calls)
+	}
+	// both failures counted: the breaker is open
+	if err := g.DoOnce(ctx, fn); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("third DoOnce = %v, want ErrCircuitOpen", err)
+	}
+	if calls != 2 {
+		t.Errorf("fn ran with the breaker open")
+	}
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// The semantic roles a table's columns can be registered with.
+const (
+	RoleTime      = "time"
+	RoleMeasure   = "measure"
+	RoleDimension = "dimension"
+)
+
+// TableRoles are the roles declared for a table's columns: timestamps,
+// the measures aggregated, and the dimensions grouped and filtered by.
+// Columns not listed have no declared role.
+type TableRoles struct {
+	Table       string    `json:"table"`
+	TimeColumns []string  `json:"time_columns"`
+	Measures    []string  `json:"measures"`
+	Dimensions  []string  `json:"dimensions"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Role is column's declared role, or "" when it has none. A nil r has no
+// roles.
+func (r *TableRoles) Role(column string) string {
+	if r == nil {
+		return ""
+	}
+	for role, cols := range map[string][]string{RoleTime: r.TimeColumns, RoleMeasure: r.Measures, RoleDimension: r.Dimensions} {
+		for _, c := range cols {
+			if strings.EqualFold(c, column) {
+				return role
+			}
+		}
+	}
+	return ""
+}
+
+// Columns are every column with a declared role, times first, then
+// measures, then dimensions.
+func (r *TableRoles) Columns() []string {
+	if r == nil {
+		return nil
+	}
+	out := append([]string(nil), r.TimeColumns...)
+	out = append(out, r.Measures...)
+	return append(out, r.Dimensions...)
+}
+
+// SaveTableRoles replaces the roles declared for r.Table.
+func SaveTableRoles(ctx context.Context, db *sql.DB, r *TableRoles) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM aqe_table_roles WHERE table_name = ?`, r.Table); err != nil {
+		return err
+	}
+	position := 0
+	for _, group := range []struct {
+		role string
+		cols []string
+	}{{RoleTime, r.TimeColumns}, {RoleMeasure, r.Measures}, {RoleDimension, r.Dimensions}} {
+		for _, c := range group.cols {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO aqe_table_roles(table_name, column_name, role, position, updated_at)
+				VALUES(?, ?, ?, ?, CURRENT_TIMESTAMP)`, r.Table, c, group.role, position); err != nil {
+				return err
+			}
+			position++
+		}
+	}
+	return tx.Commit()
+}
+
+// GetTableRoles returns the roles declared for table, or nil when none
+// are.
+func GetTableRoles(ctx context.Context, db Queryer, table string) (*TableRoles, error) {
+	rows, err := db.QueryContext(ctx, `SELECT column_name, role, `+active.Epoch("updated_at")+`
+		FROM aqe_table_roles WHERE table_name = ? ORDER BY position`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var r *TableRoles
+	for rows.Next() {
+		var column, role string
+		var updated int64
+		if err := rows.Scan(&column, &role, &updated); err != nil {
+			return nil, err
+		}
+		if r == nil {
+			r = &TableRoles{Table: table, TimeColumns: []string{}, Measures: []string{}, Dimensions: []string{}}
+		}
+		switch role {
+		case RoleTime:
+			r.TimeColumns = append(r.TimeColumns, column)
+		case RoleMeasure:
+			r.Measures = append(r.Measures, column)
+		case RoleDimension:
+			r.Dimensions = append(r.Dimensions, column)
+		}
+		if t := time.Unix(updated, 0).UTC(); t.After(r.UpdatedAt) {
+			r.UpdatedAt = t
+		}
+	}
+	return r, rows.Err()
+}
+
+// DeleteTableRoles removes the roles declared for table, reporting whether
+// there were any.
+func DeleteTableRoles(ctx context.Context, db *sql.DB, table string) (bool, error) {
+	res, err := db.ExecContext(ctx, `DELETE FROM aqe_table_roles WHERE table_name = ?`, table)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
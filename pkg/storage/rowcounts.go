@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RowCountTriggerPrefix starts the name of the triggers that keep a
+// tracked table's row count in aqe_row_counts.
+const RowCountTriggerPrefix = "aqe_rowcount_"
+
+// TrackedRowCount is a table whose exact row count is kept up to date by
+// triggers on it. Active is false when the triggers are gone, e.g. after
+// the table was dropped and created again, and the count is no longer
+// trusted.
+type TrackedRowCount struct {
+	Table     string    `json:"table"`
+	Rows      int64     `json:"rows"`
+	Active    bool      `json:"active"`
+	TrackedAt time.Time `json:"tracked_at"`
+}
+
+func rowCountTriggers(table string) (insert, remove string) {
+	t := strings.ToLower(table)
+	return RowCountTriggerPrefix + "ins_" + t, RowCountTriggerPrefix + "del_" + t
+}
+
+// TrackRowCount counts table's rows and adds triggers that keep the count
+// in aqe_row_counts as rows are inserted and deleted, by any connection.
+// Counting and adding the triggers is one transaction, so no write is
+// missed; tracking a tracked table again recounts it. An INSERT OR REPLACE
+// that replaces a row counts as an insert unless the writing connection
+// enables recursive_triggers, so such tables need recounting now and then.
+// SQLite only.
+func TrackRowCount(ctx context.Context, db *sql.DB, table string) (int64, error) {
+	if err := RequireSQLite("row count tracking"); err != nil {
+		return 0, err
+	}
+	key := strings.ToLower(table)
+	literal := "'" + strings.ReplaceAll(key, "'", "''") + "'"
+	insert, remove := rowCountTriggers(table)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	stmts := []string{
+		"DROP TRIGGER IF EXISTS " + quoteIdent(insert),
+		"DROP TRIGGER IF EXISTS " + quoteIdent(remove),
+		fmt.Sprintf(`CREATE TRIGGER %s AFTER INSERT ON %s BEGIN
+			UPDATE aqe_row_counts SET row_count = row_count + 1 WHERE table_name = %s; END`,
+			quoteIdent(insert), quoteIdent(table), literal),
+		fmt.Sprintf(`CREATE TRIGGER %s AFTER DELETE ON %s BEGIN
+			UPDATE aqe_row_counts SET row_count = row_count - 1 WHERE table_name = %s; END`,
+			quoteIdent(remove), quoteIdent(table), literal),
+	}
+	for _, s := range stmts {
+		if _, err := tx.ExecContext(ctx, s); err != nil {
+			return 0, err
+		}
+	}
+	var n int64
+	if err := tx.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s", quoteIdent(table))).Scan(&n); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO aqe_row_counts(table_name, row_count, tracked_at)
+		VALUES(?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(table_name) DO UPDATE SET row_count = excluded.row_count, tracked_at = CURRENT_TIMESTAMP`,
+		key, n); err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// UntrackRowCount drops table's row count triggers and its count. It
+// reports whether the table was tracked.
+func UntrackRowCount(ctx context.Context, db *sql.DB, table string) (bool, error) {
+	if err := RequireSQLite("row count tracking"); err != nil {
+		return false, err
+	}
+	insert, remove := rowCountTriggers(table)
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	for _, trigger := range []string{insert, remove} {
+		if _, err := tx.ExecContext(ctx, "DROP TRIGGER IF EXISTS "+quoteIdent(trigger)); err != nil {
+			return false, err
+		}
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM aqe_row_counts WHERE table_name = ?`, strings.ToLower(table))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, tx.Commit()
+}
+
+// ExactRowCount returns table's tracked row count, a single-row lookup,
+// and whether it has one: the table is tracked and its triggers are in
+// place.
+func ExactRowCount(ctx context.Context, db Queryer, table string) (int64, bool, error) {
+	if active.Name() != "sqlite" {
+		return 0, false, nil
+	}
+	insert, remove := rowCountTriggers(table)
+	var n int64
+	err := db.QueryRowContext(ctx, `SELECT row_count FROM aqe_row_counts WHERE table_name = ?
+		AND (SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name IN (?, ?) AND tbl_name = ? COLLATE NOCASE) = 2`,
+		strings.ToLower(table), insert, remove, table).Scan(&n)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return n, true, nil
+}
+
+// CountRows returns table's exact row count: its tracked count when it has
+// one, else COUNT(*).
+func CountRows(ctx context.Context, db Queryer, table string) (int64, error) {
+	if n, ok, err := ExactRowCount(ctx, db, table); err == nil && ok {
+		return n, nil
+	}
+	var n int64
+	err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s", table)).Scan(&n)
+	return n, err
+}
+
+// ListTrackedRowCounts returns every tracked table's count.
+func ListTrackedRowCounts(ctx context.Context, db Queryer) ([]TrackedRowCount, error) {
+	if active.Name() != "sqlite" {
+		return nil, nil
+	}
+	rows, err := db.QueryContext(ctx, `SELECT c.table_name, c.row_count, `+active.Epoch("c.tracked_at")+`,
+			(SELECT COUNT(*) FROM sqlite_master m WHERE m.type = 'trigger'
+				AND m.name IN (? || c.table_name, ? || c.table_name) AND m.tbl_name = c.table_name COLLATE NOCASE)
+		FROM aqe_row_counts c ORDER BY c.table_name`,
+		RowCountTriggerPrefix+"ins_", RowCountTriggerPrefix+"del_")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []TrackedRowCount
+	for rows.Next() {
+		var t TrackedRowCount
+		var tracked int64
+		var triggers int
+		if err := rows.Scan(&t.Table, &t.Rows, &tracked, &triggers); err != nil {
+			return nil, err
+		}
+		t.TrackedAt = time.Unix(tracked, 0).UTC()
+		t.Active = triggers == 2
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// SampleDetails is a sample's latest record and what its tables hold now.
+type SampleDetails struct {
+	SampleInfo
+	// Rows is how many rows the sample table holds, and OutlierRows those
+	// its outlier table does; both are 0 while the sample is archived.
+	Rows        int64 `json:"rows"`
+	OutlierRows int64 `json:"outlier_rows,omitempty"`
+	Strata      int64 `json:"strata,omitempty"`
+	// CreatedAt is when the sample was first drawn and RefreshedAt when
+	// its latest record was written, by a redraw, refresh or catch-up.
+	CreatedAt   time.Time  `json:"created_at"`
+	RefreshedAt time.Time  `json:"refreshed_at"`
+	LastUsed    *time.Time `json:"last_used,omitempty"`
+	// Missing is set when the sample table is gone from the database
+	// without being archived (see FindDanglingSynopses).
+	Missing bool `json:"missing,omitempty"`
+}
+
+// DescribeSample counts info's rows and strata and reads its timestamps.
+func DescribeSample(ctx context.Context, db Queryer, info SampleInfo) (*SampleDetails, error) {
+	d := &SampleDetails{SampleInfo: info}
+	var created, refreshed int64
+	var used sql.NullInt64
+	err := db.QueryRowContext(ctx, `SELECT MIN(`+active.Epoch("s.created_at")+`), MAX(`+active.Epoch("s.created_at")+`),
+			MAX(`+active.Epoch("u.last_used")+`)
+		FROM aqe_samples s LEFT JOIN aqe_sample_usage u ON u.sample_table = s.sample_table
+		WHERE s.sample_table = ?`, info.SampleTable).Scan(&created, &refreshed, &used)
+	if err != nil {
+		return nil, err
+	}
+	d.CreatedAt, d.RefreshedAt = time.Unix(created, 0).UTC(), time.Unix(refreshed, 0).UTC()
+	if used.Valid {
+		t := time.Unix(used.Int64, 0).UTC()
+		d.LastUsed = &t
+	}
+	if info.StrataColumn != "" {
+		if err := db.QueryRowContext(ctx, `SELECT count(DISTINCT strata_value) FROM aqe_strata_info WHERE sample_table = ?`, info.SampleTable).Scan(&d.Strata); err != nil {
+			return nil, err
+		}
+	}
+	if info.Archived {
+		return d, nil
+	}
+	exists, err := TableExists(ctx, db, info.SampleTable)
+	if err != nil || !exists {
+		d.Missing = err == nil
+		return d, err
+	}
+	if err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT count(*) FROM %s", info.SampleTable)).Scan(&d.Rows); err != nil {
+		return nil, err
+	}
+	if info.OutlierTable != "" {
+		if exists, err := TableExists(ctx, db, info.OutlierTable); err != nil || !exists {
+			d.Missing = err == nil
+			return d, err
+		}
+		if err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT count(*) FROM %s", info.OutlierTable)).Scan(&d.OutlierRows); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// synopsisTableName matches the opaque names sample and outlier tables are
+// given, and nothing else under their prefixes, such as aqe_sample_usage.
+var synopsisTableName = regexp.MustCompile(`^(` + SampleTablePrefix + `|` + OutlierTablePrefix + `)[0-9a-f]{16}$`)
+
+// sampleTableName matches the opaque names of sample tables alone.
+var sampleTableName = regexp.MustCompile(`^` + SampleTablePrefix + `[0-9a-f]{16}$`)
+
+// FindOrphanSampleTables returns the sample and outlier tables no sample
+// record refers to: those left behind when the server stopped while
+// drawing a sample, or a record was deleted outside the API. A sample
+// being drawn has its table before its record, so orphans are only
+// dropped when asked (see DropOrphanSampleTable).
+func FindOrphanSampleTables(ctx context.Context, db *sql.DB) ([]string, error) {
+	recorded := make(map[string]bool)
+	rows, err := db.QueryContext(ctx, `SELECT sample_table FROM aqe_samples
+		UNION SELECT outlier_table FROM aqe_samples WHERE outlier_table IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		recorded[strings.ToLower(name)] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.QueryContext(ctx, active.ListTablesQuery())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var orphans []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		if lower := strings.ToLower(name); synopsisTableName.MatchString(lower) && !recorded[lower] {
+			orphans = append(orphans, name)
+		}
+	}
+	return orphans, rows.Err()
+}
+
+// DropOrphanSampleTable drops name if it is an orphan sample or outlier
+// table, and reports whether it was.
+func DropOrphanSampleTable(ctx context.Context, db *sql.DB, name string) (bool, error) {
+	orphans, err := FindOrphanSampleTables(ctx, db)
+	if err != nil {
+		return false, err
+	}
+	for _, o := range orphans {
+		if strings.EqualFold(o, name) {
+			_, err := db.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", quoteIdent(o)))
+			return err == nil, err
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// StrategyFlag switches one of the planner's strategies off, or rolls it
+// out to RolloutPercent of queries, for every server sharing the database.
+type StrategyFlag struct {
+	Strategy       string    `json:"strategy"`
+	Enabled        bool      `json:"enabled"`
+	RolloutPercent float64   `json:"rollout_percent"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// SaveStrategyFlag replaces the flag set for f.Strategy.
+func SaveStrategyFlag(ctx context.Context, db *sql.DB, f *StrategyFlag) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO aqe_strategy_flags(strategy, enabled, rollout_percent, updated_at)
+		VALUES(?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(strategy) DO UPDATE SET enabled=excluded.enabled,
+			rollout_percent=excluded.rollout_percent, updated_at=CURRENT_TIMESTAMP`,
+		f.Strategy, f.Enabled, f.RolloutPercent)
+	return err
+}
+
+// GetStrategyFlag returns the flag set for strategy, or nil when none is.
+func GetStrategyFlag(ctx context.Context, db Queryer, strategy string) (*StrategyFlag, error) {
+	f := &StrategyFlag{Strategy: strategy}
+	var updated int64
+	err := db.QueryRowContext(ctx, `SELECT enabled, rollout_percent, `+active.Epoch("updated_at")+`
+		FROM aqe_strategy_flags WHERE strategy = ?`, strategy).Scan(&f.Enabled, &f.RolloutPercent, &updated)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	f.UpdatedAt = time.Unix(updated, 0).UTC()
+	return f, nil
+}
+
+// DeleteStrategyFlag clears strategy's flag. It reports whether there was
+// one.
+func DeleteStrategyFlag(ctx context.Context, db *sql.DB, strategy string) (bool, error) {
+	res, err := db.ExecContext(ctx, `DELETE FROM aqe_strategy_flags WHERE strategy = ?`, strategy)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ListStrategyFlags returns every strategy flag set.
+func ListStrategyFlags(ctx context.Context, db Queryer) ([]StrategyFlag, error) {
+	rows, err := db.QueryContext(ctx, `SELECT strategy, enabled, rollout_percent, `+active.Epoch("updated_at")+`
+		FROM aqe_strategy_flags ORDER BY strategy`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []StrategyFlag
+	for rows.Next() {
+		var f StrategyFlag
+		var updated int64
+		if err := rows.Scan(&f.Strategy, &f.Enabled, &f.RolloutPercent, &updated); err != nil {
+			return nil, err
+		}
+		f.UpdatedAt = time.Unix(updated, 0).UTC()
+		out = append(out, f)
+	}
+	return out, rows.Err()
+}
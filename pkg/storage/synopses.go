@@ -0,0 +1,388 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sahithikokkula/Hackathon-E6Data/aqe/pkg/sketches"
+)
+
+// SynopsisFormatVersion identifies the layout of synopsis exports.
+const SynopsisFormatVersion = 1
+
+// ErrNotSynopsisExport means an import was given a file ExportSynopses did
+// not write, or one from an incompatible version.
+var ErrNotSynopsisExport = errors.New("not a synopsis export")
+
+// SynopsisExport summarizes what ExportSynopses wrote.
+type SynopsisExport struct {
+	Tables   []string `json:"tables"`
+	Samples  []string `json:"samples"`
+	Sketches int      `json:"sketches"`
+}
+
+// SynopsisImport summarizes what ImportSynopses loaded and what it skipped.
+type SynopsisImport struct {
+	Samples  []string `json:"samples"`
+	Sketches []string `json:"sketches"`
+	Skipped  []string `json:"skipped,omitempty"`
+}
+
+// ExportSynopses writes the latest samples and the sketches of tables
+// (every table with a synopsis when tables is empty) into a new SQLite
+// database at path: the sample tables themselves, plus their aqe_samples,
+// aqe_strata_info and aqe_sketches records. The file is the archive; values
+// keep their SQLite storage classes. Outlier samples are left out. It needs
+// a SQLite database.
+func ExportSynopses(ctx context.Context, db *sql.DB, path string, tables []string) (*SynopsisExport, error) {
+	if err := RequireSQLite("synopsis export"); err != nil {
+		return nil, err
+	}
+	if len(tables) == 0 {
+		var err error
+		if tables, err = SynopsisTables(ctx, db); err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, `ATTACH DATABASE ? AS synopsis_export`, path); err != nil {
+		return nil, fmt.Errorf("creating export: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), `DETACH DATABASE synopsis_export`)
+
+	for _, stmt := range []string{
+		`CREATE TABLE synopsis_export.aqe_export AS SELECT 0 AS format_version, '' AS exported_at WHERE 0`,
+		`CREATE TABLE synopsis_export.aqe_samples AS SELECT * FROM main.aqe_samples WHERE 0`,
+		`CREATE TABLE synopsis_export.aqe_strata_info AS SELECT * FROM main.aqe_strata_info WHERE 0`,
+		`CREATE TABLE synopsis_export.aqe_sketches AS SELECT * FROM main.aqe_sketches WHERE 0`,
+	} {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return nil, fmt.Errorf("creating export: %w", err)
+		}
+	}
+	if _, err := conn.ExecContext(ctx, `INSERT INTO synopsis_export.aqe_export VALUES(?, ?)`,
+		SynopsisFormatVersion, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return nil, err
+	}
+
+	out := &SynopsisExport{Tables: tables}
+	for _, table := range tables {
+		samples, err := ListSamples(ctx, db, table)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range samples {
+			if !tableExists(ctx, conn, "main", s.SampleTable) {
+				continue // recorded but dropped since
+			}
+			if s.OutlierTable != "" {
+				continue // its outlier table isn't exported; redraw it instead
+			}
+			for _, stmt := range []string{
+				`INSERT INTO synopsis_export.aqe_samples SELECT * FROM main.aqe_samples
+					WHERE id = (SELECT MAX(id) FROM main.aqe_samples WHERE sample_table = ?)`,
+				`INSERT INTO synopsis_export.aqe_strata_info SELECT * FROM main.aqe_strata_info WHERE sample_table = ?`,
+			} {
+				if _, err := conn.ExecContext(ctx, stmt, s.SampleTable); err != nil {
+					return nil, fmt.Errorf("exporting %s: %w", s.SampleTable, err)
+				}
+			}
+			name := quoteIdent(s.SampleTable)
+			if _, err := conn.ExecContext(ctx, fmt.Sprintf(
+				"CREATE TABLE synopsis_export.%s AS SELECT * FROM main.%s", name, name)); err != nil {
+				return nil, fmt.Errorf("exporting %s: %w", s.SampleTable, err)
+			}
+			out.Samples = append(out.Samples, s.SampleTable)
+		}
+
+		res, err := conn.ExecContext(ctx, `INSERT INTO synopsis_export.aqe_sketches
+			SELECT * FROM main.aqe_sketches WHERE table_name = ?`, table)
+		if err != nil {
+			return nil, fmt.Errorf("exporting sketches of %s: %w", table, err)
+		}
+		n, _ := res.RowsAffected()
+		out.Sketches += int(n)
+	}
+	return out, nil
+}
+
+// ImportSynopses loads an export written by ExportSynopses from path,
+// replacing any sample or sketch of the same name. Synopses whose base
+// table does not exist here are skipped. Samples come in with base_rowids
+// off, since rowids need not match across databases, so maintenance redraws
+// rather than reconciles them; creation times and base row counts are kept,
+// so staleness is judged against this database's tables. It all happens in
+// one transaction. It needs a SQLite database.
+func ImportSynopses(ctx context.Context, db *sql.DB, path string) (*SynopsisImport, error) {
+	if err := RequireSQLite("synopsis import"); err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, `ATTACH DATABASE ? AS synopsis_import`, path); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotSynopsisExport, err)
+	}
+	defer conn.ExecContext(context.Background(), `DETACH DATABASE synopsis_import`)
+
+	var version int
+	if err := conn.QueryRowContext(ctx, `SELECT format_version FROM synopsis_import.aqe_export`).Scan(&version); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotSynopsisExport, err)
+	}
+	if version != SynopsisFormatVersion {
+		return nil, fmt.Errorf("%w: format version %d, want %d", ErrNotSynopsisExport, version, SynopsisFormatVersion)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	out := &SynopsisImport{}
+	if err := importSamples(ctx, tx, out); err != nil {
+		return nil, err
+	}
+	if err := importSketches(ctx, tx, out); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+type importedSample struct {
+	table, sampleTable string
+	fraction           float64
+	strata             sql.NullString
+	baseRows           sql.NullInt64
+	columns            sql.NullString
+	predicate          sql.NullString
+	minStratum         sql.NullInt64
+	universe           sql.NullString
+	blockRows          sql.NullInt64
+	biasWarning        sql.NullString
+	createdAt          string
+}
+
+func importSamples(ctx context.Context, tx *sql.Tx, out *SynopsisImport) error {
+	// exports from before column pruning have no sample_columns
+	columns := "NULL"
+	if columnExists(ctx, tx, "synopsis_import", "aqe_samples", "sample_columns") {
+		columns = "sample_columns"
+	}
+	// and those from before filtered samples no sample_predicate
+	predicate := "NULL"
+	if columnExists(ctx, tx, "synopsis_import", "aqe_samples", "sample_predicate") {
+		predicate = "sample_predicate"
+	}
+	// and those from before congressional allocation no min_stratum_rows
+	minStratum := "NULL"
+	if columnExists(ctx, tx, "synopsis_import", "aqe_samples", "min_stratum_rows") {
+		minStratum = "min_stratum_rows"
+	}
+	// and those from before universe samples no universe_column
+	universe := "NULL"
+	if columnExists(ctx, tx, "synopsis_import", "aqe_samples", "universe_column") {
+		universe = "universe_column"
+	}
+	// and those from before block samples no block_rows or bias_warning
+	blockRows, biasWarning := "NULL", "NULL"
+	if columnExists(ctx, tx, "synopsis_import", "aqe_samples", "block_rows") {
+		blockRows, biasWarning = "block_rows", "bias_warning"
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT table_name, sample_table, sample_fraction, strata_column,
+		base_row_count, `+columns+`, `+predicate+`, `+minStratum+`, `+universe+`, `+blockRows+`, `+biasWarning+`, strftime('%Y-%m-%d %H:%M:%S', COALESCE(created_at, CURRENT_TIMESTAMP))
+		FROM synopsis_import.aqe_samples ORDER BY id`)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNotSynopsisExport, err)
+	}
+	var samples []importedSample
+	for rows.Next() {
+		var s importedSample
+		if err := rows.Scan(&s.table, &s.sampleTable, &s.fraction, &s.strata, &s.baseRows, &s.columns, &s.predicate, &s.minStratum, &s.universe, &s.blockRows, &s.biasWarning, &s.createdAt); err != nil {
+			rows.Close()
+			return err
+		}
+		samples = append(samples, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, s := range samples {
+		switch {
+		case !tableExists(ctx, tx, "main", s.table):
+			out.Skipped = append(out.Skipped, fmt.Sprintf("sample %s: base table %s does not exist", s.sampleTable, s.table))
+			continue
+		case !tableExists(ctx, tx, "synopsis_import", s.sampleTable):
+			out.Skipped = append(out.Skipped, fmt.Sprintf("sample %s: table missing from the export", s.sampleTable))
+			continue
+		}
+		if why := sampleImportConflict(ctx, tx, s); why != "" {
+			out.Skipped = append(out.Skipped, fmt.Sprintf("sample %s: %s", s.sampleTable, why))
+			continue
+		}
+		name := quoteIdent(s.sampleTable)
+		for _, stmt := range []struct {
+			query string
+			args  []any
+		}{
+			{fmt.Sprintf("DROP TABLE IF EXISTS main.%s", name), nil},
+			{fmt.Sprintf("CREATE TABLE main.%s AS SELECT * FROM synopsis_import.%s", name, name), nil},
+			{`DELETE FROM main.aqe_strata_info WHERE sample_table = ?`, []any{s.sampleTable}},
+			{`DELETE FROM main.aqe_samples WHERE sample_table = ?`, []any{s.sampleTable}},
+			{`DELETE FROM main.aqe_sample_usage WHERE sample_table = ?`, []any{s.sampleTable}},
+			{`INSERT INTO main.aqe_samples(table_name, sample_table, sample_fraction, strata_column, base_row_count, base_rowids, sample_columns, sample_predicate, min_stratum_rows, universe_column, block_rows, bias_warning, created_at)
+				VALUES(?, ?, ?, ?, ?, 0, ?, ?, ?, ?, ?, ?, ?)`, []any{s.table, s.sampleTable, s.fraction, s.strata, s.baseRows, s.columns, s.predicate, s.minStratum, s.universe, s.blockRows, s.biasWarning, s.createdAt}},
+			{`INSERT INTO main.aqe_strata_info(sample_table, strata_key, strata_value, pop_size, sample_size, fraction, weight, variance, created_at)
+				SELECT sample_table, strata_key, strata_value, pop_size, sample_size, fraction, weight, variance, created_at
+				FROM synopsis_import.aqe_strata_info WHERE sample_table = ?`, []any{s.sampleTable}},
+		} {
+			if _, err := tx.ExecContext(ctx, stmt.query, stmt.args...); err != nil {
+				return fmt.Errorf("importing %s: %w", s.sampleTable, err)
+			}
+		}
+		out.Samples = append(out.Samples, s.sampleTable)
+	}
+	return nil
+}
+
+// sampleImportConflict says why s's table must not be written, or returns
+// "" if it may: an import replaces only tables named as samples are,
+// opaquely or in the legacy <table>__sample_ and <table>__strat_sample_
+// forms, and only those the database has no table under or records as a
+// sample already, so an export can't overwrite user or aqe_ tables.
+func sampleImportConflict(ctx context.Context, tx *sql.Tx, s importedSample) string {
+	name := strings.ToLower(s.sampleTable)
+	legacy := strings.ToLower(s.table) + "__"
+	if !sampleTableName.MatchString(s.sampleTable) &&
+		!strings.HasPrefix(name, legacy+"sample_") && !strings.HasPrefix(name, legacy+"strat_sample_") {
+		return "not a sample table name"
+	}
+	if !tableExists(ctx, tx, "main", s.sampleTable) {
+		return ""
+	}
+	var n int
+	err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM main.aqe_samples WHERE sample_table = ? COLLATE NOCASE`, s.sampleTable).Scan(&n)
+	if err != nil || n == 0 {
+		return "a table that is not a sample already has its name"
+	}
+	return ""
+}
+
+func importSketches(ctx context.Context, tx *sql.Tx, out *SynopsisImport) error {
+	rows, err := tx.QueryContext(ctx, `SELECT DISTINCT table_name FROM synopsis_import.aqe_sketches ORDER BY 1`)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNotSynopsisExport, err)
+	}
+	var tables []string
+	for rows.Next() {
+		var t string
+		if err := rows.Scan(&t); err != nil {
+			rows.Close()
+			return err
+		}
+		tables = append(tables, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		if !tableExists(ctx, tx, "main", table) {
+			out.Skipped = append(out.Skipped, fmt.Sprintf("sketches on %s: table does not exist", table))
+			continue
+		}
+		rows, err := tx.QueryContext(ctx, `SELECT sketch_type, COALESCE(column_name, ''), sketch_data
+			FROM synopsis_import.aqe_sketches WHERE table_name = ? ORDER BY 1, 2`, table)
+		if err != nil {
+			return err
+		}
+		type importedSketch struct {
+			sketchType, column string
+			data               []byte
+		}
+		var list []importedSketch
+		for rows.Next() {
+			var sk importedSketch
+			if err := rows.Scan(&sk.sketchType, &sk.column, &sk.data); err != nil {
+				rows.Close()
+				return err
+			}
+			list = append(list, sk)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, sk := range list {
+			name := fmt.Sprintf("%s %s(%s)", table, sk.sketchType, sk.column)
+			if err := checkSketchData(sk.sketchType, sk.data); err != nil {
+				out.Skipped = append(out.Skipped, fmt.Sprintf("sketch %s: %v", name, err))
+				continue
+			}
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO main.aqe_sketches(table_name, column_name, sketch_type, sketch_data, parameters, base_row_count, degraded, created_at)
+				SELECT table_name, column_name, sketch_type, sketch_data, parameters, base_row_count, COALESCE(degraded, 0), created_at
+				FROM synopsis_import.aqe_sketches WHERE table_name = ? AND sketch_type = ? AND COALESCE(column_name, '') = ?
+				ON CONFLICT(table_name, column_name, sketch_type)
+				DO UPDATE SET sketch_data=excluded.sketch_data, parameters=excluded.parameters,
+					base_row_count=excluded.base_row_count, degraded=excluded.degraded, created_at=excluded.created_at`,
+				table, sk.sketchType, sk.column)
+			if err != nil {
+				return fmt.Errorf("importing sketch %s: %w", name, err)
+			}
+			out.Sketches = append(out.Sketches, name)
+		}
+	}
+	return nil
+}
+
+// checkSketchData decodes an imported HyperLogLog or Count-Min sketch, so
+// one whose header declares an impossible precision or table is turned
+// away at import rather than when a query first loads it.
+func checkSketchData(sketchType string, data []byte) error {
+	var err error
+	switch sketches.SketchType(sketchType) {
+	case sketches.HyperLogLogType:
+		_, err = sketches.DeserializeHyperLogLog(data)
+	case sketches.CountMinSketchType:
+		_, err = sketches.DeserializeCountMinSketch(data)
+	}
+	return err
+}
+
+// tableExists reports whether schema (e.g. "main") has a table named name.
+func tableExists(ctx context.Context, db Queryer, schema, name string) bool {
+	var n int
+	err := db.QueryRowContext(ctx, fmt.Sprintf(
+		"SELECT COUNT(*) FROM %s.sqlite_master WHERE type = 'table' AND name = ? COLLATE NOCASE", schema), name).Scan(&n)
+	return err == nil && n > 0
+}
+
+// columnExists reports whether schema's table has a column named column.
+func columnExists(ctx context.Context, db Queryer, schema, table, column string) bool {
+	var n int
+	err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM pragma_table_info(?, ?) WHERE name = ?`, table, schema, column).Scan(&n)
+	return err == nil && n > 0
+}
+
+// quoteIdent quotes name as an SQL identifier.
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
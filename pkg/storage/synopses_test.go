@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestImportSynopsesRefusesNonSampleTables imports an export whose samples
+// name a user table, a metadata table and a proper sample, and whose only
+// sketch is corrupt: only the proper sample may be written.
+func TestImportSynopsesRefusesNonSampleTables(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	exportPath := filepath.Join(dir, "export.db")
+	export, err := Open(exportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer export.Close()
+	if err := EnsureMetaTables(ctx, export); err != nil {
+		t.Fatal(err)
+	}
+	const sample = SampleTablePrefix + "0123456789abcdef"
+	if _, err := export.Exec(`
+		CREATE TABLE aqe_export (format_version INTEGER);
+		INSERT INTO aqe_export VALUES (1);
+		CREATE TABLE purchases (amount REAL);
+		CREATE TABLE ` + sample + ` (amount REAL);
+		INSERT INTO ` + sample + ` VALUES (1.5);
+		INSERT INTO aqe_samples (table_name, sample_table, sample_fraction) VALUES
+			('purchases', 'purchases', 0.1),
+			('purchases', 'aqe_samples', 0.1),
+			('purchases', '` + sample + `', 0.1);
+		-- a versioned HLL header declaring precision 255
+		INSERT INTO aqe_sketches (table_name, column_name, sketch_type, sketch_data)
+			VALUES ('purchases', 'amount', 'hyperloglog', X'41514802010000000000000000FF00100000')`); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := Open(filepath.Join(dir, "aqe.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := EnsureMetaTables(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE TABLE purchases (amount REAL); INSERT INTO purchases VALUES (1), (2)`); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := ImportSynopses(ctx, db, exportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(out.Samples, []string{sample}) {
+		t.Errorf("imported samples %v, want only %s", out.Samples, sample)
+	}
+	if len(out.Sketches) != 0 {
+		t.Errorf("imported sketches %v, want none", out.Sketches)
+	}
+	skipped := strings.Join(out.Skipped, "\n")
+	for _, want := range []string{"sample purchases:", "sample aqe_samples:", "sketch purchases hyperloglog(amount):"} {
+		if !strings.Contains(skipped, want) {
+			t.Errorf("skipped %q, want it to list %q", out.Skipped, want)
+		}
+	}
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM purchases`).Scan(&n); err != nil || n != 2 {
+		t.Errorf("purchases has %d rows (%v) after the import, want 2", n, err)
+	}
+}
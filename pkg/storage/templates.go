@@ -0,0 +1,235 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// QueryTemplate is a saved parameterized query. Params is its parameter
+// list as JSON, which the planner interprets.
+type QueryTemplate struct {
+	Name            string          `json:"name"`
+	SQL             string          `json:"sql"`
+	Params          json.RawMessage `json:"params"`
+	MaxRelError     float64         `json:"max_rel_error,omitempty"`
+	ConfidenceLevel float64         `json:"confidence_level,omitempty"`
+	Description     string          `json:"description,omitempty"`
+	CreatedAt       time.Time       `json:"created_at"`
+	UpdatedAt       time.Time       `json:"updated_at"`
+}
+
+// SaveQueryTemplate stores t under t.Name, replacing any template of that
+// name but keeping its creation time. It reports whether one was replaced.
+func SaveQueryTemplate(ctx context.Context, db *sql.DB, t *QueryTemplate) (bool, error) {
+	existing, err := GetQueryTemplate(ctx, db, t.Name)
+	if err != nil {
+		return false, err
+	}
+	_, err = db.ExecContext(ctx, `INSERT INTO aqe_query_templates(name, sql_text, params, max_rel_error, confidence_level, description, created_at, updated_at)
+		VALUES(?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT(name) DO UPDATE SET sql_text = excluded.sql_text, params = excluded.params,
+			max_rel_error = excluded.max_rel_error, confidence_level = excluded.confidence_level,
+			description = excluded.description, updated_at = CURRENT_TIMESTAMP`,
+		t.Name, t.SQL, string(t.Params), t.MaxRelError, t.ConfidenceLevel, t.Description)
+	return existing != nil, err
+}
+
+const templateColumns = `name, sql_text, params, COALESCE(max_rel_error, 0), COALESCE(confidence_level, 0),
+	COALESCE(description, ''), `
+
+// GetQueryTemplate returns the template called name, or nil when there is
+// none.
+func GetQueryTemplate(ctx context.Context, db Queryer, name string) (*QueryTemplate, error) {
+	rows, err := db.QueryContext(ctx, `SELECT `+templateColumns+active.Epoch("created_at")+`, `+active.Epoch("updated_at")+`
+		FROM aqe_query_templates WHERE name = ?`, name)
+	if err != nil {
+		return nil, err
+	}
+	templates, err := scanTemplates(rows)
+	if err != nil || len(templates) == 0 {
+		return nil, err
+	}
+	return &templates[0], nil
+}
+
+// ListQueryTemplates returns every saved template, by name.
+func ListQueryTemplates(ctx context.Context, db Queryer) ([]QueryTemplate, error) {
+	rows, err := db.QueryContext(ctx, `SELECT `+templateColumns+active.Epoch("created_at")+`, `+active.Epoch("updated_at")+`
+		FROM aqe_query_templates ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	return scanTemplates(rows)
+}
+
+// DeleteQueryTemplate removes the template called name and its runs,
+// reporting whether there was one.
+func DeleteQueryTemplate(ctx context.Context, db *sql.DB, name string) (bool, error) {
+	res, err := db.ExecContext(ctx, `DELETE FROM aqe_query_templates WHERE name = ?`, name)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	_, err = db.ExecContext(ctx, `DELETE FROM aqe_template_runs WHERE template = ?`, name)
+	return true, err
+}
+
+func scanTemplates(rows *sql.Rows) ([]QueryTemplate, error) {
+	defer rows.Close()
+	var templates []QueryTemplate
+	for rows.Next() {
+		var t QueryTemplate
+		var params string
+		var created, updated int64
+		if err := rows.Scan(&t.Name, &t.SQL, &params, &t.MaxRelError, &t.ConfidenceLevel, &t.Description, &created, &updated); err != nil {
+			return nil, err
+		}
+		t.Params = json.RawMessage(params)
+		t.CreatedAt, t.UpdatedAt = time.Unix(created, 0).UTC(), time.Unix(updated, 0).UTC()
+		templates = append(templates, t)
+	}
+	return templates, rows.Err()
+}
+
+// TemplateRun is the stored answer of one run of a template, kept so runs
+// can be compared.
+type TemplateRun struct {
+	ID       int64  `json:"id"`
+	Template string `json:"template"`
+	// SQL is the rendered query and Params the values the run was given.
+	SQL             string          `json:"sql"`
+	Params          json.RawMessage `json:"params,omitempty"`
+	PlanType        string          `json:"plan_type"`
+	ConfidenceLevel float64         `json:"confidence_level"`
+	// Columns are the result columns in order, and Aggregates those of
+	// them computed by aggregates.
+	Columns    []string `json:"columns"`
+	Aggregates []string `json:"aggregates"`
+	// Result is the answer's rows as JSON; runs listed by ListTemplateRuns
+	// leave it out.
+	Result json.RawMessage `json:"result,omitempty"`
+	RanAt  time.Time       `json:"ran_at"`
+}
+
+// SaveTemplateRun stores run, setting its ID, and drops all but the
+// newest keep runs of its template.
+func SaveTemplateRun(ctx context.Context, db *sql.DB, run *TemplateRun, keep int) error {
+	columns, _ := json.Marshal(run.Columns)
+	aggregates, _ := json.Marshal(run.Aggregates)
+	err := db.QueryRowContext(ctx, `INSERT INTO aqe_template_runs(template, sql_text, params, plan_type, confidence_level, columns, aggregates, result, ran_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP) RETURNING id`,
+		run.Template, run.SQL, string(run.Params), run.PlanType, run.ConfidenceLevel, string(columns), string(aggregates), string(run.Result)).Scan(&run.ID)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `DELETE FROM aqe_template_runs WHERE template = ? AND id NOT IN (
+		SELECT id FROM aqe_template_runs WHERE template = ? ORDER BY id DESC LIMIT ?)`, run.Template, run.Template, keep)
+	return err
+}
+
+func templateRunColumns(withResult bool) string {
+	result := "''"
+	if withResult {
+		result = "result"
+	}
+	return `id, template, sql_text, COALESCE(params, ''), plan_type, confidence_level, columns, aggregates, ` +
+		result + `, ` + active.Epoch("ran_at")
+}
+
+// ListTemplateRuns returns the newest limit runs of template, newest
+// first, without their results.
+func ListTemplateRuns(ctx context.Context, db Queryer, template string, limit int) ([]TemplateRun, error) {
+	rows, err := db.QueryContext(ctx, `SELECT `+templateRunColumns(false)+`
+		FROM aqe_template_runs WHERE template = ? ORDER BY id DESC LIMIT ?`, template, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanTemplateRuns(rows)
+}
+
+// FrequentTemplateRuns returns the newest run of each of the limit
+// templates with the most stored runs, most runs first, without results.
+func FrequentTemplateRuns(ctx context.Context, db Queryer, limit int) ([]TemplateRun, error) {
+	rows, err := db.QueryContext(ctx, `SELECT `+templateRunColumns(false)+`
+		FROM aqe_template_runs r
+		WHERE id = (SELECT MAX(id) FROM aqe_template_runs WHERE template = r.template)
+		ORDER BY (SELECT COUNT(*) FROM aqe_template_runs WHERE template = r.template) DESC, id DESC
+		LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanTemplateRuns(rows)
+}
+
+// GetTemplateRun returns run id of template, or nil when there is none.
+func GetTemplateRun(ctx context.Context, db Queryer, template string, id int64) (*TemplateRun, error) {
+	rows, err := db.QueryContext(ctx, `SELECT `+templateRunColumns(true)+`
+		FROM aqe_template_runs WHERE template = ? AND id = ?`, template, id)
+	if err != nil {
+		return nil, err
+	}
+	return firstTemplateRun(rows)
+}
+
+// TemplateRunBefore returns the newest run of template older than run
+// before, or nil when there is none.
+func TemplateRunBefore(ctx context.Context, db Queryer, template string, before int64) (*TemplateRun, error) {
+	rows, err := db.QueryContext(ctx, `SELECT `+templateRunColumns(true)+`
+		FROM aqe_template_runs WHERE template = ? AND id < ? ORDER BY id DESC LIMIT 1`, template, before)
+	if err != nil {
+		return nil, err
+	}
+	return firstTemplateRun(rows)
+}
+
+// TemplateRunAt returns the newest run of template made at or before at,
+// or nil when there is none.
+func TemplateRunAt(ctx context.Context, db Queryer, template string, at time.Time) (*TemplateRun, error) {
+	rows, err := db.QueryContext(ctx, `SELECT `+templateRunColumns(true)+`
+		FROM aqe_template_runs WHERE template = ? AND `+active.Epoch("ran_at")+` <= ? ORDER BY id DESC LIMIT 1`, template, at.Unix())
+	if err != nil {
+		return nil, err
+	}
+	return firstTemplateRun(rows)
+}
+
+func firstTemplateRun(rows *sql.Rows) (*TemplateRun, error) {
+	runs, err := scanTemplateRuns(rows)
+	if err != nil || len(runs) == 0 {
+		return nil, err
+	}
+	return &runs[0], nil
+}
+
+func scanTemplateRuns(rows *sql.Rows) ([]TemplateRun, error) {
+	defer rows.Close()
+	var runs []TemplateRun
+	for rows.Next() {
+		var r TemplateRun
+		var params, columns, aggregates, result string
+		var ranAt int64
+		if err := rows.Scan(&r.ID, &r.Template, &r.SQL, &params, &r.PlanType, &r.ConfidenceLevel, &columns, &aggregates, &result, &ranAt); err != nil {
+			return nil, err
+		}
+		if params != "" {
+			r.Params = json.RawMessage(params)
+		}
+		if result != "" {
+			r.Result = json.RawMessage(result)
+		}
+		if err := json.Unmarshal([]byte(columns), &r.Columns); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(aggregates), &r.Aggregates); err != nil {
+			return nil, err
+		}
+		r.RanAt = time.Unix(ranAt, 0).UTC()
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
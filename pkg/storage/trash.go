@@ -0,0 +1,335 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrNoSynopsis means the sample, sketch or dropped synopsis asked for
+// does not exist.
+var ErrNoSynopsis = errors.New("no such synopsis")
+
+// ErrSynopsisExists means a dropped synopsis cannot be restored because
+// one of the same name has been created since.
+var ErrSynopsisExists = errors.New("synopsis exists")
+
+// Kinds of DroppedSynopsis.
+const (
+	DroppedSample = "sample"
+	DroppedSketch = "sketch"
+)
+
+// sampleRecords and sketchRecords are the metadata tables whose rows a
+// dropped sample or sketch sets aside.
+var (
+	sampleRecords = []string{"aqe_samples", "aqe_strata_info", "aqe_sample_usage"}
+	sketchRecords = []string{"aqe_sketches"}
+)
+
+// droppedOutliers is the droppedTable record an outlier sample's outlier
+// table is renamed to.
+const droppedOutliers = "outliers"
+
+// DroppedSynopsis is a sample or sketch dropped through the API. A
+// sample's table is renamed and the records of either are moved aside, so
+// RestoreSynopsis can bring it back until PurgeDropped removes it for good.
+type DroppedSynopsis struct {
+	ID    int64  `json:"id"`
+	Kind  string `json:"kind"`
+	Table string `json:"table"`
+	// Name is the sample table, or the sketch's type and column.
+	Name       string     `json:"name"`
+	Column     string     `json:"column,omitempty"`
+	SketchType SketchType `json:"sketch_type,omitempty"`
+	DroppedAt  time.Time  `json:"dropped_at"`
+}
+
+// droppedTable is the table a dropped synopsis's rows are kept in: for a
+// sample the renamed sample table itself, for record the rows moved out
+// of that metadata table, or for droppedOutliers a sample's outlier table.
+func droppedTable(id int64, record string) string {
+	if record == "" {
+		return fmt.Sprintf("aqe_dropped_%d", id)
+	}
+	return fmt.Sprintf("aqe_dropped_%d_%s", id, record)
+}
+
+// DropSampleSoft drops sampleTable so that it can be restored: the table
+// is renamed and its records moved aside. The sample must be in the main
+// database, not archived.
+func DropSampleSoft(ctx context.Context, db *sql.DB, sampleTable string) (*DroppedSynopsis, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	d := &DroppedSynopsis{Kind: DroppedSample, Name: sampleTable}
+	var outliers sql.NullString
+	err = tx.QueryRowContext(ctx, `SELECT table_name, outlier_table FROM aqe_samples
+		WHERE sample_table = ? ORDER BY id DESC LIMIT 1`, sampleTable).Scan(&d.Table, &outliers)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: no sample %s", ErrNoSynopsis, sampleTable)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if exists, err := TableExists(ctx, tx, sampleTable); err != nil || !exists {
+		if err == nil {
+			err = fmt.Errorf("%w: sample table %s is missing", ErrNoSynopsis, sampleTable)
+		}
+		return nil, err
+	}
+	if err := recordDrop(ctx, tx, d); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s RENAME TO %s",
+		quoteIdent(sampleTable), droppedTable(d.ID, ""))); err != nil {
+		return nil, err
+	}
+	if outliers.String != "" {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s RENAME TO %s",
+			quoteIdent(outliers.String), droppedTable(d.ID, droppedOutliers))); err != nil {
+			return nil, err
+		}
+	}
+	if err := setAside(ctx, tx, d.ID, sampleRecords, "sample_table = ?", sampleTable); err != nil {
+		return nil, err
+	}
+	return d, tx.Commit()
+}
+
+// DropSketchSoft drops the sketchType sketch on table's column so that it
+// can be restored: its record, data included, is moved aside.
+func DropSketchSoft(ctx context.Context, db *sql.DB, table, column string, sketchType SketchType) (*DroppedSynopsis, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	const match = "table_name = ? AND COALESCE(column_name, '') = ? AND sketch_type = ?"
+	var n int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM aqe_sketches WHERE `+match,
+		table, column, string(sketchType)).Scan(&n); err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("%w: no %s sketch on %s(%s)", ErrNoSynopsis, sketchType, table, column)
+	}
+	d := &DroppedSynopsis{
+		Kind:       DroppedSketch,
+		Table:      table,
+		Name:       fmt.Sprintf("%s(%s)", sketchType, column),
+		Column:     column,
+		SketchType: sketchType,
+	}
+	if err := recordDrop(ctx, tx, d); err != nil {
+		return nil, err
+	}
+	if err := setAside(ctx, tx, d.ID, sketchRecords, match, table, column, string(sketchType)); err != nil {
+		return nil, err
+	}
+	return d, tx.Commit()
+}
+
+// recordDrop adds d to aqe_dropped_synopses, setting its ID and DroppedAt.
+func recordDrop(ctx context.Context, tx *sql.Tx, d *DroppedSynopsis) error {
+	d.DroppedAt = time.Now().UTC().Truncate(time.Second)
+	var column, sketchType any
+	if d.Kind == DroppedSketch {
+		column, sketchType = d.Column, string(d.SketchType)
+	}
+	return tx.QueryRowContext(ctx, `INSERT INTO aqe_dropped_synopses(kind, table_name, name, column_name, sketch_type, dropped_at)
+		VALUES(?, ?, ?, ?, ?, CURRENT_TIMESTAMP) RETURNING id`,
+		d.Kind, d.Table, d.Name, column, sketchType).Scan(&d.ID)
+}
+
+// setAside moves the rows of each of records matching where into its
+// dropped table.
+func setAside(ctx context.Context, tx *sql.Tx, id int64, records []string, where string, args ...any) error {
+	for _, record := range records {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("CREATE TABLE %s AS SELECT * FROM %s WHERE %s",
+			droppedTable(id, record), record, where), args...); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE %s", record, where), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreSynopsis brings the dropped synopsis id back as it was dropped.
+// It fails with ErrSynopsisExists when a sample table of the same name, or
+// the same sketch, has been created since.
+func RestoreSynopsis(ctx context.Context, db *sql.DB, id int64) (*DroppedSynopsis, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	d, err := lookupDropped(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	records := sketchRecords
+	var taken bool
+	switch d.Kind {
+	case DroppedSample:
+		records = sampleRecords
+		if taken, err = TableExists(ctx, tx, d.Name); err == nil && !taken {
+			var n int
+			err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM aqe_samples WHERE sample_table = ?`, d.Name).Scan(&n)
+			taken = n > 0
+		}
+	default:
+		var n int
+		err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM aqe_sketches
+			WHERE table_name = ? AND COALESCE(column_name, '') = ? AND sketch_type = ?`,
+			d.Table, d.Column, string(d.SketchType)).Scan(&n)
+		taken = n > 0
+	}
+	if err != nil {
+		return nil, err
+	}
+	if taken {
+		return nil, fmt.Errorf("%w: %s %s was created again since it was dropped", ErrSynopsisExists, d.Kind, d.Name)
+	}
+
+	if d.Kind == DroppedSample {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s RENAME TO %s",
+			droppedTable(id, ""), quoteIdent(d.Name))); err != nil {
+			return nil, err
+		}
+	}
+	for _, record := range records {
+		if err := putBack(ctx, tx, droppedTable(id, record), record); err != nil {
+			return nil, fmt.Errorf("restoring %s records: %w", record, err)
+		}
+	}
+	if d.Kind == DroppedSample {
+		var outliers sql.NullString
+		if err := tx.QueryRowContext(ctx, `SELECT outlier_table FROM aqe_samples
+			WHERE sample_table = ? ORDER BY id DESC LIMIT 1`, d.Name).Scan(&outliers); err != nil {
+			return nil, err
+		}
+		if outliers.String != "" {
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s RENAME TO %s",
+				droppedTable(id, droppedOutliers), quoteIdent(outliers.String))); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM aqe_dropped_synopses WHERE id = ?`, id); err != nil {
+		return nil, err
+	}
+	return d, tx.Commit()
+}
+
+// putBack copies the rows set aside in from back into record, by the
+// columns both have, and drops from.
+func putBack(ctx context.Context, tx *sql.Tx, from, record string) error {
+	saved, err := TableColumns(ctx, tx, from)
+	if err != nil {
+		return err
+	}
+	current, err := TableColumns(ctx, tx, record)
+	if err != nil {
+		return err
+	}
+	has := make(map[string]bool, len(current))
+	for _, c := range current {
+		has[strings.ToLower(c)] = true
+	}
+	var cols []string
+	for _, c := range saved {
+		if has[strings.ToLower(c)] {
+			cols = append(cols, quoteIdent(c))
+		}
+	}
+	list := strings.Join(cols, ", ")
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s(%s) SELECT %s FROM %s", record, list, list, from)); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, fmt.Sprintf("DROP TABLE %s", from))
+	return err
+}
+
+// ListDropped returns the dropped synopses not yet purged, most recently
+// dropped first.
+func ListDropped(ctx context.Context, db Queryer) ([]DroppedSynopsis, error) {
+	rows, err := db.QueryContext(ctx, `SELECT id, kind, table_name, name, COALESCE(column_name, ''),
+		COALESCE(sketch_type, ''), `+active.Epoch("dropped_at")+`
+		FROM aqe_dropped_synopses ORDER BY id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []DroppedSynopsis
+	for rows.Next() {
+		d, err := scanDropped(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *d)
+	}
+	return out, rows.Err()
+}
+
+func lookupDropped(ctx context.Context, db Queryer, id int64) (*DroppedSynopsis, error) {
+	d, err := scanDropped(db.QueryRowContext(ctx, `SELECT id, kind, table_name, name, COALESCE(column_name, ''),
+		COALESCE(sketch_type, ''), `+active.Epoch("dropped_at")+`
+		FROM aqe_dropped_synopses WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: no dropped synopsis %d", ErrNoSynopsis, id)
+	}
+	return d, err
+}
+
+func scanDropped(row interface{ Scan(...any) error }) (*DroppedSynopsis, error) {
+	var d DroppedSynopsis
+	var sketchType string
+	var dropped int64
+	if err := row.Scan(&d.ID, &d.Kind, &d.Table, &d.Name, &d.Column, &sketchType, &dropped); err != nil {
+		return nil, err
+	}
+	d.SketchType = SketchType(sketchType)
+	d.DroppedAt = time.Unix(dropped, 0).UTC()
+	return &d, nil
+}
+
+// PurgeDropped removes for good the synopses dropped before cutoff and
+// returns them.
+func PurgeDropped(ctx context.Context, db *sql.DB, cutoff time.Time) ([]DroppedSynopsis, error) {
+	dropped, err := ListDropped(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	var purged []DroppedSynopsis
+	for _, d := range dropped {
+		if !d.DroppedAt.Before(cutoff) {
+			continue
+		}
+		tables := []string{droppedTable(d.ID, ""), droppedTable(d.ID, droppedOutliers)}
+		for _, record := range append(append([]string{}, sampleRecords...), sketchRecords...) {
+			tables = append(tables, droppedTable(d.ID, record))
+		}
+		for _, t := range tables {
+			if _, err := db.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", t)); err != nil {
+				return purged, err
+			}
+		}
+		if _, err := db.ExecContext(ctx, `DELETE FROM aqe_dropped_synopses WHERE id = ?`, d.ID); err != nil {
+			return purged, err
+		}
+		purged = append(purged, d)
+	}
+	return purged, nil
+}
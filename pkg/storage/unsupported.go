@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// maxConstructSQL bounds the example statement kept per construct.
+const maxConstructSQL = 2000
+
+// UnsupportedConstruct counts the queries a construct the planner can't
+// approximate kept exact, with the latest of them.
+type UnsupportedConstruct struct {
+	Construct  string    `json:"construct"`
+	QueryCount int64     `json:"query_count"`
+	LastSQL    string    `json:"last_sql"`
+	FirstSeen  time.Time `json:"first_seen"`
+	LastSeen   time.Time `json:"last_seen"`
+}
+
+// RecordUnsupportedConstructs counts one query, sqlText, under each of
+// constructs.
+func RecordUnsupportedConstructs(ctx context.Context, db *sql.DB, constructs []string, sqlText string) error {
+	if len(sqlText) > maxConstructSQL {
+		sqlText = sqlText[:maxConstructSQL]
+	}
+	for _, c := range constructs {
+		if _, err := db.ExecContext(ctx, `INSERT INTO aqe_unsupported_constructs(construct, query_count, last_sql, first_seen, last_seen)
+			VALUES(?, 1, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+			ON CONFLICT(construct) DO UPDATE SET query_count = aqe_unsupported_constructs.query_count + 1,
+				last_sql = excluded.last_sql, last_seen = CURRENT_TIMESTAMP`, c, sqlText); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListUnsupportedConstructs returns every construct counted, most frequent
+// first.
+func ListUnsupportedConstructs(ctx context.Context, db Queryer) ([]UnsupportedConstruct, error) {
+	rows, err := db.QueryContext(ctx, `SELECT construct, query_count, COALESCE(last_sql, ''), `+
+		active.Epoch("first_seen")+`, `+active.Epoch("last_seen")+`
+		FROM aqe_unsupported_constructs ORDER BY query_count DESC, construct`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []UnsupportedConstruct
+	for rows.Next() {
+		var c UnsupportedConstruct
+		var first, last int64
+		if err := rows.Scan(&c.Construct, &c.QueryCount, &c.LastSQL, &first, &last); err != nil {
+			return nil, err
+		}
+		c.FirstSeen, c.LastSeen = time.Unix(first, 0).UTC(), time.Unix(last, 0).UTC()
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// MaintenanceWindow is the time of day heavy background work on a table,
+// such as synopsis rebuilds and shadow exact runs, may run in: one or more
+// spans of local time, as "01:00-05:00,13:00-14:00".
+type MaintenanceWindow struct {
+	Table     string    `json:"table"`
+	Hours     string    `json:"hours"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SaveMaintenanceWindow replaces the window registered for w.Table.
+func SaveMaintenanceWindow(ctx context.Context, db *sql.DB, w *MaintenanceWindow) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO aqe_maintenance_windows(table_name, hours, updated_at)
+		VALUES(?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(table_name) DO UPDATE SET hours=excluded.hours, updated_at=CURRENT_TIMESTAMP`,
+		w.Table, w.Hours)
+	return err
+}
+
+// GetMaintenanceWindow returns the window registered for table, or nil
+// when none is.
+func GetMaintenanceWindow(ctx context.Context, db Queryer, table string) (*MaintenanceWindow, error) {
+	w := &MaintenanceWindow{Table: table}
+	var updated int64
+	err := db.QueryRowContext(ctx, `SELECT hours, `+active.Epoch("updated_at")+`
+		FROM aqe_maintenance_windows WHERE table_name = ?`, table).Scan(&w.Hours, &updated)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	w.UpdatedAt = time.Unix(updated, 0).UTC()
+	return w, nil
+}
+
+// DeleteMaintenanceWindow removes table's window, reporting whether it had
+// one.
+func DeleteMaintenanceWindow(ctx context.Context, db *sql.DB, table string) (bool, error) {
+	res, err := db.ExecContext(ctx, `DELETE FROM aqe_maintenance_windows WHERE table_name = ?`, table)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}